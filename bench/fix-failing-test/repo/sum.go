@@ -0,0 +1,10 @@
+package sum
+
+// Sum returns the total of xs
+func Sum(xs []int) int {
+	total := 0
+	for i := 0; i < len(xs)-1; i++ {
+		total += xs[i]
+	}
+	return total
+}
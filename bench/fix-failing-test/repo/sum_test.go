@@ -0,0 +1,18 @@
+package sum
+
+import "testing"
+
+func TestSum(t *testing.T) {
+	for _, tt := range []struct {
+		xs   []int
+		want int
+	}{
+		{nil, 0},
+		{[]int{1}, 1},
+		{[]int{1, 2, 3}, 6},
+	} {
+		if got := Sum(tt.xs); got != tt.want {
+			t.Errorf("Sum(%v) = %d, want %d", tt.xs, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+// apply applies a saved model response to the current tree
+// reads a response holding NinaChange, NinaDelete, and NinaRename tags from a
+// file or stdin, like one copied from a web ui or taken from batch results,
+// and applies it with lib.ConvertToRangeUpdates like arch does
+package apply
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/alexflint/go-arg"
+	"github.com/nathants/nina/lib"
+	util "github.com/nathants/nina/util"
+	"github.com/nathants/nina/workspace"
+)
+
+func init() {
+	lib.Commands["apply"] = apply
+	lib.Args["apply"] = applyArgs{}
+}
+
+type applyArgs struct {
+	File      string   `arg:"positional" help:"file holding the response, stdin when omitted or -"`
+	DryRun    bool     `arg:"-n,--dry-run" help:"print a diff of the changes without applying them"`
+	Verbose   bool     `arg:"-v,--verbose" help:"verbose output"`
+	Converter string   `arg:"--converter" help:"model to locate search text, or 'local' for no AI (default: $NINA_CONVERTER_MODEL or sonnet)"`
+	AllowPath []string `arg:"--allow-path,separate" help:"additional directory changes may write to, outside the git root"`
+	Protect   []string `arg:"--protect,separate" help:"path or glob changes may not write, e.g. .github/workflows or '*.lock', adds to NINA_PROTECT"`
+	Exec      string   `arg:"--exec" help:"where files are read and written: local or ssh://[user@]host[:path]"`
+}
+
+func (applyArgs) Description() string {
+	return `apply - Apply a saved model response to the current tree
+
+Reads a response containing NinaChange, NinaDelete, and NinaRename tags,
+with or without the surrounding NinaOutput, and applies it like arch.
+Paths are absolute or relative to the current directory.
+
+Changes are applied in order, so several changes to one file each see
+the ones before them. Nothing is written unless every change applies.
+
+Example:
+  nina apply response.txt
+  pbpaste | nina apply --dry-run`
+}
+
+func readResponse(path string) (string, error) {
+	var data []byte
+	var err error
+	if path == "" || path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return "", fmt.Errorf("reading response: %w", err)
+	}
+	return string(data), nil
+}
+
+// resolvePath returns the absolute path of a NinaPath
+func resolvePath(path string) (string, error) {
+	path = strings.TrimSpace(path)
+	if strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(home, path[2:])
+		}
+	}
+	return filepath.Abs(path)
+}
+
+// applyResponse applies the changes of response to the current workspace,
+// returning how many were applied
+func applyResponse(ctx context.Context, response string) (int, error) {
+	updates, err := util.ParseFileUpdates(response)
+	if err != nil && !util.SkipBrokenChanges(err) {
+		return 0, fmt.Errorf("failed to parse response: %w", err)
+	}
+	fileOps, err := util.ParseFileOps(response)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse response: %w", err)
+	}
+	if len(updates)+len(fileOps) == 0 {
+		return 0, fmt.Errorf("no NinaChange, NinaDelete, or NinaRename in the response")
+	}
+
+	ws := workspace.Current()
+	for _, update := range updates {
+		path, err := resolvePath(update.FileName)
+		if err != nil {
+			return 0, err
+		}
+		if err := util.CheckPathAllowed(path); err != nil {
+			return 0, err
+		}
+		if err := util.CheckFile(path); err != nil {
+			return 0, err
+		}
+		// a missing file is created
+		data, _ := ws.ReadFile(path)
+		content, format := util.NormalizeText(string(data))
+		update.FileName = path
+
+		session := &util.SessionState{
+			OrigFiles:     map[string]string{path: content},
+			SelectedFiles: map[string]string{},
+			PathMap:       map[string]string{path: path},
+		}
+		ranged, err := lib.ConvertToRangeUpdates(ctx, []util.FileUpdate{update}, session, nil)
+		if err != nil {
+			return 0, fmt.Errorf("failed to convert to range updates for %s: %w", path, err)
+		}
+		content, err = util.ApplyFileUpdates(content, ranged)
+		if err != nil {
+			return 0, fmt.Errorf("failed to apply updates to %s: %w", path, err)
+		}
+		if err := ws.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return 0, fmt.Errorf("failed to create directory for %s: %w", path, err)
+		}
+		if err := ws.WriteFile(path, []byte(format.Restore(content)), workspace.FileMode(ws, path, 0644)); err != nil {
+			return 0, fmt.Errorf("failed to write %s: %w", path, err)
+		}
+		util.Verbosef("Updated %s", path)
+	}
+
+	// Deletes and renames run after updates so changes to renamed files apply
+	// to the paths the model was shown
+	for _, op := range fileOps {
+		path, err := resolvePath(op.Path)
+		if err != nil {
+			return 0, err
+		}
+		var result util.ChangeResult
+		if op.Op == util.FileOpRename {
+			dest, err := resolvePath(op.Dest)
+			if err != nil {
+				return 0, err
+			}
+			result = util.ExecuteRename(path, dest)
+		} else {
+			result = util.ExecuteDelete(path)
+		}
+		if result.Error != "" {
+			return 0, fmt.Errorf("failed to %s %s: %s", op.Op, op.Path, result.Error)
+		}
+		util.Verbosef("%s", result.Stdout)
+	}
+	return len(updates) + len(fileOps), nil
+}
+
+// printChanges prints the diff of each change in the overlay to its base
+func printChanges(overlay *workspace.Overlay) {
+	for _, change := range overlay.Changes() {
+		if change.Deleted {
+			fmt.Printf("=== would delete %s ===\n", change.Path)
+			continue
+		}
+		before, _ := overlay.Base().ReadFile(change.Path)
+		fmt.Print(util.UnifiedDiff(change.Path, string(before), string(change.Data), 3))
+	}
+}
+
+func run(args applyArgs) error {
+	ctx := context.Background()
+	response, err := readResponse(args.File)
+	if err != nil {
+		return err
+	}
+
+	// Changes go to an overlay first so a failing change leaves the tree as it was
+	base := workspace.Current()
+	overlay := workspace.NewOverlay(base)
+	workspace.SetCurrent(overlay)
+	applied, err := applyResponse(ctx, response)
+	workspace.SetCurrent(base)
+	if err != nil {
+		return fmt.Errorf("%w, nothing applied", err)
+	}
+
+	if args.DryRun {
+		printChanges(overlay)
+		return nil
+	}
+	files := len(overlay.Changes())
+	if err := overlay.Commit(); err != nil {
+		return err
+	}
+	util.Infof("Successfully applied %d changes to %d files", applied, files)
+	return nil
+}
+
+func apply() {
+	var args applyArgs
+	arg.MustParse(&args)
+
+	if args.Verbose {
+		util.SetLogLevel(max(util.GetLogLevel(), util.LogVerbose))
+	}
+	if args.Converter != "" {
+		_ = os.Setenv("NINA_CONVERTER_MODEL", args.Converter)
+	}
+	util.AllowPaths(args.AllowPath)
+	util.ProtectPaths(args.Protect)
+	if err := workspace.Use(args.Exec); err != nil {
+		lib.Fatal(err)
+	}
+	if err := run(args); err != nil {
+		lib.Fatal(err)
+	}
+}
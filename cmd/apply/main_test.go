@@ -0,0 +1,61 @@
+package apply
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	util "github.com/nathants/nina/util"
+	"github.com/nathants/nina/workspace"
+)
+
+func TestApplyResponse(t *testing.T) {
+	t.Setenv("NINA_CONVERTER_MODEL", "local")
+	t.Setenv("NINA_CACHE_DIR", t.TempDir())
+	t.Setenv("NINA_CONVERT_CORPUS", t.TempDir())
+	t.Chdir(t.TempDir())
+	for name, content := range map[string]string{"a.txt": "one\ntwo\nthree\n", "gone.txt": "x\n"} {
+		if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	change := func(path, search, replace string) string {
+		return util.NinaStart + "\n" + util.NinaPathStart + path + util.NinaPathEnd + "\n" +
+			util.NinaSearchStart + "\n" + search + "\n" + util.NinaSearchEnd + "\n" +
+			util.NinaReplaceStart + "\n" + replace + "\n" + util.NinaReplaceEnd + "\n" + util.NinaEnd + "\n"
+	}
+	remove := util.NinaDeleteStart + "\n" + util.NinaPathStart + "gone.txt" + util.NinaPathEnd + "\n" + util.NinaDeleteEnd + "\n"
+	// two changes to one file, the second sees the first
+	response := change("a.txt", "two", "TWO") + change("a.txt", "TWO\nthree", "3") + change("new/b.txt", "", "b") + remove
+
+	base := workspace.Current()
+	overlay := workspace.NewOverlay(base)
+	workspace.SetCurrent(overlay)
+	applied, err := applyResponse(context.Background(), response)
+	workspace.SetCurrent(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if applied != 4 {
+		t.Errorf("applied %d changes, want 4", applied)
+	}
+	if data, _ := os.ReadFile("a.txt"); string(data) != "one\ntwo\nthree\n" {
+		t.Errorf("a.txt written before commit: %q", data)
+	}
+	if err := overlay.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"a.txt": "one\n3\n", "new/b.txt": "b"} {
+		if data, _ := os.ReadFile(name); strings.TrimSuffix(string(data), "\n") != strings.TrimSuffix(want, "\n") {
+			t.Errorf("%s is %q, want %q", name, data, want)
+		}
+	}
+	if _, err := os.Stat("gone.txt"); !os.IsNotExist(err) {
+		t.Error("gone.txt was not deleted")
+	}
+
+	if _, err := applyResponse(context.Background(), "no tags here"); err == nil {
+		t.Error("expected an error for a response without changes")
+	}
+}
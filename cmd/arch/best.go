@@ -0,0 +1,331 @@
+package arch
+
+// best-of-n generation for arch --n. every candidate response is applied to
+// its own in-memory overlay, so one that fails to apply never touches the
+// repo, and the check command runs in a temp copy of the repo holding the
+// tracked and untracked, non-ignored files plus the candidate's changes.
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/nathants/nina/lib"
+	"github.com/nathants/nina/models"
+	"github.com/nathants/nina/providers/claude"
+	util "github.com/nathants/nina/util"
+	"github.com/nathants/nina/workspace"
+)
+
+// maxCheckOutput bounds the check output shown for a failing candidate
+const maxCheckOutput = 2000
+
+// candidate is one generated change set and how it evaluated
+type candidate struct {
+	index    int
+	response string
+	lines    int   // lines added plus removed, the smallest change wins ties
+	err      error // the response could not be applied
+	checked  bool
+	passed   bool
+	output   string // tail of the check output
+}
+
+func (c candidate) String() string {
+	switch {
+	case c.err != nil:
+		return fmt.Sprintf("candidate %d: not applied: %v", c.index+1, c.err)
+	case !c.checked:
+		return fmt.Sprintf("candidate %d: applied, %d lines changed", c.index+1, c.lines)
+	case c.passed:
+		return fmt.Sprintf("candidate %d: check passed, %d lines changed", c.index+1, c.lines)
+	default:
+		return fmt.Sprintf("candidate %d: check failed, %d lines changed", c.index+1, c.lines)
+	}
+}
+
+func runBestOfN(ctx context.Context, args archArgs, provider, modelID, systemPrompt, userMessage, prompt string, files map[string]string) error {
+	check, err := checkCommand(args)
+	if err != nil {
+		return err
+	}
+	base := workspace.Current()
+
+	responses, err := generateCandidates(ctx, provider, modelID, systemPrompt, userMessage, args.N)
+	if err != nil {
+		return err
+	}
+
+	var candidates []candidate
+	for i, response := range responses {
+		if response == "" {
+			continue
+		}
+		c := candidate{index: i, response: response}
+		overlay := workspace.NewOverlay(base)
+		workspace.SetCurrent(overlay)
+		c.err = applyResponse(ctx, response, files, false)
+		workspace.SetCurrent(base)
+		if c.err == nil {
+			c.lines = changedLines(base, overlay.Changes())
+			if check != "" {
+				c.checked = true
+				c.passed, c.output, err = runCheck(ctx, check, overlay.Changes())
+				if err != nil {
+					return err
+				}
+			}
+		}
+		util.Infof("%s", c)
+		candidates = append(candidates, c)
+	}
+
+	best, err := selectCandidate(ctx, args, prompt, candidates)
+	if err != nil {
+		return err
+	}
+	util.Infof("applying candidate %d of %d", best.index+1, args.N)
+	return finishResponse(ctx, args, best.response, files)
+}
+
+// checkCommand returns the --check command, NINA_VALIDATE by default, which
+// needs a local workspace to copy the repo from
+func checkCommand(args archArgs) (string, error) {
+	check := args.Check
+	if check == "" {
+		check = os.Getenv("NINA_VALIDATE")
+	}
+	local := workspace.Current()
+	if overlay, ok := local.(*workspace.Overlay); ok {
+		local = overlay.Base()
+	}
+	if check != "" && !workspace.IsLocal(local) {
+		return "", fmt.Errorf("--check needs a local workspace")
+	}
+	return check, nil
+}
+
+// generateCandidates requests n responses, as one batch for batch models and
+// concurrently otherwise. Failed requests leave an empty response, it is an
+// error only when every request failed.
+func generateCandidates(ctx context.Context, provider, modelID, systemPrompt, userMessage string, n int) ([]string, error) {
+	model, _ := models.ByID(modelID)
+	responses := make([]string, n)
+	if provider == "claude" && model.Batch {
+		items := make([]claude.BatchRequestItem, n)
+		for i := range items {
+			items[i] = claudeBatchItem(model, strconv.Itoa(i), systemPrompt, userMessage)
+		}
+		results, err := claude.HandleBatch(ctx, items)
+		if err != nil {
+			return nil, fmt.Errorf("AI request failed: %w", err)
+		}
+		var firstErr error
+		for _, result := range results {
+			i, err := strconv.Atoi(result.CustomID)
+			if err != nil || i < 0 || i >= n {
+				continue
+			}
+			if responses[i], err = claudeBatchText(result); err != nil {
+				util.Errorf("candidate %d: %v", i+1, err)
+				if firstErr == nil {
+					firstErr = err
+				}
+			}
+		}
+		return responses, allFailed(responses, firstErr)
+	}
+
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := range responses {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			responses[i], errs[i] = callProvider(ctx, provider, modelID, systemPrompt, userMessage)
+			if errs[i] != nil {
+				util.Errorf("candidate %d: %v", i+1, errs[i])
+			}
+		}()
+	}
+	wg.Wait()
+	return responses, allFailed(responses, errors.Join(errs...))
+}
+
+// allFailed returns err when no response was generated
+func allFailed(responses []string, err error) error {
+	for _, response := range responses {
+		if response != "" {
+			return nil
+		}
+	}
+	if err == nil {
+		err = fmt.Errorf("no responses")
+	}
+	return fmt.Errorf("AI request failed: %w", err)
+}
+
+// selectCandidate picks the candidate to apply: only applied candidates
+// qualify, and when a check ran only passing ones. Qualifying candidates
+// are ranked by the judge model when given, otherwise the smallest change
+// wins.
+func selectCandidate(ctx context.Context, args archArgs, prompt string, candidates []candidate) (candidate, error) {
+	var eligible []candidate
+	for _, c := range candidates {
+		if c.err == nil && (!c.checked || c.passed) {
+			eligible = append(eligible, c)
+		}
+	}
+	if len(eligible) == 0 {
+		for _, c := range candidates {
+			if c.checked && c.output != "" {
+				util.Errorf("candidate %d check output:\n%s", c.index+1, c.output)
+			}
+		}
+		return candidate{}, fmt.Errorf("none of the %d candidates qualified, nothing applied", args.N)
+	}
+	if len(eligible) == 1 {
+		return eligible[0], nil
+	}
+
+	if args.Judge != "" {
+		m, err := models.Lookup(args.Judge)
+		if err != nil {
+			return candidate{}, err
+		}
+		judge := func(text string) (string, error) {
+			return callProvider(ctx, m.Provider, m.ID, lib.JudgeSystemPrompt, text)
+		}
+		var ranked []lib.Candidate
+		for _, c := range eligible {
+			ranked = append(ranked, lib.Candidate{Path: candidateName(c.index), Content: c.response})
+		}
+		ranking, err := lib.RankCandidates(judge, prompt, ranked, lib.DefaultRubric, false, len(ranked))
+		if err != nil {
+			return candidate{}, err
+		}
+		for _, c := range eligible {
+			if candidateName(c.index) == ranking.Winner {
+				util.Infof("%s ranked first by %s", ranking.Winner, args.Judge)
+				return c, nil
+			}
+		}
+	}
+
+	sort.SliceStable(eligible, func(i, j int) bool {
+		return eligible[i].lines < eligible[j].lines
+	})
+	return eligible[0], nil
+}
+
+func candidateName(index int) string {
+	return fmt.Sprintf("candidate %d", index+1)
+}
+
+// changedLines counts the lines added and removed by changes relative to ws
+func changedLines(ws workspace.Workspace, changes []workspace.Change) int {
+	total := 0
+	for _, change := range changes {
+		counts := map[string]int{}
+		if old, err := ws.ReadFile(change.Path); err == nil {
+			for _, line := range strings.Split(string(old), "\n") {
+				counts[line]++
+			}
+		}
+		if !change.Deleted {
+			for _, line := range strings.Split(string(change.Data), "\n") {
+				counts[line]--
+			}
+		}
+		for _, n := range counts {
+			total += max(n, -n)
+		}
+	}
+	return total
+}
+
+// runCheck copies the repo to a temp directory, applies changes, and runs
+// check there, returning whether it passed and the tail of its output
+func runCheck(ctx context.Context, check string, changes []workspace.Change) (bool, string, error) {
+	root := util.GetGitRoot()
+	if root == "" {
+		return false, "", fmt.Errorf("--check needs a git repository")
+	}
+	tmp, err := os.MkdirTemp("", "nina-arch-")
+	if err != nil {
+		return false, "", err
+	}
+	defer func() { _ = os.RemoveAll(tmp) }()
+
+	list, err := exec.CommandContext(ctx, "git", "-C", root, "ls-files", "-z", "-co", "--exclude-standard").Output()
+	if err != nil {
+		return false, "", fmt.Errorf("listing repo files: %w", err)
+	}
+	for _, rel := range strings.Split(string(list), "\x00") {
+		if rel == "" {
+			continue
+		}
+		src := filepath.Join(root, rel)
+		info, err := os.Lstat(src)
+		if err != nil || !info.Mode().IsRegular() {
+			// deleted but still tracked, or a symlink or submodule
+			continue
+		}
+		data, err := os.ReadFile(src)
+		if err != nil {
+			return false, "", err
+		}
+		if err := writeFile(filepath.Join(tmp, rel), data, info.Mode().Perm()); err != nil {
+			return false, "", err
+		}
+	}
+	for _, change := range changes {
+		rel, err := filepath.Rel(root, change.Path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+			continue
+		}
+		dst := filepath.Join(tmp, rel)
+		if change.Deleted {
+			_ = os.Remove(dst)
+			continue
+		}
+		perm := change.Perm
+		if perm == 0 {
+			perm = 0644
+		}
+		if err := writeFile(dst, change.Data, perm); err != nil {
+			return false, "", err
+		}
+	}
+
+	cmd := exec.CommandContext(ctx, "bash", "-c", check)
+	cmd.Dir = tmp
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	err = cmd.Run()
+	output := strings.TrimSpace(util.Redact(out.String()))
+	if len(output) > maxCheckOutput {
+		output = output[len(output)-maxCheckOutput:]
+	}
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return false, output, fmt.Errorf("running %s: %w", check, err)
+	}
+	return err == nil, output, nil
+}
+
+func writeFile(path string, data []byte, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, perm)
+}
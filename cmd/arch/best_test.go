@@ -0,0 +1,131 @@
+package arch
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nathants/nina/workspace"
+)
+
+func TestChangedLines(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.txt")
+	if err := os.WriteFile(path, []byte("one\ntwo\nthree\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ws := workspace.Current()
+	tests := []struct {
+		name    string
+		changes []workspace.Change
+		want    int
+	}{
+		{"unchanged", []workspace.Change{{Path: path, Data: []byte("one\ntwo\nthree\n")}}, 0},
+		{"edit", []workspace.Change{{Path: path, Data: []byte("one\n2\nthree\n")}}, 2},
+		{"append", []workspace.Change{{Path: path, Data: []byte("one\ntwo\nthree\nfour\n")}}, 1},
+		{"delete", []workspace.Change{{Path: path, Deleted: true}}, 4},
+		{"new file", []workspace.Change{{Path: filepath.Join(dir, "b.txt"), Data: []byte("x\ny")}}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := changedLines(ws, tt.changes); got != tt.want {
+				t.Errorf("changedLines() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectCandidate(t *testing.T) {
+	ctx := context.Background()
+	args := archArgs{N: 4}
+	candidates := []candidate{
+		{index: 0, lines: 3, err: errors.New("bad block")},
+		{index: 1, lines: 9, checked: true, passed: true},
+		{index: 2, lines: 1, checked: true, passed: false},
+		{index: 3, lines: 5, checked: true, passed: true},
+	}
+	best, err := selectCandidate(ctx, args, "", candidates)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if best.index != 3 {
+		t.Errorf("selected candidate %d, want 3", best.index)
+	}
+
+	// ties go to the first candidate
+	best, err = selectCandidate(ctx, args, "", []candidate{{index: 0, lines: 2}, {index: 1, lines: 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if best.index != 0 {
+		t.Errorf("selected candidate %d, want 0", best.index)
+	}
+
+	_, err = selectCandidate(ctx, args, "", candidates[:1])
+	if err == nil || !strings.Contains(err.Error(), "nothing applied") {
+		t.Errorf("expected nothing applied error, got %v", err)
+	}
+}
+
+func TestRunCheck(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir := t.TempDir()
+	for _, args := range [][]string{{"init", "-q"}, {"config", "user.email", "test@example.com"}, {"config", "user.name", "test"}} {
+		if out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+	files := map[string]string{
+		"value.txt":   "old\n",
+		"gone.txt":    "x\n",
+		".gitignore":  "ignored.txt\n",
+		"ignored.txt": "secret\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Chdir(dir)
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check := `grep -q new value.txt && test ! -e gone.txt && test ! -e ignored.txt && cat added/file.txt`
+	changes := []workspace.Change{
+		{Path: filepath.Join(root, "value.txt"), Data: []byte("new\n"), Perm: 0644},
+		{Path: filepath.Join(root, "gone.txt"), Deleted: true},
+		{Path: filepath.Join(root, "added", "file.txt"), Data: []byte("added\n")},
+	}
+	passed, output, err := runCheck(context.Background(), check, changes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !passed || output != "added" {
+		t.Errorf("runCheck() = %v, %q, want pass with output added", passed, output)
+	}
+
+	// the repo itself is untouched
+	data, err := os.ReadFile(filepath.Join(dir, "value.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "old\n" {
+		t.Errorf("value.txt changed to %q", data)
+	}
+
+	passed, _, err = runCheck(context.Background(), check, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if passed {
+		t.Error("check passed without the changes")
+	}
+}
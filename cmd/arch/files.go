@@ -0,0 +1,129 @@
+package arch
+
+// file arguments for arch. globs are expanded, and directories are walked
+// recursively: in a git repo the tracked and untracked files not ignored by
+// .gitignore are used, otherwise every file outside .git. --ext narrows the
+// files found in directories, files named directly are always included.
+
+import (
+	"fmt"
+	"io/fs"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/nathants/nina/lib"
+	"github.com/nathants/nina/models"
+	util "github.com/nathants/nina/util"
+	"github.com/nathants/nina/workspace"
+)
+
+// largeContext is the estimated prompt size warned about before sending
+const largeContext = 100_000
+
+// expandFiles returns the files named by patterns, with directories walked
+// and their files filtered by exts
+func expandFiles(patterns, exts []string) ([]string, error) {
+	var paths []string
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %s: %w", pattern, err)
+		}
+		if len(matches) == 0 {
+			// Treat as literal filename if no glob matches
+			matches = []string{pattern}
+		}
+		for _, path := range matches {
+			info, err := workspace.Current().Stat(path)
+			if err != nil || !info.IsDir() {
+				paths = append(paths, path)
+				continue
+			}
+			files, err := walkDir(path, exts)
+			if err != nil {
+				return nil, err
+			}
+			util.Verbosef("found %d files in %s", len(files), path)
+			paths = append(paths, files...)
+		}
+	}
+	return paths, nil
+}
+
+// walkDir returns the files below dir matching exts, without those ignored
+// by git or under the agents directory
+func walkDir(dir string, exts []string) ([]string, error) {
+	if !workspace.IsLocal(workspace.Current()) {
+		return nil, fmt.Errorf("directory %s needs a local workspace, name its files instead", dir)
+	}
+	var files []string
+	if out, err := exec.Command("git", "-C", dir, "ls-files", "-z", "-co", "--exclude-standard").Output(); err == nil {
+		for _, rel := range strings.Split(string(out), "\x00") {
+			if rel != "" {
+				files = append(files, filepath.Join(dir, rel))
+			}
+		}
+	} else {
+		err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() && d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			if d.Type().IsRegular() {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("walking %s: %w", dir, err)
+		}
+	}
+
+	agents, _ := filepath.Abs(util.GetAgentsDir())
+	var matched []string
+	for _, path := range files {
+		if abs, err := filepath.Abs(path); err == nil && strings.HasPrefix(abs, agents+string(filepath.Separator)) {
+			continue
+		}
+		if matchExt(path, exts) {
+			matched = append(matched, path)
+		}
+	}
+	sort.Strings(matched)
+	return matched, nil
+}
+
+// matchExt reports whether path has one of exts, given as go or .go, any
+// path matches when exts is empty
+func matchExt(path string, exts []string) bool {
+	if len(exts) == 0 {
+		return true
+	}
+	for _, list := range exts {
+		for _, ext := range strings.Split(list, ",") {
+			ext = strings.TrimSpace(ext)
+			if ext != "" && strings.EqualFold(filepath.Ext(path), "."+strings.TrimPrefix(ext, ".")) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// warnLargeContext warns with the estimated tokens of a prompt when it is
+// large, or more than the context window of model
+func warnLargeContext(model models.Model, files int, system, message string) {
+	tokens := util.CalculateSystemPromptTokens(system) + util.CalculateMessageTokens("user", message)
+	switch {
+	case model.ContextWindow > 0 && tokens > model.ContextWindow:
+		util.Errorf("warning: %d files are about %s tokens, more than the %s token context window of %s", files, lib.FormatTokens(tokens), lib.FormatTokens(model.ContextWindow), model.Alias)
+	case tokens > largeContext:
+		util.Errorf("warning: %d files are about %s tokens, sending a large context", files, lib.FormatTokens(tokens))
+	default:
+		util.Verbosef("%d files are about %s tokens", files, lib.FormatTokens(tokens))
+	}
+}
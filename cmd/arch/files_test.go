@@ -0,0 +1,54 @@
+package arch
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestExpandFiles(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	for _, name := range []string{"src/a.go", "src/b.md", "src/sub/c.go", "src/ignored.go", "src/.git/x.go", "agents/log.go", "top.txt"} {
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte("x\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// without git every file outside .git is found
+	got, err := expandFiles([]string{"src", "top.txt"}, []string{"go"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"src/a.go", "src/ignored.go", "src/sub/c.go", "top.txt"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expandFiles() = %v, want %v", got, want)
+	}
+
+	// in a git repo ignored files and agents are skipped
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	if err := os.RemoveAll("src/.git"); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := exec.Command("git", "init", "-q").CombinedOutput(); err != nil {
+		t.Fatalf("git init: %v: %s", err, out)
+	}
+	if err := os.WriteFile(".gitignore", []byte("ignored.go\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err = expandFiles([]string{"."}, []string{".go", "md"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"src/a.go", "src/b.md", "src/sub/c.go"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expandFiles() = %v, want %v", got, want)
+	}
+}
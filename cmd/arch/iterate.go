@@ -0,0 +1,80 @@
+package arch
+
+// iterative refinement for arch --iterate. each response is applied to an
+// in-memory overlay first, like the candidates of --n, so a response that
+// fails to parse or apply, or fails the check, never touches the repo. its
+// errors are sent back with the original prompt and files for another round.
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	util "github.com/nathants/nina/util"
+	"github.com/nathants/nina/workspace"
+)
+
+func runIterate(ctx context.Context, args archArgs, provider, modelID, systemPrompt, userMessage string, files map[string]string) error {
+	check, err := checkCommand(args)
+	if err != nil {
+		return err
+	}
+	message := userMessage
+	var feedback string
+	for round := 0; round <= args.Iterate; round++ {
+		if round > 0 {
+			util.Infof("refinement round %d of %d", round, args.Iterate)
+		}
+		response, err := respond(ctx, args, provider, modelID, systemPrompt, message)
+		if err != nil {
+			return err
+		}
+		feedback, err = tryResponse(ctx, check, response, files)
+		if err != nil {
+			return err
+		}
+		if feedback == "" {
+			return finishResponse(ctx, args, response, files)
+		}
+		util.Errorf("round %d failed: %s", round, feedback)
+		message = refineMessage(userMessage, response, feedback)
+	}
+	return fmt.Errorf("changes still failing after %d refinement rounds, nothing applied", args.Iterate)
+}
+
+// tryResponse applies response to an overlay and runs check with its
+// changes, returning why it failed, or "" when it worked. The error is for
+// failures that are not the model's, like a check that could not run.
+func tryResponse(ctx context.Context, check, response string, files map[string]string) (string, error) {
+	base := workspace.Current()
+	overlay := workspace.NewOverlay(base)
+	workspace.SetCurrent(overlay)
+	err := applyResponse(ctx, response, files, false)
+	workspace.SetCurrent(base)
+	if err != nil {
+		return err.Error(), nil
+	}
+	if check == "" {
+		return "", nil
+	}
+	passed, output, err := runCheck(ctx, check, overlay.Changes())
+	if err != nil || passed {
+		return "", err
+	}
+	return fmt.Sprintf("the changes were applied but %s failed:\n%s", check, output), nil
+}
+
+// refineMessage is the original message followed by the previous response
+// and why it failed, asking for a complete response again
+func refineMessage(userMessage, response, feedback string) string {
+	var b strings.Builder
+	b.WriteString(userMessage)
+	b.WriteString("\n\n<NinaHistory>\n")
+	b.WriteString("Your previous response, below, was not applied:\n\n")
+	b.WriteString(strings.TrimSpace(feedback))
+	b.WriteString("\n\n")
+	b.WriteString(strings.TrimSpace(response))
+	b.WriteString("\n</NinaHistory>\n\n")
+	b.WriteString("The files are unchanged. Respond again with the complete set of changes, fixing the errors above.")
+	return b.String()
+}
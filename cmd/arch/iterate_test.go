@@ -0,0 +1,61 @@
+package arch
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	util "github.com/nathants/nina/util"
+)
+
+func TestTryResponse(t *testing.T) {
+	t.Setenv("NINA_CONVERTER_MODEL", "local")
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(dir)
+	path := filepath.Join(dir, "a.txt")
+	if err := os.WriteFile(path, []byte("one\ntwo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{path: "one\ntwo\n"}
+	change := func(search, replace string) string {
+		return util.NinaOutputStart + util.NinaStart +
+			util.NinaPathStart + path + util.NinaPathEnd +
+			util.NinaSearchStart + search + util.NinaSearchEnd +
+			util.NinaReplaceStart + replace + util.NinaReplaceEnd +
+			util.NinaEnd + util.NinaOutputEnd
+	}
+	ctx := context.Background()
+
+	feedback, err := tryResponse(ctx, "", change("three", "THREE"), files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if feedback == "" {
+		t.Error("expected feedback for a search not in the file")
+	}
+	feedback, err = tryResponse(ctx, "", change("two", "TWO"), files)
+	if err != nil || feedback != "" {
+		t.Errorf("tryResponse() = %q, %v, want it to work", feedback, err)
+	}
+
+	// only the overlay was written
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "one\ntwo\n" {
+		t.Errorf("a.txt changed to %q", data)
+	}
+
+	message := refineMessage("prompt", "response", "bad search")
+	for _, want := range []string{"prompt\n\n<NinaHistory>", "bad search", "response\n</NinaHistory>"} {
+		if !strings.Contains(message, want) {
+			t.Errorf("missing %q in %q", want, message)
+		}
+	}
+}
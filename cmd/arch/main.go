@@ -5,11 +5,14 @@ package arch
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"github.com/nathants/nina/lib"
+	"github.com/nathants/nina/models"
 	"github.com/nathants/nina/prompts"
 	util "github.com/nathants/nina/util"
+	"github.com/nathants/nina/workspace"
 	"os"
 	"path/filepath"
 	"sort"
@@ -27,32 +30,28 @@ func init() {
 	lib.Args["arch"] = archArgs{}
 }
 
-var supportedModels = map[string]bool{
-	// Short names only
-	"o3":           true,
-	"o3-flex":      true,
-	"o3-pro":       true,
-	"opus":         true,
-	"opus-batch":   true,
-	"sonnet":       true,
-	"sonnet-batch": true,
-	"o4-mini":      true,
-	"o4-mini-flex": true,
-	"gemini":       true,
-	"flash":        true,
-	"4.1":          true,
-	"4.1-mini":     true,
-	"v0-md":        true,
-	"v0-lg":        true,
-	"ollama":       true,
-	"grok":         true,
-}
-
 type archArgs struct {
-	Files   []string `arg:"positional" help:"files to include in the prompt"`
-	Model   string   `arg:"-m,--model" default:"sonnet" help:"AI model to use"`
-	DryRun  bool     `arg:"-n,--dry-run" help:"show changes without applying them"`
-	Verbose bool     `arg:"-v,--verbose" help:"verbose output"`
+	Files     []string `arg:"positional" help:"files, globs, or directories to include in the prompt"`
+	Ext       []string `arg:"--ext,separate" help:"extensions of the files included from directories, e.g. go,md"`
+	Model     string   `arg:"-m,--model" default:"sonnet" help:"AI model to use"`
+	DryRun    bool     `arg:"-n,--dry-run" help:"show changes without applying them"`
+	Output    string   `arg:"--output" default:"write" help:"write to change the files, or patch to print a patch for git apply instead"`
+	PatchFile string   `arg:"--patch-file" help:"with --output patch, write the patch to this file instead of stdout"`
+	Verbose   bool     `arg:"-v,--verbose" help:"verbose output"`
+	Converter string   `arg:"--converter" help:"model to locate search text, or 'local' for no AI (default: $NINA_CONVERTER_MODEL or sonnet)"`
+	AllowPath []string `arg:"--allow-path,separate" help:"additional directory changes may write to, outside the git root"`
+	Protect   []string `arg:"--protect,separate" help:"path or glob changes may not write, e.g. .github/workflows or '*.lock', adds to NINA_PROTECT"`
+	Exec      string   `arg:"--exec" help:"where files are read and written: local or ssh://[user@]host[:path]"`
+	Effort    string   `arg:"--effort" help:"reasoning effort for openai reasoning models: low, medium, high"`
+	Budget    int      `arg:"--thinking-budget" help:"thinking token budget for claude and gemini models"`
+	System    string   `arg:"--system" help:"replace the system prompt"`
+	SysFile   string   `arg:"--system-file" help:"replace the system prompt with the contents of a file"`
+	AppendSys string   `arg:"--append-system" help:"append text to the system prompt"`
+	NoCache   bool     `arg:"--no-cache" help:"always call the model instead of reusing a cached identical response"`
+	N         int      `arg:"--n" default:"1" help:"generate this many candidate change sets and apply the best one"`
+	Check     string   `arg:"--check" help:"with --n or --iterate, build/test command run in a temp copy of the repo with each candidate applied (default: $NINA_VALIDATE)"`
+	Judge     string   `arg:"--judge" help:"with --n, model that ranks the candidates passing --check, otherwise the smallest change wins"`
+	Iterate   int      `arg:"--iterate" help:"send errors applying the changes, or a failing --check, back to the model for up to this many refinement rounds"`
 }
 
 func (archArgs) Description() string {
@@ -60,59 +59,42 @@ func (archArgs) Description() string {
 
 Reads a prompt from stdin and applies AI-suggested changes to files.
 
-Supported models (short names):
-  - o3, o3-flex, o3-pro
-  - opus, opus-batch, sonnet, sonnet-batch
-  - o4-mini, o4-mini-flex
-  - gemini, flash
-  - 4.1, 4.1-mini
-  - v0-md, v0-lg
-  - ollama, grok
+Directories are walked recursively, skipping files ignored by .gitignore,
+binary files, and agents/. --ext keeps only the files with the given
+extensions. A warning with the estimated tokens is printed before a large
+prompt is sent.
+
+Supported models: ` + strings.Join(models.Aliases(), ", ") + `
+Run 'nina models list' for providers and settings.
+
+With --n, several candidate responses are requested, in one batch for
+batch models. Each is applied to an in-memory overlay, and when --check
+or NINA_VALIDATE is set the command runs in a temp copy of the repo
+with the candidate's changes. The best candidate is applied: passing
+candidates first, ranked by --judge when given, else the smallest change.
+
+With --iterate, a response that fails to parse or apply, or fails --check
+or NINA_VALIDATE, is not applied. The errors and the response are sent
+back to the model with the original prompt and files, for up to that many
+refinement rounds, and the first response that works is applied.
+
+With --output patch, the files are left untouched and the changes are
+printed as a patch for git apply, with paths relative to the git root, or
+written to --patch-file.
 
 Example:
   echo "refactor this function to use async/await" | nina arch src/*.js
-  echo "add error handling" | nina arch main.go util.go -m gemini`
+  echo "add error handling" | nina arch main.go util.go -m gemini
+  echo "document the exported functions" | nina arch lib/ --ext go
+  echo "fix the flaky test" | nina arch --n 3 --check 'go test ./...' *.go
+  echo "add a retry option" | nina arch --iterate 3 --check 'go vet ./...' *.go
+  echo "rename the config type" | nina arch --output patch *.go | git apply --check`
 }
 
+// parseModel returns the provider and internal model id for a short name
 func parseModel(model string) (provider, modelID string) {
-	switch model {
-	case "o3":
-		return "openai", "o3-high"
-	case "o3-flex":
-		return "openai", "o3-flex"
-	case "o3-pro":
-		return "openai", "o3-pro"
-	case "opus":
-		return "claude", "claude-4-opus-24k-thinking"
-	case "opus-batch":
-		return "claude", "claude-4-opus-batch-24k-thinking"
-	case "sonnet":
-		return "claude", "claude-4-sonnet-24k-thinking"
-	case "sonnet-batch":
-		return "claude", "claude-4-sonnet-batch-24k-thinking"
-	case "o4-mini":
-		return "openai", "o4-mini-medium"
-	case "o4-mini-flex":
-		return "openai", "o4-mini-flex"
-	case "gemini":
-		return "gemini", "gemini-2.5-pro-32k-thinking"
-	case "flash":
-		return "gemini", "gemini-2.5-flash-24k-thinking"
-	case "4.1":
-		return "openai", "gpt-4.1-0.5-temp"
-	case "4.1-mini":
-		return "openai", "gpt-4.1-mini-0.5-temp"
-	case "v0-md":
-		return "v0", "v0-1.5-md"
-	case "v0-lg":
-		return "v0", "v0-1.5-lg"
-	case "ollama":
-		return "ollama", "ollama"
-	case "grok":
-		return "grok", "grok-4-0709"
-	default:
-		panic("unknown model: " + model)
-	}
+	m := models.MustLookup(model)
+	return m.Provider, m.ID
 }
 
 func readStdin() (string, error) {
@@ -128,7 +110,7 @@ func readStdin() (string, error) {
 }
 
 func readFile(path string) (string, error) {
-	data, err := os.ReadFile(path)
+	data, err := workspace.Current().ReadFile(path)
 	if err != nil {
 		return "", fmt.Errorf("reading file %s: %w", path, err)
 	}
@@ -154,24 +136,8 @@ func formatNinaInput(prompt string, files map[string]string) string {
 	sort.Strings(paths)
 
 	for _, path := range paths {
-		content := files[path]
-		builder.WriteString("\n")
-		builder.WriteString(util.NinaFileStart)
-		builder.WriteString("\n\n")
-		builder.WriteString(util.NinaPathStart)
-		builder.WriteString("\n")
-		builder.WriteString(path)
-		builder.WriteString("\n")
-		builder.WriteString(util.NinaPathEnd)
-		builder.WriteString("\n\n")
-		builder.WriteString(util.NinaContentStart)
-		builder.WriteString("\n")
-		builder.WriteString(content)
-		builder.WriteString("\n")
-		builder.WriteString(util.NinaContentEnd)
-		builder.WriteString("\n\n")
-		builder.WriteString(util.NinaFileEnd)
 		builder.WriteString("\n")
+		builder.WriteString(util.FormatNinaFile(path, files[path]))
 	}
 
 	builder.WriteString("\n")
@@ -180,53 +146,20 @@ func formatNinaInput(prompt string, files map[string]string) string {
 }
 
 func callProvider(ctx context.Context, provider, modelID, systemPrompt, userMessage string) (string, error) {
+	model, _ := models.ByID(modelID)
 	switch provider {
 	case "claude":
 		// Check if this is a batch model
-		if strings.Contains(modelID, "batch") {
+		if model.Batch {
 			// Handle batch models using HandleBatch
-			messages := []claude.Message{
-				{Role: "user", Content: []claude.Text{{Type: "text", Text: userMessage}}},
-			}
-			batchReq := claude.BatchRequestItem{
-				CustomID: "0",
-				Params: claude.BatchParams{
-					Model:     convertClaudeModelID(modelID),
-					System:    systemPrompt,
-					Messages:  messages,
-					MaxTokens: 32000,
-					Thinking: &claude.Thinking{
-						Type:         "enabled",
-						BudgetTokens: 24000,
-					},
-					UseOAuth: false,
-				},
-			}
-			results, err := claude.HandleBatch(ctx, []claude.BatchRequestItem{batchReq})
+			results, err := claude.HandleBatch(ctx, []claude.BatchRequestItem{claudeBatchItem(model, "0", systemPrompt, userMessage)})
 			if err != nil {
 				return "", err
 			}
 			if len(results) == 0 {
 				return "", fmt.Errorf("claude batch empty result")
 			}
-			first := results[0]
-			if first.Result.Error != nil {
-				return "", fmt.Errorf("claude batch error: %v", first.Result.Error)
-			}
-			if first.Result.Message == nil {
-				return "", fmt.Errorf("claude batch no message")
-			}
-			var sb strings.Builder
-			for i, blk := range first.Result.Message.Content {
-				if blk.Type != "text" {
-					continue
-				}
-				if i > 0 {
-					sb.WriteString("\n")
-				}
-				sb.WriteString(blk.Text)
-			}
-			return sb.String(), nil
+			return claudeBatchText(results[0])
 		}
 
 		// Handle regular Claude 4 models
@@ -238,18 +171,19 @@ func callProvider(ctx context.Context, provider, modelID, systemPrompt, userMess
 			}},
 		}}
 		req := claude.Request{
-			Model: convertClaudeModelID(modelID),
+			Model: model.APIModel,
 			System: []claude.Text{{
 				Type: "text",
 				Text: systemPrompt,
 			}},
 			Messages:  messages,
-			MaxTokens: 32000,
+			MaxTokens: model.MaxOutput,
+			Betas:     model.Betas,
 		}
-		if strings.Contains(modelID, "thinking") {
+		if model.ThinkingBudget > 0 {
 			req.Thinking = &claude.Thinking{
 				Type:         "enabled",
-				BudgetTokens: 24000,
+				BudgetTokens: model.ThinkingBudget,
 			}
 		}
 		resp, err := claude.Handle(ctx, req, nil)
@@ -261,7 +195,7 @@ func callProvider(ctx context.Context, provider, modelID, systemPrompt, userMess
 	case "openai":
 		// Handle OpenAI models
 		req := openai.Request{
-			Model: convertOpenAIModelID(modelID),
+			Model: model.APIModel,
 			Input: []openai.ChatMessage{
 				{
 					Type: "message",
@@ -280,25 +214,15 @@ func callProvider(ctx context.Context, provider, modelID, systemPrompt, userMess
 			},
 			Stream: false,
 		}
-		if strings.HasSuffix(modelID, "-flex") {
-			req.ServiceTier = "flex"
-		}
-		if strings.HasPrefix(modelID, "o3") {
-			req.Reasoning = &openai.ReasoningRequest{
-				Summary: "auto",
-				Effort:  "high",
-			}
-		}
-		if strings.HasPrefix(modelID, "o4-mini") {
+		req.ServiceTier = model.ServiceTier
+		req.Background = model.Background
+		if model.Effort != "" {
 			req.Reasoning = &openai.ReasoningRequest{
 				Summary: "auto",
-				Effort:  "medium",
+				Effort:  model.Effort,
 			}
 		}
-		if strings.HasPrefix(modelID, "gpt-4.1") {
-			temp := 0.5
-			req.Temperature = &temp
-		}
+		req.Temperature = model.Temperature
 		resp, err := openai.Handle(ctx, req, nil)
 		if err != nil {
 			return "", err
@@ -308,14 +232,16 @@ func callProvider(ctx context.Context, provider, modelID, systemPrompt, userMess
 
 	case "gemini":
 		// Handle Gemini models
-		messages := []string{userMessage}
-		thinkingBudget := 0
-		if modelID == "gemini-2.5-pro-32k-thinking" {
-			thinkingBudget = 32000
-		} else if modelID == "gemini-2.5-flash-24k-thinking" {
-			thinkingBudget = 24000
+		resp, err := gemini.Handle(ctx, gemini.Request{
+			Model:          model.APIModel,
+			System:         systemPrompt,
+			Contents:       []gemini.Content{gemini.UserText(userMessage)},
+			ThinkingBudget: model.ThinkingBudget,
+		}, nil)
+		if err != nil {
+			return "", err
 		}
-		return gemini.Handle(ctx, convertGeminiModelID(modelID), systemPrompt, messages, nil, nil, false, thinkingBudget)
+		return resp.Text, nil
 
 	case "grok":
 		// Handle Grok models
@@ -330,12 +256,16 @@ func callProvider(ctx context.Context, provider, modelID, systemPrompt, userMess
 			},
 		}
 		req := grok.Request{
-			Model:       modelID,
+			Model:       model.APIModel,
 			Messages:    messages,
 			Stream:      false,
 			Temperature: 0,
 		}
-		return grok.Handle(ctx, req)
+		resp, err := grok.Handle(ctx, req, nil)
+		if err != nil {
+			return "", err
+		}
+		return resp.Text, nil
 
 	case "v0":
 		return "", fmt.Errorf("v0 provider not yet implemented in arch")
@@ -348,89 +278,96 @@ func callProvider(ctx context.Context, provider, modelID, systemPrompt, userMess
 	}
 }
 
-func convertOpenAIModelID(modelID string) string {
-	switch modelID {
-	case "o3-high":
-		return "o3"
-	case "o3-flex":
-		return "o3"
-	case "o3-pro":
-		return "o3-pro"
-	case "o4-mini-medium":
-		return "o4-mini"
-	case "o4-mini-flex":
-		return "o4-mini"
-	case "gpt-4.1-0.5-temp":
-		return "gpt-4.1"
-	case "gpt-4.1-mini-0.5-temp":
-		return "gpt-4.1-mini"
-	default:
-		panic("unknown OpenAI model: " + modelID)
+// claudeBatchItem builds one request of a claude batch
+func claudeBatchItem(model models.Model, customID, systemPrompt, userMessage string) claude.BatchRequestItem {
+	messages := []claude.Message{
+		{Role: "user", Content: []claude.Text{{Type: "text", Text: userMessage}}},
 	}
-}
-
-func convertClaudeModelID(modelID string) string {
-	switch modelID {
-	case "claude-4-opus-24k-thinking":
-		return "claude-opus-4-20250514"
-	case "claude-4-opus-batch-24k-thinking":
-		return "claude-opus-4-20250514"
-	case "claude-4-sonnet-24k-thinking":
-		return "claude-sonnet-4-20250514"
-	case "claude-4-sonnet-batch-24k-thinking":
-		return "claude-sonnet-4-20250514"
-	default:
-		panic("unknown Claude model: " + modelID)
+	return claude.BatchRequestItem{
+		CustomID: customID,
+		Params: claude.BatchParams{
+			Model:     model.APIModel,
+			System:    systemPrompt,
+			Messages:  messages,
+			MaxTokens: model.MaxOutput,
+			Thinking: &claude.Thinking{
+				Type:         "enabled",
+				BudgetTokens: model.ThinkingBudget,
+			},
+			UseOAuth: false,
+		},
 	}
 }
 
-func convertGeminiModelID(modelID string) string {
-	switch modelID {
-	case "gemini-2.5-pro-32k-thinking":
-		return "gemini-2.5-pro"
-	case "gemini-2.5-flash-24k-thinking":
-		return "gemini-2.5-flash"
-	default:
-		panic("unknown Gemini model: " + modelID)
+// claudeBatchText returns the text of one claude batch result
+func claudeBatchText(result claude.BatchIndividualResult) (string, error) {
+	if result.Result.Error != nil {
+		return "", fmt.Errorf("claude batch error: %v", result.Result.Error)
+	}
+	if result.Result.Message == nil {
+		return "", fmt.Errorf("claude batch no message")
+	}
+	var sb strings.Builder
+	for i, blk := range result.Result.Message.Content {
+		if blk.Type != "text" {
+			continue
+		}
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		sb.WriteString(blk.Text)
 	}
+	return sb.String(), nil
 }
 
 func run(args archArgs) error {
 	ctx := context.Background()
 
+	if args.Output != "write" && args.Output != "patch" {
+		return fmt.Errorf("--output must be write or patch, got %q", args.Output)
+	}
+	if args.Output == "patch" && args.DryRun {
+		return fmt.Errorf("--output patch already leaves the files untouched, drop --dry-run")
+	}
+
 	prompt, err := readStdin()
 	if err != nil {
 		return err
 	}
 
+	// Dry runs apply everything to an in-memory overlay so deletes, renames,
+	// and path checks are exercised without touching the filesystem
+	if args.DryRun {
+		workspace.SetCurrent(workspace.NewOverlay(workspace.Current()))
+	}
+
 	// Read all files
+	paths, err := expandFiles(args.Files, args.Ext)
+	if err != nil {
+		return err
+	}
 	files := make(map[string]string)
-	for _, pattern := range args.Files {
-		matches, err := filepath.Glob(pattern)
+	for _, path := range paths {
+		absPath, err := filepath.Abs(path)
 		if err != nil {
-			return fmt.Errorf("invalid pattern %s: %w", pattern, err)
-		}
-		if len(matches) == 0 {
-			// Treat as literal filename if no glob matches
-			matches = []string{pattern}
+			return fmt.Errorf("getting absolute path for %s: %w", path, err)
 		}
-
-		for _, path := range matches {
-			absPath, err := filepath.Abs(path)
-			if err != nil {
-				return fmt.Errorf("getting absolute path for %s: %w", path, err)
-			}
-			content, err := readFile(path)
-			if err != nil {
-				return err
+		// Binary and oversized files are skipped rather than packed into context
+		if err := util.CheckFile(path); err != nil {
+			if errors.Is(err, util.ErrBinaryFile) || errors.Is(err, util.ErrFileTooLarge) {
+				util.Errorf("skipping %v", err)
+				continue
 			}
-			files[absPath] = content
+			return err
 		}
+		content, err := readFile(path)
+		if err != nil {
+			return err
+		}
+		files[absPath] = content
 	}
 
-	if args.Verbose {
-		fmt.Fprintf(os.Stderr, "Processing %d files with prompt: %s\n", len(files), prompt)
-	}
+	util.Verbosef("Processing %d files with prompt: %s", len(files), prompt)
 
 	// Validate we have files to process
 	if len(files) == 0 {
@@ -451,34 +388,81 @@ func run(args archArgs) error {
 	if err != nil {
 		return fmt.Errorf("failed to read ARCHITECT.md prompt: %w", err)
 	}
+	override, err := prompts.NewOverride(args.System, args.SysFile, args.AppendSys)
+	if err != nil {
+		return err
+	}
+	systemPrompt := override.Apply(string(architectPrompt))
 
 	// Parse model to get provider and modelID
 	provider, modelID := parseModel(args.Model)
 
-	if args.Verbose {
-		fmt.Fprintf(os.Stderr, "Calling AI model: %s (provider: %s)\n", args.Model, provider)
+	m, _ := models.ByID(modelID)
+	warnLargeContext(m, len(files), systemPrompt, fullUserMessage)
+
+	util.Verbosef("Calling AI model: %s (provider: %s)", args.Model, provider)
+
+	if args.N > 1 && args.Iterate > 0 {
+		return fmt.Errorf("--n and --iterate can not be combined")
+	}
+	if args.N > 1 {
+		return runBestOfN(ctx, args, provider, modelID, systemPrompt, fullUserMessage, prompt, files)
+	}
+	if args.Iterate > 0 {
+		return runIterate(ctx, args, provider, modelID, systemPrompt, fullUserMessage, files)
 	}
 
-	// Call appropriate provider
-	respText, err := callProvider(ctx, provider, modelID, string(architectPrompt), fullUserMessage)
+	respText, err := respond(ctx, args, provider, modelID, systemPrompt, fullUserMessage)
 	if err != nil {
-		return fmt.Errorf("AI request failed: %w", err)
+		return err
 	}
 
-	if args.Verbose {
-		fmt.Fprintln(os.Stderr, "AI response received")
+	util.Verbosef("AI response received")
+
+	return finishResponse(ctx, args, respText, files)
+}
+
+// respond calls the provider, identical requests are served from the cache
+func respond(ctx context.Context, args archArgs, provider, modelID, systemPrompt, userMessage string) (string, error) {
+	cacheKey := ""
+	if !args.NoCache && lib.CacheEnabled() {
+		m, _ := models.ByID(modelID)
+		cacheKey = lib.ResponseCacheKey(m, systemPrompt, userMessage)
+	}
+	if cacheKey != "" {
+		if respText, cached := lib.CachedResponse(cacheKey); cached {
+			return respText, nil
+		}
 	}
+	respText, err := callProvider(ctx, provider, modelID, systemPrompt, userMessage)
+	if err != nil {
+		return "", fmt.Errorf("AI request failed: %w", err)
+	}
+	if cacheKey != "" {
+		lib.StoreResponse(cacheKey, respText)
+	}
+	return respText, nil
+}
+
+// applyResponse applies the NinaChange, NinaDelete, and NinaRename tags of a
+// response to the current workspace, showing the changes when dryRun is set
+func applyResponse(ctx context.Context, respText string, files map[string]string, dryRun bool) error {
+	ws := workspace.Current()
 
 	// Extract NinaChange entries from response
 	updates, err := util.ParseFileUpdates(respText)
-	if err != nil {
+	if err != nil && !util.SkipBrokenChanges(err) {
 		return fmt.Errorf("failed to parse AI response: %w", err)
 	}
 
-	if args.Verbose {
-		fmt.Fprintf(os.Stderr, "Found %d file updates\n", len(updates))
+	fileOps, err := util.ParseFileOps(respText)
+	if err != nil {
+		return fmt.Errorf("failed to parse AI response: %w", err)
 	}
 
+	util.Verbosef("Found %d file updates", len(updates))
+	util.Verbosef("Found %d file deletes and renames", len(fileOps))
+
 	// Apply updates to each file
 	for _, update := range updates {
 		// Get original content
@@ -487,6 +471,8 @@ func run(args archArgs) error {
 			// New file
 			origContent = ""
 		}
+		// edit with \n line endings and write back in the file's own
+		origContent, format := util.NormalizeText(origContent)
 
 		// Create session state for this file
 		session := &util.SessionState{
@@ -507,7 +493,7 @@ func run(args archArgs) error {
 			return fmt.Errorf("failed to apply updates to %s: %w", update.FileName, err)
 		}
 
-		if args.DryRun {
+		if dryRun {
 			// Show diff
 			fmt.Printf("=== %s ===\n", update.FileName)
 			if exists {
@@ -533,26 +519,58 @@ func run(args archArgs) error {
 				fmt.Println("New file:")
 				fmt.Println(newContent)
 			}
+		}
+
+		if err := util.CheckPathAllowed(update.FileName); err != nil {
+			return err
+		}
+
+		// Ensure directory exists for new files
+		dir := filepath.Dir(update.FileName)
+		if err := ws.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create directory %s: %w", dir, err)
+		}
+
+		// Write to the workspace, an overlay when dry running
+		err = ws.WriteFile(update.FileName, []byte(format.Restore(newContent)), workspace.FileMode(ws, update.FileName, 0644))
+		if err != nil {
+			return fmt.Errorf("failed to write %s: %w", update.FileName, err)
+		}
+		if !dryRun {
+			util.Verbosef("Updated %s", update.FileName)
+		}
+	}
+
+	// Deletes and renames run after updates so changes to renamed files apply
+	// to the paths the model was shown
+	for _, op := range fileOps {
+		var result util.ChangeResult
+		if op.Op == util.FileOpRename {
+			result = util.ExecuteRename(op.Path, op.Dest)
 		} else {
-			// Ensure directory exists for new files
-			dir := filepath.Dir(update.FileName)
-			if err := os.MkdirAll(dir, 0755); err != nil {
-				return fmt.Errorf("failed to create directory %s: %w", dir, err)
-			}
+			result = util.ExecuteDelete(op.Path)
+		}
+		if result.Error != "" {
+			return fmt.Errorf("failed to %s %s: %s", op.Op, op.Path, result.Error)
+		}
+		util.Verbosef("%s", result.Stdout)
+	}
 
-			// Write to disk
-			err = os.WriteFile(update.FileName, []byte(newContent), 0644)
-			if err != nil {
-				return fmt.Errorf("failed to write %s: %w", update.FileName, err)
-			}
-			if args.Verbose {
-				fmt.Fprintf(os.Stderr, "Updated %s\n", update.FileName)
+	if overlay, ok := ws.(*workspace.Overlay); ok && dryRun {
+		for _, change := range overlay.Changes() {
+			if change.Deleted {
+				fmt.Printf("=== would delete %s ===\n", change.Path)
+			} else if _, ok := files[change.Path]; !ok {
+				fmt.Printf("=== would write %s ===\n", change.Path)
 			}
 		}
 	}
 
-	if !args.DryRun && len(updates) > 0 {
-		fmt.Fprintf(os.Stderr, "Successfully applied %d file updates\n", len(updates))
+	if !dryRun && len(updates) > 0 {
+		util.Infof("Successfully applied %d file updates", len(updates))
+	}
+	if !dryRun && len(fileOps) > 0 {
+		util.Infof("Successfully applied %d file deletes and renames", len(fileOps))
 	}
 
 	return nil
@@ -562,23 +580,26 @@ func arch() {
 	var args archArgs
 	arg.MustParse(&args)
 
-	// Validate model
-	if !supportedModels[args.Model] {
-		fmt.Fprintf(os.Stderr, "Error: unsupported model: %s\n\n", args.Model)
-		fmt.Fprintf(os.Stderr, "Supported models:\n")
-		models := make([]string, 0, len(supportedModels))
-		for model := range supportedModels {
-			models = append(models, model)
-		}
-		sort.Strings(models)
-		for _, model := range models {
-			fmt.Fprintf(os.Stderr, "  - %s\n", model)
-		}
-		os.Exit(1)
+	if args.Verbose {
+		util.SetLogLevel(max(util.GetLogLevel(), util.LogVerbose))
+	}
+	if args.Converter != "" {
+		_ = os.Setenv("NINA_CONVERTER_MODEL", args.Converter)
+	}
+	util.AllowPaths(args.AllowPath)
+	util.ProtectPaths(args.Protect)
+	if err := workspace.Use(args.Exec); err != nil {
+		lib.Fatal(err)
+	}
+
+	// Validate model and apply reasoning overrides
+	model, err := models.SetReasoning(args.Model, args.Effort, args.Budget)
+	if err != nil {
+		lib.Fatal(err)
 	}
+	util.Verbosef("model %s (%s) effort=%q thinking_budget=%d", model.Alias, model.APIModel, model.Effort, model.ThinkingBudget)
 
 	if err := run(args); err != nil {
-		fmt.Fprintln(os.Stderr, "error:", err)
-		os.Exit(1)
+		lib.Fatal(err)
 	}
 }
@@ -0,0 +1,147 @@
+package arch
+
+// patch output for arch --output patch. the response is applied to an
+// in-memory overlay and its changes are printed as a patch for git apply,
+// paths relative to the git root, so the working tree is never touched.
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	util "github.com/nathants/nina/util"
+	"github.com/nathants/nina/workspace"
+)
+
+// finishResponse applies a response to the files, or with --output patch
+// prints or writes the patch of its changes instead
+func finishResponse(ctx context.Context, args archArgs, response string, files map[string]string) error {
+	if args.Output != "patch" {
+		return applyResponse(ctx, response, files, args.DryRun)
+	}
+	base := workspace.Current()
+	overlay := workspace.NewOverlay(base)
+	workspace.SetCurrent(overlay)
+	err := applyResponse(ctx, response, files, false)
+	workspace.SetCurrent(base)
+	if err != nil {
+		return err
+	}
+	root := util.GetGitRoot()
+	if root == "" {
+		if root, err = os.Getwd(); err != nil {
+			return err
+		}
+	}
+	patch, err := formatPatch(base, root, overlay.Changes())
+	if err != nil {
+		return err
+	}
+	if args.PatchFile != "" {
+		if err := os.WriteFile(args.PatchFile, []byte(patch), 0644); err != nil {
+			return fmt.Errorf("writing patch: %w", err)
+		}
+		util.Infof("wrote the patch of %d files to %s", len(overlay.Changes()), args.PatchFile)
+		return nil
+	}
+	_, err = os.Stdout.WriteString(patch)
+	return err
+}
+
+// formatPatch returns the changes to ws as one patch for git apply, with
+// paths relative to root
+func formatPatch(ws workspace.Workspace, root string, changes []workspace.Change) (string, error) {
+	tmp, err := os.MkdirTemp("", "nina-patch-")
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = os.RemoveAll(tmp) }()
+
+	// git reports the root with symlinks resolved
+	if resolved, err := filepath.EvalSymlinks(root); err == nil {
+		root = resolved
+	}
+	var b strings.Builder
+	for _, change := range changes {
+		path := change.Path
+		if dir, err := filepath.EvalSymlinks(filepath.Dir(path)); err == nil {
+			path = filepath.Join(dir, filepath.Base(path))
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+			return "", fmt.Errorf("%s is outside %s, it can not be in the patch", change.Path, root)
+		}
+		rel = filepath.ToSlash(rel)
+
+		before, after := "/dev/null", "/dev/null"
+		if data, err := ws.ReadFile(change.Path); err == nil {
+			perm := workspace.FileMode(ws, change.Path, 0644)
+			before = filepath.Join(tmp, "before")
+			if err := os.WriteFile(before, data, perm); err != nil {
+				return "", err
+			}
+			if err := os.Chmod(before, perm); err != nil {
+				return "", err
+			}
+		}
+		if !change.Deleted {
+			perm := change.Perm
+			if perm == 0 {
+				perm = 0644
+			}
+			after = filepath.Join(tmp, "after")
+			if err := os.WriteFile(after, change.Data, perm); err != nil {
+				return "", err
+			}
+			if err := os.Chmod(after, perm); err != nil {
+				return "", err
+			}
+		}
+		diff, err := gitDiff(before, after)
+		_ = os.Remove(filepath.Join(tmp, "before"))
+		_ = os.Remove(filepath.Join(tmp, "after"))
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(renameDiff(diff, before, after, rel))
+	}
+	return b.String(), nil
+}
+
+// gitDiff returns the diff of two files, either of which may be /dev/null,
+// binary files included so the patch applies
+func gitDiff(before, after string) (string, error) {
+	cmd := exec.Command("git", "diff", "--no-index", "--no-color", "--no-ext-diff", "--binary", "--", before, after)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	var exitErr *exec.ExitError
+	// git diff exits 1 when the files differ
+	if err != nil && !(errors.As(err, &exitErr) && exitErr.ExitCode() == 1) {
+		return "", fmt.Errorf("git diff: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return string(out), nil
+}
+
+// renameDiff replaces the temp file paths in the header of a diff with rel
+func renameDiff(diff, before, after, rel string) string {
+	lines := strings.SplitAfter(diff, "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, "@@") || strings.HasPrefix(line, "GIT binary patch") || strings.HasPrefix(line, "Binary files") {
+			break
+		}
+		for _, path := range []string{before, after} {
+			if path != "/dev/null" {
+				line = strings.ReplaceAll(line, "a"+path, "a/"+rel)
+				line = strings.ReplaceAll(line, "b"+path, "b/"+rel)
+			}
+		}
+		lines[i] = line
+	}
+	return strings.Join(lines, "")
+}
@@ -0,0 +1,63 @@
+package arch
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nathants/nina/workspace"
+)
+
+func TestFormatPatch(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir := t.TempDir()
+	t.Chdir(dir)
+	if out, err := exec.Command("git", "init", "-q").CombinedOutput(); err != nil {
+		t.Fatalf("git init: %v: %s", err, out)
+	}
+	for name, content := range map[string]string{"a.txt": "one\ntwo\n", "gone.txt": "x\n", "tail.txt": "no newline"} {
+		if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	changes := []workspace.Change{
+		{Path: filepath.Join(dir, "a.txt"), Data: []byte("one\nTWO\n"), Perm: 0644},
+		{Path: filepath.Join(dir, "gone.txt"), Deleted: true},
+		{Path: filepath.Join(dir, "new", "b.txt"), Data: []byte("b\n")},
+		{Path: filepath.Join(dir, "tail.txt"), Data: []byte("no newline, still"), Perm: 0644},
+	}
+	patch, err := formatPatch(workspace.Current(), dir, changes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"--- a/a.txt\n+++ b/a.txt\n", "+++ /dev/null\n", "--- /dev/null\n+++ b/new/b.txt\n"} {
+		if !strings.Contains(patch, want) {
+			t.Errorf("missing %q in\n%s", want, patch)
+		}
+	}
+	if strings.Contains(patch, os.TempDir()) {
+		t.Errorf("temp paths in\n%s", patch)
+	}
+
+	// the files are untouched until the patch is applied
+	if data, _ := os.ReadFile("a.txt"); string(data) != "one\ntwo\n" {
+		t.Errorf("a.txt changed to %q", data)
+	}
+	cmd := exec.Command("git", "apply")
+	cmd.Stdin = strings.NewReader(patch)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git apply: %v: %s\n%s", err, out, patch)
+	}
+	for name, want := range map[string]string{"a.txt": "one\nTWO\n", "new/b.txt": "b\n", "tail.txt": "no newline, still"} {
+		if data, _ := os.ReadFile(name); string(data) != want {
+			t.Errorf("%s is %q, want %q", name, data, want)
+		}
+	}
+	if _, err := os.Stat("gone.txt"); !os.IsNotExist(err) {
+		t.Error("gone.txt was not deleted")
+	}
+}
@@ -0,0 +1,272 @@
+// batch mode for ask submits many prompts from a jsonl file in one provider
+// batch and writes one jsonl response per prompt, recording failures inline
+package ask
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/nathants/nina/lib"
+	"github.com/nathants/nina/providers/claude"
+	"github.com/nathants/nina/providers/openai"
+	"github.com/nathants/nina/util"
+)
+
+// batchPrompt is one line of the --batch input file
+type batchPrompt struct {
+	ID     string `json:"id"`
+	Prompt string `json:"prompt"`
+}
+
+// batchResult is one line of the --output file
+type batchResult struct {
+	ID       string `json:"id"`
+	Response string `json:"response,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// batchID matches the ids a batch accepts as custom_id, one bad id fails the
+// whole batch at submit time
+var batchID = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// readBatchPrompts parses a jsonl file of {"id": ..., "prompt": ...} objects.
+// Blank lines are skipped and missing ids default to the line number. Ids
+// must match batchID and be unique.
+func readBatchPrompts(path string) ([]batchPrompt, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	var prompts []batchPrompt
+	seen := map[string]bool{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var p batchPrompt
+		if err := json.Unmarshal([]byte(line), &p); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, lineNum, err)
+		}
+		if strings.TrimSpace(p.Prompt) == "" {
+			return nil, fmt.Errorf("%s:%d: empty prompt", path, lineNum)
+		}
+		if p.ID == "" {
+			p.ID = strconv.Itoa(lineNum)
+		}
+		if !batchID.MatchString(p.ID) {
+			return nil, fmt.Errorf("%s:%d: invalid id %q, ids are 1 to 64 letters, digits, _ or -", path, lineNum, p.ID)
+		}
+		if seen[p.ID] {
+			return nil, fmt.Errorf("%s:%d: duplicate id: %s", path, lineNum, p.ID)
+		}
+		seen[p.ID] = true
+		prompts = append(prompts, p)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(prompts) == 0 {
+		return nil, fmt.Errorf("no prompts in %s", path)
+	}
+	return prompts, nil
+}
+
+// runBatch submits all prompts in one provider batch and writes results in
+// input order. Prompts that fail or are missing from the batch output are
+// written with an error instead of aborting the whole run. A non-empty
+// resumeID skips submission and resumes polling an existing batch.
+func runBatch(model, systemPrompt, inputPath, outputPath, resumeID string) error {
+	prov, modelID := parseModel(model)
+	if prov != "claude" && prov != "openai" {
+		return fmt.Errorf("--batch requires a claude or openai model, got: %s", model)
+	}
+
+	prompts, err := readBatchPrompts(inputPath)
+	if err != nil {
+		return err
+	}
+
+	if outputPath == "" {
+		outputPath = strings.TrimSuffix(inputPath, ".jsonl") + ".output.jsonl"
+	}
+
+	if resumeID == "" {
+		util.Infof("submitting %d prompts to %s batch", len(prompts), prov)
+	} else {
+		util.Infof("resuming %s batch %s", prov, resumeID)
+	}
+
+	// Ctrl-C stops polling but leaves the batch running for --resume
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var results map[string]batchResult
+	var batchID string
+	switch prov {
+	case "claude":
+		batchID, results, err = runClaudeBatch(ctx, modelID, systemPrompt, prompts, resumeID)
+	case "openai":
+		batchID, results, err = runOpenAIBatch(ctx, modelID, systemPrompt, prompts, resumeID)
+	}
+	if errors.Is(err, context.Canceled) && batchID != "" {
+		return fmt.Errorf("interrupted, batch %s is still running, resume with: nina ask -m %s --batch %s --resume %s", batchID, model, inputPath, batchID)
+	}
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	w := bufio.NewWriter(f)
+	failed := 0
+	for _, p := range prompts {
+		result, ok := results[p.ID]
+		if !ok {
+			result = batchResult{ID: p.ID, Error: "no result returned by batch"}
+		}
+		if result.Error != "" {
+			failed++
+		}
+		data, err := json.Marshal(result)
+		if err != nil {
+			return err
+		}
+		_, _ = w.Write(append(data, '\n'))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	util.Infof("wrote %d results to %s (%d succeeded, %d failed)", len(prompts), outputPath, len(prompts)-failed, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d prompts failed", failed, len(prompts))
+	}
+	return nil
+}
+
+func runClaudeBatch(ctx context.Context, modelID, systemPrompt string, prompts []batchPrompt, resumeID string) (string, map[string]batchResult, error) {
+	var items []claude.BatchRequestItem
+	for _, p := range prompts {
+		items = append(items, buildClaudeBatchItem(p.ID, modelID, systemPrompt, p.Prompt))
+	}
+	batchID := resumeID
+	if batchID == "" {
+		created, err := claude.CreateBatch(ctx, items)
+		if err != nil {
+			return "", nil, err
+		}
+		batchID = created.ID
+	}
+	batchResp, err := claude.WaitBatch(ctx, batchID)
+	if err != nil {
+		return batchID, nil, err
+	}
+	responses, err := claude.FetchBatchResults(ctx, batchResp)
+	if err != nil {
+		return batchID, nil, err
+	}
+	results := map[string]batchResult{}
+	for _, r := range responses {
+		result := batchResult{ID: r.CustomID}
+		switch {
+		case r.Result.Error != nil:
+			result.Error = fmt.Sprintf("%v", r.Result.Error)
+		case r.Result.Message == nil:
+			result.Error = fmt.Sprintf("no message, result type: %s", r.Result.Type)
+		default:
+			lib.RecordUsage(items[0].Params.Model, lib.ClaudeTokenUsage(r.Result.Message.Usage), true)
+			var parts []string
+			for _, blk := range r.Result.Message.Content {
+				if blk.Type == "text" {
+					parts = append(parts, blk.Text)
+				}
+			}
+			result.Response = strings.Join(parts, "\n")
+		}
+		results[r.CustomID] = result
+	}
+	return batchID, results, nil
+}
+
+func runOpenAIBatch(ctx context.Context, modelID, systemPrompt string, prompts []batchPrompt, resumeID string) (string, map[string]batchResult, error) {
+	var items []openai.BatchRequestItem
+	for _, p := range prompts {
+		req := buildOpenAIRequest(modelID, systemPrompt, p.Prompt, false)
+		req.ServiceTier = "" // batch pricing replaces the flex tier
+		req.Background = false
+		items = append(items, openai.BatchRequestItem{CustomID: p.ID, Params: req})
+	}
+	batchID := resumeID
+	if batchID == "" {
+		created, err := openai.CreateBatch(ctx, items)
+		if err != nil {
+			return "", nil, err
+		}
+		batchID = created.ID
+	}
+	batchResp, err := openai.WaitBatch(ctx, batchID)
+	if err != nil {
+		return batchID, nil, err
+	}
+	responses, err := openai.FetchBatchResults(ctx, batchResp)
+	if err != nil {
+		return batchID, nil, err
+	}
+	results := map[string]batchResult{}
+	for _, r := range responses {
+		result := batchResult{ID: r.CustomID}
+		if r.Error != nil {
+			result.Error = fmt.Sprintf("%v", r.Error)
+			results[r.CustomID] = result
+			continue
+		}
+		if code, ok := r.Response["status_code"].(float64); ok && code != 200 {
+			result.Error = fmt.Sprintf("status %d: %v", int(code), r.Response["body"])
+			results[r.CustomID] = result
+			continue
+		}
+		data, err := json.Marshal(r.Response["body"])
+		if err != nil {
+			result.Error = err.Error()
+			results[r.CustomID] = result
+			continue
+		}
+		var resp openai.Response
+		if err := json.Unmarshal(data, &resp); err != nil {
+			result.Error = err.Error()
+			results[r.CustomID] = result
+			continue
+		}
+		lib.RecordUsage(items[0].Params.Model, lib.OpenAITokenUsage(&resp.Usage), true)
+		for _, output := range resp.Output {
+			if output.Type == "message" && len(output.Content) > 0 {
+				result.Response = output.Content[0].Text
+				break
+			}
+		}
+		if result.Response == "" {
+			result.Error = "no message output"
+		}
+		results[r.CustomID] = result
+	}
+	return batchID, results, nil
+}
@@ -6,15 +6,18 @@ package ask
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"regexp"
 	"strings"
 	"time"
 
 	"github.com/nathants/nina/lib"
+	"github.com/nathants/nina/models"
 	"github.com/nathants/nina/prompts"
 	"github.com/nathants/nina/providers/claude"
 	"github.com/nathants/nina/providers/gemini"
@@ -22,6 +25,7 @@ import (
 	"github.com/nathants/nina/providers/groq"
 	"github.com/nathants/nina/providers/oauth"
 	"github.com/nathants/nina/providers/openai"
+	"github.com/nathants/nina/util"
 
 	"github.com/alexflint/go-arg"
 )
@@ -39,56 +43,53 @@ type askArgs struct {
 	Search   bool   `arg:"-s,--search" help:"Enable web search capabilities"`
 	Debug    bool   `arg:"-d,--debug" help:"Enable debug output (show JSON)"`
 	NoOAuth  bool   `arg:"-n,--no-oauth" help:"Don't use OAuth token if available"`
+	Batch    string `arg:"-b,--batch" help:"Submit prompts from a jsonl file of {\"id\", \"prompt\"} objects via the provider batch api"`
+	Output   string `arg:"-o,--output" help:"Output jsonl file for --batch (default: <batch>.output.jsonl)"`
+	Resume   string `arg:"--resume" help:"Resume polling an existing batch id for --batch instead of submitting"`
+	Effort   string `arg:"--effort" help:"Reasoning effort for openai reasoning models: low, medium, high"`
+	Budget   int    `arg:"--thinking-budget" help:"Thinking token budget for claude and gemini models"`
+
+	Temperature *float64 `arg:"--temperature" help:"Sampling temperature, for models without reasoning settings"`
+	TopP        *float64 `arg:"--top-p" help:"Nucleus sampling probability, for models without reasoning settings"`
+	MaxOutput   int      `arg:"--max-output" help:"Maximum output tokens, including any thinking"`
+
+	System       string `arg:"--system" help:"Replace the system prompt"`
+	SystemFile   string `arg:"--system-file" help:"Replace the system prompt with the contents of a file"`
+	AppendSystem string `arg:"--append-system" help:"Append text to the system prompt"`
+
+	Prompt   []string `arg:"positional" help:"Prompt text, stdin is appended in a fenced block when given"`
+	Template string   `arg:"-p,--prompt" help:"Prompt template from 'nina prompt list', stdin is appended when given"`
+	Vars     []string `arg:"--var,separate" help:"Prompt template variable as key=value"`
+	Files    []string `arg:"-f,--file,separate" help:"Attach a file or glob to the prompt, repeatable"`
+	NoCache  bool     `arg:"--no-cache" help:"Always call the model instead of reusing a cached identical response"`
 }
 
 func (askArgs) Description() string {
 	return `ask - Simple one-shot AI interface
 
-Reads prompt from stdin.
-
-Supported models (short names):
-  - o3
-  - o3-flex
-  - o3-pro
-  - opus
-  - opus-batch
-  - sonnet
-  - sonnet-batch
-  - o4-mini
-  - o4-mini-flex
-  - gemini
-  - flash
-  - 4.1
-  - 4.1-mini
-  - v0-md
-  - v0-lg
-  - ollama
-  - grok
-
-Note: Models with -flex suffix use OpenAI's flexible service tier.
-Long names also supported for backward compatibility.`
-}
+Reads the prompt from arguments and/or stdin. With both, stdin is
+appended to the arguments in a fenced block:
+
+  git diff | nina ask summarize this diff
+
+Attach files with -f, each is sent in NinaFile tags after the prompt:
+
+  nina ask -f 'lib/*.go' -f README.md where is the config loaded
+
+With --batch, reads many prompts from a jsonl file of {"id",
+"prompt"} lines, submits them in one claude or openai batch, and
+writes {"id", "response", "error"} lines to the output jsonl. Ids
+are unique, 1 to 64 letters, digits, _ or -, and default to the
+line number. If interrupted, the batch keeps running and --resume
+<id> picks it up again.
 
-var supportedModels = map[string]bool{
-	// Short names only
-	"o3":           true,
-	"o3-flex":      true,
-	"o3-pro":       true,
-	"opus":         true,
-	"opus-batch":   true,
-	"sonnet":       true,
-	"sonnet-batch": true,
-	"o4-mini":      true,
-	"o4-mini-flex": true,
-	"gemini":       true,
-	"flash":        true,
-	"4.1":          true,
-	"4.1-mini":     true,
-	"v0-md":        true,
-	"v0-lg":        true,
-	"ollama":       true,
-	"grok":         true,
-	"k2":           true,
+Identical requests within NINA_CACHE_TTL (default 24h) reuse the
+response cached in ~/.cache/nina, use --no-cache to call the model.
+
+Supported models: ` + strings.Join(models.Aliases(), ", ") + `
+Run 'nina models list' for providers and settings.
+
+Note: Models with -flex suffix use OpenAI's flexible service tier.`
 }
 
 func ask() {
@@ -98,37 +99,80 @@ func ask() {
 	// Initialize session for timestamp
 	lib.InitializeSession(false)
 
-	if !supportedModels[args.Model] {
-		fmt.Fprintf(os.Stderr, "Error: unsupported model: %s\n\n", args.Model)
-		fmt.Fprintf(os.Stderr, "Supported models:\n")
-		for model := range supportedModels {
-			fmt.Fprintf(os.Stderr, "  - %s\n", model)
+	model, err := askModel(args, args.Model)
+	if err != nil {
+		lib.Fatal(err)
+	}
+
+	override, err := prompts.NewOverride(args.System, args.SystemFile, args.AppendSystem)
+	if err != nil {
+		lib.Fatal(err)
+	}
+	systemPrompt := buildSystemPrompt(override)
+
+	if args.Batch != "" {
+		err := runBatch(args.Model, systemPrompt, args.Batch, args.Output, args.Resume)
+		if err != nil {
+			lib.Fatal(err)
+		}
+		return
+	}
+
+	var template string
+	if args.Template != "" {
+		vars, err := prompts.ParseVars(args.Vars)
+		if err == nil {
+			template, err = prompts.RenderTemplate(args.Template, vars)
+		}
+		if err != nil {
+			lib.Fatal(err)
 		}
-		os.Exit(1)
 	}
 
-	// Read prompt from stdin
+	// Read stdin, optional when the prompt comes from arguments or a template
+	var stdinBuilder strings.Builder
 	reader := bufio.NewReader(os.Stdin)
-	var promptBuilder strings.Builder
-	for {
+	for (len(args.Prompt) == 0 && args.Template == "") || !util.IsTerminal(os.Stdin) {
 		line, err := reader.ReadString('\n')
 		if err != nil {
 			if err == io.EOF {
-				promptBuilder.WriteString(line)
+				stdinBuilder.WriteString(line)
 				break
 			}
 			fmt.Fprintf(os.Stderr, "Error reading stdin: %v\n", err)
 			os.Exit(1)
 		}
-		promptBuilder.WriteString(line)
+		stdinBuilder.WriteString(line)
 	}
 
-	prompt := strings.TrimSpace(promptBuilder.String())
+	prompt := composePrompt(template, strings.Join(args.Prompt, " "), stdinBuilder.String())
 	if prompt == "" {
-		fmt.Fprintf(os.Stderr, "Error: no prompt provided via stdin\n")
+		fmt.Fprintf(os.Stderr, "Error: no prompt provided via arguments or stdin\n")
 		os.Exit(1)
 	}
 
+	var attachments []attachment
+	if len(args.Files) > 0 {
+		if attachments, err = attachFiles(args.Files); err != nil {
+			lib.Fatal(err)
+		}
+	}
+	if long := lib.FitModel(model, 0, systemPrompt, withAttachments(prompt, attachments)); long.Alias != model.Alias {
+		util.Infof("prompt exceeds the %s context window, using %s", model.Alias, long.Alias)
+		if model, err = askModel(args, long.Alias); err != nil {
+			lib.Fatal(err)
+		}
+		args.Model = model.Alias
+	}
+	if len(args.Files) > 0 {
+		attachments = fitAttachments(model, systemPrompt, prompt, attachments)
+		prompt = withAttachments(prompt, attachments)
+		util.Infof("attached %d files, %s tokens", len(attachments), lib.FormatTokens(lib.CountTokens(model, systemPrompt, prompt)))
+	}
+	if _, err := lib.CheckContextWindow(model, 0, systemPrompt, prompt); err != nil {
+		lib.Fatal(err)
+	}
+
 	// Generate timestamp for both input and output files
 	timestamp := time.Now().Format("2006-01-02T15:04:05")
 
@@ -140,7 +184,7 @@ func ask() {
 	// Create agents/ask directory with timestamp subdirectory
 	sessionTimestamp := lib.GetSessionTimestamp()
 	agentsDir := fmt.Sprintf("%s/agents/ask/%s", gitRoot, sessionTimestamp)
-	err := os.MkdirAll(agentsDir, 0755)
+	err = os.MkdirAll(agentsDir, 0755)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error creating agents/ask directory: %v\n", err)
 		// Fall back to /tmp with timestamp
@@ -151,20 +195,38 @@ func ask() {
 
 	// Save input prompt to file
 	inputPath := fmt.Sprintf("%s/%s.input", agentsDir, baseFilename)
-	err = os.WriteFile(inputPath, []byte(prompt), 0644)
+	err = util.WriteLog(inputPath, []byte(prompt))
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error saving input: %v\n", err)
 		os.Exit(1)
 	}
-
-	err = runAsk(args.Model, prompt, !args.NoStream, !args.NoOAuth, args.Search, args.Debug, agentsDir, baseFilename)
+	err = lib.WriteModelSettings(fmt.Sprintf("%s/%s.model.json", agentsDir, baseFilename), model)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Error saving model settings: %v\n", err)
 		os.Exit(1)
 	}
+
+	err = runAsk(args.Model, systemPrompt, prompt, !args.NoStream, !args.NoOAuth, args.Search, args.Debug, !args.NoCache, agentsDir, baseFilename)
+	if err != nil {
+		lib.Fatal(err)
+	}
 }
 
-func runAsk(model, prompt string, stream bool, useOAuth bool, search bool, debug bool, agentsDir, baseFilename string) error {
+// askModel returns the model for alias with the reasoning and sampling
+// settings of args
+func askModel(args askArgs, alias string) (models.Model, error) {
+	model, err := models.SetReasoning(alias, args.Effort, args.Budget)
+	if err == nil {
+		model, err = model.WithSampling(args.Temperature, args.TopP, args.MaxOutput)
+	}
+	if err != nil {
+		return model, err
+	}
+	models.Override(model)
+	return model, nil
+}
+
+func runAsk(model, systemPrompt, prompt string, stream bool, useOAuth bool, search bool, debug bool, cache bool, agentsDir, baseFilename string) error {
 	// Set debug environment variable for providers
 	if debug {
 		_ = os.Setenv("DEBUG", "true")
@@ -175,14 +237,29 @@ func runAsk(model, prompt string, stream bool, useOAuth bool, search bool, debug
 	var response string
 	var err error
 
-	response, err = callProvider(provider, modelID, prompt, stream, useOAuth, search)
-	if err != nil {
-		return err
+	// Identical requests are served from the response cache
+	cacheKey := ""
+	if cache && !search && lib.CacheEnabled() {
+		m, _ := models.ByID(modelID)
+		cacheKey = lib.ResponseCacheKey(m, systemPrompt, prompt)
+	}
+	cached := false
+	if cacheKey != "" {
+		response, cached = lib.CachedResponse(cacheKey)
+	}
+	if !cached {
+		response, err = callProvider(provider, modelID, systemPrompt, prompt, stream, useOAuth, search)
+		if err != nil {
+			return err
+		}
+		if cacheKey != "" {
+			lib.StoreResponse(cacheKey, response)
+		}
 	}
 
 	// Save output response to file (only created if API call succeeds)
 	outputPath := fmt.Sprintf("%s/%s.output", agentsDir, baseFilename)
-	err = os.WriteFile(outputPath, []byte(response), 0644)
+	err = util.WriteLog(outputPath, []byte(response))
 	if err != nil {
 		return fmt.Errorf("failed to save output: %v", err)
 	}
@@ -195,52 +272,117 @@ func runAsk(model, prompt string, stream bool, useOAuth bool, search bool, debug
 	return nil
 }
 
-func parseModel(model string) (provider, modelID string) {
-	switch model {
-	case "o3":
-		return "openai", "o3-high"
-	case "o3-flex":
-		return "openai", "o3-flex"
-	case "o3-pro":
-		return "openai", "o3-pro"
-	case "opus":
-		return "claude", "claude-4-opus-24k-thinking"
-	case "opus-batch":
-		return "claude", "claude-4-opus-batch-24k-thinking"
-	case "sonnet":
-		return "claude", "claude-4-sonnet-24k-thinking"
-	case "sonnet-batch":
-		return "claude", "claude-4-sonnet-batch-24k-thinking"
-	case "o4-mini":
-		return "openai", "o4-mini-medium"
-	case "o4-mini-flex":
-		return "openai", "o4-mini-flex"
-	case "gemini":
-		return "gemini", "gemini-2.5-pro-32k-thinking"
-	case "flash":
-		return "gemini", "gemini-2.5-flash-24k-thinking"
-	case "4.1":
-		return "openai", "gpt-4.1-0.5-temp"
-	case "4.1-mini":
-		return "openai", "gpt-4.1-mini-0.5-temp"
-	case "v0-md":
-		return "v0", "v0-1.5-md"
-	case "v0-lg":
-		return "v0", "v0-1.5-lg"
-	case "ollama":
-		return "ollama", "ollama"
-	case "grok":
-		return "grok", "grok-4-0709"
-	case "k2":
-		return "groq", "moonshotai/kimi-k2-instruct"
-	default:
-		panic("unknown model: " + model)
+// composePrompt joins the rendered template, the argument text, and stdin.
+// Stdin follows the argument text in a fenced block, otherwise it is used as is.
+func composePrompt(template, text, stdin string) string {
+	var parts []string
+	for _, part := range []string{template, text} {
+		if part = strings.TrimSpace(part); part != "" {
+			parts = append(parts, part)
+		}
+	}
+	if stdin = strings.TrimRight(stdin, "\n"); strings.TrimSpace(stdin) != "" {
+		if strings.TrimSpace(text) != "" {
+			fence := "```"
+			for strings.Contains(stdin, fence) {
+				fence += "`"
+			}
+			stdin = fence + "\n" + stdin + "\n" + fence
+		} else {
+			stdin = strings.TrimSpace(stdin)
+		}
+		parts = append(parts, stdin)
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// attachment is a file attached to the prompt as a NinaFile block
+type attachment struct {
+	Path  string
+	Block string
+}
+
+// attachFiles reads files and globs into NinaFile blocks like arch, skipping
+// binary and oversized files
+func attachFiles(patterns []string) ([]attachment, error) {
+	var attachments []attachment
+	seen := map[string]bool{}
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %s: %w", pattern, err)
+		}
+		if len(matches) == 0 {
+			matches = []string{pattern}
+		}
+		for _, path := range matches {
+			absPath, err := filepath.Abs(path)
+			if err != nil {
+				return nil, err
+			}
+			if seen[absPath] {
+				continue
+			}
+			seen[absPath] = true
+			if err := util.CheckFile(path); err != nil {
+				if errors.Is(err, util.ErrBinaryFile) || errors.Is(err, util.ErrFileTooLarge) {
+					util.Errorf("skipping %v", err)
+					continue
+				}
+				return nil, err
+			}
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			attachments = append(attachments, attachment{Path: absPath, Block: util.FormatNinaFile(absPath, string(content))})
+		}
 	}
+	return attachments, nil
 }
 
-func callProvider(prov, modelID, message string, stream bool, useOAuth bool, search bool) (string, error) {
+// withAttachments appends the attachments' NinaFile blocks to prompt
+func withAttachments(prompt string, attachments []attachment) string {
+	if len(attachments) == 0 {
+		return prompt
+	}
+	var builder strings.Builder
+	for _, a := range attachments {
+		builder.WriteString(a.Block)
+	}
+	return prompt + "\n\n" + strings.TrimRight(builder.String(), "\n")
+}
+
+// fitAttachments drops the largest attachments until the prompt fits the
+// model's context window
+func fitAttachments(model models.Model, system, prompt string, attachments []attachment) []attachment {
+	for len(attachments) > 0 {
+		tokens, err := lib.CheckContextWindow(model, 0, system, withAttachments(prompt, attachments))
+		if err == nil {
+			break
+		}
+		largest := 0
+		for i, a := range attachments {
+			if len(a.Block) > len(attachments[largest].Block) {
+				largest = i
+			}
+		}
+		util.Errorf("warning: dropping %s, %s tokens exceeds the %s input budget of %s",
+			attachments[largest].Path, lib.FormatTokens(tokens), lib.FormatTokens(lib.InputBudget(model)), model.Alias)
+		attachments = append(attachments[:largest:largest], attachments[largest+1:]...)
+	}
+	return attachments
+}
+
+// parseModel returns the provider and internal model id for a short name
+func parseModel(model string) (provider, modelID string) {
+	m := models.MustLookup(model)
+	return m.Provider, m.ID
+}
+
+func callProvider(prov, modelID, systemPrompt, message string, stream bool, useOAuth bool, search bool) (string, error) {
 	ctx := context.Background()
-	systemPrompt := buildSystemPrompt()
+	model, _ := models.ByID(modelID)
 
 	// Setup OAuth for Claude if requested
 	if useOAuth && prov == "claude" {
@@ -277,52 +419,14 @@ func callProvider(prov, modelID, message string, stream bool, useOAuth bool, sea
 		} else {
 			// For other providers, write reasoning to stderr with newlines
 			reasoningCallback = func(data string) {
-				fmt.Fprintln(os.Stderr, data)
+				util.Infof("%s", data)
 			}
 		}
 	}
 
 	switch prov {
 	case "openai":
-		req := openai.Request{
-			Model: convertOpenAIModelID(modelID),
-			Input: []openai.ChatMessage{
-				{
-					Type: "message",
-					Role: "system",
-					Content: []openai.ContentPart{
-						{Type: "input_text", Text: systemPrompt},
-					},
-				},
-				{
-					Type: "message",
-					Role: "user",
-					Content: []openai.ContentPart{
-						{Type: "input_text", Text: message},
-					},
-				},
-			},
-			Stream: stream,
-		}
-		if isFlexModel(modelID) {
-			req.ServiceTier = "flex"
-		}
-		if isO3Model(modelID) {
-			req.Reasoning = &openai.ReasoningRequest{
-				Summary: "auto",
-				Effort:  "high",
-			}
-		}
-		if isO4MiniModel(modelID) {
-			req.Reasoning = &openai.ReasoningRequest{
-				Summary: "auto",
-				Effort:  "medium",
-			}
-		}
-		if is41Model(modelID) {
-			temp := 0.5
-			req.Temperature = &temp
-		}
+		req := buildOpenAIRequest(modelID, systemPrompt, message, stream)
 		if search {
 			// Use standard tool format for all models
 			// req.Tools = []any{provider.CreateOpenAIExaTool()}
@@ -332,30 +436,16 @@ func callProvider(prov, modelID, message string, stream bool, useOAuth bool, sea
 		if err != nil {
 			return "", err
 		}
+		lib.RecordUsage(req.Model, lib.OpenAITokenUsage(handleResp.Usage), false)
 		// fmt.Fprintln(os.Stderr, "usage:", util.Format(handleResp.Usage))
 		return handleResp.Text, nil
 
 	case "claude":
 		// Check if this is a batch model
-		if strings.Contains(modelID, "batch") {
+		if model.Batch {
 			// Handle batch models using HandleClaudeBatch
-			messages := []claude.Message{
-				{Role: "user", Content: []claude.Text{{Type: "text", Text: message}}},
-			}
-			batchReq := claude.BatchRequestItem{
-				CustomID: "0",
-				Params: claude.BatchParams{
-					Model:     convertClaudeModelID(modelID),
-					System:    systemPrompt,
-					Messages:  messages,
-					MaxTokens: 32000,
-					Thinking: &claude.Thinking{
-						Type:         "enabled",
-						BudgetTokens: 24000,
-					},
-					UseOAuth: useOAuth,
-				},
-			}
+			batchReq := buildClaudeBatchItem("0", modelID, systemPrompt, message)
+			batchReq.Params.UseOAuth = useOAuth
 			results, err := claude.HandleBatch(ctx, []claude.BatchRequestItem{batchReq})
 			if err != nil {
 				return "", err
@@ -370,6 +460,7 @@ func callProvider(prov, modelID, message string, stream bool, useOAuth bool, sea
 			if first.Result.Message == nil {
 				return "", fmt.Errorf("claude batch no message")
 			}
+			lib.RecordUsage(batchReq.Params.Model, lib.ClaudeTokenUsage(first.Result.Message.Usage), true)
 			var sb strings.Builder
 			for i, blk := range first.Result.Message.Content {
 				if blk.Type != "text" {
@@ -396,7 +487,7 @@ func callProvider(prov, modelID, message string, stream bool, useOAuth bool, sea
 				},
 			}
 			req := claude.Request{
-				Model: convertClaudeModelID(modelID),
+				Model: model.APIModel,
 				System: []claude.Text{
 					{
 						Type:  "text",
@@ -404,12 +495,17 @@ func callProvider(prov, modelID, message string, stream bool, useOAuth bool, sea
 						Cache: &claude.CacheControl{Type: "ephemeral"},
 					},
 				},
-				Messages:  messages,
-				MaxTokens: 32000,
-				Thinking: &claude.Thinking{
+				Messages:    messages,
+				MaxTokens:   model.MaxOutput,
+				Temperature: model.Temperature,
+				TopP:        model.TopP,
+				Betas:       model.Betas,
+			}
+			if model.ThinkingBudget > 0 {
+				req.Thinking = &claude.Thinking{
 					Type:         "enabled",
-					BudgetTokens: 24000,
-				},
+					BudgetTokens: model.ThinkingBudget,
+				}
 			}
 			if search {
 				panic("search disabled for now")
@@ -420,26 +516,31 @@ func callProvider(prov, modelID, message string, stream bool, useOAuth bool, sea
 			if err != nil {
 				return "", err
 			}
+			lib.RecordUsage(req.Model, lib.ClaudeTokenUsage(handleResp.Usage), false)
 			// fmt.Fprintln(os.Stderr, "usage:", util.Format(handleResp.Usage))
 			return handleResp.Text, nil
 		}
 
 	case "gemini":
-		// Gemini expects messages as a slice of strings
-		messages := []string{message}
-		thinkingBudget := 0
-		if modelID == "gemini-2.5-pro-32k-thinking" {
-			thinkingBudget = 32000
-		} else if modelID == "gemini-2.5-flash-24k-thinking" {
-			thinkingBudget = 24000
-		}
-
 		if search {
 			// Note: Gemini doesn't support streaming with our simulated web search approach
-			// return provider.HandleGeminiChatWithSearch(ctx, convertGeminiModelID(modelID), systemPrompt, messages, nil, reasoningCallback, false, thinkingBudget, true)
+			// return provider.HandleGeminiChatWithSearch(ctx, model.APIModel, systemPrompt, messages, nil, reasoningCallback, false, thinkingBudget, true)
 			panic("search disabled for now")
 		}
-		return gemini.Handle(ctx, convertGeminiModelID(modelID), systemPrompt, messages, nil, reasoningCallback, false, thinkingBudget)
+		handleResp, err := gemini.Handle(ctx, gemini.Request{
+			Model:          model.APIModel,
+			System:         systemPrompt,
+			Contents:       []gemini.Content{gemini.UserText(message)},
+			Temperature:    model.Temperature,
+			TopP:           model.TopP,
+			MaxOutput:      model.MaxOutput,
+			ThinkingBudget: model.ThinkingBudget,
+		}, reasoningCallback)
+		if err != nil {
+			return "", err
+		}
+		lib.RecordUsage(model.APIModel, lib.GeminiTokenUsage(handleResp.Usage), false)
+		return handleResp.Text, nil
 
 	case "grok":
 		messages := []grok.Message{
@@ -453,12 +554,25 @@ func callProvider(prov, modelID, message string, stream bool, useOAuth bool, sea
 			},
 		}
 		req := grok.Request{
-			Model:       modelID,
-			Messages:    messages,
-			Stream:      false,
-			Temperature: 0,
+			Model:    model.APIModel,
+			Messages: messages,
+			Stream:   stream,
+			TopP:     model.TopP,
+		}
+		if model.Temperature != nil {
+			req.Temperature = *model.Temperature
+		}
+		if model.MaxOutput > 0 {
+			req.MaxTokens = &model.MaxOutput
+		}
+		handleResp, err := grok.Handle(ctx, req, reasoningCallback)
+		if err != nil {
+			return "", err
+		}
+		if handleResp.Usage != nil {
+			lib.RecordUsage(req.Model, lib.GrokTokenUsage(handleResp.Usage), false)
 		}
-		return grok.Handle(ctx, req)
+		return handleResp.Text, nil
 
 	case "groq":
 		messages := []groq.Message{
@@ -471,90 +585,101 @@ func callProvider(prov, modelID, message string, stream bool, useOAuth bool, sea
 				Content: message,
 			},
 		}
-		temp := 0.6
 		req := groq.Request{
-			Model:       modelID,
+			Model:       model.APIModel,
 			Messages:    messages,
 			Stream:      stream,
-			Temperature: &temp,
+			Temperature: model.Temperature,
+			TopP:        model.TopP,
 		}
-		handleResp, err := groq.Handle(ctx, req)
+		if model.MaxOutput > 0 {
+			req.MaxTokens = &model.MaxOutput
+		}
+		handleResp, err := groq.Handle(ctx, req, reasoningCallback)
 		if err != nil {
 			return "", err
 		}
+		if handleResp.Usage != nil {
+			lib.RecordUsage(req.Model, lib.GroqTokenUsage(handleResp.Usage), false)
+		}
 		return handleResp.Text, nil
 
+	case "ollama":
+		return lib.OllamaChat(ctx, model, systemPrompt, message, reasoningCallback)
+
 	default:
 		return "", fmt.Errorf("unknown provider: %s", prov)
 	}
 }
 
-func buildSystemPrompt() string {
-	return prompts.Ask()
+// buildSystemPrompt returns the ask system prompt with override applied
+func buildSystemPrompt(override prompts.Override) string {
+	return override.Apply(prompts.Ask())
 }
 
-func convertOpenAIModelID(modelID string) string {
-	// Map internal model IDs to OpenAI model names
-	switch modelID {
-	case "o3-high":
-		return "o3"
-	case "o3-flex":
-		return "o3"
-	case "o3-pro":
-		return "o3-pro"
-	case "o4-mini-medium":
-		return "o4-mini"
-	case "o4-mini-flex":
-		return "o4-mini"
-	case "gpt-4.1-0.5-temp":
-		return "gpt-4.1"
-	case "gpt-4.1-mini-0.5-temp":
-		return "gpt-4.1-mini"
-	default:
-		panic("unknown: " + modelID)
+// buildOpenAIRequest applies the per model service tier, reasoning, and
+// temperature settings shared by interactive and batch requests
+func buildOpenAIRequest(modelID, systemPrompt, message string, stream bool) openai.Request {
+	model, _ := models.ByID(modelID)
+	req := openai.Request{
+		Model: model.APIModel,
+		Input: []openai.ChatMessage{
+			{
+				Type: "message",
+				Role: "system",
+				Content: []openai.ContentPart{
+					{Type: "input_text", Text: systemPrompt},
+				},
+			},
+			{
+				Type: "message",
+				Role: "user",
+				Content: []openai.ContentPart{
+					{Type: "input_text", Text: message},
+				},
+			},
+		},
+		Stream: stream,
 	}
-}
-
-func convertClaudeModelID(modelID string) string {
-	switch modelID {
-	case "claude-4-opus-24k-thinking":
-		return "claude-opus-4-20250514"
-	case "claude-4-opus-batch-24k-thinking":
-		return "claude-opus-4-20250514"
-	case "claude-4-sonnet-24k-thinking":
-		return "claude-sonnet-4-20250514"
-	case "claude-4-sonnet-batch-24k-thinking":
-		return "claude-sonnet-4-20250514"
-	default:
-		panic("unknown: " + modelID)
+	req.ServiceTier = model.ServiceTier
+	req.Background = model.Background
+	if model.Effort != "" {
+		req.Reasoning = &openai.ReasoningRequest{
+			Summary: "auto",
+			Effort:  model.Effort,
+		}
 	}
-}
-
-func convertGeminiModelID(modelID string) string {
-	switch modelID {
-	case "gemini-2.5-pro-32k-thinking":
-		return "gemini-2.5-pro"
-	case "gemini-2.5-flash-24k-thinking":
-		return "gemini-2.5-flash"
-	default:
-		panic("unknown: " + modelID)
+	req.Temperature = model.Temperature
+	req.TopP = model.TopP
+	if model.MaxOutput > 0 {
+		req.MaxOutputTokens = &model.MaxOutput
 	}
+	return req
 }
 
-func isO3Model(modelID string) bool {
-	return modelID == "o3-high" || modelID == "o3-flex" || modelID == "o3-pro"
-}
-
-func isO4MiniModel(modelID string) bool {
-	return modelID == "o4-mini-medium" || modelID == "o4-mini-flex"
-}
-
-func is41Model(modelID string) bool {
-	return modelID == "gpt-4.1-0.5-temp" || modelID == "gpt-4.1-mini-0.5-temp"
-}
-
-func isFlexModel(modelID string) bool {
-	return strings.HasSuffix(modelID, "-flex")
+// buildClaudeBatchItem creates a batch request item with thinking enabled
+func buildClaudeBatchItem(customID, modelID, systemPrompt, message string) claude.BatchRequestItem {
+	model, _ := models.ByID(modelID)
+	item := claude.BatchRequestItem{
+		CustomID: customID,
+		Params: claude.BatchParams{
+			Model:  model.APIModel,
+			System: systemPrompt,
+			Messages: []claude.Message{
+				{Role: "user", Content: []claude.Text{{Type: "text", Text: message}}},
+			},
+			MaxTokens:   model.MaxOutput,
+			Temperature: model.Temperature,
+			TopP:        model.TopP,
+		},
+	}
+	if model.ThinkingBudget > 0 {
+		item.Params.Thinking = &claude.Thinking{
+			Type:         "enabled",
+			BudgetTokens: model.ThinkingBudget,
+		}
+	}
+	return item
 }
 
 // Execute git rev-parse to find repository root, fallback to current directory
@@ -8,8 +8,12 @@ import (
 	"encoding/json"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 	"testing"
+
+	"github.com/nathants/nina/models"
+	"github.com/nathants/nina/util"
 )
 
 func TestWebSearchToolFormatting(t *testing.T) {
@@ -156,41 +160,45 @@ func TestModelParsing(t *testing.T) {
 	}
 }
 
-func TestToolHelperFunctions(t *testing.T) {
-	// Test isO3Model
-	if !isO3Model("o3-high") {
-		t.Error("isO3Model(\"o3-high\") should return true")
-	}
-	if !isO3Model("o3-flex") {
-		t.Error("isO3Model(\"o3-flex\") should return true")
-	}
-	if isO3Model("o4-mini-medium") {
-		t.Error("isO3Model(\"o4-mini-medium\") should return false")
-	}
-
-	// Test isO4MiniModel
-	if !isO4MiniModel("o4-mini-medium") {
-		t.Error("isO4MiniModel(\"o4-mini-medium\") should return true")
-	}
-	if !isO4MiniModel("o4-mini-flex") {
-		t.Error("isO4MiniModel(\"o4-mini-flex\") should return true")
-	}
-	if isO4MiniModel("o3-high") {
-		t.Error("isO4MiniModel(\"o3-high\") should return false")
-	}
-
-	// Test isFlexModel
-	if !isFlexModel("o3-flex") {
-		t.Error("isFlexModel(\"o3-flex\") should return true")
-	}
-	if !isFlexModel("o4-mini-flex") {
-		t.Error("isFlexModel(\"o4-mini-flex\") should return true")
-	}
-	if isFlexModel("o3-high") {
-		t.Error("isFlexModel(\"o3-high\") should return false")
+func TestBuildOpenAIRequest(t *testing.T) {
+	tests := []struct {
+		model       string
+		apiModel    string
+		effort      string
+		serviceTier string
+		background  bool
+	}{
+		{"o3", "o3", "high", "", false},
+		{"o3-flex", "o3", "high", "flex", false},
+		{"o3-pro", "o3-pro", "high", "", true},
+		{"o4-mini", "o4-mini", "medium", "", false},
+		{"o4-mini-flex", "o4-mini", "medium", "flex", false},
+		{"4.1", "gpt-4.1", "", "", false},
 	}
-	if isFlexModel("o3") {
-		t.Error("isFlexModel(\"o3\") should return false")
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			_, modelID := parseModel(tt.model)
+			req := buildOpenAIRequest(modelID, "system", "message", false)
+			if req.Model != tt.apiModel {
+				t.Errorf("Model = %s, want %s", req.Model, tt.apiModel)
+			}
+			if req.ServiceTier != tt.serviceTier {
+				t.Errorf("ServiceTier = %q, want %q", req.ServiceTier, tt.serviceTier)
+			}
+			if req.Background != tt.background {
+				t.Errorf("Background = %v, want %v", req.Background, tt.background)
+			}
+			effort := ""
+			if req.Reasoning != nil {
+				effort = req.Reasoning.Effort
+			}
+			if effort != tt.effort {
+				t.Errorf("Effort = %q, want %q", effort, tt.effort)
+			}
+			if tt.effort == "" && (req.Temperature == nil || *req.Temperature != 0.5) {
+				t.Errorf("Temperature = %v, want 0.5", req.Temperature)
+			}
+		})
 	}
 }
 
@@ -335,3 +343,100 @@ func TestWebSearchJSONLOutput(t *testing.T) {
 		})
 	}
 }
+
+func TestReadBatchPrompts(t *testing.T) {
+	dir := t.TempDir()
+	path := dir + "/prompts.jsonl"
+	content := `{"id": "a", "prompt": "first"}
+
+{"prompt": "second"}
+`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	prompts, err := readBatchPrompts(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prompts) != 2 {
+		t.Fatalf("expected 2 prompts, got %d", len(prompts))
+	}
+	if prompts[0].ID != "a" || prompts[1].ID != "3" || prompts[1].Prompt != "second" {
+		t.Errorf("unexpected prompts: %+v", prompts)
+	}
+
+	for name, bad := range map[string]struct{ input, err string }{
+		"empty prompt":     {`{"id": "a", "prompt": ""}`, ":1: empty prompt"},
+		"duplicate id":     {`{"id": "a", "prompt": "x"}` + "\n" + `{"id": "a", "prompt": "y"}`, ":2: duplicate id"},
+		"duplicate number": {`{"id": "2", "prompt": "x"}` + "\n" + `{"prompt": "y"}`, ":2: duplicate id"},
+		"id with space":    {`{"id": "a", "prompt": "x"}` + "\n\n" + `{"id": "a b", "prompt": "y"}`, ":3: invalid id"},
+		"id with dot":      {`{"id": "q.1", "prompt": "x"}`, ":1: invalid id"},
+		"id too long":      {`{"id": "` + strings.Repeat("a", 65) + `", "prompt": "x"}`, ":1: invalid id"},
+		"invalid json":     {`not json`, ":1:"},
+		"no prompts":       {"\n", "no prompts"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if err := os.WriteFile(path, []byte(bad.input), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := readBatchPrompts(path); err == nil || !strings.Contains(err.Error(), bad.err) {
+				t.Errorf("expected an error with %q, got %v", bad.err, err)
+			}
+		})
+	}
+}
+
+func TestComposePrompt(t *testing.T) {
+	cases := []struct {
+		template, text, stdin, want string
+	}{
+		{"", "", "just stdin\n", "just stdin"},
+		{"", "summarize this diff", "", "summarize this diff"},
+		{"", "summarize this diff", "+a\n-b\n", "summarize this diff\n\n```\n+a\n-b\n```"},
+		{"", "explain", "has ``` fence\n", "explain\n\n````\nhas ``` fence\n````"},
+		{"review {{pkg}}", "", "code\n", "review {{pkg}}\n\ncode"},
+		{"review util", "focus on errors", "code", "review util\n\nfocus on errors\n\n```\ncode\n```"},
+		{"", "", "  \n", ""},
+	}
+	for _, c := range cases {
+		if got := composePrompt(c.template, c.text, c.stdin); got != c.want {
+			t.Errorf("composePrompt(%q, %q, %q) = %q, want %q", c.template, c.text, c.stdin, got, c.want)
+		}
+	}
+}
+
+func TestAttachFiles(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{"a.go": "package a", "b.go": "package b", "c.bin": "\x00\x01"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	attachments, err := attachFiles([]string{filepath.Join(dir, "*.go"), filepath.Join(dir, "a.go"), filepath.Join(dir, "c.bin")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	text, count := withAttachments("", attachments), len(attachments)
+	if count != 2 || strings.Count(text, util.NinaFileStart) != 2 || !strings.Contains(text, "package b") {
+		t.Errorf("got %d files:\n%s", count, text)
+	}
+	if _, err := attachFiles([]string{filepath.Join(dir, "missing.go")}); err == nil {
+		t.Error("expected error for missing file")
+	}
+}
+
+func TestFitAttachments(t *testing.T) {
+	model := models.Model{Alias: "tiny", Provider: models.ProviderOpenAI, ContextWindow: 300, MaxOutput: 100}
+	attachments := []attachment{
+		{Path: "/a.go", Block: util.FormatNinaFile("/a.go", "package a\n")},
+		{Path: "/big.go", Block: util.FormatNinaFile("/big.go", strings.Repeat("var x = 1\n", 100))},
+		{Path: "/b.go", Block: util.FormatNinaFile("/b.go", "package b\n")},
+	}
+	kept := fitAttachments(model, "", "explain", attachments)
+	if len(kept) != 2 || kept[0].Path != "/a.go" || kept[1].Path != "/b.go" {
+		t.Errorf("fitAttachments() kept %+v, want a.go and b.go", kept)
+	}
+	if len(attachments) != 3 || attachments[1].Path != "/big.go" {
+		t.Errorf("fitAttachments() modified its input: %+v", attachments)
+	}
+}
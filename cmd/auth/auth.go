@@ -36,8 +36,7 @@ func authMain() {
 		Program: "nina auth",
 	}, &args)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		lib.Fatal(err)
 	}
 	
 	// Check for help or no args
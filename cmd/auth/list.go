@@ -4,9 +4,6 @@
 package auth
 
 import (
-	"fmt"
-	"os"
-
 	"github.com/alexflint/go-arg"
 	"github.com/nathants/nina/lib"
 )
@@ -26,7 +23,6 @@ func authList() {
 	arg.MustParse(&args)
 
 	if err := lib.List(); err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		lib.Fatal(err)
 	}
 }
@@ -12,6 +12,7 @@ import (
 	"strings"
 
 	"github.com/alexflint/go-arg"
+	"github.com/nathants/nina/lib"
 	oauth "github.com/nathants/nina/providers/oauth"
 )
 
@@ -34,8 +35,7 @@ func login() {
 	switch args.Provider {
 	case "anthropic":
 		if err := loginAnthropic(); err != nil {
-			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-			os.Exit(1)
+			lib.Fatal(err)
 		}
 	default:
 		fmt.Fprintf(os.Stderr, "Unknown provider: %s\n", args.Provider)
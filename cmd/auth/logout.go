@@ -4,9 +4,6 @@
 package auth
 
 import (
-	"fmt"
-	"os"
-
 	"github.com/alexflint/go-arg"
 	"github.com/nathants/nina/lib"
 )
@@ -27,7 +24,6 @@ func authLogout() {
 	arg.MustParse(&args)
 
 	if err := lib.Logout(args.Provider); err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		lib.Fatal(err)
 	}
 }
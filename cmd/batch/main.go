@@ -0,0 +1,249 @@
+// batch manages provider batches recorded in ~/.nina/batches.json, or
+// $NINA_BATCHES_FILE: lists, inspects, cancels, and resumes polling for
+// results of batches submitted by earlier, possibly interrupted, nina processes
+package batch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/alexflint/go-arg"
+	"github.com/nathants/nina/lib"
+	"github.com/nathants/nina/providers"
+	"github.com/nathants/nina/providers/claude"
+	"github.com/nathants/nina/providers/openai"
+	"github.com/nathants/nina/util"
+)
+
+func init() {
+	lib.Commands["batch"] = batchMain
+	lib.Args["batch"] = batchMainArgs{}
+}
+
+type batchMainArgs struct {
+	Subcommand string   `arg:"positional" help:"Subcommand to run (list, status, cancel, fetch)"`
+	Args       []string `arg:"positional" help:"Arguments for subcommand"`
+}
+
+func (batchMainArgs) Description() string {
+	return `batch - Manage provider batches
+
+Available subcommands:
+  list          - List batches submitted from this machine
+  status <id>   - Show current status of a batch
+  cancel <id>   - Cancel a running batch
+  fetch <id>    - Wait for a batch to finish and write its results
+
+Batches are recorded in ~/.nina/batches.json, or $NINA_BATCHES_FILE.`
+}
+
+type batchIDArgs struct {
+	ID string `arg:"positional,required" help:"Batch id"`
+}
+
+type batchFetchArgs struct {
+	ID     string `arg:"positional,required" help:"Batch id"`
+	Output string `arg:"-o,--output" help:"Output jsonl file (default: <id>.jsonl)"`
+}
+
+func batchMain() {
+	var args batchMainArgs
+	p, err := arg.NewParser(arg.Config{
+		Program: "nina batch",
+	}, &args)
+	if err != nil {
+		lib.Fatal(err)
+	}
+
+	if len(os.Args) < 2 || os.Args[1] == "-h" || os.Args[1] == "--help" {
+		p.WriteHelp(os.Stdout)
+		os.Exit(0)
+	}
+
+	err = p.Parse(os.Args[1:2])
+	if err != nil {
+		p.WriteHelp(os.Stderr)
+		os.Exit(1)
+	}
+
+	if len(os.Args) > 2 {
+		os.Args = append([]string{"nina batch " + args.Subcommand}, os.Args[2:]...)
+	} else {
+		os.Args = []string{"nina batch " + args.Subcommand}
+	}
+
+	// Ctrl-C stops polling without cancelling the remote batch
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	switch args.Subcommand {
+	case "list":
+		err = batchList()
+	case "status":
+		var idArgs batchIDArgs
+		arg.MustParse(&idArgs)
+		err = batchStatus(ctx, idArgs.ID)
+	case "cancel":
+		var idArgs batchIDArgs
+		arg.MustParse(&idArgs)
+		err = batchCancel(ctx, idArgs.ID)
+	case "fetch":
+		var fetchArgs batchFetchArgs
+		arg.MustParse(&fetchArgs)
+		err = batchFetch(ctx, fetchArgs.ID, fetchArgs.Output)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown subcommand: %s\n", args.Subcommand)
+		p.WriteHelp(os.Stderr)
+		os.Exit(1)
+	}
+	if err != nil {
+		lib.Fatal(err)
+	}
+}
+
+// batchProvider returns the provider for a batch id, from the local record if
+// known, otherwise from the id prefix each provider uses
+func batchProvider(id string) (string, error) {
+	record, ok, err := providers.GetBatch(id)
+	if err != nil {
+		return "", err
+	}
+	if ok {
+		return record.Provider, nil
+	}
+	switch {
+	case strings.HasPrefix(id, "msgbatch_"):
+		return "claude", nil
+	case strings.HasPrefix(id, "batch_"):
+		return "openai", nil
+	}
+	return "", fmt.Errorf("unknown batch: %s", id)
+}
+
+func batchList() error {
+	records, err := providers.ListBatches()
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		util.Infof("no batches recorded")
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "ID\tPROVIDER\tREQUESTS\tSTATUS\tCOMMAND\tCREATED")
+	for _, r := range records {
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\n",
+			r.ID, r.Provider, r.Requests, r.Status, r.Command, r.CreatedAt.Local().Format("2006-01-02 15:04"))
+	}
+	return w.Flush()
+}
+
+func batchStatus(ctx context.Context, id string) error {
+	prov, err := batchProvider(id)
+	if err != nil {
+		return err
+	}
+	var resp any
+	switch prov {
+	case "claude":
+		resp, err = claude.GetBatch(ctx, id)
+	case "openai":
+		resp, err = openai.GetBatch(ctx, id)
+	}
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(resp, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
+func batchCancel(ctx context.Context, id string) error {
+	prov, err := batchProvider(id)
+	if err != nil {
+		return err
+	}
+	var status string
+	switch prov {
+	case "claude":
+		var resp *claude.BatchResponse
+		resp, err = claude.CancelBatch(ctx, id)
+		if resp != nil {
+			status = resp.ProcessingStatus
+		}
+	case "openai":
+		var resp *openai.BatchResponse
+		resp, err = openai.CancelBatch(ctx, id)
+		if resp != nil {
+			status = resp.Status
+		}
+	}
+	if err != nil {
+		return err
+	}
+	util.Infof("batch %s: %s", id, status)
+	return nil
+}
+
+// batchFetch resumes polling a batch until it finishes, then writes the raw
+// provider results as jsonl
+func batchFetch(ctx context.Context, id, output string) error {
+	prov, err := batchProvider(id)
+	if err != nil {
+		return err
+	}
+	if output == "" {
+		output = id + ".jsonl"
+	}
+
+	var results []any
+	switch prov {
+	case "claude":
+		resp, err := claude.WaitBatch(ctx, id)
+		if err != nil {
+			return err
+		}
+		items, err := claude.FetchBatchResults(ctx, resp)
+		if err != nil {
+			return err
+		}
+		for _, item := range items {
+			results = append(results, item)
+		}
+	case "openai":
+		resp, err := openai.WaitBatch(ctx, id)
+		if err != nil {
+			return err
+		}
+		items, err := openai.FetchBatchResults(ctx, resp)
+		if err != nil {
+			return err
+		}
+		for _, item := range items {
+			results = append(results, item)
+		}
+	}
+
+	f, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	enc := json.NewEncoder(f)
+	for _, r := range results {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	util.Infof("wrote %d results to %s", len(results), output)
+	return nil
+}
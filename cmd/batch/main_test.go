@@ -0,0 +1,78 @@
+package batch
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nathants/nina/providers"
+)
+
+func TestBatchProvider(t *testing.T) {
+	t.Setenv("NINA_BATCHES_FILE", filepath.Join(t.TempDir(), "batches.json"))
+	if err := providers.SaveBatch("openai", "custom-id", "validating", 1); err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]string{"custom-id": "openai", "msgbatch_1": "claude", "batch_1": "openai"}
+	for id, want := range tests {
+		if got, err := batchProvider(id); err != nil || got != want {
+			t.Errorf("batchProvider(%s) = %s, %v, want %s", id, got, err, want)
+		}
+	}
+	if _, err := batchProvider("job-1"); err == nil {
+		t.Error("batchProvider(job-1) expected an error for an unknown batch")
+	}
+}
+
+func TestBatchCancelAndFetch(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("NINA_BATCHES_FILE", filepath.Join(t.TempDir(), "batches.json"))
+	t.Setenv("OPENAI_API_KEY", "test")
+	status := "in_progress"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/v1/batches/batch_1/cancel":
+			status = "cancelling"
+			_, _ = fmt.Fprintf(w, `{"id": "batch_1", "status": %q}`, status)
+		case r.Method == http.MethodGet && r.URL.Path == "/v1/batches/batch_1":
+			status = "cancelled"
+			_, _ = fmt.Fprintf(w, `{"id": "batch_1", "status": %q, "output_file_id": "file_1"}`, status)
+		case r.Method == http.MethodGet && r.URL.Path == "/v1/files/file_1/content":
+			_, _ = fmt.Fprint(w, `{"id": "r1", "custom_id": "0", "response": {"status_code": 200}}`+"\n")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	t.Setenv("NINA_OPENAI_BASE_URL", server.URL+"/v1")
+
+	if err := providers.SaveBatch("openai", "batch_1", status, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := batchCancel(context.Background(), "batch_1"); err != nil {
+		t.Fatal(err)
+	}
+	if record, _, _ := providers.GetBatch("batch_1"); record.Status != "cancelling" {
+		t.Errorf("status after cancel = %s, want cancelling", record.Status)
+	}
+
+	// fetching resumes polling the recorded batch until it is done
+	if err := batchFetch(context.Background(), "batch_1", ""); err != nil {
+		t.Fatal(err)
+	}
+	if record, _, _ := providers.GetBatch("batch_1"); record.Status != "cancelled" {
+		t.Errorf("status after fetch = %s, want cancelled", record.Status)
+	}
+	data, err := os.ReadFile("batch_1.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"custom_id":"0"`) {
+		t.Errorf("results = %s", data)
+	}
+}
@@ -0,0 +1,188 @@
+package bench
+
+// eval scores a model on a suite of small tasks, the create, edit, delete,
+// and multi-file cases the integration tests cover, so a local model can be
+// checked before it is trusted with real work. a suite is a subdirectory of
+// bench/ holding tasks in the format of bench run, loaded the same way.
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/alexflint/go-arg"
+	"github.com/nathants/nina/lib"
+	"github.com/nathants/nina/util"
+)
+
+func init() {
+	lib.Commands["eval"] = evalMain
+	lib.Args["eval"] = evalArgs{}
+}
+
+type evalArgs struct {
+	Models []string `arg:"-m,--model,separate" help:"model to evaluate, repeat to compare models (default: o3)"`
+	Suite  string   `arg:"-s,--suite" default:"basic" help:"suite under bench/ at the git root, or a directory of bench tasks"`
+	N      int      `arg:"--n" default:"1" help:"runs of each task per model"`
+	Keep   bool     `arg:"--keep" help:"keep each run's repo for inspection"`
+	JSON   bool     `arg:"--json" help:"print every result and the summaries as json"`
+}
+
+func (evalArgs) Description() string {
+	return `eval - Score a model on a suite of small coding tasks
+
+Runs every task of the suite in a temp git repo with nina run and
+checks the files it leaves, then prints a scorecard of the tasks
+each model passed, its iterations, tokens, and cost. Use it to
+decide whether a local model is good enough before trusting it.
+
+Suites are subdirectories of bench/ at the git root, like bench/basic,
+holding tasks in the format of nina bench.
+
+Example:
+  nina eval --model ollama --suite basic
+  nina eval -m ollama -m flash --n 3`
+}
+
+func evalMain() {
+	var args evalArgs
+	arg.MustParse(&args)
+	if err := eval(args); err != nil {
+		lib.Fatal(err)
+	}
+}
+
+// suiteNames lists the suites in dir, its subdirectories that are not tasks
+func suiteNames(dir string) []string {
+	entries, _ := os.ReadDir(dir)
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(dir, entry.Name(), "task.json")); err == nil {
+			continue
+		}
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+// suiteDir returns the task directory of suite, a directory on disk or a
+// suite under tasksDir
+func suiteDir(tasksDir, suite string) (string, error) {
+	if info, err := os.Stat(suite); err == nil && info.IsDir() {
+		return suite, nil
+	}
+	dir := filepath.Join(tasksDir, suite)
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() || suite == "" || strings.ContainsRune(suite, filepath.Separator) {
+		return "", fmt.Errorf("no suite %s in %s, available: %s", suite, tasksDir, strings.Join(suiteNames(tasksDir), ", "))
+	}
+	return dir, nil
+}
+
+func eval(args evalArgs) error {
+	dir, err := suiteDir(TasksDir(), args.Suite)
+	if err != nil {
+		return err
+	}
+	tasks, err := LoadTasks(dir, nil)
+	if err != nil {
+		return err
+	}
+	if len(tasks) == 0 {
+		return fmt.Errorf("no tasks in suite %s", args.Suite)
+	}
+	models := args.Models
+	if len(models) == 0 {
+		models = []string{"o3"}
+	}
+	nina, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	var results []Result
+	for _, model := range models {
+		for _, task := range tasks {
+			for run := 1; run <= max(args.N, 1); run++ {
+				r := runTask(context.Background(), nina, task, model, run, args.Keep)
+				status := "pass"
+				if !r.Passed {
+					status = "FAIL"
+				}
+				util.Infof("%s %s %s run %d: %d iterations, %.0fs", status, model, task.Name, run, r.Iterations, r.Seconds)
+				if r.Error != "" {
+					util.Infof("  error: %s", r.Error)
+				}
+				for _, failed := range r.Failed {
+					util.Infof("  check failed: %s", failed)
+				}
+				results = append(results, r)
+			}
+		}
+	}
+
+	summaries := summarize(models, results)
+	if args.JSON {
+		data, err := json.MarshalIndent(map[string]any{"suite": args.Suite, "results": results, "models": summaries}, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+	writeScorecard(os.Stdout, tasks, summaries, results)
+	return nil
+}
+
+// writeScorecard prints the passes of each task per model, then each model's
+// score, mean iterations and tokens, and cost
+func writeScorecard(w io.Writer, tasks []Task, summaries []ModelSummary, results []Result) {
+	width := len("iterations")
+	for _, task := range tasks {
+		width = max(width, len(task.Name))
+	}
+	colWidth := 10
+	for _, s := range summaries {
+		colWidth = max(colWidth, len(s.Model))
+	}
+	row := func(label string, cells []string) {
+		_, _ = fmt.Fprintf(w, "%-*s", width, label)
+		for _, cell := range cells {
+			_, _ = fmt.Fprintf(w, "  %*s", colWidth, cell)
+		}
+		_, _ = fmt.Fprintln(w)
+	}
+	cells := func(cell func(ModelSummary) string) []string {
+		var out []string
+		for _, s := range summaries {
+			out = append(out, cell(s))
+		}
+		return out
+	}
+
+	row("task", cells(func(s ModelSummary) string { return s.Model }))
+	for _, task := range tasks {
+		row(task.Name, cells(func(s ModelSummary) string {
+			runs, passed := 0, 0
+			for _, r := range results {
+				if r.Model == s.Model && r.Task == task.Name {
+					runs++
+					if r.Passed {
+						passed++
+					}
+				}
+			}
+			return fmt.Sprintf("%d/%d", passed, runs)
+		}))
+	}
+	row("score", cells(func(s ModelSummary) string { return fmt.Sprintf("%.0f%%", s.SuccessRate*100) }))
+	row("iterations", cells(func(s ModelSummary) string { return fmt.Sprintf("%.1f", s.Iterations) }))
+	row("tokens", cells(func(s ModelSummary) string { return fmt.Sprintf("%.0f", s.Tokens) }))
+	row("cost", cells(func(s ModelSummary) string { return fmt.Sprintf("$%.4f", s.Cost) }))
+}
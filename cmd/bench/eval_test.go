@@ -0,0 +1,57 @@
+package bench
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestSuiteDir(t *testing.T) {
+	dir, err := suiteDir("../../bench", "basic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tasks, err := LoadTasks(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, task := range tasks {
+		names = append(names, task.Name)
+	}
+	if strings.Join(names, " ") != "create-file delete-file edit-file multi-file" {
+		t.Errorf("tasks = %v", names)
+	}
+	if _, err := suiteDir("../../bench", "missing"); err == nil || !strings.HasSuffix(err.Error(), "available: basic") {
+		t.Errorf("expected an unknown suite error listing only suites, got %v", err)
+	}
+	if _, err := suiteDir("../../bench", "edit-readme/repo"); err == nil {
+		t.Error("expected an error for a path inside the task directory")
+	}
+	if got, err := suiteDir("../../bench", "../../bench/basic"); err != nil || got != "../../bench/basic" {
+		t.Errorf("suiteDir(dir) = %q, %v, want the directory", got, err)
+	}
+}
+
+func TestWriteScorecard(t *testing.T) {
+	tasks := []Task{{Name: "create-file"}, {Name: "delete-file"}}
+	results := []Result{
+		{Task: "create-file", Model: "ollama", Passed: true, Iterations: 2, Input: 100},
+		{Task: "delete-file", Model: "ollama", Iterations: 4, Input: 300},
+		{Task: "create-file", Model: "flash", Passed: true, Iterations: 1, Cost: 0.01},
+		{Task: "delete-file", Model: "flash", Passed: true, Iterations: 1, Cost: 0.01},
+	}
+	var out bytes.Buffer
+	writeScorecard(&out, tasks, summarize([]string{"ollama", "flash"}, results), results)
+	want := `task             ollama       flash
+create-file         1/1         1/1
+delete-file         0/1         1/1
+score               50%        100%
+iterations          3.0         1.0
+tokens              200           0
+cost            $0.0000     $0.0200
+`
+	if out.String() != want {
+		t.Errorf("scorecard:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
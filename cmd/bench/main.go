@@ -0,0 +1,372 @@
+// bench measures nina's accuracy. bench run runs task fixtures end to end
+// with one or more models, and bench convert replays the converter feedback
+// corpus recorded by ConvertToRangeUpdates against the current matcher,
+// prompt, and converter model
+package bench
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/alexflint/go-arg"
+	"github.com/nathants/nina/lib"
+	"github.com/nathants/nina/util"
+)
+
+func init() {
+	lib.Commands["bench"] = benchMain
+	lib.Args["bench"] = benchMainArgs{}
+}
+
+type benchMainArgs struct {
+	Subcommand string   `arg:"positional" help:"Subcommand to run (run, list, convert)"`
+	Args       []string `arg:"positional" help:"Arguments for subcommand"`
+}
+
+func (benchMainArgs) Description() string {
+	return `bench - Measure accuracy against recorded outcomes
+
+Tasks are directories under bench/ at the git root holding task.json
+and a repo/ snapshot. Each run copies the snapshot into a new git repo,
+runs nina run with the prompt, then checks the outcome:
+
+  {
+    "prompt": "change hello to hi in README.md",
+    "checks": [
+      {"path": "README.md", "equals": "hi"},
+      {"path": "old.txt", "exists": false},
+      {"command": "go test ./..."}
+    ],
+    "max_tokens": 100000,
+    "timeout": "5m"
+  }
+
+Subdirectories of bench/ without a task.json are suites of tasks, like
+bench/basic, run with --dir or scored with nina eval.
+
+Available subcommands:
+  run     - Run tasks with each model n times and report success rate,
+            iterations, tokens, and cost per model
+  list    - List tasks
+  convert - Replay the converter corpus in agents/convert-corpus, or
+            $NINA_CONVERT_CORPUS, and report conversions that changed.
+            Exits 1 when a conversion that validated before now fails.
+
+Example:
+  nina bench run -m sonnet -m o3 --n 3
+  nina bench run -m flash --task edit-readme --keep
+  nina bench convert
+  nina bench convert --model haiku --failures`
+}
+
+type runArgs struct {
+	Models []string `arg:"-m,--model,separate" help:"model to benchmark, repeat to compare models (default: o3)"`
+	N      int      `arg:"--n" default:"1" help:"runs of each task per model"`
+	Tasks  []string `arg:"--task,separate" help:"run only this task, repeatable"`
+	Dir    string   `arg:"--dir" help:"task directory (default: bench/ at the git root)"`
+	Keep   bool     `arg:"--keep" help:"keep each run's repo for inspection"`
+	JSON   bool     `arg:"--json" help:"print every result and the summaries as json"`
+}
+
+type listArgs struct {
+	Dir string `arg:"--dir" help:"task directory (default: bench/ at the git root)"`
+}
+
+type convertArgs struct {
+	Dir      string `arg:"--dir" help:"corpus directory (default: agents/convert-corpus or $NINA_CONVERT_CORPUS)"`
+	Model    string `arg:"-m,--model" help:"converter model to replay with, or local (default: $NINA_CONVERTER_MODEL or sonnet)"`
+	Limit    int    `arg:"--limit" help:"replay only the newest n records"`
+	Parallel int    `arg:"--parallel" default:"4" help:"records replayed concurrently"`
+	Failures bool   `arg:"--failures" help:"print every record whose outcome changed"`
+	JSON     bool   `arg:"--json" help:"print the report as json"`
+}
+
+func benchMain() {
+	var args benchMainArgs
+	p, err := arg.NewParser(arg.Config{
+		Program: "nina bench",
+	}, &args)
+	if err != nil {
+		lib.Fatal(err)
+	}
+
+	if len(os.Args) < 2 || os.Args[1] == "-h" || os.Args[1] == "--help" {
+		p.WriteHelp(os.Stdout)
+		os.Exit(0)
+	}
+
+	err = p.Parse(os.Args[1:2])
+	if err != nil {
+		p.WriteHelp(os.Stderr)
+		os.Exit(1)
+	}
+
+	os.Args = append([]string{"nina bench " + args.Subcommand}, os.Args[2:]...)
+
+	switch args.Subcommand {
+	case "run":
+		var run runArgs
+		arg.MustParse(&run)
+		err = benchRun(run)
+	case "list":
+		var list listArgs
+		arg.MustParse(&list)
+		err = benchList(list.Dir)
+	case "convert":
+		var convert convertArgs
+		arg.MustParse(&convert)
+		err = benchConvert(convert)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown subcommand: %s\n", args.Subcommand)
+		p.WriteHelp(os.Stderr)
+		os.Exit(1)
+	}
+	if err != nil {
+		lib.Fatal(err)
+	}
+}
+
+func benchList(dir string) error {
+	if dir == "" {
+		dir = TasksDir()
+	}
+	tasks, err := LoadTasks(dir, nil)
+	if err != nil {
+		return err
+	}
+	if len(tasks) == 0 {
+		util.Infof("no tasks in %s", dir)
+		return nil
+	}
+	for _, task := range tasks {
+		prompt, _, _ := strings.Cut(strings.TrimSpace(task.Prompt), "\n")
+		fmt.Printf("%-24s %d checks  %s\n", task.Name, len(task.Checks), prompt)
+	}
+	return nil
+}
+
+func benchRun(args runArgs) error {
+	dir := args.Dir
+	if dir == "" {
+		dir = TasksDir()
+	}
+	tasks, err := LoadTasks(dir, args.Tasks)
+	if err != nil {
+		return err
+	}
+	if len(tasks) == 0 {
+		return fmt.Errorf("no tasks in %s", dir)
+	}
+	models := args.Models
+	if len(models) == 0 {
+		models = []string{"o3"}
+	}
+	nina, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	var results []Result
+	for _, model := range models {
+		for _, task := range tasks {
+			for run := 1; run <= max(args.N, 1); run++ {
+				r := runTask(context.Background(), nina, task, model, run, args.Keep)
+				status := "pass"
+				if !r.Passed {
+					status = "FAIL"
+				}
+				util.Infof("%s %s %s run %d: %d iterations, %d tokens, $%.4f, %.0fs", status, model, task.Name, run, r.Iterations, r.Input+r.Output, r.Cost, r.Seconds)
+				if r.Error != "" {
+					util.Infof("  error: %s", r.Error)
+				}
+				for _, failed := range r.Failed {
+					util.Infof("  check failed: %s", failed)
+				}
+				results = append(results, r)
+			}
+		}
+	}
+
+	summaries := summarize(models, results)
+	if args.JSON {
+		data, err := json.MarshalIndent(map[string]any{"results": results, "models": summaries}, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+	fmt.Printf("%-28s %6s %8s %10s %10s %10s %12s\n", "model", "runs", "success", "iterations", "tokens", "cost", "cost/pass")
+	for _, s := range summaries {
+		perPass := "-"
+		if s.Passed > 0 {
+			perPass = fmt.Sprintf("$%.4f", s.CostPerPass)
+		}
+		fmt.Printf("%-28s %6d %7.0f%% %10.1f %10.0f %10s %12s\n", s.Model, s.Runs, s.SuccessRate*100, s.Iterations, s.Tokens, fmt.Sprintf("$%.4f", s.Cost), perPass)
+	}
+	return nil
+}
+
+// Outcome of replaying a record compared to the recorded one
+const (
+	outcomeAgree     = "agree"     // same valid range
+	outcomeChanged   = "changed"   // a different valid range
+	outcomeRegressed = "regressed" // valid before, fails now
+	outcomeFixed     = "fixed"     // failed before, valid now
+	outcomeFailing   = "failing"   // failed before and now
+)
+
+// replay is the result of replaying one corpus record
+type replay struct {
+	Record  lib.ConvertRecord `json:"record"`
+	Outcome string            `json:"outcome"`
+	Method  string            `json:"method"`
+	Start   int               `json:"start"`
+	End     int               `json:"end"`
+	Error   string            `json:"error,omitempty"`
+}
+
+// convertReport summarizes a corpus replay
+type convertReport struct {
+	Dir      string         `json:"dir"`
+	Model    string         `json:"model"`
+	Records  int            `json:"records"`
+	Skipped  int            `json:"skipped"` // file content missing from the corpus
+	Outcomes map[string]int `json:"outcomes"`
+	Accuracy float64        `json:"accuracy"` // same range among records valid before
+	Replays  []replay       `json:"replays,omitempty"`
+}
+
+// classify compares a replayed range to the recorded outcome
+func classify(record lib.ConvertRecord, result util.RangeResult, err error) string {
+	valid := err == nil && result.Start > 0 && result.End-result.Start+1 == len(strings.Split(record.Search, "\n"))
+	switch {
+	case record.Valid && !valid:
+		return outcomeRegressed
+	case !record.Valid && valid:
+		return outcomeFixed
+	case !valid:
+		return outcomeFailing
+	case result.Start == record.Start && result.End == record.End:
+		return outcomeAgree
+	default:
+		return outcomeChanged
+	}
+}
+
+func benchConvert(args convertArgs) error {
+	dir := args.Dir
+	if dir == "" {
+		dir = lib.CorpusDir()
+	}
+	if dir == "" {
+		return fmt.Errorf("no corpus directory, NINA_CONVERT_CORPUS=0 disables it, use --dir")
+	}
+	if args.Model != "" {
+		_ = os.Setenv("NINA_CONVERTER_MODEL", args.Model)
+	}
+	// replays must not add to the corpus they measure
+	_ = os.Setenv("NINA_CONVERT_CORPUS", "0")
+
+	records, err := lib.LoadCorpus(dir)
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		return fmt.Errorf("no records in %s", dir)
+	}
+	if args.Limit > 0 && len(records) > args.Limit {
+		records = records[len(records)-args.Limit:]
+	}
+
+	report := runConvertBench(context.Background(), dir, records, max(args.Parallel, 1))
+	report.Model = lib.ConverterModel()
+	var changed []replay
+	for _, r := range report.Replays {
+		if r.Outcome != outcomeAgree {
+			changed = append(changed, r)
+		}
+	}
+
+	if args.JSON {
+		report.Replays = nil
+		if args.Failures {
+			report.Replays = changed
+		}
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+	} else {
+		if args.Failures {
+			for _, r := range changed {
+				fmt.Printf("%-9s %s:%d-%d -> %d-%d %s %s\n", r.Outcome, r.Record.File, r.Record.Start, r.Record.End, r.Start, r.End, r.Method, r.Error)
+			}
+		}
+		fmt.Printf("records    %d\n", report.Records)
+		if report.Skipped > 0 {
+			fmt.Printf("skipped    %d\n", report.Skipped)
+		}
+		for _, outcome := range []string{outcomeAgree, outcomeChanged, outcomeRegressed, outcomeFixed, outcomeFailing} {
+			fmt.Printf("%-10s %d\n", outcome, report.Outcomes[outcome])
+		}
+		fmt.Printf("accuracy   %.1f%% with %s\n", report.Accuracy*100, report.Model)
+	}
+	if n := report.Outcomes[outcomeRegressed]; n > 0 {
+		return fmt.Errorf("%d conversions regressed", n)
+	}
+	return nil
+}
+
+// runConvertBench replays records with up to parallel conversions at once
+func runConvertBench(ctx context.Context, dir string, records []lib.ConvertRecord, parallel int) convertReport {
+	report := convertReport{Dir: dir, Records: len(records), Outcomes: map[string]int{}}
+	replays := make([]*replay, len(records))
+	sem := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for i, record := range records {
+		content := record.Content
+		if content == "" {
+			util.Verbosef("skipping %s: no file content recorded", record.File)
+			report.Skipped++
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			result, method, err := lib.ReplayConversion(ctx, record, content)
+			r := &replay{Record: record, Method: method, Start: result.Start, End: result.End, Outcome: classify(record, result, err)}
+			if err != nil {
+				r.Error = err.Error()
+			}
+			replays[i] = r
+		}()
+	}
+	wg.Wait()
+
+	validBefore, agree := 0, 0
+	for _, r := range replays {
+		if r == nil {
+			continue
+		}
+		report.Replays = append(report.Replays, *r)
+		report.Outcomes[r.Outcome]++
+		if r.Record.Valid {
+			validBefore++
+			if r.Outcome == outcomeAgree {
+				agree++
+			}
+		}
+	}
+	if validBefore > 0 {
+		report.Accuracy = float64(agree) / float64(validBefore)
+	}
+	return report
+}
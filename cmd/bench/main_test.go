@@ -0,0 +1,61 @@
+package bench
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/nathants/nina/lib"
+	"github.com/nathants/nina/util"
+)
+
+func TestClassify(t *testing.T) {
+	valid := lib.ConvertRecord{Search: "a\nb", Start: 3, End: 4, Valid: true}
+	invalid := lib.ConvertRecord{Search: "a\nb"}
+	tests := []struct {
+		name   string
+		record lib.ConvertRecord
+		result util.RangeResult
+		err    error
+		want   string
+	}{
+		{"agree", valid, util.RangeResult{Start: 3, End: 4}, nil, outcomeAgree},
+		{"changed", valid, util.RangeResult{Start: 7, End: 8}, nil, outcomeChanged},
+		{"regressed by error", valid, util.RangeResult{}, errors.New("not found"), outcomeRegressed},
+		{"regressed by length", valid, util.RangeResult{Start: 3, End: 5}, nil, outcomeRegressed},
+		{"fixed", invalid, util.RangeResult{Start: 1, End: 2}, nil, outcomeFixed},
+		{"failing", invalid, util.RangeResult{}, errors.New("not found"), outcomeFailing},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classify(tt.record, tt.result, tt.err); got != tt.want {
+				t.Errorf("classify() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunConvertBench(t *testing.T) {
+	t.Setenv("NINA_CONVERTER_MODEL", lib.ConverterLocal)
+	content := util.AddLineNumbers("one\ntwo\nthree")
+	records := []lib.ConvertRecord{
+		{File: "a.txt", Content: content, Search: "two", Start: 2, End: 2, Valid: true},
+		{File: "a.txt", Content: content, Search: "three", Start: 1, End: 1, Valid: true},
+		{File: "a.txt", Content: content, Search: "four", Start: 3, End: 3, Valid: true},
+		{File: "a.txt", Content: content, Search: "one"},
+		{File: "b.txt", Search: "x", Valid: true},
+	}
+	report := runConvertBench(context.Background(), t.TempDir(), records, 2)
+	if report.Skipped != 1 {
+		t.Errorf("skipped %d, want 1", report.Skipped)
+	}
+	want := map[string]int{outcomeAgree: 1, outcomeChanged: 1, outcomeRegressed: 1, outcomeFixed: 1}
+	for outcome, n := range want {
+		if report.Outcomes[outcome] != n {
+			t.Errorf("%s = %d, want %d: %v", outcome, report.Outcomes[outcome], n, report.Outcomes)
+		}
+	}
+	if report.Accuracy != 1.0/3 {
+		t.Errorf("accuracy = %v, want 1/3", report.Accuracy)
+	}
+}
@@ -0,0 +1,375 @@
+package bench
+
+// end to end task benchmarks. a task is a directory holding task.json and a
+// repo/ snapshot. each run copies the snapshot into a fresh git repo, runs
+// nina run with the task prompt and --output-format stream-json, reads the
+// iterations, tokens, and cost from the event stream, and then runs the
+// task's checks against the repo, like the integration tests do.
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nathants/nina/lib"
+	"github.com/nathants/nina/util"
+)
+
+const (
+	defaultTaskTimeout   = 10 * time.Minute
+	defaultTaskMaxTokens = 200000
+)
+
+// Task is a benchmark fixture loaded from <dir>/task.json
+type Task struct {
+	Name      string  `json:"-"`
+	Dir       string  `json:"-"`
+	Prompt    string  `json:"prompt"`
+	Checks    []Check `json:"checks"`
+	MaxTokens int     `json:"max_tokens,omitempty"` // default 200000
+	Timeout   string  `json:"timeout,omitempty"`    // e.g. 5m, default 10m
+}
+
+// Check is one expected outcome. Path checks compare a file of the repo:
+// equals its exact content, contains a substring, and exists false that it
+// was removed, with only path set it must exist. Command checks pass when
+// the shell command exits 0 in the repo.
+type Check struct {
+	Path     string  `json:"path,omitempty"`
+	Equals   *string `json:"equals,omitempty"`
+	Contains string  `json:"contains,omitempty"`
+	Exists   *bool   `json:"exists,omitempty"`
+	Command  string  `json:"command,omitempty"`
+}
+
+func (c Check) String() string {
+	switch {
+	case c.Command != "":
+		return "command " + c.Command
+	case c.Equals != nil:
+		return c.Path + " equals"
+	case c.Contains != "":
+		return fmt.Sprintf("%s contains %q", c.Path, c.Contains)
+	case c.Exists != nil && !*c.Exists:
+		return c.Path + " is absent"
+	default:
+		return c.Path + " exists"
+	}
+}
+
+// TasksDir returns the default task directory, bench/ at the git root
+func TasksDir() string {
+	return filepath.Join(util.GetGitRoot(), "bench")
+}
+
+// LoadTasks loads every task in dir, or only those named, sorted by name
+func LoadTasks(dir string, names []string) ([]Task, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*", "task.json"))
+	if err != nil {
+		return nil, err
+	}
+	want := map[string]bool{}
+	for _, name := range names {
+		want[name] = true
+	}
+	var tasks []Task
+	for _, path := range paths {
+		name := filepath.Base(filepath.Dir(path))
+		if len(names) > 0 && !want[name] {
+			continue
+		}
+		task, err := loadTask(path)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, task)
+		delete(want, name)
+	}
+	for name := range want {
+		return nil, fmt.Errorf("no task %s in %s", name, dir)
+	}
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].Name < tasks[j].Name })
+	return tasks, nil
+}
+
+func loadTask(path string) (Task, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Task{}, err
+	}
+	var task Task
+	if err := json.Unmarshal(data, &task); err != nil {
+		return Task{}, fmt.Errorf("%s: %w", path, err)
+	}
+	task.Dir = filepath.Dir(path)
+	task.Name = filepath.Base(task.Dir)
+	if strings.TrimSpace(task.Prompt) == "" {
+		return Task{}, fmt.Errorf("%s: missing prompt", path)
+	}
+	if len(task.Checks) == 0 {
+		return Task{}, fmt.Errorf("%s: missing checks", path)
+	}
+	for _, check := range task.Checks {
+		if (check.Path == "") == (check.Command == "") {
+			return Task{}, fmt.Errorf("%s: a check needs exactly one of path or command", path)
+		}
+	}
+	if _, err := task.timeout(); err != nil {
+		return Task{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return task, nil
+}
+
+func (t Task) timeout() (time.Duration, error) {
+	if t.Timeout == "" {
+		return defaultTaskTimeout, nil
+	}
+	d, err := time.ParseDuration(t.Timeout)
+	if err != nil {
+		return 0, fmt.Errorf("invalid timeout %q", t.Timeout)
+	}
+	return d, nil
+}
+
+// Result is the outcome of one run of a task
+type Result struct {
+	Task       string   `json:"task"`
+	Model      string   `json:"model"`
+	Run        int      `json:"run"`
+	Passed     bool     `json:"passed"`
+	Iterations int      `json:"iterations"`
+	Input      int      `json:"input_tokens"`
+	Output     int      `json:"output_tokens"`
+	Cost       float64  `json:"cost_usd"`
+	Seconds    float64  `json:"seconds"`
+	Failed     []string `json:"failed,omitempty"` // checks that failed
+	Error      string   `json:"error,omitempty"`  // the run itself failed
+}
+
+// setupRepo copies the task's repo snapshot into a new committed git repo
+func setupRepo(task Task) (string, error) {
+	dir, err := os.MkdirTemp("", "nina-bench-"+task.Name+"-")
+	if err != nil {
+		return "", err
+	}
+	src := filepath.Join(task.Dir, "repo")
+	err = filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(dir, rel)
+		if d.IsDir() {
+			return os.MkdirAll(dst, 0755)
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(dst, data, info.Mode().Perm())
+	})
+	if err != nil && !os.IsNotExist(err) {
+		_ = os.RemoveAll(dir)
+		return "", err
+	}
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"add", "-A"},
+		{"-c", "user.name=nina", "-c", "user.email=nina@localhost", "commit", "-q", "--allow-empty", "-m", "bench " + task.Name},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			_ = os.RemoveAll(dir)
+			return "", fmt.Errorf("git %s: %v: %s", args[0], err, out)
+		}
+	}
+	return dir, nil
+}
+
+// runTask runs task once with model, keeping the repo when keep is set
+func runTask(ctx context.Context, nina string, task Task, model string, run int, keep bool) Result {
+	result := Result{Task: task.Name, Model: model, Run: run}
+
+	dir, err := setupRepo(task)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	if keep {
+		util.Infof("%s %s run %d: %s", task.Name, model, run, dir)
+	} else {
+		defer func() { _ = os.RemoveAll(dir) }()
+	}
+
+	timeout, _ := task.timeout()
+	runCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	maxTokens := task.MaxTokens
+	if maxTokens == 0 {
+		maxTokens = defaultTaskMaxTokens
+	}
+	start := time.Now()
+	cmd := exec.CommandContext(runCtx, nina, "run", "-m", model, "--max-tokens", strconv.Itoa(maxTokens), "--output-format", lib.OutputStreamJSON)
+	cmd.Dir = dir
+	cmd.Stdin = strings.NewReader(task.Prompt)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	runErr := cmd.Run()
+	result.Seconds = time.Since(start).Seconds()
+
+	done := readEvents(&stdout, &result)
+	switch {
+	case runCtx.Err() != nil:
+		result.Error = fmt.Sprintf("timed out after %s", timeout)
+	case done != nil && done.Status != "success":
+		result.Error = done.Error
+	case runErr != nil:
+		result.Error = fmt.Sprintf("%v: %s", runErr, lastLine(stderr.String()))
+	}
+	result.Failed = runChecks(ctx, dir, task.Checks)
+	result.Passed = result.Error == "" && len(result.Failed) == 0
+	return result
+}
+
+// readEvents fills the iterations and usage of result from the event stream
+// and returns its done event, if any
+func readEvents(stream *bytes.Buffer, result *Result) *lib.StreamEvent {
+	var done *lib.StreamEvent
+	scanner := bufio.NewScanner(stream)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var event lib.StreamEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			continue
+		}
+		result.Iterations = max(result.Iterations, event.Step)
+		switch event.Type {
+		case lib.EventUsage:
+			if event.Usage != nil {
+				result.Input = event.Usage.InputTokens
+				result.Output = event.Usage.OutputTokens
+				result.Cost = event.Usage.CostUSD
+			}
+		case lib.EventDone:
+			done = &event
+		}
+	}
+	return done
+}
+
+// runChecks returns the checks that fail in dir
+func runChecks(ctx context.Context, dir string, checks []Check) []string {
+	var failed []string
+	for _, check := range checks {
+		if err := runCheck(ctx, dir, check); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", check, err))
+		}
+	}
+	return failed
+}
+
+func runCheck(ctx context.Context, dir string, check Check) error {
+	if check.Command != "" {
+		cmd := exec.CommandContext(ctx, "bash", "-c", check.Command)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("%v: %s", err, lastLine(string(out)))
+		}
+		return nil
+	}
+	data, err := os.ReadFile(filepath.Join(dir, check.Path))
+	exists := err == nil
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if check.Exists != nil && !*check.Exists {
+		if exists {
+			return fmt.Errorf("exists")
+		}
+		return nil
+	}
+	if !exists {
+		return fmt.Errorf("missing")
+	}
+	if check.Equals != nil && string(data) != *check.Equals {
+		got := string(data)
+		if len(got) > 200 {
+			got = got[:200] + "..."
+		}
+		return fmt.Errorf("got %q", got)
+	}
+	if check.Contains != "" && !strings.Contains(string(data), check.Contains) {
+		return fmt.Errorf("not found")
+	}
+	return nil
+}
+
+func lastLine(text string) string {
+	text = strings.TrimSpace(text)
+	if i := strings.LastIndex(text, "\n"); i >= 0 {
+		return text[i+1:]
+	}
+	return text
+}
+
+// ModelSummary aggregates the runs of one model
+type ModelSummary struct {
+	Model       string  `json:"model"`
+	Runs        int     `json:"runs"`
+	Passed      int     `json:"passed"`
+	SuccessRate float64 `json:"success_rate"`
+	Iterations  float64 `json:"mean_iterations"`
+	Tokens      float64 `json:"mean_tokens"`
+	Cost        float64 `json:"cost_usd"`
+	CostPerPass float64 `json:"cost_per_pass_usd,omitempty"`
+}
+
+// summarize aggregates results per model, in the order models were given
+func summarize(models []string, results []Result) []ModelSummary {
+	var summaries []ModelSummary
+	for _, model := range models {
+		s := ModelSummary{Model: model}
+		for _, r := range results {
+			if r.Model != model {
+				continue
+			}
+			s.Runs++
+			if r.Passed {
+				s.Passed++
+			}
+			s.Iterations += float64(r.Iterations)
+			s.Tokens += float64(r.Input + r.Output)
+			s.Cost += r.Cost
+		}
+		if s.Runs > 0 {
+			s.SuccessRate = float64(s.Passed) / float64(s.Runs)
+			s.Iterations /= float64(s.Runs)
+			s.Tokens /= float64(s.Runs)
+		}
+		if s.Passed > 0 {
+			s.CostPerPass = s.Cost / float64(s.Passed)
+		}
+		summaries = append(summaries, s)
+	}
+	return summaries
+}
@@ -0,0 +1,132 @@
+package bench
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadTasks(t *testing.T) {
+	tasks, err := LoadTasks("../../bench", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) < 3 {
+		t.Fatalf("got %d tasks, want the 3 fixtures", len(tasks))
+	}
+	tasks, err = LoadTasks("../../bench", []string{"edit-readme"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 1 || tasks[0].Name != "edit-readme" || tasks[0].Prompt == "" {
+		t.Errorf("unexpected tasks: %+v", tasks)
+	}
+	if _, err := LoadTasks("../../bench", []string{"missing"}); err == nil {
+		t.Error("expected an error for an unknown task")
+	}
+
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "bad"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "bad", "task.json"), []byte(`{"prompt": "x", "checks": [{"path": "a", "command": "true"}]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadTasks(dir, nil); err == nil || !strings.Contains(err.Error(), "exactly one of path or command") {
+		t.Errorf("expected an invalid check error, got %v", err)
+	}
+}
+
+// fakeNina writes a script standing in for the nina binary, it runs script
+// in the repo and prints the events of a two step run
+func fakeNina(t *testing.T, script, status string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "nina")
+	events := `{"type":"usage","step":1,"usage":{"input_tokens":100,"output_tokens":10,"cost_usd":0.01}}
+{"type":"usage","step":2,"usage":{"input_tokens":300,"output_tokens":30,"cost_usd":0.03}}
+{"type":"done","step":2,"status":"` + status + `","error":"boom"}`
+	content := "#!/bin/bash\ncat > /dev/null\n" + script + "\ncat <<'EOF'\n" + events + "\nEOF\n"
+	if err := os.WriteFile(path, []byte(content), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRunTask(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	tasks, err := LoadTasks("../../bench", []string{"edit-readme"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	task := tasks[0]
+
+	r := runTask(context.Background(), fakeNina(t, "printf hi > README.md", "success"), task, "sonnet", 1, false)
+	if !r.Passed || r.Error != "" || len(r.Failed) != 0 {
+		t.Fatalf("expected a pass: %+v", r)
+	}
+	if r.Iterations != 2 || r.Input != 300 || r.Output != 30 || r.Cost != 0.03 {
+		t.Errorf("unexpected usage: %+v", r)
+	}
+
+	r = runTask(context.Background(), fakeNina(t, "printf hey > README.md", "success"), task, "sonnet", 2, false)
+	if r.Passed || len(r.Failed) != 1 || !strings.Contains(r.Failed[0], `got "hey"`) {
+		t.Errorf("expected a failed check: %+v", r)
+	}
+
+	r = runTask(context.Background(), fakeNina(t, "printf hi > README.md", "error"), task, "sonnet", 3, false)
+	if r.Passed || r.Error != "boom" {
+		t.Errorf("expected the run error: %+v", r)
+	}
+}
+
+func TestRunChecks(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("hello world"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	no := false
+	equals := "hello world"
+	checks := []Check{
+		{Path: "a.txt"},
+		{Path: "a.txt", Equals: &equals},
+		{Path: "a.txt", Contains: "world"},
+		{Path: "b.txt", Exists: &no},
+		{Command: "test -f a.txt"},
+	}
+	if failed := runChecks(context.Background(), dir, checks); len(failed) != 0 {
+		t.Errorf("unexpected failures: %v", failed)
+	}
+	failing := []Check{
+		{Path: "b.txt"},
+		{Path: "a.txt", Contains: "moon"},
+		{Path: "a.txt", Exists: &no},
+		{Command: "false"},
+	}
+	if failed := runChecks(context.Background(), dir, failing); len(failed) != len(failing) {
+		t.Errorf("got %d failures, want %d: %v", len(failed), len(failing), failed)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	results := []Result{
+		{Model: "a", Passed: true, Iterations: 2, Input: 100, Output: 20, Cost: 0.1},
+		{Model: "a", Passed: false, Iterations: 4, Input: 300, Output: 60, Cost: 0.3},
+		{Model: "b", Passed: false, Iterations: 1, Cost: 0.05},
+	}
+	summaries := summarize([]string{"a", "b"}, results)
+	a, b := summaries[0], summaries[1]
+	if a.Runs != 2 || a.Passed != 1 || a.SuccessRate != 0.5 || a.Iterations != 3 || a.Tokens != 240 {
+		t.Errorf("unexpected summary: %+v", a)
+	}
+	if a.Cost < 0.399 || a.Cost > 0.401 || a.CostPerPass != a.Cost {
+		t.Errorf("unexpected cost: %+v", a)
+	}
+	if b.SuccessRate != 0 || b.CostPerPass != 0 {
+		t.Errorf("unexpected summary: %+v", b)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"io"
 	"github.com/nathants/nina/lib"
+	"github.com/nathants/nina/models"
 	"os"
 	"os/exec"
 	"strings"
@@ -21,6 +22,7 @@ import (
 	"github.com/nathants/nina/providers/groq"
 	"github.com/nathants/nina/providers/oauth"
 	"github.com/nathants/nina/providers/openai"
+	"github.com/nathants/nina/util"
 
 	"github.com/alexflint/go-arg"
 )
@@ -31,11 +33,17 @@ func init() {
 }
 
 type chooseArgs struct {
-	Prompt   string `arg:"positional,required" help:"The prompt describing what to select"`
-	Model    string `arg:"-m,--model" help:"AI model to use" default:"gemini"`
-	NoStream bool   `arg:"-r,--no-stream" help:"Disable streaming"`
-	Debug    bool   `arg:"-d,--debug" help:"Enable debug output (show JSON)"`
-	NoOAuth  bool   `arg:"-n,--no-oauth" help:"Don't use OAuth token if available"`
+	Prompt     string   `arg:"positional,required" help:"The prompt describing what to select"`
+	Model      string   `arg:"-m,--model" help:"AI model to use" default:"gemini"`
+	NoStream   bool     `arg:"-r,--no-stream" help:"Disable streaming"`
+	Debug      bool     `arg:"-d,--debug" help:"Enable debug output (show JSON)"`
+	NoOAuth    bool     `arg:"-n,--no-oauth" help:"Don't use OAuth token if available"`
+	Rank       bool     `arg:"--rank" help:"Rank the candidate responses named on stdin against the prompt instead of selecting files"`
+	Tournament bool     `arg:"--tournament" help:"With --rank, judge every pair of candidates head to head"`
+	Rubric     []string `arg:"--rubric,separate" help:"With --rank, a scoring criterion, repeatable (default correctness, completeness, simplicity)"`
+	Judge      string   `arg:"--judge" help:"With --rank, the judging model, defaults to --model"`
+	JSON       bool     `arg:"--json" help:"With --rank, print the ranking, scores, and rationales as json"`
+	Parallel   int      `arg:"--parallel" default:"4" help:"With --rank, judge calls to run at once"`
 }
 
 func (chooseArgs) Description() string {
@@ -46,38 +54,16 @@ Reads file paths from stdin and uses AI to select relevant files based on prompt
 Example usage:
   find -name "*.go" | nina choose  -m gemini "select files related to authentication"
 
-Supported models (short names):
-  - o3, o3-flex, o3-pro
-  - opus, opus-batch, sonnet, sonnet-batch
-  - o4-mini, o4-mini-flex
-  - gemini, flash
-  - 4.1, 4.1-mini
-  - v0-md, v0-lg
-  - ollama, grok
+With --rank, stdin names candidate responses to the prompt, such as
+several arch outputs, and a judge model scores each one on the rubric.
+With --tournament every pair is also judged head to head, and the
+ranking is by wins with the rubric score breaking ties:
+  ls arch-*.md | nina choose --rank --tournament --judge opus --json "$(cat task.md)"
 
-Note: Models with -flex suffix use OpenAI's flexible service tier.`
-}
+Supported models: ` + strings.Join(models.Aliases(), ", ") + `
+Run 'nina models list' for providers and settings.
 
-var supportedModels = map[string]bool{
-	// Short names only
-	"o3":           true,
-	"o3-flex":      true,
-	"o3-pro":       true,
-	"opus":         true,
-	"opus-batch":   true,
-	"sonnet":       true,
-	"sonnet-batch": true,
-	"o4-mini":      true,
-	"o4-mini-flex": true,
-	"gemini":       true,
-	"flash":        true,
-	"4.1":          true,
-	"4.1-mini":     true,
-	"v0-md":        true,
-	"v0-lg":        true,
-	"ollama":       true,
-	"grok":         true,
-	"k2":           true,
+Note: Models with -flex suffix use OpenAI's flexible service tier.`
 }
 
 func choose() {
@@ -87,13 +73,8 @@ func choose() {
 	// Initialize session for timestamp
 	lib.InitializeSession(false)
 
-	if !supportedModels[args.Model] {
-		fmt.Fprintf(os.Stderr, "Error: unsupported model: %s\n\n", args.Model)
-		fmt.Fprintf(os.Stderr, "Supported models:\n")
-		for model := range supportedModels {
-			fmt.Fprintf(os.Stderr, "  - %s\n", model)
-		}
-		os.Exit(1)
+	if _, err := models.Lookup(args.Model); err != nil {
+		lib.Fatal(err)
 	}
 
 	// Read file paths from stdin
@@ -122,6 +103,13 @@ func choose() {
 		os.Exit(1)
 	}
 
+	if args.Rank {
+		if err := runRank(args, filePaths); err != nil {
+			lib.Fatal(err)
+		}
+		return
+	}
+
 	// Build prompt according to CHOOSE.md schema
 	var promptBuilder strings.Builder
 	promptBuilder.WriteString("<NinaInput>\n\n")
@@ -135,6 +123,10 @@ func choose() {
 			// Skip files that can't be read
 			continue
 		}
+		if err := util.CheckContent(filePath, content); err != nil {
+			util.Errorf("skipping %v", err)
+			continue
+		}
 
 		promptBuilder.WriteString("<NinaFile>\n\n")
 		promptBuilder.WriteString("<NinaPath>\n")
@@ -176,7 +168,7 @@ func choose() {
 
 	// Save input prompt to file
 	inputPath := fmt.Sprintf("%s/%s.input", agentsDir, baseFilename)
-	err = os.WriteFile(inputPath, []byte(prompt), 0644)
+	err = util.WriteLog(inputPath, []byte(prompt))
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error saving input: %v\n", err)
 		os.Exit(1)
@@ -184,8 +176,7 @@ func choose() {
 
 	err = runChoose(args.Model, prompt, !args.NoStream, !args.NoOAuth, args.Debug, agentsDir, baseFilename)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		lib.Fatal(err)
 	}
 }
 
@@ -200,14 +191,14 @@ func runChoose(model, prompt string, stream bool, useOAuth bool, debug bool, age
 	var response string
 	var err error
 
-	response, err = callProvider(provider, modelID, prompt, stream, useOAuth)
+	response, err = callProvider(provider, modelID, buildSystemPrompt(), prompt, stream, useOAuth)
 	if err != nil {
 		return err
 	}
 
 	// Save output response to file (only created if API call succeeds)
 	outputPath := fmt.Sprintf("%s/%s.output", agentsDir, baseFilename)
-	err = os.WriteFile(outputPath, []byte(response), 0644)
+	err = util.WriteLog(outputPath, []byte(response))
 	if err != nil {
 		return fmt.Errorf("failed to save output: %v", err)
 	}
@@ -220,52 +211,15 @@ func runChoose(model, prompt string, stream bool, useOAuth bool, debug bool, age
 	return nil
 }
 
+// parseModel returns the provider and internal model id for a short name
 func parseModel(model string) (provider, modelID string) {
-	switch model {
-	case "o3":
-		return "openai", "o3-high"
-	case "o3-flex":
-		return "openai", "o3-flex"
-	case "o3-pro":
-		return "openai", "o3-pro"
-	case "opus":
-		return "claude", "claude-4-opus-24k-thinking"
-	case "opus-batch":
-		return "claude", "claude-4-opus-batch-24k-thinking"
-	case "sonnet":
-		return "claude", "claude-4-sonnet-24k-thinking"
-	case "sonnet-batch":
-		return "claude", "claude-4-sonnet-batch-24k-thinking"
-	case "o4-mini":
-		return "openai", "o4-mini-medium"
-	case "o4-mini-flex":
-		return "openai", "o4-mini-flex"
-	case "gemini":
-		return "gemini", "gemini-2.5-pro-32k-thinking"
-	case "flash":
-		return "gemini", "gemini-2.5-flash-24k-thinking"
-	case "4.1":
-		return "openai", "gpt-4.1-0.5-temp"
-	case "4.1-mini":
-		return "openai", "gpt-4.1-mini-0.5-temp"
-	case "v0-md":
-		return "v0", "v0-1.5-md"
-	case "v0-lg":
-		return "v0", "v0-1.5-lg"
-	case "ollama":
-		return "ollama", "ollama"
-	case "grok":
-		return "grok", "grok-4-0709"
-	case "k2":
-		return "groq", "moonshotai/kimi-k2-instruct"
-	default:
-		panic("unknown model: " + model)
-	}
+	m := models.MustLookup(model)
+	return m.Provider, m.ID
 }
 
-func callProvider(prov, modelID, message string, stream bool, useOAuth bool) (string, error) {
+func callProvider(prov, modelID, sysPrompt, message string, stream bool, useOAuth bool) (string, error) {
 	ctx := context.Background()
-	sysPrompt := buildSystemPrompt()
+	model, _ := models.ByID(modelID)
 
 	// Setup OAuth for Claude if requested
 	if useOAuth && prov == "claude" {
@@ -302,7 +256,7 @@ func callProvider(prov, modelID, message string, stream bool, useOAuth bool) (st
 		} else {
 			// For other providers, write reasoning to stderr with newlines
 			reasoningCallback = func(data string) {
-				fmt.Fprintln(os.Stderr, data)
+				util.Infof("%s", data)
 			}
 		}
 	}
@@ -310,7 +264,7 @@ func callProvider(prov, modelID, message string, stream bool, useOAuth bool) (st
 	switch prov {
 	case "openai":
 		req := openai.Request{
-			Model: convertOpenAIModelID(modelID),
+			Model: model.APIModel,
 			Input: []openai.ChatMessage{
 				{
 					Type: "message",
@@ -329,25 +283,15 @@ func callProvider(prov, modelID, message string, stream bool, useOAuth bool) (st
 			},
 			Stream: stream,
 		}
-		if isFlexModel(modelID) {
-			req.ServiceTier = "flex"
-		}
-		if isO3Model(modelID) {
-			req.Reasoning = &openai.ReasoningRequest{
-				Summary: "auto",
-				Effort:  "high",
-			}
-		}
-		if isO4MiniModel(modelID) {
+		req.ServiceTier = model.ServiceTier
+		req.Background = model.Background
+		if model.Effort != "" {
 			req.Reasoning = &openai.ReasoningRequest{
 				Summary: "auto",
-				Effort:  "medium",
+				Effort:  model.Effort,
 			}
 		}
-		if is41Model(modelID) {
-			temp := 0.5
-			req.Temperature = &temp
-		}
+		req.Temperature = model.Temperature
 		handleResp, err := openai.Handle(ctx, req, reasoningCallback)
 		if err != nil {
 			return "", err
@@ -356,7 +300,7 @@ func callProvider(prov, modelID, message string, stream bool, useOAuth bool) (st
 
 	case "claude":
 		// Check if this is a batch model
-		if strings.Contains(modelID, "batch") {
+		if model.Batch {
 			// Handle batch models using HandleClaudeBatch
 			messages := []claude.Message{
 				{Role: "user", Content: []claude.Text{{Type: "text", Text: message}}},
@@ -364,13 +308,13 @@ func callProvider(prov, modelID, message string, stream bool, useOAuth bool) (st
 			batchReq := claude.BatchRequestItem{
 				CustomID: "0",
 				Params: claude.BatchParams{
-					Model:     convertClaudeModelID(modelID),
+					Model:     model.APIModel,
 					System:    sysPrompt,
 					Messages:  messages,
-					MaxTokens: 32000,
+					MaxTokens: model.MaxOutput,
 					Thinking: &claude.Thinking{
 						Type:         "enabled",
-						BudgetTokens: 24000,
+						BudgetTokens: model.ThinkingBudget,
 					},
 					UseOAuth: useOAuth,
 				},
@@ -415,7 +359,7 @@ func callProvider(prov, modelID, message string, stream bool, useOAuth bool) (st
 				},
 			}
 			req := claude.Request{
-				Model: convertClaudeModelID(modelID),
+				Model: model.APIModel,
 				System: []claude.Text{
 					{
 						Type:  "text",
@@ -424,10 +368,11 @@ func callProvider(prov, modelID, message string, stream bool, useOAuth bool) (st
 					},
 				},
 				Messages:  messages,
-				MaxTokens: 32000,
+				MaxTokens: model.MaxOutput,
+				Betas:     model.Betas,
 				Thinking: &claude.Thinking{
 					Type:         "enabled",
-					BudgetTokens: 24000,
+					BudgetTokens: model.ThinkingBudget,
 				},
 			}
 			handleResp, err := claude.Handle(ctx, req, reasoningCallback)
@@ -438,15 +383,16 @@ func callProvider(prov, modelID, message string, stream bool, useOAuth bool) (st
 		}
 
 	case "gemini":
-		// Gemini expects messages as a slice of strings
-		messages := []string{message}
-		thinkingBudget := 0
-		if modelID == "gemini-2.5-pro-32k-thinking" {
-			thinkingBudget = 32000
-		} else if modelID == "gemini-2.5-flash-24k-thinking" {
-			thinkingBudget = 24000
+		resp, err := gemini.Handle(ctx, gemini.Request{
+			Model:          model.APIModel,
+			System:         sysPrompt,
+			Contents:       []gemini.Content{gemini.UserText(message)},
+			ThinkingBudget: model.ThinkingBudget,
+		}, reasoningCallback)
+		if err != nil {
+			return "", err
 		}
-		return gemini.Handle(ctx, convertGeminiModelID(modelID), sysPrompt, messages, nil, reasoningCallback, false, thinkingBudget)
+		return resp.Text, nil
 
 	case "grok":
 		messages := []grok.Message{
@@ -460,12 +406,16 @@ func callProvider(prov, modelID, message string, stream bool, useOAuth bool) (st
 			},
 		}
 		req := grok.Request{
-			Model:       modelID,
+			Model:       model.APIModel,
 			Messages:    messages,
 			Stream:      false,
 			Temperature: 0,
 		}
-		return grok.Handle(ctx, req)
+		resp, err := grok.Handle(ctx, req, nil)
+		if err != nil {
+			return "", err
+		}
+		return resp.Text, nil
 
 	case "groq":
 		messages := []groq.Message{
@@ -478,19 +428,21 @@ func callProvider(prov, modelID, message string, stream bool, useOAuth bool) (st
 				Content: message,
 			},
 		}
-		temp := 0.6
 		req := groq.Request{
-			Model:       modelID,
+			Model:       model.APIModel,
 			Messages:    messages,
 			Stream:      stream,
-			Temperature: &temp,
+			Temperature: model.Temperature,
 		}
-		handleResp, err := groq.Handle(ctx, req)
+		handleResp, err := groq.Handle(ctx, req, reasoningCallback)
 		if err != nil {
 			return "", err
 		}
 		return handleResp.Text, nil
 
+	case "ollama":
+		return lib.OllamaChat(ctx, model, sysPrompt, message, reasoningCallback)
+
 	default:
 		return "", fmt.Errorf("unknown provider: %s", prov)
 	}
@@ -500,70 +452,6 @@ func buildSystemPrompt() string {
 	return prompts.Choose()
 }
 
-func convertOpenAIModelID(modelID string) string {
-	// Map internal model IDs to OpenAI model names
-	switch modelID {
-	case "o3-high":
-		return "o3"
-	case "o3-flex":
-		return "o3"
-	case "o3-pro":
-		return "o3-pro"
-	case "o4-mini-medium":
-		return "o4-mini"
-	case "o4-mini-flex":
-		return "o4-mini"
-	case "gpt-4.1-0.5-temp":
-		return "gpt-4.1"
-	case "gpt-4.1-mini-0.5-temp":
-		return "gpt-4.1-mini"
-	default:
-		panic("unknown: " + modelID)
-	}
-}
-
-func convertClaudeModelID(modelID string) string {
-	switch modelID {
-	case "claude-4-opus-24k-thinking":
-		return "claude-opus-4-20250514"
-	case "claude-4-opus-batch-24k-thinking":
-		return "claude-opus-4-20250514"
-	case "claude-4-sonnet-24k-thinking":
-		return "claude-sonnet-4-20250514"
-	case "claude-4-sonnet-batch-24k-thinking":
-		return "claude-sonnet-4-20250514"
-	default:
-		panic("unknown: " + modelID)
-	}
-}
-
-func convertGeminiModelID(modelID string) string {
-	switch modelID {
-	case "gemini-2.5-pro-32k-thinking":
-		return "gemini-2.5-pro"
-	case "gemini-2.5-flash-24k-thinking":
-		return "gemini-2.5-flash"
-	default:
-		panic("unknown: " + modelID)
-	}
-}
-
-func isO3Model(modelID string) bool {
-	return modelID == "o3-high" || modelID == "o3-flex" || modelID == "o3-pro"
-}
-
-func isO4MiniModel(modelID string) bool {
-	return modelID == "o4-mini-medium" || modelID == "o4-mini-flex"
-}
-
-func is41Model(modelID string) bool {
-	return modelID == "gpt-4.1-0.5-temp" || modelID == "gpt-4.1-mini-0.5-temp"
-}
-
-func isFlexModel(modelID string) bool {
-	return strings.HasSuffix(modelID, "-flex")
-}
-
 // Execute git rev-parse to find repository root, fallback to current directory
 // if not in a git repo or if git command fails
 func getGitRoot() string {
@@ -0,0 +1,82 @@
+package choose
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/nathants/nina/lib"
+	"github.com/nathants/nina/models"
+	"github.com/nathants/nina/util"
+)
+
+// runRank ranks the candidate responses in paths with a judge model, see
+// lib.RankCandidates
+func runRank(args chooseArgs, paths []string) error {
+	judgeModel := args.Judge
+	if judgeModel == "" {
+		judgeModel = args.Model
+	}
+	m, err := models.Lookup(judgeModel)
+	if err != nil {
+		return err
+	}
+	var candidates []lib.Candidate
+	for _, path := range paths {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := util.CheckContent(path, content); err != nil {
+			return err
+		}
+		candidates = append(candidates, lib.Candidate{Path: path, Content: string(content)})
+	}
+	if len(candidates) < 2 {
+		return fmt.Errorf("--rank needs at least two candidates, got %d", len(candidates))
+	}
+	rubric := args.Rubric
+	if len(rubric) == 0 {
+		rubric = lib.DefaultRubric
+	}
+
+	var mu sync.Mutex
+	calls := 0
+	judge := func(prompt string) (string, error) {
+		mu.Lock()
+		calls++
+		name := fmt.Sprintf("rank-%s-%03d", judgeModel, calls)
+		mu.Unlock()
+		if err := util.WriteLog(lib.GetTimestampedAgentsPath("choose", name+".input"), []byte(prompt)); err != nil {
+			return "", err
+		}
+		response, err := callProvider(m.Provider, m.ID, lib.JudgeSystemPrompt, prompt, false, !args.NoOAuth)
+		if err != nil {
+			return "", err
+		}
+		return response, util.WriteLog(lib.GetTimestampedAgentsPath("choose", name+".output"), []byte(response))
+	}
+
+	ranking, err := lib.RankCandidates(judge, args.Prompt, candidates, rubric, args.Tournament, args.Parallel)
+	if err != nil {
+		return err
+	}
+	ranking.Judge = judgeModel
+	if args.JSON {
+		data, err := json.MarshalIndent(ranking, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+	for _, c := range ranking.Candidates {
+		if ranking.Mode == "tournament" {
+			fmt.Printf("%d. %s  score %.1f  wins %g\n", c.Rank, c.Path, c.Score, c.Wins)
+		} else {
+			fmt.Printf("%d. %s  score %.1f\n", c.Rank, c.Path, c.Score)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+// clean prunes old session logs under agents/ by age and total size,
+// keeping the current session and those marked .keep
+package clean
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/alexflint/go-arg"
+	"github.com/nathants/nina/lib"
+	"github.com/nathants/nina/util"
+)
+
+func init() {
+	lib.Commands["clean"] = clean
+	lib.Args["clean"] = cleanArgs{}
+}
+
+type cleanArgs struct {
+	MaxAge  string `arg:"-a,--max-age" help:"Remove sessions older than this duration (e.g. 72h, 30d)"`
+	MaxSize string `arg:"-s,--max-size" help:"Remove oldest sessions until agents/ is under this size (e.g. 500M, 2G)"`
+	DryRun  bool   `arg:"-n,--dry-run" help:"Print what would be removed without removing it"`
+}
+
+func (cleanArgs) Description() string {
+	return `clean - Prune old session logs under agents/
+
+Removes session directories from agents/api, text, debug, ask,
+choose, and artifacts, and converter entries from agents/convert-cache
+and agents/convert-corpus, that are older than --max-age, then the oldest
+until the total is under --max-size. At least one limit is required.
+Sessions with a .keep file in any of their directories, e.g.
+agents/api/<id>/.keep, are never removed.
+
+Set NINA_RETENTION_MAX_AGE and/or NINA_RETENTION_MAX_SIZE to
+prune automatically whenever a new session starts.
+
+Examples:
+  nina clean --max-age 30d --dry-run
+  nina clean --max-age 7d
+  nina clean --max-size 1G`
+}
+
+func clean() {
+	var args cleanArgs
+	arg.MustParse(&args)
+	if args.MaxAge == "" && args.MaxSize == "" {
+		fmt.Fprintln(os.Stderr, "Error: pass --max-age and/or --max-size")
+		os.Exit(1)
+	}
+
+	var policy lib.RetentionPolicy
+	var err error
+	if args.MaxAge != "" {
+		if policy.MaxAge, err = lib.ParseAge(args.MaxAge); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: --max-age: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if args.MaxSize != "" {
+		if policy.MaxSize, err = lib.ParseSize(args.MaxSize); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: --max-size: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	pruned, err := lib.PruneSessions(policy, args.DryRun)
+	verb := "removed"
+	if args.DryRun {
+		verb = "would remove"
+	}
+	var freed int64
+	for _, dir := range pruned {
+		fmt.Printf("%s %s\n", verb, dir.Path)
+		freed += dir.Size
+	}
+	if err != nil {
+		lib.Fatal(err)
+	}
+	util.Infof("%s %d sessions, %s", verb, len(pruned), formatBytes(freed))
+}
+
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1fG", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1fM", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1fK", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%dB", n)
+}
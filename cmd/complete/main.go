@@ -0,0 +1,240 @@
+// complete returns fill-in-the-middle completions at a cursor for editor
+// integration. It reads the buffer from a file or stdin, splits it at a byte
+// offset, and asks a fim capable model for candidates with a short deadline.
+// Nothing is logged unless --log is given, only usage is recorded.
+package complete
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/alexflint/go-arg"
+	"github.com/nathants/nina/lib"
+	"github.com/nathants/nina/models"
+	"github.com/nathants/nina/providers/fim"
+	"github.com/nathants/nina/util"
+)
+
+func init() {
+	lib.Commands["complete"] = complete
+	lib.Args["complete"] = completeArgs{}
+}
+
+// context sent around the cursor, trimmed to whole lines
+const (
+	maxPrefix = 16_000
+	maxSuffix = 4_000
+)
+
+type completeArgs struct {
+	File        string        `arg:"positional,required" help:"File being edited"`
+	Offset      int           `arg:"-o,--offset" default:"-1" help:"Cursor byte offset (default: end of buffer)"`
+	Suffix      *string       `arg:"-s,--suffix" help:"Text after the cursor, replacing the rest of the buffer"`
+	Stdin       bool          `arg:"--stdin" help:"Read the buffer from stdin instead of the file, for unsaved edits"`
+	Model       string        `arg:"-m,--model" default:"codestral" help:"FIM capable model"`
+	N           int           `arg:"-n,--candidates" default:"1" help:"Number of candidates to request"`
+	MaxTokens   int           `arg:"--max-tokens" default:"128" help:"Maximum tokens per candidate"`
+	Temperature *float64      `arg:"--temperature" help:"Sampling temperature (default: 0 for one candidate, 0.7 for several)"`
+	Timeout     time.Duration `arg:"-t,--timeout" default:"3s" help:"Give up after this long"`
+	Log         bool          `arg:"--log" help:"Write the request and candidates to agents/complete"`
+}
+
+func (completeArgs) Description() string {
+	return `complete - Fill-in-the-middle code completion
+
+Completes the buffer at the cursor and prints the candidates as json:
+
+  nina complete main.go --offset 1234
+  {"model":"codestral","candidates":["return nil\n"],"latency_ms":180}
+
+Editors pass unsaved buffers on stdin with --stdin, the file name is
+still used for context. --suffix replaces the text after the cursor.
+Candidates are deduplicated and empty ones dropped, so fewer than -n
+may be returned.
+
+Supported models: ` + strings.Join(fimModels(), ", ") + `
+
+Keys: codestral uses CODESTRAL_API_KEY or MISTRAL_API_KEY, deepseek
+uses DEEPSEEK_API_KEY, openai models use OPENAI_API_KEY.`
+}
+
+type result struct {
+	Model      string   `json:"model"`
+	Candidates []string `json:"candidates"`
+	LatencyMS  int64    `json:"latency_ms"`
+}
+
+// fimFunc returns the completion function for a model, nil when the model
+// cannot fill in the middle
+func fimFunc(m models.Model) func(context.Context, fim.Request) (*fim.Response, error) {
+	switch {
+	case m.Batch:
+		return nil
+	case m.Provider == models.ProviderMistral:
+		return fim.Mistral
+	case m.Provider == models.ProviderDeepSeek:
+		return fim.DeepSeek
+	case m.Provider == models.ProviderOpenAI && m.Effort == "":
+		return fim.OpenAI
+	}
+	return nil
+}
+
+func fimModels() []string {
+	var names []string
+	for _, m := range models.All() {
+		if fimFunc(m) != nil {
+			names = append(names, m.Alias)
+		}
+	}
+	return names
+}
+
+// splitBuffer returns the text before and after offset, trimmed to the
+// context limits at line boundaries
+func splitBuffer(buffer string, offset int) (string, string, error) {
+	if offset < 0 {
+		offset = len(buffer)
+	}
+	if offset > len(buffer) {
+		return "", "", fmt.Errorf("offset %d is past the end of the buffer (%d bytes)", offset, len(buffer))
+	}
+	if !utf8.ValidString(buffer[:offset]) || !utf8.ValidString(buffer[offset:]) {
+		return "", "", fmt.Errorf("offset %d is not on a character boundary", offset)
+	}
+	prefix, suffix := buffer[:offset], buffer[offset:]
+	if len(prefix) > maxPrefix {
+		prefix = prefix[len(prefix)-maxPrefix:]
+		if i := strings.IndexByte(prefix, '\n'); i >= 0 {
+			prefix = prefix[i+1:]
+		}
+	}
+	if len(suffix) > maxSuffix {
+		suffix = suffix[:maxSuffix]
+		if i := strings.LastIndexByte(suffix, '\n'); i >= 0 {
+			suffix = suffix[:i+1]
+		}
+	}
+	return prefix, suffix, nil
+}
+
+// candidates requests n completions concurrently, returning the distinct
+// non empty ones in request order
+func candidates(ctx context.Context, call func(context.Context, fim.Request) (*fim.Response, error), apiModel string, req fim.Request, n int) ([]string, error) {
+	responses := make([]*fim.Response, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			responses[i], errs[i] = call(ctx, req)
+		}()
+	}
+	wg.Wait()
+
+	out := []string{}
+	for _, resp := range responses {
+		if resp == nil {
+			continue
+		}
+		lib.RecordUsage(apiModel, lib.TokenUsage{
+			Input:  resp.Usage.PromptTokens - resp.Usage.CachedTokens,
+			Output: resp.Usage.CompletionTokens,
+			Cache:  lib.CacheUsage{Read: resp.Usage.CachedTokens},
+		}, false)
+		if strings.TrimSpace(resp.Text) != "" && !slices.Contains(out, resp.Text) {
+			out = append(out, resp.Text)
+		}
+	}
+	if len(out) == 0 {
+		for _, err := range errs {
+			if err != nil {
+				return nil, err // only fail when every request failed
+			}
+		}
+	}
+	return out, nil
+}
+
+func complete() {
+	var args completeArgs
+	arg.MustParse(&args)
+	start := time.Now()
+
+	m, err := models.Lookup(args.Model)
+	if err != nil {
+		lib.Fatal(err)
+	}
+	call := fimFunc(m)
+	if call == nil {
+		fmt.Fprintf(os.Stderr, "Error: %s does not support completion (supported: %s)\n", args.Model, strings.Join(fimModels(), ", "))
+		os.Exit(1)
+	}
+	if args.N < 1 {
+		fmt.Fprintf(os.Stderr, "Error: --candidates must be at least 1\n")
+		os.Exit(1)
+	}
+
+	var data []byte
+	if args.Stdin {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(args.File)
+	}
+	if err != nil {
+		lib.Fatal(err)
+	}
+	prefix, suffix, err := splitBuffer(string(data), args.Offset)
+	if err != nil {
+		lib.Fatal(err)
+	}
+	if args.Suffix != nil {
+		suffix = *args.Suffix
+	}
+
+	temperature := 0.0
+	if args.N > 1 {
+		temperature = 0.7
+	}
+	if args.Temperature != nil {
+		temperature = *args.Temperature
+	}
+	req := fim.Request{
+		Model:       m.APIModel,
+		Prefix:      prefix,
+		Suffix:      suffix,
+		MaxTokens:   args.MaxTokens,
+		Temperature: temperature,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), args.Timeout)
+	defer cancel()
+	texts, err := candidates(ctx, call, m.APIModel, req, args.N)
+	if err != nil {
+		lib.Fatal(err)
+	}
+
+	out := result{Model: m.Alias, Candidates: texts, LatencyMS: time.Since(start).Milliseconds()}
+	encoded, err := json.Marshal(out)
+	if err != nil {
+		lib.Fatal(err)
+	}
+	fmt.Println(string(encoded))
+
+	if args.Log {
+		record, _ := json.MarshalIndent(map[string]any{"file": args.File, "request": req, "result": out}, "", "  ")
+		path := lib.GetTimestampedAgentsPath("complete", fmt.Sprintf("%d.json", time.Now().UnixNano()))
+		if err := util.WriteLog(path, record); err != nil {
+			util.Errorf("warning: failed to write %s: %v", path, err)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package complete
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/nathants/nina/models"
+	"github.com/nathants/nina/providers/fim"
+)
+
+func TestSplitBuffer(t *testing.T) {
+	prefix, suffix, err := splitBuffer("func main() {\n\t\n}\n", 15)
+	if err != nil || prefix != "func main() {\n\t" || suffix != "\n}\n" {
+		t.Fatalf("got %q %q %v", prefix, suffix, err)
+	}
+	prefix, suffix, err = splitBuffer("abc", -1)
+	if err != nil || prefix != "abc" || suffix != "" {
+		t.Fatalf("got %q %q %v", prefix, suffix, err)
+	}
+	if _, _, err := splitBuffer("abc", 4); err == nil {
+		t.Error("expected an error past the end")
+	}
+	if _, _, err := splitBuffer("é", 1); err == nil {
+		t.Error("expected an error inside a character")
+	}
+
+	long := strings.Repeat("line\n", maxPrefix/5+10)
+	prefix, suffix, err = splitBuffer(long, len(long)/2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prefix) > maxPrefix || !strings.HasPrefix(prefix, "line\n") || len(suffix) > maxSuffix || !strings.HasSuffix(suffix, "line\n") {
+		t.Errorf("context was not trimmed to whole lines: %d %d", len(prefix), len(suffix))
+	}
+}
+
+func TestCandidates(t *testing.T) {
+	t.Setenv("NINA_USAGE_FILE", t.TempDir()+"/usage.jsonl")
+	replies := make(chan string, 4)
+	for _, r := range []string{"return nil", "", "return nil", "return err"} {
+		replies <- r
+	}
+	call := func(ctx context.Context, req fim.Request) (*fim.Response, error) {
+		return &fim.Response{Text: <-replies}, nil
+	}
+	got, err := candidates(context.Background(), call, "codestral-latest", fim.Request{}, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 {
+		t.Errorf("got %q, want the two distinct non empty replies", got)
+	}
+
+	failed := func(ctx context.Context, req fim.Request) (*fim.Response, error) {
+		return nil, errors.New("status 401")
+	}
+	if _, err := candidates(context.Background(), failed, "codestral-latest", fim.Request{}, 2); err == nil {
+		t.Error("expected an error when every request fails")
+	}
+}
+
+func TestFimModels(t *testing.T) {
+	for _, alias := range []string{"codestral", "deepseek", "4.1"} {
+		if fimFunc(models.MustLookup(alias)) == nil {
+			t.Errorf("%s should support completion", alias)
+		}
+	}
+	for _, alias := range []string{"o3", "sonnet"} {
+		if fimFunc(models.MustLookup(alias)) != nil {
+			t.Errorf("%s should not support completion", alias)
+		}
+	}
+}
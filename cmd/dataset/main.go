@@ -0,0 +1,70 @@
+// dataset builds fine-tuning data from successful past sessions, one redacted
+// prompt and NinaOutput pair per line of OpenAI chat JSONL
+package dataset
+
+import (
+	"io"
+	"os"
+
+	"github.com/alexflint/go-arg"
+	"github.com/nathants/nina/lib"
+	"github.com/nathants/nina/util"
+)
+
+func init() {
+	lib.Commands["dataset"] = dataset
+	lib.Args["dataset"] = datasetArgs{}
+}
+
+type datasetArgs struct {
+	Tags       []string `arg:"-t,--tag,separate" help:"Use sessions with this tag, see 'nina sessions tag' (default: good)"`
+	TaggedOnly bool     `arg:"--tagged-only" help:"Only use tagged sessions, not those whose last test command passed"`
+	NoSystem   bool     `arg:"--no-system" help:"Leave the system prompt out of each example"`
+	Output     string   `arg:"-o,--output" help:"File to write, stdout when omitted"`
+}
+
+func (datasetArgs) Description() string {
+	return `dataset - Build fine-tuning data from successful sessions
+
+Reads the sessions index, agents/sessions.jsonl, and uses every
+session whose last test command passed or that has a --tag. Each
+response holding a NinaOutput becomes one line of OpenAI chat JSONL
+with the system prompt, the user message before it, and the
+response. Secrets, email addresses, ip addresses, and user names in
+home directory paths are redacted.
+
+Sessions whose logs under agents/api were pruned are skipped.
+
+Examples:
+  nina sessions tag 20250701-093000 good
+  nina dataset --output train.jsonl
+  nina dataset --tagged-only --tag reviewed --no-system`
+}
+
+func dataset() {
+	var args datasetArgs
+	arg.MustParse(&args)
+
+	filter := lib.DatasetFilter{Tags: args.Tags, Passed: !args.TaggedOnly}
+	if len(filter.Tags) == 0 {
+		filter.Tags = []string{"good"}
+	}
+	pairs, sessions, err := lib.BuildDataset(filter, !args.NoSystem)
+	if err != nil {
+		lib.Fatal(err)
+	}
+
+	var w io.Writer = os.Stdout
+	if args.Output != "" {
+		f, err := os.Create(args.Output)
+		if err != nil {
+			lib.Fatal(err)
+		}
+		defer func() { _ = f.Close() }()
+		w = f
+	}
+	if err := lib.WriteOpenAIJSONL(w, pairs); err != nil {
+		lib.Fatal(err)
+	}
+	util.Infof("wrote %d examples from %d sessions", len(pairs), sessions)
+}
@@ -0,0 +1,463 @@
+// docgen writes doc comments for exported go identifiers through the edit
+// pipeline, sending each file with ARCHITECT.md and applying the NinaChange
+// with ConvertToRangeUpdates. Results are rejected unless the code without
+// comments is unchanged, so only documentation is ever written
+package docgen
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/alexflint/go-arg"
+	"github.com/nathants/nina/lib"
+	"github.com/nathants/nina/models"
+	"github.com/nathants/nina/prompts"
+	"github.com/nathants/nina/util"
+)
+
+func init() {
+	lib.Commands["docgen"] = docgen
+	lib.Args["docgen"] = docgenArgs{}
+}
+
+type docgenArgs struct {
+	Files     []string `arg:"positional,required" help:"go files or globs to document"`
+	Model     string   `arg:"-m,--model" default:"sonnet" help:"AI model to use"`
+	DryRun    bool     `arg:"-n,--dry-run" help:"print a diff instead of writing files"`
+	All       bool     `arg:"-a,--all" help:"rewrite existing doc comments too, not only missing ones"`
+	Overview  bool     `arg:"-o,--overview" help:"add a package doc comment when the package has none"`
+	Readme    string   `arg:"--readme" help:"write a package overview section for the files to this markdown file"`
+	Converter string   `arg:"--converter" help:"model to locate search text, or 'local' for no AI (default: $NINA_CONVERTER_MODEL or sonnet)"`
+	Verbose   bool     `arg:"-v,--verbose" help:"verbose output"`
+}
+
+func (docgenArgs) Description() string {
+	return `docgen - Write doc comments for exported go identifiers
+
+Finds exported functions, methods, types, constants, and variables
+without doc comments and asks the model to document them in the style
+of the surrounding file. Changes that touch anything but comments are
+rejected.
+
+With --readme, also writes a package overview section between
+<!-- nina docgen --> markers in the markdown file, replacing the
+section on later runs and appending it the first time.
+
+Example:
+  nina docgen -n lib/*.go
+  nina docgen --overview --readme README.md util/*.go`
+}
+
+// README section markers, the section between them is replaced on each run
+const (
+	readmeStart = "<!-- nina docgen -->"
+	readmeEnd   = "<!-- /nina docgen -->"
+)
+
+// identifier is an exported declaration that needs a doc comment
+type identifier struct {
+	Name string
+	Line int
+}
+
+// undocumented returns the exported declarations of a file without doc
+// comments, or every exported declaration when all is set
+func undocumented(fset *token.FileSet, file *ast.File, all bool) []identifier {
+	var ids []identifier
+	add := func(name string, doc *ast.CommentGroup, pos token.Pos) {
+		if ast.IsExported(name) && (all || doc == nil) {
+			ids = append(ids, identifier{Name: name, Line: fset.Position(pos).Line})
+		}
+	}
+	for _, decl := range file.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			name := d.Name.Name
+			if d.Recv != nil && len(d.Recv.List) > 0 {
+				recv := receiverType(d.Recv.List[0].Type)
+				if !ast.IsExported(recv) {
+					continue
+				}
+				name = recv + "." + name
+				if !ast.IsExported(d.Name.Name) {
+					continue
+				}
+			}
+			add(name, d.Doc, d.Pos())
+		case *ast.GenDecl:
+			for _, spec := range d.Specs {
+				doc := d.Doc
+				switch s := spec.(type) {
+				case *ast.TypeSpec:
+					if d.Lparen.IsValid() {
+						doc = s.Doc
+					}
+					add(s.Name.Name, doc, s.Pos())
+				case *ast.ValueSpec:
+					if d.Lparen.IsValid() {
+						doc = s.Doc
+						if doc == nil {
+							doc = s.Comment // a trailing comment documents a grouped value
+						}
+					}
+					for _, name := range s.Names {
+						add(name.Name, doc, name.Pos())
+					}
+				}
+			}
+		}
+	}
+	return ids
+}
+
+func receiverType(expr ast.Expr) string {
+	switch t := expr.(type) {
+	case *ast.StarExpr:
+		return receiverType(t.X)
+	case *ast.IndexExpr:
+		return receiverType(t.X)
+	case *ast.IndexListExpr:
+		return receiverType(t.X)
+	case *ast.Ident:
+		return t.Name
+	}
+	return ""
+}
+
+// codeOnly returns the formatted source without comments, to check an edit
+// changed nothing else
+func codeOnly(src string) (string, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", src, 0)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := format.Node(&buf, fset, file); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func formatNinaInput(prompt string, files map[string]string) string {
+	var builder strings.Builder
+	builder.WriteString(util.NinaInputStart)
+	builder.WriteString("\n\n")
+	builder.WriteString(util.NinaPromptStart)
+	builder.WriteString("\n")
+	builder.WriteString(prompt)
+	builder.WriteString("\n")
+	builder.WriteString(util.NinaPromptEnd)
+	builder.WriteString("\n")
+
+	var paths []string
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		builder.WriteString("\n")
+		builder.WriteString(util.FormatNinaFile(path, files[path]))
+	}
+
+	builder.WriteString("\n")
+	builder.WriteString(util.NinaInputEnd)
+	return builder.String()
+}
+
+// applyUpdates applies the model's changes to path one at a time, locating
+// each against the content left by the previous one
+func applyUpdates(ctx context.Context, response, path, content string) (string, error) {
+	updates, err := util.ParseFileUpdates(response)
+	if err != nil && !util.SkipBrokenChanges(err) {
+		return "", fmt.Errorf("failed to parse AI response: %w", err)
+	}
+	for _, update := range updates {
+		if update.FileName != path {
+			util.Errorf("warning: dropping change to %s, only %s is documented", update.FileName, path)
+			continue
+		}
+		session := &util.SessionState{
+			OrigFiles:     map[string]string{path: content},
+			SelectedFiles: map[string]string{},
+			PathMap:       map[string]string{path: path},
+		}
+		ranged, err := lib.ConvertToRangeUpdates(ctx, []util.FileUpdate{update}, session, nil)
+		if err != nil {
+			return "", fmt.Errorf("failed to convert to range updates for %s: %w", path, err)
+		}
+		content, err = util.ApplyFileUpdates(content, ranged)
+		if err != nil {
+			return "", fmt.Errorf("failed to apply updates to %s: %w", path, err)
+		}
+	}
+	return content, nil
+}
+
+// diff returns a unified diff of a file's old and new content
+func diff(path, before, after string) (string, error) {
+	dir, err := os.MkdirTemp("", "nina-docgen-")
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	oldPath, newPath := filepath.Join(dir, "old"), filepath.Join(dir, "new")
+	if err := os.WriteFile(oldPath, []byte(before), 0600); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(newPath, []byte(after), 0600); err != nil {
+		return "", err
+	}
+	out, err := exec.Command("diff", "-u", "--label", "a"+path, "--label", "b"+path, oldPath, newPath).Output()
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+		err = nil // differences found
+	}
+	return string(out), err
+}
+
+// docPrompt asks for doc comments on ids, and a package comment if needed
+func docPrompt(path string, ids []identifier, packageDoc bool) string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "Write go doc comments in %s.\n\n", path)
+	if len(ids) > 0 {
+		builder.WriteString("Document these exported identifiers:\n")
+		for _, id := range ids {
+			fmt.Fprintf(&builder, "- %s (line %d)\n", id.Name, id.Line)
+		}
+		builder.WriteString("\n")
+	}
+	if packageDoc {
+		builder.WriteString("Add a package doc comment above the package clause giving an overview of what the package is for and how its parts fit together.\n\n")
+	}
+	builder.WriteString(`Rules:
+- Only add or change comment lines, never change code, imports, or formatting.
+- Follow go conventions, each comment is a full sentence starting with the identifier's name.
+- Match the length and register of the comments already in the file. Say what a caller needs to know, not how it is implemented.
+- Replace an existing doc comment only when it is listed above.`)
+	return builder.String()
+}
+
+// documentFile asks the model to document a file and returns the new content,
+// unchanged when there is nothing to document
+func documentFile(ctx context.Context, args docgenArgs, path string, systemPrompt, codingPrompt string, packageDoc bool) (string, string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", "", err
+	}
+	content := string(data)
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, path, content, parser.ParseComments)
+	if err != nil {
+		return "", "", err
+	}
+	ids := undocumented(fset, file, args.All)
+	packageDoc = packageDoc && file.Doc == nil
+	if len(ids) == 0 && !packageDoc {
+		util.Verbosef("%s: nothing to document", path)
+		return content, content, nil
+	}
+
+	provider, model, err := lib.CreateProviderForModel(args.Model)
+	if err != nil {
+		return "", "", err
+	}
+	message := codingPrompt + "\n\n" + formatNinaInput(docPrompt(path, ids, packageDoc), map[string]string{path: content})
+	util.Verbosef("%s: documenting %d identifiers", path, len(ids))
+	response, err := lib.CallAIProvider(provider, model, systemPrompt, message, &lib.LoopState{}, false)
+	if err != nil {
+		return "", "", fmt.Errorf("AI request failed: %w", err)
+	}
+	updated, err := applyUpdates(ctx, response, path, content)
+	if err != nil {
+		return "", "", err
+	}
+	formatted, err := format.Source([]byte(updated))
+	if err != nil {
+		return "", "", fmt.Errorf("%s no longer parses after the edit: %w", path, err)
+	}
+	updated = string(formatted)
+
+	before, err := codeOnly(content)
+	if err != nil {
+		return "", "", err
+	}
+	after, err := codeOnly(updated)
+	if err != nil {
+		return "", "", err
+	}
+	if before != after {
+		return "", "", fmt.Errorf("model changed code in %s, not only comments", path)
+	}
+	return content, updated, nil
+}
+
+// writeReadme replaces the docgen section of a markdown file, appending it
+// when the file or section does not exist yet
+func writeReadme(path, section string, dryRun bool) error {
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	before := string(data)
+	block := readmeStart + "\n" + strings.TrimSpace(section) + "\n" + readmeEnd
+	var after string
+	start, end := strings.Index(before, readmeStart), strings.Index(before, readmeEnd)
+	if start >= 0 && end > start {
+		after = before[:start] + block + before[end+len(readmeEnd):]
+	} else {
+		after = before
+		if after != "" && !strings.HasSuffix(after, "\n\n") {
+			after = strings.TrimRight(after, "\n") + "\n\n"
+		}
+		after += block + "\n"
+	}
+	if dryRun {
+		out, err := diff(path, before, after)
+		fmt.Print(out)
+		return err
+	}
+	return os.WriteFile(path, []byte(after), 0644)
+}
+
+// readmeSection asks the model for a markdown overview of the files
+func readmeSection(args docgenArgs, files map[string]string) (string, error) {
+	provider, model, err := lib.CreateProviderForModel(args.Model)
+	if err != nil {
+		return "", err
+	}
+	prompt := `Write a markdown section for the project README giving an overview of these go packages:
+what each is for, its main types and functions, and how they fit together.
+Start with a level two heading. Reply with only the markdown, no NinaChange tags and no code fences around the whole reply.`
+	systemPrompt, err := prompts.EmbeddedFiles.ReadFile("EXPLAIN.md")
+	if err != nil {
+		return "", err
+	}
+	return lib.CallAIProvider(provider, model, string(systemPrompt), formatNinaInput(prompt, files), &lib.LoopState{}, false)
+}
+
+func run(args docgenArgs) error {
+	ctx := context.Background()
+	var paths []string
+	for _, pattern := range args.Files {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern %s: %w", pattern, err)
+		}
+		if len(matches) == 0 {
+			matches = []string{pattern}
+		}
+		for _, path := range matches {
+			if !strings.HasSuffix(path, ".go") || strings.HasSuffix(path, "_test.go") {
+				continue
+			}
+			absPath, err := filepath.Abs(path)
+			if err != nil {
+				return err
+			}
+			paths = append(paths, absPath)
+		}
+	}
+	if len(paths) == 0 {
+		return fmt.Errorf("no go files to document")
+	}
+	sort.Strings(paths)
+
+	codingPrompt, err := prompts.EmbeddedFiles.ReadFile("CODING.md")
+	if err != nil {
+		return fmt.Errorf("failed to read CODING.md prompt: %w", err)
+	}
+	architectPrompt, err := prompts.EmbeddedFiles.ReadFile("ARCHITECT.md")
+	if err != nil {
+		return fmt.Errorf("failed to read ARCHITECT.md prompt: %w", err)
+	}
+
+	// a package comment goes in the first file of a package that has none
+	needsPackageDoc := map[string]bool{}
+	if args.Overview {
+		for _, path := range paths {
+			needsPackageDoc[filepath.Dir(path)] = true
+		}
+		for _, path := range paths {
+			file, err := parser.ParseFile(token.NewFileSet(), path, nil, parser.PackageClauseOnly|parser.ParseComments)
+			if err == nil && file.Doc != nil {
+				needsPackageDoc[filepath.Dir(path)] = false
+			}
+		}
+	}
+
+	files := map[string]string{}
+	var failed []string
+	for _, path := range paths {
+		dir := filepath.Dir(path)
+		before, after, err := documentFile(ctx, args, path, string(architectPrompt), string(codingPrompt), needsPackageDoc[dir])
+		if err != nil {
+			util.Errorf("warning: %v", err)
+			failed = append(failed, path)
+			continue
+		}
+		files[path] = after
+		if before == after {
+			continue
+		}
+		needsPackageDoc[dir] = false
+		if args.DryRun {
+			out, err := diff(path, before, after)
+			if err != nil {
+				return err
+			}
+			fmt.Print(out)
+			continue
+		}
+		if err := util.CheckPathAllowed(path); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, []byte(after), 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", path, err)
+		}
+		util.Infof("documented %s", path)
+	}
+
+	if args.Readme != "" && len(files) > 0 {
+		section, err := readmeSection(args, files)
+		if err != nil {
+			return fmt.Errorf("AI request failed: %w", err)
+		}
+		if err := writeReadme(args.Readme, section, args.DryRun); err != nil {
+			return err
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to document %d files: %s", len(failed), strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+func docgen() {
+	var args docgenArgs
+	arg.MustParse(&args)
+
+	if args.Verbose {
+		util.SetLogLevel(max(util.GetLogLevel(), util.LogVerbose))
+	}
+	if args.Converter != "" {
+		_ = os.Setenv("NINA_CONVERTER_MODEL", args.Converter)
+	}
+	if _, err := models.Lookup(args.Model); err != nil {
+		lib.Fatal(err)
+	}
+	lib.InitializeSession(false)
+
+	if err := run(args); err != nil {
+		lib.Fatal(err)
+	}
+}
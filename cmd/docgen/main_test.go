@@ -0,0 +1,86 @@
+package docgen
+
+import (
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const source = `package a
+
+// Documented is documented
+func Documented() {}
+
+func Missing() {}
+
+func unexported() {}
+
+type T struct{}
+
+func (t *T) Method() {}
+
+func (t *T) hidden() {}
+
+const (
+	// A is documented
+	A = 1
+	B = 2 // B is documented
+	C = 3
+)
+
+var X, Y int
+`
+
+func TestUndocumented(t *testing.T) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "a.go", source, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, id := range undocumented(fset, file, false) {
+		names = append(names, id.Name)
+	}
+	if got := strings.Join(names, " "); got != "Missing T T.Method C X Y" {
+		t.Errorf("got %s", got)
+	}
+	if got := len(undocumented(fset, file, true)); got != 9 {
+		t.Errorf("got %d identifiers with --all, want 9", got)
+	}
+}
+
+func TestCodeOnly(t *testing.T) {
+	before, err := codeOnly(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commented, err := codeOnly(strings.Replace(source, "func Missing", "// Missing is now documented\nfunc Missing", 1))
+	if err != nil || commented != before {
+		t.Errorf("adding a comment changed the code: %v", err)
+	}
+	changed, err := codeOnly(strings.Replace(source, "func Missing() {}", "func Missing() { panic(1) }", 1))
+	if err != nil || changed == before {
+		t.Errorf("changing code was not detected: %v", err)
+	}
+}
+
+func TestWriteReadme(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "README.md")
+	if err := os.WriteFile(path, []byte("# project\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeReadme(path, "## Packages\n\nfirst", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeReadme(path, "## Packages\n\nsecond\n", false); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := os.ReadFile(path)
+	want := "# project\n\n" + readmeStart + "\n## Packages\n\nsecond\n" + readmeEnd + "\n"
+	if string(got) != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,391 @@
+// doctor validates nina configuration end to end as nina subcommand
+// checks provider credentials, makes minimal test calls, and verifies local
+// tooling, printing a pass/fail table with remediation hints
+package doctor
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/alexflint/go-arg"
+	"github.com/nathants/nina/lib"
+	"github.com/nathants/nina/providers/claude"
+	"github.com/nathants/nina/providers/gemini"
+	"github.com/nathants/nina/providers/grok"
+	"github.com/nathants/nina/providers/groq"
+	"github.com/nathants/nina/providers/oauth"
+	"github.com/nathants/nina/providers/openai"
+	"github.com/nathants/nina/util"
+	"github.com/tiktoken-go/tokenizer"
+)
+
+func init() {
+	lib.Commands["doctor"] = doctor
+	lib.Args["doctor"] = doctorArgs{}
+}
+
+type doctorArgs struct {
+	Offline bool `arg:"-o,--offline" help:"Skip live test calls to providers"`
+	Timeout int  `arg:"-t,--timeout" default:"60" help:"Seconds to wait for each test call"`
+}
+
+func (doctorArgs) Description() string {
+	return `doctor - Validate nina configuration end to end
+
+Checks credentials for each provider, makes a minimal test call
+with each configured provider, and verifies git, the agents
+directory, and the tokenizer. Providers without credentials are
+skipped.
+
+Exits non-zero if no provider is configured or any check fails.`
+}
+
+const (
+	statusPass = "PASS"
+	statusFail = "FAIL"
+	statusSkip = "SKIP"
+)
+
+// check is one row of the doctor report
+type check struct {
+	Name   string
+	Status string
+	Detail string
+	Hint   string
+}
+
+// providerCheck describes how to find credentials for a provider and how to
+// make a minimal call against it
+type providerCheck struct {
+	Name     string
+	EnvVars  []string
+	OAuth    func() (string, error)
+	OAuthEnv string
+	Hint     string
+	Call     func(ctx context.Context) (string, error)
+}
+
+const pingSystem = "Reply with the single word: ok"
+const pingMessage = "ping"
+
+var providerChecks = []providerCheck{
+	{
+		Name:     "anthropic",
+		EnvVars:  []string{"ANTHROPIC_API_KEY", "CLAUDE_KEY"},
+		OAuth:    oauth.AnthropicAccess,
+		OAuthEnv: "ANTHROPIC_OAUTH_TOKEN",
+		Hint:     "set ANTHROPIC_API_KEY or run `nina auth login anthropic`",
+		Call: func(ctx context.Context) (string, error) {
+			resp, err := claude.Handle(ctx, claude.Request{
+				Model:     "claude-sonnet-4-20250514",
+				System:    []claude.Text{{Type: "text", Text: pingSystem}},
+				Messages:  []claude.Message{{Role: "user", Content: []claude.Text{{Type: "text", Text: pingMessage}}}},
+				MaxTokens: 16,
+			}, nil)
+			if err != nil {
+				return "", err
+			}
+			return resp.Text, nil
+		},
+	},
+	{
+		Name:     "openai",
+		EnvVars:  []string{"OPENAI_API_KEY", "OPENAI_KEY"},
+		OAuth:    oauth.OpenAIAccess,
+		OAuthEnv: "OPENAI_OAUTH_TOKEN",
+		Hint:     "set OPENAI_API_KEY or run `nina auth login openai`",
+		Call: func(ctx context.Context) (string, error) {
+			resp, err := openai.Handle(ctx, openai.Request{
+				Model: "gpt-4.1-mini",
+				Input: []openai.ChatMessage{
+					{Type: "message", Role: "system", Content: []openai.ContentPart{{Type: "input_text", Text: pingSystem}}},
+					{Type: "message", Role: "user", Content: []openai.ContentPart{{Type: "input_text", Text: pingMessage}}},
+				},
+				MaxOutputTokens: util.Ptr(16),
+				User:            "nina",
+			}, nil)
+			if err != nil {
+				return "", err
+			}
+			return resp.Text, nil
+		},
+	},
+	{
+		Name:     "gemini",
+		EnvVars:  []string{"GOOGLE_API_KEY", "GOOGLE_AISTUDIO_TOKEN"},
+		OAuth:    oauth.GeminiAccess,
+		OAuthEnv: "GEMINI_OAUTH_TOKEN",
+		Hint:     "set GOOGLE_API_KEY or run `nina auth login gemini`",
+		Call: func(ctx context.Context) (string, error) {
+			resp, err := gemini.Handle(ctx, gemini.Request{
+				Model:      "gemini-2.5-flash",
+				System:     pingSystem,
+				Contents:   []gemini.Content{gemini.UserText(pingMessage)},
+				NoThoughts: true,
+			}, nil)
+			if err != nil {
+				return "", err
+			}
+			return resp.Text, nil
+		},
+	},
+	{
+		Name:    "grok",
+		EnvVars: []string{"XAI_API_KEY"},
+		Hint:    "set XAI_API_KEY",
+		Call: func(ctx context.Context) (string, error) {
+			resp, err := grok.Handle(ctx, grok.Request{
+				Model: "grok-4-0709",
+				Messages: []grok.Message{
+					{Role: "system", Content: pingSystem},
+					{Role: "user", Content: pingMessage},
+				},
+			}, nil)
+			if err != nil {
+				return "", err
+			}
+			return resp.Text, nil
+		},
+	},
+	{
+		Name:    "groq",
+		EnvVars: []string{"GROQ_API_KEY", "GROQ_KEY"},
+		Hint:    "set GROQ_API_KEY",
+		Call: func(ctx context.Context) (string, error) {
+			resp, err := groq.Handle(ctx, groq.Request{
+				Model: "moonshotai/kimi-k2-instruct",
+				Messages: []groq.Message{
+					{Role: "system", Content: pingSystem},
+					{Role: "user", Content: pingMessage},
+				},
+				MaxTokens: util.Ptr(16),
+			}, nil)
+			if err != nil {
+				return "", err
+			}
+			return resp.Text, nil
+		},
+	},
+}
+
+// credentialSource returns a description of where credentials for the
+// provider come from, or empty string if none are configured. OAuth tokens
+// are exported to the environment so providers pick them up like ask does.
+func credentialSource(p providerCheck) string {
+	for _, env := range p.EnvVars {
+		if os.Getenv(env) != "" {
+			return env
+		}
+	}
+	if p.OAuth != nil {
+		if token, err := p.OAuth(); err == nil && token != "" {
+			_ = os.Setenv(p.OAuthEnv, token)
+			return "oauth"
+		}
+	}
+	return ""
+}
+
+// checkProviders checks the credentials of each provider and makes a test
+// call with each configured one. Providers without credentials are skipped,
+// it fails when none has any.
+func checkProviders(providers []providerCheck, offline bool, timeout time.Duration) []check {
+	var checks []check
+	configured := 0
+	for _, p := range providers {
+		source := credentialSource(p)
+		if source == "" {
+			checks = append(checks, check{
+				Name:   p.Name + " credentials",
+				Status: statusSkip,
+				Detail: "not configured",
+				Hint:   p.Hint,
+			})
+			continue
+		}
+		configured++
+		checks = append(checks, check{
+			Name:   p.Name + " credentials",
+			Status: statusPass,
+			Detail: "using " + source,
+		})
+		if offline {
+			checks = append(checks, check{
+				Name:   p.Name + " test call",
+				Status: statusSkip,
+				Detail: "offline",
+			})
+			continue
+		}
+		checks = append(checks, callProvider(p, timeout))
+	}
+	if configured == 0 {
+		checks = append(checks, check{
+			Name:   "providers",
+			Status: statusFail,
+			Detail: "no provider has credentials",
+			Hint:   "set an api key like ANTHROPIC_API_KEY or run `nina auth login`",
+		})
+	}
+	return checks
+}
+
+func callProvider(p providerCheck, timeout time.Duration) (c check) {
+	c.Name = p.Name + " test call"
+	defer func() {
+		if r := recover(); r != nil {
+			c.Status = statusFail
+			c.Detail = fmt.Sprintf("panic: %v", r)
+			c.Hint = p.Hint
+		}
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	start := time.Now()
+	text, err := p.Call(ctx)
+	if err != nil {
+		c.Status = statusFail
+		c.Detail = firstLine(err.Error())
+		c.Hint = "verify the credentials are valid and the account has access"
+		return c
+	}
+	c.Status = statusPass
+	c.Detail = fmt.Sprintf("%.1fs, replied %q", time.Since(start).Seconds(), firstLine(strings.TrimSpace(text)))
+	return c
+}
+
+func checkGit() check {
+	c := check{Name: "git"}
+	path, err := exec.LookPath("git")
+	if err != nil {
+		c.Status = statusFail
+		c.Detail = "git not found in PATH"
+		c.Hint = "install git"
+		return c
+	}
+	out, err := exec.Command(path, "--version").Output()
+	if err != nil {
+		c.Status = statusFail
+		c.Detail = fmt.Sprintf("git --version failed: %v", err)
+		c.Hint = "reinstall git"
+		return c
+	}
+	c.Status = statusPass
+	c.Detail = strings.TrimSpace(string(out))
+	if util.GetGitRoot() == "" {
+		c.Detail += ", not inside a git repo"
+	}
+	return c
+}
+
+func checkAgentsDir() check {
+	dir := util.GetAgentsDir()
+	c := check{Name: "agents dir"}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		c.Status = statusFail
+		c.Detail = fmt.Sprintf("cannot create %s: %v", dir, err)
+		c.Hint = "check permissions on the repo root"
+		return c
+	}
+	f, err := os.CreateTemp(dir, ".doctor-*")
+	if err != nil {
+		c.Status = statusFail
+		c.Detail = fmt.Sprintf("cannot write to %s: %v", dir, err)
+		c.Hint = "check permissions on " + dir
+		return c
+	}
+	_ = f.Close()
+	_ = os.Remove(f.Name())
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		abs = dir
+	}
+	c.Status = statusPass
+	c.Detail = abs
+	return c
+}
+
+func checkTokenizer() check {
+	c := check{Name: "tokenizer"}
+	enc, err := tokenizer.Get(tokenizer.O200kBase)
+	if err != nil {
+		c.Status = statusFail
+		c.Detail = fmt.Sprintf("failed to load o200k: %v", err)
+		c.Hint = "rebuild nina with `go install github.com/nathants/nina@latest`"
+		return c
+	}
+	ids, _, err := enc.Encode("hello world")
+	if err != nil || len(ids) == 0 {
+		c.Status = statusFail
+		c.Detail = fmt.Sprintf("failed to encode sample text: %v", err)
+		c.Hint = "rebuild nina with `go install github.com/nathants/nina@latest`"
+		return c
+	}
+	c.Status = statusPass
+	c.Detail = "o200k loaded"
+	return c
+}
+
+func firstLine(s string) string {
+	s, _, _ = strings.Cut(s, "\n")
+	if len(s) > 80 {
+		s = s[:77] + "..."
+	}
+	return s
+}
+
+func printChecks(checks []check) {
+	nameWidth := len("CHECK")
+	for _, c := range checks {
+		nameWidth = max(nameWidth, len(c.Name))
+	}
+	fmtStr := "%-" + fmt.Sprint(nameWidth) + "s  %s%-4s%s  %s\n"
+	fmt.Printf("%-"+fmt.Sprint(nameWidth)+"s  %-4s  %s\n", "CHECK", "STATUS", "DETAIL")
+	useColor := util.ColorEnabled(os.Stdout)
+	for _, c := range checks {
+		color, reset := lib.ColorGreen, lib.ColorReset
+		switch c.Status {
+		case statusFail:
+			color = lib.ColorRed
+		case statusSkip:
+			color = lib.ColorYellow
+		}
+		if !useColor {
+			color, reset = "", ""
+		}
+		fmt.Printf(fmtStr, c.Name, color, c.Status, reset, c.Detail)
+		if c.Hint != "" {
+			fmt.Printf("%-"+fmt.Sprint(nameWidth)+"s  %-4s  hint: %s\n", "", "", c.Hint)
+		}
+	}
+}
+
+func doctor() {
+	var args doctorArgs
+	arg.MustParse(&args)
+
+	checks := []check{
+		checkGit(),
+		checkAgentsDir(),
+		checkTokenizer(),
+	}
+	checks = append(checks, checkProviders(providerChecks, args.Offline, time.Duration(args.Timeout)*time.Second)...)
+
+	printChecks(checks)
+	os.Exit(exitStatus(checks))
+}
+
+// exitStatus is 1 when a check failed, skipped checks do not fail
+func exitStatus(checks []check) int {
+	for _, c := range checks {
+		if c.Status == statusFail {
+			return 1
+		}
+	}
+	return 0
+}
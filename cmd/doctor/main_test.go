@@ -0,0 +1,70 @@
+package doctor
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCredentialSource(t *testing.T) {
+	oauth := func(token string, err error) func() (string, error) {
+		return func() (string, error) { return token, err }
+	}
+	tests := []struct {
+		name string
+		env  map[string]string
+		p    providerCheck
+		want string
+	}{
+		{"none", nil, providerCheck{EnvVars: []string{"DOCTOR_KEY"}}, ""},
+		{"first env var", map[string]string{"DOCTOR_KEY": "k", "DOCTOR_OLD_KEY": "o"}, providerCheck{EnvVars: []string{"DOCTOR_KEY", "DOCTOR_OLD_KEY"}}, "DOCTOR_KEY"},
+		{"fallback env var", map[string]string{"DOCTOR_OLD_KEY": "o"}, providerCheck{EnvVars: []string{"DOCTOR_KEY", "DOCTOR_OLD_KEY"}}, "DOCTOR_OLD_KEY"},
+		{"env before oauth", map[string]string{"DOCTOR_KEY": "k"}, providerCheck{EnvVars: []string{"DOCTOR_KEY"}, OAuth: oauth("t", nil), OAuthEnv: "DOCTOR_OAUTH"}, "DOCTOR_KEY"},
+		{"oauth", nil, providerCheck{EnvVars: []string{"DOCTOR_KEY"}, OAuth: oauth("t", nil), OAuthEnv: "DOCTOR_OAUTH"}, "oauth"},
+		{"oauth error", nil, providerCheck{EnvVars: []string{"DOCTOR_KEY"}, OAuth: oauth("", errors.New("expired")), OAuthEnv: "DOCTOR_OAUTH"}, ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, env := range []string{"DOCTOR_KEY", "DOCTOR_OLD_KEY", "DOCTOR_OAUTH"} {
+				t.Setenv(env, tc.env[env])
+			}
+			if got := credentialSource(tc.p); got != tc.want {
+				t.Errorf("credentialSource() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestExitStatus(t *testing.T) {
+	t.Setenv("DOCTOR_KEY", "")
+	t.Setenv("DOCTOR_OTHER_KEY", "")
+	ok := func(context.Context) (string, error) { return "ok", nil }
+	bad := func(context.Context) (string, error) { return "", errors.New("401 unauthorized") }
+	tests := []struct {
+		name      string
+		env       string
+		providers []providerCheck
+		offline   bool
+		want      int
+	}{
+		{"none configured", "", []providerCheck{{Name: "a", EnvVars: []string{"DOCTOR_KEY"}, Call: ok}}, false, 1},
+		{"one configured", "DOCTOR_KEY", []providerCheck{{Name: "a", EnvVars: []string{"DOCTOR_KEY"}, Call: ok}, {Name: "b", EnvVars: []string{"DOCTOR_OTHER_KEY"}, Call: bad}}, false, 0},
+		{"configured call fails", "DOCTOR_KEY", []providerCheck{{Name: "a", EnvVars: []string{"DOCTOR_KEY"}, Call: bad}}, false, 1},
+		{"offline", "DOCTOR_KEY", []providerCheck{{Name: "a", EnvVars: []string{"DOCTOR_KEY"}, Call: bad}}, true, 0},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.env != "" {
+				t.Setenv(tc.env, "key")
+			}
+			checks := checkProviders(tc.providers, tc.offline, time.Second)
+			if got := exitStatus(checks); got != tc.want {
+				t.Errorf("exitStatus() = %d, want %d for %+v", got, tc.want, checks)
+			}
+		})
+	}
+	if got := exitStatus([]check{{Status: statusPass}, {Status: statusSkip}}); got != 0 {
+		t.Errorf("skipped checks should not fail, got %d", got)
+	}
+}
@@ -1,5 +1,6 @@
 // edit converts search/replace into line edits via ConvertToRangeUpdates
-// takes search file, replace file, and target file to perform replacements
+// takes search file, replace file, and target file to perform replacements,
+// or the target file with any number of search/replace blocks on stdin
 // uses lib.ConvertToRangeUpdates for accurate line-based editing
 package edit
 
@@ -8,6 +9,8 @@ import (
 	"fmt"
 	"github.com/nathants/nina/lib"
 	util "github.com/nathants/nina/util"
+	"github.com/nathants/nina/workspace"
+	"io"
 	"os"
 	"strings"
 
@@ -20,77 +23,216 @@ func init() {
 }
 
 type editArgs struct {
-	Search  string `arg:"positional,required" help:"file with search text"`
-	Replace string `arg:"positional,required" help:"file with replacement text"`
-	Target  string `arg:"positional,required" help:"file to edit"`
+	Paths     []string `arg:"positional,required" help:"search file, replace file, and target file, or only the target file with search/replace blocks on stdin"`
+	DryRun    bool     `arg:"-n,--dry-run" help:"print a diff of the edits without writing the target"`
+	Converter string   `arg:"--converter" help:"model to locate search text, or 'local' for no AI (default: $NINA_CONVERTER_MODEL or sonnet)"`
+	Exec      string   `arg:"--exec" help:"where target is read and written: local or ssh://[user@]host[:path]"`
 }
 
 func (editArgs) Description() string {
 	return `edit - Edit a file via search and replace
 
 Search must be a single section of entire contiguous lines as text.
-Replace will replace those lines in Target.`
+Replace will replace those lines in Target. Either search or replace
+may be - to read it from stdin.
+
+With only a target, stdin holds any number of blocks, applied in
+order, each edit seeing the result of the ones before it:
+
+  <<<<<<< SEARCH
+  lines to find
+  =======
+  lines to replace them with
+  >>>>>>> REPLACE
+
+Nothing is written unless every block applies.
+
+Exact matches are applied locally, otherwise an AI model locates
+the search text. Use --converter local to never call AI.
+
+Example:
+  nina edit main.go <<'EOF'
+  <<<<<<< SEARCH
+  const retries = 3
+  =======
+  const retries = 5
+  >>>>>>> REPLACE
+  EOF`
+}
+
+// block markers of the search/replace pairs read from stdin
+const (
+	searchMarker  = "<<<<<<< SEARCH"
+	dividerMarker = "======="
+	replaceMarker = ">>>>>>> REPLACE"
+)
+
+// pair is one search and its replacement
+type pair struct {
+	search  []string
+	replace []string
+}
+
+func splitLines(text string) []string {
+	text = strings.TrimSuffix(text, "\n")
+	if text == "" {
+		return []string{}
+	}
+	return strings.Split(text, "\n")
 }
 
 func readLines(path string) ([]string, error) {
-	data, err := os.ReadFile(path)
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
 	if err != nil {
 		return nil, err
 	}
-	text := strings.TrimSuffix(string(data), "\n")
-	if text == "" {
-		return []string{}, nil
+	return splitLines(string(data)), nil
+}
+
+// parseBlocks returns the search/replace blocks of text, blank lines between
+// blocks are ignored and anything else is an error
+func parseBlocks(text string) ([]pair, error) {
+	var pairs []pair
+	var current *pair
+	inReplace := false
+	for i, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		marker := strings.TrimRight(line, " \t")
+		switch {
+		case current == nil && marker == searchMarker:
+			current = &pair{search: []string{}, replace: []string{}}
+		case current == nil && strings.TrimSpace(line) == "":
+		case current == nil:
+			return nil, fmt.Errorf("line %d: expected %s, got %q", i+1, searchMarker, line)
+		case !inReplace && marker == dividerMarker:
+			inReplace = true
+		case inReplace && marker == replaceMarker:
+			pairs = append(pairs, *current)
+			current, inReplace = nil, false
+		case inReplace:
+			current.replace = append(current.replace, line)
+		default:
+			current.search = append(current.search, line)
+		}
+	}
+	if current != nil {
+		return nil, fmt.Errorf("block %d is missing %s", len(pairs)+1, replaceMarker)
+	}
+	if len(pairs) == 0 {
+		return nil, fmt.Errorf("no %s blocks on stdin", searchMarker)
+	}
+	return pairs, nil
+}
+
+// readPairs returns the search/replace pairs named by paths, the target is
+// the last path
+func readPairs(paths []string) ([]pair, error) {
+	switch len(paths) {
+	case 1:
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, fmt.Errorf("reading stdin: %w", err)
+		}
+		return parseBlocks(string(data))
+	case 3:
+		if paths[0] == "-" && paths[1] == "-" {
+			return nil, fmt.Errorf("only one of search and replace can be read from stdin")
+		}
+		search, err := readLines(paths[0])
+		if err != nil {
+			return nil, err
+		}
+		replace, err := readLines(paths[1])
+		if err != nil {
+			return nil, err
+		}
+		return []pair{{search: search, replace: replace}}, nil
+	default:
+		return nil, fmt.Errorf("expected search, replace, and target files, or only a target file, got %d paths", len(paths))
+	}
+}
+
+// applyPairs applies each pair to content in order
+func applyPairs(ctx context.Context, target, content string, pairs []pair) (string, error) {
+	for i, p := range pairs {
+		update := util.FileUpdate{
+			FileName:     target,
+			SearchLines:  p.search,
+			ReplaceLines: p.replace,
+		}
+
+		session := &util.SessionState{
+			OrigFiles:     map[string]string{target: content},
+			SelectedFiles: map[string]string{},
+			PathMap:       map[string]string{target: target},
+		}
+
+		updates, err := lib.ConvertToRangeUpdates(ctx, []util.FileUpdate{update}, session, nil)
+		if err != nil {
+			return "", blockError(i, len(pairs), err)
+		}
+
+		content, err = util.ApplyFileUpdates(content, updates)
+		if err != nil {
+			return "", blockError(i, len(pairs), err)
+		}
+	}
+	return content, nil
+}
+
+// blockError names the failing block when there are several
+func blockError(i, n int, err error) error {
+	if n == 1 {
+		return err
 	}
-	return strings.Split(text, "\n"), nil
+	return fmt.Errorf("block %d of %d: %w", i+1, n, err)
 }
 
 func run(args editArgs) error {
 	ctx := context.Background()
 
-	search, err := readLines(args.Search)
+	pairs, err := readPairs(args.Paths)
 	if err != nil {
 		return err
 	}
-	replace, err := readLines(args.Replace)
-	if err != nil {
+	target := args.Paths[len(args.Paths)-1]
+	if err := util.CheckFile(target); err != nil {
 		return err
 	}
-	origBytes, err := os.ReadFile(args.Target)
+	ws := workspace.Current()
+	origBytes, err := ws.ReadFile(target)
 	if err != nil {
 		return err
 	}
-	orig := string(origBytes)
-
-	update := util.FileUpdate{
-		FileName:     args.Target,
-		SearchLines:  search,
-		ReplaceLines: replace,
-	}
-
-	session := &util.SessionState{
-		OrigFiles:     map[string]string{args.Target: orig},
-		SelectedFiles: map[string]string{},
-		PathMap:       map[string]string{args.Target: args.Target},
-	}
+	orig, format := util.NormalizeText(string(origBytes))
 
-	updates, err := lib.ConvertToRangeUpdates(ctx, []util.FileUpdate{update}, session, nil)
+	newContent, err := applyPairs(ctx, target, orig, pairs)
 	if err != nil {
 		return err
 	}
 
-	newContent, err := util.ApplyFileUpdates(orig, updates)
-	if err != nil {
-		return err
+	if args.DryRun {
+		fmt.Print(util.UnifiedDiff("/"+strings.TrimPrefix(target, "/"), orig, newContent, 3))
+		return nil
 	}
-
-	return os.WriteFile(args.Target, []byte(newContent), 0644)
+	return ws.WriteFile(target, []byte(format.Restore(newContent)), workspace.FileMode(ws, target, 0644))
 }
 
 func edit() {
 	var args editArgs
 	arg.MustParse(&args)
+	if args.Converter != "" {
+		_ = os.Setenv("NINA_CONVERTER_MODEL", args.Converter)
+	}
+	if err := workspace.Use(args.Exec); err != nil {
+		lib.Fatal(err)
+	}
 	if err := run(args); err != nil {
-		fmt.Fprintln(os.Stderr, "error:", err)
-		os.Exit(1)
+		lib.Fatal(err)
 	}
 }
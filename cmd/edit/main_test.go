@@ -0,0 +1,52 @@
+package edit
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseBlocks(t *testing.T) {
+	text := "<<<<<<< SEARCH\na\n=======\nA\n>>>>>>> REPLACE\n\n<<<<<<< SEARCH\nb\nc\n=======\n>>>>>>> REPLACE\n"
+	pairs, err := parseBlocks(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []pair{
+		{search: []string{"a"}, replace: []string{"A"}},
+		{search: []string{"b", "c"}, replace: []string{}},
+	}
+	if !reflect.DeepEqual(pairs, want) {
+		t.Errorf("parseBlocks() = %v, want %v", pairs, want)
+	}
+
+	for _, bad := range []string{"", "stray\n<<<<<<< SEARCH\na\n=======\nA\n>>>>>>> REPLACE\n", "<<<<<<< SEARCH\na\n=======\nA\n"} {
+		if _, err := parseBlocks(bad); err == nil {
+			t.Errorf("expected an error for %q", bad)
+		}
+	}
+}
+
+func TestApplyPairs(t *testing.T) {
+	t.Setenv("NINA_CONVERTER_MODEL", "local")
+	t.Setenv("NINA_CACHE_DIR", t.TempDir())
+	t.Setenv("NINA_CONVERT_CORPUS", t.TempDir())
+	pairs := []pair{
+		{search: []string{"two"}, replace: []string{"TWO", "2"}},
+		// later blocks see the earlier edits
+		{search: []string{"2", "three"}, replace: []string{"3"}},
+	}
+	got, err := applyPairs(context.Background(), "/a.txt", "one\ntwo\nthree\n", pairs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "one\nTWO\n3\n" {
+		t.Errorf("applyPairs() = %q", got)
+	}
+
+	_, err = applyPairs(context.Background(), "/a.txt", "one\n", []pair{pairs[0], {search: []string{"missing"}, replace: []string{"x"}}})
+	if err == nil || !strings.Contains(err.Error(), "block 1 of 2") {
+		t.Errorf("expected an error naming block 1, got %v", err)
+	}
+}
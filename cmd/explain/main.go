@@ -0,0 +1,350 @@
+// explain is the read only sibling of arch for code comprehension
+// sends files or line ranges with the EXPLAIN.md system prompt
+// and prints the explanation, never changing any file
+package explain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/alexflint/go-arg"
+	"github.com/nathants/nina/lib"
+	"github.com/nathants/nina/models"
+	"github.com/nathants/nina/prompts"
+	"github.com/nathants/nina/providers/claude"
+	"github.com/nathants/nina/providers/gemini"
+	"github.com/nathants/nina/providers/grok"
+	"github.com/nathants/nina/providers/openai"
+	"github.com/nathants/nina/util"
+	"github.com/nathants/nina/workspace"
+)
+
+func init() {
+	lib.Commands["explain"] = explain
+	lib.Args["explain"] = explainArgs{}
+}
+
+// models used for each depth unless --model is given
+var depthModels = map[string]string{
+	"quick": "flash",
+	"deep":  "o3",
+}
+
+type explainArgs struct {
+	Files    []string `arg:"positional,required" help:"files or globs to explain, with an optional line range as path:start-end"`
+	Question string   `arg:"-q,--question" help:"what to explain (default: stdin when piped, else an overview)"`
+	Depth    string   `arg:"-d,--depth" default:"quick" help:"quick or deep, picks the model and level of detail"`
+	Model    string   `arg:"-m,--model" help:"AI model to use instead of the depth's model"`
+	Verbose  bool     `arg:"-v,--verbose" help:"verbose output"`
+	NoCache  bool     `arg:"--no-cache" help:"always call the model instead of reusing a cached identical response"`
+}
+
+func (explainArgs) Description() string {
+	return `explain - Explain code without changing it
+
+Sends files, or line ranges of them, to a model with the EXPLAIN.md
+prompt and prints a markdown explanation. --depth quick uses flash for
+a short summary, --depth deep uses o3 for an architecture walkthrough.
+
+Supported models: ` + strings.Join(models.Aliases(), ", ") + `
+
+Example:
+  nina explain lib/loop.go
+  nina explain lib/loop.go:400-480 -q "why is the response text switched on type"
+  nina explain --depth deep 'providers/*.go'`
+}
+
+// fileSpec is a path with an optional 1-based inclusive line range
+type fileSpec struct {
+	Path  string
+	Start int
+	End   int
+}
+
+// parseFileSpec splits path:start-end or path:line, a suffix that is not a
+// line range is kept as part of the path
+func parseFileSpec(spec string) (fileSpec, error) {
+	i := strings.LastIndex(spec, ":")
+	if i <= 0 {
+		return fileSpec{Path: spec}, nil
+	}
+	path, lines := spec[:i], spec[i+1:]
+	startText, endText, isRange := strings.Cut(lines, "-")
+	start, err := strconv.Atoi(startText)
+	if err != nil {
+		return fileSpec{Path: spec}, nil
+	}
+	end := start
+	if isRange {
+		end, err = strconv.Atoi(endText)
+		if err != nil {
+			return fileSpec{}, fmt.Errorf("invalid line range %q in %s", lines, spec)
+		}
+	}
+	if start < 1 || end < start {
+		return fileSpec{}, fmt.Errorf("invalid line range %q in %s", lines, spec)
+	}
+	return fileSpec{Path: path, Start: start, End: end}, nil
+}
+
+// selectLines returns the range of content with line numbers from the
+// original file, or all of it when no range is set
+func selectLines(content string, spec fileSpec) (string, error) {
+	if spec.Start == 0 {
+		return util.AddLineNumbers(content), nil
+	}
+	lines := strings.Split(content, "\n")
+	if spec.Start > len(lines) {
+		return "", fmt.Errorf("%s has %d lines, range starts at %d", spec.Path, len(lines), spec.Start)
+	}
+	end := min(spec.End, len(lines))
+	var builder strings.Builder
+	for n := spec.Start; n <= end; n++ {
+		fmt.Fprintf(&builder, "%d: %s", n, lines[n-1])
+		if n < end {
+			builder.WriteString("\n")
+		}
+	}
+	return builder.String(), nil
+}
+
+func readQuestion(question string) (string, error) {
+	if question != "" {
+		return question, nil
+	}
+	if stat, err := os.Stdin.Stat(); err == nil && stat.Mode()&os.ModeCharDevice == 0 {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return "", fmt.Errorf("reading stdin: %w", err)
+		}
+		if q := strings.TrimSpace(string(data)); q != "" {
+			return q, nil
+		}
+	}
+	return "Explain what this code does and how it works.", nil
+}
+
+// readFiles expands the specs into file content keyed by absolute path,
+// with a note for each selected range
+func readFiles(specs []string) (map[string]string, []string, error) {
+	files := map[string]string{}
+	var ranges []string
+	for _, raw := range specs {
+		spec, err := parseFileSpec(raw)
+		if err != nil {
+			return nil, nil, err
+		}
+		matches, err := filepath.Glob(spec.Path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid pattern %s: %w", spec.Path, err)
+		}
+		if len(matches) == 0 {
+			matches = []string{spec.Path}
+		}
+		for _, path := range matches {
+			absPath, err := filepath.Abs(path)
+			if err != nil {
+				return nil, nil, fmt.Errorf("getting absolute path for %s: %w", path, err)
+			}
+			if err := util.CheckFile(path); err != nil {
+				if errors.Is(err, util.ErrBinaryFile) || errors.Is(err, util.ErrFileTooLarge) {
+					util.Errorf("skipping %v", err)
+					continue
+				}
+				return nil, nil, err
+			}
+			data, err := workspace.Current().ReadFile(path)
+			if err != nil {
+				return nil, nil, fmt.Errorf("reading file %s: %w", path, err)
+			}
+			content, err := selectLines(string(data), spec)
+			if err != nil {
+				return nil, nil, err
+			}
+			if existing, ok := files[absPath]; ok && spec.Start > 0 {
+				content = existing + "\n...\n" + content
+			}
+			files[absPath] = content
+			if spec.Start > 0 {
+				ranges = append(ranges, fmt.Sprintf("%s:%d-%d", absPath, spec.Start, spec.End))
+			}
+		}
+	}
+	return files, ranges, nil
+}
+
+func formatNinaInput(prompt string, files map[string]string) string {
+	var builder strings.Builder
+	builder.WriteString(util.NinaInputStart)
+	builder.WriteString("\n\n")
+	builder.WriteString(util.NinaPromptStart)
+	builder.WriteString("\n")
+	builder.WriteString(prompt)
+	builder.WriteString("\n")
+	builder.WriteString(util.NinaPromptEnd)
+	builder.WriteString("\n")
+
+	var paths []string
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		builder.WriteString("\n")
+		builder.WriteString(util.FormatNinaFile(path, files[path]))
+	}
+
+	builder.WriteString("\n")
+	builder.WriteString(util.NinaInputEnd)
+	return builder.String()
+}
+
+func callProvider(ctx context.Context, model models.Model, systemPrompt, userMessage string) (string, error) {
+	switch model.Provider {
+	case models.ProviderClaude:
+		req := claude.Request{
+			Model:     model.APIModel,
+			System:    []claude.Text{{Type: "text", Text: systemPrompt}},
+			Messages:  []claude.Message{{Role: "user", Content: []claude.Text{{Type: "text", Text: userMessage}}}},
+			MaxTokens: model.MaxOutput,
+			Betas:     model.Betas,
+		}
+		if model.ThinkingBudget > 0 {
+			req.Thinking = &claude.Thinking{Type: "enabled", BudgetTokens: model.ThinkingBudget}
+		}
+		resp, err := claude.Handle(ctx, req, nil)
+		if err != nil {
+			return "", err
+		}
+		return resp.Text, nil
+
+	case models.ProviderOpenAI:
+		req := openai.Request{
+			Model: model.APIModel,
+			Input: []openai.ChatMessage{
+				{Type: "message", Role: "system", Content: []openai.ContentPart{{Type: "input_text", Text: systemPrompt}}},
+				{Type: "message", Role: "user", Content: []openai.ContentPart{{Type: "input_text", Text: userMessage}}},
+			},
+			ServiceTier: model.ServiceTier,
+			Background:  model.Background,
+			Temperature: model.Temperature,
+		}
+		if model.Effort != "" {
+			req.Reasoning = &openai.ReasoningRequest{Summary: "auto", Effort: model.Effort}
+		}
+		resp, err := openai.Handle(ctx, req, nil)
+		if err != nil {
+			return "", err
+		}
+		return resp.Text, nil
+
+	case models.ProviderGemini:
+		resp, err := gemini.Handle(ctx, gemini.Request{
+			Model:          model.APIModel,
+			System:         systemPrompt,
+			Contents:       []gemini.Content{gemini.UserText(userMessage)},
+			ThinkingBudget: model.ThinkingBudget,
+		}, nil)
+		if err != nil {
+			return "", err
+		}
+		return resp.Text, nil
+
+	case models.ProviderGrok:
+		resp, err := grok.Handle(ctx, grok.Request{
+			Model: model.APIModel,
+			Messages: []grok.Message{
+				{Role: "system", Content: systemPrompt},
+				{Role: "user", Content: userMessage},
+			},
+		}, nil)
+		if err != nil {
+			return "", err
+		}
+		return resp.Text, nil
+
+	default:
+		return "", fmt.Errorf("provider %s is not supported by explain", model.Provider)
+	}
+}
+
+func run(args explainArgs, model models.Model) error {
+	question, err := readQuestion(args.Question)
+	if err != nil {
+		return err
+	}
+	files, ranges, err := readFiles(args.Files)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no files to explain")
+	}
+
+	prompt := question + "\n\nDepth: " + args.Depth
+	if len(ranges) > 0 {
+		prompt += "\n\nSelected lines:\n" + strings.Join(ranges, "\n")
+	}
+	systemPrompt, err := prompts.EmbeddedFiles.ReadFile("EXPLAIN.md")
+	if err != nil {
+		return fmt.Errorf("failed to read EXPLAIN.md prompt: %w", err)
+	}
+	userMessage := formatNinaInput(prompt, files)
+
+	util.Verbosef("Explaining %d files with %s (%s)", len(files), model.Alias, model.APIModel)
+
+	cacheKey := ""
+	if !args.NoCache && lib.CacheEnabled() {
+		cacheKey = lib.ResponseCacheKey(model, string(systemPrompt), userMessage)
+	}
+	response, cached := "", false
+	if cacheKey != "" {
+		response, cached = lib.CachedResponse(cacheKey)
+	}
+	if !cached {
+		response, err = callProvider(context.Background(), model, string(systemPrompt), userMessage)
+		if err != nil {
+			return fmt.Errorf("AI request failed: %w", err)
+		}
+		if cacheKey != "" {
+			lib.StoreResponse(cacheKey, response)
+		}
+	}
+	fmt.Println(strings.TrimSpace(response))
+	return nil
+}
+
+func explain() {
+	var args explainArgs
+	arg.MustParse(&args)
+
+	if args.Verbose {
+		util.SetLogLevel(max(util.GetLogLevel(), util.LogVerbose))
+	}
+	alias, ok := depthModels[args.Depth]
+	if !ok {
+		fmt.Fprintln(os.Stderr, "error: invalid depth:", args.Depth, "(expected quick or deep)")
+		os.Exit(1)
+	}
+	if args.Model != "" {
+		alias = args.Model
+	}
+	model, err := models.Lookup(alias)
+	if err == nil && model.Batch {
+		err = fmt.Errorf("batch model %s is not supported by explain", alias)
+	}
+	if err != nil {
+		lib.Fatal(err)
+	}
+
+	if err := run(args, model); err != nil {
+		lib.Fatal(err)
+	}
+}
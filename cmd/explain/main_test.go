@@ -0,0 +1,50 @@
+package explain
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseFileSpec(t *testing.T) {
+	tests := []struct {
+		spec string
+		want fileSpec
+		err  bool
+	}{
+		{"main.go", fileSpec{Path: "main.go"}, false},
+		{"main.go:10-20", fileSpec{Path: "main.go", Start: 10, End: 20}, false},
+		{"main.go:7", fileSpec{Path: "main.go", Start: 7, End: 7}, false},
+		{"c:notes.txt", fileSpec{Path: "c:notes.txt"}, false},
+		{"main.go:20-10", fileSpec{}, true},
+		{"main.go:10-x", fileSpec{}, true},
+	}
+	for _, tc := range tests {
+		got, err := parseFileSpec(tc.spec)
+		if (err != nil) != tc.err || got != tc.want {
+			t.Errorf("parseFileSpec(%q) = %+v, %v", tc.spec, got, err)
+		}
+	}
+}
+
+func TestReadFilesRanges(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.go")
+	if err := os.WriteFile(path, []byte("one\ntwo\nthree\nfour\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	files, ranges, err := readFiles([]string{path + ":2-3", path + ":4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := files[path]; got != "2: two\n3: three\n...\n4: four" {
+		t.Errorf("got %q", got)
+	}
+	if strings.Join(ranges, " ") != path+":2-3 "+path+":4-4" {
+		t.Errorf("got ranges %v", ranges)
+	}
+	if _, _, err := readFiles([]string{path + ":9-10"}); err == nil {
+		t.Error("expected an error for a range past the end")
+	}
+}
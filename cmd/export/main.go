@@ -0,0 +1,157 @@
+// export writes recorded sessions as OpenAI chat JSONL, and import reads
+// such JSONL back into sessions, so transcripts can feed fine-tuning or
+// third-party tools and outside conversations can be replayed by nina
+package export
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/alexflint/go-arg"
+	"github.com/nathants/nina/lib"
+	"github.com/nathants/nina/util"
+)
+
+func init() {
+	lib.Commands["export"] = export
+	lib.Args["export"] = exportArgs{}
+	lib.Commands["import"] = importSessions
+	lib.Args["import"] = importArgs{}
+}
+
+type exportArgs struct {
+	Sessions []string `arg:"positional" help:"Sessions to export: timestamp, agents/api path, or latest (default: all)"`
+	Format   string   `arg:"-f,--format" default:"openai-jsonl" help:"Output format: openai-jsonl"`
+	Output   string   `arg:"-o,--output" help:"File to write, stdout when omitted"`
+}
+
+func (exportArgs) Description() string {
+	return `export - Export sessions as chat message JSONL
+
+Rebuilds each session under agents/api into the system prompt,
+the user message of every call, and the response, and writes one
+{"messages": [{"role": ..., "content": ...}]} line per session,
+the format of OpenAI fine-tuning datasets.
+
+Examples:
+  nina export latest
+  nina export --output sessions.jsonl
+  nina export 20250701-093000 | jq .messages[1].content`
+}
+
+type importArgs struct {
+	File   string `arg:"positional" help:"JSONL file to import, stdin when omitted or -"`
+	Format string `arg:"-f,--format" default:"openai-jsonl" help:"Input format: openai-jsonl"`
+	Model  string `arg:"-m,--model" default:"imported" help:"Model recorded with the imported calls"`
+}
+
+func (importArgs) Description() string {
+	return `import - Import chat message JSONL as sessions
+
+Each line is {"messages": [...]}, an optional system message then
+alternating user and assistant messages. Every line becomes a new
+session under agents/api, one recorded call per assistant message,
+that nina run --replay, nina export, and nina sessions search use
+like a recorded session.
+
+Example:
+  nina import conversations.jsonl
+  nina export latest | nina import`
+}
+
+func checkFormat(format string) {
+	if format != lib.FormatOpenAIJSONL {
+		lib.Fatal(fmt.Errorf("unknown format: %s, want %s", format, lib.FormatOpenAIJSONL))
+	}
+}
+
+// sessionIDs returns every recorded session, oldest first
+func sessionIDs() ([]string, error) {
+	entries, err := os.ReadDir(util.GetAgentsSubdir("api"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			ids = append(ids, entry.Name())
+		}
+	}
+	return ids, nil
+}
+
+func export() {
+	var args exportArgs
+	arg.MustParse(&args)
+	checkFormat(args.Format)
+
+	sessions := args.Sessions
+	all := len(sessions) == 0
+	if all {
+		var err error
+		if sessions, err = sessionIDs(); err != nil {
+			lib.Fatal(err)
+		}
+	}
+
+	var transcripts []lib.Transcript
+	for _, session := range sessions {
+		transcript, err := lib.LoadTranscript(session)
+		if err != nil {
+			if !all {
+				lib.Fatal(err)
+			}
+			util.Verbosef("skipping %s: %v", session, err)
+			continue
+		}
+		transcripts = append(transcripts, transcript)
+	}
+
+	var w io.Writer = os.Stdout
+	if args.Output != "" {
+		f, err := os.Create(args.Output)
+		if err != nil {
+			lib.Fatal(err)
+		}
+		defer func() { _ = f.Close() }()
+		w = f
+	}
+	if err := lib.WriteOpenAIJSONL(w, transcripts); err != nil {
+		lib.Fatal(err)
+	}
+	util.Infof("exported %d sessions", len(transcripts))
+}
+
+func importSessions() {
+	var args importArgs
+	arg.MustParse(&args)
+	checkFormat(args.Format)
+
+	var r io.Reader = os.Stdin
+	if args.File != "" && args.File != "-" {
+		f, err := os.Open(args.File)
+		if err != nil {
+			lib.Fatal(err)
+		}
+		defer func() { _ = f.Close() }()
+		r = f
+	}
+	transcripts, err := lib.ReadOpenAIJSONL(r)
+	if err != nil {
+		lib.Fatal(err)
+	}
+	now := time.Now()
+	for _, transcript := range transcripts {
+		id, err := lib.ImportTranscript(transcript, args.Model, now)
+		if err != nil {
+			lib.Fatal(err)
+		}
+		fmt.Println(id)
+		now = now.Add(time.Second)
+	}
+}
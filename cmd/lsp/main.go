@@ -0,0 +1,396 @@
+// lsp is a long running stdio json-rpc server editors spawn for "edit
+// selection with nina". It speaks enough of the language server protocol
+// for neovim and vscode clients to attach, tracks open buffers, and answers
+// nina/edit requests with workspace edits located by ConvertToRangeUpdates,
+// so unsaved buffers are edited without temp files.
+package lsp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode/utf16"
+
+	"github.com/alexflint/go-arg"
+	"github.com/nathants/nina/lib"
+	"github.com/nathants/nina/models"
+	"github.com/nathants/nina/prompts"
+	"github.com/nathants/nina/util"
+)
+
+func init() {
+	lib.Commands["lsp"] = lsp
+	lib.Args["lsp"] = lspArgs{}
+}
+
+type lspArgs struct {
+	Model     string `arg:"-m,--model" default:"sonnet" help:"Model used for edits unless a request names one"`
+	Converter string `arg:"--converter" help:"Model to locate search text, or 'local' for no AI (default: $NINA_CONVERTER_MODEL or sonnet)"`
+}
+
+func (lspArgs) Description() string {
+	return `lsp - Edit server for editors over stdio
+
+Speaks json-rpc with Content-Length framing like a language server.
+Configure it as a language server command, 'nina lsp', then either send
+a nina/edit request:
+
+  {"textDocument": {"uri": "file:///src/main.go"},
+   "range": {"start": {"line": 10, "character": 0}, "end": {"line": 20, "character": 0}},
+   "instruction": "handle the error", "text": "<optional buffer text>", "model": "<optional>"}
+
+which responds with {"edit": WorkspaceEdit} after streaming each located
+change as a nina/editProgress notification, or run the nina.edit command
+with the same params through workspace/executeCommand to have the edit
+applied with workspace/applyEdit.
+
+Edit ranges refer to the buffer as it was sent, apply them together.`
+}
+
+// editParams are the params of nina/edit and the nina.edit command
+type editParams struct {
+	TextDocument textDocumentIdentifier `json:"textDocument"`
+	Range        *textRange             `json:"range,omitempty"`
+	Instruction  string                 `json:"instruction"`
+	Text         *string                `json:"text,omitempty"`
+	Model        string                 `json:"model,omitempty"`
+}
+
+type editResult struct {
+	Edit workspaceEdit `json:"edit"`
+}
+
+type editProgress struct {
+	ID   json.RawMessage `json:"id"`
+	Edit workspaceEdit   `json:"edit"`
+}
+
+// callModel returns the model's NinaOutput for a prompt, replaced in tests
+var callModel = func(ctx context.Context, model, systemPrompt, userMessage string) (string, error) {
+	provider, model, err := lib.CreateProviderForModel(model)
+	if err != nil {
+		return "", err
+	}
+	return lib.CallAIProvider(provider, model, systemPrompt, userMessage, &lib.LoopState{}, false)
+}
+
+type server struct {
+	conn     *conn
+	model    string
+	mu       sync.Mutex
+	docs     map[string]string
+	cancels  map[string]context.CancelFunc
+	shutdown bool
+	wg       sync.WaitGroup
+}
+
+func newServer(r io.Reader, w io.Writer, model string) *server {
+	return &server{
+		conn:    newConn(r, w),
+		model:   model,
+		docs:    map[string]string{},
+		cancels: map[string]context.CancelFunc{},
+	}
+}
+
+// serve handles messages until exit or end of input, returning whether
+// shutdown was requested first
+func (s *server) serve() bool {
+	defer s.wg.Wait()
+	for {
+		msg, err := s.conn.read()
+		if err != nil {
+			var rerr *rpcError
+			if errors.As(err, &rerr) {
+				_ = s.conn.reply(json.RawMessage("null"), nil, rerr)
+				continue
+			}
+			if !errors.Is(err, io.EOF) {
+				util.Errorf("lsp: %v", err)
+			}
+			return s.shutdown
+		}
+		if msg.Method == "" {
+			continue // a response to one of our requests
+		}
+		if msg.Method == "exit" {
+			return s.shutdown
+		}
+		s.handle(msg)
+	}
+}
+
+func (s *server) handle(msg *message) {
+	switch msg.Method {
+	case "initialize":
+		_ = s.conn.reply(msg.ID, map[string]any{
+			"capabilities": map[string]any{
+				"textDocumentSync":       1, // full text on every change
+				"executeCommandProvider": map[string]any{"commands": []string{"nina.edit"}},
+			},
+			"serverInfo": map[string]any{"name": "nina"},
+		}, nil)
+	case "initialized":
+	case "shutdown":
+		s.shutdown = true
+		_ = s.conn.reply(msg.ID, nil, nil)
+	case "textDocument/didOpen":
+		var params didOpenParams
+		if json.Unmarshal(msg.Params, &params) == nil {
+			s.setDoc(params.TextDocument.URI, params.TextDocument.Text)
+		}
+	case "textDocument/didChange":
+		var params didChangeParams
+		if json.Unmarshal(msg.Params, &params) == nil && len(params.ContentChanges) > 0 {
+			s.setDoc(params.TextDocument.URI, params.ContentChanges[len(params.ContentChanges)-1].Text)
+		}
+	case "textDocument/didClose":
+		var params didCloseParams
+		if json.Unmarshal(msg.Params, &params) == nil {
+			s.mu.Lock()
+			delete(s.docs, params.TextDocument.URI)
+			s.mu.Unlock()
+		}
+	case "$/cancelRequest":
+		var params cancelParams
+		if json.Unmarshal(msg.Params, &params) == nil {
+			s.mu.Lock()
+			if cancel, ok := s.cancels[string(params.ID)]; ok {
+				cancel()
+			}
+			s.mu.Unlock()
+		}
+	case "nina/edit":
+		var params editParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			_ = s.conn.reply(msg.ID, nil, &rpcError{Code: codeInvalidParams, Message: err.Error()})
+			return
+		}
+		s.async(msg.ID, func(ctx context.Context) (any, error) {
+			edit, err := s.edit(ctx, msg.ID, params)
+			if err != nil {
+				return nil, err
+			}
+			return editResult{Edit: edit}, nil
+		})
+	case "workspace/executeCommand":
+		var params executeCommandParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil || params.Command != "nina.edit" || len(params.Arguments) != 1 {
+			_ = s.conn.reply(msg.ID, nil, &rpcError{Code: codeInvalidParams, Message: "expected the nina.edit command with one argument"})
+			return
+		}
+		var edit editParams
+		if err := json.Unmarshal(params.Arguments[0], &edit); err != nil {
+			_ = s.conn.reply(msg.ID, nil, &rpcError{Code: codeInvalidParams, Message: err.Error()})
+			return
+		}
+		s.async(msg.ID, func(ctx context.Context) (any, error) {
+			result, err := s.edit(ctx, msg.ID, edit)
+			if err != nil {
+				return nil, err
+			}
+			return nil, s.conn.request("workspace/applyEdit", map[string]any{"label": "nina", "edit": result})
+		})
+	default:
+		if msg.ID != nil {
+			_ = s.conn.reply(msg.ID, nil, &rpcError{Code: codeMethodNotFound, Message: "unknown method: " + msg.Method})
+		}
+	}
+}
+
+func (s *server) setDoc(uri, text string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.docs[uri] = text
+}
+
+// async runs a request in the background so it can be cancelled
+func (s *server) async(id json.RawMessage, fn func(ctx context.Context) (any, error)) {
+	ctx, cancel := context.WithCancel(context.Background())
+	key := string(id)
+	s.mu.Lock()
+	s.cancels[key] = cancel
+	s.mu.Unlock()
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer func() {
+			s.mu.Lock()
+			delete(s.cancels, key)
+			s.mu.Unlock()
+			cancel()
+		}()
+		result, err := fn(ctx)
+		if ctx.Err() != nil {
+			err = &rpcError{Code: codeCancelled, Message: "request cancelled"}
+		} else if err != nil {
+			util.Errorf("lsp: %v", err)
+		}
+		_ = s.conn.reply(id, result, err)
+	}()
+}
+
+// edit asks the model to change the selection and returns the edit
+func (s *server) edit(ctx context.Context, id json.RawMessage, params editParams) (workspaceEdit, error) {
+	uri := params.TextDocument.URI
+	path, err := uriPath(uri)
+	if err != nil {
+		return workspaceEdit{}, err
+	}
+	if strings.TrimSpace(params.Instruction) == "" {
+		return workspaceEdit{}, &rpcError{Code: codeInvalidParams, Message: "instruction is required"}
+	}
+	text, err := s.text(uri, path, params.Text)
+	if err != nil {
+		return workspaceEdit{}, err
+	}
+	model := params.Model
+	if model == "" {
+		model = s.model
+	}
+	if _, err := models.Lookup(model); err != nil {
+		return workspaceEdit{}, err
+	}
+
+	systemPrompt, err := prompts.EmbeddedFiles.ReadFile("ARCHITECT.md")
+	if err != nil {
+		return workspaceEdit{}, err
+	}
+	codingPrompt, err := prompts.EmbeddedFiles.ReadFile("CODING.md")
+	if err != nil {
+		return workspaceEdit{}, err
+	}
+	userMessage := string(codingPrompt) + "\n\n" + formatInput(path, text, params.Instruction, params.Range)
+	response, err := callModel(ctx, model, string(systemPrompt), userMessage)
+	if err != nil {
+		return workspaceEdit{}, err
+	}
+	updates, err := util.ParseFileUpdates(response)
+	if err != nil && !util.SkipBrokenChanges(err) {
+		return workspaceEdit{}, fmt.Errorf("failed to parse model response: %w", err)
+	}
+
+	result := workspaceEdit{Changes: map[string][]textEdit{uri: {}}}
+	session := &util.SessionState{
+		OrigFiles:     map[string]string{path: text},
+		SelectedFiles: map[string]string{},
+		PathMap:       map[string]string{path: path},
+	}
+	for _, update := range updates {
+		if update.FileName != path {
+			util.Errorf("lsp: skipping change to %s, only %s can be edited", update.FileName, path)
+			continue
+		}
+		ranged, err := lib.ConvertToRangeUpdates(ctx, []util.FileUpdate{update}, session, nil)
+		if err != nil {
+			return workspaceEdit{}, err
+		}
+		for _, u := range ranged {
+			edit := rangeEdit(text, u)
+			result.Changes[uri] = append(result.Changes[uri], edit)
+			_ = s.conn.notify("nina/editProgress", editProgress{
+				ID:   id,
+				Edit: workspaceEdit{Changes: map[string][]textEdit{uri: {edit}}},
+			})
+		}
+	}
+	return result, nil
+}
+
+// text returns the document from the request, the open buffer, or disk
+func (s *server) text(uri, path string, text *string) (string, error) {
+	if text != nil {
+		return *text, nil
+	}
+	s.mu.Lock()
+	doc, ok := s.docs[uri]
+	s.mu.Unlock()
+	if ok {
+		return doc, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// formatInput builds the NinaInput for the document and instruction
+func formatInput(path, text, instruction string, selection *textRange) string {
+	prompt := instruction
+	if selection != nil {
+		lines := strings.Split(text, "\n")
+		start := min(max(selection.Start.Line, 0), len(lines)-1)
+		end := min(max(selection.End.Line, start), len(lines)-1)
+		if selection.End.Character == 0 && end > start {
+			end-- // a selection ending at column 0 does not include that line
+		}
+		prompt = fmt.Sprintf("%s\n\nOnly change lines %d-%d of %s:\n\n%s",
+			instruction, start+1, end+1, path, strings.Join(lines[start:end+1], "\n"))
+	}
+	return util.NinaInputStart + "\n\n" + util.NinaPromptStart + "\n" + prompt + "\n" + util.NinaPromptEnd + "\n\n" +
+		util.FormatNinaFile(path, text) + "\n" + util.NinaInputEnd
+}
+
+// rangeEdit converts a 1-based inclusive line update, or a full rewrite,
+// into a text edit against text
+func rangeEdit(text string, update util.FileUpdate) textEdit {
+	lines := strings.Split(text, "\n")
+	newText := strings.Join(update.ReplaceLines, "\n")
+	if update.StartLine <= 0 && update.EndLine <= 0 {
+		last := len(lines) - 1
+		return textEdit{
+			Range:   textRange{End: position{Line: last, Character: utf16Len(lines[last])}},
+			NewText: newText,
+		}
+	}
+	start := position{Line: update.StartLine - 1}
+	if update.EndLine < len(lines) {
+		if len(update.ReplaceLines) > 0 {
+			newText += "\n"
+		}
+		return textEdit{Range: textRange{Start: start, End: position{Line: update.EndLine}}, NewText: newText}
+	}
+	// the range reaches the last line, which has no newline to replace
+	last := len(lines) - 1
+	return textEdit{Range: textRange{Start: start, End: position{Line: last, Character: utf16Len(lines[last])}}, NewText: newText}
+}
+
+func utf16Len(s string) int {
+	return len(utf16.Encode([]rune(s)))
+}
+
+// uriPath returns the absolute path of a file uri
+func uriPath(uri string) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return "", &rpcError{Code: codeInvalidParams, Message: fmt.Sprintf("unsupported document uri %q, expected file://", uri)}
+	}
+	return filepath.Clean(u.Path), nil
+}
+
+func lsp() {
+	var args lspArgs
+	arg.MustParse(&args)
+	if args.Converter != "" {
+		_ = os.Setenv("NINA_CONVERTER_MODEL", args.Converter)
+	}
+	if _, err := models.Lookup(args.Model); err != nil {
+		lib.Fatal(err)
+	}
+	lib.InitializeSession(false)
+
+	// stdout carries only protocol messages
+	out := os.Stdout
+	os.Stdout = os.Stderr
+	if !newServer(os.Stdin, out, args.Model).serve() {
+		os.Exit(1)
+	}
+}
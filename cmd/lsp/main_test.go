@@ -0,0 +1,115 @@
+package lsp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/nathants/nina/util"
+)
+
+func frame(t *testing.T, buf *bytes.Buffer, msg map[string]any) {
+	t.Helper()
+	msg["jsonrpc"] = "2.0"
+	body, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintf(buf, "Content-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+func TestEditSelection(t *testing.T) {
+	t.Setenv("NINA_CACHE_DIR", t.TempDir())
+	t.Setenv("NINA_CONVERT_CORPUS", t.TempDir())
+	t.Setenv("NINA_CONVERTER_MODEL", "local")
+	var prompt string
+	model := callModel
+	callModel = func(ctx context.Context, model, systemPrompt, userMessage string) (string, error) {
+		prompt = userMessage
+		return "<NinaOutput>\n" + util.NinaStart + "\n" +
+			util.NinaPathStart + "/src/main.go" + util.NinaPathEnd + "\n" +
+			util.NinaSearchStart + "\n\treturn a\n" + util.NinaSearchEnd + "\n" +
+			util.NinaReplaceStart + "\n\tif a < 0 {\n\t\treturn -a\n\t}\n\treturn a\n" + util.NinaReplaceEnd + "\n" +
+			util.NinaEnd + "\n</NinaOutput>", nil
+	}
+	defer func() { callModel = model }()
+
+	uri := "file:///src/main.go"
+	var in bytes.Buffer
+	frame(t, &in, map[string]any{"id": 1, "method": "initialize", "params": map[string]any{}})
+	frame(t, &in, map[string]any{"method": "textDocument/didOpen", "params": map[string]any{
+		"textDocument": map[string]any{"uri": uri, "text": "package main\n\nfunc abs(a int) int {\n\treturn a\n}\n"},
+	}})
+	frame(t, &in, map[string]any{"id": 2, "method": "nina/edit", "params": map[string]any{
+		"textDocument": map[string]any{"uri": uri},
+		"range":        map[string]any{"start": map[string]any{"line": 2, "character": 0}, "end": map[string]any{"line": 5, "character": 0}},
+		"instruction":  "handle negatives",
+		"model":        "sonnet",
+	}})
+	frame(t, &in, map[string]any{"id": 3, "method": "shutdown"})
+	frame(t, &in, map[string]any{"method": "exit"})
+
+	var out bytes.Buffer
+	s := newServer(&in, &out, "sonnet")
+	if !s.serve() {
+		t.Fatal("expected a clean shutdown")
+	}
+	if !strings.Contains(prompt, "Only change lines 3-5 of /src/main.go") {
+		t.Errorf("prompt is missing the selection:\n%s", prompt)
+	}
+
+	c := newConn(&out, nil)
+	var progress, result *message
+	for {
+		msg, err := c.read()
+		if err != nil {
+			break
+		}
+		switch {
+		case msg.Method == "nina/editProgress":
+			progress = msg
+		case string(msg.ID) == "2":
+			result = msg
+		}
+	}
+	if progress == nil || result == nil {
+		t.Fatalf("missing progress or result")
+	}
+	if result.Error != nil {
+		t.Fatalf("edit failed: %v", result.Error)
+	}
+	data, _ := json.Marshal(result.Result)
+	var got editResult
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	edits := got.Edit.Changes[uri]
+	want := textEdit{
+		Range:   textRange{Start: position{Line: 3}, End: position{Line: 4}},
+		NewText: "\tif a < 0 {\n\t\treturn -a\n\t}\n\treturn a\n",
+	}
+	if len(edits) != 1 || edits[0] != want {
+		t.Errorf("got edits %+v\nwant %+v", edits, want)
+	}
+}
+
+func TestRangeEdit(t *testing.T) {
+	text := "a\nb\nc"
+	tests := []struct {
+		update util.FileUpdate
+		want   textEdit
+	}{
+		{util.FileUpdate{StartLine: 1, EndLine: 1, ReplaceLines: []string{"x"}}, textEdit{textRange{position{0, 0}, position{1, 0}}, "x\n"}},
+		{util.FileUpdate{StartLine: 2, EndLine: 2}, textEdit{textRange{position{1, 0}, position{2, 0}}, ""}},
+		{util.FileUpdate{StartLine: 2, EndLine: 3, ReplaceLines: []string{"y"}}, textEdit{textRange{position{1, 0}, position{2, 1}}, "y"}},
+		{util.FileUpdate{ReplaceLines: []string{"new"}}, textEdit{textRange{position{0, 0}, position{2, 1}}, "new"}},
+	}
+	for _, tc := range tests {
+		if got := rangeEdit(text, tc.update); got != tc.want {
+			t.Errorf("rangeEdit(%+v) = %+v, want %+v", tc.update, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+// memory manages the project's long-term memory, facts the agent recorded
+// with NinaRemember that are recalled into future sessions
+package memory
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/alexflint/go-arg"
+	"github.com/nathants/nina/lib"
+	"github.com/nathants/nina/memory"
+	"github.com/nathants/nina/util"
+)
+
+func init() {
+	lib.Commands["memory"] = memoryMain
+	lib.Args["memory"] = memoryMainArgs{}
+}
+
+type memoryMainArgs struct {
+	Subcommand string   `arg:"positional" help:"Subcommand to run (list, add, forget, recall)"`
+	Args       []string `arg:"positional" help:"Arguments for subcommand"`
+}
+
+func (memoryMainArgs) Description() string {
+	return `memory - Manage long-term memory
+
+Facts the agent records with <NinaRemember> are stored in
+agents/memory.jsonl, or $NINA_MEMORY_FILE. At the start of each
+session the memories most similar to the prompt are added to the
+first message, up to $NINA_MEMORY_RECALL (default 5, 0 disables).
+
+Available subcommands:
+  list          - List memories, oldest first
+  add <text>    - Remember a fact
+  forget <id>   - Remove a memory by id or id prefix
+  recall <text> - Print the memories a prompt would recall`
+}
+
+type memoryTextArgs struct {
+	Text []string `arg:"positional,required" help:"Text of the memory or prompt"`
+}
+
+type memoryForgetArgs struct {
+	IDs []string `arg:"positional,required" help:"Memory ids or id prefixes"`
+}
+
+func memoryMain() {
+	var args memoryMainArgs
+	p, err := arg.NewParser(arg.Config{
+		Program: "nina memory",
+	}, &args)
+	if err != nil {
+		lib.Fatal(err)
+	}
+
+	if len(os.Args) < 2 || os.Args[1] == "-h" || os.Args[1] == "--help" {
+		p.WriteHelp(os.Stdout)
+		os.Exit(0)
+	}
+
+	err = p.Parse(os.Args[1:2])
+	if err != nil {
+		p.WriteHelp(os.Stderr)
+		os.Exit(1)
+	}
+
+	os.Args = append([]string{"nina memory " + args.Subcommand}, os.Args[2:]...)
+
+	switch args.Subcommand {
+	case "list":
+		err = memoryList()
+	case "add":
+		var textArgs memoryTextArgs
+		arg.MustParse(&textArgs)
+		var m memory.Memory
+		if m, err = memory.Remember(strings.Join(textArgs.Text, " ")); err == nil {
+			fmt.Println(m.ID)
+		}
+	case "forget":
+		var forgetArgs memoryForgetArgs
+		arg.MustParse(&forgetArgs)
+		err = memoryForget(forgetArgs.IDs)
+	case "recall":
+		var textArgs memoryTextArgs
+		arg.MustParse(&textArgs)
+		err = memoryRecall(strings.Join(textArgs.Text, " "))
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown subcommand: %s\n", args.Subcommand)
+		p.WriteHelp(os.Stderr)
+		os.Exit(1)
+	}
+	if err != nil {
+		lib.Fatal(err)
+	}
+}
+
+func printMemories(memories []memory.Memory) {
+	for _, m := range memories {
+		fmt.Printf("%s  %s  %s\n", m.ID, m.Created.Local().Format("2006-01-02"), strings.ReplaceAll(m.Text, "\n", " "))
+	}
+}
+
+func memoryList() error {
+	memories, err := memory.Load()
+	if err != nil {
+		return err
+	}
+	if len(memories) == 0 {
+		util.Infof("no memories in %s", memory.Path())
+		return nil
+	}
+	printMemories(memories)
+	return nil
+}
+
+func memoryForget(ids []string) error {
+	for _, id := range ids {
+		m, err := memory.Forget(id)
+		if err != nil {
+			return err
+		}
+		util.Infof("forgot %s: %s", m.ID, m.Text)
+	}
+	return nil
+}
+
+func memoryRecall(prompt string) error {
+	memories, err := memory.Recall(prompt, memory.RecallLimit())
+	if err != nil {
+		return err
+	}
+	printMemories(memories)
+	return nil
+}
@@ -0,0 +1,183 @@
+// models lists the model registry shared by every command, with each short
+// name's provider, api model, reasoning settings, context window, and price,
+// and the models installed on the ollama server
+package models
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/alexflint/go-arg"
+	"github.com/nathants/nina/lib"
+	"github.com/nathants/nina/models"
+	"github.com/nathants/nina/providers/ollama"
+	"github.com/nathants/nina/util"
+)
+
+func init() {
+	lib.Commands["models"] = modelsMain
+	lib.Args["models"] = modelsMainArgs{}
+}
+
+type modelsMainArgs struct {
+	Subcommand string   `arg:"positional" help:"Subcommand to run (list, ollama)"`
+	Args       []string `arg:"positional" help:"Arguments for subcommand"`
+}
+
+func (modelsMainArgs) Description() string {
+	return `models - Show supported models
+
+Available subcommands:
+  list          - List model short names and their settings
+  ollama        - List the models installed on the ollama server, use
+                  one with --model ollama:<name>
+
+Define aliases or override built in ones in ~/.nina/models.json or
+the project's .nina/models.json, entries from them are marked *:
+
+  {
+    "fast": "openai:gpt-4.1-nano",
+    "sonnet": "claude-sonnet-4-latest",
+    "deep": {"model": "o3", "effort": "medium", "service_tier": "flex"}
+  }
+
+Values are "provider:api-model", a built in alias to copy, or a new
+api model for an existing alias. Objects may also set temperature, top_p,
+thinking_budget, context_window, and max_output, and for ollama num_ctx.
+An alias like "ollama:qwen2.5-coder" sets options of that ollama model:
+
+  {"ollama:qwen2.5-coder": {"num_ctx": 32768, "temperature": 0.2}}`
+}
+
+type modelsListArgs struct {
+	Provider string `arg:"-p,--provider" help:"Only list models from this provider"`
+}
+
+type modelsOllamaArgs struct {
+	Pull string `arg:"--pull" help:"Pull this model to the ollama server first"`
+}
+
+func modelsMain() {
+	var args modelsMainArgs
+	p, err := arg.NewParser(arg.Config{
+		Program: "nina models",
+	}, &args)
+	if err != nil {
+		lib.Fatal(err)
+	}
+
+	if len(os.Args) < 2 || os.Args[1] == "-h" || os.Args[1] == "--help" {
+		p.WriteHelp(os.Stdout)
+		os.Exit(0)
+	}
+
+	err = p.Parse(os.Args[1:2])
+	if err != nil {
+		p.WriteHelp(os.Stderr)
+		os.Exit(1)
+	}
+
+	os.Args = append([]string{"nina models " + args.Subcommand}, os.Args[2:]...)
+
+	switch args.Subcommand {
+	case "list":
+		var listArgs modelsListArgs
+		arg.MustParse(&listArgs)
+		err = modelsList(listArgs.Provider)
+	case "ollama":
+		var ollamaArgs modelsOllamaArgs
+		arg.MustParse(&ollamaArgs)
+		err = modelsOllama(ollamaArgs.Pull)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown subcommand: %s\n", args.Subcommand)
+		p.WriteHelp(os.Stderr)
+		os.Exit(1)
+	}
+	if err != nil {
+		lib.Fatal(err)
+	}
+}
+
+func modelsList(provider string) error {
+	if err := models.Load(); err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "NAME\tPROVIDER\tAPI MODEL\tREASONING\tCONTEXT\t$/M IN\t$/M OUT")
+	for _, m := range models.All() {
+		if provider != "" && m.Provider != provider {
+			continue
+		}
+		in, out := "-", "-"
+		if price, ok := m.Price(); ok {
+			in = strconv.FormatFloat(price.Input, 'f', -1, 64)
+			out = strconv.FormatFloat(price.Output, 'f', -1, 64)
+		}
+		name := strings.Join(m.Names(), ", ")
+		if m.Config != "" {
+			name += " *"
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			name, m.Provider, m.APIModel, reasoning(m), contextWindow(m), in, out)
+	}
+	return w.Flush()
+}
+
+func modelsOllama(pull string) error {
+	ctx := context.Background()
+	if pull != "" {
+		if err := ollama.Pull(ctx, pull, func(status string) { util.Infof("%s", status) }); err != nil {
+			return err
+		}
+	}
+	installed, err := ollama.ListModels(ctx)
+	if err != nil {
+		return err
+	}
+	sort.Slice(installed, func(i, j int) bool { return installed[i].Name < installed[j].Name })
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "NAME\tPARAMETERS\tQUANTIZATION\tSIZE\tMODIFIED")
+	for _, m := range installed {
+		_, _ = fmt.Fprintf(w, "ollama:%s\t%s\t%s\t%.1fG\t%s\n",
+			m.Name, m.Details.ParameterSize, m.Details.QuantizationLevel, float64(m.Size)/1e9, m.ModifiedAt.Format("2006-01-02"))
+	}
+	return w.Flush()
+}
+
+func reasoning(m models.Model) string {
+	var parts []string
+	if m.Effort != "" {
+		parts = append(parts, "effort "+m.Effort)
+	}
+	if m.ThinkingBudget > 0 {
+		parts = append(parts, lib.FormatTokens(m.ThinkingBudget)+" thinking")
+	}
+	if m.Temperature != nil {
+		parts = append(parts, "temp "+strconv.FormatFloat(*m.Temperature, 'f', -1, 64))
+	}
+	if m.ServiceTier != "" {
+		parts = append(parts, m.ServiceTier)
+	}
+	if m.Background {
+		parts = append(parts, "background")
+	}
+	if m.Batch {
+		parts = append(parts, "batch")
+	}
+	if len(parts) == 0 {
+		return "-"
+	}
+	return strings.Join(parts, ", ")
+}
+
+func contextWindow(m models.Model) string {
+	if m.ContextWindow == 0 {
+		return "-"
+	}
+	return lib.FormatTokens(m.ContextWindow)
+}
@@ -0,0 +1,199 @@
+// prompt manages named prompt templates under ~/.config/nina/prompts that
+// ask and run render with --prompt and --var
+package prompt
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+
+	"github.com/alexflint/go-arg"
+	"github.com/nathants/nina/lib"
+	"github.com/nathants/nina/prompts"
+	"github.com/nathants/nina/util"
+)
+
+func init() {
+	lib.Commands["prompt"] = promptMain
+	lib.Args["prompt"] = promptMainArgs{}
+}
+
+type promptMainArgs struct {
+	Subcommand string   `arg:"positional" help:"Subcommand to run (list, show, add, edit, rm, render)"`
+	Args       []string `arg:"positional" help:"Arguments for subcommand"`
+}
+
+func (promptMainArgs) Description() string {
+	return `prompt - Manage prompt templates
+
+Templates are stored as <name>.md in ~/.config/nina/prompts, or
+$NINA_PROMPTS_DIR. Placeholders like {{pkg}} are filled from
+--var pkg=value when the template is used:
+
+  nina ask -p refactor --var pkg=util
+  nina run -p refactor --var pkg=util
+
+Available subcommands:
+  list                 - List templates
+  show <name>          - Print a template
+  add <name> [-f file] - Save a template from a file or stdin
+  edit <name>          - Open a template in $EDITOR
+  rm <name>            - Delete a template
+  render <name>        - Print a template rendered with --var values`
+}
+
+type promptNameArgs struct {
+	Name string `arg:"positional,required" help:"Template name"`
+}
+
+type promptAddArgs struct {
+	Name string `arg:"positional,required" help:"Template name"`
+	File string `arg:"-f,--file" help:"Read the template from a file instead of stdin"`
+}
+
+type promptRenderArgs struct {
+	Name string   `arg:"positional,required" help:"Template name"`
+	Vars []string `arg:"--var,separate" help:"Template variable as key=value"`
+}
+
+func promptMain() {
+	var args promptMainArgs
+	p, err := arg.NewParser(arg.Config{
+		Program: "nina prompt",
+	}, &args)
+	if err != nil {
+		lib.Fatal(err)
+	}
+
+	if len(os.Args) < 2 || os.Args[1] == "-h" || os.Args[1] == "--help" {
+		p.WriteHelp(os.Stdout)
+		os.Exit(0)
+	}
+
+	err = p.Parse(os.Args[1:2])
+	if err != nil {
+		p.WriteHelp(os.Stderr)
+		os.Exit(1)
+	}
+
+	os.Args = append([]string{"nina prompt " + args.Subcommand}, os.Args[2:]...)
+
+	switch args.Subcommand {
+	case "list":
+		err = promptList()
+	case "show":
+		var nameArgs promptNameArgs
+		arg.MustParse(&nameArgs)
+		err = promptShow(nameArgs.Name)
+	case "add":
+		var addArgs promptAddArgs
+		arg.MustParse(&addArgs)
+		err = promptAdd(addArgs.Name, addArgs.File)
+	case "edit":
+		var nameArgs promptNameArgs
+		arg.MustParse(&nameArgs)
+		err = promptEdit(nameArgs.Name)
+	case "rm":
+		var nameArgs promptNameArgs
+		arg.MustParse(&nameArgs)
+		err = prompts.DeleteTemplate(nameArgs.Name)
+	case "render":
+		var renderArgs promptRenderArgs
+		arg.MustParse(&renderArgs)
+		err = promptRender(renderArgs.Name, renderArgs.Vars)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown subcommand: %s\n", args.Subcommand)
+		p.WriteHelp(os.Stderr)
+		os.Exit(1)
+	}
+	if err != nil {
+		lib.Fatal(err)
+	}
+}
+
+func promptList() error {
+	names, err := prompts.ListTemplates()
+	if err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		util.Infof("no prompt templates in %s", prompts.TemplateDir())
+		return nil
+	}
+	for _, name := range names {
+		fmt.Println(name)
+	}
+	return nil
+}
+
+func promptShow(name string) error {
+	text, err := prompts.LoadTemplate(name)
+	if err != nil {
+		return err
+	}
+	fmt.Print(text)
+	return nil
+}
+
+func promptAdd(name, file string) error {
+	var data []byte
+	var err error
+	if file != "" {
+		data, err = os.ReadFile(file)
+	} else {
+		data, err = io.ReadAll(os.Stdin)
+	}
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return fmt.Errorf("empty template")
+	}
+	if err := prompts.SaveTemplate(name, string(data)); err != nil {
+		return err
+	}
+	path, _ := prompts.TemplatePath(name)
+	util.Infof("saved %s", path)
+	return nil
+}
+
+func promptEdit(name string) error {
+	path, err := prompts.TemplatePath(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(prompts.TemplateDir(), 0755); err != nil {
+		return err
+	}
+	editor := os.Getenv("EDITOR")
+	if editor == "" {
+		editor = "vi"
+	}
+	cmd := exec.Command("sh", "-c", editor+` "$1"`, "sh", path)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s: %w", editor, err)
+	}
+	text, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return prompts.SaveTemplate(name, string(text))
+}
+
+func promptRender(name string, pairs []string) error {
+	vars, err := prompts.ParseVars(pairs)
+	if err != nil {
+		return err
+	}
+	text, err := prompts.RenderTemplate(name, vars)
+	if err != nil {
+		return err
+	}
+	fmt.Print(text)
+	return nil
+}
@@ -0,0 +1,449 @@
+// rename renames a top level go identifier across a module. References are
+// found by parsing every package: uses in the declaring package that resolve
+// to the declaration, and qualified or dot imported uses in packages that
+// import it. Each changed line becomes a range update, the module is built,
+// and every file is restored when the build fails
+package rename
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/alexflint/go-arg"
+	"github.com/nathants/nina/lib"
+	"github.com/nathants/nina/util"
+)
+
+func init() {
+	lib.Commands["rename"] = rename
+	lib.Args["rename"] = renameArgs{}
+}
+
+type renameArgs struct {
+	Old        string `arg:"positional,required" help:"identifier to rename"`
+	New        string `arg:"positional,required" help:"new name"`
+	Package    string `arg:"-p,--package" help:"directory of the declaring package, when several declare the name"`
+	DryRun     bool   `arg:"-n,--dry-run" help:"print the changes without writing them"`
+	Check      string `arg:"--check" default:"go build ./... && go vet ./..." help:"command run from the module root to validate the rename"`
+	NoComments bool   `arg:"--no-comments" help:"leave mentions in comments unchanged"`
+}
+
+func (renameArgs) Description() string {
+	return `rename - Rename a go identifier across the module
+
+Renames a top level function, type, constant, or variable, along with
+every reference to it in the module and whole word mentions in the
+comments of changed files. The module is then validated with --check
+and all files are restored if it fails.
+
+Fields and methods are not supported, their references cannot be told
+apart without type information.
+
+Example:
+  nina rename ParseFileUpdates ParseChanges
+  nina rename -p lib/processors newState newLoopState -n`
+}
+
+var identRegex = regexp.MustCompile(`^[\p{L}_][\p{L}\p{N}_]*$`)
+
+// edit replaces the identifier at a byte offset
+type edit struct {
+	Offset int
+	Length int
+}
+
+// goPackage is the parsed files of one package in one directory
+type goPackage struct {
+	Dir        string
+	ImportPath string
+	Name       string
+	Files      map[string]*ast.File
+}
+
+// modulePath returns the module root and path of the go.mod above dir
+func modulePath(dir string) (string, string, error) {
+	for d := dir; ; d = filepath.Dir(d) {
+		data, err := os.ReadFile(filepath.Join(d, "go.mod"))
+		if err == nil {
+			for line := range strings.SplitSeq(string(data), "\n") {
+				if name, ok := strings.CutPrefix(strings.TrimSpace(line), "module "); ok {
+					return d, strings.Trim(strings.TrimSpace(name), `"`), nil
+				}
+			}
+			return "", "", fmt.Errorf("no module directive in %s", filepath.Join(d, "go.mod"))
+		}
+		if filepath.Dir(d) == d {
+			return "", "", fmt.Errorf("no go.mod found above %s", dir)
+		}
+	}
+}
+
+// loadPackages parses every go file in the module, keyed by directory and
+// package name so external test packages are separate
+func loadPackages(fset *token.FileSet, root, module string) ([]*goPackage, error) {
+	byKey := map[string]*goPackage{}
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			name := d.Name()
+			if p != root && (strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") || name == "vendor" || name == "testdata" || name == "agents") {
+				return filepath.SkipDir
+			}
+			if _, err := os.Stat(filepath.Join(p, "go.mod")); p != root && err == nil {
+				return filepath.SkipDir // nested module
+			}
+			return nil
+		}
+		if !strings.HasSuffix(p, ".go") {
+			return nil
+		}
+		file, err := parser.ParseFile(fset, p, nil, parser.ParseComments)
+		if err != nil {
+			return err
+		}
+		dir := filepath.Dir(p)
+		key := dir + "\x00" + file.Name.Name
+		pkg, ok := byKey[key]
+		if !ok {
+			rel, _ := filepath.Rel(root, dir)
+			pkg = &goPackage{Dir: dir, ImportPath: path.Join(module, filepath.ToSlash(rel)), Name: file.Name.Name, Files: map[string]*ast.File{}}
+			byKey[key] = pkg
+		}
+		pkg.Files[p] = file
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	var pkgs []*goPackage
+	for _, pkg := range byKey {
+		pkgs = append(pkgs, pkg)
+	}
+	sort.Slice(pkgs, func(i, j int) bool { return pkgs[i].Dir+pkgs[i].Name < pkgs[j].Dir+pkgs[j].Name })
+	return pkgs, nil
+}
+
+// topLevel returns the package level declaration of name, nil when absent
+func topLevel(pkg *goPackage, name string) *ast.Ident {
+	for _, file := range pkg.Files {
+		for _, decl := range file.Decls {
+			switch d := decl.(type) {
+			case *ast.FuncDecl:
+				if d.Recv == nil && d.Name.Name == name {
+					return d.Name
+				}
+			case *ast.GenDecl:
+				for _, spec := range d.Specs {
+					switch s := spec.(type) {
+					case *ast.TypeSpec:
+						if s.Name.Name == name {
+							return s.Name
+						}
+					case *ast.ValueSpec:
+						for _, n := range s.Names {
+							if n.Name == name {
+								return n
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// skipIdents returns identifiers named like the target that are not
+// references to it: selected fields and methods, method names, struct fields,
+// and composite literal keys
+func skipIdents(file *ast.File, name string) map[*ast.Ident]bool {
+	skip := map[*ast.Ident]bool{}
+	ast.Inspect(file, func(n ast.Node) bool {
+		switch x := n.(type) {
+		case *ast.SelectorExpr:
+			skip[x.Sel] = true
+		case *ast.FuncDecl:
+			if x.Recv != nil {
+				skip[x.Name] = true
+			}
+		case *ast.Field:
+			for _, n := range x.Names {
+				skip[n] = true
+			}
+		case *ast.KeyValueExpr:
+			if key, ok := x.Key.(*ast.Ident); ok && key.Name == name {
+				skip[key] = true
+			}
+		}
+		return true
+	})
+	return skip
+}
+
+// findEdits returns the offsets to change per file
+func findEdits(fset *token.FileSet, pkgs []*goPackage, decl *goPackage, old string) map[string][]edit {
+	edits := map[string][]edit{}
+	add := func(id *ast.Ident) {
+		pos := fset.Position(id.Pos())
+		edits[pos.Filename] = append(edits[pos.Filename], edit{Offset: pos.Offset, Length: len(old)})
+	}
+
+	declIdent := topLevel(decl, old)
+	for _, file := range decl.Files {
+		skip := skipIdents(file, old)
+		ast.Inspect(file, func(n ast.Node) bool {
+			id, ok := n.(*ast.Ident)
+			if !ok || id.Name != old || skip[id] {
+				return true
+			}
+			// the parser resolves names within a file, a different object
+			// is a local declaration shadowing the package level one
+			if id == declIdent || id.Obj == nil || id.Obj == declIdent.Obj {
+				add(id)
+			}
+			return true
+		})
+	}
+	if !ast.IsExported(old) {
+		return edits
+	}
+
+	for _, pkg := range pkgs {
+		if pkg == decl {
+			continue
+		}
+		for _, file := range pkg.Files {
+			local, dot := "", false
+			for _, imp := range file.Imports {
+				importPath, _ := strconv.Unquote(imp.Path.Value)
+				if importPath != decl.ImportPath {
+					continue
+				}
+				local = decl.Name
+				if imp.Name != nil {
+					local = imp.Name.Name
+				}
+				dot = local == "."
+			}
+			if local == "" || local == "_" {
+				continue
+			}
+			skip := skipIdents(file, old)
+			ast.Inspect(file, func(n ast.Node) bool {
+				switch x := n.(type) {
+				case *ast.SelectorExpr:
+					if pkgIdent, ok := x.X.(*ast.Ident); ok && !dot && pkgIdent.Name == local && pkgIdent.Obj == nil && x.Sel.Name == old {
+						add(x.Sel)
+					}
+				case *ast.Ident:
+					if dot && x.Name == old && x.Obj == nil && !skip[x] {
+						add(x)
+					}
+				}
+				return true
+			})
+		}
+	}
+	return edits
+}
+
+// commentEdits returns whole word mentions of old in a file's comments
+func commentEdits(fset *token.FileSet, file *ast.File, old string) []edit {
+	word := regexp.MustCompile(`\b` + regexp.QuoteMeta(old) + `\b`)
+	var edits []edit
+	for _, group := range file.Comments {
+		for _, c := range group.List {
+			start := fset.Position(c.Pos()).Offset
+			for _, loc := range word.FindAllStringIndex(c.Text, -1) {
+				edits = append(edits, edit{Offset: start + loc[0], Length: len(old)})
+			}
+		}
+	}
+	return edits
+}
+
+// lineUpdates applies edits to content and returns one range update per
+// changed line, which keeps line numbers stable across updates
+func lineUpdates(content string, edits []edit, name string) []util.FileUpdate {
+	sort.Slice(edits, func(i, j int) bool { return edits[i].Offset < edits[j].Offset })
+	var buf bytes.Buffer
+	last := 0
+	for _, e := range edits {
+		if e.Offset < last {
+			continue // a duplicate
+		}
+		buf.WriteString(content[last:e.Offset])
+		buf.WriteString(name)
+		last = e.Offset + e.Length
+	}
+	buf.WriteString(content[last:])
+
+	before := strings.Split(content, "\n")
+	after := strings.Split(buf.String(), "\n")
+	var updates []util.FileUpdate
+	for i := range before {
+		if before[i] != after[i] {
+			updates = append(updates, util.FileUpdate{
+				SearchLines:  []string{before[i]},
+				ReplaceLines: []string{after[i]},
+				StartLine:    i + 1,
+				EndLine:      i + 1,
+			})
+		}
+	}
+	return updates
+}
+
+func run(args renameArgs) error {
+	if !identRegex.MatchString(args.Old) || !identRegex.MatchString(args.New) {
+		return fmt.Errorf("both names must be go identifiers")
+	}
+	if ast.IsExported(args.Old) != ast.IsExported(args.New) {
+		util.Errorf("warning: renaming %s to %s changes whether it is exported", args.Old, args.New)
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	root, module, err := modulePath(cwd)
+	if err != nil {
+		return err
+	}
+	fset := token.NewFileSet()
+	pkgs, err := loadPackages(fset, root, module)
+	if err != nil {
+		return err
+	}
+
+	var candidates []*goPackage
+	for _, pkg := range pkgs {
+		if args.Package != "" {
+			dir, err := filepath.Abs(args.Package)
+			if err != nil {
+				return err
+			}
+			if pkg.Dir != dir {
+				continue
+			}
+		}
+		if topLevel(pkg, args.Old) != nil {
+			candidates = append(candidates, pkg)
+		}
+	}
+	switch len(candidates) {
+	case 0:
+		return fmt.Errorf("no package declares %s", args.Old)
+	case 1:
+	default:
+		var dirs []string
+		for _, pkg := range candidates {
+			rel, _ := filepath.Rel(cwd, pkg.Dir)
+			dirs = append(dirs, rel)
+		}
+		return fmt.Errorf("%s is declared in several packages, pick one with --package: %s", args.Old, strings.Join(dirs, ", "))
+	}
+	decl := candidates[0]
+	if topLevel(decl, args.New) != nil {
+		return fmt.Errorf("%s is already declared in %s", args.New, decl.ImportPath)
+	}
+
+	edits := findEdits(fset, pkgs, decl, args.Old)
+	if !args.NoComments {
+		for _, pkg := range pkgs {
+			for filename, file := range pkg.Files {
+				if _, ok := edits[filename]; ok {
+					edits[filename] = append(edits[filename], commentEdits(fset, file, args.Old)...)
+				}
+			}
+		}
+	}
+
+	var files []string
+	for filename := range edits {
+		files = append(files, filename)
+	}
+	sort.Strings(files)
+
+	originals := map[string]string{}
+	updated := map[string]string{}
+	sites := 0
+	for _, filename := range files {
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			return err
+		}
+		updates := lineUpdates(string(data), edits[filename], args.New)
+		content, err := util.ApplyFileUpdates(string(data), updates)
+		if err != nil {
+			return fmt.Errorf("failed to apply updates to %s: %w", filename, err)
+		}
+		originals[filename] = string(data)
+		updated[filename] = content
+		sites += len(edits[filename])
+		if args.DryRun {
+			rel, _ := filepath.Rel(cwd, filename)
+			for _, u := range updates {
+				fmt.Printf("%s:%d\n- %s\n+ %s\n", rel, u.StartLine, u.SearchLines[0], u.ReplaceLines[0])
+			}
+		}
+	}
+	if args.DryRun {
+		return nil
+	}
+
+	restore := func() {
+		for filename, content := range originals {
+			if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+				util.Errorf("failed to restore %s: %v", filename, err)
+			}
+		}
+	}
+	for _, filename := range files {
+		if err := util.CheckPathAllowed(filename); err != nil {
+			return err
+		}
+	}
+	for _, filename := range files {
+		if err := os.WriteFile(filename, []byte(updated[filename]), 0644); err != nil {
+			restore()
+			return fmt.Errorf("failed to write %s: %w", filename, err)
+		}
+	}
+
+	if args.Check != "" {
+		util.Infof("$ %s", args.Check)
+		cmd := exec.Command("sh", "-c", args.Check)
+		cmd.Dir = root
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			restore()
+			_, _ = os.Stderr.Write(out)
+			return fmt.Errorf("check failed, restored %d files: %w", len(files), err)
+		}
+	}
+	util.Infof("renamed %s to %s at %d sites in %d files", args.Old, args.New, sites, len(files))
+	return nil
+}
+
+func rename() {
+	var args renameArgs
+	arg.MustParse(&args)
+
+	if err := run(args); err != nil {
+		lib.Fatal(err)
+	}
+}
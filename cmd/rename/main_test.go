@@ -0,0 +1,120 @@
+package rename
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeModule(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+var module = map[string]string{
+	"go.mod": "module example.com/m\n\ngo 1.24\n",
+	"a/a.go": `package a
+
+// Parse reads a value
+func Parse(s string) int { return len(s) }
+
+type T struct{ Parse int }
+
+func (T) Parse() {}
+`,
+	"a/b.go": `package a
+
+func use() int {
+	n := Parse("x")
+	{
+		Parse := 1
+		n += Parse
+	}
+	return n + T{Parse: 1}.Parse
+}
+`,
+	"b/b.go": `package b
+
+import aa "example.com/m/a"
+
+// calls Parse
+var N = aa.Parse("y")
+`,
+}
+
+func read(t *testing.T, dir, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestRename(t *testing.T) {
+	dir := writeModule(t, module)
+	t.Chdir(dir)
+	if err := run(renameArgs{Old: "Parse", New: "Decode", Check: "true"}); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"a/a.go": `package a
+
+// Decode reads a value
+func Decode(s string) int { return len(s) }
+
+type T struct{ Parse int }
+
+func (T) Parse() {}
+`,
+		"a/b.go": `package a
+
+func use() int {
+	n := Decode("x")
+	{
+		Parse := 1
+		n += Parse
+	}
+	return n + T{Parse: 1}.Parse
+}
+`,
+		"b/b.go": `package b
+
+import aa "example.com/m/a"
+
+// calls Decode
+var N = aa.Decode("y")
+`,
+	}
+	for name, content := range want {
+		if got := read(t, dir, name); got != content {
+			t.Errorf("%s:\n%s\nwant:\n%s", name, got, content)
+		}
+	}
+}
+
+func TestRenameRollsBack(t *testing.T) {
+	dir := writeModule(t, module)
+	t.Chdir(dir)
+	if err := run(renameArgs{Old: "Parse", New: "Decode", Check: "false"}); err == nil {
+		t.Fatal("expected the failed check to be reported")
+	}
+	for name, content := range module {
+		if got := read(t, dir, name); got != content {
+			t.Errorf("%s was not restored:\n%s", name, got)
+		}
+	}
+	if err := run(renameArgs{Old: "Parse", New: "T", Check: "true"}); err == nil {
+		t.Error("expected a conflict with an existing declaration")
+	}
+}
@@ -1,13 +1,19 @@
 package run
 
 import (
+	"context"
 	"io"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/alexflint/go-arg"
 	"github.com/nathants/nina/lib"
 	"github.com/nathants/nina/lib/processors"
+	"github.com/nathants/nina/models"
+	"github.com/nathants/nina/prompts"
+	"github.com/nathants/nina/util"
+	"github.com/nathants/nina/workspace"
 )
 
 func init() {
@@ -16,12 +22,37 @@ func init() {
 }
 
 type runArgs struct {
-	Model     string `arg:"-m,--model" default:"o3" help:"o3, gemini, opus, sonnet, grok, k2"`
-	MaxTokens int    `arg:"--max-tokens" default:"200000" help:"Maximum tokens to use"`
-	Debug     bool   `arg:"-d,--debug" help:"Show raw NinaInput and NinaOutput XML content"`
-	UUID      string `arg:"--uuid" help:"UUID for process tracking (used by integration tests)"`
-	Continue  bool   `arg:"-c,--continue" help:"Continue the last conversation from agents/api/*.input.json"`
-	Thinking  bool   `arg:"-t,--thinking" help:"Enable thinking mode for supported models"`
+	Model     string        `arg:"-m,--model" default:"o3" help:"o3, gemini, opus, sonnet, grok, k2"`
+	MaxTokens int           `arg:"--max-tokens" default:"200000" help:"Maximum tokens to use"`
+	Debug     bool          `arg:"-d,--debug" help:"Show raw NinaInput and NinaOutput XML content"`
+	UUID      string        `arg:"--uuid" help:"UUID for process tracking (used by integration tests)"`
+	Continue  bool          `arg:"-c,--continue" help:"Continue the last conversation from agents/api/*.input.json"`
+	Thinking  bool          `arg:"-t,--thinking" help:"Enable thinking mode for supported models"`
+	Effort    string        `arg:"--effort" help:"Reasoning effort for openai reasoning models: low, medium, high"`
+	Budget    int           `arg:"--thinking-budget" help:"Thinking token budget for claude and gemini models, implies --thinking"`
+	System    string        `arg:"--system" help:"Replace the system prompt"`
+	SysFile   string        `arg:"--system-file" help:"Replace the system prompt with the contents of a file"`
+	AppendSys string        `arg:"--append-system" help:"Append text to the system prompt"`
+	Template  string        `arg:"-p,--prompt" help:"Prompt template from 'nina prompt list', stdin is appended when given"`
+	Vars      []string      `arg:"--var,separate" help:"Prompt template variable as key=value"`
+	AllowPath []string      `arg:"--allow-path,separate" help:"Additional directory changes may write to, outside the git root"`
+	Protect   []string      `arg:"--protect,separate" help:"Path or glob changes and commands may not write, e.g. .github/workflows or '*.lock', adds to NINA_PROTECT"`
+	Exec      string        `arg:"--exec" help:"Execution backend: local, ssh://[user@]host[:path], or docker:<image>[?network=none&memory=2g&cpus=2]"`
+	TUI       bool          `arg:"--tui" help:"Show a live full screen status view instead of the scrolling status bar, when stdout is a terminal"`
+	Notify    []string      `arg:"--notify,separate" help:"Notify when the run completes or fails: desktop or a webhook/slack url, adds to NINA_NOTIFY"`
+	Validate  string        `arg:"--validate" help:"Shell command run after each response's changes are written, they are all reverted if it fails, sets NINA_VALIDATE"`
+	Output    string        `arg:"--output-format" default:"text" help:"Output format: text, or stream-json for newline delimited json events on stdout"`
+	Replay    string        `arg:"--replay" help:"Replay the responses recorded in an agents/api session (timestamp, path, or latest) instead of calling the model"`
+	Remote    bool          `arg:"--remote" help:"Pull sessions from NINA_REMOTE (s3://bucket/prefix, a directory, or an rclone remote) before running and push them after"`
+	NoPlan    bool          `arg:"--no-plan" help:"Skip planning, by default the first response writes a TODO.md checklist and NinaStop is refused until its critical items are ticked"`
+	Speculate string        `arg:"--speculate" help:"Draft model, e.g. flash, answering every message in parallel, its response is used when the primary model fails or times out"`
+	SpecAfter time.Duration `arg:"--speculate-timeout" help:"With --speculate, how long the primary model may take before the draft is used (default 5m)"`
+	Summarize string        `arg:"--summarize" help:"Cheap model, e.g. flash, summarizing long command output before it is sent, the full output is kept under agents/artifacts"`
+	SumCmds   []string      `arg:"--summarize-cmd,separate" help:"With --summarize, a regexp of commands whose output is always summarized, e.g. '^go test'"`
+	TitleMdl  string        `arg:"--title-model" help:"Cheap model, e.g. flash, titling the session for 'nina sessions search', by default the title is the prompt's first line"`
+	Downshift []string      `arg:"--downshift,separate" help:"Switch to a cheaper model once the session costs this many USD, e.g. 5 for the model's cheaper tier (sonnet to flash, o3 to o4-mini) or 5=flash, repeat for more thresholds"`
+	Steer     bool          `arg:"--steer" help:"Read steering messages from stdin, one per line, instead of the prompt, for editor integrations. Lines typed into a terminal are always read"`
+	ToolFmt   string        `arg:"--tool-format" default:"xml" help:"How the model calls tools: xml for Nina tags in the response, or native for the provider's tool calling (claude, openai, and gemini models)"`
 }
 
 func (runArgs) Description() string {
@@ -31,23 +62,91 @@ func (runArgs) Description() string {
 func run() {
 	var args runArgs
 	arg.MustParse(&args)
+	util.AllowPaths(args.AllowPath)
+	util.ProtectPaths(args.Protect)
+	lib.AddNotifyTargets(args.Notify)
+	if args.Validate != "" {
+		_ = os.Setenv("NINA_VALIDATE", args.Validate)
+	}
+	if err := workspace.Use(args.Exec); err != nil {
+		lib.Fatal(lib.WithKind(lib.ErrorTool, err))
+	}
+	if err := lib.ValidateOutputFormat(args.Output); err != nil {
+		lib.Fatal(err)
+	}
+
+	// Resolve the replayed session before this run starts its own
+	replay := ""
+	if args.Replay != "" {
+		if args.Continue {
+			lib.LogError("Error: --replay cannot be used with --continue")
+			os.Exit(1)
+		}
+		dir, err := lib.ReplaySessionDir(args.Replay)
+		if err != nil {
+			lib.Fatal(err)
+		}
+		replay = dir
+	}
+
+	processor, err := processors.ForFormat(args.ToolFmt)
+	if err != nil {
+		lib.Fatal(err)
+	}
+
+	model, err := models.SetReasoning(args.Model, args.Effort, args.Budget)
+	if err != nil {
+		lib.Fatal(err)
+	}
+	system, err := prompts.NewOverride(args.System, args.SysFile, args.AppendSys)
+	if err != nil {
+		lib.Fatal(err)
+	}
+	downshift, err := lib.ParseDownshift(args.Downshift)
+	if err != nil {
+		lib.Fatal(err)
+	}
+
+	// Pull sessions started elsewhere before picking the one to continue
+	if args.Remote {
+		if err := lib.PullRemote(context.Background()); err != nil {
+			lib.Fatal(err)
+		}
+	}
 
 	// Initialize session for proper log numbering
 	lib.InitializeSession(args.Continue)
+	if err := lib.WriteModelSettings(lib.GetTimestampedAgentsPath("api", "model.json"), model); err != nil {
+		lib.LogError("Failed to record model settings: %v", err)
+	}
 
-	// Read stdin content
+	// Read stdin content, unless it carries steering messages
 	stdinContent := ""
 	stat, _ := os.Stdin.Stat()
-	if (stat.Mode() & os.ModeCharDevice) == 0 {
+	terminal := (stat.Mode() & os.ModeCharDevice) != 0
+	if !terminal && !args.Steer {
 		// Input is available (pipe or redirect)
 		stdinBytes, err := io.ReadAll(os.Stdin)
 		if err != nil {
-			lib.LogStderr("Failed to read stdin: %v", err)
+			lib.LogError("Failed to read stdin: %v", err)
 			os.Exit(1)
 		}
 		stdinContent = strings.TrimSpace(string(stdinBytes))
 	}
 
+	// Render the prompt template ahead of stdin
+	if args.Template != "" {
+		vars, err := prompts.ParseVars(args.Vars)
+		if err != nil {
+			lib.Fatal(err)
+		}
+		text, err := prompts.RenderTemplate(args.Template, vars)
+		if err != nil {
+			lib.Fatal(err)
+		}
+		stdinContent = strings.TrimSpace(strings.TrimSpace(text) + "\n\n" + stdinContent)
+	}
+
 	// Check for TASK.md and use as prompt if no stdin
 	if stdinContent == "" {
 		if taskData, err := os.ReadFile("TASK.md"); err == nil && len(taskData) > 0 {
@@ -56,27 +155,68 @@ func run() {
 				stdinContent = taskContent
 				// Wipe TASK.md after reading
 				if err := os.Truncate("TASK.md", 0); err != nil {
-					lib.LogStderr("Failed to truncate TASK.md: %v", err)
+					lib.LogError("Failed to truncate TASK.md: %v", err)
 				}
 			}
 		}
 	}
 
-	// Create loop configuration with XML tool processor
+	// Tell the model when it is working on another machine
+	if note := workspace.Describe(workspace.Current()); note != "" && stdinContent != "" {
+		stdinContent += "\n\n" + note
+	}
+
+	// Create loop configuration with the tool processor of --tool-format
 	config := lib.LoopConfig{
 		Model:         args.Model,
 		MaxTokens:     args.MaxTokens,
 		Debug:         args.Debug,
 		UUID:          args.UUID,
 		Continue:      args.Continue,
-		ToolProcessor: &processors.XMLToolProcessor{},
+		ToolProcessor: processor,
 		StdinContent:  stdinContent,
-		Thinking:      args.Thinking,
+		Thinking:      args.Thinking || args.Budget > 0,
+		System:        system,
+		Replay:        replay,
+		Plan:          !args.NoPlan,
+		Speculate:     args.Speculate,
+		DraftAfter:    args.SpecAfter,
+		Summarize:     args.Summarize,
+		SummarizeCmds: args.SumCmds,
+		TitleModel:    args.TitleMdl,
+		Downshift:     downshift,
+	}
+
+	// Lines typed while the loop runs are sent with the next message, or
+	// pause, report on, or stop it
+	if terminal || args.Steer {
+		if terminal {
+			lib.LogStderr("Type a message and press enter to steer, or press p to pause, s for status, q to stop")
+		}
+		lib.StartSteering(os.Stdin)
 	}
 
 	// Run the main loop
-	if err := lib.RunLoop(config); err != nil {
-		lib.LogStderr("Error: %v", err)
-		os.Exit(1)
+	if args.Output == lib.OutputStreamJSON {
+		lib.StartEventStream()
+	} else if args.TUI && lib.TUIAvailable() {
+		lib.StartTUI(args.Model)
+	}
+	err = lib.RunLoop(config)
+	lib.StopTUI()
+	lib.StopSteering()
+	lib.StopEventStream(err)
+	if closeErr := workspace.Close(); closeErr != nil {
+		lib.LogError("Failed to close workspace: %v", closeErr)
+	}
+	if args.Remote {
+		if pushErr := lib.PushRemote(context.Background()); pushErr != nil {
+			lib.LogError("Failed to push sessions: %v", pushErr)
+		}
+	}
+	if err != nil {
+		lib.Notify(lib.NotifyFail, err.Error())
+		lib.Fatal(err)
 	}
+	lib.Notify(lib.NotifyComplete, "finished")
 }
@@ -0,0 +1,135 @@
+// sessions lists and searches the index of past runs, their titles, prompts,
+// and changed files, to find the session of an earlier change
+package sessions
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/alexflint/go-arg"
+	"github.com/nathants/nina/lib"
+	"github.com/nathants/nina/util"
+)
+
+func init() {
+	lib.Commands["sessions"] = sessionsMain
+	lib.Args["sessions"] = sessionsMainArgs{}
+}
+
+type sessionsMainArgs struct {
+	Subcommand string   `arg:"positional" help:"Subcommand to run (list, search, tag)"`
+	Args       []string `arg:"positional" help:"Arguments for subcommand"`
+}
+
+func (sessionsMainArgs) Description() string {
+	return `sessions - Find past runs
+
+When nina run ends it records the session id, a title, the
+prompt, and the changed files in agents/sessions.jsonl, or
+$NINA_SESSIONS_FILE. The title is the prompt's first line, or
+written by a cheap model with nina run --title-model flash.
+
+Available subcommands:
+  list             - List sessions, newest first
+  search <terms>   - List sessions whose title, prompt, or changed
+                     files contain every term, ignoring case
+  tag <id> <tags>  - Tag a session, e.g. good, for nina dataset
+
+Listed sessions show whether their last test command passed, and
+their tags. The session id works with nina run --replay and under
+agents/.
+
+Examples:
+  nina sessions search tokenizer
+  nina sessions search fix lib/parser.go
+  nina sessions tag 20250701-093000 good`
+}
+
+type sessionsListArgs struct {
+	Limit int `arg:"-n,--limit" default:"20" help:"Sessions to list, 0 for all"`
+}
+
+type sessionsTagArgs struct {
+	ID   string   `arg:"positional,required" help:"Session id"`
+	Tags []string `arg:"positional,required" help:"Tags to add"`
+}
+
+type sessionsSearchArgs struct {
+	Terms []string `arg:"positional,required" help:"Terms every listed session contains"`
+	Limit int      `arg:"-n,--limit" default:"20" help:"Sessions to list, 0 for all"`
+	Files bool     `arg:"-f,--files" help:"Print the changed files of each session"`
+}
+
+func sessionsMain() {
+	var args sessionsMainArgs
+	p, err := arg.NewParser(arg.Config{
+		Program: "nina sessions",
+	}, &args)
+	if err != nil {
+		lib.Fatal(err)
+	}
+
+	if len(os.Args) < 2 || os.Args[1] == "-h" || os.Args[1] == "--help" {
+		p.WriteHelp(os.Stdout)
+		os.Exit(0)
+	}
+
+	err = p.Parse(os.Args[1:2])
+	if err != nil {
+		p.WriteHelp(os.Stderr)
+		os.Exit(1)
+	}
+
+	os.Args = append([]string{"nina sessions " + args.Subcommand}, os.Args[2:]...)
+
+	records, err := lib.ReadSessions()
+	if err != nil {
+		lib.Fatal(err)
+	}
+
+	switch args.Subcommand {
+	case "list":
+		var listArgs sessionsListArgs
+		arg.MustParse(&listArgs)
+		printSessions(records, listArgs.Limit, false)
+	case "search":
+		var searchArgs sessionsSearchArgs
+		arg.MustParse(&searchArgs)
+		printSessions(lib.SearchSessions(records, searchArgs.Terms), searchArgs.Limit, searchArgs.Files)
+	case "tag":
+		var tagArgs sessionsTagArgs
+		arg.MustParse(&tagArgs)
+		if err := lib.TagSession(tagArgs.ID, tagArgs.Tags); err != nil {
+			lib.Fatal(err)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown subcommand: %s\n", args.Subcommand)
+		p.WriteHelp(os.Stderr)
+		os.Exit(1)
+	}
+}
+
+func printSessions(records []lib.SessionRecord, limit int, files bool) {
+	if len(records) == 0 {
+		util.Infof("no sessions found in %s", lib.SessionsIndexPath())
+		return
+	}
+	if limit > 0 && len(records) > limit {
+		records = records[:limit]
+	}
+	for _, record := range records {
+		line := fmt.Sprintf("%s  %s  %-8s %s", record.ID, record.Time.Local().Format("2006-01-02 15:04"), record.Model, record.Title)
+		if record.Tests != "" {
+			line += "  [tests " + record.Tests + "]"
+		}
+		for _, tag := range record.Tags {
+			line += "  #" + tag
+		}
+		fmt.Println(line)
+		if files {
+			for _, file := range record.Files {
+				fmt.Printf("    %s\n", file)
+			}
+		}
+	}
+}
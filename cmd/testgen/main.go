@@ -0,0 +1,342 @@
+// testgen writes tests for source files through the architect pipeline
+// sends the file and its package with ARCHITECT.md, applies the NinaChange
+// to the test file next to the source, then compiles or runs the tests and
+// feeds failures back to the model until they pass or iterations run out
+package testgen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/alexflint/go-arg"
+	"github.com/nathants/nina/lib"
+	"github.com/nathants/nina/models"
+	"github.com/nathants/nina/prompts"
+	"github.com/nathants/nina/util"
+)
+
+func init() {
+	lib.Commands["testgen"] = testgen
+	lib.Args["testgen"] = testgenArgs{}
+}
+
+type testgenArgs struct {
+	File       string `arg:"positional,required" help:"source file to test"`
+	Model      string `arg:"-m,--model" default:"sonnet" help:"AI model to use"`
+	Output     string `arg:"-o,--output" help:"test file to write (default: next to the source, named for its language)"`
+	Run        bool   `arg:"-r,--run" help:"run the tests instead of only compiling them"`
+	Check      string `arg:"--check" help:"shell command that verifies the tests (default: for the language, see below)"`
+	Iterations int    `arg:"-i,--iterations" default:"3" help:"attempts to fix failing tests before giving up"`
+	Prompt     string `arg:"-p,--prompt" help:"extra instructions, like which behavior to cover"`
+	Converter  string `arg:"--converter" help:"model to locate search text, or 'local' for no AI (default: $NINA_CONVERTER_MODEL or sonnet)"`
+	Verbose    bool   `arg:"-v,--verbose" help:"verbose output"`
+}
+
+func (testgenArgs) Description() string {
+	return `testgen - Generate tests for a source file
+
+Sends the file, the rest of its package, and any existing tests to the
+model, writes the tests it proposes next to the source, and verifies
+them. When verification fails the output goes back to the model, up to
+--iterations times.
+
+  go:     foo.go -> foo_test.go, checked with go vet, run with go test
+  python: foo.py -> test_foo.py, checked with py_compile, run with pytest
+  js/ts:  foo.ts -> foo.test.ts, checked and run with npx jest
+
+Only the test file is ever written, changes to other files are dropped.
+
+Example:
+  nina testgen util/parsing.go
+  nina testgen lib/chaos.go --run -p "cover malformed NINA_CHAOS values"`
+}
+
+// language describes where tests live and how they are verified
+type language struct {
+	TestPath func(source string) string
+	Check    func(testPath string) string // compiles the tests
+	Run      func(testPath string) string // runs the tests
+	Style    string                       // guidance for the prompt
+}
+
+var languages = map[string]language{
+	".go": {
+		TestPath: func(source string) string {
+			return strings.TrimSuffix(source, ".go") + "_test.go"
+		},
+		Check: func(testPath string) string { return "go vet " + shellQuote(pkgDir(testPath)) },
+		Run:   func(testPath string) string { return "go test -count=1 " + shellQuote(pkgDir(testPath)) },
+		Style: "table-driven Go tests using the standard testing package, in the same package as the source",
+	},
+	".py": {
+		TestPath: func(source string) string {
+			return filepath.Join(filepath.Dir(source), "test_"+filepath.Base(source))
+		},
+		Check: func(testPath string) string { return "python3 -m py_compile " + shellQuote(testPath) },
+		Run:   func(testPath string) string { return "python3 -m pytest -q " + shellQuote(testPath) },
+		Style: "pytest tests, parametrized where cases share a shape",
+	},
+	".js": jsLanguage(".js"),
+	".ts": jsLanguage(".ts"),
+}
+
+func jsLanguage(ext string) language {
+	jest := func(testPath string) string { return "npx jest " + shellQuote(testPath) }
+	return language{
+		TestPath: func(source string) string {
+			return strings.TrimSuffix(source, ext) + ".test" + ext
+		},
+		Check: jest,
+		Run:   jest,
+		Style: "jest tests using test.each where cases share a shape",
+	}
+}
+
+// pkgDir returns the go package pattern for a file's directory
+func pkgDir(path string) string {
+	dir := filepath.Dir(path)
+	if !filepath.IsAbs(dir) && !strings.HasPrefix(dir, ".") {
+		dir = "./" + dir
+	}
+	return dir
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// packageFiles returns the source, the other files of its language in the
+// same directory, and the existing test file, skipping binary and large files
+func packageFiles(source, testPath string) (map[string]string, error) {
+	files := map[string]string{}
+	paths := []string{source}
+	matches, err := filepath.Glob(filepath.Join(filepath.Dir(source), "*"+filepath.Ext(source)))
+	if err != nil {
+		return nil, err
+	}
+	paths = append(paths, matches...)
+	if _, err := os.Stat(testPath); err == nil {
+		paths = append(paths, testPath)
+	}
+	for _, path := range paths {
+		if _, ok := files[path]; ok {
+			continue
+		}
+		if err := util.CheckFile(path); err != nil {
+			if errors.Is(err, util.ErrBinaryFile) || errors.Is(err, util.ErrFileTooLarge) {
+				util.Errorf("skipping %v", err)
+				continue
+			}
+			return nil, err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		files[path] = string(data)
+	}
+	return files, nil
+}
+
+func formatNinaInput(prompt string, files map[string]string) string {
+	var builder strings.Builder
+	builder.WriteString(util.NinaInputStart)
+	builder.WriteString("\n\n")
+	builder.WriteString(util.NinaPromptStart)
+	builder.WriteString("\n")
+	builder.WriteString(prompt)
+	builder.WriteString("\n")
+	builder.WriteString(util.NinaPromptEnd)
+	builder.WriteString("\n")
+
+	var paths []string
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		builder.WriteString("\n")
+		builder.WriteString(util.FormatNinaFile(path, files[path]))
+	}
+
+	builder.WriteString("\n")
+	builder.WriteString(util.NinaInputEnd)
+	return builder.String()
+}
+
+// applyUpdates applies the model's changes to the test file one at a time,
+// locating each against the content left by the previous one
+func applyUpdates(ctx context.Context, response, testPath, content string) (string, int, error) {
+	updates, err := util.ParseFileUpdates(response)
+	if err != nil && !util.SkipBrokenChanges(err) {
+		return "", 0, fmt.Errorf("failed to parse AI response: %w", err)
+	}
+	applied := 0
+	for _, update := range updates {
+		if update.FileName != testPath {
+			util.Errorf("warning: dropping change to %s, only %s is written", update.FileName, testPath)
+			continue
+		}
+		session := &util.SessionState{
+			OrigFiles:     map[string]string{testPath: content},
+			SelectedFiles: map[string]string{},
+			PathMap:       map[string]string{testPath: testPath},
+		}
+		ranged, err := lib.ConvertToRangeUpdates(ctx, []util.FileUpdate{update}, session, nil)
+		if err != nil {
+			return "", 0, fmt.Errorf("failed to convert to range updates for %s: %w", testPath, err)
+		}
+		content, err = util.ApplyFileUpdates(content, ranged)
+		if err != nil {
+			return "", 0, fmt.Errorf("failed to apply updates to %s: %w", testPath, err)
+		}
+		applied++
+	}
+	return content, applied, nil
+}
+
+// verify runs the check command, returning its combined output
+func verify(command string) (string, error) {
+	util.Infof("$ %s", command)
+	cmd := exec.Command("sh", "-c", command)
+	out, err := cmd.CombinedOutput()
+	return string(out), err
+}
+
+// maxFeedback bounds the verification output sent back to the model
+const maxFeedback = 20_000
+
+func run(args testgenArgs) error {
+	ctx := context.Background()
+	source, err := filepath.Abs(args.File)
+	if err != nil {
+		return err
+	}
+	lang, ok := languages[filepath.Ext(source)]
+	if !ok && (args.Output == "" || args.Check == "") {
+		return fmt.Errorf("unsupported language for %s, pass --output and --check", args.File)
+	}
+	testPath := args.Output
+	if testPath == "" {
+		testPath = lang.TestPath(source)
+	}
+	if testPath, err = filepath.Abs(testPath); err != nil {
+		return err
+	}
+	if err := util.CheckPathAllowed(testPath); err != nil {
+		return err
+	}
+	check := args.Check
+	if check == "" {
+		check = lang.Check(testPath)
+		if args.Run {
+			check = lang.Run(testPath)
+		}
+	}
+
+	files, err := packageFiles(source, testPath)
+	if err != nil {
+		return err
+	}
+	content, exists := files[testPath]
+
+	style := lang.Style
+	if style == "" {
+		style = "tests in the style of the project"
+	}
+	prompt := fmt.Sprintf("Write %s for %s in %s.\n"+
+		"Cover the exported behavior and the edge cases the code handles, using the other files only as context.\n"+
+		"Only create or change %s, never change the source.\n"+
+		"Tests are verified with: %s",
+		style, source, testPath, testPath, check)
+	if exists {
+		prompt += "\nThe test file already exists, extend it and do not duplicate existing tests."
+	}
+	if args.Prompt != "" {
+		prompt += "\n\n" + args.Prompt
+	}
+
+	codingPrompt, err := prompts.EmbeddedFiles.ReadFile("CODING.md")
+	if err != nil {
+		return fmt.Errorf("failed to read CODING.md prompt: %w", err)
+	}
+	architectPrompt, err := prompts.EmbeddedFiles.ReadFile("ARCHITECT.md")
+	if err != nil {
+		return fmt.Errorf("failed to read ARCHITECT.md prompt: %w", err)
+	}
+
+	// one provider for every attempt so fixes continue the conversation
+	provider, model, err := lib.CreateProviderForModel(args.Model)
+	if err != nil {
+		return err
+	}
+	state := &lib.LoopState{}
+	message := string(codingPrompt) + "\n\n" + formatNinaInput(prompt, files)
+
+	for attempt := 1; ; attempt++ {
+		util.Verbosef("attempt %d: calling %s", attempt, args.Model)
+		response, err := lib.CallAIProvider(provider, model, string(architectPrompt), message, state, false)
+		if err != nil {
+			return fmt.Errorf("AI request failed: %w", err)
+		}
+		updated, applied, err := applyUpdates(ctx, response, testPath, content)
+		if err != nil {
+			return err
+		}
+		if applied == 0 {
+			return fmt.Errorf("model made no changes to %s", testPath)
+		}
+		if err := os.MkdirAll(filepath.Dir(testPath), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(testPath, []byte(updated), 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", testPath, err)
+		}
+		content = updated
+		util.Infof("wrote %s", testPath)
+
+		out, err := verify(check)
+		if err == nil {
+			util.Infof("tests verified after %d attempt(s)", attempt)
+			return nil
+		}
+		if attempt > args.Iterations {
+			_, _ = os.Stderr.WriteString(out)
+			return fmt.Errorf("tests still fail after %d attempts: %w", attempt, err)
+		}
+		util.Infof("verification failed, asking for a fix (%d/%d)", attempt, args.Iterations)
+		if len(out) > maxFeedback {
+			out = out[len(out)-maxFeedback:]
+		}
+		message = formatNinaInput(fmt.Sprintf(
+			"The tests fail verification with `%s`:\n\n%s\n\nFix %s. If the failure is a bug in the source rather than the test, make the test document the current behavior and say so.",
+			check, strings.TrimSpace(out), testPath),
+			map[string]string{testPath: content})
+	}
+}
+
+func testgen() {
+	var args testgenArgs
+	arg.MustParse(&args)
+
+	if args.Verbose {
+		util.SetLogLevel(max(util.GetLogLevel(), util.LogVerbose))
+	}
+	if args.Converter != "" {
+		_ = os.Setenv("NINA_CONVERTER_MODEL", args.Converter)
+	}
+	if _, err := models.Lookup(args.Model); err != nil {
+		lib.Fatal(err)
+	}
+	lib.InitializeSession(false)
+
+	if err := run(args); err != nil {
+		lib.Fatal(err)
+	}
+}
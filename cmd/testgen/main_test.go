@@ -0,0 +1,67 @@
+package testgen
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nathants/nina/util"
+)
+
+func TestTestPaths(t *testing.T) {
+	tests := map[string]string{
+		"/src/lib/parse.go": "/src/lib/parse_test.go",
+		"/src/app/parse.py": "/src/app/test_parse.py",
+		"/src/web/parse.ts": "/src/web/parse.test.ts",
+	}
+	for source, want := range tests {
+		if got := languages[filepath.Ext(source)].TestPath(source); got != want {
+			t.Errorf("TestPath(%s) = %s, want %s", source, got, want)
+		}
+	}
+	if got := languages[".go"].Check("lib/parse_test.go"); got != "go vet './lib'" {
+		t.Errorf("got %s", got)
+	}
+}
+
+func TestPackageFiles(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{"a.go": "package a", "b.go": "package a", "c.txt": "notes"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, err := packageFiles(filepath.Join(dir, "a.go"), filepath.Join(dir, "a_test.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[filepath.Join(dir, "b.go")] != "package a" {
+		t.Errorf("got %v", files)
+	}
+}
+
+func TestApplyUpdates(t *testing.T) {
+	t.Setenv("NINA_CONVERTER_MODEL", "local")
+	t.Setenv("NINA_CACHE_DIR", t.TempDir())
+	t.Setenv("NINA_CONVERT_CORPUS", t.TempDir())
+	change := func(path, search, replace string) string {
+		return util.NinaStart + "\n" + util.NinaPathStart + path + util.NinaPathEnd + "\n" +
+			util.NinaSearchStart + "\n" + search + "\n" + util.NinaSearchEnd + "\n" +
+			util.NinaReplaceStart + "\n" + replace + "\n" + util.NinaReplaceEnd + "\n" + util.NinaEnd + "\n"
+	}
+	response := "<NinaOutput>\n" +
+		change("/a_test.go", "func TestA(t *testing.T) {}", "func TestA(t *testing.T) {\n\tt.Log(1)\n}") +
+		change("/a_test.go", "func TestB(t *testing.T) {}", "func TestB(t *testing.T) {\n\tt.Log(2)\n}") +
+		change("/a.go", "package a", "package b") +
+		"</NinaOutput>"
+	content := "package a\n\nfunc TestA(t *testing.T) {}\n\nfunc TestB(t *testing.T) {}\n"
+	got, applied, err := applyUpdates(context.Background(), response, "/a_test.go", content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "package a\n\nfunc TestA(t *testing.T) {\n\tt.Log(1)\n}\n\nfunc TestB(t *testing.T) {\n\tt.Log(2)\n}\n"
+	if applied != 2 || got != want {
+		t.Errorf("applied %d, got:\n%s", applied, got)
+	}
+}
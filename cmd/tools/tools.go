@@ -9,6 +9,8 @@ import (
 	"github.com/alexflint/go-arg"
 	"github.com/nathants/nina/lib"
 	"github.com/nathants/nina/lib/processors"
+	"github.com/nathants/nina/util"
+	"github.com/nathants/nina/workspace"
 )
 
 func init() {
@@ -17,12 +19,17 @@ func init() {
 }
 
 type toolsArgs struct {
-	Model     string `arg:"-m,--model" default:"sonnet" help:"Model to use (e.g., sonnet, opus, o4-mini, gemini)"`
-	MaxTokens int    `arg:"--max-tokens" default:"200000" help:"Maximum tokens to use"`
-	Debug     bool   `arg:"-d,--debug" help:"Show debug output including tool calls"`
-	UUID      string `arg:"--uuid" help:"UUID for process tracking (used by integration tests)"`
-	Continue  bool   `arg:"-c,--continue" help:"Continue the last conversation from agents/api/*.input.json"`
-	Thinking  bool   `arg:"-t,--thinking" help:"Enable thinking mode for supported models"`
+	Model     string   `arg:"-m,--model" default:"sonnet" help:"Model to use (e.g., sonnet, opus, o4-mini, gemini)"`
+	MaxTokens int      `arg:"--max-tokens" default:"200000" help:"Maximum tokens to use"`
+	Debug     bool     `arg:"-d,--debug" help:"Show debug output including tool calls"`
+	UUID      string   `arg:"--uuid" help:"UUID for process tracking (used by integration tests)"`
+	Continue  bool     `arg:"-c,--continue" help:"Continue the last conversation from agents/api/*.input.json"`
+	Thinking  bool     `arg:"-t,--thinking" help:"Enable thinking mode for supported models"`
+	AllowPath []string `arg:"--allow-path,separate" help:"Additional directory changes may write to, outside the git root"`
+	Protect   []string `arg:"--protect,separate" help:"Path or glob changes and commands may not write, e.g. .github/workflows or '*.lock', adds to NINA_PROTECT"`
+	Exec      string   `arg:"--exec" help:"Execution backend: local, ssh://[user@]host[:path], or docker:<image>[?network=none&memory=2g&cpus=2]"`
+	Notify    []string `arg:"--notify,separate" help:"Notify when the run completes or fails: desktop or a webhook/slack url, adds to NINA_NOTIFY"`
+	Output    string   `arg:"--output-format" default:"text" help:"Output format: text, or stream-json for newline delimited json events on stdout"`
 }
 
 func (toolsArgs) Description() string {
@@ -32,6 +39,15 @@ func (toolsArgs) Description() string {
 func tools() {
 	var args toolsArgs
 	arg.MustParse(&args)
+	util.AllowPaths(args.AllowPath)
+	util.ProtectPaths(args.Protect)
+	lib.AddNotifyTargets(args.Notify)
+	if err := workspace.Use(args.Exec); err != nil {
+		lib.Fatal(lib.WithKind(lib.ErrorTool, err))
+	}
+	if err := lib.ValidateOutputFormat(args.Output); err != nil {
+		lib.Fatal(err)
+	}
 
 	// Initialize session for proper log numbering
 	lib.InitializeSession(args.Continue)
@@ -43,7 +59,7 @@ func tools() {
 		// Input is available (pipe or redirect)
 		stdinBytes, err := io.ReadAll(os.Stdin)
 		if err != nil {
-			lib.LogStderr("Failed to read stdin: %v", err)
+			lib.LogError("Failed to read stdin: %v", err)
 			os.Exit(1)
 		}
 		stdinContent = strings.TrimSpace(string(stdinBytes))
@@ -57,17 +73,22 @@ func tools() {
 				stdinContent = taskContent
 				// Wipe TASK.md after reading
 				if err := os.Truncate("TASK.md", 0); err != nil {
-					lib.LogStderr("Failed to truncate TASK.md: %v", err)
+					lib.LogError("Failed to truncate TASK.md: %v", err)
 				}
 			}
 		}
 	}
 
 	if stdinContent == "" {
-		lib.LogStderr("Error: no input provided")
+		lib.LogError("Error: no input provided")
 		os.Exit(1)
 	}
 
+	// Tell the model when it is working on another machine
+	if note := workspace.Describe(workspace.Current()); note != "" && stdinContent != "" {
+		stdinContent += "\n\n" + note
+	}
+
 	// Create loop configuration with JSON tool processor
 	config := lib.LoopConfig{
 		Model:         args.Model,
@@ -80,9 +101,23 @@ func tools() {
 		Thinking:      args.Thinking,
 	}
 
+	// Lines typed while the loop runs are sent with the next message
+	if (stat.Mode() & os.ModeCharDevice) != 0 {
+		lib.StartSteering(os.Stdin)
+	}
+
 	// Run the main loop
-	if err := lib.RunLoop(config); err != nil {
-		lib.LogStderr("Error: %v", err)
-		os.Exit(1)
+	if args.Output == lib.OutputStreamJSON {
+		lib.StartEventStream()
+	}
+	err := lib.RunLoop(config)
+	lib.StopEventStream(err)
+	if closeErr := workspace.Close(); closeErr != nil {
+		lib.LogError("Failed to close workspace: %v", closeErr)
+	}
+	if err != nil {
+		lib.Notify(lib.NotifyFail, err.Error())
+		lib.Fatal(err)
 	}
+	lib.Notify(lib.NotifyComplete, "finished")
 }
@@ -0,0 +1,124 @@
+// usage reports api token usage and cost from the local usage ledger
+// aggregated by model, day, command, or user over a recent time window, or
+// exports the window's records as csv or json
+package usage
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/alexflint/go-arg"
+	"github.com/nathants/nina/lib"
+	"github.com/nathants/nina/util"
+)
+
+func init() {
+	lib.Commands["usage"] = usage
+	lib.Args["usage"] = usageArgs{}
+}
+
+type usageArgs struct {
+	Since  string `arg:"-s,--since" default:"7d" help:"Report calls newer than this duration (e.g. 24h, 7d) or date (2006-01-02)"`
+	By     string `arg:"-b,--by" default:"model" help:"Group by: model, day, command, or user"`
+	Export string `arg:"-e,--export" help:"Print every record as csv or json instead of a report"`
+}
+
+func (usageArgs) Description() string {
+	return `usage - Report api token usage and cost
+
+Every api call records its model, tokens, cache stats, and
+estimated cost to ~/.nina/usage.jsonl, tagged with $NINA_USER
+when set. This aggregates those records over a time window.
+Savings estimates what cache reads saved over the input price.
+
+Examples:
+  nina usage --since 7d --by model
+  nina usage --since 30d --by day
+  nina usage --since 2025-07-01 --by command
+  NINA_USER=alice nina run ...
+  nina usage --since 30d --by user
+  nina usage --since 30d --export csv > usage.csv`
+}
+
+// parseSince accepts a go duration, a duration in days like 7d, or a date
+func parseSince(s string, now time.Time) (time.Time, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err == nil {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid --since: %s", s)
+}
+
+func usage() {
+	var args usageArgs
+	arg.MustParse(&args)
+
+	since, err := parseSince(args.Since, time.Now())
+	if err != nil {
+		lib.Fatal(err)
+	}
+
+	records, err := lib.ReadUsage(since)
+	if err != nil {
+		lib.Fatal(err)
+	}
+
+	if args.Export != "" {
+		if err := lib.ExportUsage(os.Stdout, records, args.Export); err != nil {
+			lib.Fatal(err)
+		}
+		return
+	}
+
+	summaries, err := lib.AggregateUsage(records, args.By)
+	if err != nil {
+		lib.Fatal(err)
+	}
+
+	if len(summaries) == 0 {
+		util.Infof("no usage recorded since %s", since.Format("2006-01-02 15:04"))
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	_, _ = fmt.Fprintf(w, "%s\tcalls\tinput\toutput\tcache read\tcache write\tcost\tsavings\t\n", strings.ToLower(args.By))
+	var total lib.UsageSummary
+	for _, s := range summaries {
+		printRow(w, s)
+		total.Calls += s.Calls
+		total.Input += s.Input
+		total.Output += s.Output
+		total.CacheRead += s.CacheRead
+		total.CacheWrite += s.CacheWrite
+		total.Cost += s.Cost
+		total.Savings += s.Savings
+	}
+	total.Key = "total"
+	printRow(w, total)
+	_ = w.Flush()
+}
+
+func printRow(w *tabwriter.Writer, s lib.UsageSummary) {
+	_, _ = fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\t$%.2f\t$%.2f\t\n",
+		s.Key,
+		s.Calls,
+		lib.FormatTokens(s.Input),
+		lib.FormatTokens(s.Output),
+		lib.FormatTokens(s.CacheRead),
+		lib.FormatTokens(s.CacheWrite),
+		s.Cost,
+		s.Savings,
+	)
+}
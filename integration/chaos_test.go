@@ -0,0 +1,86 @@
+//go:build integration
+
+// chaos_test.go runs the loop in process against a mock provider with
+// injected faults, checking it retries and recovers instead of crashing.
+package integration
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nathants/nina/lib"
+	"github.com/nathants/nina/lib/processors"
+)
+
+const (
+	chaosBash = "<NinaOutput>\n<NinaBash>echo hello > out.txt</NinaBash>\n</NinaOutput>"
+	chaosStop = "<NinaOutput>\n<NinaStop>done</NinaStop>\n</NinaOutput>"
+)
+
+// runChaosLoop runs the loop in a temp repo with faults injected into mock
+func runChaosLoop(t *testing.T, mock *lib.MockClient, config lib.ChaosConfig) (*lib.ChaosProvider, error) {
+	t.Helper()
+	t.Chdir(CreateTempRepo(t, map[string]string{}))
+	if out, err := exec.Command("git", "init", "-q").CombinedOutput(); err != nil {
+		t.Fatalf("git init: %v %s", err, out)
+	}
+	delay := lib.ProviderRetryDelay
+	lib.ProviderRetryDelay = time.Millisecond
+	t.Cleanup(func() { lib.ProviderRetryDelay = delay })
+
+	chaos := lib.NewChaosProvider(mock, config)
+	err := lib.RunLoop(lib.LoopConfig{
+		Model:         "mock",
+		MaxTokens:     200000,
+		ToolProcessor: &processors.XMLToolProcessor{},
+		StdinContent:  "write hello to out.txt",
+		Provider:      chaos,
+	})
+	return chaos, err
+}
+
+func TestChaosRetriesTransientErrors(t *testing.T) {
+	for _, fault := range []string{lib.FaultTimeout, lib.FaultRateLimit, lib.FaultMalformed} {
+		t.Run(fault, func(t *testing.T) {
+			mock := lib.NewMockClient(chaosBash, chaosStop)
+			chaos, err := runChaosLoop(t, mock, lib.ChaosConfig{Rates: map[string]float64{fault: 1}, Limit: 2})
+			if err != nil {
+				t.Fatalf("loop failed: %v", err)
+			}
+			if len(chaos.Injected) != 2 {
+				t.Fatalf("injected %v, want 2 faults", chaos.Injected)
+			}
+			if got, _ := os.ReadFile("out.txt"); string(got) != "hello\n" {
+				t.Fatalf("out.txt = %q", got)
+			}
+		})
+	}
+}
+
+func TestChaosRecoversFromTruncatedOutput(t *testing.T) {
+	mock := lib.NewMockClient(chaosBash, chaosBash, chaosStop)
+	_, err := runChaosLoop(t, mock, lib.ChaosConfig{Rates: map[string]float64{lib.FaultTruncate: 1}, Limit: 1})
+	if err != nil {
+		t.Fatalf("loop failed: %v", err)
+	}
+	if len(mock.Messages) != 3 {
+		t.Fatalf("got %d calls, want 3", len(mock.Messages))
+	}
+	if !strings.Contains(mock.Messages[1], "was cut off before") {
+		t.Errorf("expected feedback about the truncated response:\n%s", mock.Messages[1])
+	}
+	if got, _ := os.ReadFile("out.txt"); string(got) != "hello\n" {
+		t.Fatalf("out.txt = %q", got)
+	}
+}
+
+func TestChaosGivesUpOnRepeatedInvalidOutput(t *testing.T) {
+	mock := lib.NewMockClient(chaosBash, chaosBash, chaosBash, chaosBash, chaosBash, chaosBash)
+	_, err := runChaosLoop(t, mock, lib.ChaosConfig{Rates: map[string]float64{lib.FaultTruncate: 1}})
+	if err == nil || !strings.Contains(err.Error(), "invalid responses in a row") {
+		t.Fatalf("expected the loop to stop with an error, got %v", err)
+	}
+}
@@ -145,7 +145,7 @@ func BuildNinaLoop(t *testing.T) {
 		// This file is at integration/helpers.go, so parent dir is project root
 		projectRoot := filepath.Dir(filepath.Dir(filename))
 
-		cmd := exec.Command("go", "build", "-o", out, "./cmd/nina")
+		cmd := exec.Command("go", "build", "-o", out, ".")
 		cmd.Dir = projectRoot
 		var stderr strings.Builder
 		cmd.Stderr = &stderr
@@ -217,6 +217,7 @@ func RunNinaLoop(t *testing.T, repo string, prompt string, model string) error {
 	// Always run in debug mode
 	cmd := exec.CommandContext(ctx, NinaBin, "run", "-m", model, "-d", "--uuid", testUUID)
 	cmd.Dir = repo
+	cmd.Env = CassetteEnv(t)
 	cmd.Stdin = strings.NewReader(prompt)
 
 	// Create output files
@@ -287,6 +288,59 @@ func RunNinaLoop(t *testing.T, repo string, prompt string, model string) error {
 	return err
 }
 
+// cassetteDir holds recorded provider traffic, one cassette per test. Record
+// with NINA_RECORD=1 and real api keys, after that tests replay them without
+// network, including in FAST mode.
+func cassetteDir() string {
+	_, filename, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(filename), "testdata", "cassettes")
+}
+
+// cassettePath returns the cassette of the test or subtest named name, like
+// cassettes/TestLoopSimpleEdit/edit_readme.json
+func cassettePath(name string) string {
+	return filepath.Join(cassetteDir(), name+".json")
+}
+
+// CassetteEnv returns the environment to run nina with for t, recording or
+// replaying its cassette when there is one.
+func CassetteEnv(t *testing.T) []string {
+	env := os.Environ()
+	path := cassettePath(t.Name())
+	if os.Getenv("NINA_RECORD") != "" {
+		fmt.Printf("Recording cassette: %s\n", path)
+		return append(env, "NINA_CASSETTE="+path, "NINA_CASSETTE_MODE=record")
+	}
+	if _, err := os.Stat(path); err != nil {
+		return env
+	}
+	fmt.Printf("Replaying cassette: %s\n", path)
+	env = append(env, "NINA_CASSETTE="+path, "NINA_CASSETTE_MODE=replay")
+	// clients only check that a key is set, replayed requests never leave the process
+	for _, key := range []string{"ANTHROPIC_API_KEY", "OPENAI_API_KEY", "XAI_API_KEY", "GROQ_API_KEY", "GOOGLE_API_KEY"} {
+		if os.Getenv(key) == "" {
+			env = append(env, key+"=replay")
+		}
+	}
+	return env
+}
+
+// SkipIfFast skips t in FAST mode unless it has cassettes to replay, its own
+// or those of its subtests.
+func SkipIfFast(t *testing.T) {
+	t.Helper()
+	if os.Getenv("FAST") == "" {
+		return
+	}
+	if _, err := os.Stat(cassettePath(t.Name())); err == nil {
+		return
+	}
+	if subtests, _ := filepath.Glob(cassettePath(filepath.Join(t.Name(), "*"))); len(subtests) > 0 {
+		return
+	}
+	t.Skip("skipping integration test in FAST mode")
+}
+
 // RunNinaLoopWithFiles executes nina with a plain prompt.
 func RunNinaLoopWithFiles(t *testing.T, repo string, prompt string, _ map[string]string, _ string) error {
 	t.Helper()
@@ -361,6 +415,7 @@ func RunNinaLoopWithContinue(t *testing.T, repo string, prompt string, model str
 	// Run with debug mode and --continue flag
 	cmd := exec.CommandContext(ctx, NinaBin, "run", "-m", model, "-d", "--continue", "--uuid", testUUID)
 	cmd.Dir = repo
+	cmd.Env = CassetteEnv(t)
 	cmd.Stdin = strings.NewReader(prompt)
 
 	// Create output files
@@ -22,9 +22,7 @@ func mapKeys(m map[string]string) []string {
 
 func TestLoopSimpleEdit(t *testing.T) {
 	t.Parallel()
-	if os.Getenv("FAST") != "" {
-		t.Skip("skipping integration test in FAST mode")
-	}
+	SkipIfFast(t)
 	tests := []struct {
 		name        string
 		files       map[string]string
@@ -104,9 +102,7 @@ func TestLoopSimpleEdit(t *testing.T) {
 
 func TestLoopContinuation(t *testing.T) {
 	t.Parallel()
-	if os.Getenv("FAST") != "" {
-		t.Skip("skipping integration test in FAST mode")
-	}
+	SkipIfFast(t)
 
 	// Test continuation for both o4-mini and sonnet models
 	models := []string{"o4-mini", "sonnet"}
@@ -179,9 +175,7 @@ func TestLoopContinuation(t *testing.T) {
 
 func TestLoopCreateFile(t *testing.T) {
 	t.Parallel()
-	if os.Getenv("FAST") != "" {
-		t.Skip("skipping integration test in FAST mode")
-	}
+	SkipIfFast(t)
 	tests := []struct {
 		name         string
 		files        map[string]string
@@ -279,9 +273,7 @@ func TestLoopCreateFile(t *testing.T) {
 
 func TestLoopAppendFile(t *testing.T) {
 	t.Parallel()
-	if os.Getenv("FAST") != "" {
-		t.Skip("skipping integration test in FAST mode")
-	}
+	SkipIfFast(t)
 	tests := []struct {
 		name         string
 		files        map[string]string
@@ -388,9 +380,7 @@ func TestLoopAppendFile(t *testing.T) {
 }
 
 func TestLoopMultiLineEdit(t *testing.T) {
-	if os.Getenv("FAST") != "" {
-		t.Skip("skipping integration test in FAST mode")
-	}
+	SkipIfFast(t)
 	tests := []struct {
 		name            string
 		files           map[string]string
@@ -578,9 +568,7 @@ func add(a, b int) int {
 
 func TestLoopDeleteFile(t *testing.T) {
 	t.Parallel()
-	if os.Getenv("FAST") != "" {
-		t.Skip("skipping integration test in FAST mode")
-	}
+	SkipIfFast(t)
 	tests := []struct {
 		name        string
 		files       map[string]string
@@ -680,9 +668,7 @@ func TestLoopDeleteFile(t *testing.T) {
 
 func TestLoopPrependFile(t *testing.T) {
 	t.Parallel()
-	if os.Getenv("FAST") != "" {
-		t.Skip("skipping integration test in FAST mode")
-	}
+	SkipIfFast(t)
 	tests := []struct {
 		name         string
 		files        map[string]string
@@ -790,9 +776,7 @@ func TestLoopPrependFile(t *testing.T) {
 
 func TestLoopInsertMiddle(t *testing.T) {
 	t.Parallel()
-	if os.Getenv("FAST") != "" {
-		t.Skip("skipping integration test in FAST mode")
-	}
+	SkipIfFast(t)
 	tests := []struct {
 		name         string
 		files        map[string]string
@@ -914,9 +898,7 @@ func TestLoopInsertMiddle(t *testing.T) {
 
 func TestLoopMultipleOccurrences(t *testing.T) {
 	t.Parallel()
-	if os.Getenv("FAST") != "" {
-		t.Skip("skipping integration test in FAST mode")
-	}
+	SkipIfFast(t)
 	tests := []struct {
 		name            string
 		files           map[string]string
@@ -1055,9 +1037,7 @@ func TestLoopMultipleOccurrences(t *testing.T) {
 
 func TestLoopErrorHandling(t *testing.T) {
 	t.Parallel()
-	if os.Getenv("FAST") != "" {
-		t.Skip("skipping integration test in FAST mode")
-	}
+	SkipIfFast(t)
 	tests := []struct {
 		name         string
 		files        map[string]string
@@ -1162,9 +1142,7 @@ func TestLoopErrorHandling(t *testing.T) {
 
 func TestLoopMultipleFiles(t *testing.T) {
 	t.Parallel()
-	if os.Getenv("FAST") != "" {
-		t.Skip("skipping integration test in FAST mode")
-	}
+	SkipIfFast(t)
 	tests := []struct {
 		name         string
 		files        map[string]string
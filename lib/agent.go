@@ -0,0 +1,121 @@
+// Sub-agent delegation. A NinaAgent tag runs a child loop with its own task,
+// model, and token budget, for example a cheap model investigating a failing
+// test while an expensive one makes the edits. The child shares the workspace
+// and session logs but not the conversation, and only its NinaStop report is
+// returned to the parent. Children cannot delegate further.
+package lib
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/nathants/nina/util"
+)
+
+// defaultAgentBudget is the token budget of a child loop without NinaMaxTokens
+const defaultAgentBudget = 100000
+
+// maxAgentDepth is how deeply NinaAgent calls may nest
+const maxAgentDepth = 1
+
+// agentPrompt wraps the delegated task for the child loop
+const agentPrompt = `You are a sub-agent working on one task delegated by another agent, which will only see your final report. When the task is done, or cannot be done, add a <NinaStop> containing your complete report: what you found or changed, the relevant file paths and line numbers, and anything the caller must do next.
+
+Task:
+`
+
+// AgentTask is a parsed NinaAgent tag
+type AgentTask struct {
+	Task   string
+	Model  string // empty uses NINA_AGENT_MODEL, then the parent's model
+	Budget int    // tokens, 0 uses defaultAgentBudget
+}
+
+// parseNinaAgent parses the fields of a NinaAgent tag
+func parseNinaAgent(chunk string) (AgentTask, error) {
+	task, err := util.ExtractSingle(chunk, util.NinaTaskStart, util.NinaTaskEnd)
+	if err != nil {
+		return AgentTask{}, err
+	}
+	agent := AgentTask{Task: strings.TrimSpace(task)}
+	if agent.Task == "" {
+		return AgentTask{}, fmt.Errorf("missing NinaTask in NinaAgent")
+	}
+	model, err := util.ExtractSingle(chunk, util.NinaModelStart, util.NinaModelEnd)
+	if err != nil {
+		return AgentTask{}, err
+	}
+	agent.Model = strings.TrimSpace(model)
+	budget, err := util.ExtractSingle(chunk, util.NinaMaxTokensStart, util.NinaMaxTokensEnd)
+	if err != nil {
+		return AgentTask{}, err
+	}
+	if budget = strings.TrimSpace(budget); budget != "" {
+		agent.Budget, err = strconv.Atoi(budget)
+		if err != nil || agent.Budget <= 0 {
+			return AgentTask{}, fmt.Errorf("invalid NinaMaxTokens: %s", budget)
+		}
+	}
+	return agent, nil
+}
+
+// runAgent runs a child loop for a NinaAgent tag and returns its report
+func runAgent(chunk string, state *LoopState) ProcessorEvent {
+	event := ProcessorEvent{Type: "NinaAgent"}
+	agent, err := parseNinaAgent(chunk)
+	if err != nil {
+		event.Reason = err.Error()
+		return event
+	}
+	if state == nil || state.config.ToolProcessor == nil {
+		event.Reason = "NinaAgent is not available here"
+		return event
+	}
+	parent := state.config
+	if parent.agentDepth >= maxAgentDepth {
+		event.Reason = "sub-agents cannot start their own NinaAgent, do the task yourself"
+		return event
+	}
+	if agent.Model == "" {
+		agent.Model = os.Getenv("NINA_AGENT_MODEL")
+	}
+	if agent.Model == "" {
+		agent.Model = parent.Model
+	}
+	if agent.Budget == 0 {
+		agent.Budget = defaultAgentBudget
+	}
+	event.Cmd = agent.Model
+
+	child := LoopConfig{
+		Model:         agent.Model,
+		MaxTokens:     agent.Budget,
+		TokenBudget:   agent.Budget,
+		Debug:         parent.Debug,
+		ToolProcessor: parent.ToolProcessor,
+		StdinContent:  agentPrompt + agent.Task,
+		Thinking:      parent.Thinking,
+		agentDepth:    parent.agentDepth + 1,
+	}
+	// scripted and replayed providers answer the child's calls in order
+	if parent.Provider != nil || parent.Replay != "" {
+		child.Provider = state.AIProvider
+	}
+
+	LogStderr("Starting sub-agent with %s, budget %s: %s", agent.Model, FormatTokens(agent.Budget), firstLine(agent.Task))
+	report, err := runLoop(child)
+	if err != nil {
+		event.Reason = fmt.Sprintf("sub-agent failed: %v", err)
+		return event
+	}
+	LogStderr("Sub-agent finished")
+	event.Stdout = report
+	return event
+}
+
+func firstLine(text string) string {
+	line, _, _ := strings.Cut(text, "\n")
+	return line
+}
@@ -0,0 +1,112 @@
+// Response cache for ask and arch. Responses are stored under
+// ~/.cache/nina/responses keyed by a sha256 of the provider, model settings,
+// system prompt, and prompt, so identical requests in scripts and tests
+// return instantly without an api call. Entries expire after NINA_CACHE_TTL
+// (default 24h, e.g. 30m or 7d), NINA_CACHE=0 disables the cache.
+package lib
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/nathants/nina/models"
+	"github.com/nathants/nina/util"
+)
+
+const defaultCacheTTL = 24 * time.Hour
+
+// CacheDir returns the nina cache directory, overridable with NINA_CACHE_DIR
+func CacheDir() string {
+	if dir := os.Getenv("NINA_CACHE_DIR"); dir != "" {
+		return dir
+	}
+	cache := os.Getenv("XDG_CACHE_HOME")
+	if cache == "" {
+		cache = filepath.Join(os.Getenv("HOME"), ".cache")
+	}
+	return filepath.Join(cache, "nina")
+}
+
+// CacheEnabled reports whether the response cache is on, NINA_CACHE=0 turns it off
+func CacheEnabled() bool {
+	enabled, err := strconv.ParseBool(os.Getenv("NINA_CACHE"))
+	return err != nil || enabled
+}
+
+// CacheTTL returns how long cached responses are served
+func CacheTTL() time.Duration {
+	if value := os.Getenv("NINA_CACHE_TTL"); value != "" {
+		if ttl, err := ParseAge(value); err == nil {
+			return ttl
+		}
+		util.Errorf("warning: invalid NINA_CACHE_TTL %q, using %s", value, defaultCacheTTL)
+	}
+	return defaultCacheTTL
+}
+
+// ResponseCacheKey hashes everything that affects a model's response
+func ResponseCacheKey(m models.Model, system, prompt string) string {
+	hash := sha256.New()
+	for _, part := range []string{m.Provider, m.APIModel, modelSettings(m), system, prompt} {
+		hash.Write([]byte(part))
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func modelSettings(m models.Model) string {
+	settings := fmt.Sprintf("effort=%s thinking=%d tier=%s batch=%t max=%d", m.Effort, m.ThinkingBudget, m.ServiceTier, m.Batch, m.MaxOutput)
+	if m.Temperature != nil {
+		settings += fmt.Sprintf(" temperature=%g", *m.Temperature)
+	}
+	if m.TopP != nil {
+		settings += fmt.Sprintf(" top_p=%g", *m.TopP)
+	}
+	return settings
+}
+
+func responseCachePath(key string) string {
+	return filepath.Join(CacheDir(), "responses", key[:2], key)
+}
+
+// CachedResponse returns a cached response younger than the ttl
+func CachedResponse(key string) (string, bool) {
+	path := responseCachePath(key)
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", false
+	}
+	if time.Since(info.ModTime()) > CacheTTL() {
+		_ = os.Remove(path)
+		return "", false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", false
+	}
+	util.Verbosef("using cached response %s", key[:12])
+	return string(data), true
+}
+
+// StoreResponse caches a response, failures only warn
+func StoreResponse(key, response string) {
+	path := responseCachePath(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		util.Errorf("warning: failed to cache response: %v", err)
+		return
+	}
+	tmp := fmt.Sprintf("%s.%d.tmp", path, os.Getpid())
+	if err := os.WriteFile(tmp, []byte(response), 0600); err != nil {
+		util.Errorf("warning: failed to cache response: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		util.Errorf("warning: failed to cache response: %v", err)
+	}
+}
@@ -0,0 +1,45 @@
+package lib
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/nathants/nina/models"
+)
+
+func TestResponseCache(t *testing.T) {
+	t.Setenv("NINA_CACHE_DIR", t.TempDir())
+	t.Setenv("NINA_CACHE_TTL", "1h")
+	sonnet := models.MustLookup("sonnet")
+	key := ResponseCacheKey(sonnet, "system", "prompt")
+	if _, ok := CachedResponse(key); ok {
+		t.Fatal("unexpected hit")
+	}
+	StoreResponse(key, "answer")
+	if got, ok := CachedResponse(key); !ok || got != "answer" {
+		t.Fatalf("got %q %v", got, ok)
+	}
+
+	cooler := 0.1
+	other := sonnet
+	other.Temperature = &cooler
+	for _, k := range []string{
+		ResponseCacheKey(models.MustLookup("opus"), "system", "prompt"),
+		ResponseCacheKey(sonnet, "system2", "prompt"),
+		ResponseCacheKey(sonnet, "system", "prompt2"),
+		ResponseCacheKey(other, "system", "prompt"),
+	} {
+		if k == key {
+			t.Error("expected distinct keys")
+		}
+	}
+
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(responseCachePath(key), old, old); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := CachedResponse(key); ok {
+		t.Error("expected expired entry to miss")
+	}
+}
@@ -0,0 +1,190 @@
+// Fault injection for testing how the loop survives a misbehaving provider.
+// ChaosProvider wraps any AIProvider and, at configured rates, fails calls
+// with timeouts, 429s, or malformed stream events, or truncates the response
+// so its NinaOutput is cut off. It is enabled for run with NINA_CHAOS, e.g.
+//
+//	NINA_CHAOS=timeout=0.1,429=0.1,malformed=0.05,truncate=0.2,seed=7,limit=5
+//
+// Rates are probabilities per call, seed makes the faults repeatable, and
+// limit caps how many faults are injected in total.
+package lib
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/nathants/nina/providers"
+	"github.com/nathants/nina/providers/claude"
+	"github.com/nathants/nina/providers/grok"
+	"github.com/nathants/nina/providers/openai"
+)
+
+const (
+	FaultTimeout   = "timeout"
+	FaultRateLimit = "429"
+	FaultMalformed = "malformed"
+	FaultTruncate  = "truncate"
+)
+
+var faults = []string{FaultTimeout, FaultRateLimit, FaultMalformed, FaultTruncate}
+
+// ChaosConfig sets the rate of each fault
+type ChaosConfig struct {
+	Rates map[string]float64
+	Seed  int64
+	Limit int // total faults to inject, 0 for unlimited
+}
+
+// ParseChaos parses a NINA_CHAOS value
+func ParseChaos(value string) (ChaosConfig, error) {
+	config := ChaosConfig{Rates: map[string]float64{}, Seed: 1}
+	for part := range strings.SplitSeq(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		key, val, ok := strings.Cut(part, "=")
+		if !ok {
+			return config, fmt.Errorf("invalid chaos setting %q, expected key=value", part)
+		}
+		switch key {
+		case "seed":
+			seed, err := strconv.ParseInt(val, 10, 64)
+			if err != nil {
+				return config, fmt.Errorf("invalid chaos seed %q", val)
+			}
+			config.Seed = seed
+		case "limit":
+			limit, err := strconv.Atoi(val)
+			if err != nil || limit < 0 {
+				return config, fmt.Errorf("invalid chaos limit %q", val)
+			}
+			config.Limit = limit
+		case FaultTimeout, FaultRateLimit, FaultMalformed, FaultTruncate:
+			rate, err := strconv.ParseFloat(val, 64)
+			if err != nil || rate < 0 || rate > 1 {
+				return config, fmt.Errorf("invalid chaos rate %s=%s, expected 0 to 1", key, val)
+			}
+			config.Rates[key] = rate
+		default:
+			return config, fmt.Errorf("unknown chaos setting %q (supported: %s, seed, limit)", key, strings.Join(faults, ", "))
+		}
+	}
+	return config, nil
+}
+
+// ChaosProvider injects faults into calls to a wrapped provider
+type ChaosProvider struct {
+	AIProvider
+	config   ChaosConfig
+	rand     *rand.Rand
+	Injected []string // faults injected so far, in order
+}
+
+// NewChaosProvider wraps provider with fault injection
+func NewChaosProvider(provider AIProvider, config ChaosConfig) *ChaosProvider {
+	return &ChaosProvider{
+		AIProvider: provider,
+		config:     config,
+		rand:       rand.New(rand.NewSource(config.Seed)),
+	}
+}
+
+// chaosFromEnv wraps provider when NINA_CHAOS is set
+func chaosFromEnv(provider AIProvider) (AIProvider, error) {
+	value := os.Getenv("NINA_CHAOS")
+	if value == "" {
+		return provider, nil
+	}
+	config, err := ParseChaos(value)
+	if err != nil {
+		return nil, fmt.Errorf("NINA_CHAOS: %w", err)
+	}
+	LogError("Warning: injecting provider faults from NINA_CHAOS=%s", value)
+	return NewChaosProvider(provider, config), nil
+}
+
+// pick returns the fault to inject on this call, if any
+func (c *ChaosProvider) pick() string {
+	if c.config.Limit > 0 && len(c.Injected) >= c.config.Limit {
+		return ""
+	}
+	for _, fault := range faults {
+		if rate := c.config.Rates[fault]; rate > 0 && c.rand.Float64() < rate {
+			c.Injected = append(c.Injected, fault)
+			return fault
+		}
+	}
+	return ""
+}
+
+// Call calls the wrapped provider, injecting a fault at the configured rates
+func (c *ChaosProvider) Call(ctx context.Context, model, systemPrompt, userMessage string) (any, error) {
+	return c.inject(func() (any, error) {
+		return c.AIProvider.Call(ctx, model, systemPrompt, userMessage)
+	})
+}
+
+// CallWithStore calls the wrapped provider, injecting a fault at the configured rates
+func (c *ChaosProvider) CallWithStore(ctx context.Context, model, systemPrompt, userMessage string) (any, error) {
+	return c.inject(func() (any, error) {
+		return c.AIProvider.CallWithStore(ctx, model, systemPrompt, userMessage)
+	})
+}
+
+// CallWithTools calls the wrapped provider, injecting a fault at the configured rates
+func (c *ChaosProvider) CallWithTools(ctx context.Context, model, systemPrompt, userMessage string, tools []any) (any, error) {
+	return c.inject(func() (any, error) {
+		return c.AIProvider.CallWithTools(ctx, model, systemPrompt, userMessage, tools)
+	})
+}
+
+// RecordTurn records a response from another model in the wrapped provider
+func (c *ChaosProvider) RecordTurn(userMessage, response string) {
+	recordTurn(c.AIProvider, userMessage, response)
+}
+
+func (c *ChaosProvider) inject(call func() (any, error)) (any, error) {
+	fault := c.pick()
+	switch fault {
+	case FaultTimeout:
+		return nil, fmt.Errorf("do request error: %w (injected)", context.DeadlineExceeded)
+	case FaultRateLimit:
+		return nil, &providers.StatusError{StatusCode: 429, Body: "rate_limit_error (injected)"}
+	case FaultMalformed:
+		return nil, &providers.StreamError{Err: fmt.Errorf("api stream error: malformed event {\"type\": (injected)")}
+	}
+	resp, err := call()
+	if err != nil || fault != FaultTruncate {
+		return resp, err
+	}
+	truncateResponse(resp)
+	return resp, nil
+}
+
+// truncateResponse cuts the response text in half, as if the stream ended early
+func truncateResponse(resp any) {
+	half := func(text string) string { return text[:len(text)/2] }
+	switch r := resp.(type) {
+	case *claude.Response:
+		if len(r.Content) > 0 {
+			r.Content[0].Text = half(r.Content[0].Text)
+		}
+	case *openai.Response:
+		if len(r.Output) > 0 && len(r.Output[0].Content) > 0 {
+			r.Output[0].Content[0].Text = half(r.Output[0].Content[0].Text)
+		}
+	case *grok.Response:
+		if len(r.Choices) > 0 {
+			r.Choices[0].Message.Content = half(r.Choices[0].Message.Content)
+		}
+	case *GeminiResponse:
+		r.Text = half(r.Text)
+	case *ReplayResponse:
+		r.Text = half(r.Text)
+	}
+}
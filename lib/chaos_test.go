@@ -0,0 +1,21 @@
+package lib
+
+import "testing"
+
+func TestParseChaos(t *testing.T) {
+	config, err := ParseChaos("timeout=0.1, 429=0.5,truncate=1,seed=7,limit=3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Rates[FaultTimeout] != 0.1 || config.Rates[FaultRateLimit] != 0.5 || config.Rates[FaultTruncate] != 1 {
+		t.Errorf("rates %v", config.Rates)
+	}
+	if config.Seed != 7 || config.Limit != 3 {
+		t.Errorf("seed %d limit %d", config.Seed, config.Limit)
+	}
+	for _, bad := range []string{"timeout", "timeout=2", "slow=0.1", "limit=-1"} {
+		if _, err := ParseChaos(bad); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
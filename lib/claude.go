@@ -6,11 +6,11 @@ package lib
 import (
 	"context"
 	"fmt"
+	"github.com/nathants/nina/models"
 	claude "github.com/nathants/nina/providers/claude"
 	oauth "github.com/nathants/nina/providers/oauth"
 	util "github.com/nathants/nina/util"
 	"os"
-	"os/exec"
 	"strings"
 )
 
@@ -18,6 +18,9 @@ import (
 // and maintains the full message history for each conversation
 type ClaudeClient struct {
 	messages []*claude.Message
+	// pending holds tool calls from the last turn, the next user message
+	// answers them with their results
+	pending []claude.ToolUse
 }
 
 // NewClaudeClient creates a new Claude client with an empty message history
@@ -96,10 +99,54 @@ func (c *ClaudeClient) RestoreMessages(messages []any) error {
 	return nil
 }
 
+// messageText returns the text blocks of a message
+func messageText(msg *claude.Message) string {
+	var text string
+	for _, block := range msg.Content {
+		if block.Type == "text" {
+			text += block.Text
+		}
+	}
+	return text
+}
+
+// userTurn builds the next user message, answering pending tool calls first
+// with their results
+func (c *ClaudeClient) userTurn(userMessage string, results []ToolCall) *claude.Message {
+	msg := &claude.Message{Role: "user"}
+	for i, use := range c.pending {
+		msg.Content = append(msg.Content, claude.Text{Type: "tool_result", ToolUseID: use.ID, Content: toolResult(results, i, use.ID)})
+	}
+	if userMessage != "" {
+		msg.Content = append(msg.Content, claude.Text{Type: "text", Text: userMessage})
+	}
+	return msg
+}
+
+// RecordTurn records a response from another model, see turnRecorder
+func (c *ClaudeClient) RecordTurn(userMessage, response string) {
+	i := turnStart(len(c.messages), func(i int) (string, string) {
+		return c.messages[i].Role, messageText(c.messages[i])
+	}, userMessage)
+	user := c.userTurn(userMessage, nil)
+	if i < len(c.messages) {
+		user = c.messages[i]
+	}
+	c.messages = append(c.messages[:i],
+		user,
+		&claude.Message{Role: "assistant", Content: []claude.Text{{Type: "text", Text: response}}},
+	)
+	c.pending = nil
+}
+
 // builds request incl system prompt, history; caches system prompt and newest messages
 // sends synchronous request to Claude, returns response struct, tracks token usage
 // appends assistant reply to history and logs request/response to agents directory
 func (c *ClaudeClient) CallWithStore(ctx context.Context, model, systemPrompt, userMessage string) (any, error) {
+	return c.call(ctx, model, systemPrompt, userMessage, nil)
+}
+
+func (c *ClaudeClient) call(ctx context.Context, model, systemPrompt, userMessage string, tools []claude.Tool) (any, error) {
 	// Map nina model names to Claude models
 	var claudeModel string
 	var thinking *claude.Thinking
@@ -110,33 +157,26 @@ func (c *ClaudeClient) CallWithStore(ctx context.Context, model, systemPrompt, u
 		thinkingEnabled = val.(bool)
 	}
 
-	switch model {
-	case "sonnet", "4-sonnet":
-		claudeModel = "claude-sonnet-4-20250514"
-		if thinkingEnabled {
-			thinking = &claude.Thinking{
-				Type:         "enabled",
-				BudgetTokens: 24000,
-			}
-		}
-	case "opus", "4-opus":
-		claudeModel = "claude-opus-4-20250514"
-		if thinkingEnabled {
-			thinking = &claude.Thinking{
-				Type:         "enabled",
-				BudgetTokens: 24000,
-			}
+	m, err := registryModel(model, models.ProviderClaude)
+	if err != nil {
+		return nil, err
+	}
+	claudeModel = m.APIModel
+	if thinkingEnabled && m.ThinkingBudget > 0 {
+		thinking = &claude.Thinking{
+			Type:         "enabled",
+			BudgetTokens: m.ThinkingBudget,
 		}
-	default:
-		return nil, fmt.Errorf("unknown Claude model: %s", model)
 	}
 
 	// Build request
 	req := claude.Request{
 		Model:     claudeModel,
-		MaxTokens: 32000,
+		MaxTokens: m.MaxOutput,
 		Thinking:  thinking,
 		Stream:    true,
+		Tools:     tools,
+		Betas:     m.Betas,
 	}
 
 	// Add system prompt with cache control for efficiency
@@ -153,12 +193,9 @@ func (c *ClaudeClient) CallWithStore(ctx context.Context, model, systemPrompt, u
 	}
 
 	// Add the new user message to history
-	if userMessage != "" {
-		userMsg := claude.Message{
-			Role:    "user",
-			Content: []claude.Text{{Type: "text", Text: userMessage}},
-		}
-		c.messages = append(c.messages, &userMsg)
+	userTurn := userMessage != "" || len(c.pending) > 0
+	if userTurn {
+		c.messages = append(c.messages, c.userTurn(userMessage, ToolResults(ctx)))
 	}
 
 	maxCachedMessages := 3
@@ -169,6 +206,7 @@ func (c *ClaudeClient) CallWithStore(ctx context.Context, model, systemPrompt, u
 	for i, msg := range c.messages {
 		msg.Content[0].Cache = nil
 		msgCopy := *msg
+		msgCopy.Content = append([]claude.Text(nil), msg.Content...)
 		if i >= cacheStartIndex {
 			for j := range msgCopy.Content {
 				if msgCopy.Content[j].Text != "" {
@@ -181,8 +219,14 @@ func (c *ClaudeClient) CallWithStore(ctx context.Context, model, systemPrompt, u
 
 	handleResp, err := claude.Handle(ctx, req, func(data string) {
 		// fmt.Printf("%s%s%s\n", ColorGreen, data, ColorReset)
+		currentTUI().Reasoning(data)
+		currentEvents().Delta("reasoning", data)
 	})
 	if err != nil {
+		// drop the unanswered message so a retry does not send it twice
+		if userTurn {
+			c.messages = c.messages[:len(c.messages)-1]
+		}
 		return nil, err
 	}
 
@@ -194,23 +238,35 @@ func (c *ClaudeClient) CallWithStore(ctx context.Context, model, systemPrompt, u
 				Text: handleResp.Text,
 			},
 		},
-		Usage: handleResp.Usage,
+		Usage:      handleResp.Usage,
+		StopReason: handleResp.StopReason,
+		Model:      handleResp.Model,
 	}
 
 	// Message ID is stored separately in handleResp, not in Usage
 
+	// Add assistant response to history, tool calls included, after the
+	// thinking that has to be sent back with them
+	assistantMsg := claude.Message{Role: "assistant"}
+	if len(handleResp.ToolUses) > 0 {
+		assistantMsg.Content = append(assistantMsg.Content, handleResp.Thinking...)
+	}
 	if handleResp.Text != "" {
-		assistantMsg := claude.Message{
-			Role:    "assistant",
-			Content: []claude.Text{{Type: "text", Text: handleResp.Text}},
-		}
+		assistantMsg.Content = append(assistantMsg.Content, claude.Text{Type: "text", Text: handleResp.Text})
+	}
+	for _, use := range handleResp.ToolUses {
+		assistantMsg.Content = append(assistantMsg.Content, claude.Text{Type: "tool_use", ID: use.ID, Name: use.Name, Input: use.Input})
+		resp.Content = append(resp.Content, claude.ContentBlock{Type: "tool_use", ID: use.ID, Name: use.Name, Input: use.Input})
+	}
+	if len(assistantMsg.Content) > 0 {
 		c.messages = append(c.messages, &assistantMsg)
 	}
+	c.pending = handleResp.ToolUses
 
 	// Log API call
 	err = c.logAPICall(&req, resp)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to log API call: %v\n", err)
+		util.Errorf("Failed to log API call: %v", err)
 	}
 
 	return resp, nil
@@ -230,13 +286,13 @@ func (c *ClaudeClient) logAPICall(req *claude.Request, resp *claude.Response) er
 		for i, msg := range reqCopy.Messages {
 			// Check if Content is a string (for user messages)
 			// Collect all input texts
-			inputTexts = append(inputTexts, fmt.Sprintf("=== Message %d (Role: %s) ===\n%s", i+1, msg.Role, msg.Content[0].Text))
+			inputTexts = append(inputTexts, fmt.Sprintf("=== Message %d (Role: %s) ===\n%s", i+1, msg.Role, messageText(&msg)))
 		}
 		// Save all input texts to a single file
 		if len(inputTexts) > 0 {
 			textPath := GetTimestampedAgentsPath("text", fmt.Sprintf("%05d.input.txt", logNum))
 			combinedText := strings.Join(inputTexts, "\n\n")
-			if err := os.WriteFile(textPath, []byte(combinedText), 0644); err != nil {
+			if err := util.WriteLog(textPath, []byte(combinedText)); err != nil {
 				return fmt.Errorf("failed to write input text: %w", err)
 			}
 		}
@@ -247,7 +303,7 @@ func (c *ClaudeClient) logAPICall(req *claude.Request, resp *claude.Response) er
 		for _, text := range reqCopy.System {
 			if text.Text != "" {
 				textPath := GetTimestampedAgentsPath("text", fmt.Sprintf("%05d.system.txt", logNum))
-				if err := os.WriteFile(textPath, []byte(text.Text), 0644); err != nil {
+				if err := util.WriteLog(textPath, []byte(text.Text)); err != nil {
 					return fmt.Errorf("failed to write system text: %w", err)
 				}
 			}
@@ -264,7 +320,7 @@ func (c *ClaudeClient) logAPICall(req *claude.Request, resp *claude.Response) er
 		}
 		if outputText != "" {
 			textPath := GetTimestampedAgentsPath("text", fmt.Sprintf("%05d.output.txt", logNum))
-			if err := os.WriteFile(textPath, []byte(outputText), 0644); err != nil {
+			if err := util.WriteLog(textPath, []byte(outputText)); err != nil {
 				return fmt.Errorf("failed to write output text: %w", err)
 			}
 		}
@@ -273,7 +329,7 @@ func (c *ClaudeClient) logAPICall(req *claude.Request, resp *claude.Response) er
 	// fmt.Println(GetTimestampedAgentsPath("api", fmt.Sprintf("%05d.*.json", logNum)))
 
 	jsonPath := GetTimestampedAgentsPath("api", fmt.Sprintf("%05d.input.json", logNum))
-	err := os.WriteFile(jsonPath, []byte(util.Pformat(reqCopy)), 0644)
+	err := util.WriteLog(jsonPath, []byte(util.Pformat(reqCopy)))
 	if err != nil {
 		return fmt.Errorf("failed to write API log: %w", err)
 	}
@@ -286,7 +342,7 @@ func (c *ClaudeClient) logAPICall(req *claude.Request, resp *claude.Response) er
 		"messages": c.messages,
 	}
 
-	err = os.WriteFile(jsonPath, []byte(util.Pformat(outputData)), 0644)
+	err = util.WriteLog(jsonPath, []byte(util.Pformat(outputData)))
 	if err != nil {
 		return fmt.Errorf("failed to write API log: %w", err)
 	}
@@ -374,26 +430,7 @@ func (c *ClaudeClient) CompactMessages(messagePairs int) CompactionResult {
 			contentBuilder.WriteString(text.Text)
 			contentBuilder.WriteString(" ")
 		}
-		contentStr := contentBuilder.String()
-
-		// Use tokens utility to count tokens
-		cmd := exec.Command("tokens")
-		cmd.Stdin = strings.NewReader(contentStr)
-		output, err := cmd.Output()
-		if err != nil {
-			// Log error but continue with zero tokens for this message
-			fmt.Fprintf(os.Stderr, "Warning: Failed to count tokens for message %d: %v\n", i, err)
-		} else {
-			tokens := 0
-			_, _ = fmt.Sscanf(string(output), "%d", &tokens)
-			tokensRemoved += tokens
-		}
-
-		// Fallback estimation if tokens command failed or returned 0
-		if tokensRemoved == 0 && len(contentStr) > 0 {
-			// Rough estimation: ~4 characters per token
-			tokensRemoved += len(contentStr) / 4
-		}
+		tokensRemoved += countProviderTokens(models.ProviderClaude, contentBuilder.String())
 	}
 
 	// Remove old messages
@@ -415,9 +452,13 @@ func (c *ClaudeClient) SupportsTools() bool {
 	return true
 }
 
-// CallWithTools calls Claude API with tool definitions.
+// CallWithTools calls Claude API with tools, tools holds
+// prompts.ToolDefinition values and defaults to the nina tools when empty.
+// Tool calls are returned as tool_use blocks of the claude.Response.
 func (c *ClaudeClient) CallWithTools(ctx context.Context, model, systemPrompt, userMessage string, tools []any) (any, error) {
-	// For now, just call the regular method
-	// In a full implementation, this would add tools to the request
-	return c.CallWithStore(ctx, model, systemPrompt, userMessage)
+	var claudeTools []claude.Tool
+	for _, def := range toolDefinitions(tools) {
+		claudeTools = append(claudeTools, claude.Tool{Name: def.Name, Description: def.Description, InputSchema: toolSchema(def)})
+	}
+	return c.call(ctx, model, systemPrompt, userMessage, claudeTools)
 }
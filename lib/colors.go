@@ -2,8 +2,9 @@ package lib
 
 import (
 	"fmt"
-	"os"
 	"regexp"
+
+	"github.com/nathants/nina/util"
 )
 
 const (
@@ -19,13 +20,13 @@ const (
 // ColoredStderr writes colored output to stderr
 func ColoredStderr(format string, args ...any) {
 	msg := fmt.Sprintf(format, args...)
-	fmt.Fprintf(os.Stderr, "%s%s%s\n", ColorCyan, msg, ColorReset)
+	util.Printf(util.LogNormal, "%s%s%s\n", ColorCyan, msg, ColorReset)
 }
 
 // PrintYellowSeparator prints yellow equal signs separator (2 rows, 80 chars)
 func PrintYellowSeparator() {
 	separator := "================================================================================"
-	fmt.Fprintf(os.Stderr, "%s%s%s\n", ColorYellow, separator, ColorReset)
+	util.Printf(util.LogNormal, "%s%s%s\n", ColorYellow, separator, ColorReset)
 }
 
 // HighlightAllXMLTags highlights all XML tags in green
@@ -0,0 +1,156 @@
+// Pause, status, and stop controls for a running loop. In a terminal p, s,
+// and q are keys acting as soon as they are pressed on an empty steering
+// line, see keys.go, and steering input from a pipe, see steer.go, takes a
+// line of just p, s, or q: p pauses the loop after the current step and
+// resumes it when pressed again, s prints a status summary, and q stops the
+// loop after the current step, as if the model had sent NinaStop. Messages
+// typed while paused are sent when the loop resumes.
+package lib
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StopByUser is the stop reason of a loop stopped with q
+const StopByUser = "stopped by the user"
+
+// loopControl is the control state shared by the input goroutine and the loop
+type loopControl struct {
+	mu      sync.Mutex
+	paused  bool
+	waiting bool // paused between steps
+	quit    bool
+	wake    chan struct{}
+	step    int
+	start   time.Time
+	status  string
+	changed map[string]bool
+}
+
+var control = &loopControl{wake: make(chan struct{}, 1)}
+
+// handleControl runs the control named by line, it reports false for any
+// other line
+func handleControl(line string) bool {
+	switch strings.TrimSpace(line) {
+	case "p":
+		control.mu.Lock()
+		control.paused = !control.paused
+		paused := control.paused
+		control.mu.Unlock()
+		if paused {
+			LogStderr("Pausing after this step, p to resume")
+		} else {
+			LogStderr("Resuming")
+		}
+	case "s":
+		LogStderr("%s", control.summary())
+	case "q":
+		control.mu.Lock()
+		control.quit = true
+		control.mu.Unlock()
+		LogStderr("Stopping after this step")
+	default:
+		return false
+	}
+	select {
+	case control.wake <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// checkControl waits while the loop is paused and returns StopByUser when it
+// was asked to stop, "" to go on with the next step
+func checkControl(state *LoopState) string {
+	announced := false
+	defer func() {
+		control.mu.Lock()
+		control.waiting = false
+		control.mu.Unlock()
+	}()
+	for {
+		control.mu.Lock()
+		quit, paused := control.quit, control.paused
+		control.waiting = paused && !quit
+		control.mu.Unlock()
+		if quit {
+			return StopByUser
+		}
+		if !paused {
+			return ""
+		}
+		if !announced {
+			LogStderr("Paused after step %d, p to resume, s for status, q to stop, or type a message", state.StepNumber)
+			announced = true
+		}
+		<-control.wake
+	}
+}
+
+// startStep records the step now running for the status summary
+func (c *loopControl) startStep(step int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.step = step
+	c.start = time.Now()
+}
+
+// finishStep records the usage and changed files of a finished step for the
+// status summary
+func (c *loopControl) finishStep(state *LoopState, events []ProcessorEvent) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.changed == nil {
+		c.changed = map[string]bool{}
+	}
+	for _, event := range events {
+		switch event.Type {
+		case "NinaChange", "NinaDelete", "NinaRename", "NinaPlan":
+			if event.Reason == "" && event.Filepath != "" {
+				c.changed[seenPath(event.Filepath)] = true
+			}
+		}
+	}
+	for path := range state.ExternalChanges {
+		c.changed[path] = true
+	}
+	c.status = fmt.Sprintf("%s input and %s output tokens, $%.2f, %s elapsed",
+		FormatTokens(state.SessionUsage.SessionInput), FormatTokens(state.TokensUsed), SessionCost(), state.TotalDuration.Round(time.Second))
+}
+
+// summary returns the status summary printed for s
+func (c *loopControl) summary() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.step == 0 {
+		return "Status: starting"
+	}
+	state := fmt.Sprintf("running step %d for %s", c.step, time.Since(c.start).Round(time.Second))
+	switch {
+	case c.waiting:
+		state = fmt.Sprintf("paused after step %d", c.step)
+	case c.quit:
+		state += ", stopping after it"
+	case c.paused:
+		state += ", pausing after it"
+	}
+	lines := []string{"Status: " + state}
+	if c.status != "" {
+		lines = append(lines, "Used "+c.status)
+	}
+	files := make([]string, 0, len(c.changed))
+	for path := range c.changed {
+		files = append(files, path)
+	}
+	sort.Strings(files)
+	lines = append(lines, fmt.Sprintf("%d files changed", len(files)))
+	for _, path := range files {
+		lines = append(lines, "  "+path)
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,63 @@
+package lib
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// resetControl clears the shared control state for a test
+func resetControl(t *testing.T) {
+	reset := func() { control = &loopControl{wake: make(chan struct{}, 1)} }
+	reset()
+	t.Cleanup(reset)
+}
+
+func TestControl(t *testing.T) {
+	resetControl(t)
+	state := &LoopState{StepNumber: 1}
+	if handleControl("fix the tests") {
+		t.Error("a message was taken as a control")
+	}
+	if reason := checkControl(state); reason != "" {
+		t.Errorf("unexpected stop %q", reason)
+	}
+
+	// p pauses between steps until pressed again
+	control.startStep(1)
+	if !handleControl(" p ") {
+		t.Fatal("p was not taken as a control")
+	}
+	done := make(chan string)
+	go func() { done <- checkControl(state) }()
+	select {
+	case reason := <-done:
+		t.Fatalf("paused loop went on with %q", reason)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if summary := control.summary(); !strings.Contains(summary, "paused after step 1") {
+		t.Errorf("unexpected summary %q", summary)
+	}
+	handleControl("p")
+	select {
+	case reason := <-done:
+		if reason != "" {
+			t.Errorf("unexpected stop %q", reason)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("loop was not resumed")
+	}
+
+	// s reports the changed files, q stops the loop
+	control.finishStep(&LoopState{ExternalChanges: map[string]int{"/repo/b.txt": 2}}, []ProcessorEvent{{Type: "NinaChange", Filepath: "/repo/a.txt"}})
+	summary := control.summary()
+	for _, want := range []string{"running step 1", "2 files changed", "/repo/a.txt", "/repo/b.txt"} {
+		if !strings.Contains(summary, want) {
+			t.Errorf("missing %q in %q", want, summary)
+		}
+	}
+	handleControl("q")
+	if reason := checkControl(state); reason != StopByUser {
+		t.Errorf("got %q, want %q", reason, StopByUser)
+	}
+}
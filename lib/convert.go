@@ -0,0 +1,136 @@
+// Converter cache and batching for ConvertToRangeUpdates. Successful conversions
+// are cached on disk keyed by (file hash, search text) so retries and repeated
+// edits skip the model, and multiple searches in one file share a single prompt.
+package lib
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/nathants/nina/prompts"
+	"github.com/nathants/nina/util"
+)
+
+// ConverterLocal is the converter model value that disables AI conversion
+const ConverterLocal = "local"
+
+// ConverterModel returns the model used by ConvertToRangeUpdates, configured
+// with NINA_CONVERTER_MODEL. The value "local" uses only the deterministic
+// matcher so no API calls are made while applying edits.
+func ConverterModel() string {
+	if model := os.Getenv("NINA_CONVERTER_MODEL"); model != "" {
+		return model
+	}
+	return "sonnet"
+}
+
+// converterResponse calls the converter model, a variable so tests can stub it
+var converterResponse = generateResponse
+
+// convertCacheKey hashes the numbered file content and search text
+func convertCacheKey(content, searchText string) string {
+	h := sha256.New()
+	h.Write([]byte(content))
+	h.Write([]byte{0})
+	h.Write([]byte(searchText))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func convertCachePath(key string) string {
+	return filepath.Join(util.GetAgentsSubdir("convert-cache"), key+".json")
+}
+
+// loadConvertCache returns a cached range for the search text, if any
+func loadConvertCache(content, searchText string) (util.RangeResult, bool) {
+	var result util.RangeResult
+	data, err := os.ReadFile(convertCachePath(convertCacheKey(content, searchText)))
+	if err != nil {
+		return result, false
+	}
+	if err := json.Unmarshal(data, &result); err != nil || result.Start <= 0 {
+		return result, false
+	}
+	return result, true
+}
+
+// saveConvertCache stores a validated range, failures only lose the cache entry
+func saveConvertCache(content, searchText string, result util.RangeResult) {
+	path := convertCachePath(convertCacheKey(content, searchText))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		return
+	}
+	_ = os.WriteFile(path, data, 0644)
+}
+
+// validRange reports whether a converter range spans exactly the search lines
+func validRange(result util.RangeResult, searchText string) bool {
+	if result.Start <= 0 || result.End < result.Start {
+		return false
+	}
+	return result.End-result.Start+1 == len(strings.Split(searchText, "\n"))
+}
+
+// batchRangeResult is one element of the CONVERT_BATCH.md output array
+type batchRangeResult struct {
+	ID    int `json:"id"`
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// convertBatch asks the converter for the ranges of several searches in the
+// same file with one call. Only ranges that pass validation are returned,
+// keyed by position in searches, the caller converts the rest individually.
+func convertBatch(ctx context.Context, content string, searches []string, reasoningCallback func(string)) (map[int]util.RangeResult, error) {
+	data, err := prompts.EmbeddedFiles.ReadFile("CONVERT_BATCH.md")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read batch converter prompt: %v", err)
+	}
+
+	var b strings.Builder
+	b.WriteString("\n\n# Input data\n\n")
+	b.WriteString("<NinaFile>\n")
+	b.WriteString(strings.TrimSpace(content))
+	b.WriteString("\n</NinaFile>\n")
+	for i, search := range searches {
+		fmt.Fprintf(&b, "\n<NinaSearch id=\"%d\">\n", i)
+		b.WriteString(search)
+		b.WriteString("\n</NinaSearch>\n")
+	}
+
+	output, err := converterResponse(ctx, AiRequest{
+		Model:   ConverterModel(),
+		System:  string(data),
+		Message: b.String(),
+	}, reasoningCallback)
+	if err != nil {
+		return nil, err
+	}
+
+	output = util.RemoveFencedBlocks(strings.TrimSpace(output))
+	var batchResults []batchRangeResult
+	if err := json.Unmarshal([]byte(output), &batchResults); err != nil {
+		return nil, fmt.Errorf("failed to parse batch converter output: %v", err)
+	}
+
+	results := map[int]util.RangeResult{}
+	for _, r := range batchResults {
+		if r.ID < 0 || r.ID >= len(searches) {
+			continue
+		}
+		result := util.RangeResult{Start: r.Start, End: r.End}
+		if validRange(result, searches[r.ID]) {
+			results[r.ID] = result
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,148 @@
+// Tests for the converter cache and batching searches in one file
+package lib
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/nathants/nina/util"
+)
+
+// stubConverter replaces the converter model with respond, returning the
+// requests it received
+func stubConverter(t *testing.T, respond func(AiRequest) string) func() []AiRequest {
+	var mu sync.Mutex
+	var requests []AiRequest
+	orig := converterResponse
+	converterResponse = func(ctx context.Context, req AiRequest, reasoningCallback func(string)) (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests = append(requests, req)
+		return respond(req), nil
+	}
+	t.Cleanup(func() { converterResponse = orig })
+	return func() []AiRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]AiRequest(nil), requests...)
+	}
+}
+
+func TestConvertCacheKey(t *testing.T) {
+	key := convertCacheKey("a\nb", "c")
+	if len(key) != 64 || key != convertCacheKey("a\nb", "c") {
+		t.Errorf("convertCacheKey() = %q, want a stable sha256", key)
+	}
+	for _, other := range [][2]string{{"a\nb", "d"}, {"a\nbc", ""}, {"a", "\nbc"}} {
+		if convertCacheKey(other[0], other[1]) == key {
+			t.Errorf("convertCacheKey(%q, %q) collides with (\"a\\nb\", \"c\")", other[0], other[1])
+		}
+	}
+}
+
+func TestConvertCache(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if _, ok := loadConvertCache("content", "search"); ok {
+		t.Fatal("loadConvertCache() hit on an empty cache")
+	}
+	saveConvertCache("content", "search", util.RangeResult{Start: 3, End: 4})
+	got, ok := loadConvertCache("content", "search")
+	if !ok || got != (util.RangeResult{Start: 3, End: 4}) {
+		t.Errorf("loadConvertCache() = %+v, %v", got, ok)
+	}
+	if _, ok := loadConvertCache("changed content", "search"); ok {
+		t.Error("loadConvertCache() hit for different content")
+	}
+	saveConvertCache("content", "other", util.RangeResult{Start: -1, End: -1})
+	if _, ok := loadConvertCache("content", "other"); ok {
+		t.Error("loadConvertCache() hit for a range not found")
+	}
+}
+
+func TestValidRange(t *testing.T) {
+	tests := []struct {
+		result util.RangeResult
+		search string
+		want   bool
+	}{
+		{util.RangeResult{Start: 2, End: 3}, "a\nb", true},
+		{util.RangeResult{Start: 2, End: 2}, "a", true},
+		{util.RangeResult{Start: 2, End: 4}, "a\nb", false},
+		{util.RangeResult{Start: 3, End: 2}, "a", false},
+		{util.RangeResult{Start: 0, End: 0}, "a", false},
+		{util.RangeResult{Start: -1, End: -1}, "a", false},
+	}
+	for _, tt := range tests {
+		if got := validRange(tt.result, tt.search); got != tt.want {
+			t.Errorf("validRange(%+v, %q) = %v, want %v", tt.result, tt.search, got, tt.want)
+		}
+	}
+}
+
+func TestConvertBatch(t *testing.T) {
+	requests := stubConverter(t, func(AiRequest) string {
+		return "```json\n" + `[{"id":0,"start":2,"end":3},{"id":1,"start":5,"end":7},{"id":2,"start":9,"end":9},{"id":7,"start":1,"end":1},{"id":-1,"start":1,"end":1}]` + "\n```"
+	})
+	results, err := convertBatch(context.Background(), "file", []string{"a\nb", "c", "d"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[int]util.RangeResult{0: {Start: 2, End: 3}, 2: {Start: 9, End: 9}}
+	if len(results) != len(want) || results[0] != want[0] || results[2] != want[2] {
+		t.Errorf("convertBatch() = %+v, want %+v", results, want)
+	}
+	message := requests()[0].Message
+	for _, id := range []string{`<NinaSearch id="0">`, `<NinaSearch id="1">`, `<NinaSearch id="2">`} {
+		if !strings.Contains(message, id) {
+			t.Errorf("batch message is missing %s", id)
+		}
+	}
+
+	stubConverter(t, func(AiRequest) string { return "no ranges" })
+	if _, err := convertBatch(context.Background(), "file", []string{"a", "b"}, nil); err == nil {
+		t.Error("convertBatch() expected a parse error")
+	}
+}
+
+func TestConvertToRangeUpdatesBatch(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("NINA_CONVERT_CORPUS", "0")
+	t.Setenv("NINA_CONVERTER_MODEL", "sonnet")
+	requests := stubConverter(t, func(req AiRequest) string {
+		if strings.Contains(req.Message, `<NinaSearch id="0">`) {
+			return `[{"id":0,"start":4,"end":4},{"id":1,"start":4,"end":5}]`
+		}
+		return `{"start":5,"end":5}`
+	})
+
+	content := util.AddLineNumbers("package main\n\nfunc main() {\n\tprintln(1)\n\tprintln(2)\n}")
+	session := &util.SessionState{
+		PathMap:       map[string]string{"main.go": "/repo/main.go"},
+		SelectedFiles: map[string]string{"/repo/main.go": content},
+	}
+	updates := []util.FileUpdate{
+		{FileName: "main.go", SearchLines: []string{"\tprintln(one)"}, ReplaceLines: []string{"\tprintln(10)"}},
+		{FileName: "main.go", SearchLines: []string{"\tprintln(two)"}, ReplaceLines: []string{"\tprintln(20)"}},
+	}
+	check := func() {
+		t.Helper()
+		converted, err := ConvertToRangeUpdates(context.Background(), updates, session, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if converted[0].StartLine != 4 || converted[0].EndLine != 4 || converted[1].StartLine != 5 || converted[1].EndLine != 5 {
+			t.Errorf("ConvertToRangeUpdates() = %+v", converted)
+		}
+	}
+
+	check()
+	if got := len(requests()); got != 2 {
+		t.Fatalf("got %d converter calls, want one batch and one single for the invalid range", got)
+	}
+	check()
+	if got := len(requests()); got != 2 {
+		t.Errorf("got %d converter calls, want cached ranges to skip the model", got)
+	}
+}
@@ -0,0 +1,141 @@
+// Feedback corpus for ConvertToRangeUpdates. Every conversion outcome is
+// recorded under agents/convert-corpus as one json file per (file content,
+// search text) holding the numbered content, the method that resolved it,
+// the chosen range, and whether it validated. Records are redacted like other
+// logs, skipped for content over maxCorpusContent, and pruned with the
+// session logs by nina clean. nina bench convert replays the corpus against
+// the current converter to measure accuracy regressions. NINA_CONVERT_CORPUS
+// overrides the directory, and 0 disables recording.
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nathants/nina/prompts"
+	"github.com/nathants/nina/util"
+)
+
+// How a conversion was resolved, ranges reused from the convert cache were
+// recorded when first converted
+const (
+	ConvertExact = "exact" // exact unique match, no model call
+	ConvertBatch = "batch" // one model call for several searches in a file
+	ConvertModel = "model" // one model call for the search
+)
+
+// ConvertRecord is one conversion outcome in the corpus
+type ConvertRecord struct {
+	Time    time.Time `json:"time"`
+	File    string    `json:"file"`
+	Content string    `json:"content"` // the numbered file content
+	Search  string    `json:"search"`
+	Method  string    `json:"method"`
+	Model   string    `json:"model,omitempty"`
+	Start   int       `json:"start"`
+	End     int       `json:"end"`
+	Valid   bool      `json:"valid"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// CorpusDir returns the converter corpus directory, "" when recording is off
+func CorpusDir() string {
+	dir := os.Getenv("NINA_CONVERT_CORPUS")
+	if dir == "0" {
+		return ""
+	}
+	if dir == "" {
+		dir = util.GetAgentsSubdir("convert-corpus")
+	}
+	return dir
+}
+
+// maxCorpusContent bounds the file content of a record, conversions in larger
+// files are not recorded
+const maxCorpusContent = 256 << 10
+
+// recordConversion adds a conversion outcome to the corpus, failures only
+// lose the record
+func recordConversion(file, content, searchText, method string, result util.RangeResult, err error) {
+	dir := CorpusDir()
+	if dir == "" || len(content) > maxCorpusContent {
+		return
+	}
+	record := ConvertRecord{
+		Time:    time.Now().UTC(),
+		File:    file,
+		Content: content,
+		Search:  searchText,
+		Method:  method,
+		Start:   result.Start,
+		End:     result.End,
+		Valid:   err == nil && validRange(result, searchText),
+	}
+	if method == ConvertBatch || method == ConvertModel {
+		record.Model = ConverterModel()
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		util.Verbosef("failed to record conversion: %v", err)
+		return
+	}
+	path := filepath.Join(dir, convertCacheKey(content, searchText)+".json")
+	if err := util.WriteLog(path, data); err != nil {
+		util.Verbosef("failed to record conversion: %v", err)
+	}
+}
+
+// LoadCorpus reads the records of a corpus directory, oldest first
+func LoadCorpus(dir string) ([]ConvertRecord, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var records []ConvertRecord
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var record ConvertRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		records = append(records, record)
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Time.Before(records[j].Time)
+	})
+	return records, nil
+}
+
+// ReplayConversion converts a corpus record again with the current matcher
+// and converter model, bypassing the convert cache and the corpus, and
+// returns the range and the method that found it
+func ReplayConversion(ctx context.Context, record ConvertRecord, content string) (util.RangeResult, string, error) {
+	searchLines := strings.Split(record.Search, "\n")
+	if found, err := util.FindSearchRange(content, searchLines); err == nil {
+		return found, ConvertExact, nil
+	} else if ConverterModel() == ConverterLocal {
+		return util.RangeResult{}, ConvertExact, fmt.Errorf("local converter failed for %s: %v", record.File, err)
+	}
+	data, err := prompts.EmbeddedFiles.ReadFile("CONVERT.md")
+	if err != nil {
+		return util.RangeResult{}, ConvertModel, fmt.Errorf("failed to read converter prompt: %v", err)
+	}
+	update := util.FileUpdate{FileName: record.File, SearchLines: searchLines}
+	result, err := convertSingleUpdate(ctx, string(data), 0, update, content, record.Search, nil)
+	return result, ConvertModel, err
+}
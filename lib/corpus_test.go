@@ -0,0 +1,88 @@
+// Tests for the converter feedback corpus
+package lib
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/nathants/nina/util"
+)
+
+func TestConverterCorpus(t *testing.T) {
+	t.Chdir(t.TempDir())
+	dir := t.TempDir()
+	t.Setenv("NINA_CONVERT_CORPUS", dir)
+	t.Setenv("NINA_CONVERTER_MODEL", ConverterLocal)
+
+	content := util.AddLineNumbers("package main\n\nfunc main() {\n\tprintln(1)\n}")
+	session := &util.SessionState{
+		PathMap:       map[string]string{"main.go": "/repo/main.go"},
+		SelectedFiles: map[string]string{"/repo/main.go": content},
+	}
+	found := util.FileUpdate{FileName: "main.go", SearchLines: []string{"\tprintln(1)"}, ReplaceLines: []string{"\tprintln(2)"}}
+	if _, err := ConvertToRangeUpdates(context.Background(), []util.FileUpdate{found}, session, nil); err != nil {
+		t.Fatal(err)
+	}
+	missing := util.FileUpdate{FileName: "main.go", SearchLines: []string{"\tprintln(3)"}}
+	if _, err := ConvertToRangeUpdates(context.Background(), []util.FileUpdate{missing}, session, nil); err == nil {
+		t.Fatal("expected the local converter to fail")
+	}
+
+	records, err := LoadCorpus(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("got %d records, want 2", len(records))
+	}
+	ok, failed := records[0], records[1]
+	if !ok.Valid || ok.Method != ConvertExact || ok.Start != 4 || ok.End != 4 || ok.Error != "" {
+		t.Errorf("unexpected record: %+v", ok)
+	}
+	if failed.Valid || failed.Start != 0 || !strings.Contains(failed.Error, "local converter failed") {
+		t.Errorf("unexpected record: %+v", failed)
+	}
+
+	if ok.Content != content {
+		t.Errorf("recorded content differs:\n%s", ok.Content)
+	}
+	result, method, err := ReplayConversion(context.Background(), ok, ok.Content)
+	if err != nil || method != ConvertExact || result.Start != 4 || result.End != 4 {
+		t.Errorf("ReplayConversion() = %+v, %s, %v", result, method, err)
+	}
+
+	// recording can be turned off
+	t.Setenv("NINA_CONVERT_CORPUS", "0")
+	other := util.FileUpdate{FileName: "main.go", SearchLines: []string{"func main() {"}}
+	if _, err := ConvertToRangeUpdates(context.Background(), []util.FileUpdate{other}, session, nil); err != nil {
+		t.Fatal(err)
+	}
+	if records, _ := LoadCorpus(dir); len(records) != 2 {
+		t.Errorf("got %d records with recording off, want 2", len(records))
+	}
+}
+
+func TestConverterCorpusRedactsAndBounds(t *testing.T) {
+	t.Chdir(t.TempDir())
+	dir := t.TempDir()
+	t.Setenv("NINA_CONVERT_CORPUS", dir)
+	t.Setenv("NINA_NO_REDACT", "")
+	t.Setenv("MY_SERVICE_TOKEN", "supersecretvalue123")
+
+	content := util.AddLineNumbers("token = \"supersecretvalue123\"\nx = 1")
+	recordConversion("a.py", content, "x = 1", ConvertExact, util.RangeResult{Start: 2, End: 2}, nil)
+	large := util.AddLineNumbers(strings.Repeat("x = 1\n", maxCorpusContent/5))
+	recordConversion("b.py", large, "x = 1", ConvertExact, util.RangeResult{Start: 1, End: 1}, nil)
+
+	records, err := LoadCorpus(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].File != "a.py" {
+		t.Fatalf("got %d records, want only the one under maxCorpusContent", len(records))
+	}
+	if strings.Contains(records[0].Content, "supersecretvalue123") || !strings.Contains(records[0].Content, "[REDACTED]") {
+		t.Errorf("recorded content is not redacted:\n%s", records[0].Content)
+	}
+}
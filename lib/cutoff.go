@@ -0,0 +1,56 @@
+// Automatic continuation of responses cut off at the output token limit.
+// When the provider reports a max tokens stop before </NinaOutput>, the loop
+// asks the model to continue exactly where it left off, up to
+// maxContinuations times, and stitches the pieces into one response before
+// processing it, so a long NinaChange is not lost with its iteration. Text a
+// continuation repeats from the end of the previous piece is dropped.
+package lib
+
+import (
+	"context"
+	"strings"
+
+	"github.com/nathants/nina/util"
+)
+
+// maxContinuations is how many times one response is continued
+const maxContinuations = 3
+
+// minOverlap is the shortest repeated text dropped when stitching, shorter
+// matches are likely to be coincidence
+const minOverlap = 8
+
+// maxOverlap is the longest repeated text looked for when stitching
+const maxOverlap = 2000
+
+const continuationPrompt = "Your response was cut off at the output token limit. Continue exactly where you left off, starting with the next character. Do not repeat anything or add any preamble."
+
+// continueResponse continues response while the provider reports it was cut
+// off before </NinaOutput>, returning the stitched response
+func continueResponse(ctx context.Context, provider AIProvider, model, systemPrompt, response string, state *LoopState, thinking bool) string {
+	for i := 0; i < maxContinuations && state.Truncated && !strings.Contains(response, util.NinaOutputEnd); i++ {
+		LogStderr("Response cut off at the output token limit, continuing (%d/%d)", i+1, maxContinuations)
+		next, err := callWithRetry(ctx, provider, model, systemPrompt, continuationPrompt, state, thinking)
+		if err != nil {
+			LogError("Warning: failed to continue the cut off response: %v", err)
+			break
+		}
+		state.Continuations++
+		response = stitch(response, next)
+	}
+	return response
+}
+
+// stitch joins a cut off response and its continuation. A continuation that
+// starts a new NinaOutput replaces the response, the model started over.
+func stitch(response, next string) string {
+	if strings.HasPrefix(strings.TrimSpace(next), util.NinaOutputStart) && strings.Contains(response, util.NinaOutputStart) {
+		return next
+	}
+	for k := min(len(response), len(next), maxOverlap); k >= minOverlap; k-- {
+		if strings.HasSuffix(response, next[:k]) {
+			return response + next[k:]
+		}
+	}
+	return response + next
+}
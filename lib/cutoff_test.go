@@ -0,0 +1,19 @@
+package lib
+
+import "testing"
+
+func TestStitch(t *testing.T) {
+	tests := []struct {
+		response, next, want string
+	}{
+		{"<NinaOutput>\n<NinaBash>echo hel", "lo</NinaBash>\n</NinaOutput>", "<NinaOutput>\n<NinaBash>echo hello</NinaBash>\n</NinaOutput>"},
+		{"<NinaOutput>\n<NinaBash>echo hel", "<NinaBash>echo hello</NinaBash>\n</NinaOutput>", "<NinaOutput>\n<NinaBash>echo hello</NinaBash>\n</NinaOutput>"},
+		{"<NinaOutput>\n<NinaBash>ech", "<NinaOutput>\n<NinaStop>done</NinaStop>\n</NinaOutput>", "<NinaOutput>\n<NinaStop>done</NinaStop>\n</NinaOutput>"},
+		{"a b c", " c d", "a b c c d"}, // short overlaps are kept
+	}
+	for _, tt := range tests {
+		if got := stitch(tt.response, tt.next); got != tt.want {
+			t.Errorf("stitch(%q, %q) = %q, want %q", tt.response, tt.next, got, tt.want)
+		}
+	}
+}
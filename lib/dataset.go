@@ -0,0 +1,85 @@
+// Training data from past sessions, groundwork for distilling nina's behavior
+// into smaller local models. `nina dataset` picks the indexed sessions whose
+// last test command passed or that were tagged, see sessions.go, rebuilds
+// their transcripts, see transcript.go, and writes one example per response
+// holding a NinaOutput: the system prompt, the user message that led to it,
+// and the response. Secrets and personal data are redacted from every example.
+package lib
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/nathants/nina/util"
+)
+
+// DatasetFilter picks the sessions training pairs come from, a session
+// matching either condition is used
+type DatasetFilter struct {
+	Tags   []string // sessions tagged with any of these
+	Passed bool     // sessions whose last test command passed
+}
+
+// Match reports whether the session is used
+func (f DatasetFilter) Match(record SessionRecord) bool {
+	if f.Passed && record.Tests == TestsPassed {
+		return true
+	}
+	for _, tag := range record.Tags {
+		if slices.Contains(f.Tags, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// TrainingPairs splits a transcript into one redacted example per response
+// holding a NinaOutput, without the system prompt when system is false
+func TrainingPairs(transcript Transcript, system bool) []Transcript {
+	var systemMsg *ChatMessage
+	var pairs []Transcript
+	for i, msg := range transcript.Messages {
+		switch {
+		case msg.Role == "system":
+			systemMsg = &ChatMessage{Role: "system", Content: util.RedactPII(msg.Content)}
+		case msg.Role == "assistant" && i > 0 && transcript.Messages[i-1].Role == "user" && strings.Contains(msg.Content, util.NinaOutputStart):
+			pair := Transcript{Session: transcript.Session, Model: transcript.Model}
+			if system && systemMsg != nil {
+				pair.Messages = append(pair.Messages, *systemMsg)
+			}
+			pair.Messages = append(pair.Messages,
+				ChatMessage{Role: "user", Content: util.RedactPII(transcript.Messages[i-1].Content)},
+				ChatMessage{Role: "assistant", Content: util.RedactPII(msg.Content)},
+			)
+			pairs = append(pairs, pair)
+		}
+	}
+	return pairs
+}
+
+// BuildDataset returns the training pairs of the indexed sessions filter
+// matches and how many sessions they came from. Sessions whose logs were
+// pruned are skipped.
+func BuildDataset(filter DatasetFilter, system bool) ([]Transcript, int, error) {
+	records, err := ReadSessions()
+	if err != nil {
+		return nil, 0, err
+	}
+	var pairs []Transcript
+	sessions := 0
+	for _, record := range slices.Backward(records) {
+		if !filter.Match(record) {
+			continue
+		}
+		transcript, err := LoadTranscript(record.ID)
+		if err != nil {
+			util.Verbosef("skipping %s: %v", record.ID, err)
+			continue
+		}
+		if sessionPairs := TrainingPairs(transcript, system); len(sessionPairs) > 0 {
+			pairs = append(pairs, sessionPairs...)
+			sessions++
+		}
+	}
+	return pairs, sessions, nil
+}
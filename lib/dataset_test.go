@@ -0,0 +1,55 @@
+// Tests for building training pairs from sessions, picking successful
+// sessions and redacting examples
+package lib
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nathants/nina/util"
+)
+
+func TestTrainingPairs(t *testing.T) {
+	output := util.NinaOutputStart + "mail ops@example.com" + util.NinaOutputEnd
+	transcript := Transcript{Messages: []ChatMessage{
+		{Role: "system", Content: "sys"},
+		{Role: "user", Content: "fix /home/jane/app.go"},
+		{Role: "assistant", Content: output},
+		{Role: "user", Content: "results"},
+		{Role: "assistant", Content: "no tags here"},
+	}}
+
+	pairs := TrainingPairs(transcript, true)
+	if len(pairs) != 1 || len(pairs[0].Messages) != 3 {
+		t.Fatalf("pairs = %+v, want one example with the system prompt", pairs)
+	}
+	if got := pairs[0].Messages[1].Content; got != "fix ~/app.go" {
+		t.Errorf("user = %q, want the home directory redacted", got)
+	}
+	if got := pairs[0].Messages[2].Content; !strings.Contains(got, "[EMAIL]") {
+		t.Errorf("assistant = %q, want the email redacted", got)
+	}
+	if pairs := TrainingPairs(transcript, false); len(pairs) != 1 || pairs[0].Messages[0].Role != "user" {
+		t.Errorf("pairs = %+v, want no system prompt", pairs)
+	}
+}
+
+func TestDatasetFilter(t *testing.T) {
+	filter := DatasetFilter{Tags: []string{"good"}, Passed: true}
+	for _, tc := range []struct {
+		record SessionRecord
+		want   bool
+	}{
+		{SessionRecord{Tests: TestsPassed}, true},
+		{SessionRecord{Tests: TestsFailed}, false},
+		{SessionRecord{Tests: TestsFailed, Tags: []string{"good"}}, true},
+		{SessionRecord{Tags: []string{"bad"}}, false},
+	} {
+		if got := filter.Match(tc.record); got != tc.want {
+			t.Errorf("Match(%+v) = %v, want %v", tc.record, got, tc.want)
+		}
+	}
+	if (DatasetFilter{Tags: []string{"good"}}).Match(SessionRecord{Tests: TestsPassed}) {
+		t.Error("want passing sessions skipped when only tags are used")
+	}
+}
@@ -0,0 +1,141 @@
+// Cost ceilings for nina run. With --downshift a session whose spend crosses
+// a threshold continues on a cheaper model instead of stopping: the model
+// named with the threshold, or else the cheaper tier of the current model,
+// like flash for sonnet or o4-mini for o3, see models.Model.Cheaper. The
+// exchanges so far are recorded in the cheaper model's history so it picks
+// up where the last one stopped. The switch is logged and the status bar
+// shows the model the session started on for the rest of the run.
+package lib
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nathants/nina/models"
+)
+
+// CostStep is a session spend threshold and the model used past it
+type CostStep struct {
+	Cost  float64 // USD spent by the session
+	Model string  // alias to switch to, empty for the current model's cheaper tier
+}
+
+// ParseDownshift parses thresholds like "5" or "5=flash", in USD, sorted by
+// cost
+func ParseDownshift(specs []string) ([]CostStep, error) {
+	var steps []CostStep
+	for _, spec := range specs {
+		cost, model, _ := strings.Cut(spec, "=")
+		usd, err := strconv.ParseFloat(strings.TrimPrefix(strings.TrimSpace(cost), "$"), 64)
+		if err != nil || usd <= 0 {
+			return nil, fmt.Errorf("invalid downshift %q, expected a cost in USD like 5 or 5=flash", spec)
+		}
+		model = strings.TrimSpace(model)
+		if model != "" {
+			if _, err := models.Lookup(model); err != nil {
+				return nil, fmt.Errorf("invalid downshift %q: %w", spec, err)
+			}
+		}
+		steps = append(steps, CostStep{Cost: usd, Model: model})
+	}
+	sort.SliceStable(steps, func(i, j int) bool { return steps[i].Cost < steps[j].Cost })
+	return steps, nil
+}
+
+// exchange is a user message and the response used for it
+type exchange struct {
+	user     string
+	response string
+}
+
+// recordExchange keeps the exchange for a model the session may downshift to
+func recordExchange(state *LoopState, userMessage, response string) {
+	if len(state.config.Downshift) > 0 {
+		state.exchanges = append(state.exchanges, exchange{userMessage, response})
+	}
+}
+
+// sessionSpend returns the USD spent since the loop started
+func sessionSpend(state *LoopState) float64 {
+	return SessionCost() - state.startCost
+}
+
+// downshift returns the provider and model of the next step, a cheaper model
+// holding the conversation so far once the session's spend crosses the next
+// threshold, otherwise provider and model
+func downshift(state *LoopState, provider AIProvider, model string) (AIProvider, string, error) {
+	steps := state.config.Downshift
+	spent := sessionSpend(state)
+	target := model
+	for ; state.costSteps < len(steps) && spent >= steps[state.costSteps].Cost; state.costSteps++ {
+		step := steps[state.costSteps]
+		next := step.Model
+		if next == "" {
+			m, err := models.Lookup(target)
+			if err != nil || m.Cheaper == "" {
+				LogError("Warning: session cost $%.2f crossed $%.2f but %s has no cheaper model", spent, step.Cost, target)
+				continue
+			}
+			next = m.Cheaper
+		}
+		target = next
+	}
+	if target == model {
+		return provider, model, nil
+	}
+
+	next, nextModel := state.config.CheapProvider, target
+	if next == nil {
+		var err error
+		if next, nextModel, err = CreateProviderForModel(target); err != nil {
+			return nil, "", fmt.Errorf("failed to create provider for %s: %w", target, err)
+		}
+		if _, ok := next.(*OllamaClient); ok {
+			window, err := configureOllamaModel(context.Background(), nextModel)
+			if err != nil {
+				return nil, "", fmt.Errorf("failed to configure %s: %w", nextModel, err)
+			}
+			if state.MaxTokens == 0 || state.MaxTokens > window {
+				state.MaxTokens = window
+			}
+		}
+	}
+	for _, e := range state.exchanges {
+		recordTurn(next, e.user, e.response)
+	}
+	// the cheaper model holds no tool calls to answer, so their results are
+	// sent as text
+	for _, call := range state.ToolCalls {
+		if call.Result != "" {
+			state.LastResults = append(state.LastResults, call.Result)
+		}
+	}
+	state.ToolCalls = nil
+	next, err := chaosFromEnv(next)
+	if err != nil {
+		return nil, "", err
+	}
+	if state.tools, err = nativeTools(state.config.ToolProcessor, next); err != nil {
+		return nil, "", err
+	}
+
+	LogStderr("Session cost $%.2f crossed $%.2f, continuing with %s instead of %s", spent, steps[state.costSteps-1].Cost, nextModel, state.Model)
+	if state.Downshifted == "" {
+		state.Downshifted = state.Model
+	}
+	state.Model = nextModel
+	state.AIProvider = next
+	return next, nextModel, nil
+}
+
+// statusModel returns the model shown in the status bar, with the model the
+// session started on once it downshifted
+func statusModel(state *LoopState) string {
+	if state.Downshifted == "" {
+		return state.Model
+	}
+	return fmt.Sprintf("%s (downshifted from %s)", state.Model, state.Downshifted)
+}
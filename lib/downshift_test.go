@@ -0,0 +1,57 @@
+// Tests for cost ceilings switching a session to a cheaper model
+package lib
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseDownshift(t *testing.T) {
+	steps, err := ParseDownshift([]string{"10=flash", "$2.50"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(steps, []CostStep{{Cost: 2.5}, {Cost: 10, Model: "flash"}}) {
+		t.Errorf("ParseDownshift() = %+v", steps)
+	}
+	for _, spec := range []string{"", "five", "-1", "5=no-such-model"} {
+		if _, err := ParseDownshift([]string{spec}); err == nil {
+			t.Errorf("ParseDownshift(%q): expected error", spec)
+		}
+	}
+}
+
+func TestDownshift(t *testing.T) {
+	primary := NewMockClient("one")
+	cheap := NewMockClient("two")
+	state := &LoopState{Model: "sonnet", config: LoopConfig{
+		Downshift:     []CostStep{{Cost: 1}, {Cost: 5, Model: "o4-mini"}},
+		CheapProvider: cheap,
+	}}
+	recordExchange(state, "hello", "one")
+
+	provider, model, err := downshift(state, primary, "sonnet")
+	if err != nil || provider != primary || model != "sonnet" || state.Downshifted != "" {
+		t.Fatalf("downshift() under the ceiling = %T %s %v", provider, model, err)
+	}
+
+	state.startCost = SessionCost() - 2
+	provider, model, err = downshift(state, primary, "sonnet")
+	if err != nil || provider != cheap || model != "flash" {
+		t.Fatalf("downshift() = %T %s %v, want flash", provider, model, err)
+	}
+	if state.Model != "flash" || state.Downshifted != "sonnet" || !slices.Equal(cheap.Recorded, []string{"one"}) {
+		t.Errorf("state %s from %s, recorded %q", state.Model, state.Downshifted, cheap.Recorded)
+	}
+	if got := statusModel(state); got != "flash (downshifted from sonnet)" {
+		t.Errorf("statusModel() = %q", got)
+	}
+
+	if _, model, _ = downshift(state, provider, model); model != "flash" {
+		t.Errorf("downshift() = %s, want no switch before the next ceiling", model)
+	}
+	state.startCost = SessionCost() - 6
+	if _, model, _ = downshift(state, provider, model); model != "o4-mini" || state.Downshifted != "sonnet" {
+		t.Errorf("downshift() = %s from %s, want o4-mini from sonnet", model, state.Downshifted)
+	}
+}
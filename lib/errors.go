@@ -0,0 +1,129 @@
+// Error kinds and exit codes. A failing command exits with the code of its
+// error's kind, so wrappers can tell a missing key from a rate limit or a
+// prompt too large for the model without parsing messages. With the global
+// --json flag the error is written to stderr as one json object:
+//
+//	{"error":"...","kind":"rate_limit","exit_code":4}
+//
+// Errors are classified by an explicit kind when one was attached with
+// WithKind, then by sentinel, then by the provider error text.
+package lib
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Error kinds
+const (
+	ErrorGeneral         = "error"
+	ErrorAuth            = "auth"             // missing or rejected credentials
+	ErrorRateLimit       = "rate_limit"       // rate limited or out of quota after retries
+	ErrorContextOverflow = "context_overflow" // prompt too large for the model
+	ErrorParse           = "parse"            // responses could not be processed
+	ErrorTool            = "tool_failure"     // a tool or execution backend failed
+	ErrorBudget          = "budget_exceeded"  // token budget used up
+)
+
+// ExitCodes maps error kinds to process exit codes, 2 is left to go-arg for
+// usage errors
+var ExitCodes = map[string]int{
+	ErrorGeneral:         1,
+	ErrorAuth:            3,
+	ErrorRateLimit:       4,
+	ErrorContextOverflow: 5,
+	ErrorParse:           6,
+	ErrorTool:            7,
+	ErrorBudget:          8,
+}
+
+// KindError attaches an error kind to an error
+type KindError struct {
+	Kind string
+	Err  error
+}
+
+func (e *KindError) Error() string { return e.Err.Error() }
+
+func (e *KindError) Unwrap() error { return e.Err }
+
+// WithKind returns err classified as kind, nil for a nil err
+func WithKind(kind string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &KindError{Kind: kind, Err: err}
+}
+
+// provider error text for each kind, matched lowercased
+var errorPatterns = []struct {
+	kind     string
+	patterns []string
+}{
+	{ErrorContextOverflow, []string{"context_length_exceeded", "prompt is too long", "maximum context length", "exceeds the maximum number of tokens", "input is too long"}},
+	{ErrorAuth, []string{"authentication_error", "permission_error", "invalid_api_key", "invalid x-api-key", "incorrect api key", "unauthenticated", "permission_denied", "status 401", "status 403", "401 unauthorized", "403 forbidden", "environment variable not set", "no gemini credentials"}},
+	{ErrorRateLimit, []string{"429", "rate_limit", "rate limit", "resource_exhausted", "insufficient_quota"}},
+}
+
+// ErrorKind classifies err, ErrorGeneral when nothing matches
+func ErrorKind(err error) string {
+	if err == nil {
+		return ""
+	}
+	var kindErr *KindError
+	if errors.As(err, &kindErr) {
+		return kindErr.Kind
+	}
+	if errors.Is(err, ErrContextWindow) {
+		return ErrorContextOverflow
+	}
+	msg := strings.ToLower(err.Error())
+	for _, p := range errorPatterns {
+		for _, pattern := range p.patterns {
+			if strings.Contains(msg, pattern) {
+				return p.kind
+			}
+		}
+	}
+	return ErrorGeneral
+}
+
+// ExitCode returns the exit code for err, 0 for nil
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	return ExitCodes[ErrorKind(err)]
+}
+
+// JSONErrors reports whether errors are written as json, see the global
+// --json flag which sets NINA_JSON_ERRORS
+func JSONErrors() bool {
+	return os.Getenv("NINA_JSON_ERRORS") == "1"
+}
+
+// errorReport is the json written for a fatal error with --json
+type errorReport struct {
+	Error    string `json:"error"`
+	Kind     string `json:"kind"`
+	ExitCode int    `json:"exit_code"`
+}
+
+// FormatError returns the stderr line for a fatal error
+func FormatError(err error) string {
+	if !JSONErrors() {
+		return fmt.Sprintf("Error: %v", err)
+	}
+	data, _ := json.Marshal(errorReport{Error: err.Error(), Kind: ErrorKind(err), ExitCode: ExitCode(err)})
+	return string(data)
+}
+
+// Fatal writes err to stderr and exits with its exit code
+func Fatal(err error) {
+	restoreTerminal()
+	fmt.Fprintln(os.Stderr, FormatError(err))
+	os.Exit(ExitCode(err))
+}
@@ -0,0 +1,53 @@
+package lib
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestErrorKind(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{nil, ""},
+		{errors.New("something broke"), ErrorGeneral},
+		{fmt.Errorf("failed to call AI provider: %w", errors.New(`api error: {"type":"error","error":{"type":"authentication_error","message":"invalid x-api-key"}}`)), ErrorAuth},
+		{errors.New("OPENAI_API_KEY environment variable not set"), ErrorAuth},
+		{errors.New("API error: status 401 Unauthorized, body: {}"), ErrorAuth},
+		{errors.New("api error (status 429): rate_limit_error"), ErrorRateLimit},
+		{errors.New(`api error: {"error":{"code":"context_length_exceeded"}}`), ErrorContextOverflow},
+		{fmt.Errorf("failed to call AI provider: %w", fmt.Errorf("%w: 300k tokens", ErrContextWindow)), ErrorContextOverflow},
+		{fmt.Errorf("run: %w", WithKind(ErrorParse, errors.New("3 invalid responses in a row: rate limit"))), ErrorParse},
+		{WithKind(ErrorBudget, errors.New("token budget exhausted")), ErrorBudget},
+	}
+	for _, tt := range tests {
+		if got := ErrorKind(tt.err); got != tt.want {
+			t.Errorf("ErrorKind(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+	if ExitCode(nil) != 0 || ExitCode(errors.New("x")) != 1 || ExitCode(WithKind(ErrorTool, errors.New("x"))) != 7 {
+		t.Error("unexpected exit codes")
+	}
+	if WithKind(ErrorAuth, nil) != nil {
+		t.Error("WithKind(nil) should be nil")
+	}
+}
+
+func TestFormatError(t *testing.T) {
+	err := WithKind(ErrorBudget, errors.New("token budget exhausted"))
+	t.Setenv("NINA_JSON_ERRORS", "")
+	if got := FormatError(err); got != "Error: token budget exhausted" {
+		t.Errorf("got %q", got)
+	}
+	t.Setenv("NINA_JSON_ERRORS", "1")
+	var report errorReport
+	if err := json.Unmarshal([]byte(FormatError(err)), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Error != "token budget exhausted" || report.Kind != ErrorBudget || report.ExitCode != 8 {
+		t.Errorf("unexpected report %+v", report)
+	}
+}
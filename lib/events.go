@@ -0,0 +1,229 @@
+// events.go is the newline delimited json event stream that run and tools
+// write to stdout with --output-format stream-json, for editor integrations.
+// Every line is one event carrying its type and the protocol version. Within
+// a version fields and event types are only ever added, never renamed or
+// removed, so consumers should ignore what they do not recognize. Logs stay
+// on stderr.
+//
+//	{"version":1,"type":"assistant_delta","session":"...","step":1,"kind":"text","text":"..."}
+//	{"version":1,"type":"tool_start","session":"...","step":1,"tool":"NinaBash","command":"go test ./..."}
+//	{"version":1,"type":"tool_result","session":"...","step":1,"tool":"NinaBash","command":"go test ./...","exit_code":0,"stdout":"ok"}
+//	{"version":1,"type":"file_changed","session":"...","step":1,"tool":"NinaChange","path":"main.go","lines_changed":3}
+//	{"version":1,"type":"file_changed","session":"...","step":1,"tool":"external","path":"/repo/gen.go","lines_changed":40}
+//	{"version":1,"type":"usage","session":"...","step":1,"usage":{"input_tokens":1200,"output_tokens":300,"cached_tokens":0,"max_tokens":200000,"cost_usd":0.01}}
+//	{"version":1,"type":"done","session":"...","step":2,"status":"success","stop_reason":"finished"}
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EventProtocolVersion is bumped only for incompatible changes
+const EventProtocolVersion = 1
+
+// Output formats for run and tools
+const (
+	OutputText       = "text"
+	OutputStreamJSON = "stream-json"
+)
+
+// Event types
+const (
+	EventAssistantDelta = "assistant_delta"
+	EventToolStart      = "tool_start"
+	EventToolResult     = "tool_result"
+	EventFileChanged    = "file_changed"
+	EventUsage          = "usage"
+	EventDone           = "done"
+)
+
+// StreamEvent is one line of the event stream
+type StreamEvent struct {
+	Version      int          `json:"version"`
+	Type         string       `json:"type"`
+	Session      string       `json:"session"`
+	Time         string       `json:"time"`
+	Step         int          `json:"step,omitempty"`
+	Kind         string       `json:"kind,omitempty"` // assistant_delta: text or reasoning
+	Text         string       `json:"text,omitempty"`
+	Tool         string       `json:"tool,omitempty"`
+	Command      string       `json:"command,omitempty"`
+	Path         string       `json:"path,omitempty"`
+	LinesChanged int          `json:"lines_changed,omitempty"`
+	ExitCode     *int         `json:"exit_code,omitempty"`
+	Stdout       string       `json:"stdout,omitempty"`
+	Stderr       string       `json:"stderr,omitempty"`
+	Error        string       `json:"error,omitempty"`
+	ErrorKind    string       `json:"error_kind,omitempty"` // done: see ErrorKind
+	Usage        *StreamUsage `json:"usage,omitempty"`
+	Status       string       `json:"status,omitempty"` // done: success or error
+	StopReason   string       `json:"stop_reason,omitempty"`
+}
+
+// StreamUsage is the session's cumulative usage
+type StreamUsage struct {
+	InputTokens  int     `json:"input_tokens"`
+	OutputTokens int     `json:"output_tokens"`
+	CachedTokens int     `json:"cached_tokens"`
+	MaxTokens    int     `json:"max_tokens"`
+	CostUSD      float64 `json:"cost_usd"`
+}
+
+type eventStream struct {
+	mu         sync.Mutex
+	enc        *json.Encoder
+	step       int
+	stopReason string
+}
+
+var (
+	activeEvents   *eventStream
+	activeEventsMu sync.Mutex
+)
+
+// ValidateOutputFormat checks an --output-format value
+func ValidateOutputFormat(format string) error {
+	switch format {
+	case "", OutputText, OutputStreamJSON:
+		return nil
+	}
+	return fmt.Errorf("unknown output format %q (supported: %s, %s)", format, OutputText, OutputStreamJSON)
+}
+
+// StartEventStream writes events to stdout until StopEventStream. Anything
+// else written to stdout goes to stderr instead so the stream stays parseable.
+func StartEventStream() {
+	out := os.Stdout
+	os.Stdout = os.Stderr
+	startEventStream(out)
+}
+
+func startEventStream(w io.Writer) {
+	activeEventsMu.Lock()
+	defer activeEventsMu.Unlock()
+	activeEvents = &eventStream{enc: json.NewEncoder(w)}
+}
+
+// StopEventStream writes the done event and ends the stream
+func StopEventStream(err error) {
+	activeEventsMu.Lock()
+	s := activeEvents
+	activeEvents = nil
+	activeEventsMu.Unlock()
+	if s == nil {
+		return
+	}
+	done := StreamEvent{Type: EventDone, Status: "success", StopReason: s.stopReason}
+	if err != nil {
+		done.Status = "error"
+		done.Error = err.Error()
+		done.ErrorKind = ErrorKind(err)
+	}
+	s.emit(done)
+}
+
+func currentEvents() *eventStream {
+	activeEventsMu.Lock()
+	defer activeEventsMu.Unlock()
+	return activeEvents
+}
+
+func (s *eventStream) emit(event StreamEvent) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	event.Version = EventProtocolVersion
+	event.Session = GetSessionTimestamp()
+	event.Time = time.Now().UTC().Format(time.RFC3339Nano)
+	if event.Step == 0 {
+		event.Step = s.step
+	}
+	_ = s.enc.Encode(event)
+}
+
+// Step sets the step number of following events
+func (s *eventStream) Step(step int) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.step = step
+}
+
+// Stop records why the loop stopped for the done event
+func (s *eventStream) Stop(reason string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stopReason = reason
+}
+
+// Delta emits model output
+func (s *eventStream) Delta(kind, text string) {
+	if text == "" {
+		return
+	}
+	s.emit(StreamEvent{Type: EventAssistantDelta, Kind: kind, Text: text})
+}
+
+// ToolStart emits a tool about to run
+func (s *eventStream) ToolStart(tool, command, path string) {
+	s.emit(StreamEvent{Type: EventToolStart, Tool: tool, Command: command, Path: path})
+}
+
+// Results emits the tool results and changed files of a step
+func (s *eventStream) Results(events []ProcessorEvent) {
+	for _, event := range events {
+		if event.Type == "NinaStop" {
+			continue
+		}
+		result := StreamEvent{
+			Type:   EventToolResult,
+			Tool:   event.Type,
+			Path:   event.Filepath,
+			Stdout: event.Stdout,
+			Stderr: event.Stderr,
+			Error:  event.Reason,
+		}
+		if event.Type == "NinaBash" {
+			exitCode := event.ExitCode
+			result.ExitCode = &exitCode
+			result.Command = strings.TrimSpace(event.Cmd + " " + strings.Join(event.Args, " "))
+		}
+		s.emit(result)
+		switch event.Type {
+		case "NinaChange", "NinaDelete", "NinaRename", "NinaPlan":
+			if event.Reason == "" && event.Filepath != "" && !event.AlreadyApplied {
+				s.FileChanged(event.Type, event.Filepath, event.LinesChanged)
+			}
+		}
+	}
+}
+
+// FileChanged emits a file changed by tool, external for changes made other
+// than by a nina tool
+func (s *eventStream) FileChanged(tool, path string, linesChanged int) {
+	s.emit(StreamEvent{Type: EventFileChanged, Tool: tool, Path: path, LinesChanged: linesChanged})
+}
+
+// Usage emits the session's usage so far
+func (s *eventStream) Usage(state *LoopState) {
+	s.emit(StreamEvent{Type: EventUsage, Usage: &StreamUsage{
+		InputTokens:  state.SessionUsage.SessionInput,
+		OutputTokens: state.TokensUsed,
+		CachedTokens: state.TotalCachedTokens,
+		MaxTokens:    state.MaxTokens,
+		CostUSD:      SessionCost(),
+	}})
+}
@@ -1,11 +1,13 @@
 // GeminiClient wraps the nina-providers Gemini functionality with conversation history
-// maintains full message history and sends all messages to the API on each call
-// supports Gemini's thinking models with reasoning callbacks
+// maintains full typed history and sends all turns to the API on each call
+// supports Gemini's thinking models with reasoning callbacks and function calling
 package lib
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"github.com/nathants/nina/models"
 	gemini "github.com/nathants/nina/providers/gemini"
 	util "github.com/nathants/nina/util"
 	"os"
@@ -14,8 +16,11 @@ import (
 
 // GeminiClient wraps the nina-providers Gemini functionality with conversation management
 type GeminiClient struct {
-	messages []string
+	contents []gemini.Content
 	system   string
+	// pending holds function calls from the last turn, the next user message
+	// answers them since tool results are reported as text
+	pending []gemini.FunctionCall
 }
 
 // NewGeminiClient creates a new Gemini client
@@ -32,27 +37,85 @@ func NewGeminiClient() (*GeminiClient, error) {
 		return nil, fmt.Errorf("GEMINI_OAUTH_TOKEN or GOOGLE_API_KEY environment variable not set")
 	}
 
-	return &GeminiClient{
-		messages: []string{},
-	}, nil
+	return &GeminiClient{}, nil
+}
+
+// RestoreMessages restores the system prompt and typed contents from a
+// previous session for continuation, function calls left unanswered by the
+// last model turn are answered by the next user turn
+func (c *GeminiClient) RestoreMessages(system string, contents []any) error {
+	data, err := json.Marshal(contents)
+	if err != nil {
+		return err
+	}
+	var restored []gemini.Content
+	if err := json.Unmarshal(data, &restored); err != nil {
+		return err
+	}
+	c.system = system
+	c.contents = restored
+	c.pending = nil
+	if n := len(restored); n > 0 && restored[n-1].Role == gemini.RoleModel {
+		for _, part := range restored[n-1].Parts {
+			if part.FunctionCall != nil {
+				c.pending = append(c.pending, *part.FunctionCall)
+			}
+		}
+	}
+	return nil
 }
 
 // CallWithStore calls Gemini API maintaining conversation history
 func (c *GeminiClient) CallWithStore(ctx context.Context, model, systemPrompt, userMessage string) (any, error) {
+	return c.call(ctx, model, systemPrompt, userMessage, nil)
+}
+
+// userTurn builds the next user turn, answering pending function calls first
+// with their results
+func (c *GeminiClient) userTurn(userMessage string, results []ToolCall) gemini.Content {
+	turn := gemini.Content{Role: gemini.RoleUser}
+	for i, call := range c.pending {
+		turn.Parts = append(turn.Parts, gemini.Part{FunctionResponse: &gemini.FunctionResponse{
+			ID:       call.ID,
+			Name:     call.Name,
+			Response: map[string]any{"output": toolResult(results, i, call.ID)},
+		}})
+	}
+	turn.Parts = append(turn.Parts, gemini.Part{Text: userMessage})
+	return turn
+}
+
+// RecordTurn records a response from another model, see turnRecorder
+func (c *GeminiClient) RecordTurn(userMessage, response string) {
+	i := turnStart(len(c.contents), func(i int) (string, string) {
+		parts := c.contents[i].Parts
+		if len(parts) == 0 {
+			return c.contents[i].Role, ""
+		}
+		return c.contents[i].Role, parts[len(parts)-1].Text
+	}, userMessage)
+	user := c.userTurn(userMessage, nil)
+	if i < len(c.contents) {
+		user = c.contents[i]
+	}
+	c.contents = append(c.contents[:i], user, gemini.Content{Role: gemini.RoleModel, Parts: []gemini.Part{{Text: response}}})
+	c.pending = nil
+}
+
+func (c *GeminiClient) call(ctx context.Context, model, systemPrompt, userMessage string, tools []gemini.FunctionDeclaration) (any, error) {
 	// Store system prompt on first call
 	if c.system == "" {
 		c.system = systemPrompt
 	}
 
 	// Add user message to history
-	c.messages = append(c.messages, userMessage)
+	c.contents = append(c.contents, c.userTurn(userMessage, ToolResults(ctx)))
 
-	// Map model names if needed
-	geminiModel := model
-	switch model {
-	case "gemini":
-		geminiModel = "gemini-2.5-pro"
+	m, err := registryModel(model, models.ProviderGemini)
+	if err != nil {
+		return nil, err
 	}
+	geminiModel := m.APIModel
 
 	// Call Gemini API with all messages
 	var responseText strings.Builder
@@ -63,46 +126,64 @@ func (c *GeminiClient) CallWithStore(ctx context.Context, model, systemPrompt, u
 		if reasoning != "" {
 			reasoningText.WriteString(reasoning)
 			reasoningText.WriteString("\n")
+			currentTUI().Reasoning(reasoning)
+			currentEvents().Delta("reasoning", reasoning)
 		}
 	}
 
 	// Call Gemini with thinking enabled
-	result, err := gemini.Handle(
-		ctx,
-		geminiModel,
-		c.system,
-		c.messages,
-		[]string{},
-		reasoningCallback,
-		false,
-		32000,
-	)
+	result, err := gemini.Handle(ctx, gemini.Request{
+		Model:          geminiModel,
+		System:         c.system,
+		Contents:       c.contents,
+		Tools:          tools,
+		Temperature:    m.Temperature,
+		TopP:           m.TopP,
+		MaxOutput:      m.MaxOutput,
+		ThinkingBudget: m.ThinkingBudget,
+	}, reasoningCallback)
 	if err != nil {
+		// drop the unanswered message so a retry does not send it twice
+		c.contents = c.contents[:len(c.contents)-1]
 		return nil, err
 	}
 
-	responseText.WriteString(result)
+	responseText.WriteString(result.Text)
 
-	// Add assistant response to history
-	c.messages = append(c.messages, result)
+	// Add assistant response to history, function calls included
+	turn := gemini.Content{Role: gemini.RoleModel}
+	if result.Text != "" {
+		turn.Parts = append(turn.Parts, gemini.Part{Text: result.Text})
+	}
+	for _, call := range result.FunctionCalls {
+		turn.Parts = append(turn.Parts, gemini.Part{FunctionCall: &call})
+	}
+	if len(turn.Parts) == 0 {
+		turn.Parts = []gemini.Part{{Text: ""}}
+	}
+	c.contents = append(c.contents, turn)
+	c.pending = result.FunctionCalls
 
 	_ = reasoningText.String()
 
 	// Create response structure
 	resp := &GeminiResponse{
-		Model:      geminiModel,
-		Text:       responseText.String(),
-		Reasoning:  "",
-		InputCount: len(c.messages) - 1, // Approximate token count
+		Model:         geminiModel,
+		Text:          responseText.String(),
+		Reasoning:     "",
+		FunctionCalls: result.FunctionCalls,
+		FinishReason:  result.FinishReason,
+		ModelVersion:  result.ModelVersion,
+		Usage:         result.Usage,
 	}
 
 	// Print response without color
-	fmt.Printf("%s\n", result)
+	fmt.Printf("%s\n", result.Text)
 
 	// Log API call
 	err = c.logAPICall(model, systemPrompt, userMessage, resp)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to log API call: %v\n", err)
+		util.Errorf("Failed to log API call: %v", err)
 	}
 
 	return resp, nil
@@ -110,10 +191,13 @@ func (c *GeminiClient) CallWithStore(ctx context.Context, model, systemPrompt, u
 
 // GeminiResponse represents a response from Gemini API
 type GeminiResponse struct {
-	Model      string
-	Text       string
-	Reasoning  string
-	InputCount int // Approximate message count as we don't get token counts
+	Model         string
+	Text          string
+	Reasoning     string
+	FunctionCalls []gemini.FunctionCall
+	FinishReason  string
+	ModelVersion  string
+	Usage         gemini.Usage
 }
 
 // logAPICall logs the API request and response
@@ -124,10 +208,18 @@ func (c *GeminiClient) logAPICall(model, system, userMessage string, resp *Gemin
 	// Save input text
 	inputPath := GetTimestampedAgentsPath("text", fmt.Sprintf("%05d.input.txt", logNum))
 
+	var previous []string
+	for _, content := range c.contents[:len(c.contents)-2] {
+		for _, part := range content.Parts {
+			if part.Text != "" {
+				previous = append(previous, part.Text)
+			}
+		}
+	}
 	inputText := fmt.Sprintf("=== System ===\n%s\n\n=== User Message ===\n%s\n\n=== Previous Messages ===\n%s",
-		system, userMessage, strings.Join(c.messages[:len(c.messages)-2], "\n---\n"))
+		system, userMessage, strings.Join(previous, "\n---\n"))
 
-	if err := os.WriteFile(inputPath, []byte(inputText), 0644); err != nil {
+	if err := util.WriteLog(inputPath, []byte(inputText)); err != nil {
 		return fmt.Errorf("failed to write input text: %w", err)
 	}
 
@@ -138,71 +230,62 @@ func (c *GeminiClient) logAPICall(model, system, userMessage string, resp *Gemin
 		outputText = fmt.Sprintf("=== Reasoning ===\n%s\n\n=== Response ===\n%s", resp.Reasoning, resp.Text)
 	}
 
-	if err := os.WriteFile(outputPath, []byte(outputText), 0644); err != nil {
+	if err := util.WriteLog(outputPath, []byte(outputText)); err != nil {
 		return fmt.Errorf("failed to write output text: %w", err)
 	}
 
 	// Save request JSON
 	reqJSON := map[string]any{
-		"model":    model,
+		"model":    resp.Model,
 		"system":   system,
-		"messages": c.messages,
+		"contents": c.contents,
+	}
+	if m, err := models.Lookup(model); err == nil {
+		reqJSON["thinking_budget"] = m.ThinkingBudget
 	}
 
 	jsonPath := GetTimestampedAgentsPath("api", fmt.Sprintf("%05d.input.json", logNum))
-	if err := os.WriteFile(jsonPath, []byte(util.Pformat(reqJSON)), 0644); err != nil {
+	if err := util.WriteLog(jsonPath, []byte(util.Pformat(reqJSON))); err != nil {
 		return fmt.Errorf("failed to write API request log: %w", err)
 	}
 
 	// Save response JSON
 	respJSON := map[string]any{
-		"model":     resp.Model,
-		"text":      resp.Text,
-		"reasoning": resp.Reasoning,
+		"model":          resp.Model,
+		"text":           resp.Text,
+		"reasoning":      resp.Reasoning,
+		"function_calls": resp.FunctionCalls,
+		"finish_reason":  resp.FinishReason,
+		"model_version":  resp.ModelVersion,
+		"usage":          resp.Usage,
 	}
 
 	jsonPath = GetTimestampedAgentsPath("api", fmt.Sprintf("%05d.output.json", logNum))
-	if err := os.WriteFile(jsonPath, []byte(util.Pformat(respJSON)), 0644); err != nil {
+	if err := util.WriteLog(jsonPath, []byte(util.Pformat(respJSON))); err != nil {
 		return fmt.Errorf("failed to write API response log: %w", err)
 	}
 
 	return nil
 }
 
-// GetTokenUsage returns estimated token usage (Gemini doesn't provide exact counts)
+// GetTokenUsage returns token usage from the usageMetadata of the response
 func (c *GeminiClient) GetTokenUsage(resp any) (promptTokens, completionTokens, totalTokens int) {
-	// Estimate tokens based on message count and response length
-	// This is a rough approximation since Gemini doesn't return token counts
-	gResp := resp.(*GeminiResponse)
-
-	// Rough estimation: 1 token ~= 4 characters
-	promptTokens = len(strings.Join(c.messages, " ")) / 4
-	completionTokens = len(gResp.Text) / 4
-	totalTokens = promptTokens + completionTokens
-
-	return
+	usage := resp.(*GeminiResponse).Usage
+	return usage.PromptTokens, usage.CandidatesTokens + usage.ThoughtsTokens, usage.TotalTokens
 }
 
-// GetDetailedUsage returns detailed token usage (estimated for Gemini)
+// GetDetailedUsage returns detailed token usage, cached content is reported
+// as cache reads
 func (c *GeminiClient) GetDetailedUsage(resp any) TokenUsage {
-	prompt, completion, _ := c.GetTokenUsage(resp)
-	return TokenUsage{
-		Input:  prompt,
-		Output: completion,
-		Cache: CacheUsage{
-			Read:  0, // Gemini doesn't provide cache metrics
-			Write: 0,
-		},
-	}
+	return GeminiTokenUsage(resp.(*GeminiResponse).Usage)
 }
 
-// CompactMessages removes old messages when approaching token limit
 // CompactMessages removes old messages when approaching token limit
 func (c *GeminiClient) CompactMessages(messagePairs int) CompactionResult {
 	// Keep system prompt and remove oldest message pairs
 	toRemove := messagePairs * 2 // Each pair is user + assistant
-	if toRemove >= len(c.messages) {
-		toRemove = len(c.messages) - 2 // Keep at least the last exchange
+	if toRemove >= len(c.contents) {
+		toRemove = len(c.contents) - 2 // Keep at least the last exchange
 	}
 
 	if toRemove <= 0 {
@@ -210,11 +293,16 @@ func (c *GeminiClient) CompactMessages(messagePairs int) CompactionResult {
 	}
 
 	// Estimate tokens being removed
-	removedText := strings.Join(c.messages[:toRemove], " ")
-	tokensRemoved := len(removedText) / 4 // Rough estimate
+	var removedText strings.Builder
+	for _, content := range c.contents[:toRemove] {
+		for _, part := range content.Parts {
+			removedText.WriteString(part.Text)
+		}
+	}
+	tokensRemoved := removedText.Len() / 4 // Rough estimate
 
 	// Remove messages
-	c.messages = c.messages[toRemove:]
+	c.contents = c.contents[toRemove:]
 
 	return CompactionResult{
 		MessagesRemoved: toRemove,
@@ -237,9 +325,9 @@ func (c *GeminiClient) SupportsTools() bool {
 	return true
 }
 
-// CallWithTools calls Gemini API with tool definitions.
+// CallWithTools calls Gemini API with function declarations, tools holds
+// prompts.ToolDefinition values and defaults to the nina tools when empty.
+// Function calls are returned on the GeminiResponse.
 func (c *GeminiClient) CallWithTools(ctx context.Context, model, systemPrompt, userMessage string, tools []any) (any, error) {
-	// For now, just call the regular method
-	// In a full implementation, this would add function declarations to the request
-	return c.CallWithStore(ctx, model, systemPrompt, userMessage)
+	return c.call(ctx, model, systemPrompt, userMessage, gemini.TranslateToGeminiTools(toolDefinitions(tools)))
 }
@@ -0,0 +1,76 @@
+// Tests for the Gemini client covering function call bookkeeping in the typed
+// history, restoring logged contents, and conversion of usageMetadata into
+// TokenUsage
+package lib
+
+import (
+	"encoding/json"
+	"testing"
+
+	gemini "github.com/nathants/nina/providers/gemini"
+)
+
+func TestGeminiUserTurnAnswersFunctionCalls(t *testing.T) {
+	client := &GeminiClient{}
+	turn := client.userTurn("hello", nil)
+	if turn.Role != gemini.RoleUser || len(turn.Parts) != 1 || turn.Parts[0].Text != "hello" {
+		t.Fatalf("plain turn = %+v", turn)
+	}
+
+	client.pending = []gemini.FunctionCall{{Name: "NinaBash"}, {Name: "NinaChange"}}
+	turn = client.userTurn("results", []ToolCall{{Name: "NinaBash", Result: "ran"}})
+	if len(turn.Parts) != 3 {
+		t.Fatalf("got %d parts, want 3", len(turn.Parts))
+	}
+	for i, call := range client.pending {
+		resp := turn.Parts[i].FunctionResponse
+		if resp == nil || resp.ID != call.ID || resp.Name != call.Name {
+			t.Errorf("part %d = %+v, want response to %+v", i, turn.Parts[i], call)
+		}
+	}
+	if out := turn.Parts[0].FunctionResponse.Response["output"]; out != "ran" {
+		t.Errorf("calls without ids are answered by position, got %v", out)
+	}
+	if out := turn.Parts[1].FunctionResponse.Response["output"]; out != toolCallNotRun {
+		t.Errorf("a call without a result = %v, want %q", out, toolCallNotRun)
+	}
+	if turn.Parts[2].Text != "results" {
+		t.Errorf("last part = %+v, want the message text", turn.Parts[2])
+	}
+}
+
+func TestGeminiRestoreMessages(t *testing.T) {
+	logged, err := json.Marshal([]gemini.Content{
+		gemini.UserText("fix the bug"),
+		{Role: gemini.RoleModel, Parts: []gemini.Part{
+			{Text: "running tests"},
+			{FunctionCall: &gemini.FunctionCall{ID: "1", Name: "NinaBash", Args: map[string]any{"command": "go test"}}},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var contents []any
+	if err := json.Unmarshal(logged, &contents); err != nil {
+		t.Fatal(err)
+	}
+
+	client := &GeminiClient{}
+	if err := client.RestoreMessages("system", contents); err != nil {
+		t.Fatal(err)
+	}
+	if client.system != "system" || len(client.contents) != 2 {
+		t.Fatalf("restored system %q with %d contents", client.system, len(client.contents))
+	}
+	if len(client.pending) != 1 || client.pending[0].Name != "NinaBash" || client.pending[0].Args["command"] != "go test" {
+		t.Fatalf("pending = %+v, want the unanswered NinaBash call", client.pending)
+	}
+}
+
+func TestGeminiTokenUsage(t *testing.T) {
+	got := GeminiTokenUsage(gemini.Usage{PromptTokens: 100, CandidatesTokens: 20, ThoughtsTokens: 5, CachedTokens: 40})
+	want := TokenUsage{Input: 60, Output: 25, Cache: CacheUsage{Read: 40}}
+	if got != want {
+		t.Errorf("GeminiTokenUsage() = %+v, want %+v", got, want)
+	}
+}
@@ -6,10 +6,10 @@ package lib
 import (
 	"context"
 	"fmt"
+	"github.com/nathants/nina/models"
 	grok "github.com/nathants/nina/providers/grok"
 	util "github.com/nathants/nina/util"
 	"os"
-	"os/exec"
 	"strings"
 )
 
@@ -34,18 +34,27 @@ func NewGrokClient() (*GrokClient, error) {
 	return client, nil
 }
 
+// RestoreMessages restores conversation history from a previous session for continuation
+func (c *GrokClient) RestoreMessages(messages []any) error {
+	c.messages = []grok.Message{}
+	for _, msg := range messages {
+		role, text := loggedMessage(msg)
+		if role != "" && text != "" {
+			c.messages = append(c.messages, grok.Message{Role: role, Content: text})
+		}
+	}
+	return nil
+}
+
 // builds request with system prompt and history, sends request to Grok API
 // returns response wrapped in grok.Response, tracks conversation history
 // logs request/response to agents directory for debugging and analysis
 func (c *GrokClient) CallWithStore(ctx context.Context, model, systemPrompt, userMessage string) (any, error) {
-	_ = ctx // Context not used by current grok.Handle implementation
-	var grokModel string
-	switch model {
-	case "grok":
-		grokModel = "grok-4-0709"
-	default:
-		return nil, fmt.Errorf("unknown Grok model: %s", model)
+	m, err := registryModel(model, models.ProviderGrok)
+	if err != nil {
+		return nil, err
 	}
+	grokModel := m.APIModel
 
 	// Add system message if this is the first message
 	if len(c.messages) == 0 && systemPrompt != "" {
@@ -67,16 +76,23 @@ func (c *GrokClient) CallWithStore(ctx context.Context, model, systemPrompt, use
 	req := grok.Request{
 		Model:       grokModel,
 		Messages:    c.messages,
-		Stream:      false, // Grok streaming not implemented yet
+		Stream:      true,
 		Temperature: 0.7,
 	}
 
-	// Call Grok API
-	responseText, err := grok.Handle(ctx, req)
+	// Call Grok API, showing reasoning as it arrives
+	handleResp, err := grok.Handle(ctx, req, func(data string) {
+		currentTUI().Reasoning(data)
+		currentEvents().Delta("reasoning", data)
+	})
 	if err != nil {
+		// drop the unanswered message so a retry does not send it twice
+		c.messages = c.messages[:len(c.messages)-1]
 		return nil, err
 	}
 
+	responseText := handleResp.Text
+
 	// Create a response structure compatible with the rest of the code
 	resp := &grok.Response{
 		Model: grokModel,
@@ -84,18 +100,17 @@ func (c *GrokClient) CallWithStore(ctx context.Context, model, systemPrompt, use
 			{
 				Index: 0,
 				Message: grok.ChoiceMessage{
-					Role:    "assistant",
-					Content: responseText,
+					Role:             "assistant",
+					Content:          responseText,
+					ReasoningContent: handleResp.Reasoning,
 				},
-				FinishReason: "stop",
+				FinishReason: handleResp.FinishReason,
 			},
 		},
-		// Usage data not available from current grok.Handle
-		Usage: map[string]any{
-			"prompt_tokens":     0,
-			"completion_tokens": 0,
-			"total_tokens":      0,
-		},
+		Usage: handleResp.Usage,
+	}
+	if handleResp.Model != "" {
+		resp.Model = handleResp.Model
 	}
 
 	// Add assistant response to message history
@@ -108,7 +123,7 @@ func (c *GrokClient) CallWithStore(ctx context.Context, model, systemPrompt, use
 	// Log API call
 	err = c.logAPICall(&req, resp)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to log API call: %v\n", err)
+		util.Errorf("Failed to log API call: %v", err)
 	}
 
 	return resp, nil
@@ -133,7 +148,7 @@ func (c *GrokClient) logAPICall(req *grok.Request, resp *grok.Response) error {
 		if len(inputTexts) > 0 {
 			textPath := GetTimestampedAgentsPath("text", fmt.Sprintf("%05d.input.txt", logNum))
 			combinedText := strings.Join(inputTexts, "\n\n")
-			if err := os.WriteFile(textPath, []byte(combinedText), 0644); err != nil {
+			if err := util.WriteLog(textPath, []byte(combinedText)); err != nil {
 				return fmt.Errorf("failed to write input text: %w", err)
 			}
 		}
@@ -142,7 +157,7 @@ func (c *GrokClient) logAPICall(req *grok.Request, resp *grok.Response) error {
 	// Extract and save text content from response
 	if len(respCopy.Choices) > 0 && respCopy.Choices[0].Message.Content != "" {
 		textPath := GetTimestampedAgentsPath("text", fmt.Sprintf("%05d.output.txt", logNum))
-		if err := os.WriteFile(textPath, []byte(respCopy.Choices[0].Message.Content), 0644); err != nil {
+		if err := util.WriteLog(textPath, []byte(respCopy.Choices[0].Message.Content)); err != nil {
 			return fmt.Errorf("failed to write output text: %w", err)
 		}
 	}
@@ -150,13 +165,13 @@ func (c *GrokClient) logAPICall(req *grok.Request, resp *grok.Response) error {
 	// fmt.Println(filepath.Join("agents", "api", fmt.Sprintf("%05d.*.json", logNum)))
 
 	jsonPath := GetTimestampedAgentsPath("api", fmt.Sprintf("%05d.input.json", logNum))
-	err := os.WriteFile(jsonPath, []byte(util.Pformat(reqCopy)), 0644)
+	err := util.WriteLog(jsonPath, []byte(util.Pformat(reqCopy)))
 	if err != nil {
 		return fmt.Errorf("failed to write API log: %w", err)
 	}
 
 	jsonPath = GetTimestampedAgentsPath("api", fmt.Sprintf("%05d.output.json", logNum))
-	err = os.WriteFile(jsonPath, []byte(util.Pformat(respCopy)), 0644)
+	err = util.WriteLog(jsonPath, []byte(util.Pformat(respCopy)))
 	if err != nil {
 		return fmt.Errorf("failed to write API log: %w", err)
 	}
@@ -167,41 +182,16 @@ func (c *GrokClient) logAPICall(req *grok.Request, resp *grok.Response) error {
 // GetTokenUsage returns the token usage from the last response
 func (c *GrokClient) GetTokenUsage(resp any) (promptTokens, completionTokens, totalTokens int) {
 	gResp := resp.(*grok.Response)
-	if usage, ok := gResp.Usage.(map[string]any); ok {
-		if v, ok := usage["prompt_tokens"].(float64); ok {
-			promptTokens = int(v)
-		}
-		if v, ok := usage["completion_tokens"].(float64); ok {
-			completionTokens = int(v)
-		}
-		if v, ok := usage["total_tokens"].(float64); ok {
-			totalTokens = int(v)
-		}
+	if gResp.Usage != nil {
+		return gResp.Usage.PromptTokens, gResp.Usage.CompletionTokens, gResp.Usage.TotalTokens
 	}
 	return
 }
 
-// GetDetailedUsage returns detailed token usage from Grok API responses
-// Grok doesn't provide cache tokens, so cache fields are always zero
+// GetDetailedUsage returns detailed token usage from Grok API responses,
+// cached prompt tokens are reported as cache reads
 func (c *GrokClient) GetDetailedUsage(resp any) TokenUsage {
-	gResp := resp.(*grok.Response)
-	usage := TokenUsage{
-		Cache: CacheUsage{
-			Read:  0, // Grok doesn't provide cache metrics
-			Write: 0,
-		},
-	}
-
-	if respUsage, ok := gResp.Usage.(map[string]any); ok {
-		if v, ok := respUsage["prompt_tokens"].(float64); ok {
-			usage.Input = int(v)
-		}
-		if v, ok := respUsage["completion_tokens"].(float64); ok {
-			usage.Output = int(v)
-		}
-	}
-
-	return usage
+	return GrokTokenUsage(resp.(*grok.Response).Usage)
 }
 
 // GetGrokResponseText extracts the text content from a Grok response
@@ -212,6 +202,17 @@ func GetGrokResponseText(resp *grok.Response) string {
 	return ""
 }
 
+// RecordTurn records a response from another model, see turnRecorder
+func (c *GrokClient) RecordTurn(userMessage, response string) {
+	i := turnStart(len(c.messages), func(i int) (string, string) {
+		return c.messages[i].Role, c.messages[i].Content
+	}, userMessage)
+	c.messages = append(c.messages[:i],
+		grok.Message{Role: "user", Content: userMessage},
+		grok.Message{Role: "assistant", Content: response},
+	)
+}
+
 // CompactMessages removes old messages keeping only recent pairs when approaching token limit.
 // Keeps the most recent messagePairs (user+assistant pairs), preserving conversation context.
 func (c *GrokClient) CompactMessages(messagePairs int) CompactionResult {
@@ -254,25 +255,7 @@ func (c *GrokClient) CompactMessages(messagePairs int) CompactionResult {
 	// Count tokens in messages to be removed
 	tokensRemoved := 0
 	for i := range removeCount {
-		message := c.messages[startIdx+i]
-		// Use tokens utility to count tokens
-		cmd := exec.Command("tokens")
-		cmd.Stdin = strings.NewReader(message.Content)
-		output, err := cmd.Output()
-		if err != nil {
-			// Log error but continue with zero tokens for this message
-			fmt.Fprintf(os.Stderr, "Warning: Failed to count tokens for message %d: %v\n", startIdx+i, err)
-		} else {
-			tokens := 0
-			_, _ = fmt.Sscanf(string(output), "%d", &tokens)
-			tokensRemoved += tokens
-		}
-
-		// Fallback estimation if tokens command failed or returned 0
-		if tokensRemoved == 0 && len(message.Content) > 0 {
-			// Rough estimation: ~4 characters per token
-			tokensRemoved += len(message.Content) / 4
-		}
+		tokensRemoved += countProviderTokens(models.ProviderGrok, c.messages[startIdx+i].Content)
 	}
 
 	// Keep system message (if any) and recent messages
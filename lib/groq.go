@@ -7,10 +7,10 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"github.com/nathants/nina/models"
 	groq "github.com/nathants/nina/providers/groq"
 	util "github.com/nathants/nina/util"
 	"os"
-	"path/filepath"
 	"strings"
 )
 
@@ -39,17 +39,38 @@ func NewGroqClient() (*GroqClient, error) {
 	return client, nil
 }
 
+// RestoreMessages restores conversation history from a previous session for continuation
+func (c *GroqClient) RestoreMessages(messages []any) error {
+	c.messages = []groq.Message{}
+	for _, msg := range messages {
+		role, text := loggedMessage(msg)
+		if role != "" && text != "" {
+			c.messages = append(c.messages, groq.Message{Role: role, Content: text})
+		}
+	}
+	return nil
+}
+
+// loggedMessage returns the role and text of a logged chat message
+func loggedMessage(msg any) (role, text string) {
+	msgMap, ok := msg.(map[string]any)
+	if !ok {
+		return "", ""
+	}
+	role, _ = msgMap["role"].(string)
+	text, _ = msgMap["content"].(string)
+	return role, text
+}
+
 // builds request with system prompt and history, sends request to Groq API
 // returns response wrapped in groq.HandleResponse, tracks conversation history
 // logs request/response to agents directory for debugging and analysis
 func (c *GroqClient) CallWithStore(ctx context.Context, model, systemPrompt, userMessage string) (any, error) {
-	var groqModel string
-	switch model {
-	case "k2":
-		groqModel = "moonshotai/kimi-k2-instruct"
-	default:
-		return nil, fmt.Errorf("unknown Groq model: %s", model)
+	m, err := registryModel(model, models.ProviderGroq)
+	if err != nil {
+		return nil, err
 	}
+	groqModel := m.APIModel
 
 	// Add system message if this is the first message
 	if len(c.messages) == 0 && systemPrompt != "" {
@@ -71,18 +92,24 @@ func (c *GroqClient) CallWithStore(ctx context.Context, model, systemPrompt, use
 	request := groq.Request{
 		Model:    groqModel,
 		Messages: c.messages,
-		Stream:   false,
+		Stream:   true,
 	}
 
 	// Log request
-	logFile := GetTimestampedAgentsPath("api", fmt.Sprintf("%d.input.json", getMessageCount()+1))
+	logNum := GetNextAPILogNumber()
+	logFile := GetTimestampedAgentsPath("api", fmt.Sprintf("%05d.input.json", logNum))
 	if err := logRequest(logFile, request); err != nil {
-		ColoredStderr("Failed to log request: %v", err)
+		util.Errorf("Failed to log request: %v", err)
 	}
 
-	// Call Groq API with context
-	response, err := groq.Handle(ctx, request)
+	// Call Groq API with context, showing reasoning as it arrives
+	response, err := groq.Handle(ctx, request, func(data string) {
+		currentTUI().Reasoning(data)
+		currentEvents().Delta("reasoning", data)
+	})
 	if err != nil {
+		// drop the unanswered message so a retry does not send it twice
+		c.messages = c.messages[:len(c.messages)-1]
 		return nil, fmt.Errorf("groq API error: %w", err)
 	}
 
@@ -96,13 +123,13 @@ func (c *GroqClient) CallWithStore(ctx context.Context, model, systemPrompt, use
 	}
 
 	// Log response
-	logFile = GetTimestampedAgentsPath("api", fmt.Sprintf("%d.output.json", getMessageCount()))
+	logFile = GetTimestampedAgentsPath("api", fmt.Sprintf("%05d.output.json", logNum))
 	if err := logResponse(logFile, response); err != nil {
-		ColoredStderr("Failed to log response: %v", err)
+		util.Errorf("Failed to log response: %v", err)
 	}
 
 	// Log text format
-	logTextConversation(c.messages)
+	logTextConversation(logNum, c.messages)
 
 	return response, nil
 }
@@ -117,15 +144,21 @@ func (c *GroqClient) GetTokenUsage(resp any) (int, int, int) {
 
 // GetDetailedUsage returns detailed token usage for the response
 func (c *GroqClient) GetDetailedUsage(resp any) TokenUsage {
-	usage := TokenUsage{}
-	if r, ok := resp.(*groq.HandleResponse); ok && r.Usage != nil {
-		usage.Input = r.Usage.PromptTokens
-		usage.Output = r.Usage.CompletionTokens
-		// Groq doesn't provide cache metrics currently
-		usage.Cache.Read = 0
-		usage.Cache.Write = 0
+	if r, ok := resp.(*groq.HandleResponse); ok {
+		return GroqTokenUsage(r.Usage)
 	}
-	return usage
+	return TokenUsage{}
+}
+
+// RecordTurn records a response from another model, see turnRecorder
+func (c *GroqClient) RecordTurn(userMessage, response string) {
+	i := turnStart(len(c.messages), func(i int) (string, string) {
+		return c.messages[i].Role, c.messages[i].Content
+	}, userMessage)
+	c.messages = append(c.messages[:i],
+		groq.Message{Role: "user", Content: userMessage},
+		groq.Message{Role: "assistant", Content: response},
+	)
 }
 
 // CompactMessages removes old messages keeping recent context
@@ -153,24 +186,13 @@ func (c *GroqClient) CompactMessages(keepRecentPairs int) CompactionResult {
 	return result
 }
 
-// helper function to get message count for logging
-func getMessageCount() int {
-	agentsDir := util.GetAgentsDir()
-	pattern := filepath.Join(agentsDir, "api", "*.input.json")
-	files, err := filepath.Glob(pattern)
-	if err != nil {
-		return 0
-	}
-	return len(files)
-}
-
 // helper function to log request to file
 func logRequest(filename string, request groq.Request) error {
 	data, err := json.MarshalIndent(request, "", "  ")
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(filename, data, 0644)
+	return util.WriteLog(filename, data)
 }
 
 // helper function to log response to file
@@ -179,11 +201,11 @@ func logResponse(filename string, response *groq.HandleResponse) error {
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(filename, data, 0644)
+	return util.WriteLog(filename, data)
 }
 
 // helper function to log text format conversation
-func logTextConversation(messages []groq.Message) {
+func logTextConversation(logNum int, messages []groq.Message) {
 	var content strings.Builder
 	for _, msg := range messages {
 		switch msg.Role {
@@ -200,9 +222,9 @@ func logTextConversation(messages []groq.Message) {
 		}
 	}
 
-	logFile := GetTimestampedAgentsPath("text", fmt.Sprintf("%d.txt", getMessageCount()))
-	if err := os.WriteFile(logFile, []byte(content.String()), 0644); err != nil {
-		ColoredStderr("Failed to write text log: %v", err)
+	logFile := GetTimestampedAgentsPath("text", fmt.Sprintf("%05d.txt", logNum))
+	if err := util.WriteLog(logFile, []byte(content.String())); err != nil {
+		util.Errorf("Failed to write text log: %v", err)
 	}
 }
 
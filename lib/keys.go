@@ -0,0 +1,155 @@
+// Key by key steering input for a terminal. StartSteering puts a terminal on
+// stdin in cbreak mode, without echo, and edits the steering line itself: the
+// typed line is echoed to stderr, or shown on the last row of the tui so the
+// view is not scribbled over, and enter sends it. On an empty line p, s, and
+// q are key bindings for the controls in control.go, without enter, so a
+// message starting with one of them is typed after a space. Ctrl-C and
+// Ctrl-\ restore the terminal before signaling nina as they would in cooked
+// mode, and StopSteering or Fatal restore it on exit.
+package lib
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"unicode"
+)
+
+var (
+	ttyMu      sync.Mutex
+	ttyRestore func()
+)
+
+// keyLine is the steering line being typed
+type keyLine struct {
+	runes  []rune
+	escape int // 1 after ESC, 2 inside a CSI or SS3 sequence being skipped
+}
+
+// press handles one key, returning the line when enter completes it, or a
+// control key pressed on an empty line, and the text to echo for the key.
+// Escape sequences like arrow keys are ignored.
+func (k *keyLine) press(r rune) (line string, done bool, echo string) {
+	switch {
+	case k.escape == 1:
+		k.escape = 0
+		if r == '[' || r == 'O' {
+			k.escape = 2
+		}
+		return "", false, ""
+	case k.escape == 2:
+		if r >= '@' && r <= '~' {
+			k.escape = 0
+		}
+		return "", false, ""
+	}
+	switch r {
+	case 'p', 's', 'q':
+		if len(k.runes) == 0 {
+			return string(r), true, ""
+		}
+	case '\r', '\n':
+		line = string(k.runes)
+		k.runes = nil
+		return line, true, "\n"
+	case 0x7f, '\b':
+		if len(k.runes) == 0 {
+			return "", false, ""
+		}
+		k.runes = k.runes[:len(k.runes)-1]
+		return "", false, "\b \b"
+	case 0x15: // ctrl-u clears the line
+		echo = strings.Repeat("\b \b", len(k.runes))
+		k.runes = nil
+		return "", false, echo
+	case 0x1b:
+		k.escape = 1
+		return "", false, ""
+	}
+	if !unicode.IsPrint(r) {
+		return "", false, ""
+	}
+	k.runes = append(k.runes, r)
+	return "", false, string(r)
+}
+
+// text returns the line typed so far
+func (k *keyLine) text() string {
+	return string(k.runes)
+}
+
+// readKeys edits steering lines from r key by key until it ends, running or
+// queuing each completed line like StartSteering
+func readKeys(r io.Reader, echo io.Writer) {
+	reader := bufio.NewReader(r)
+	var line keyLine
+	for {
+		key, _, err := reader.ReadRune()
+		if err != nil {
+			return
+		}
+		switch key {
+		case 0x03:
+			restoreTerminal()
+			raise(syscall.SIGINT)
+			return
+		case 0x1c:
+			restoreTerminal()
+			raise(syscall.SIGQUIT)
+			return
+		case 0x04:
+			// ctrl-d ends steering like the end of piped input
+			restoreTerminal()
+			return
+		}
+		text, done, out := line.press(key)
+		if tui := currentTUI(); tui != nil {
+			tui.SetInput(line.text())
+		} else if out != "" {
+			_, _ = io.WriteString(echo, out)
+		}
+		if done && !handleControl(text) {
+			Steer(text)
+		}
+	}
+}
+
+// startKeySteering reads steering keys from a terminal in cbreak mode, it
+// reports false when the terminal could not be switched
+func startKeySteering(f *os.File) bool {
+	restore, err := cbreak(f)
+	if err != nil {
+		return false
+	}
+	ttyMu.Lock()
+	ttyRestore = restore
+	ttyMu.Unlock()
+	go readKeys(f, os.Stderr)
+	return true
+}
+
+// keySteering reports whether steering keys are being read from a terminal
+func keySteering() bool {
+	ttyMu.Lock()
+	defer ttyMu.Unlock()
+	return ttyRestore != nil
+}
+
+// restoreTerminal returns a terminal in cbreak mode to the mode it had
+func restoreTerminal() {
+	ttyMu.Lock()
+	restore := ttyRestore
+	ttyRestore = nil
+	ttyMu.Unlock()
+	if restore != nil {
+		restore()
+	}
+}
+
+// StopSteering restores the terminal used for steering input
+func StopSteering() {
+	restoreTerminal()
+}
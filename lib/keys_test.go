@@ -0,0 +1,62 @@
+package lib
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// fakeKeySteering marks steering keys as read from a terminal for a test
+func fakeKeySteering(t *testing.T) {
+	ttyMu.Lock()
+	ttyRestore = func() {}
+	ttyMu.Unlock()
+	t.Cleanup(restoreTerminal)
+}
+
+func TestKeyLine(t *testing.T) {
+	var line keyLine
+	var echo strings.Builder
+	var done []string
+	for _, key := range "fix\x7f\x7fun\x1b[Dc\x1bOA\x01\r\x15ab\x15\rs please\r" {
+		text, ok, out := line.press(key)
+		echo.WriteString(out)
+		if ok {
+			done = append(done, text)
+		}
+	}
+	if !slices.Equal(done, []string{"func", "", "s", " please"}) {
+		t.Errorf("lines = %q, want func, an empty line, the s key, and a message after a space", done)
+	}
+	if want := "fix\b \b\b \bunc\nab\b \b\b \b\n please\n"; echo.String() != want {
+		t.Errorf("echo = %q, want %q", echo.String(), want)
+	}
+	if line.text() != "" {
+		t.Errorf("text() = %q after enter", line.text())
+	}
+}
+
+func TestReadKeys(t *testing.T) {
+	resetControl(t)
+	steerMu.Lock()
+	steerQueue = nil
+	steerMu.Unlock()
+	t.Cleanup(func() { _ = TakeSuggestions() })
+	t.Chdir(t.TempDir())
+
+	var echo bytes.Buffer
+	readKeys(strings.NewReader("prun the tests\r\x04ignored\r"), &echo)
+	control.mu.Lock()
+	paused := control.paused
+	control.mu.Unlock()
+	if !paused {
+		t.Error("the p key did not pause")
+	}
+	if got := TakeSuggestions(); got != "run the tests" {
+		t.Errorf("queued %q, want the line before ctrl-d", got)
+	}
+	if !strings.Contains(echo.String(), "run the tests\n") {
+		t.Errorf("echo = %q", echo.String())
+	}
+}
@@ -0,0 +1,35 @@
+//go:build !windows
+
+package lib
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// cbreak switches the terminal f to read single keys without echo or signal
+// keys, returning a function restoring its previous mode
+func cbreak(f *os.File) (func(), error) {
+	saved, err := stty(f, "-g")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := stty(f, "-icanon", "-echo", "-isig", "min", "1", "time", "0"); err != nil {
+		return nil, err
+	}
+	return func() { _, _ = stty(f, strings.TrimSpace(saved)) }, nil
+}
+
+func stty(f *os.File, args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = f
+	out, err := cmd.Output()
+	return string(out), err
+}
+
+// raise sends sig to nina itself
+func raise(sig syscall.Signal) {
+	_ = syscall.Kill(os.Getpid(), sig)
+}
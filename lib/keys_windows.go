@@ -0,0 +1,19 @@
+//go:build windows
+
+package lib
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// cbreak is not supported, steering reads lines instead
+func cbreak(*os.File) (func(), error) {
+	return nil, errors.New("cbreak mode is not supported on windows")
+}
+
+// raise exits like an interrupted process, windows cannot signal itself
+func raise(syscall.Signal) {
+	os.Exit(130)
+}
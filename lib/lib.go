@@ -7,6 +7,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"github.com/nathants/nina/models"
 	"github.com/nathants/nina/prompts"
 	"github.com/nathants/nina/providers/claude"
 	"github.com/nathants/nina/providers/gemini"
@@ -86,6 +87,7 @@ func InitializeSession(continueSession bool) {
 		// New session
 		sessionTimestamp = time.Now().Format("20060102-150405")
 		atomic.StoreInt64(&logNumber, 0)
+		applyRetentionFromEnv()
 	})
 }
 
@@ -94,6 +96,12 @@ func GetNextAPILogNumber() int {
 	return int(atomic.AddInt64(&logNumber, 1))
 }
 
+// CurrentAPILogNumber returns the log number of the last api call of the
+// current session
+func CurrentAPILogNumber() int {
+	return int(atomic.LoadInt64(&logNumber))
+}
+
 // GetSessionTimestamp returns the current session timestamp
 func GetSessionTimestamp() string {
 	InitializeSession(false) // Ensure initialization
@@ -108,6 +116,29 @@ func GetTimestampedAgentsPath(subdir string, filename string) string {
 	return filepath.Join(agentsDir, filename)
 }
 
+// WriteModelSettings records the model and reasoning settings a session ran
+// with to path
+func WriteModelSettings(path string, m models.Model) error {
+	settings := map[string]any{
+		"model":     m.Alias,
+		"provider":  m.Provider,
+		"api_model": m.APIModel,
+	}
+	if m.Effort != "" {
+		settings["effort"] = m.Effort
+	}
+	if m.ThinkingBudget != 0 {
+		settings["thinking_budget"] = m.ThinkingBudget
+	}
+	if m.Temperature != nil {
+		settings["temperature"] = *m.Temperature
+	}
+	if m.ServiceTier != "" {
+		settings["service_tier"] = m.ServiceTier
+	}
+	return util.WriteLog(path, []byte(util.Pformat(settings)))
+}
+
 // FormatNumberK formats numbers with k suffix for thousands (e.g. 8226 -> 8k)
 // numbers under 1000 are shown as-is, numbers >= 1000 use k suffix
 func FormatNumberK(n int) string {
@@ -119,9 +150,12 @@ func FormatNumberK(n int) string {
 
 
 // ConvertToRangeUpdates converts search/replace updates to range-based updates by using AI to find
-// exact line numbers for search text. Processes updates in parallel (max 5 concurrent) and converts
-// each update by sending file content + search text to AI which returns start/end line numbers.
-// Range updates are passed through unchanged. Returns converted updates or error if any fail.
+// exact line numbers for search text. Exact unique matches are resolved locally without AI, and
+// with NINA_CONVERTER_MODEL=local anything else is an error. Cached ranges keyed by (file hash,
+// search text) are reused, multiple searches in the same file are converted together in one call,
+// and anything left is converted individually in parallel by sending file content + search text to
+// AI which returns start/end line numbers. Range updates are passed through unchanged. Returns
+// converted updates or error if any fail.
 func ConvertToRangeUpdates(ctx context.Context, updates []util.FileUpdate, session *util.SessionState, reasoningCallback func(string)) ([]util.FileUpdate, error) {
 	// Load the converter prompt
 
@@ -140,7 +174,11 @@ func ConvertToRangeUpdates(ctx context.Context, updates []util.FileUpdate, sessi
 
 	sem := make(chan error, 10)
 
-	// Process each update in parallel
+	// Resolve file content for each update needing conversion, serving cache hits directly
+	contents := map[int]string{}
+	searchTexts := map[int]string{}
+	var pathOrder []string
+	pending := map[string][]int{}
 	for i, update := range updates {
 		// Skip range updates (already converted)
 		if update.StartLine > 0 || update.EndLine > 0 {
@@ -154,159 +192,121 @@ func ConvertToRangeUpdates(ctx context.Context, updates []util.FileUpdate, sessi
 			continue
 		}
 
-		wg.Add(1)
+		// Get the file content
+		path, ok := session.ResolvePath(update.FileName)
+		if !ok {
+			convertErrors = append(convertErrors, fmt.Errorf("missing pathMap for file: %s", update.FileName))
+			continue
+		}
+
+		// Use SelectedFiles which have line numbers - this is what the AI saw
+		content, ok := session.SelectedFiles[path]
+		if !ok {
+			content, ok = session.OrigFiles[path]
+			content = util.AddLineNumbers(content)
+			if !ok {
+				convertErrors = append(convertErrors, fmt.Errorf("file not found in session: %s", path))
+				continue
+			}
+		}
+
+		searchText := strings.Join(util.TrimBlankLines(update.SearchLines), "\n")
+		if cached, ok := loadConvertCache(content, searchText); ok && validRange(cached, searchText) {
+			convertedUpdates[i] = rangeUpdate(update, cached)
+			continue
+		}
+
+		// An exact unique match needs no AI
+		found, err := util.FindSearchRange(content, update.SearchLines)
+		if err == nil {
+			recordConversion(update.FileName, content, searchText, ConvertExact, found, nil)
+			convertedUpdates[i] = rangeUpdate(update, found)
+			continue
+		}
+		if ConverterModel() == ConverterLocal {
+			err = fmt.Errorf("local converter failed for %s: %v", update.FileName, err)
+			recordConversion(update.FileName, content, searchText, ConvertExact, util.RangeResult{}, err)
+			convertErrors = append(convertErrors, err)
+			continue
+		}
+
+		contents[i] = content
+		searchTexts[i] = searchText
+		if _, ok := pending[path]; !ok {
+			pathOrder = append(pathOrder, path)
+		}
+		pending[path] = append(pending[path], i)
+	}
+	if len(convertErrors) > 0 {
+		return nil, convertErrors[0]
+	}
 
-		go func(idx int, upd util.FileUpdate) {
+	// Convert files with several searches in one call each, leaving misses pending
+	var batchMutex sync.Mutex
+	for _, path := range pathOrder {
+		idxs := pending[path]
+		if len(idxs) < 2 {
+			continue
+		}
+		wg.Add(1)
+		go func(path string, idxs []int) {
 			defer util.LogRecover()
 			defer wg.Done()
 
-			// Acquire semaphore
 			sem <- nil
 			defer func() { <-sem }()
 
-			// Get the file content
-			path, ok := session.PathMap[upd.FileName]
-			if !ok && len(upd.SearchLines) != 0 {
-				errMutex.Lock()
-				convertErrors = append(convertErrors, fmt.Errorf("missing pathMap for file: %s", upd.FileName))
-				errMutex.Unlock()
-				return
+			var searches []string
+			for _, idx := range idxs {
+				searches = append(searches, searchTexts[idx])
 			}
-
-			// Use SelectedFiles which have line numbers - this is what the AI saw
-			content, ok := session.SelectedFiles[path]
-			if !ok {
-				content, ok = session.OrigFiles[path]
-				content = util.AddLineNumbers(content)
+			results, err := convertBatch(ctx, contents[idxs[0]], searches, reasoningCallback)
+			if err != nil {
+				return // fall back to individual conversion
+			}
+			var remaining []int
+			for n, idx := range idxs {
+				result, ok := results[n]
 				if !ok {
-					errMutex.Lock()
-					convertErrors = append(convertErrors, fmt.Errorf("file not found in session: %s", path))
-					errMutex.Unlock()
-					return
+					remaining = append(remaining, idx)
+					continue
 				}
+				saveConvertCache(contents[idx], searchTexts[idx], result)
+				recordConversion(updates[idx].FileName, contents[idx], searchTexts[idx], ConvertBatch, result, nil)
+				convertedUpdates[idx] = rangeUpdate(updates[idx], result)
 			}
+			batchMutex.Lock()
+			pending[path] = remaining
+			batchMutex.Unlock()
+		}(path, idxs)
+	}
+	wg.Wait()
 
-			// Try conversion with retry on line range mismatch
-			maxAttempts := 2
-			var lastError error
-
-			searchText := strings.Join(util.TrimBlankLines(upd.SearchLines), "\n")
-
-			for attempt := 1; attempt <= maxAttempts; attempt++ {
-				var b strings.Builder
-				b.WriteString("\n\n# Input data\n\n")
-
-				// If this is a retry due to line range mismatch, include the error history
-				if attempt > 1 && lastError != nil {
-					fmt.Println("attempt", attempt)
-					b.WriteString("<NinaHistory>\n")
-					b.WriteString(lastError.Error())
-					b.WriteString("\n</NinaHistory>\n\n")
-				}
-
-				b.WriteString("<NinaFile>\n")
-				b.WriteString(strings.TrimSpace(content))
-				b.WriteString("\n</NinaFile>\n")
-				b.WriteString("\n\n")
-				b.WriteString("<NinaSearch>\n")
-				b.WriteString(searchText)
-				b.WriteString("\n</NinaSearch>\n")
-
-				converterMessage := b.String()
-
-				// Save initial input when retry happens (attempt 2)
-				if attempt == 2 {
-					errorDir := util.GetAgentsSubdir("edit-errors")
-					_ = os.MkdirAll(errorDir, 0755)
-					errorNum := GetNextAPILogNumber()
-					errorPath := filepath.Join(errorDir, fmt.Sprintf("%05d.txt", errorNum))
-					_ = os.WriteFile(errorPath, []byte(converterMessage), 0644)
-				}
-
-				converterReq := AiRequest{
-					// Model: "o3",
-					// Model:  "o4-mini",
-					Model: "sonnet",
-					// Model: "gemini-2.5-pro",
-					// Effort: "medium",
-					System:  converterPrompt,
-					Message: converterMessage,
-				}
+	// Process each remaining update in parallel
+	for _, path := range pathOrder {
+		for _, i := range pending[path] {
+			wg.Add(1)
 
-				if attempt > 1 {
-					converterReq.ThinkingBudget = 22000
-				}
+			go func(idx int, upd util.FileUpdate) {
+				defer util.LogRecover()
+				defer wg.Done()
 
-				output, err := generateResponse(ctx, converterReq, reasoningCallback)
-				if err != nil {
-					errMutex.Lock()
-					convertErrors = append(convertErrors, fmt.Errorf("converter error for %s: %v", upd.FileName, err))
-					errMutex.Unlock()
-					return
-				}
+				// Acquire semaphore
+				sem <- nil
+				defer func() { <-sem }()
 
-				// Parse the single line JSON output
-				output = strings.TrimSpace(output)
-				// Remove markdown code fences if present
-				output = util.RemoveFencedBlocks(output)
-				var rangeResult util.RangeResult
-				err = json.Unmarshal([]byte(output), &rangeResult)
+				result, err := convertSingleUpdate(ctx, converterPrompt, idx, upd, contents[idx], searchTexts[idx], reasoningCallback)
+				recordConversion(upd.FileName, contents[idx], searchTexts[idx], ConvertModel, result, err)
 				if err != nil {
-					if attempt < maxAttempts {
-						continue
-					}
-					errMutex.Lock()
-					convertErrors = append(convertErrors, fmt.Errorf("failed to parse converter output for %s: %v", upd.FileName, err))
-					errMutex.Unlock()
-					return
-				}
-
-				// Check if converter indicated an error with -1 values
-				if rangeResult.Start == -1 || rangeResult.End == -1 {
-					if attempt < maxAttempts {
-						continue
-					}
-					errMutex.Lock()
-					convertErrors = append(convertErrors, fmt.Errorf("converter could not find search text in file %s (idx=%d)", upd.FileName, idx))
-					errMutex.Unlock()
-					return
-				}
-
-				// Calculate line range and search line count for validation
-				lineRange := rangeResult.End - rangeResult.Start + 1
-				searchLineCount := len(strings.Split(searchText, "\n"))
-
-				// Validate that line range matches search line count
-				if lineRange != searchLineCount {
-					// Store the error for potential retry
-
-					lastError = fmt.Errorf("line range mismatch for %s (idx=%d): range is %d lines (end:%d - start:%d) but search has %d lines\nsearch: %s\nreplace: %s",
-						upd.FileName, idx, lineRange, rangeResult.End, rangeResult.Start, searchLineCount, util.Pformat(upd.SearchLines), util.Pformat(upd.ReplaceLines))
-
-					// If this is not the last attempt, retry
-					if attempt < maxAttempts {
-						// fmt.Printf("Retrying converter due to line range mismatch (attempt %d/%d): %v\n", attempt, maxAttempts, lastError)
-						continue
-					}
-
-					// Final attempt failed, record the error
 					errMutex.Lock()
-					convertErrors = append(convertErrors, lastError)
+					convertErrors = append(convertErrors, err)
 					errMutex.Unlock()
 					return
 				}
-
-				// Success! Create the converted update
-				convertedUpdate := util.FileUpdate{
-					FileName:     upd.FileName,
-					ReplaceLines: upd.ReplaceLines,
-					StartLine:    rangeResult.Start,
-					EndLine:      rangeResult.End,
-				}
-				convertedUpdates[idx] = convertedUpdate
-				break // Success, exit retry loop
-			}
-		}(i, update)
+				saveConvertCache(contents[idx], searchTexts[idx], result)
+				convertedUpdates[idx] = rangeUpdate(upd, result)
+			}(i, updates[i])
+		}
 	}
 
 	// Wait for all goroutines to complete
@@ -322,6 +322,118 @@ func ConvertToRangeUpdates(ctx context.Context, updates []util.FileUpdate, sessi
 	return convertedUpdates, nil
 }
 
+// rangeUpdate builds the converted range update for a search/replace update
+func rangeUpdate(upd util.FileUpdate, result util.RangeResult) util.FileUpdate {
+	return util.FileUpdate{
+		FileName:     upd.FileName,
+		ReplaceLines: upd.ReplaceLines,
+		StartLine:    result.Start,
+		EndLine:      result.End,
+	}
+}
+
+// convertSingleUpdate finds the line range of one search block, retrying once
+// with error history and extended thinking on a line range mismatch
+func convertSingleUpdate(ctx context.Context, converterPrompt string, idx int, upd util.FileUpdate, content, searchText string, reasoningCallback func(string)) (util.RangeResult, error) {
+	// Try conversion with retry on line range mismatch
+	maxAttempts := 2
+	var lastError error
+
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		var b strings.Builder
+		b.WriteString("\n\n# Input data\n\n")
+
+		// If this is a retry due to line range mismatch, include the error history
+		if attempt > 1 && lastError != nil {
+			util.Verbosef("converter retry attempt %d for %s", attempt, upd.FileName)
+			b.WriteString("<NinaHistory>\n")
+			b.WriteString(lastError.Error())
+			b.WriteString("\n</NinaHistory>\n\n")
+		}
+
+		b.WriteString("<NinaFile>\n")
+		b.WriteString(strings.TrimSpace(content))
+		b.WriteString("\n</NinaFile>\n")
+		b.WriteString("\n\n")
+		b.WriteString("<NinaSearch>\n")
+		b.WriteString(searchText)
+		b.WriteString("\n</NinaSearch>\n")
+
+		converterMessage := b.String()
+
+		// Save initial input when retry happens (attempt 2)
+		if attempt == 2 {
+			errorDir := util.GetAgentsSubdir("edit-errors")
+			_ = os.MkdirAll(errorDir, 0755)
+			errorNum := GetNextAPILogNumber()
+			errorPath := filepath.Join(errorDir, fmt.Sprintf("%05d.txt", errorNum))
+			_ = util.WriteLog(errorPath, []byte(converterMessage))
+		}
+
+		converterReq := AiRequest{
+			Model: ConverterModel(),
+			// Effort: "medium",
+			System:  converterPrompt,
+			Message: converterMessage,
+		}
+
+		if attempt > 1 {
+			converterReq.ThinkingBudget = 22000
+		}
+
+		output, err := converterResponse(ctx, converterReq, reasoningCallback)
+		if err != nil {
+			return util.RangeResult{}, fmt.Errorf("converter error for %s: %v", upd.FileName, err)
+		}
+
+		// Parse the single line JSON output
+		output = strings.TrimSpace(output)
+		// Remove markdown code fences if present
+		output = util.RemoveFencedBlocks(output)
+		var rangeResult util.RangeResult
+		err = json.Unmarshal([]byte(output), &rangeResult)
+		if err != nil {
+			if attempt < maxAttempts {
+				continue
+			}
+			return util.RangeResult{}, fmt.Errorf("failed to parse converter output for %s: %v", upd.FileName, err)
+		}
+
+		// Check if converter indicated an error with -1 values
+		if rangeResult.Start == -1 || rangeResult.End == -1 {
+			if attempt < maxAttempts {
+				continue
+			}
+			return util.RangeResult{}, fmt.Errorf("converter could not find search text in file %s (idx=%d)", upd.FileName, idx)
+		}
+
+		// Calculate line range and search line count for validation
+		lineRange := rangeResult.End - rangeResult.Start + 1
+		searchLineCount := len(strings.Split(searchText, "\n"))
+
+		// Validate that line range matches search line count
+		if lineRange != searchLineCount {
+			// Store the error for potential retry
+
+			lastError = fmt.Errorf("line range mismatch for %s (idx=%d): range is %d lines (end:%d - start:%d) but search has %d lines\nsearch: %s\nreplace: %s",
+				upd.FileName, idx, lineRange, rangeResult.End, rangeResult.Start, searchLineCount, util.Pformat(upd.SearchLines), util.Pformat(upd.ReplaceLines))
+
+			// If this is not the last attempt, retry
+			if attempt < maxAttempts {
+				// fmt.Printf("Retrying converter due to line range mismatch (attempt %d/%d): %v\n", attempt, maxAttempts, lastError)
+				continue
+			}
+
+			// Final attempt failed, record the error
+			return util.RangeResult{}, lastError
+		}
+
+		// Success!
+		return rangeResult, nil
+	}
+	return util.RangeResult{}, lastError
+}
+
 // PrepareAndValidateUpdates takes raw updates, converts them to range updates, validates them,
 // and returns them organized and ready for application. This is used by both HandleChat and cmd/apply.
 func PrepareAndValidateUpdates(ctx context.Context, updates []util.FileUpdate, session *util.SessionState, reasoningCallback func(string)) ([]util.FileUpdate, error) {
@@ -397,35 +509,31 @@ func generateResponse(ctx context.Context, req AiRequest, reasoningCallback func
 	systemPrompt := req.System
 
 	if strings.HasPrefix(req.Model, "gemini-") {
-		msg, err := gemini.Handle(
-			ctx,
-			req.Model,
-			systemPrompt,
-			[]string{req.Message},
-			nil,
-			reasoningCallback,
-			req.NoThoughts,
-			req.ThinkingBudget,
-		)
+		resp, err := gemini.Handle(ctx, gemini.Request{
+			Model:          req.Model,
+			System:         systemPrompt,
+			Contents:       []gemini.Content{gemini.UserText(req.Message)},
+			ThinkingBudget: req.ThinkingBudget,
+			NoThoughts:     req.NoThoughts,
+		}, reasoningCallback)
 		if err != nil {
 			fmt.Println("error:", err)
+			return "", err
 		}
-		return msg, err
+		return resp.Text, nil
 	} else if req.Model == "gemini" {
-		msg, err := gemini.Handle(
-			ctx,
-			"gemini-2.5-pro",
-			systemPrompt,
-			[]string{req.Message},
-			nil,
-			reasoningCallback,
-			req.NoThoughts,
-			req.ThinkingBudget,
-		)
+		resp, err := gemini.Handle(ctx, gemini.Request{
+			Model:          "gemini-2.5-pro",
+			System:         systemPrompt,
+			Contents:       []gemini.Content{gemini.UserText(req.Message)},
+			ThinkingBudget: req.ThinkingBudget,
+			NoThoughts:     req.NoThoughts,
+		}, reasoningCallback)
 		if err != nil {
 			fmt.Println("error:", err)
+			return "", err
 		}
-		return msg, err
+		return resp.Text, nil
 	} else if req.Model == "grok" {
 		messages := []grok.Message{
 			{
@@ -443,7 +551,11 @@ func generateResponse(ctx context.Context, req AiRequest, reasoningCallback func
 			Stream:      false,
 			Temperature: 0,
 		}
-		return grok.Handle(ctx, grokReq)
+		resp, err := grok.Handle(ctx, grokReq, nil)
+		if err != nil {
+			return "", err
+		}
+		return resp.Text, nil
 	} else if req.Model == "sonnet" {
 		messages := []claude.Message{
 			{
@@ -476,6 +588,7 @@ func generateResponse(ctx context.Context, req AiRequest, reasoningCallback func
 		if err != nil {
 			return "", err
 		}
+		RecordUsage(request.Model, ClaudeTokenUsage(msg.Usage), false)
 		return msg.Text, nil
 	} else if req.Model == "opus" {
 		messages := []claude.Message{
@@ -504,6 +617,7 @@ func generateResponse(ctx context.Context, req AiRequest, reasoningCallback func
 		if err != nil {
 			return "", err
 		}
+		RecordUsage("claude-opus-4-20250514", ClaudeTokenUsage(msg.Usage), false)
 		return msg.Text, nil
 	} else if req.Model == "sonnet-batch" {
 		messages := []claude.Message{
@@ -539,6 +653,7 @@ func generateResponse(ctx context.Context, req AiRequest, reasoningCallback func
 		if first.Result.Message == nil {
 			return "", fmt.Errorf("claude batch no message")
 		}
+		RecordUsage(batchReq.Params.Model, ClaudeTokenUsage(first.Result.Message.Usage), true)
 		var sb strings.Builder
 		for i, blk := range first.Result.Message.Content {
 			if blk.Type != "text" {
@@ -584,6 +699,7 @@ func generateResponse(ctx context.Context, req AiRequest, reasoningCallback func
 		if first.Result.Message == nil {
 			return "", fmt.Errorf("claude batch no message")
 		}
+		RecordUsage(batchReq.Params.Model, ClaudeTokenUsage(first.Result.Message.Usage), true)
 		var sb strings.Builder
 		for i, blk := range first.Result.Message.Content {
 			if blk.Type != "text" {
@@ -659,6 +775,7 @@ func generateResponse(ctx context.Context, req AiRequest, reasoningCallback func
 		if err != nil {
 			return "", err
 		}
+		RecordUsage(baseModel, OpenAITokenUsage(&openaiResp.Usage), true)
 
 		for _, output := range openaiResp.Output {
 			if output.Type == "message" && len(output.Content) > 0 {
@@ -733,6 +850,7 @@ func generateResponse(ctx context.Context, req AiRequest, reasoningCallback func
 	if err != nil {
 		return "", err
 	}
+	RecordUsage(input.Model, OpenAITokenUsage(resp.Usage), false)
 	// lib.Logger.Println("usage:", util.Format(resp.Usage))
 	return resp.Text, nil
 }
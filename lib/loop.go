@@ -4,18 +4,26 @@ package lib
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
+	"syscall"
 	"time"
 
+	"github.com/nathants/nina/models"
+	"github.com/nathants/nina/prompts"
+	"github.com/nathants/nina/providers"
 	"github.com/nathants/nina/util"
 
 	claude "github.com/nathants/nina/providers/claude"
 	grok "github.com/nathants/nina/providers/grok"
 	groq "github.com/nathants/nina/providers/groq"
+	ollama "github.com/nathants/nina/providers/ollama"
 	openai "github.com/nathants/nina/providers/openai"
 )
 
@@ -24,6 +32,16 @@ type contextKey string
 
 const thinkingKey contextKey = "thinking"
 
+// maxProviderRetries is how many times a transient provider failure is retried
+const maxProviderRetries = 3
+
+// maxInvalidResponses is how many unprocessable responses in a row end the loop
+const maxInvalidResponses = 5
+
+// ProviderRetryDelay is the wait before retrying a provider call, doubled on
+// each attempt
+var ProviderRetryDelay = 2 * time.Second
+
 // LoopState tracks the state of the running conversation loop.
 type LoopState struct {
 	TokensUsed    int // Cumulative tokens used across ALL iterations
@@ -46,6 +64,51 @@ type LoopState struct {
 	StepNumber    int    // Current step/iteration number
 	// Accurate API token tracking for input limits and cache ratio
 	SessionUsage SessionUsage // Tracks cumulative input and cache metrics
+	// Hashes of NinaChange blocks applied this session, see changeKey
+	AppliedChanges map[string]bool
+	// Estimated tokens of the conversation so far, checked against the
+	// model's context window before each call
+	ContextTokens int
+	// Planning tracks a TODO.md plan, see plan.go
+	Planning     bool
+	RefusedStops int  // NinaStop responses refused for unticked critical items
+	DraftsUsed   int  // Responses from the --speculate draft model, see speculate.go
+	Truncated    bool // The last response stopped at the output token limit
+	// StopReason is why the last response ended, normalized, see stopreason.go
+	StopReason string
+	// Protocol is the Nina protocol version of the session, see protocol.go
+	Protocol int
+	// Continuations counts the requests continuing cut off responses, see cutoff.go
+	Continuations int
+	// ToolCalls holds the native tool calls of the last response, see native.go
+	ToolCalls []ToolCall
+	// tools are the native tool definitions sent with each call, nil when
+	// tools are Nina tags
+	tools []any
+	// SeenFiles holds the files the model has seen by path, see stale.go
+	SeenFiles map[string]seenFile
+	// summarizer summarizes long NinaBash output with --summarize
+	summarizer *summarizer
+	// ExternalChanges holds the lines changed per file other than by nina's
+	// file tools, like by sed -i in a NinaBash, see snapshot.go
+	ExternalChanges map[string]int
+	// Downshifted is the model the session started on once a cost ceiling
+	// moved it to a cheaper one, see downshift.go
+	Downshifted string
+	exchanges   []exchange // exchanges so far, kept for a cheaper model
+	costSteps   int        // cost ceilings crossed
+	startCost   float64    // SessionCost when the loop started
+	// Title, ChangedFiles, and Tests are recorded in the sessions index, see
+	// sessions.go
+	Title        string
+	ChangedFiles map[string]bool
+	Tests        string
+	stepsRun     int // steps whose response was processed, indexed once there is one
+	// snapshot is the git state the next changes.diff is taken against, see
+	// snapshot.go
+	snapshot *snapshot
+	// config the loop was started with, NinaAgent children inherit from it
+	config LoopConfig
 }
 
 // ToolProcessor defines how tools are handled in the conversation loop.
@@ -83,13 +146,34 @@ type LoopConfig struct {
 	ToolProcessor ToolProcessor
 	StdinContent  string // Initial content from stdin
 	Thinking      bool   // Enable thinking mode for supported models
+	System        prompts.Override
+	Replay        string        // Recorded agents/api session to replay instead of calling the provider
+	Provider      AIProvider    // Used instead of creating a provider for Model, e.g. a MockClient in tests
+	Plan          bool          // Plan in TODO.md first and refuse NinaStop until critical items are ticked
+	TokenBudget   int           // Fail once input plus output tokens reach this, 0 is unlimited
+	Speculate     string        // Draft model answering in parallel, used when the primary call fails or times out
+	DraftAfter    time.Duration // Use the draft once the primary call takes this long, 0 for the default
+	DraftProvider AIProvider    // Used instead of creating a provider for Speculate, e.g. a MockClient in tests
+	Summarize     string        // Cheap model summarizing long NinaBash output, see summarize.go
+	SummarizeCmds []string      // Patterns of commands whose output is always summarized
+	Summarizer    AIProvider    // Used instead of creating a provider for Summarize, e.g. a MockClient in tests
+	TitleModel    string        // Cheap model titling the session in the sessions index, see sessions.go
+	Titler        AIProvider    // Used instead of creating a provider for TitleModel, e.g. a MockClient in tests
+	Downshift     []CostStep    // Cheaper models used once the session's spend crosses each cost, see downshift.go
+	CheapProvider AIProvider    // Used instead of creating a provider for a Downshift model, e.g. a MockClient in tests
+	agentDepth    int           // NinaAgent nesting, 0 for the top level loop
 }
 
-// LogStderr logs a message to stderr with timestamp.
+// LogStderr logs a message to stderr with timestamp, hidden by -q.
 func LogStderr(format string, args ...any) {
 	timestamp := time.Now().Format("15:04:05")
-	message := fmt.Sprintf(format, args...)
-	fmt.Fprintf(os.Stderr, "[%s] %s\n", timestamp, message)
+	util.Infof("[%s] %s", timestamp, fmt.Sprintf(format, args...))
+}
+
+// LogError logs an error or warning to stderr with timestamp at every level.
+func LogError(format string, args ...any) {
+	timestamp := time.Now().Format("15:04:05")
+	util.Errorf("[%s] %s", timestamp, fmt.Sprintf(format, args...))
 }
 
 // FormatTokens formats token counts for display (e.g., 1500 -> "1k").
@@ -130,42 +214,76 @@ func PrintStatusBar(state *LoopState) {
 	cumulativeCachePercent := int(state.SessionUsage.CacheHitRatio)
 
 	content := fmt.Sprintf(" %s [%d %s %s] [cached %d%%] [%s/%s input (%d%%)] ",
-		state.Model,
+		statusModel(state),
 		state.StepNumber,
 		totalTime, iterTime,
 		cumulativeCachePercent,
 		inputTokensDisplay, maxTokensDisplay, inputPercent)
 
+	// Pipes and CI logs get one plain line instead of a colored box
+	if !util.LogColorEnabled() {
+		util.Infof("%s", strings.TrimSpace(content))
+		return
+	}
+
 	// Calculate separator length to match content
 	separatorLen := len(content) + 4
 	separator := strings.Repeat("=", separatorLen)
 
 	// Print top separator in yellow
-	fmt.Fprintf(os.Stderr, "%s%s%s\n", ColorYellow, separator, ColorReset)
+	util.Printf(util.LogNormal, "%s%s%s\n", ColorYellow, separator, ColorReset)
 
 	// Print content with padding in yellow
-	fmt.Fprintf(os.Stderr, "%s| %s |%s\n", ColorYellow, content, ColorReset)
+	util.Printf(util.LogNormal, "%s| %s |%s\n", ColorYellow, content, ColorReset)
 
 	// Print bottom separator in yellow
-	fmt.Fprintf(os.Stderr, "%s%s%s\n", ColorYellow, separator, ColorReset)
+	util.Printf(util.LogNormal, "%s%s%s\n", ColorYellow, separator, ColorReset)
 }
 
 // RunLoop runs the main conversation loop with the given configuration.
 func RunLoop(config LoopConfig) error {
+	_, err := runLoop(config)
+	return err
+}
+
+// runLoop runs the conversation loop and returns the NinaStop reason
+func runLoop(config LoopConfig) (string, error) {
 	// Set UUID env var if provided
 	if config.UUID != "" {
 		_ = os.Setenv("NINA_UUID", config.UUID)
 	}
 
-	// Create AI provider based on model selection
-	provider, model, err := CreateProviderForModel(config.Model)
-	if err != nil {
-		return fmt.Errorf("failed to create provider: %w", err)
+	// Create AI provider based on model selection, or replay a recorded session
+	provider := config.Provider
+	model := config.Model
+	localWindow := 0
+	switch {
+	case provider != nil:
+		// supplied by the caller
+	case config.Replay != "":
+		replay, err := NewReplayClient(config.Replay)
+		if err != nil {
+			return "", fmt.Errorf("failed to load replay: %w", err)
+		}
+		LogStderr("Replaying %d responses from %s", len(replay.files), replay.Dir())
+		provider = replay
+	default:
+		var err error
+		provider, model, err = CreateProviderForModel(config.Model)
+		if err != nil {
+			return "", fmt.Errorf("failed to create provider: %w", err)
+		}
+		// Local models are limited to the context the server allocates
+		if _, ok := provider.(*OllamaClient); ok {
+			if localWindow, err = configureOllamaModel(context.Background(), model); err != nil {
+				return "", fmt.Errorf("failed to configure %s: %w", model, err)
+			}
+		}
 	}
 
 	// Validate ToolProcessor is set
 	if config.ToolProcessor == nil {
-		return fmt.Errorf("ToolProcessor is required but not set")
+		return "", fmt.Errorf("ToolProcessor is required but not set")
 	}
 
 	// Initialize state
@@ -176,67 +294,171 @@ func RunLoop(config LoopConfig) error {
 		Model:         model,
 		SessionUsage:  SessionUsage{},
 		InitialPrompt: config.StdinContent,
+		Planning:      config.Plan && canPlan(config.ToolProcessor),
+		Protocol:      modelProtocol(config.Model),
+		startCost:     SessionCost(),
+		config:        config,
+	}
+
+	if localWindow > 0 && (state.MaxTokens == 0 || state.MaxTokens > localWindow) {
+		state.MaxTokens = localWindow
+	}
+
+	// Stop the background processes this loop spawned when it returns
+	defer stopSpawned(state)
+
+	// Title the session now and index it for nina sessions search when it ends
+	if config.agentDepth == 0 && config.Replay == "" {
+		state.Title = sessionTitle(config, util.Redact(config.StdinContent))
+		defer indexSession(state)
 	}
 
 	// Handle continuation if requested
 	if err := HandleContinuation(config, provider); err != nil {
-		return err
+		return "", err
+	}
+	provider, err := chaosFromEnv(provider)
+	if err != nil {
+		return "", err
+	}
+	state.AIProvider = provider
+	if state.tools, err = nativeTools(config.ToolProcessor, provider); err != nil {
+		return "", err
+	}
+	spec, err := newSpeculator(config)
+	if err != nil {
+		return "", err
+	}
+	if state.summarizer, err = newSummarizer(config); err != nil {
+		return "", err
 	}
+	state.snapshot = takeSnapshot()
 	// Get system prompt from tool processor
-	systemPrompt := config.ToolProcessor.GetSystemPrompt()
+	systemPrompt := config.System.Apply(config.ToolProcessor.GetSystemPrompt() + "\n" + protocolPrompt(state.Protocol))
 
 	// Track stdin content for first message
 	stdinContent := config.StdinContent
+	invalidResponses := 0
+	seePrompt(state, stdinContent)
 
 	// Main loop
 	for {
+		// Wait while paused, or stop when asked to, between steps
+		if state.StepNumber > 0 {
+			if reason := checkControl(state); reason != "" {
+				currentEvents().Stop(reason)
+				LogStderr("%s", reason)
+				return reason, nil
+			}
+		}
+
 		// Increment step counter
 		state.StepNumber++
+		currentEvents().Step(state.StepNumber)
+		if config.agentDepth == 0 {
+			control.startStep(state.StepNumber)
+		}
 
 		// Track iteration start time
 		state.IterStartTime = time.Now()
 
+		// Report files changed since the model saw them
+		if notice := staleFiles(state); notice != "" {
+			suggest(notice)
+		}
+
 		// Build message using tool processor
 		userMessage, err := config.ToolProcessor.FormatUserMessage(state, stdinContent)
 		if err != nil {
-			return fmt.Errorf("failed to build user message: %w", err)
+			return "", fmt.Errorf("failed to build user message: %w", err)
 		}
 
 		// Show input in debug mode
-		if config.Debug {
+		if config.Debug || util.LogEnabled(util.LogDebug) {
 			highlighted := HighlightNinaTags(userMessage + "\n")
-			fmt.Fprintf(os.Stderr, "%s", highlighted)
+			fmt.Fprint(os.Stderr, util.ForTerminal(os.Stderr, highlighted))
 		}
 
-		// Call AI provider
-		response, err := CallAIProvider(provider, model, systemPrompt, userMessage, state, config.Thinking)
+		// Call AI provider, retrying transient failures, racing the draft
+		// model when speculating
+		var response string
+		if spec != nil {
+			response, err = spec.call(provider, model, systemPrompt, userMessage, state, config.Thinking)
+		} else {
+			response, err = callWithRetry(context.Background(), provider, model, systemPrompt, userMessage, state, config.Thinking)
+		}
 		if err != nil {
-			return fmt.Errorf("failed to call AI provider: %w", err)
+			return "", fmt.Errorf("failed to call AI provider: %w", err)
 		}
+		response = continueResponse(context.Background(), provider, model, systemPrompt, response, state, config.Thinking)
+		recordExchange(state, userMessage, response)
+
+		currentEvents().Delta("text", response)
 
 		// Show output in debug mode
-		if config.Debug {
+		if config.Debug || util.LogEnabled(util.LogDebug) {
 			highlighted := HighlightNinaTags(response + "\n")
-			fmt.Fprintf(os.Stderr, "%s", highlighted)
+			fmt.Fprint(os.Stderr, util.ForTerminal(os.Stderr, highlighted))
 		}
 
 		// Process response using tool processor
 		result := config.ToolProcessor.ProcessResponse(response, state)
+		state.stepsRun++
 
 		// Store results for next input
 		state.LastResults = result.Results
+		seeEvents(state, result.Events)
+
+		// Ask the model to fix responses that could not be processed
+		if result.Error != nil && result.StopReason == "" {
+			invalidResponses++
+			if invalidResponses >= maxInvalidResponses {
+				return "", WithKind(ErrorParse, fmt.Errorf("%d invalid responses in a row: %w", invalidResponses, result.Error))
+			}
+			LogError("Warning: invalid response (%d/%d): %v", invalidResponses, maxInvalidResponses, result.Error)
+			var respErr *ResponseError
+			if errors.As(result.Error, &respErr) {
+				suggest(respErr.Suggestion)
+			} else {
+				suggest(fmt.Sprintf("Your last response could not be processed: %v. Respond with one complete %s block.", result.Error, util.NinaOutputStart))
+			}
+		} else {
+			invalidResponses = 0
+		}
 
 		// Update timings
 		state.IterDuration = time.Since(state.IterStartTime)
 		state.TotalDuration = time.Since(state.StartTime)
 
-		// Print status bar after processing
-		PrintStatusBar(state)
+		currentEvents().Results(result.Events)
+		logChanges(state, result.Events)
+		recordChangedFiles(state, result.Events)
+		recordTests(state, result.Events)
+		if config.agentDepth == 0 {
+			control.finishStep(state, result.Events)
+		}
+		// Continue on a cheaper model once the session's spend crosses a ceiling
+		if provider, model, err = downshift(state, provider, model); err != nil {
+			return "", err
+		}
+		currentEvents().Usage(state)
+
+		// Print status bar after processing, or update the tui
+		if tui := currentTUI(); tui != nil {
+			tui.Step(state, result.Events)
+		} else {
+			PrintStatusBar(state)
+		}
 
 		// Check for stop condition
-		if result.StopReason != "" {
+		if result.StopReason != "" && !refuseStop(state) {
+			currentEvents().Stop(result.StopReason)
 			LogStderr("%s", result.StopReason)
-			break
+			return result.StopReason, nil
+		}
+
+		if used := state.SessionUsage.SessionInput + state.TokensUsed; config.TokenBudget > 0 && used >= config.TokenBudget {
+			return "", WithKind(ErrorBudget, fmt.Errorf("token budget exhausted, used %s of %s", FormatTokens(used), FormatTokens(config.TokenBudget)))
 		}
 
 		// Clear stdin content after first message
@@ -244,64 +466,150 @@ func RunLoop(config LoopConfig) error {
 			stdinContent = ""
 		}
 	}
-
-	return nil
 }
 
 // CreateProviderForModel creates the appropriate AI provider for the given model.
 func CreateProviderForModel(model string) (AIProvider, string, error) {
-	switch model {
-	case "sonnet", "4-sonnet", "opus", "4-opus":
-		provider, err := NewClaudeClient()
-		if err != nil {
-			return nil, "", err
-		}
-		return provider, model, nil
+	m, err := models.Lookup(model)
+	if err != nil {
+		return nil, "", err
+	}
+	var provider AIProvider
+	switch m.Provider {
+	case models.ProviderClaude:
+		provider, err = NewClaudeClient()
+	case models.ProviderOpenAI:
+		provider, err = NewOpenAIClient()
+	case models.ProviderGrok:
+		provider, err = NewGrokClient()
+	case models.ProviderGroq:
+		provider, err = NewGroqClient()
+	case models.ProviderGemini:
+		provider, err = NewGeminiClient()
+	case models.ProviderOllama:
+		provider, err = NewOllamaClient()
+	default:
+		return nil, "", fmt.Errorf("model %s is not supported by run", model)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return provider, model, nil
+}
 
-	case "o3", "o3-flex", "o4-mini", "o4-mini-flex", "gpt-4.1":
-		provider, err := NewOpenAIClient()
-		if err != nil {
-			return nil, "", err
-		}
-		return provider, model, nil
+// registryModel looks up a run model and checks it belongs to provider
+func registryModel(model, provider string) (models.Model, error) {
+	m, err := models.Lookup(model)
+	if err != nil {
+		return m, err
+	}
+	if m.Provider != provider || m.Batch {
+		return m, fmt.Errorf("unknown %s model: %s", provider, model)
+	}
+	return m, nil
+}
 
-	case "grok":
-		provider, err := NewGrokClient()
-		if err != nil {
-			return nil, "", err
+// callWithRetry calls the provider, retrying transient failures with backoff
+// until ctx is done
+func callWithRetry(ctx context.Context, provider AIProvider, model, systemPrompt, userMessage string, state *LoopState, thinking bool) (string, error) {
+	for attempt := 0; ; attempt++ {
+		response, err := callAIProvider(ctx, provider, model, systemPrompt, userMessage, state, thinking)
+		if err == nil || attempt >= maxProviderRetries || !isTransientError(err) || ctx.Err() != nil {
+			return response, err
 		}
-		return provider, "grok-beta", nil
-
-	case "k2":
-		provider, err := NewGroqClient()
-		if err != nil {
-			return nil, "", err
+		delay := ProviderRetryDelay << attempt
+		LogError("Warning: provider call failed, retrying in %s (%d/%d): %v", delay, attempt+1, maxProviderRetries, err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return "", ctx.Err()
 		}
-		return provider, "moonshotai/kimi-k2-instruct", nil
+	}
+}
 
-	case "gemini":
-		provider, err := NewGeminiClient()
-		if err != nil {
-			return nil, "", err
-		}
-		return provider, "gemini-2.5-pro", nil
+// isTransientError reports whether a provider error is worth retrying:
+// timeouts, dropped connections, failed streams, and statuses like 429 or 503
+func isTransientError(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	var statusErr *providers.StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Transient()
+	}
+	var streamErr *providers.StreamError
+	return errors.As(err, &streamErr)
+}
 
-	default:
-		return nil, "", fmt.Errorf("unknown model: %s", model)
+// suggest appends feedback to SUGGEST.md, which the xml processor sends with
+// the next message
+func suggest(text string) {
+	path := filepath.Join(util.GetGitRoot(), "SUGGEST.md")
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		LogError("Failed to write %s: %v", path, err)
+		return
+	}
+	defer func() { _ = f.Close() }()
+	if _, err := fmt.Fprintln(f, text); err != nil {
+		LogError("Failed to write %s: %v", path, err)
 	}
 }
 
 // CallAIProvider calls the AI provider with the given parameters.
 func CallAIProvider(provider AIProvider, model, systemPrompt, userMessage string, state *LoopState, thinking bool) (string, error) {
-	ctx := context.Background()
+	return callAIProvider(context.Background(), provider, model, systemPrompt, userMessage, state, thinking)
+}
+
+func callAIProvider(ctx context.Context, provider AIProvider, model, systemPrompt, userMessage string, state *LoopState, thinking bool) (string, error) {
 	// Add thinking flag to context
 	ctx = context.WithValue(ctx, thinkingKey, thinking)
 
+	// Move to the long context variant, or fail before the call, when the
+	// conversation cannot fit the model. Providers keep the history so only the
+	// first call sends the system prompt.
+	system := systemPrompt
+	if state.ContextTokens > 0 {
+		system = ""
+	}
+	m, lookupErr := models.Lookup(model)
+	if lookupErr == nil {
+		history := max(state.ContextTokens, state.PromptTokens)
+		if long := FitModel(m, history, system, userMessage); long.Alias != m.Alias {
+			if state.Model != long.Alias {
+				LogStderr("The conversation exceeds the %s context window, continuing with %s", m.Alias, long.Alias)
+				state.Model = long.Alias
+			}
+			m, model = long, long.Alias
+		}
+		tokens, err := CheckContextWindow(m, history, system, userMessage)
+		if errors.Is(err, ErrContextWindow) && history > 0 && compactHistory(provider, state, m) {
+			history = max(state.ContextTokens, state.PromptTokens)
+			tokens, err = CheckContextWindow(m, history, system, userMessage)
+		}
+		if err != nil {
+			return "", err
+		}
+		state.ContextTokens = tokens
+	}
+
 	// Track API call timing
 	callStart := time.Now()
 
-	// Call the provider
-	resp, err := provider.Call(ctx, model, systemPrompt, userMessage)
+	// Call the provider, with native tool definitions when the processor has
+	// them, answering the tool calls of the last response with their results
+	ctx = context.WithValue(ctx, toolResultsKey, state.ToolCalls)
+	var resp any
+	var err error
+	if len(state.tools) > 0 && provider.SupportsTools() {
+		resp, err = provider.CallWithTools(ctx, model, systemPrompt, userMessage, state.tools)
+	} else {
+		resp, err = provider.Call(ctx, model, systemPrompt, userMessage)
+	}
 	if err != nil {
 		return "", err
 	}
@@ -311,50 +619,81 @@ func CallAIProvider(provider AIProvider, model, systemPrompt, userMessage string
 
 	// Extract response text based on provider type
 	var responseText string
+	state.StopReason = ""
 	switch r := resp.(type) {
 	case *claude.Response:
 		responseText = r.Content[0].Text
+		state.StopReason = normalizeStopReason(r.StopReason)
 		// Update token tracking
 		cachedTokens := r.Usage.CacheWriteTokens + r.Usage.CacheReadTokens
 		updateTokenTracking(state, r.Usage.InputTokens, r.Usage.OutputTokens, cachedTokens)
 		updateCacheHitRatio(state, r.Usage.CacheReadTokens, r.Usage.InputTokens)
+		RecordUsage(model, ClaudeTokenUsage(r.Usage), false)
 	case *openai.Response:
 		if len(r.Output) > 0 && len(r.Output[0].Content) > 0 && r.Output[0].Content[0].Text != "" {
 			responseText = r.Output[0].Content[0].Text
 		}
+		state.StopReason = openaiStopReason(r)
 		// Update token tracking
 		updateTokenTracking(state, r.Usage.InputTokens, r.Usage.OutputTokens, r.Usage.InputTokensDetails.CachedTokens)
 		updateCacheHitRatio(state, r.Usage.InputTokensDetails.CachedTokens, r.Usage.InputTokens)
+		RecordUsage(model, OpenAITokenUsage(&r.Usage), false)
 
 	case *grok.Response:
 		if len(r.Choices) > 0 && r.Choices[0].Message.Content != "" {
 			responseText = r.Choices[0].Message.Content
+			state.StopReason = normalizeStopReason(r.Choices[0].FinishReason)
 		}
 		// Update token tracking
-		if usage, ok := r.Usage.(map[string]any); ok {
-			if v, ok := usage["completion_tokens"].(float64); ok {
-				state.TokensUsed += int(v)
-			}
-			if v, ok := usage["prompt_tokens"].(float64); ok {
-				state.PromptTokens = int(v)
-			}
+		if r.Usage != nil {
+			updateTokenTracking(state, r.Usage.PromptTokens, r.Usage.CompletionTokens, r.Usage.CachedTokens())
+			updateCacheHitRatio(state, r.Usage.CachedTokens(), r.Usage.PromptTokens)
 		}
+		RecordUsage(model, GrokTokenUsage(r.Usage), false)
 
-	case *groq.Response:
-		if len(r.Choices) > 0 && r.Choices[0].Message.Content != "" {
-			responseText = r.Choices[0].Message.Content
-		}
+	case *groq.HandleResponse:
+		responseText = r.Text
+		state.StopReason = normalizeStopReason(r.FinishReason)
 		// Update token tracking
-		updateTokenTracking(state, r.Usage.PromptTokens, r.Usage.CompletionTokens, 0)
+		if r.Usage != nil {
+			updateTokenTracking(state, r.Usage.PromptTokens, r.Usage.CompletionTokens, r.Usage.CachedTokens())
+			updateCacheHitRatio(state, r.Usage.CachedTokens(), r.Usage.PromptTokens)
+		}
+		RecordUsage(model, GroqTokenUsage(r.Usage), false)
+
+	case *ollama.ChatResponse:
+		responseText = r.Message.Content
+		state.StopReason = normalizeStopReason(r.DoneReason)
+		updateTokenTracking(state, r.PromptEvalCount, r.EvalCount, 0)
+		RecordUsage(model, OllamaTokenUsage(r), false)
+
+	case *ReplayResponse:
+		// Replayed responses cost nothing, so they are tracked but not recorded
+		responseText = r.Text
+		state.StopReason = r.StopReason
+		updateTokenTracking(state, r.Usage.Input, r.Usage.Output, r.Usage.Cache.Read)
 
 	case *GeminiResponse:
 		responseText = r.Text
-		// Gemini doesn't provide exact token counts, so we estimate
-		// This is handled in GetTokenUsage method
+		state.StopReason = normalizeStopReason(r.FinishReason)
+		// Update token tracking
+		updateTokenTracking(state, r.Usage.PromptTokens, r.Usage.CandidatesTokens+r.Usage.ThoughtsTokens, r.Usage.CachedTokens)
+		updateCacheHitRatio(state, r.Usage.CachedTokens, r.Usage.PromptTokens)
+		RecordUsage(model, GeminiTokenUsage(r.Usage), false)
 
 	default:
 		return "", fmt.Errorf("unknown response type: %T", resp)
 	}
+	if state.ToolCalls, err = responseToolCalls(resp); err != nil {
+		return "", err
+	}
+	state.Truncated = state.StopReason == StopMaxTokens
+	if state.StopReason == StopContentFilter {
+		LogError("Warning: the response from %s was stopped by a content filter", model)
+	}
+	if lookupErr == nil {
+		state.ContextTokens += util.CalculateMessageTokens("assistant", responseText)
+	}
 
 	return responseText, nil
 }
@@ -386,7 +725,7 @@ func updateCacheHitRatio(state *LoopState, cachedTokens, inputTokens int) {
 
 type PreviousConversation struct {
 	Model        string `json:"model"`
-	Messages     []any  `json:"messages"`    // Generic messages for Claude/Grok
+	Messages     []any  `json:"messages"`    // Generic messages, or contents for Gemini
 	ResponseID   string `json:"response_id"` // For OpenAI continuation
 	SystemPrompt string `json:"system_prompt"`
 }
@@ -404,7 +743,7 @@ func findLatestConversation() (inputPath string, outputPath string, err error) {
 	// Filter and sort timestamp directories
 	var timestamps []string
 	for _, entry := range entries {
-		if entry.IsDir() && len(entry.Name()) == 19 { // YYYY-MM-DD_HH-MM-SS format
+		if entry.IsDir() && len(entry.Name()) == 15 { // YYYYMMDD-HHMMSS format
 			timestamps = append(timestamps, entry.Name())
 		}
 	}
@@ -485,6 +824,14 @@ func loadPreviousConversation() (*PreviousConversation, error) {
 		}
 	}
 
+	// Gemini logs a string system prompt and its contents with the reply
+	if system, ok := inputJSON["system"].(string); ok {
+		prev.SystemPrompt = system
+	}
+	if contents, ok := inputJSON["contents"].([]any); ok {
+		prev.Messages = contents
+	}
+
 	// Read output file to get messages and response ID
 	if outputPath != "" {
 		outputData, err := os.ReadFile(outputPath)
@@ -499,6 +846,13 @@ func loadPreviousConversation() (*PreviousConversation, error) {
 				if id, ok := outputJSON["id"].(string); ok {
 					prev.ResponseID = id
 				}
+				// For Grok, Groq, and Ollama, the request messages plus the reply
+				if messages, ok := inputJSON["messages"].([]any); ok && len(prev.Messages) == 0 {
+					prev.Messages = messages
+					if reply := loggedReply(outputJSON); reply != "" {
+						prev.Messages = append(prev.Messages, map[string]any{"role": "assistant", "content": reply})
+					}
+				}
 			}
 		}
 	}
@@ -507,6 +861,25 @@ func loadPreviousConversation() (*PreviousConversation, error) {
 
 }
 
+// loggedReply returns the assistant text of a logged Grok, Groq, or Ollama
+// response
+func loggedReply(outputJSON map[string]any) string {
+	if message, ok := outputJSON["message"].(map[string]any); ok {
+		text, _ := message["content"].(string)
+		return text
+	}
+	if choices, ok := outputJSON["choices"].([]any); ok && len(choices) > 0 {
+		if choice, ok := choices[0].(map[string]any); ok {
+			if message, ok := choice["message"].(map[string]any); ok {
+				text, _ := message["content"].(string)
+				return text
+			}
+		}
+	}
+	text, _ := outputJSON["Text"].(string)
+	return text
+}
+
 // HandleContinuation handles loading and restoring previous conversation state
 func HandleContinuation(config LoopConfig, provider AIProvider) error {
 	if !config.Continue {
@@ -515,30 +888,21 @@ func HandleContinuation(config LoopConfig, provider AIProvider) error {
 
 	prev, err := loadPreviousConversation()
 	if err != nil {
-		LogStderr("Warning: Could not load previous conversation: %v", err)
+		LogError("Warning: Could not load previous conversation: %v", err)
 		LogStderr("Starting new conversation instead.")
 		return nil
 	}
 
-	// Map API model names to CLI model names
-	modelMap := map[string]string{
-		"claude-opus-4-20250514":      "opus",
-		"claude-sonnet-4-20250514":    "sonnet",
-		"claude-sonnet-4-20241022":    "4-sonnet",
-		"o3":                          "o3",
-		"o4-mini":                     "o4-mini",
-		"grok-4-0709":                 "grok",
-		"moonshotai/kimi-k2-instruct": "k2",
-		"gemini-2.5-pro":              "gemini",
-	}
-
-	prevModel := modelMap[prev.Model]
-	if prevModel == "" {
-		prevModel = prev.Model // Use as-is if not in map
+	// Continuation only works with the same api model
+	current, err := models.Lookup(config.Model)
+	if err != nil {
+		return err
 	}
-
-	// Check for model mismatch - continuation only works with same model
-	if prevModel != config.Model {
+	if current.APIModel != prev.Model {
+		prevModel := prev.Model
+		if m, ok := models.ByAPIModel(prev.Model); ok {
+			prevModel = m.Alias
+		}
 		return fmt.Errorf("cannot continue conversation from model '%s' with model '%s'", prevModel, config.Model)
 	}
 
@@ -561,9 +925,37 @@ func HandleContinuation(config LoopConfig, provider AIProvider) error {
 			}
 			LogStderr("Successfully restored Claude conversation with %d messages", len(prev.Messages))
 		}
+	case *GeminiClient:
+		if len(prev.Messages) > 0 {
+			if err := p.RestoreMessages(prev.SystemPrompt, prev.Messages); err != nil {
+				return fmt.Errorf("failed to restore Gemini conversation: %w", err)
+			}
+			LogStderr("Successfully restored Gemini conversation with %d messages", len(prev.Messages))
+		}
+	case *GrokClient:
+		if len(prev.Messages) > 0 {
+			if err := p.RestoreMessages(prev.Messages); err != nil {
+				return fmt.Errorf("failed to restore Grok conversation: %w", err)
+			}
+			LogStderr("Successfully restored Grok conversation with %d messages", len(prev.Messages))
+		}
+	case *GroqClient:
+		if len(prev.Messages) > 0 {
+			if err := p.RestoreMessages(prev.Messages); err != nil {
+				return fmt.Errorf("failed to restore Groq conversation: %w", err)
+			}
+			LogStderr("Successfully restored Groq conversation with %d messages", len(prev.Messages))
+		}
+	case *OllamaClient:
+		if len(prev.Messages) > 0 {
+			if err := p.RestoreMessages(prev.Messages); err != nil {
+				return fmt.Errorf("failed to restore Ollama conversation: %w", err)
+			}
+			LogStderr("Successfully restored Ollama conversation with %d messages", len(prev.Messages))
+		}
 	default:
 		// For other providers, log a warning
-		LogStderr("Warning: Continuation not supported for this provider type")
+		LogError("Warning: Continuation not supported for this provider type")
 	}
 
 	return nil
@@ -0,0 +1,72 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/nathants/nina/providers"
+)
+
+func TestLoadPreviousConversationAppendsGrokReply(t *testing.T) {
+	t.Chdir(t.TempDir())
+	dir := filepath.Join("agents", "api", "20250101-120000")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	input := `{"model": "grok-4", "messages": [{"role": "system", "content": "sys"}, {"role": "user", "content": "hi"}]}`
+	output := `{"model": "grok-4", "choices": [{"message": {"role": "assistant", "content": "hello"}}]}`
+	if err := os.WriteFile(filepath.Join(dir, "00001.input.json"), []byte(input), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "00001.output.json"), []byte(output), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	prev, err := loadPreviousConversation()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &GrokClient{}
+	if err := client.RestoreMessages(prev.Messages); err != nil {
+		t.Fatal(err)
+	}
+	if len(client.messages) != 3 || client.messages[2].Role != "assistant" || client.messages[2].Content != "hello" {
+		t.Fatalf("messages = %+v, want system, user, and the logged reply", client.messages)
+	}
+}
+
+func TestLoggedReplyGroq(t *testing.T) {
+	if got := loggedReply(map[string]any{"Text": "done"}); got != "done" {
+		t.Errorf("loggedReply() = %q, want %q", got, "done")
+	}
+}
+
+func TestIsTransientError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&providers.StatusError{StatusCode: 429, Body: "rate_limit_error"}, true},
+		{fmt.Errorf("grok: %w", &providers.StatusError{StatusCode: 503}), true},
+		{&providers.StatusError{StatusCode: 529, Body: "overloaded_error"}, true},
+		{&providers.StatusError{StatusCode: 400, Body: "stream: 429 tokens is not a valid value"}, false},
+		{&providers.StatusError{StatusCode: 401, Body: "invalid x-api-key"}, false},
+		{&providers.StreamError{Err: errors.New("stream read error: connection closed")}, true},
+		{fmt.Errorf("do request error: %w", context.DeadlineExceeded), true},
+		{fmt.Errorf("read: %w", io.ErrUnexpectedEOF), true},
+		{fmt.Errorf("read: %w", syscall.ECONNRESET), true},
+		{errors.New("the stream of 429 requests"), false},
+		{context.Canceled, false},
+	}
+	for _, tt := range tests {
+		if got := isTransientError(tt.err); got != tt.want {
+			t.Errorf("isTransientError(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+// Deterministic AIProvider for tests. It returns scripted responses in order
+// and records the messages it was sent, so the loop and tool processors can
+// be tested hermetically without api keys or network.
+package lib
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// MockClient is an AIProvider that returns scripted responses in order
+type MockClient struct {
+	Responses []string
+	Messages  []string      // user messages received, in order
+	System    string        // last system prompt received
+	Recorded  []string      // responses from another model, see RecordTurn
+	Delay     time.Duration // wait before answering, ended early by cancelation
+	MaxOutput int           // cut responses longer than this, like an output token limit
+}
+
+// NewMockClient returns a MockClient that answers with responses in order
+func NewMockClient(responses ...string) *MockClient {
+	return &MockClient{Responses: responses}
+}
+
+// Call returns the next scripted response with usage estimated from length
+func (c *MockClient) Call(ctx context.Context, model, systemPrompt, userMessage string) (any, error) {
+	if len(c.Messages) >= len(c.Responses) {
+		return nil, fmt.Errorf("mock responses exhausted after %d calls", len(c.Responses))
+	}
+	if c.Delay > 0 {
+		select {
+		case <-time.After(c.Delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	text := c.Responses[len(c.Messages)]
+	c.Messages = append(c.Messages, userMessage)
+	c.System = systemPrompt
+	var stopReason string
+	if c.MaxOutput > 0 && len(text) > c.MaxOutput {
+		text = text[:c.MaxOutput]
+		stopReason = StopMaxTokens
+	}
+	return &ReplayResponse{
+		Path: "mock",
+		Text: text,
+		Usage: TokenUsage{
+			Input:  (len(systemPrompt) + len(userMessage)) / 4,
+			Output: len(text) / 4,
+		},
+		StopReason: stopReason,
+	}, nil
+}
+
+// RecordTurn records a response from another model
+func (c *MockClient) RecordTurn(userMessage, response string) {
+	c.Recorded = append(c.Recorded, response)
+}
+
+// CallWithStore returns the next scripted response
+func (c *MockClient) CallWithStore(ctx context.Context, model, systemPrompt, userMessage string) (any, error) {
+	return c.Call(ctx, model, systemPrompt, userMessage)
+}
+
+// CallWithTools returns the next scripted response
+func (c *MockClient) CallWithTools(ctx context.Context, model, systemPrompt, userMessage string, tools []any) (any, error) {
+	return c.Call(ctx, model, systemPrompt, userMessage)
+}
+
+// SupportsTools returns false, scripted responses use xml tool calls
+func (c *MockClient) SupportsTools() bool {
+	return false
+}
+
+// GetTokenUsage returns the estimated usage of the response
+func (c *MockClient) GetTokenUsage(resp any) (promptTokens, completionTokens, totalTokens int) {
+	usage := c.GetDetailedUsage(resp)
+	return usage.Input, usage.Output, usage.Input + usage.Output
+}
+
+// GetDetailedUsage returns the estimated usage of the response
+func (c *MockClient) GetDetailedUsage(resp any) TokenUsage {
+	if r, ok := resp.(*ReplayResponse); ok {
+		return r.Usage
+	}
+	return TokenUsage{}
+}
+
+// CompactMessages does nothing, there is no history to send
+func (c *MockClient) CompactMessages(messagePairs int) CompactionResult {
+	return CompactionResult{}
+}
@@ -0,0 +1,151 @@
+// Native tool calling. A ToolProcessor implementing NativeToolProcessor has
+// its tools sent as provider-native tool definitions, Anthropic tools, OpenAI
+// function calling, or Gemini function calling, instead of described in the
+// system prompt as Nina tags. The calls of each response are kept in
+// LoopState.ToolCalls for the processor, which runs them with the executors
+// of the XML path and keeps the NinaResult of each in ToolCall.Result. The
+// next call answers each tool_use, function_call_output, or functionResponse
+// with the result of its call, so both formats can be compared on the same
+// tasks.
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/nathants/nina/prompts"
+	claude "github.com/nathants/nina/providers/claude"
+	openai "github.com/nathants/nina/providers/openai"
+)
+
+// toolCallNotRun answers a native tool call without a result, one of a
+// response recorded from another model or cut off by the token limit
+const toolCallNotRun = "this call was not run"
+
+// toolResultsKey holds the calls of the last response, with their results,
+// in the context of the next call
+const toolResultsKey contextKey = "tool results"
+
+// ToolCall is one native tool call of a response
+type ToolCall struct {
+	ID        string
+	Name      string
+	Arguments map[string]any
+	Result    string // the NinaResult of running the call
+}
+
+// ToolResults returns the calls of the last response, with their results,
+// answered by the call made with ctx
+func ToolResults(ctx context.Context) []ToolCall {
+	calls, _ := ctx.Value(toolResultsKey).([]ToolCall)
+	return calls
+}
+
+// toolResult returns the result of the pending call with id, the ith call of
+// its response, matched by position for providers without call ids
+func toolResult(calls []ToolCall, i int, id string) string {
+	for _, call := range calls {
+		if id != "" && call.ID == id && call.Result != "" {
+			return call.Result
+		}
+	}
+	if id == "" && i < len(calls) && calls[i].Result != "" {
+		return calls[i].Result
+	}
+	return toolCallNotRun
+}
+
+// NativeToolProcessor is a ToolProcessor whose tools are sent to the provider
+// as native tool definitions
+type NativeToolProcessor interface {
+	ToolProcessor
+	GetToolDefinitions() []prompts.ToolDefinition
+}
+
+// nativeTools returns the tool definitions sent with each call for
+// processor, nil for one using Nina tags
+func nativeTools(processor ToolProcessor, provider AIProvider) ([]any, error) {
+	native, ok := processor.(NativeToolProcessor)
+	if !ok {
+		return nil, nil
+	}
+	if !provider.SupportsTools() {
+		return nil, fmt.Errorf("native tool calls need a provider with tool calling, %T has none, use xml", provider)
+	}
+	var tools []any
+	for _, def := range native.GetToolDefinitions() {
+		tools = append(tools, def)
+	}
+	return tools, nil
+}
+
+// toolDefinitions returns the prompts.ToolDefinition values of tools, the
+// nina tools when there are none
+func toolDefinitions(tools []any) []prompts.ToolDefinition {
+	var defs []prompts.ToolDefinition
+	for _, tool := range tools {
+		if def, ok := tool.(prompts.ToolDefinition); ok {
+			defs = append(defs, def)
+		}
+	}
+	if len(defs) == 0 {
+		defs = prompts.GetToolDefinitions()
+	}
+	return defs
+}
+
+// toolSchema returns the json schema of the input of def
+func toolSchema(def prompts.ToolDefinition) map[string]any {
+	properties := map[string]any{}
+	required := []string{}
+	for _, field := range def.InputSchema.Fields {
+		fieldType := field.Type
+		if fieldType == "int" {
+			fieldType = "integer"
+		}
+		properties[field.Name] = map[string]any{
+			"type":        fieldType,
+			"description": field.Description,
+		}
+		if field.Required {
+			required = append(required, field.Name)
+		}
+	}
+	return map[string]any{
+		"type":       "object",
+		"properties": properties,
+		"required":   required,
+	}
+}
+
+// responseToolCalls returns the native tool calls of a provider response
+func responseToolCalls(resp any) ([]ToolCall, error) {
+	var calls []ToolCall
+	switch r := resp.(type) {
+	case *claude.Response:
+		for _, block := range r.Content {
+			if block.Type == "tool_use" {
+				calls = append(calls, ToolCall{ID: block.ID, Name: block.Name, Arguments: block.Input})
+			}
+		}
+	case *openai.Response:
+		for _, output := range r.Output {
+			if output.Type != "function_call" {
+				continue
+			}
+			call := ToolCall{ID: output.CallID, Name: output.Name, Arguments: map[string]any{}}
+			if output.Arguments != "" {
+				if err := json.Unmarshal([]byte(output.Arguments), &call.Arguments); err != nil {
+					return nil, fmt.Errorf("invalid arguments for %s: %w", output.Name, err)
+				}
+			}
+			calls = append(calls, call)
+		}
+	case *GeminiResponse:
+		for _, fc := range r.FunctionCalls {
+			calls = append(calls, ToolCall{ID: fc.ID, Name: fc.Name, Arguments: fc.Args})
+		}
+	}
+	return calls, nil
+}
@@ -0,0 +1,81 @@
+// Tests for native tool calling covering tool schemas, reading calls from
+// provider responses, and answering pending calls in the next user turn
+package lib
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nathants/nina/prompts"
+	claude "github.com/nathants/nina/providers/claude"
+	gemini "github.com/nathants/nina/providers/gemini"
+	openai "github.com/nathants/nina/providers/openai"
+)
+
+func TestToolSchema(t *testing.T) {
+	got := toolSchema(prompts.ToolDefinition{Name: "NinaRead", InputSchema: prompts.ToolInputSchema{Fields: []prompts.ToolField{
+		{Name: "path", Type: "string", Required: true, Description: "file"},
+		{Name: "start_line", Type: "int", Description: "first"},
+	}}})
+	want := map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"path":       map[string]any{"type": "string", "description": "file"},
+			"start_line": map[string]any{"type": "integer", "description": "first"},
+		},
+		"required": []string{"path"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("toolSchema() = %v, want %v", got, want)
+	}
+}
+
+func TestResponseToolCalls(t *testing.T) {
+	want := []ToolCall{{ID: "1", Name: "NinaBash", Arguments: map[string]any{"command": "ls"}}}
+	for name, resp := range map[string]any{
+		"claude": &claude.Response{Content: []claude.ContentBlock{
+			{Type: "text", Text: "listing"},
+			{Type: "tool_use", ID: "1", Name: "NinaBash", Input: map[string]any{"command": "ls"}},
+		}},
+		"openai": &openai.Response{Output: []openai.Output{
+			{Type: "message"},
+			{Type: "function_call", CallID: "1", Name: "NinaBash", Arguments: `{"command": "ls"}`},
+		}},
+		"gemini": &GeminiResponse{FunctionCalls: []gemini.FunctionCall{{ID: "1", Name: "NinaBash", Args: map[string]any{"command": "ls"}}}},
+	} {
+		got, err := responseToolCalls(resp)
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: responseToolCalls() = %+v, %v, want %+v", name, got, err, want)
+		}
+	}
+	if calls, err := responseToolCalls(&ReplayResponse{Text: "x"}); err != nil || calls != nil {
+		t.Errorf("a response without tool calls = %+v, %v", calls, err)
+	}
+	if _, err := responseToolCalls(&openai.Response{Output: []openai.Output{{Type: "function_call", Name: "NinaBash", Arguments: "{"}}}); err == nil {
+		t.Error("expected an error for invalid arguments")
+	}
+}
+
+func TestUserTurnAnswersToolCalls(t *testing.T) {
+	results := []ToolCall{{ID: "b", Result: "ran b"}, {ID: "a", Result: "ran a"}}
+	c := &ClaudeClient{pending: []claude.ToolUse{{ID: "a"}, {ID: "b"}}}
+	turn := c.userTurn("results", results)
+	if len(turn.Content) != 3 || turn.Content[0].Type != "tool_result" || turn.Content[1].ToolUseID != "b" || turn.Content[2].Text != "results" {
+		t.Errorf("claude turn = %+v", turn)
+	}
+	if turn.Content[0].Content != "ran a" || turn.Content[1].Content != "ran b" {
+		t.Errorf("tool results = %q %q, want each call's own", turn.Content[0].Content, turn.Content[1].Content)
+	}
+	if messageText(turn) != "results" {
+		t.Errorf("messageText() = %q", messageText(turn))
+	}
+
+	o := &OpenAIClient{pending: []openai.FunctionCall{{CallID: "c"}}}
+	items := o.userTurn("results", results)
+	if len(items) != 2 || items[0].Type != "function_call_output" || items[0].CallID != "c" || items[1].Content[0].Text != "results" {
+		t.Errorf("openai turn = %+v", items)
+	}
+	if items[0].Output != toolCallNotRun {
+		t.Errorf("a call without a result = %q, want %q", items[0].Output, toolCallNotRun)
+	}
+}
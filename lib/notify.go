@@ -0,0 +1,193 @@
+// Notifications when a run completes, fails, or pauses for approval, so long
+// sessions need not be watched. Targets come from NINA_NOTIFY, a comma
+// separated list, and from the project's .nina/notify.json at the git root:
+//
+//	desktop              notify-send on linux, osascript on macos
+//	https://...          POST a json event to a webhook
+//	https://hooks.slack.com/...  POST a slack message
+//
+// The project file is {"targets": [...], "events": ["complete", "fail"]},
+// events defaults to all of them.
+package lib
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nathants/nina/util"
+)
+
+const (
+	NotifyComplete = "complete"
+	NotifyFail     = "fail"
+	NotifyPause    = "pause"
+)
+
+const notifyTimeout = 10 * time.Second
+
+// NotifyConfig is the project notification config in .nina/notify.json
+type NotifyConfig struct {
+	Targets []string `json:"targets"`
+	Events  []string `json:"events,omitempty"`
+}
+
+// NotifyEvent is the json body posted to webhooks
+type NotifyEvent struct {
+	Event    string  `json:"event"`
+	Command  string  `json:"command"`
+	Message  string  `json:"message"`
+	Project  string  `json:"project"`
+	Session  string  `json:"session"`
+	Duration string  `json:"duration,omitempty"`
+	Cost     float64 `json:"cost"`
+}
+
+var processStart = time.Now()
+
+// LoadNotifyConfig merges the project config with NINA_NOTIFY targets
+func LoadNotifyConfig() (NotifyConfig, error) {
+	var config NotifyConfig
+	if root := util.GetGitRoot(); root != "" {
+		data, err := os.ReadFile(filepath.Join(root, ".nina", "notify.json"))
+		if err != nil && !os.IsNotExist(err) {
+			return config, err
+		}
+		if err == nil {
+			if err := json.Unmarshal(data, &config); err != nil {
+				return config, fmt.Errorf("invalid .nina/notify.json: %w", err)
+			}
+		}
+	}
+	for _, target := range strings.Split(os.Getenv("NINA_NOTIFY"), ",") {
+		if target = strings.TrimSpace(target); target != "" && !slices.Contains(config.Targets, target) {
+			config.Targets = append(config.Targets, target)
+		}
+	}
+	for _, event := range config.Events {
+		if event != NotifyComplete && event != NotifyFail && event != NotifyPause {
+			return config, fmt.Errorf("invalid notify event: %s", event)
+		}
+	}
+	return config, nil
+}
+
+// AddNotifyTargets adds targets to NINA_NOTIFY for this process and any children
+func AddNotifyTargets(targets []string) {
+	if len(targets) == 0 {
+		return
+	}
+	existing := os.Getenv("NINA_NOTIFY")
+	if existing != "" {
+		targets = append([]string{existing}, targets...)
+	}
+	_ = os.Setenv("NINA_NOTIFY", strings.Join(targets, ","))
+}
+
+// Notify sends event to every configured target, failures only warn
+func Notify(event, message string) {
+	config, err := LoadNotifyConfig()
+	if err != nil {
+		util.Errorf("warning: notify: %v", err)
+		return
+	}
+	if len(config.Targets) == 0 || (len(config.Events) > 0 && !slices.Contains(config.Events, event)) {
+		return
+	}
+	project := filepath.Base(util.GetGitRoot())
+	if project == "." || project == "/" {
+		project, _ = os.Getwd()
+	}
+	command := ""
+	if len(os.Args) > 0 {
+		command = filepath.Base(os.Args[0])
+	}
+	payload := NotifyEvent{
+		Event:    event,
+		Command:  command,
+		Message:  util.Redact(message),
+		Project:  project,
+		Session:  sessionTimestamp,
+		Duration: time.Since(processStart).Round(time.Second).String(),
+		Cost:     SessionCost(),
+	}
+
+	var wg sync.WaitGroup
+	for _, target := range config.Targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := notifyTarget(target, payload); err != nil {
+				util.Errorf("warning: notify %s: %v", target, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func notifyTarget(target string, payload NotifyEvent) error {
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+	title := fmt.Sprintf("nina %s: %s", payload.Command, payload.Event)
+	body := fmt.Sprintf("%s (%s, $%.2f)", payload.Message, payload.Duration, payload.Cost)
+	switch {
+	case target == "desktop":
+		return notifyDesktop(ctx, title, body)
+	case strings.HasPrefix(target, "https://hooks.slack.com/"):
+		return postJSON(ctx, target, map[string]string{"text": fmt.Sprintf("*%s* [%s] %s", title, payload.Project, body)})
+	case strings.HasPrefix(target, "https://") || strings.HasPrefix(target, "http://"):
+		return postJSON(ctx, target, payload)
+	default:
+		return fmt.Errorf("unknown target, expected desktop or a url")
+	}
+}
+
+func notifyDesktop(ctx context.Context, title, body string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		script := fmt.Sprintf("display notification %s with title %s", appleScriptQuote(body), appleScriptQuote(title))
+		cmd = exec.CommandContext(ctx, "osascript", "-e", script)
+	default:
+		cmd = exec.CommandContext(ctx, "notify-send", "--app-name=nina", title, body)
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %w: %s", cmd.Args[0], err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func appleScriptQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+func postJSON(ctx context.Context, url string, body any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+// Tests for notifications posting json events to webhooks and filtering by
+// the configured events
+package lib
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNotifyWebhook(t *testing.T) {
+	var got []NotifyEvent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event NotifyEvent
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("decode: %v", err)
+		}
+		got = append(got, event)
+	}))
+	defer server.Close()
+	t.Chdir(t.TempDir())
+	t.Setenv("NINA_NOTIFY", server.URL)
+
+	Notify(NotifyFail, "boom")
+	if len(got) != 1 || got[0].Event != NotifyFail || got[0].Message != "boom" {
+		t.Fatalf("unexpected events: %+v", got)
+	}
+
+	t.Setenv("NINA_NOTIFY", "")
+	AddNotifyTargets([]string{server.URL, server.URL})
+	config, err := LoadNotifyConfig()
+	if err != nil || len(config.Targets) != 1 {
+		t.Fatalf("LoadNotifyConfig() = %+v, %v, want one deduplicated target", config, err)
+	}
+}
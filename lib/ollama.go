@@ -0,0 +1,324 @@
+// Ollama integration for nina run against a local server. Models are named
+// ollama, the most recently modified installed model, or ollama:<name>, with
+// num_ctx and temperature read from a models.json entry of the same name. A
+// named model that is not installed is pulled after asking on the terminal,
+// or without asking when NINA_OLLAMA_PULL=1. Maintains full message history.
+//
+// Ollama truncates prompts longer than the context it allocated without an
+// error, so before the first step the model's context window is set to the
+// num_ctx requested: the models.json one, else the Modelfile's, else the
+// length the model was trained with capped at NINA_OLLAMA_NUM_CTX, default
+// 32k, as the whole window costs memory. The preflight check and compaction
+// then work against that window and the session's MaxTokens is capped by it.
+package lib
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/nathants/nina/models"
+	ollama "github.com/nathants/nina/providers/ollama"
+	util "github.com/nathants/nina/util"
+)
+
+// OllamaClient talks to the ollama server and keeps the message history
+type OllamaClient struct {
+	messages []ollama.Message
+	checked  map[string]bool // models known to be installed
+}
+
+// NewOllamaClient creates a new Ollama client with an empty message history
+func NewOllamaClient() (*OllamaClient, error) {
+	return &OllamaClient{checked: map[string]bool{}}, nil
+}
+
+// ollamaConfig returns the request settings of a registry model
+func ollamaConfig(m models.Model) ollama.OllamaConfig {
+	config := ollama.OllamaConfig{
+		Model:       m.APIModel,
+		Temperature: m.Temperature,
+		TopP:        m.TopP,
+	}
+	if m.NumCtx > 0 {
+		config.NumCtx = &m.NumCtx
+	}
+	if m.MaxOutput > 0 {
+		config.NumPredict = &m.MaxOutput
+	}
+	return config
+}
+
+// defaultOllamaContext caps the num_ctx requested for a model whose config
+// and Modelfile set none
+const defaultOllamaContext = 32_768
+
+// ollamaContextCap returns the largest num_ctx requested without config
+func ollamaContextCap() int {
+	if n, err := strconv.Atoi(os.Getenv("NINA_OLLAMA_NUM_CTX")); err == nil && n > 0 {
+		return n
+	}
+	return defaultOllamaContext
+}
+
+// ollamaWindow returns m with its context window and num_ctx set to the
+// context the server is asked for, given the length the model was trained
+// with and the num_ctx of its Modelfile, 0 when unknown. A quarter of the
+// window is kept for output when m sets no max output that fits.
+func ollamaWindow(m models.Model, trained, configured int) models.Model {
+	window := m.NumCtx
+	if window == 0 {
+		window = m.ContextWindow
+	}
+	if window == 0 {
+		window = configured
+	}
+	if window == 0 {
+		window = ollamaContextCap()
+		if trained > 0 {
+			window = min(trained, window)
+		}
+	}
+	m.NumCtx = window
+	m.ContextWindow = window
+	if m.MaxOutput == 0 || m.MaxOutput >= window {
+		m.MaxOutput = window / 4
+	}
+	return m
+}
+
+// configureOllamaModel installs the ollama model if needed and sets its
+// context window for the rest of the process, returning the window
+func configureOllamaModel(ctx context.Context, model string) (int, error) {
+	m, err := models.Lookup(model)
+	if err != nil {
+		return 0, err
+	}
+	if err := EnsureOllamaModel(ctx, m.APIModel); err != nil {
+		return 0, err
+	}
+	trained, configured := 0, 0
+	if m.NumCtx == 0 && m.ContextWindow == 0 {
+		if trained, configured, err = ollama.ContextLength(ctx, m.APIModel); err != nil {
+			return 0, err
+		}
+	}
+	m = ollamaWindow(m, trained, configured)
+	models.Override(m)
+	LogStderr("Using a %s token context window for %s", FormatTokens(m.ContextWindow), model)
+	return m.ContextWindow, nil
+}
+
+// EnsureOllamaModel checks that name is installed on the ollama server,
+// offering to pull it when it is not. The model "ollama" picks an installed
+// model and is not checked.
+func EnsureOllamaModel(ctx context.Context, name string) error {
+	if name == "ollama" || name == "" {
+		return nil
+	}
+	installed, err := ollama.ListModels(ctx)
+	if err != nil {
+		return fmt.Errorf("ollama: %w", err)
+	}
+	if ollama.Installed(installed, name) {
+		return nil
+	}
+	if !confirmPull(name) {
+		return fmt.Errorf("ollama model %s is not installed, run: ollama pull %s", name, name)
+	}
+	LogStderr("Pulling ollama model %s", name)
+	return ollama.Pull(ctx, name, func(status string) {
+		LogStderr("ollama: %s", status)
+	})
+}
+
+// confirmPull asks on the terminal whether to pull a missing model, since
+// stdin may hold the prompt. NINA_OLLAMA_PULL=1 pulls and =0 refuses without
+// asking, without a terminal the pull is refused.
+func confirmPull(name string) bool {
+	switch os.Getenv("NINA_OLLAMA_PULL") {
+	case "1":
+		return true
+	case "0":
+		return false
+	}
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return false
+	}
+	defer func() { _ = tty.Close() }()
+	_, _ = fmt.Fprintf(tty, "ollama model %s is not installed, pull it? [y/N] ", name)
+	answer, _ := bufio.NewReader(tty).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// OllamaChat sends one system and user message to a registry model, pulling
+// it first when needed, records the usage, and returns the reply. Streamed
+// text goes to callback when it is set.
+func OllamaChat(ctx context.Context, m models.Model, systemPrompt, message string, callback func(string)) (string, error) {
+	if err := EnsureOllamaModel(ctx, m.APIModel); err != nil {
+		return "", err
+	}
+	config := ollamaConfig(m)
+	config.Stream = callback != nil
+	config.ReasoningCallback = callback
+	resp, err := ollama.HandleChat(ctx, []ollama.Message{
+		{Role: "system", Content: systemPrompt},
+		{Role: "user", Content: message},
+	}, config)
+	if err != nil {
+		return "", err
+	}
+	RecordUsage(resp.Model, OllamaTokenUsage(resp), false)
+	return resp.Message.Content, nil
+}
+
+// RestoreMessages restores conversation history from a previous session for continuation
+func (c *OllamaClient) RestoreMessages(messages []any) error {
+	c.messages = []ollama.Message{}
+	for _, msg := range messages {
+		role, text := loggedMessage(msg)
+		if role != "" && text != "" {
+			c.messages = append(c.messages, ollama.Message{Role: role, Content: text})
+		}
+	}
+	return nil
+}
+
+// CallWithStore adds the user message to the history, sends it to the ollama
+// server streaming the reply to the TUI, and logs the request and response
+func (c *OllamaClient) CallWithStore(ctx context.Context, model, systemPrompt, userMessage string) (any, error) {
+	m, err := registryModel(model, models.ProviderOllama)
+	if err != nil {
+		return nil, err
+	}
+	if !c.checked[m.APIModel] {
+		if err := EnsureOllamaModel(ctx, m.APIModel); err != nil {
+			return nil, err
+		}
+		c.checked[m.APIModel] = true
+	}
+
+	if len(c.messages) == 0 && systemPrompt != "" {
+		c.messages = append(c.messages, ollama.Message{Role: "system", Content: systemPrompt})
+	}
+	c.messages = append(c.messages, ollama.Message{Role: "user", Content: userMessage})
+
+	config := ollamaConfig(m)
+	config.Stream = true
+	config.ReasoningCallback = func(data string) {
+		currentTUI().Reasoning(data)
+		currentEvents().Delta("reasoning", data)
+	}
+
+	logNum := GetNextAPILogNumber()
+	request := ollama.Request{Model: m.APIModel, Messages: c.messages, Stream: true}
+	if data, err := json.MarshalIndent(request, "", "  "); err == nil {
+		if err := util.WriteLog(GetTimestampedAgentsPath("api", fmt.Sprintf("%05d.input.json", logNum)), data); err != nil {
+			util.Errorf("Failed to log request: %v", err)
+		}
+	}
+
+	response, err := ollama.HandleChat(ctx, c.messages, config)
+	if err != nil {
+		// drop the unanswered message so a retry does not send it twice
+		c.messages = c.messages[:len(c.messages)-1]
+		return nil, fmt.Errorf("ollama API error: %w", err)
+	}
+	if response.Message.Content != "" {
+		c.messages = append(c.messages, response.Message)
+	}
+
+	if data, err := json.MarshalIndent(response, "", "  "); err == nil {
+		if err := util.WriteLog(GetTimestampedAgentsPath("api", fmt.Sprintf("%05d.output.json", logNum)), data); err != nil {
+			util.Errorf("Failed to log response: %v", err)
+		}
+	}
+	logOllamaConversation(logNum, c.messages)
+
+	return response, nil
+}
+
+// logOllamaConversation writes the text log of the conversation
+func logOllamaConversation(logNum int, messages []ollama.Message) {
+	var content strings.Builder
+	for _, msg := range messages {
+		switch msg.Role {
+		case "system":
+			content.WriteString(util.NinaSystemPromptStart + "\n" + msg.Content + "\n" + util.NinaSystemPromptEnd + "\n\n")
+		case "user":
+			content.WriteString(util.NinaInputStart + "\n" + msg.Content + "\n" + util.NinaInputEnd + "\n\n")
+		case "assistant":
+			content.WriteString(msg.Content + "\n\n")
+		}
+	}
+	logFile := GetTimestampedAgentsPath("text", fmt.Sprintf("%05d.txt", logNum))
+	if err := util.WriteLog(logFile, []byte(content.String())); err != nil {
+		util.Errorf("Failed to write text log: %v", err)
+	}
+}
+
+// Call makes a basic API call without store functionality.
+func (c *OllamaClient) Call(ctx context.Context, model, systemPrompt, userMessage string) (any, error) {
+	return c.CallWithStore(ctx, model, systemPrompt, userMessage)
+}
+
+// GetTokenUsage extracts token usage information from an Ollama response
+func (c *OllamaClient) GetTokenUsage(resp any) (int, int, int) {
+	if r, ok := resp.(*ollama.ChatResponse); ok {
+		return r.PromptEvalCount, r.EvalCount, r.PromptEvalCount + r.EvalCount
+	}
+	return 0, 0, 0
+}
+
+// GetDetailedUsage returns detailed token usage for the response
+func (c *OllamaClient) GetDetailedUsage(resp any) TokenUsage {
+	if r, ok := resp.(*ollama.ChatResponse); ok {
+		return OllamaTokenUsage(r)
+	}
+	return TokenUsage{}
+}
+
+// RecordTurn records a response from another model, see turnRecorder
+func (c *OllamaClient) RecordTurn(userMessage, response string) {
+	i := turnStart(len(c.messages), func(i int) (string, string) {
+		return c.messages[i].Role, c.messages[i].Content
+	}, userMessage)
+	c.messages = append(c.messages[:i],
+		ollama.Message{Role: "user", Content: userMessage},
+		ollama.Message{Role: "assistant", Content: response},
+	)
+}
+
+// CompactMessages removes old messages keeping the system message and the
+// most recent pairs
+func (c *OllamaClient) CompactMessages(keepRecentPairs int) CompactionResult {
+	result := CompactionResult{}
+	keepCount := 1 + (keepRecentPairs * 2)
+	if len(c.messages) <= keepCount {
+		return result
+	}
+	removedMessages := c.messages[1 : len(c.messages)-keepCount+1]
+	for _, msg := range removedMessages {
+		result.TokensRemoved += len(msg.Content) / 4
+	}
+	result.MessagesRemoved = len(removedMessages)
+	newMessages := []ollama.Message{c.messages[0]}
+	c.messages = append(newMessages, c.messages[len(c.messages)-keepCount+1:]...)
+	return result
+}
+
+// SupportsTools returns false, tool calls go through the Nina protocol
+func (c *OllamaClient) SupportsTools() bool {
+	return false
+}
+
+// CallWithTools returns an error as Ollama tool calling is not used.
+func (c *OllamaClient) CallWithTools(ctx context.Context, model, systemPrompt, userMessage string, tools []any) (any, error) {
+	return nil, fmt.Errorf("ollama does not support tool calling")
+}
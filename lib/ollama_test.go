@@ -0,0 +1,80 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nathants/nina/models"
+)
+
+func TestEnsureOllamaModel(t *testing.T) {
+	pulled := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/tags":
+			_, _ = fmt.Fprint(w, `{"models": [{"name": "llama3:latest"}]}`)
+		case "/api/pull":
+			pulled = "qwen3"
+			_, _ = fmt.Fprintln(w, `{"status": "success"}`)
+		}
+	}))
+	defer server.Close()
+	t.Setenv("OLLAMA_URL", server.URL)
+
+	ctx := context.Background()
+	if err := EnsureOllamaModel(ctx, "llama3"); err != nil {
+		t.Errorf("installed model: %v", err)
+	}
+	t.Setenv("NINA_OLLAMA_PULL", "0")
+	if err := EnsureOllamaModel(ctx, "qwen3"); err == nil || !strings.Contains(err.Error(), "ollama pull qwen3") || pulled != "" {
+		t.Errorf("want the pull refused, got %v", err)
+	}
+	t.Setenv("NINA_OLLAMA_PULL", "1")
+	if err := EnsureOllamaModel(ctx, "qwen3"); err != nil || pulled != "qwen3" {
+		t.Errorf("want the model pulled, got %v", err)
+	}
+}
+
+func TestParseRecordedOllamaResponse(t *testing.T) {
+	output := `{"model": "llama3:latest", "message": {"role": "assistant", "content": "hello"}, "done": true, "done_reason": "length", "prompt_eval_count": 12, "eval_count": 3}`
+	resp, err := parseRecordedResponse([]byte(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Text != "hello" || resp.Usage.Input != 12 || resp.Usage.Output != 3 || resp.StopReason != StopMaxTokens {
+		t.Errorf("parseRecordedResponse() = %+v", resp)
+	}
+	if got := loggedReply(map[string]any{"message": map[string]any{"role": "assistant", "content": "hello"}}); got != "hello" {
+		t.Errorf("loggedReply() = %q, want the ollama message", got)
+	}
+}
+
+func TestOllamaWindow(t *testing.T) {
+	t.Setenv("NINA_OLLAMA_NUM_CTX", "")
+	for _, tc := range []struct {
+		name                string
+		m                   models.Model
+		trained, configured int
+		window, output      int
+	}{
+		{"trained capped", models.Model{}, 131072, 0, 32768, 8192},
+		{"trained below cap", models.Model{}, 8192, 0, 8192, 2048},
+		{"unknown", models.Model{}, 0, 0, 32768, 8192},
+		{"modelfile", models.Model{}, 131072, 65536, 65536, 16384},
+		{"num_ctx", models.Model{NumCtx: 4096, MaxOutput: 1024}, 131072, 65536, 4096, 1024},
+		{"max output too large", models.Model{ContextWindow: 16384, MaxOutput: 32000}, 0, 0, 16384, 4096},
+	} {
+		m := ollamaWindow(tc.m, tc.trained, tc.configured)
+		if m.ContextWindow != tc.window || m.NumCtx != tc.window || m.MaxOutput != tc.output {
+			t.Errorf("%s: window %d, num_ctx %d, max output %d, want %d and %d", tc.name, m.ContextWindow, m.NumCtx, m.MaxOutput, tc.window, tc.output)
+		}
+	}
+	t.Setenv("NINA_OLLAMA_NUM_CTX", "65536")
+	if m := ollamaWindow(models.Model{}, 131072, 0); m.ContextWindow != 65536 {
+		t.Errorf("window %d, want NINA_OLLAMA_NUM_CTX", m.ContextWindow)
+	}
+}
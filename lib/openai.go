@@ -3,6 +3,7 @@ package lib
 import (
 	"context"
 	"fmt"
+	"github.com/nathants/nina/models"
 	openai "github.com/nathants/nina/providers/openai"
 	util "github.com/nathants/nina/util"
 	"os"
@@ -11,10 +12,15 @@ import (
 
 // OpenAIClient wraps the nina-providers OpenAI functionality with store support
 // maintains conversation state using previous_message_id for efficient API calls
-// only sends the most recent message instead of full message history
+// only sends the most recent message instead of full message history, and
+// resends the full history when the stored chain has expired
 type OpenAIClient struct {
 	responseID string
-	messages   []openai.ChatMessage // Full message history for logging
+	system     string
+	messages   []openai.ChatMessage // Full message history for logging and resends
+	// pending holds function calls from the last turn, the next user message
+	// answers them since tool results are reported as text
+	pending []openai.FunctionCall
 }
 
 // NewOpenAIClient creates a new OpenAI client and loads any saved response ID
@@ -65,12 +71,17 @@ func (c *OpenAIClient) RestoreMessages(messages []any) error {
 			continue
 		}
 
+		// assistant turns are resent as output text when the chain expires
+		contentType := "input_text"
+		if role == "assistant" {
+			contentType = "output_text"
+		}
 		chatMsg := openai.ChatMessage{
 			Type: "message",
 			Role: role,
 			Content: []openai.ContentPart{
 				{
-					Type: "input_text",
+					Type: contentType,
 					Text: text,
 				},
 			},
@@ -81,99 +92,105 @@ func (c *OpenAIClient) RestoreMessages(messages []any) error {
 	return nil
 }
 
+// RecordTurn records a response from another model, see turnRecorder. The
+// stored chain does not include it, so the next call resends the history.
+func (c *OpenAIClient) RecordTurn(userMessage, response string) {
+	i := turnStart(len(c.messages), func(i int) (string, string) {
+		text := ""
+		if len(c.messages[i].Content) > 0 {
+			text = c.messages[i].Content[0].Text
+		}
+		return c.messages[i].Role, text
+	}, userMessage)
+	c.messages = append(c.messages[:i],
+		openai.ChatMessage{Type: "message", Role: "user", Content: []openai.ContentPart{{Type: "input_text", Text: userMessage}}},
+		openai.ChatMessage{Type: "message", Role: "assistant", Content: []openai.ContentPart{{Type: "output_text", Text: response}}},
+	)
+	c.responseID = ""
+	c.pending = nil
+}
+
+// userTurn builds the input items of the next user message, answering pending
+// function calls first with their results
+func (c *OpenAIClient) userTurn(userMessage string, results []ToolCall) []openai.ChatMessage {
+	var items []openai.ChatMessage
+	for i, call := range c.pending {
+		items = append(items, openai.ChatMessage{Type: "function_call_output", CallID: call.CallID, Output: toolResult(results, i, call.CallID)})
+	}
+	return append(items, openai.ChatMessage{
+		Type:    "message",
+		Role:    "user",
+		Content: []openai.ContentPart{{Type: "input_text", Text: userMessage}},
+	})
+}
+
 // CallWithStore calls OpenAI API with store=true using previous_message_id for efficiency
 func (c *OpenAIClient) CallWithStore(ctx context.Context, model, systemPrompt, userMessage string) (any, error) {
-	var effort string
-	var temp float64
-	switch model {
-	case "o3", "o3-flex":
-		effort = "medium"
-	case "o4-mini", "o4-mini-flex":
-		// o4-mini doesn't support reasoning.effort
-		effort = "medium"
-	case "gpt-4.1":
-		temp = 0.6
-	default:
-		panic("unknown model: " + model)
+	return c.call(ctx, model, systemPrompt, userMessage, nil)
+}
+
+func (c *OpenAIClient) call(ctx context.Context, model, systemPrompt, userMessage string, tools []openai.Tool) (any, error) {
+	m, err := registryModel(model, models.ProviderOpenAI)
+	if err != nil {
+		return nil, err
 	}
-	// Build request using previous_message_id for efficiency
 	req := openai.Request{
-		Model:  model,
-		Store:  true,
-		Stream: true,
-	}
-	if temp != 0 {
-		req.Temperature = &temp
+		Model:       m.APIModel,
+		Store:       true,
+		Stream:      true,
+		ServiceTier: m.ServiceTier,
+		Background:  m.Background,
+		Temperature: m.Temperature,
+		Tools:       tools,
 	}
-	// Set service tier for flex models and convert model name
-	if strings.HasSuffix(model, "-flex") {
-		req.ServiceTier = "flex"
-		// Convert o4-mini-flex to o4-mini for API call
-		req.Model = strings.TrimSuffix(model, "-flex")
-	}
-	if effort != "" {
+	if m.Effort != "" {
 		req.Reasoning = &openai.ReasoningRequest{
 			Summary: "auto",
-			Effort:  effort,
+			Effort:  m.Effort,
 		}
 	}
 
+	if c.system == "" {
+		c.system = systemPrompt
+	}
+
 	// When using previous_message_id, only send the new user message
+	results := ToolResults(ctx)
 	if c.responseID != "" {
 		req.PreviousID = c.responseID
-		req.Input = []openai.ChatMessage{
-			{
-				Type: "message",
-				Role: "user",
-				Content: []openai.ContentPart{
-					{
-						Type: "input_text",
-						Text: userMessage,
-					},
-				},
-			},
-		}
+		req.Input = c.userTurn(userMessage, results)
 	} else {
-		// First message - include system prompt
-		req.Input = []openai.ChatMessage{
-			{
-				Type: "message",
-				Role: "system",
-				Content: []openai.ContentPart{
-					{
-						Type: "input_text",
-						Text: systemPrompt,
-					},
-				},
-			},
-			{
-				Type: "message",
-				Role: "user",
-				Content: []openai.ContentPart{
-					{
-						Type: "input_text",
-						Text: userMessage,
-					},
-				},
-			},
-		}
+		// First message, or no chain to continue, send everything
+		req.Input = c.fullHistory(userMessage, results)
 	}
 
 	// fmt.Println(util.Pformat(req))
 
 	// Call OpenAI API using nina-providers
-	handleResp, err := openai.Handle(ctx, req, func(data string) {
+	reasoningCallback := func(data string) {
 		// fmt.Printf("%s%s%s\n", ColorGreen, data, ColorReset)
-	})
+		currentTUI().Reasoning(data)
+		currentEvents().Delta("reasoning", data)
+	}
+	handleResp, err := openai.Handle(ctx, req, reasoningCallback)
+	if err != nil && req.PreviousID != "" && isExpiredChain(err) {
+		// stored responses expire, so rebuild the conversation from history
+		util.Verbosef("openai response %s expired, resending %d messages", req.PreviousID, len(c.messages)+1)
+		c.responseID = ""
+		req.PreviousID = ""
+		req.Input = c.fullHistory(userMessage, results)
+		handleResp, err = openai.Handle(ctx, req, reasoningCallback)
+	}
 	if err != nil {
 		return nil, err
 	}
 
 	// Create a response structure compatible with the rest of the code
 	resp := &openai.Response{
-		ID:    handleResp.ResponseID,
-		Model: model,
-		Usage: *handleResp.Usage,
+		ID:     handleResp.ResponseID,
+		Model:  model,
+		Status: handleResp.Status,
+		Usage:  *handleResp.Usage,
 		Output: []openai.Output{
 			{
 				Role:   "assistant",
@@ -189,25 +206,25 @@ func (c *OpenAIClient) CallWithStore(ctx context.Context, model, systemPrompt, u
 		},
 	}
 
+	if handleResp.Model != "" {
+		resp.Model = handleResp.Model
+	}
+	if handleResp.IncompleteReason != "" {
+		resp.IncompleteDetails = openai.IncompleteDetails{Reason: handleResp.IncompleteReason}
+	}
+
 	// Store the new responseID only after successful API call
 	if resp.ID != "" {
 		c.responseID = resp.ID
 	}
 
-	// Add user message to history if not already there (for first message)
-	if userMessage != "" {
-		userMsg := openai.ChatMessage{
-			Type: "message",
-			Role: "user",
-			Content: []openai.ContentPart{
-				{
-					Type: "input_text",
-					Text: userMessage,
-				},
-			},
-		}
-		c.messages = append(c.messages, userMsg)
+	// Add user message to history if not already there (for first message),
+	// after the answers to the function calls it follows
+	turn := c.userTurn(userMessage, results)
+	if userMessage == "" {
+		turn = turn[:len(turn)-1]
 	}
+	c.messages = append(c.messages, turn...)
 
 	// Add assistant response to history
 	if handleResp.Text != "" {
@@ -223,16 +240,43 @@ func (c *OpenAIClient) CallWithStore(ctx context.Context, model, systemPrompt, u
 		}
 		c.messages = append(c.messages, assistantMsg)
 	}
+	for _, call := range handleResp.FunctionCalls {
+		c.messages = append(c.messages, openai.ChatMessage{Type: "function_call", CallID: call.CallID, Name: call.Name, Arguments: call.Arguments})
+		resp.Output = append(resp.Output, openai.Output{Type: "function_call", Status: "completed", CallID: call.CallID, Name: call.Name, Arguments: call.Arguments})
+	}
+	c.pending = handleResp.FunctionCalls
 
 	// Log API call
 	err = c.logAPICall(&req, resp)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to log API call: %v\n", err)
+		util.Errorf("Failed to log API call: %v", err)
 	}
 
 	return resp, nil
 }
 
+// fullHistory returns the system prompt, the conversation so far, and the new
+// user message, for calls that do not continue a stored response
+func (c *OpenAIClient) fullHistory(userMessage string, results []ToolCall) []openai.ChatMessage {
+	input := []openai.ChatMessage{}
+	if c.system != "" && (len(c.messages) == 0 || c.messages[0].Role != "system") {
+		input = append(input, openai.ChatMessage{
+			Type:    "message",
+			Role:    "system",
+			Content: []openai.ContentPart{{Type: "input_text", Text: c.system}},
+		})
+	}
+	input = append(input, c.messages...)
+	return append(input, c.userTurn(userMessage, results)...)
+}
+
+// isExpiredChain reports whether an api error means previous_response_id
+// no longer refers to a stored response
+func isExpiredChain(err error) bool {
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "previous response") || strings.Contains(msg, "previous_response_id")
+}
+
 func (c *OpenAIClient) logAPICall(req *openai.Request, resp *openai.Response) error {
 	// Get the next log number
 	logNum := GetNextAPILogNumber()
@@ -243,8 +287,8 @@ func (c *OpenAIClient) logAPICall(req *openai.Request, resp *openai.Response) er
 
 	// Log actual sent request to agents/debug/
 	debugPath := GetTimestampedAgentsPath("debug", fmt.Sprintf("%05d.input.json", logNum))
-	if err := os.WriteFile(debugPath, []byte(util.Pformat(reqCopy)), 0644); err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to write debug log: %v\n", err)
+	if err := util.WriteLog(debugPath, []byte(util.Pformat(reqCopy))); err != nil {
+		util.Errorf("Failed to write debug log: %v", err)
 	}
 
 	// For agents/api/, show full message history
@@ -253,6 +297,7 @@ func (c *OpenAIClient) logAPICall(req *openai.Request, resp *openai.Response) er
 		Store:       req.Store,
 		Stream:      req.Stream,
 		ServiceTier: req.ServiceTier,
+		Background:  req.Background,
 		Reasoning:   req.Reasoning,
 		Input:       c.messages, // Use full message history
 	}
@@ -272,7 +317,7 @@ func (c *OpenAIClient) logAPICall(req *openai.Request, resp *openai.Response) er
 		if len(inputTexts) > 0 {
 			textPath := GetTimestampedAgentsPath("text", fmt.Sprintf("%05d.input.txt", logNum))
 			combinedText := strings.Join(inputTexts, "\n\n")
-			if err := os.WriteFile(textPath, []byte(combinedText), 0644); err != nil {
+			if err := util.WriteLog(textPath, []byte(combinedText)); err != nil {
 				return fmt.Errorf("failed to write input text: %w", err)
 			}
 		}
@@ -289,7 +334,7 @@ func (c *OpenAIClient) logAPICall(req *openai.Request, resp *openai.Response) er
 			}
 		}
 		textPath := GetTimestampedAgentsPath("text", fmt.Sprintf("%05d.output.txt", logNum))
-		err := os.WriteFile(textPath, []byte(val), 0644)
+		err := util.WriteLog(textPath, []byte(val))
 		if err != nil {
 			return fmt.Errorf("failed to write output text: %w", err)
 		}
@@ -298,13 +343,13 @@ func (c *OpenAIClient) logAPICall(req *openai.Request, resp *openai.Response) er
 	// fmt.Println(GetTimestampedAgentsPath("api", fmt.Sprintf("%05d.*.json", logNum)))
 
 	jsonPath := GetTimestampedAgentsPath("api", fmt.Sprintf("%05d.input.json", logNum))
-	err := os.WriteFile(jsonPath, []byte(util.Pformat(reqWithHistory)), 0644)
+	err := util.WriteLog(jsonPath, []byte(util.Pformat(reqWithHistory)))
 	if err != nil {
 		return fmt.Errorf("failed to write API log: %w", err)
 	}
 
 	jsonPath = GetTimestampedAgentsPath("api", fmt.Sprintf("%05d.output.json", logNum))
-	err = os.WriteFile(jsonPath, []byte(util.Pformat(respCopy)), 0644)
+	err = util.WriteLog(jsonPath, []byte(util.Pformat(respCopy)))
 	if err != nil {
 		return fmt.Errorf("failed to write API log: %w", err)
 	}
@@ -363,9 +408,13 @@ func (c *OpenAIClient) SupportsTools() bool {
 	return true
 }
 
-// CallWithTools calls OpenAI API with tool definitions.
+// CallWithTools calls OpenAI API with functions, tools holds
+// prompts.ToolDefinition values and defaults to the nina tools when empty.
+// Function calls are returned as function_call outputs of the openai.Response.
 func (c *OpenAIClient) CallWithTools(ctx context.Context, model, systemPrompt, userMessage string, tools []any) (any, error) {
-	// For now, just call the regular method
-	// In a full implementation, this would add functions to the request
-	return c.CallWithStore(ctx, model, systemPrompt, userMessage)
+	var functions []openai.Tool
+	for _, def := range toolDefinitions(tools) {
+		functions = append(functions, openai.Tool{Type: "function", Name: def.Name, Description: def.Description, Parameters: toolSchema(def)})
+	}
+	return c.call(ctx, model, systemPrompt, userMessage, functions)
 }
@@ -0,0 +1,51 @@
+// Tests for the OpenAI client covering the full history resend used when a
+// stored previous_response_id chain has expired
+package lib
+
+import (
+	"errors"
+	"testing"
+
+	openai "github.com/nathants/nina/providers/openai"
+)
+
+func TestOpenAIFullHistory(t *testing.T) {
+	client := &OpenAIClient{system: "sys"}
+	if err := client.RestoreMessages([]any{
+		map[string]any{"role": "user", "content": []any{map[string]any{"text": "one"}}},
+		map[string]any{"role": "assistant", "content": []any{map[string]any{"text": "two"}}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	input := client.fullHistory("three", nil)
+	want := []struct{ role, kind, text string }{
+		{"system", "input_text", "sys"},
+		{"user", "input_text", "one"},
+		{"assistant", "output_text", "two"},
+		{"user", "input_text", "three"},
+	}
+	if len(input) != len(want) {
+		t.Fatalf("got %d messages, want %d", len(input), len(want))
+	}
+	for i, w := range want {
+		got := input[i]
+		if got.Role != w.role || got.Content[0].Type != w.kind || got.Content[0].Text != w.text {
+			t.Errorf("message %d = %+v, want %+v", i, got, w)
+		}
+	}
+
+	restored := &OpenAIClient{system: "sys", messages: []openai.ChatMessage{{Type: "message", Role: "system"}}}
+	if got := restored.fullHistory("x", nil); len(got) != 2 {
+		t.Errorf("a restored system message should not be repeated, got %d messages", len(got))
+	}
+}
+
+func TestIsExpiredChain(t *testing.T) {
+	expired := errors.New(`api error: {"error": {"message": "Previous response with id 'resp_1' not found.", "param": "previous_response_id"}}`)
+	if !isExpiredChain(expired) {
+		t.Error("expected an expired chain")
+	}
+	if isExpiredChain(errors.New("api error: rate limited")) {
+		t.Error("unrelated errors are not an expired chain")
+	}
+}
@@ -0,0 +1,207 @@
+// Task planning for nina run. The first response writes a checklist with a
+// NinaPlan tag, which is stored as TODO.md at the git root. The model ticks
+// items by editing TODO.md, the remaining items are sent with every message,
+// and NinaStop is refused while items marked (critical) are unticked. A
+// TODO.md nina did not write, one without planMarker, is never read as the
+// plan or overwritten, planning is off for the run instead. Planning needs a
+// tool processor that asks for the plan, see Planner.
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/nathants/nina/util"
+	"github.com/nathants/nina/workspace"
+)
+
+// maxRefusedStops is how many times NinaStop is refused for unfinished
+// critical items before the run is allowed to stop anyway
+const maxRefusedStops = 3
+
+// criticalMarker marks an item that must be ticked before stopping
+const criticalMarker = "(critical)"
+
+// planMarker is the first line of a TODO.md written by nina
+const planMarker = "<!-- nina plan, written by nina run -->"
+
+// ErrForeignPlan is returned for a TODO.md nina did not write
+var ErrForeignPlan = errors.New("TODO.md exists and was not written by nina")
+
+// Planner is implemented by tool processors that ask for a NinaPlan with
+// PlanSuggestion and apply it, runs with other processors do not plan
+type Planner interface {
+	Plans() bool
+}
+
+// planPrompt asks for the plan on the first message
+const planPrompt = `Before doing any other work, plan the task. Add a <NinaPlan> tag to your <NinaOutput> containing a markdown checklist of concrete steps, one per line like "- [ ] step", and start the steps that must be done before the task is complete with "(critical)", such as "- [ ] (critical) make the tests pass". The plan is saved as TODO.md. As you complete each step tick it with a <NinaChange> to TODO.md, changing "- [ ]" to "- [x]". Send a new <NinaPlan> to replace the plan when it changes. <NinaStop> is refused while critical steps are unticked.`
+
+// PlanItem is one checklist line of TODO.md
+type PlanItem struct {
+	Text     string
+	Done     bool
+	Critical bool
+}
+
+var planItemRegex = regexp.MustCompile(`^\s*[-*] \[([ xX])\]\s+(.*)$`)
+
+// PlanPath returns the TODO.md file holding the plan
+func PlanPath() string {
+	return filepath.Join(util.GetGitRoot(), "TODO.md")
+}
+
+// ParsePlan returns the checklist items of a markdown document, other lines
+// are ignored
+func ParsePlan(text string) []PlanItem {
+	var items []PlanItem
+	for _, line := range strings.Split(text, "\n") {
+		match := planItemRegex.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		item := PlanItem{Text: strings.TrimSpace(match[2]), Done: match[1] != " "}
+		if rest, ok := strings.CutPrefix(item.Text, criticalMarker); ok {
+			item.Text = strings.TrimSpace(rest)
+			item.Critical = true
+		}
+		if item.Text != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// FormatPlan renders items as a markdown checklist
+func FormatPlan(items []PlanItem) string {
+	var b strings.Builder
+	for _, item := range items {
+		box := "[ ]"
+		if item.Done {
+			box = "[x]"
+		}
+		text := item.Text
+		if item.Critical {
+			text = criticalMarker + " " + text
+		}
+		fmt.Fprintf(&b, "- %s %s\n", box, text)
+	}
+	return b.String()
+}
+
+// readPlan returns the contents of TODO.md, nil when it is missing, and
+// ErrForeignPlan when nina did not write it
+func readPlan() ([]byte, error) {
+	data, err := workspace.Current().ReadFile(PlanPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(string(data), planMarker) {
+		return nil, ErrForeignPlan
+	}
+	return data, nil
+}
+
+// LoadPlan reads the plan from TODO.md, a missing file is an empty plan
+func LoadPlan() ([]PlanItem, error) {
+	data, err := readPlan()
+	if err != nil {
+		return nil, err
+	}
+	return ParsePlan(string(data)), nil
+}
+
+// SavePlan writes items to TODO.md, refusing to overwrite one nina did not
+// write
+func SavePlan(items []PlanItem) error {
+	if _, err := readPlan(); err != nil {
+		return err
+	}
+	return workspace.Current().WriteFile(PlanPath(), []byte(planMarker+"\n# TODO\n\n"+FormatPlan(items)), 0644)
+}
+
+// canPlan reports whether a run with processor plans, warning when a TODO.md
+// nina did not write is in the way
+func canPlan(processor ToolProcessor) bool {
+	if p, ok := processor.(Planner); !ok || !p.Plans() {
+		return false
+	}
+	if _, err := readPlan(); err != nil {
+		LogError("Warning: not planning, %v, move it or pass --no-plan", err)
+		return false
+	}
+	return true
+}
+
+// remainingItems returns the unticked items, only critical ones if critical
+func remainingItems(items []PlanItem, critical bool) []PlanItem {
+	var remaining []PlanItem
+	for _, item := range items {
+		if !item.Done && (item.Critical || !critical) {
+			remaining = append(remaining, item)
+		}
+	}
+	return remaining
+}
+
+// PlanSuggestion returns the planning feedback for the next message: the
+// request for a plan when there is none yet, otherwise the unticked items
+func PlanSuggestion() string {
+	items, err := LoadPlan()
+	if err != nil {
+		LogError("Failed to read %s: %v", PlanPath(), err)
+		return ""
+	}
+	if len(items) == 0 {
+		return planPrompt
+	}
+	remaining := remainingItems(items, false)
+	if len(remaining) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("Remaining TODO.md items, %d of %d:\n%s", len(remaining), len(items), strings.TrimSuffix(FormatPlan(remaining), "\n"))
+}
+
+// refuseStop reports whether NinaStop must be refused because critical plan
+// items are unticked, suggesting the model finish them first
+func refuseStop(state *LoopState) bool {
+	if !state.Planning || state.RefusedStops >= maxRefusedStops {
+		return false
+	}
+	items, err := LoadPlan()
+	if err != nil {
+		LogError("Failed to read %s: %v", PlanPath(), err)
+		return false
+	}
+	critical := remainingItems(items, true)
+	if len(critical) == 0 {
+		return false
+	}
+	state.RefusedStops++
+	LogError("Warning: refusing NinaStop (%d/%d), %d critical TODO.md items remain", state.RefusedStops, maxRefusedStops, len(critical))
+	suggest(fmt.Sprintf("Your NinaStop was refused because these critical TODO.md items are not ticked:\n%s\nFinish them and tick them in TODO.md, or if one is no longer needed send a new <NinaPlan> without it.", strings.TrimSuffix(FormatPlan(critical), "\n")))
+	return true
+}
+
+// applyNinaPlan replaces TODO.md with the checklist of a NinaPlan tag
+func applyNinaPlan(plan string) ProcessorEvent {
+	event := ProcessorEvent{Type: "NinaPlan", Filepath: PlanPath()}
+	items := ParsePlan(plan)
+	if len(items) == 0 {
+		event.Reason = `no checklist items found, use one "- [ ] step" per line`
+		return event
+	}
+	if err := SavePlan(items); err != nil {
+		event.Reason = err.Error()
+		return event
+	}
+	event.Stdout = fmt.Sprintf("%d items, %d critical", len(items), len(remainingItems(items, true)))
+	return event
+}
@@ -0,0 +1,75 @@
+// Tests for TODO.md plan parsing and the remaining item feedback
+package lib
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"reflect"
+	"testing"
+)
+
+func TestParsePlan(t *testing.T) {
+	text := `# TODO
+
+- [ ] (critical) add the parser
+- [x] read the code
+* [X] (critical) write tests
+- [ ]
+not an item
+  - [ ] nested step`
+	want := []PlanItem{
+		{Text: "add the parser", Critical: true},
+		{Text: "read the code", Done: true},
+		{Text: "write tests", Done: true, Critical: true},
+		{Text: "nested step"},
+	}
+	got := ParsePlan(text)
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("ParsePlan() = %+v, want %+v", got, want)
+	}
+	if again := ParsePlan(FormatPlan(got)); !reflect.DeepEqual(again, want) {
+		t.Errorf("round trip = %+v", again)
+	}
+	if critical := remainingItems(got, true); len(critical) != 1 || critical[0].Text != "add the parser" {
+		t.Errorf("remaining critical = %+v", critical)
+	}
+	if remaining := remainingItems(got, false); len(remaining) != 2 {
+		t.Errorf("remaining = %+v", remaining)
+	}
+}
+
+// planProcessor is a tool processor that plans
+type planProcessor struct{ ToolProcessor }
+
+func (planProcessor) Plans() bool { return true }
+
+func TestForeignPlan(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if out, err := exec.Command("git", "init", "-q").CombinedOutput(); err != nil {
+		t.Fatalf("git init: %v %s", err, out)
+	}
+	if !canPlan(planProcessor{}) || canPlan(nil) {
+		t.Error("want planning only for a processor that plans")
+	}
+	items := []PlanItem{{Text: "write tests", Critical: true}}
+	if err := SavePlan(items); err != nil {
+		t.Fatal(err)
+	}
+	if loaded, err := LoadPlan(); err != nil || !reflect.DeepEqual(loaded, items) {
+		t.Errorf("LoadPlan() = %+v, %v", loaded, err)
+	}
+
+	if err := os.WriteFile(PlanPath(), []byte("- [ ] (critical) the user's item\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadPlan(); !errors.Is(err, ErrForeignPlan) {
+		t.Errorf("LoadPlan() = %v, want ErrForeignPlan", err)
+	}
+	if err := SavePlan(items); !errors.Is(err, ErrForeignPlan) {
+		t.Errorf("SavePlan() = %v, want ErrForeignPlan", err)
+	}
+	if canPlan(planProcessor{}) {
+		t.Error("want no planning with a foreign TODO.md")
+	}
+}
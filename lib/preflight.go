@@ -0,0 +1,80 @@
+// Pre-flight context window checks. A prompt that cannot fit the model's
+// context window is sent to its long context variant when it has one, like
+// sonnet-1m for sonnet, and otherwise fails before the api call with the
+// counts, instead of a round trip to a provider 400.
+package lib
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/nathants/nina/models"
+	"github.com/nathants/nina/util"
+)
+
+// ErrContextWindow is returned for a prompt too large for the model
+var ErrContextWindow = errors.New("prompt exceeds context window")
+
+// InputBudget returns the prompt tokens model accepts, its context window
+// less the output it reserves, or 0 when the window is unknown
+func InputBudget(m models.Model) int {
+	if m.ContextWindow <= 0 {
+		return 0
+	}
+	return m.ContextWindow - m.MaxOutput
+}
+
+// CheckContextWindow returns the estimated prompt tokens for a message sent
+// after history tokens of conversation, and ErrContextWindow when they exceed
+// the model's input budget. Prompts are estimated locally and only counted
+// with the provider's tokenizer when within 10% of the budget.
+func CheckContextWindow(m models.Model, history int, system, message string) (int, error) {
+	tokens := history
+	tokens += util.CalculateMessageTokens("user", message)
+	if system != "" {
+		tokens += util.CalculateSystemPromptTokens(system)
+	}
+	budget := InputBudget(m)
+	if budget <= 0 || tokens < budget*9/10 {
+		return tokens, nil
+	}
+	tokens = history + CountTokens(m, system, message)
+	if tokens > budget {
+		return tokens, fmt.Errorf("%w: %s tokens, %s accepts %s (a %s window less %s output), send fewer files or start a new session",
+			ErrContextWindow, FormatTokens(tokens), m.Alias, FormatTokens(budget), FormatTokens(m.ContextWindow), FormatTokens(m.MaxOutput))
+	}
+	return tokens, nil
+}
+
+// FitModel returns the long context variant of m when a message sent after
+// history tokens of conversation does not fit m's input budget, otherwise m
+func FitModel(m models.Model, history int, system, message string) models.Model {
+	if m.LongContext == "" {
+		return m
+	}
+	if _, err := CheckContextWindow(m, history, system, message); !errors.Is(err, ErrContextWindow) {
+		return m
+	}
+	long, err := models.Lookup(m.LongContext)
+	if err != nil || long.Provider != m.Provider {
+		return m
+	}
+	return long
+}
+
+// compactKeepPairs is how many recent user and assistant pairs compaction keeps
+const compactKeepPairs = 4
+
+// compactHistory drops old messages from the provider's history when the
+// next message does not fit m's window, reporting whether any were dropped
+func compactHistory(provider AIProvider, state *LoopState, m models.Model) bool {
+	result := provider.CompactMessages(compactKeepPairs)
+	if result.MessagesRemoved == 0 {
+		return false
+	}
+	state.ContextTokens = max(state.ContextTokens-result.TokensRemoved, 0)
+	state.PromptTokens = max(state.PromptTokens-result.TokensRemoved, 0)
+	LogStderr("Compacted %d messages, about %s tokens, to fit the %s context window of %s",
+		result.MessagesRemoved, FormatTokens(result.TokensRemoved), FormatTokens(m.ContextWindow), m.Alias)
+	return true
+}
@@ -0,0 +1,92 @@
+package lib
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/nathants/nina/models"
+)
+
+func TestCheckContextWindow(t *testing.T) {
+	m := models.Model{Alias: "tiny", Provider: models.ProviderOpenAI, ContextWindow: 120, MaxOutput: 20}
+	if InputBudget(m) != 100 {
+		t.Fatalf("InputBudget() = %d, want 100", InputBudget(m))
+	}
+	if _, err := CheckContextWindow(m, 0, "be brief", "hello"); err != nil {
+		t.Errorf("unexpected error for a small prompt: %v", err)
+	}
+	if _, err := CheckContextWindow(m, 95, "", "hello there"); !errors.Is(err, ErrContextWindow) {
+		t.Errorf("expected history to count toward the window, got %v", err)
+	}
+	if _, err := CheckContextWindow(models.Model{Provider: models.ProviderOpenAI}, 1_000_000, "", "hi"); err != nil {
+		t.Errorf("expected no check for an unknown window, got %v", err)
+	}
+}
+
+func TestCallAIProviderContextWindow(t *testing.T) {
+	mock := NewMockClient("ok")
+	state := &LoopState{}
+	_, err := CallAIProvider(mock, "o3", "system", strings.Repeat("word ", 250_000), state, false)
+	if !errors.Is(err, ErrContextWindow) {
+		t.Fatalf("expected ErrContextWindow, got %v", err)
+	}
+	if len(mock.Messages) != 0 {
+		t.Errorf("expected no call to the provider")
+	}
+	if _, err := CallAIProvider(mock, "o3", "system", "hello", state, false); err != nil {
+		t.Fatal(err)
+	}
+	if state.ContextTokens == 0 {
+		t.Errorf("expected the conversation to be counted")
+	}
+}
+
+// compactingClient is a MockClient whose history compacts once
+type compactingClient struct {
+	*MockClient
+	removed int
+}
+
+func (c *compactingClient) CompactMessages(keepRecentPairs int) CompactionResult {
+	removed := c.removed
+	c.removed = 0
+	return CompactionResult{MessagesRemoved: removed / 1000, TokensRemoved: removed}
+}
+
+func TestCallAIProviderCompacts(t *testing.T) {
+	t.Setenv("NINA_TOKENIZER", "local")
+	budget := InputBudget(models.MustLookup("o3"))
+	client := &compactingClient{MockClient: NewMockClient("ok", "ok"), removed: budget}
+	state := &LoopState{ContextTokens: budget + 1000}
+	if _, err := CallAIProvider(client, "o3", "system", "hello", state, false); err != nil {
+		t.Fatalf("expected the history compacted to fit, got %v", err)
+	}
+	state.ContextTokens = budget + 1000
+	if _, err := CallAIProvider(client, "o3", "system", "hello", state, false); !errors.Is(err, ErrContextWindow) {
+		t.Errorf("expected ErrContextWindow when nothing compacts, got %v", err)
+	}
+}
+
+func TestFitModelLongContext(t *testing.T) {
+	t.Setenv("NINA_TOKENIZER", "local")
+	sonnet := models.MustLookup("sonnet")
+	if m := FitModel(sonnet, 0, "system", "hello"); m.Alias != "sonnet" {
+		t.Errorf("expected sonnet for a small prompt, got %s", m.Alias)
+	}
+	if m := FitModel(sonnet, 300_000, "", "hello"); m.Alias != "sonnet-1m" || len(m.Betas) == 0 {
+		t.Errorf("expected sonnet-1m for a large conversation, got %+v", m)
+	}
+	if m := FitModel(models.MustLookup("o3"), 300_000, "", "hello"); m.Alias != "o3" {
+		t.Errorf("expected o3 without a long context variant, got %s", m.Alias)
+	}
+
+	mock := NewMockClient("ok")
+	state := &LoopState{Model: "sonnet", ContextTokens: 300_000}
+	if _, err := CallAIProvider(mock, "sonnet", "system", "hello", state, false); err != nil {
+		t.Fatal(err)
+	}
+	if state.Model != "sonnet-1m" || len(mock.Messages) != 1 {
+		t.Errorf("expected the call to continue with sonnet-1m, got %s", state.Model)
+	}
+}
@@ -1,13 +1,18 @@
 package lib
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 
 	// Removed lib/tools import - functions moved to util
+	"github.com/nathants/nina/memory"
 	"github.com/nathants/nina/prompts"
 	util "github.com/nathants/nina/util"
+	"github.com/nathants/nina/workspace"
 )
 
 // ProcessorResult contains the result of processing output
@@ -30,6 +35,21 @@ type ProcessorEvent struct {
 	Stdout       string
 	Stderr       string
 	Reason       string
+	// AlreadyApplied marks a NinaChange identical to one applied earlier in
+	// the session, skipped instead of failing to match again
+	AlreadyApplied bool
+	// Diff is the unified diff of an applied NinaChange
+	Diff string
+	// Malformed marks a NinaChange skipped for broken tags, the other
+	// changes of the response still apply
+	Malformed bool
+	// Unfenced marks a NinaChange applied without the code fences wrapping
+	// its NinaSearch and NinaReplace, see unfence
+	Unfenced bool
+	// StdoutFile and StderrFile hold the full output of a NinaBash when it
+	// was too long to return, see util.SpillOutput
+	StdoutFile string
+	StderrFile string
 }
 
 // Event represents a logged event (for stdout output)
@@ -41,7 +61,7 @@ type Event struct {
 }
 
 // ProcessOutput processes the AI output and executes any commands
-func ProcessOutput(output string, _ *LoopState, _ bool) ProcessorResult {
+func ProcessOutput(output string, state *LoopState, _ bool) ProcessorResult {
 	result := ProcessorResult{
 		Events: []ProcessorEvent{},
 	}
@@ -49,10 +69,10 @@ func ProcessOutput(output string, _ *LoopState, _ bool) ProcessorResult {
 	// Extract and print NinaMessage if present
 	ninaMessage, err := util.ExtractNinaMessage(output)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to extract NinaMessage: %v\n", err)
+		util.Errorf("Failed to extract NinaMessage: %v", err)
 	} else if ninaMessage != "" {
 		// Print NinaMessage to stderr with blue color
-		fmt.Fprintf(os.Stderr, "%s| %s |%s\n", ColorBlue, ninaMessage, ColorReset)
+		util.Printf(util.LogNormal, "%s| %s |%s\n", ColorBlue, ninaMessage, ColorReset)
 	}
 
 	// Check for NinaStop but don't return immediately
@@ -60,7 +80,7 @@ func ProcessOutput(output string, _ *LoopState, _ bool) ProcessorResult {
 	if err != nil {
 		// Convert NinaStop parsing errors to feedback
 		errorMsg := fmt.Sprintf("Failed to parse NinaStop: %v", err)
-		fmt.Fprintf(os.Stderr, "%s\n", errorMsg)
+		util.Errorf("%s", errorMsg)
 
 		// Add error feedback to results so AI can fix it
 		resultStr := fmt.Sprintf("%s\n<NinaSuggestion>%s. Please check your XML formatting.</NinaSuggestion>\n%s",
@@ -69,10 +89,10 @@ func ProcessOutput(output string, _ *LoopState, _ bool) ProcessorResult {
 	}
 	foundNinaStop := stopReason != ""
 
-	// Process NinaChange blocks
-	ninaOutput, err := util.ExtractSingle(output, util.NinaOutputStart, util.NinaOutputEnd)
+	// Check the response is whole before running anything in it
+	ninaOutput, err := checkResponse(output, state)
 	if err != nil {
-		result.Error = fmt.Errorf("failed to extract NinaOutput: %v", err)
+		result.Error = err
 		return result
 	}
 	if ninaOutput == "" {
@@ -80,38 +100,230 @@ func ProcessOutput(output string, _ *LoopState, _ bool) ProcessorResult {
 		return result
 	}
 
+	// Drop tool tags newer than the session's protocol
+	if checked, results := checkProtocol(ninaOutput, state); len(results) > 0 {
+		output = strings.Replace(output, ninaOutput, checked, 1)
+		ninaOutput = checked
+		result.Results = append(result.Results, results...)
+	}
+
+	// Process NinaChange blocks
+
 	changes, err := util.ExtractAll(ninaOutput, util.NinaStart, util.NinaEnd)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to extract NinaChange blocks: %v\n", err)
+		util.Errorf("Failed to extract NinaChange blocks: %v", err)
 	}
-	for _, change := range changes {
-		event := applyNinaChange(change)
+	for _, event := range applyNinaChanges(changes, state) {
 		result.Events = append(result.Events, event)
 		// Add result to be returned for feedback
-		resultStr := fmt.Sprintf("%s\n<NinaChange>%s</NinaChange>\n%s", util.NinaResultStart, event.Filepath, util.NinaResultEnd)
-		if event.Reason != "" {
+		resultStr := fmt.Sprintf("%s\n<NinaChange>%s</NinaChange>\n%s%s", util.NinaResultStart, event.Filepath, diffResult(event), util.NinaResultEnd)
+		if event.AlreadyApplied {
+			resultStr = fmt.Sprintf("%s\n<NinaChange>%s</NinaChange>\n<NinaSuggestion>This change was already applied earlier in the session, it was skipped. Do not send it again.</NinaSuggestion>\n%s", util.NinaResultStart, event.Filepath, util.NinaResultEnd)
+		} else if event.Reason != "" {
 			resultStr = fmt.Sprintf("%s\n<NinaChange>%s</NinaChange>\n<NinaError>%s</NinaError>\n%s", util.NinaResultStart, event.Filepath, event.Reason, util.NinaResultEnd)
+		} else if event.Unfenced {
+			resultStr = fmt.Sprintf("%s\n<NinaChange>%s</NinaChange>\n%s<NinaSuggestion>This change was applied without the code fences around its NinaSearch and NinaReplace. Send raw file content in them, without code fences.</NinaSuggestion>\n%s", util.NinaResultStart, event.Filepath, diffResult(event), util.NinaResultEnd)
 		}
 		result.Results = append(result.Results, resultStr)
 		// Also print to stdout for immediate visibility
 	}
 
+	// Process NinaDelete and NinaRename blocks
+	fileOps, err := util.ParseFileOps(ninaOutput)
+	if err != nil {
+		util.Errorf("Failed to parse file operations: %v", err)
+		resultStr := fmt.Sprintf("%s\n<NinaSuggestion>Failed to parse NinaDelete or NinaRename: %v</NinaSuggestion>\n%s",
+			util.NinaResultStart, err, util.NinaResultEnd)
+		result.Results = append(result.Results, resultStr)
+	}
+	for _, op := range fileOps {
+		if op.Op == util.FileOpRename {
+			currentEvents().ToolStart("NinaRename", "", op.Path)
+		} else {
+			currentEvents().ToolStart("NinaDelete", "", op.Path)
+		}
+		event := applyFileOp(op)
+		result.Events = append(result.Events, event)
+		tag := "<" + event.Type + ">"
+		endTag := "</" + event.Type + ">"
+		target := event.Filepath
+		if op.Op == util.FileOpRename {
+			target = op.Path + " -> " + op.Dest
+		}
+		resultStr := fmt.Sprintf("%s\n%s%s%s\n%s", util.NinaResultStart, tag, target, endTag, util.NinaResultEnd)
+		if event.Reason != "" {
+			resultStr = fmt.Sprintf("%s\n%s%s%s\n<NinaError>%s</NinaError>\n%s", util.NinaResultStart, tag, target, endTag, event.Reason, util.NinaResultEnd)
+		}
+		result.Results = append(result.Results, resultStr)
+	}
+
+	// Process NinaPlan blocks, the last one replaces TODO.md
+	plans, err := util.ExtractAll(ninaOutput, util.NinaPlanStart, util.NinaPlanEnd)
+	if err != nil {
+		util.Errorf("Failed to extract NinaPlan: %v", err)
+	}
+	if len(plans) > 0 {
+		currentEvents().ToolStart("NinaPlan", "", PlanPath())
+		event := applyNinaPlan(plans[len(plans)-1])
+		result.Events = append(result.Events, event)
+		resultStr := fmt.Sprintf("%s\n<NinaPlan>%s</NinaPlan>\n%s", util.NinaResultStart, event.Stdout, util.NinaResultEnd)
+		if event.Reason != "" {
+			resultStr = fmt.Sprintf("%s\n<NinaPlan></NinaPlan>\n<NinaError>%s</NinaError>\n%s", util.NinaResultStart, event.Reason, util.NinaResultEnd)
+		} else {
+			util.Printf(util.LogNormal, "%s| Plan [%s] |%s\n", ColorBlue, event.Stdout, ColorReset)
+		}
+		result.Results = append(result.Results, resultStr)
+	}
+
+	// Process NinaRemember blocks
+	facts, err := util.ParseNinaRemember(output)
+	if err != nil {
+		util.Errorf("Failed to parse NinaRemember: %v", err)
+	}
+	for _, fact := range facts {
+		currentEvents().ToolStart("NinaRemember", "", "")
+		event := ProcessorEvent{Type: "NinaRemember"}
+		m, err := memory.Remember(fact)
+		if err != nil {
+			event.Reason = err.Error()
+		} else {
+			event.Stdout = m.ID
+			util.Printf(util.LogNormal, "%s| Remember [%s] |%s\n", ColorBlue, m.ID, ColorReset)
+		}
+		result.Events = append(result.Events, event)
+		resultStr := fmt.Sprintf("%s\n<NinaRemember>%s</NinaRemember>\n%s", util.NinaResultStart, m.ID, util.NinaResultEnd)
+		if event.Reason != "" {
+			resultStr = fmt.Sprintf("%s\n<NinaRemember></NinaRemember>\n<NinaError>%s</NinaError>\n%s", util.NinaResultStart, event.Reason, util.NinaResultEnd)
+		}
+		result.Results = append(result.Results, resultStr)
+	}
+
 	// Process NinaBash blocks
 	bashCmds, err := util.ParseNinaBash(output)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to parse NinaBash: %v\n", err)
+		util.Errorf("Failed to parse NinaBash: %v", err)
 	}
 	for _, bashCmd := range bashCmds {
-		fmt.Fprintf(os.Stderr, "%s| Bash [%s %s] |%s\n", ColorBlue, bashCmd.Command, strings.Join(bashCmd.Args, " "), ColorReset)
+		util.Printf(util.LogNormal, "%s| Bash [%s %s] |%s\n", ColorBlue, bashCmd.Command, strings.Join(bashCmd.Args, " "), ColorReset)
+		currentEvents().ToolStart("NinaBash", strings.TrimSpace(bashCmd.Command+" "+strings.Join(bashCmd.Args, " ")), "")
 		event := executeNinaBash(bashCmd)
+		if state != nil && state.summarizer != nil {
+			state.summarizer.summarize(state, &event)
+		}
 		result.Events = append(result.Events, event)
 		// Add result to be returned for feedback
 		cmdStr := bashCmd.Command
 		if len(bashCmd.Args) > 0 {
 			cmdStr = bashCmd.Command + " " + strings.Join(bashCmd.Args, " ")
 		}
-		resultStr := fmt.Sprintf("%s\n<NinaCmd>%s</NinaCmd>\n<NinaExit>%d</NinaExit>\n<NinaStdout>%s</NinaStdout>\n<NinaStderr>%s</NinaStderr>\n%s",
-			util.NinaResultStart, cmdStr, event.ExitCode, event.Stdout, event.Stderr, util.NinaResultEnd)
+		resultStr := fmt.Sprintf("%s\n<NinaCmd>%s</NinaCmd>\n<NinaCwd>%s</NinaCwd>\n<NinaExit>%d</NinaExit>\n<NinaStdout>%s</NinaStdout>\n<NinaStderr>%s</NinaStderr>\n%s",
+			util.NinaResultStart, cmdStr, event.Cwd, event.ExitCode, event.Stdout, event.Stderr, util.NinaResultEnd)
+		result.Results = append(result.Results, resultStr)
+	}
+
+	// Process NinaKill then NinaSpawn blocks, so one response can restart a
+	// process
+	kills, err := util.ParseNinaKill(output)
+	if err != nil {
+		util.Errorf("Failed to parse NinaKill: %v", err)
+		result.Results = append(result.Results, fmt.Sprintf("%s\n<NinaSuggestion>Failed to parse NinaKill: %v</NinaSuggestion>\n%s", util.NinaResultStart, err, util.NinaResultEnd))
+	}
+	for _, id := range kills {
+		currentEvents().ToolStart("NinaKill", strconv.Itoa(id), "")
+		status, err := killProcess(id)
+		status.ID = id
+		event, resultStr := spawnResult("NinaKill", status, err)
+		result.Events = append(result.Events, event)
+		result.Results = append(result.Results, resultStr)
+	}
+	spawns, err := util.ParseNinaSpawn(output)
+	if err != nil {
+		util.Errorf("Failed to parse NinaSpawn: %v", err)
+		result.Results = append(result.Results, fmt.Sprintf("%s\n<NinaSuggestion>Failed to parse NinaSpawn: %v</NinaSuggestion>\n%s", util.NinaResultStart, err, util.NinaResultEnd))
+	}
+	for _, req := range spawns {
+		var status SpawnStatus
+		if req.Command != "" {
+			currentEvents().ToolStart("NinaSpawn", req.Command, "")
+			util.Printf(util.LogNormal, "%s| Spawn [%s] |%s\n", ColorBlue, req.Command, ColorReset)
+			status, err = spawnProcess(state, req.Command)
+		} else {
+			currentEvents().ToolStart("NinaSpawn", strconv.Itoa(req.ID), "")
+			status, err = pollProcess(req.ID)
+			status.ID = req.ID
+		}
+		event, resultStr := spawnResult("NinaSpawn", status, err)
+		result.Events = append(result.Events, event)
+		result.Results = append(result.Results, resultStr)
+	}
+
+	// Process NinaRead blocks
+	reads, err := util.ParseNinaRead(output)
+	if err != nil {
+		util.Errorf("Failed to parse NinaRead: %v", err)
+		resultStr := fmt.Sprintf("%s\n<NinaSuggestion>Failed to parse NinaRead: %v</NinaSuggestion>\n%s",
+			util.NinaResultStart, err, util.NinaResultEnd)
+		result.Results = append(result.Results, resultStr)
+	}
+	for _, req := range reads {
+		currentEvents().ToolStart("NinaRead", "", req.Path)
+		read := util.ExecuteRead(req)
+		event := ProcessorEvent{Type: "NinaRead", Filepath: read.Path, Reason: read.Error}
+		result.Events = append(result.Events, event)
+		resultStr := fmt.Sprintf("%s\n<NinaRead>%s</NinaRead>\n<NinaLines>%s</NinaLines>\n<NinaContent>\n%s\n</NinaContent>\n%s",
+			util.NinaResultStart, read.Path, read.Summary(), util.EscapeNinaTags(read.Content), util.NinaResultEnd)
+		if read.Error != "" {
+			resultStr = fmt.Sprintf("%s\n<NinaRead>%s</NinaRead>\n<NinaError>%s</NinaError>\n%s", util.NinaResultStart, read.Path, read.Error, util.NinaResultEnd)
+		} else {
+			util.Printf(util.LogNormal, "%s| Read [%s %s] |%s\n", ColorBlue, read.Path, read.Summary(), ColorReset)
+		}
+		result.Results = append(result.Results, resultStr)
+	}
+
+	// Process NinaGrep and NinaGlob blocks
+	for _, tool := range []struct {
+		name, start, end, unit string
+		execute                func(util.SearchRequest) util.SearchResult
+	}{
+		{"NinaGrep", util.NinaGrepStart, util.NinaGrepEnd, "match", util.ExecuteGrep},
+		{"NinaGlob", util.NinaGlobStart, util.NinaGlobEnd, "file", util.ExecuteGlob},
+	} {
+		reqs, err := util.ParseNinaSearch(output, tool.start, tool.end)
+		if err != nil {
+			util.Errorf("Failed to parse %s: %v", tool.name, err)
+			resultStr := fmt.Sprintf("%s\n<NinaSuggestion>Failed to parse %s: %v</NinaSuggestion>\n%s",
+				util.NinaResultStart, tool.name, err, util.NinaResultEnd)
+			result.Results = append(result.Results, resultStr)
+		}
+		for _, req := range reqs {
+			currentEvents().ToolStart(tool.name, req.Pattern, req.Path)
+			search := tool.execute(req)
+			event := ProcessorEvent{Type: tool.name, Cmd: req.Pattern, Filepath: req.Path, Stdout: search.Content(), Reason: search.Error}
+			result.Events = append(result.Events, event)
+			resultStr := fmt.Sprintf("%s\n%s%s%s\n<NinaMatches>%s</NinaMatches>\n<NinaContent>\n%s\n</NinaContent>\n%s",
+				util.NinaResultStart, tool.start, req.Pattern, tool.end, search.Summary(tool.unit), search.Content(), util.NinaResultEnd)
+			if search.Error != "" {
+				resultStr = fmt.Sprintf("%s\n%s%s%s\n<NinaError>%s</NinaError>\n%s", util.NinaResultStart, tool.start, req.Pattern, tool.end, search.Error, util.NinaResultEnd)
+			} else {
+				util.Printf(util.LogNormal, "%s| %s [%s] %s |%s\n", ColorBlue, strings.TrimPrefix(tool.name, "Nina"), req.Pattern, search.Summary(tool.unit), ColorReset)
+			}
+			result.Results = append(result.Results, resultStr)
+		}
+	}
+
+	// Process NinaAgent blocks after the other tools, so children see their effects
+	agents, err := util.ExtractAll(ninaOutput, util.NinaAgentStart, util.NinaAgentEnd)
+	if err != nil {
+		util.Errorf("Failed to extract NinaAgent: %v", err)
+	}
+	for _, agent := range agents {
+		currentEvents().ToolStart("NinaAgent", "", "")
+		event := runAgent(agent, state)
+		result.Events = append(result.Events, event)
+		resultStr := fmt.Sprintf("%s\n<NinaAgent>%s</NinaAgent>\n%s", util.NinaResultStart, event.Stdout, util.NinaResultEnd)
+		if event.Reason != "" {
+			resultStr = fmt.Sprintf("%s\n<NinaAgent></NinaAgent>\n<NinaError>%s</NinaError>\n%s", util.NinaResultStart, event.Reason, util.NinaResultEnd)
+		}
 		result.Results = append(result.Results, resultStr)
 	}
 
@@ -128,6 +340,149 @@ func ProcessOutput(output string, _ *LoopState, _ bool) ProcessorResult {
 	return result
 }
 
+// spawnResult returns the event and NinaResult of a NinaSpawn or NinaKill
+func spawnResult(tool string, status SpawnStatus, err error) (ProcessorEvent, string) {
+	event := ProcessorEvent{Type: tool, Cmd: status.Command, ExitCode: status.ExitCode, Stdout: status.Output}
+	if err != nil {
+		event.Reason = err.Error()
+		return event, fmt.Sprintf("%s\n<%s>%d</%s>\n<NinaError>%s</NinaError>\n%s", util.NinaResultStart, tool, status.ID, tool, err, util.NinaResultEnd)
+	}
+	exit := ""
+	if !status.Running {
+		exit = fmt.Sprintf("<NinaExit>%d</NinaExit>\n", status.ExitCode)
+	}
+	return event, fmt.Sprintf("%s\n<%s>%d</%s>\n<NinaCmd>%s</NinaCmd>\n<NinaRunning>%t</NinaRunning>\n%s<NinaStdout>%s</NinaStdout>\n%s",
+		util.NinaResultStart, tool, status.ID, tool, status.Command, status.Running, exit, status.Output, util.NinaResultEnd)
+}
+
+// maxValidateOutput bounds the validation output fed back to the model
+const maxValidateOutput = 4000
+
+// MaxDiffTokens bounds the diff of each change fed back to the model
+const MaxDiffTokens = 400
+
+// diffResult returns the NinaDiff tag for an applied change, "" without one
+func diffResult(event ProcessorEvent) string {
+	if event.Diff == "" || event.Reason != "" {
+		return ""
+	}
+	return util.NinaDiffStart + "\n" + util.TruncateDiff(event.Diff, MaxDiffTokens) + util.NinaDiffEnd + "\n"
+}
+
+// applyNinaChanges applies a response's NinaChange blocks as one transaction.
+// Nothing is written unless every change applies, and when NINA_VALIDATE is
+// set it runs after writing and a failure restores every file. Changes
+// already applied this session, as recorded in state, are skipped, and so are
+// malformed blocks, which are reported without failing the others.
+func applyNinaChanges(changes []string, state *LoopState) []ProcessorEvent {
+	if len(changes) == 0 {
+		return nil
+	}
+	base := workspace.Current()
+	tx := workspace.NewTransaction(base)
+	workspace.SetCurrent(tx)
+	events := make([]ProcessorEvent, 0, len(changes))
+	keys := make([]string, 0, len(changes))
+	failed := ""
+	for _, change := range changes {
+		path, _ := util.ExtractSingle(change, util.NinaPathStart, util.NinaPathEnd)
+		currentEvents().ToolStart("NinaChange", "", strings.TrimSpace(path))
+		key := changeKey(change)
+		keys = append(keys, key)
+		if _, err := util.CheckNinaChange(change); err != nil {
+			util.Errorf("skipping malformed NinaChange [%s]: %v", strings.TrimSpace(path), err)
+			events = append(events, ProcessorEvent{Type: "NinaChange", Filepath: strings.TrimSpace(path), Reason: fmt.Sprintf("not applied, malformed: %v", err), Malformed: true})
+			continue
+		}
+		if alreadyApplied(state, key, change) {
+			util.Printf(util.LogNormal, "%s| Already applied [%s] |%s\n", ColorBlue, strings.TrimSpace(path), ColorReset)
+			events = append(events, ProcessorEvent{Type: "NinaChange", Filepath: strings.TrimSpace(path), Stdout: "already applied", AlreadyApplied: true})
+			continue
+		}
+		event := applyNinaChange(change)
+		if event.Reason != "" && failed == "" {
+			failed = event.Filepath
+		}
+		events = append(events, event)
+	}
+	workspace.SetCurrent(base)
+
+	reason := ""
+	if failed != "" {
+		reason = fmt.Sprintf("not applied, the change to %s failed and changes in a response are applied all or none", failed)
+	} else if err := tx.Commit(); err != nil {
+		reason = fmt.Sprintf("not applied, writing changes failed: %v", err)
+	} else if command := os.Getenv("NINA_VALIDATE"); command != "" {
+		util.Printf(util.LogNormal, "%s| Validate [%s] |%s\n", ColorBlue, command, ColorReset)
+		output, err := workspace.BashCommand(command).CombinedOutput()
+		if err != nil {
+			out := strings.TrimSpace(util.Redact(string(output)))
+			if len(out) > maxValidateOutput {
+				out = out[len(out)-maxValidateOutput:]
+			}
+			reason = fmt.Sprintf("reverted, validation `%s` failed: %v\n%s", command, err, out)
+			if err := tx.Rollback(); err != nil {
+				reason += fmt.Sprintf("\nrestoring files failed: %v", err)
+			}
+		}
+	}
+	if reason != "" {
+		util.Errorf("%s", strings.SplitN(reason, "\n", 2)[0])
+		for i := range events {
+			if events[i].AlreadyApplied || events[i].Malformed {
+				continue
+			}
+			if events[i].Reason == "" || strings.HasPrefix(reason, "reverted") {
+				events[i].Reason = strings.TrimSpace(events[i].Reason + "\n" + reason)
+				events[i].LinesChanged = 0
+				events[i].Diff = ""
+			}
+		}
+		return events
+	}
+	if state != nil {
+		if state.AppliedChanges == nil {
+			state.AppliedChanges = map[string]bool{}
+		}
+		for i, event := range events {
+			if !event.AlreadyApplied && !event.Malformed {
+				state.AppliedChanges[keys[i]] = true
+			}
+		}
+	}
+	return events
+}
+
+// changeKey hashes the path, search and replace of a NinaChange block,
+// ignoring surrounding whitespace
+func changeKey(change string) string {
+	hash := sha256.New()
+	for _, tag := range [][2]string{
+		{util.NinaPathStart, util.NinaPathEnd},
+		{util.NinaSearchStart, util.NinaSearchEnd},
+		{util.NinaReplaceStart, util.NinaReplaceEnd},
+	} {
+		value, _ := util.ExtractSingle(change, tag[0], tag[1])
+		hash.Write([]byte(strings.TrimSpace(value)))
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// alreadyApplied reports whether an identical change was applied earlier in
+// the session and the file still holds its replacement, so applying it again
+// would fail to find the search text or repeat the edit
+func alreadyApplied(state *LoopState, key, change string) bool {
+	if state == nil || !state.AppliedChanges[key] {
+		return false
+	}
+	path, _ := util.ExtractSingle(change, util.NinaPathStart, util.NinaPathEnd)
+	replace, _ := util.ExtractSingle(change, util.NinaReplaceStart, util.NinaReplaceEnd)
+	data, err := workspace.Current().ReadFile(strings.TrimSpace(path))
+	content, _ := util.NormalizeText(string(data))
+	return err == nil && strings.Contains(content, util.UnescapeNinaTags(strings.TrimSpace(replace)))
+}
+
 func applyNinaChange(change string) ProcessorEvent {
 	// Extract NinaPath
 	filepath, err := util.ExtractSingle(change, util.NinaPathStart, util.NinaPathEnd)
@@ -139,9 +494,9 @@ func applyNinaChange(change string) ProcessorEvent {
 	}
 	filepath = strings.TrimSpace(filepath)
 
-	// Extract NinaSearch
+	// Extract NinaSearch, content tags come escaped
 	searchText, _ := util.ExtractSingle(change, util.NinaSearchStart, util.NinaSearchEnd)
-	searchText = strings.TrimSpace(searchText)
+	searchText = util.UnescapeNinaTags(strings.TrimSpace(searchText))
 
 	// Extract NinaReplace
 	replaceText, err := util.ExtractSingle(change, util.NinaReplaceStart, util.NinaReplaceEnd)
@@ -152,15 +507,25 @@ func applyNinaChange(change string) ProcessorEvent {
 			Reason:   "Missing NinaReplace",
 		}
 	}
+	replaceText = util.UnescapeNinaTags(replaceText)
 
-	// Use shared executor
+	// Use shared executor, retrying a failed search without code fences
 	result := util.ExecuteChange(filepath, searchText, replaceText)
+	unfenced := false
+	if result.Stderr != "" && searchText != "" {
+		if search, replace, ok := unfence(searchText, replaceText); ok {
+			if retry := util.ExecuteChange(filepath, search, replace); retry.Error+retry.Stderr == "" {
+				result, unfenced = retry, true
+			}
+		}
+	}
 
-	if result.Error != "" {
+	// a search that does not match is reported on Stderr, it still fails the change
+	if reason := result.Error + result.Stderr; reason != "" {
 		return ProcessorEvent{
 			Type:     "NinaChange",
 			Filepath: result.FilePath,
-			Reason:   result.Error,
+			Reason:   reason,
 		}
 	}
 
@@ -168,6 +533,29 @@ func applyNinaChange(change string) ProcessorEvent {
 		Type:         "NinaChange",
 		Filepath:     result.FilePath,
 		LinesChanged: result.LinesChanged,
+		Diff:         result.Diff,
+		Unfenced:     unfenced,
+	}
+}
+
+func applyFileOp(op util.FileOp) ProcessorEvent {
+	var result util.ChangeResult
+	eventType := "NinaDelete"
+	if op.Op == util.FileOpRename {
+		eventType = "NinaRename"
+		util.Printf(util.LogNormal, "%s| Rename [%s -> %s] |%s\n", ColorBlue, op.Path, op.Dest, ColorReset)
+		result = util.ExecuteRename(op.Path, op.Dest)
+	} else {
+		util.Printf(util.LogNormal, "%s| Delete [%s] |%s\n", ColorBlue, op.Path, ColorReset)
+		result = util.ExecuteDelete(op.Path)
+	}
+
+	return ProcessorEvent{
+		Type:     eventType,
+		Filepath: result.FilePath,
+		Args:     result.Args,
+		Stdout:   result.Stdout,
+		Reason:   result.Error,
 	}
 }
 
@@ -176,13 +564,15 @@ func executeNinaBash(bashCmd util.BashCommand) ProcessorEvent {
 	result := util.ExecuteBash(bashCmd)
 
 	return ProcessorEvent{
-		Type:     "NinaBash",
-		Cwd:      result.Cwd,
-		Cmd:      result.Cmd,
-		Args:     result.Args,
-		ExitCode: result.ExitCode,
-		Stdout:   result.Stdout,
-		Stderr:   result.Stderr,
+		Type:       "NinaBash",
+		Cwd:        result.Cwd,
+		Cmd:        result.Cmd,
+		Args:       result.Args,
+		ExitCode:   result.ExitCode,
+		Stdout:     result.Stdout,
+		Stderr:     result.Stderr,
+		StdoutFile: result.StdoutFile,
+		StderrFile: result.StderrFile,
 	}
 }
 
@@ -197,7 +587,7 @@ func LogEvent(event Event) {
 }
 
 func IsDebugMode() bool {
-	return os.Getenv("DEBUG") != ""
+	return util.LogEnabled(util.LogDebug)
 }
 
 // LoadSystemPromptWithXML loads SYSTEM.md and XML.md prompts.
@@ -213,3 +603,16 @@ func LoadSystemPromptWithXML() string {
 	}
 	return string(systemPrompt) + "\n" + string(xmlPrompt)
 }
+
+// LoadSystemPromptWithNative loads the system prompt for native tool calling
+func LoadSystemPromptWithNative() string {
+	systemPrompt, err := prompts.EmbeddedFiles.ReadFile("SYSTEM.md")
+	if err != nil {
+		panic(err)
+	}
+	nativePrompt, err := prompts.EmbeddedFiles.ReadFile("NATIVE.md")
+	if err != nil {
+		panic(err)
+	}
+	return string(systemPrompt) + "\n" + string(nativePrompt)
+}
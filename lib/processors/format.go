@@ -0,0 +1,25 @@
+package processors
+
+import (
+	"fmt"
+
+	"github.com/nathants/nina/lib"
+)
+
+// Tool formats of nina run --tool-format
+const (
+	ToolFormatXML    = "xml"    // Nina tags in the response text
+	ToolFormatNative = "native" // the provider's tool calling
+)
+
+// ForFormat returns the tool processor of a tool format
+func ForFormat(format string) (lib.ToolProcessor, error) {
+	switch format {
+	case "", ToolFormatXML:
+		return &XMLToolProcessor{}, nil
+	case ToolFormatNative:
+		return &JSONToolProcessor{}, nil
+	default:
+		return nil, fmt.Errorf("unknown tool format %q, use %s or %s", format, ToolFormatXML, ToolFormatNative)
+	}
+}
@@ -1,19 +1,23 @@
-// JSON tool processor for nina tools command using native JSON tool calling.
+// JSON tool processor for nina tools and nina run --tool-format native using
+// provider-native tool calling. Tool calls are rendered as the Nina tags of
+// the XML path and run by lib.ProcessOutput, so both formats share executors,
+// checks, and results, and differ only in how the model calls tools.
 package processors
 
 import (
 	"encoding/json"
 	"fmt"
-	"os"
+	"strconv"
 	"strings"
 
 	"github.com/nathants/nina/lib"
+	"github.com/nathants/nina/memory"
 	// Removed lib/tools import
 	"github.com/nathants/nina/prompts"
 	"github.com/nathants/nina/util"
 )
 
-// JSONToolProcessor processes AI responses containing JSON tool calls.
+// JSONToolProcessor processes AI responses containing native tool calls.
 type JSONToolProcessor struct {
 	Tools []prompts.ToolDefinition // Tool definitions for the AI
 }
@@ -26,59 +30,135 @@ type ToolCall struct {
 	Arguments map[string]interface{} `json:"arguments"`
 }
 
-// ProcessResponse processes the AI response and executes any JSON tool calls.
-func (j *JSONToolProcessor) ProcessResponse(response string, state *lib.LoopState) lib.ProcessorResult {
-	result := lib.ProcessorResult{
-		Events: []lib.ProcessorEvent{},
-	}
+// toolCallTags maps the arguments of each native tool to the Nina tags of its
+// XML form, in order, an empty tag is the content of the tool's own tag
+var toolCallTags = map[string][][2]string{
+	"NinaBash":     {{"command", ""}, {"stdin", "NinaStdin"}, {"cwd", "NinaCwd"}},
+	"NinaRead":     {{"path", "NinaPath"}, {"start_line", "NinaStartLine"}, {"end_line", "NinaEndLine"}, {"pattern", "NinaPattern"}},
+	"NinaGrep":     {{"pattern", "NinaPattern"}, {"path", "NinaPath"}, {"include", "NinaInclude"}},
+	"NinaGlob":     {{"pattern", "NinaPattern"}, {"path", "NinaPath"}},
+	"NinaSpawn":    {{"command", "NinaCmd"}, {"id", "NinaId"}},
+	"NinaKill":     {{"id", "NinaId"}},
+	"NinaChange":   {{"path", "NinaPath"}, {"search", "NinaSearch"}, {"replace", "NinaReplace"}},
+	"NinaDelete":   {{"path", "NinaPath"}},
+	"NinaRename":   {{"path", "NinaPath"}, {"dest", "NinaDest"}},
+	"NinaRemember": {{"content", ""}},
+	"NinaAgent":    {{"task", "NinaTask"}, {"model", "NinaModel"}, {"max_tokens", "NinaMaxTokens"}},
+	"NinaStop":     {{"reason", ""}},
+}
 
-	// For JSON tool calling, the response should contain tool_calls
-	// This is a simplified version - in reality we'd need to parse the full API response
-	// For now, we'll look for a specific format in the response
+// escapedTags hold file content, whose Nina tags are escaped as in the file
+// content the model is shown
+var escapedTags = map[string]bool{"NinaSearch": true, "NinaReplace": true}
 
-	// Check for stop condition first
-	if strings.Contains(response, "I'll stop here") || strings.Contains(response, "task is complete") {
-		result.StopReason = "Task completed"
-		return result
+// toolArgument formats an argument of a tool call as tag content
+func toolArgument(value any) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(v)
 	}
-
-	// In a real implementation, we would parse the API response structure
-	// For this prototype, we'll return empty results
-	// The actual JSON tool execution would happen in the provider's CallWithTools method
-
-	return result
 }
 
-func (x *JSONToolProcessor) GetSystemPrompt() string {
-	return lib.LoadSystemPromptWithXML()
+// wrapTag returns content inside tag, on lines of its own when it has several
+func wrapTag(tag, content string) string {
+	if strings.Contains(content, "\n") {
+		return fmt.Sprintf("<%s>\n%s\n</%s>\n", tag, content, tag)
+	}
+	return fmt.Sprintf("<%s>%s</%s>\n", tag, content, tag)
 }
 
-// FormatUserMessage formats the user message for JSON tool calling.
-func (j *JSONToolProcessor) FormatUserMessage(state *lib.LoopState, content string) (string, error) {
-	var message string
-
-	// Check for SUGGEST.md
-	suggestPath := util.GetGitRoot() + "/SUGGEST.md"
-	if data, err := os.ReadFile(suggestPath); err == nil && len(data) > 0 {
-		suggestContent := strings.TrimSpace(string(data))
-		if suggestContent != "" {
-			message = fmt.Sprintf("Suggestion: %s", suggestContent)
-			// Truncate SUGGEST.md after reading
-			if err := os.Truncate(suggestPath, 0); err != nil {
-				lib.LogStderr("Failed to truncate SUGGEST.md: %v", err)
+// renderToolCalls returns the NinaOutput of a response with native tool
+// calls, its text as the NinaMessage, and the results telling the model of
+// calls to unknown tools
+func renderToolCalls(text string, calls []lib.ToolCall) (string, []string) {
+	var b strings.Builder
+	var results []string
+	b.WriteString(util.NinaOutputStart + "\n")
+	if text = strings.TrimSpace(text); text != "" {
+		b.WriteString(wrapTag("NinaMessage", util.EscapeNinaTags(text)))
+	}
+	for _, call := range calls {
+		tags, ok := toolCallTags[call.Name]
+		if !ok {
+			results = append(results, fmt.Sprintf("%s\n<NinaError>unknown tool %s, use the tools you were given</NinaError>\n%s", util.NinaResultStart, call.Name, util.NinaResultEnd))
+			continue
+		}
+		var body, inner strings.Builder
+		for _, tag := range tags {
+			value := toolArgument(call.Arguments[tag[0]])
+			switch {
+			case tag[1] == "":
+				body.WriteString(value)
+			case value == "":
+			case escapedTags[tag[1]]:
+				inner.WriteString(wrapTag(tag[1], util.EscapeNinaTags(value)))
+			default:
+				inner.WriteString(wrapTag(tag[1], value))
+			}
+		}
+		content := body.String()
+		if inner.Len() > 0 {
+			if content = strings.TrimSpace(content); content != "" {
+				content += "\n"
 			}
+			content += strings.TrimSuffix(inner.String(), "\n")
 		}
+		b.WriteString(wrapTag(call.Name, content))
 	}
+	b.WriteString(util.NinaOutputEnd)
+	return b.String(), results
+}
 
-	// On first message, include the initial content
-	if state.StepNumber == 1 && content != "" {
-		if message != "" {
-			return fmt.Sprintf("%s\n\n%s", content, message), nil
+// ProcessResponse runs the native tool calls of the response, kept in
+// state.ToolCalls, as the Nina tags they stand for, one call at a time so the
+// result of each is kept in its ToolCall.Result and answers it in the next
+// call. A response written as Nina tags instead is run as is.
+func (j *JSONToolProcessor) ProcessResponse(response string, state *lib.LoopState) lib.ProcessorResult {
+	if len(state.ToolCalls) == 0 {
+		if strings.Contains(response, util.NinaOutputStart) {
+			return lib.ProcessOutput(response, state, false)
 		}
-		return content, nil
+		output, _ := renderToolCalls(response, nil)
+		return lib.ProcessOutput(output, state, false)
 	}
+	result := lib.ProcessorResult{Events: []lib.ProcessorEvent{}}
+	for i := range state.ToolCalls {
+		call := &state.ToolCalls[i]
+		text := ""
+		if i == 0 {
+			text = response
+		}
+		output, unknown := renderToolCalls(text, []lib.ToolCall{*call})
+		util.Verbosef("native tool call %s %s as %s", call.ID, call.Name, output)
+		run := lib.ProcessOutput(output, state, false)
+		results := append(run.Results, unknown...)
+		if run.Error != nil {
+			results = append(results, fmt.Sprintf("%s\n<NinaError>%v</NinaError>\n%s", util.NinaResultStart, run.Error, util.NinaResultEnd))
+		}
+		call.Result = strings.Join(results, "\n")
+		result.Events = append(result.Events, run.Events...)
+		if run.StopReason != "" {
+			result.StopReason = run.StopReason
+		}
+	}
+	return result
+}
+
+// GetSystemPrompt loads the system prompt for native tool calling.
+func (j *JSONToolProcessor) GetSystemPrompt() string {
+	return lib.LoadSystemPromptWithNative()
+}
 
-	return message, nil
+// FormatUserMessage formats the user message like the XML path, the native
+// tool calls it follows are answered with their results.
+func (j *JSONToolProcessor) FormatUserMessage(state *lib.LoopState, content string) (string, error) {
+	return (&XMLToolProcessor{}).FormatUserMessage(state, content)
 }
 
 // GetToolDefinitions returns the tool definitions for JSON tool calling.
@@ -96,6 +176,120 @@ func (j *JSONToolProcessor) GetToolDefinitions() []prompts.ToolDefinition {
 							Description: "your command",
 							Required:    true,
 						},
+						{
+							Name:        "stdin",
+							Type:        "string",
+							Description: "input sent to the command's stdin, for commands that prompt",
+						},
+						{
+							Name:        "cwd",
+							Type:        "string",
+							Description: "the directory to run the command in, default the repository root",
+						},
+					},
+				},
+			},
+			{
+				Name:        "NinaRead",
+				Description: "read numbered lines of a file, optionally a range or only lines matching a pattern",
+				InputSchema: prompts.ToolInputSchema{
+					Fields: []prompts.ToolField{
+						{
+							Name:        "path",
+							Type:        "string",
+							Description: "the absolute filepath to read (starts with `/` or `~/`)",
+							Required:    true,
+						},
+						{
+							Name:        "start_line",
+							Type:        "int",
+							Description: "the first line to read, default 1",
+						},
+						{
+							Name:        "end_line",
+							Type:        "int",
+							Description: "the last line to read, default 200 lines from the start, or the end of the file with a pattern",
+						},
+						{
+							Name:        "pattern",
+							Type:        "string",
+							Description: "a regular expression, only lines matching it are returned",
+						},
+					},
+				},
+			},
+			{
+				Name:        "NinaGrep",
+				Description: "search files for lines matching a regular expression",
+				InputSchema: prompts.ToolInputSchema{
+					Fields: []prompts.ToolField{
+						{
+							Name:        "pattern",
+							Type:        "string",
+							Description: "a regular expression",
+							Required:    true,
+						},
+						{
+							Name:        "path",
+							Type:        "string",
+							Description: "the directory or file to search, default the repository root",
+						},
+						{
+							Name:        "include",
+							Type:        "string",
+							Description: "a glob the searched files must match, like `*.go`",
+						},
+					},
+				},
+			},
+			{
+				Name:        "NinaGlob",
+				Description: "list files matching a glob pattern",
+				InputSchema: prompts.ToolInputSchema{
+					Fields: []prompts.ToolField{
+						{
+							Name:        "pattern",
+							Type:        "string",
+							Description: "a glob, `*` matches within a path element and `**` across them, a pattern without `/` matches file names",
+							Required:    true,
+						},
+						{
+							Name:        "path",
+							Type:        "string",
+							Description: "the directory to list, default the repository root",
+						},
+					},
+				},
+			},
+			{
+				Name:        "NinaSpawn",
+				Description: "start a long running command in the background, like a dev server, or look at the output of one",
+				InputSchema: prompts.ToolInputSchema{
+					Fields: []prompts.ToolField{
+						{
+							Name:        "command",
+							Type:        "string",
+							Description: "bash string that will be run as `bash -c \"$cmd\"` without waiting for it to exit",
+						},
+						{
+							Name:        "id",
+							Type:        "string",
+							Description: "the id of a background command to look at, instead of a command",
+						},
+					},
+				},
+			},
+			{
+				Name:        "NinaKill",
+				Description: "stop a background command",
+				InputSchema: prompts.ToolInputSchema{
+					Fields: []prompts.ToolField{
+						{
+							Name:        "id",
+							Type:        "string",
+							Description: "the id of the command",
+							Required:    true,
+						},
 					},
 				},
 			},
@@ -125,6 +319,92 @@ func (j *JSONToolProcessor) GetToolDefinitions() []prompts.ToolDefinition {
 					},
 				},
 			},
+			{
+				Name:        "NinaDelete",
+				Description: "delete a single file, uses git rm when the file is tracked",
+				InputSchema: prompts.ToolInputSchema{
+					Fields: []prompts.ToolField{
+						{
+							Name:        "path",
+							Type:        "string",
+							Description: "the absolute filepath to delete (starts with `/` or `~/`)",
+							Required:    true,
+						},
+					},
+				},
+			},
+			{
+				Name:        "NinaRename",
+				Description: "rename or move a file or directory, uses git mv when the source is tracked",
+				InputSchema: prompts.ToolInputSchema{
+					Fields: []prompts.ToolField{
+						{
+							Name:        "path",
+							Type:        "string",
+							Description: "the absolute filepath to rename (starts with `/` or `~/`)",
+							Required:    true,
+						},
+						{
+							Name:        "dest",
+							Type:        "string",
+							Description: "the absolute destination filepath, must not exist",
+							Required:    true,
+						},
+					},
+				},
+			},
+			{
+				Name:        "NinaRemember",
+				Description: "remember a durable fact about this project for future sessions",
+				InputSchema: prompts.ToolInputSchema{
+					Fields: []prompts.ToolField{
+						{
+							Name:        "content",
+							Type:        "string",
+							Description: "the fact to remember, one short self-contained statement",
+							Required:    true,
+						},
+					},
+				},
+			},
+			{
+				Name:        "NinaAgent",
+				Description: "delegate a self-contained task to a sub-agent",
+				InputSchema: prompts.ToolInputSchema{
+					Fields: []prompts.ToolField{
+						{
+							Name:        "task",
+							Type:        "string",
+							Description: "the task, with all the context the sub-agent needs since it cannot see this conversation",
+							Required:    true,
+						},
+						{
+							Name:        "model",
+							Type:        "string",
+							Description: "the model to use, a cheaper model is a good fit for investigation",
+						},
+						{
+							Name:        "max_tokens",
+							Type:        "int",
+							Description: "the token budget, default 100000",
+						},
+					},
+				},
+			},
+			{
+				Name:        "NinaStop",
+				Description: "stop working, when all work is complete, you need to ask the user, or there is no work to do",
+				InputSchema: prompts.ToolInputSchema{
+					Fields: []prompts.ToolField{
+						{
+							Name:        "reason",
+							Type:        "string",
+							Description: "the reason for stopping",
+							Required:    true,
+						},
+					},
+				},
+			},
 		}
 	}
 	return j.Tools
@@ -139,10 +419,13 @@ func (j *JSONToolProcessor) ExecuteToolCall(toolCall ToolCall) (string, error) {
 			return "", fmt.Errorf("invalid command argument")
 		}
 
-		result := util.ExecuteBash(util.BashCommand{Command: command})
+		stdin, _ := toolCall.Arguments["stdin"].(string)
+		cwd, _ := toolCall.Arguments["cwd"].(string)
+		result := util.ExecuteBash(util.BashCommand{Command: command, Stdin: stdin, Cwd: cwd})
 
 		// Format result as JSON
 		resultData := map[string]interface{}{
+			"cwd":       result.Cwd,
 			"exit_code": result.ExitCode,
 			"stdout":    result.Stdout,
 			"stderr":    result.Stderr,
@@ -166,7 +449,104 @@ func (j *JSONToolProcessor) ExecuteToolCall(toolCall ToolCall) (string, error) {
 			return fmt.Sprintf(`{"error": "%s"}`, result.Error), nil
 		}
 
-		return fmt.Sprintf(`{"lines_changed": %d}`, result.LinesChanged), nil
+		jsonResult, err := json.Marshal(map[string]interface{}{
+			"lines_changed": result.LinesChanged,
+			"diff":          util.TruncateDiff(result.Diff, lib.MaxDiffTokens),
+		})
+		if err != nil {
+			return "", err
+		}
+		return string(jsonResult), nil
+
+	case "NinaRead":
+		path, _ := toolCall.Arguments["path"].(string)
+		req := util.ReadRequest{Path: path}
+		if start, ok := toolCall.Arguments["start_line"].(float64); ok {
+			req.Start = int(start)
+		}
+		if end, ok := toolCall.Arguments["end_line"].(float64); ok {
+			req.End = int(end)
+		}
+		req.Pattern, _ = toolCall.Arguments["pattern"].(string)
+
+		result := util.ExecuteRead(req)
+		resultData := map[string]interface{}{
+			"lines":   result.Summary(),
+			"content": result.Content,
+		}
+		if result.Error != "" {
+			resultData = map[string]interface{}{"error": result.Error}
+		}
+		jsonResult, err := json.Marshal(resultData)
+		if err != nil {
+			return "", err
+		}
+
+		return string(jsonResult), nil
+
+	case "NinaGrep", "NinaGlob":
+		req := util.SearchRequest{}
+		req.Pattern, _ = toolCall.Arguments["pattern"].(string)
+		req.Path, _ = toolCall.Arguments["path"].(string)
+		req.Include, _ = toolCall.Arguments["include"].(string)
+
+		unit, result := "match", util.SearchResult{}
+		if toolCall.Function == "NinaGrep" {
+			result = util.ExecuteGrep(req)
+		} else {
+			unit, result = "file", util.ExecuteGlob(req)
+		}
+		resultData := map[string]interface{}{
+			"matches": result.Summary(unit),
+			"content": result.Content(),
+		}
+		if result.Error != "" {
+			resultData = map[string]interface{}{"error": result.Error}
+		}
+		jsonResult, err := json.Marshal(resultData)
+		if err != nil {
+			return "", err
+		}
+
+		return string(jsonResult), nil
+
+	case "NinaDelete", "NinaRename":
+		path, _ := toolCall.Arguments["path"].(string)
+
+		var result util.ChangeResult
+		if toolCall.Function == "NinaDelete" {
+			result = util.ExecuteDelete(path)
+		} else {
+			dest, _ := toolCall.Arguments["dest"].(string)
+			result = util.ExecuteRename(path, dest)
+		}
+
+		resultData := map[string]interface{}{"stdout": result.Stdout}
+		if result.Error != "" {
+			resultData = map[string]interface{}{"error": result.Error}
+		}
+		jsonResult, err := json.Marshal(resultData)
+		if err != nil {
+			return "", err
+		}
+
+		return string(jsonResult), nil
+
+	case "NinaRemember":
+		content, _ := toolCall.Arguments["content"].(string)
+
+		resultData := map[string]interface{}{}
+		if m, err := memory.Remember(content); err != nil {
+			resultData["error"] = err.Error()
+		} else {
+			resultData["id"] = m.ID
+		}
+		jsonResult, err := json.Marshal(resultData)
+		if err != nil {
+			return "", err
+		}
+
+		return string(jsonResult), nil
 
 	default:
 		return "", fmt.Errorf("unknown tool: %s", toolCall.Function)
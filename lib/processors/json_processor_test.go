@@ -0,0 +1,157 @@
+package processors
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nathants/nina/lib"
+	claude "github.com/nathants/nina/providers/claude"
+)
+
+// toolsMock answers CallWithTools with scripted claude tool_use responses
+type toolsMock struct {
+	*lib.MockClient
+	calls   [][]claude.ContentBlock
+	tools   []any
+	results [][]lib.ToolCall // the tool results each call answered
+}
+
+func (m *toolsMock) SupportsTools() bool {
+	return true
+}
+
+func (m *toolsMock) CallWithTools(ctx context.Context, model, systemPrompt, userMessage string, tools []any) (any, error) {
+	i := len(m.Messages)
+	resp, err := m.Call(ctx, model, systemPrompt, userMessage)
+	if err != nil {
+		return nil, err
+	}
+	m.tools = tools
+	m.results = append(m.results, lib.ToolResults(ctx))
+	return &claude.Response{
+		Content:    append([]claude.ContentBlock{{Type: "text", Text: resp.(*lib.ReplayResponse).Text}}, m.calls[i]...),
+		StopReason: "tool_use",
+	}, nil
+}
+
+func TestRenderToolCalls(t *testing.T) {
+	output, unknown := renderToolCalls("fixing it", []lib.ToolCall{
+		{Name: "NinaBash", Arguments: map[string]any{"command": "cat", "stdin": "yes", "cwd": "sub"}},
+		{Name: "NinaChange", Arguments: map[string]any{"path": "/a.go", "search": "old </NinaSearch>", "replace": "new"}},
+		{Name: "NinaRead", Arguments: map[string]any{"path": "/a.go", "start_line": float64(10)}},
+		{Name: "NinaStop", Arguments: map[string]any{"reason": "done"}},
+		{Name: "Missing"},
+	})
+	for _, want := range []string{
+		"<NinaMessage>fixing it</NinaMessage>",
+		"<NinaBash>\ncat\n<NinaStdin>yes</NinaStdin>\n<NinaCwd>sub</NinaCwd>\n</NinaBash>",
+		"<NinaChange>\n<NinaPath>/a.go</NinaPath>",
+		`<NinaSearch>old <\/NinaSearch></NinaSearch>`,
+		"<NinaStartLine>10</NinaStartLine>",
+		"<NinaStop>done</NinaStop>",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("output is missing %q:\n%s", want, output)
+		}
+	}
+	if strings.Contains(output, "NinaEndLine") {
+		t.Errorf("missing arguments should be left out:\n%s", output)
+	}
+	if len(unknown) != 1 || !strings.Contains(unknown[0], "unknown tool Missing") {
+		t.Errorf("unknown = %q", unknown)
+	}
+}
+
+func TestRunLoopNativeToolCalls(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	if out, err := exec.Command("git", "init", "-q").CombinedOutput(); err != nil {
+		t.Fatalf("git init: %v %s", err, out)
+	}
+	if err := os.WriteFile("a.txt", []byte("one\ntwo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	mock := &toolsMock{
+		MockClient: lib.NewMockClient("changing a.txt", "", "all done"),
+		calls: [][]claude.ContentBlock{
+			{
+				{Type: "tool_use", ID: "1", Name: "NinaChange", Input: map[string]any{"path": dir + "/a.txt", "search": "two", "replace": "three"}},
+				{Type: "tool_use", ID: "2", Name: "NinaBash", Input: map[string]any{"command": "cat a.txt"}},
+			},
+			{},
+			{{Type: "tool_use", ID: "3", Name: "NinaStop", Input: map[string]any{"reason": "done"}}},
+		},
+	}
+	err := lib.RunLoop(lib.LoopConfig{
+		Model:         "mock",
+		MaxTokens:     200000,
+		ToolProcessor: &JSONToolProcessor{},
+		StdinContent:  "change two to three in a.txt",
+		Provider:      mock,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := os.ReadFile("a.txt")
+	if err != nil || string(got) != "one\nthree\n" {
+		t.Fatalf("a.txt = %q, %v", got, err)
+	}
+	if len(mock.Messages) != 3 {
+		t.Fatalf("got %d calls, want 3", len(mock.Messages))
+	}
+	results := mock.results[1]
+	if len(results) != 2 || results[0].ID != "1" || results[1].ID != "2" {
+		t.Fatalf("second call answered %+v, want calls 1 and 2", results)
+	}
+	if !strings.Contains(results[0].Result, "<NinaChange>"+dir+"/a.txt</NinaChange>") || strings.Contains(results[0].Result, "NinaStdout") {
+		t.Errorf("result of call 1 = %q, want the change alone", results[0].Result)
+	}
+	if !strings.Contains(results[1].Result, "<NinaStdout>one\nthree") {
+		t.Errorf("result of call 2 = %q, want the command's output", results[1].Result)
+	}
+	if strings.Contains(mock.Messages[1], "NinaStdout") {
+		t.Errorf("tool results should answer their calls, not the message text:\n%s", mock.Messages[1])
+	}
+	if !strings.Contains(mock.Messages[2], "you have not output <NinaStop>") {
+		t.Errorf("a response without tool calls should be asked to continue:\n%s", mock.Messages[2])
+	}
+	if len(mock.tools) != len((&JSONToolProcessor{}).GetToolDefinitions()) {
+		t.Errorf("got %d tools, want the processor's", len(mock.tools))
+	}
+	if !strings.Contains(mock.System, "tool calling of the API") {
+		t.Error("expected the native system prompt")
+	}
+}
+
+func TestForFormat(t *testing.T) {
+	if p, err := ForFormat("native"); err != nil || p == nil {
+		t.Fatalf("native = %v, %v", p, err)
+	}
+	if _, ok := lib.ToolProcessor(&JSONToolProcessor{}).(lib.NativeToolProcessor); !ok {
+		t.Error("JSONToolProcessor should be a NativeToolProcessor")
+	}
+	if _, err := ForFormat("yaml"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+	t.Chdir(t.TempDir())
+	sessions := filepath.Join(t.TempDir(), "sessions.jsonl")
+	t.Setenv("NINA_SESSIONS_FILE", sessions)
+	err := lib.RunLoop(lib.LoopConfig{
+		Model:         "mock",
+		ToolProcessor: &JSONToolProcessor{},
+		StdinContent:  "x",
+		Provider:      lib.NewMockClient(),
+	})
+	if err == nil || !strings.Contains(err.Error(), "tool calling") {
+		t.Errorf("a provider without tool calling should fail, got %v", err)
+	}
+	if _, err := os.Stat(sessions); !os.IsNotExist(err) {
+		t.Errorf("a loop that ran no step should not be indexed, got %v", err)
+	}
+}
@@ -3,10 +3,10 @@ package processors
 
 import (
 	"fmt"
-	"os"
 	"strings"
 
 	"github.com/nathants/nina/lib"
+	"github.com/nathants/nina/memory"
 	"github.com/nathants/nina/util"
 )
 
@@ -19,6 +19,11 @@ func (x *XMLToolProcessor) ProcessResponse(response string, state *lib.LoopState
 	return lib.ProcessOutput(response, state, false)
 }
 
+// Plans reports that the XML processor asks for and applies NinaPlan tags.
+func (x *XMLToolProcessor) Plans() bool {
+	return true
+}
+
 // GetSystemPrompt loads the system prompt for XML tool usage.
 func (x *XMLToolProcessor) GetSystemPrompt() string {
 	return lib.LoadSystemPromptWithXML()
@@ -28,17 +33,9 @@ func (x *XMLToolProcessor) GetSystemPrompt() string {
 func (x *XMLToolProcessor) FormatUserMessage(state *lib.LoopState, content string) (string, error) {
 	var promptContent []string
 
-	// Check for SUGGEST.md
-	suggestPath := util.GetGitRoot() + "/SUGGEST.md"
-	if data, err := os.ReadFile(suggestPath); err == nil && len(data) > 0 {
-		suggestContent := strings.TrimSpace(string(data))
-		if suggestContent != "" {
-			promptContent = append(promptContent, fmt.Sprintf("\n\n%s\n%s\n%s", util.NinaSuggestionStart, suggestContent, util.NinaSuggestionEnd))
-		}
-		// Truncate SUGGEST.md after reading
-		if err := os.Truncate(suggestPath, 0); err != nil {
-			lib.LogStderr("Failed to truncate SUGGEST.md: %v", err)
-		}
+	// Send SUGGEST.md and steering messages
+	if suggestContent := lib.TakeSuggestions(); suggestContent != "" {
+		promptContent = append(promptContent, fmt.Sprintf("\n\n%s\n%s\n%s", util.NinaSuggestionStart, suggestContent, util.NinaSuggestionEnd))
 	}
 
 	if len(promptContent) == 0 {
@@ -46,10 +43,21 @@ func (x *XMLToolProcessor) FormatUserMessage(state *lib.LoopState, content strin
 		promptContent = append(promptContent, fmt.Sprintf("\n\n%s\n%s\n%s", util.NinaSuggestionStart, suggestContent, util.NinaSuggestionEnd))
 	}
 
+	// Ask for a plan, or list the unticked TODO.md items
+	if state.Planning {
+		if plan := lib.PlanSuggestion(); plan != "" {
+			promptContent = append(promptContent, fmt.Sprintf("%s\n%s\n%s", util.NinaSuggestionStart, plan, util.NinaSuggestionEnd))
+		}
+	}
+
 	// Create NinaPrompt tag only on first message
 	if len(promptContent) > 0 {
 		if state.StepNumber == 1 && content != "" {
-			promptContent[0] = fmt.Sprintf("%s\n%s\n%s", util.NinaPromptStart, content, util.NinaPromptEnd) + promptContent[0]
+			prompt := fmt.Sprintf("%s\n%s\n%s", util.NinaPromptStart, content, util.NinaPromptEnd)
+			if recall := memory.RecallBlock(content); recall != "" {
+				prompt += "\n" + recall
+			}
+			promptContent[0] = prompt + promptContent[0]
 		}
 	} else if state.StepNumber == 1 && content != "" {
 		promptContent = append(promptContent, fmt.Sprintf("%s\n%s\n%s", util.NinaPromptStart, content, util.NinaPromptEnd))
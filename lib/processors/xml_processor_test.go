@@ -0,0 +1,371 @@
+package processors
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nathants/nina/lib"
+)
+
+func TestRunLoopWithMockProvider(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	if out, err := exec.Command("git", "init", "-q").CombinedOutput(); err != nil {
+		t.Fatalf("git init: %v %s", err, out)
+	}
+
+	mock := lib.NewMockClient(
+		"<NinaOutput>\n<NinaBash>echo hello > out.txt</NinaBash>\n</NinaOutput>",
+		"<NinaOutput>\n<NinaStop>done</NinaStop>\n</NinaOutput>",
+	)
+	err := lib.RunLoop(lib.LoopConfig{
+		Model:         "mock",
+		MaxTokens:     200000,
+		ToolProcessor: &XMLToolProcessor{},
+		StdinContent:  "write hello to out.txt",
+		Provider:      mock,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := os.ReadFile("out.txt")
+	if err != nil || string(got) != "hello\n" {
+		t.Fatalf("out.txt = %q, %v", got, err)
+	}
+	if len(mock.Messages) != 2 {
+		t.Fatalf("got %d calls, want 2", len(mock.Messages))
+	}
+	if !strings.Contains(mock.Messages[0], "write hello to out.txt") {
+		t.Errorf("first message is missing the prompt:\n%s", mock.Messages[0])
+	}
+	if !strings.Contains(mock.Messages[1], "<NinaExit>0</NinaExit>") {
+		t.Errorf("second message is missing the bash result:\n%s", mock.Messages[1])
+	}
+	if !strings.Contains(mock.System, "NinaOutput") {
+		t.Error("expected the xml system prompt")
+	}
+}
+
+func TestRunLoopEventStream(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	if out, err := exec.Command("git", "init", "-q").CombinedOutput(); err != nil {
+		t.Fatalf("git init: %v %s", err, out)
+	}
+	// events are written outside the repo so they are not a changed file
+	eventsPath := filepath.Join(t.TempDir(), "events.jsonl")
+	events, err := os.Create(eventsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = events
+	defer func() { os.Stdout = stdout }()
+
+	lib.StartEventStream()
+	err = lib.RunLoop(lib.LoopConfig{
+		Model:         "mock",
+		MaxTokens:     200000,
+		ToolProcessor: &XMLToolProcessor{},
+		StdinContent:  "write hello to out.txt",
+		Provider: lib.NewMockClient(
+			"<NinaOutput>\n<NinaBash>echo hello > out.txt</NinaBash>\n</NinaOutput>",
+			"<NinaOutput>\n<NinaStop>done</NinaStop>\n</NinaOutput>",
+		),
+	})
+	lib.StopEventStream(err)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = events.Close()
+
+	f, err := os.Open(eventsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = f.Close() }()
+	var types []string
+	var last lib.StreamEvent
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var event lib.StreamEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("invalid event %s: %v", scanner.Text(), err)
+		}
+		if event.Version != lib.EventProtocolVersion {
+			t.Errorf("version %d", event.Version)
+		}
+		if event.Type == lib.EventToolResult && (event.ExitCode == nil || *event.ExitCode != 0 || event.Command != "echo hello > out.txt") {
+			t.Errorf("unexpected tool result %+v", event)
+		}
+		if event.Type == lib.EventFileChanged && (event.Tool != "external" || event.Path != filepath.Join(dir, "out.txt") || event.LinesChanged != 1) {
+			t.Errorf("unexpected file change %+v", event)
+		}
+		types = append(types, event.Type)
+		last = event
+	}
+	want := "assistant_delta tool_start tool_result file_changed usage assistant_delta usage done"
+	if got := strings.Join(types, " "); got != want {
+		t.Errorf("got events %s\nwant %s", got, want)
+	}
+	if last.Status != "success" || last.StopReason != "done" || last.Step != 2 {
+		t.Errorf("unexpected done event %+v", last)
+	}
+}
+
+func TestRunLoopRefusesStopWithCriticalPlanItems(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	if out, err := exec.Command("git", "init", "-q").CombinedOutput(); err != nil {
+		t.Fatalf("git init: %v %s", err, out)
+	}
+
+	mock := lib.NewMockClient(
+		"<NinaOutput>\n<NinaPlan>\n- [ ] (critical) write out.txt\n- [ ] tidy up\n</NinaPlan>\n</NinaOutput>",
+		"<NinaOutput>\n<NinaStop>done</NinaStop>\n</NinaOutput>",
+		"<NinaOutput>\n<NinaBash>echo hello > out.txt && sed -i 's/- \\[ \\] (critical)/- [x] (critical)/' TODO.md</NinaBash>\n<NinaStop>done</NinaStop>\n</NinaOutput>",
+	)
+	err := lib.RunLoop(lib.LoopConfig{
+		Model:         "mock",
+		MaxTokens:     200000,
+		ToolProcessor: &XMLToolProcessor{},
+		StdinContent:  "write hello to out.txt",
+		Provider:      mock,
+		Plan:          true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mock.Messages) != 3 {
+		t.Fatalf("got %d calls, want 3", len(mock.Messages))
+	}
+	if !strings.Contains(mock.Messages[0], "<NinaPlan>") {
+		t.Errorf("first message does not ask for a plan:\n%s", mock.Messages[0])
+	}
+	if !strings.Contains(mock.Messages[1], "- [ ] (critical) write out.txt") {
+		t.Errorf("second message is missing the remaining items:\n%s", mock.Messages[1])
+	}
+	if !strings.Contains(mock.Messages[2], "NinaStop was refused") {
+		t.Errorf("third message is missing the refusal:\n%s", mock.Messages[2])
+	}
+	plan, err := lib.LoadPlan()
+	if err != nil || len(plan) != 2 || !plan[0].Done {
+		t.Errorf("TODO.md plan = %+v, %v", plan, err)
+	}
+}
+
+func TestRunLoopKeepsForeignTODO(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	if out, err := exec.Command("git", "init", "-q").CombinedOutput(); err != nil {
+		t.Fatalf("git init: %v %s", err, out)
+	}
+	todo := "# my notes\n\n- [ ] (critical) ship the release\n"
+	if err := os.WriteFile("TODO.md", []byte(todo), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	mock := lib.NewMockClient("<NinaOutput>\n<NinaPlan>\n- [ ] (critical) write out.txt\n</NinaPlan>\n<NinaStop>done</NinaStop>\n</NinaOutput>")
+	err := lib.RunLoop(lib.LoopConfig{
+		Model:         "mock",
+		MaxTokens:     200000,
+		ToolProcessor: &XMLToolProcessor{},
+		StdinContent:  "write hello to out.txt",
+		Provider:      mock,
+		Plan:          true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mock.Messages) != 1 || strings.Contains(mock.Messages[0], "plan the task") {
+		t.Errorf("want one call without a plan request, got %d:\n%s", len(mock.Messages), mock.Messages[0])
+	}
+	if data, _ := os.ReadFile("TODO.md"); string(data) != todo {
+		t.Errorf("TODO.md was changed:\n%s", data)
+	}
+}
+
+func TestRunLoopNinaAgent(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	if out, err := exec.Command("git", "init", "-q").CombinedOutput(); err != nil {
+		t.Fatalf("git init: %v %s", err, out)
+	}
+
+	// the child answers the second and third calls, the parent the first and last
+	mock := lib.NewMockClient(
+		"<NinaOutput>\n<NinaAgent>\n<NinaTask>find the answer</NinaTask>\n<NinaMaxTokens>50000</NinaMaxTokens>\n</NinaAgent>\n</NinaOutput>",
+		"<NinaOutput>\n<NinaBash>echo 42 > answer.txt</NinaBash>\n</NinaOutput>",
+		"<NinaOutput>\n<NinaStop>the answer is 42, see answer.txt</NinaStop>\n</NinaOutput>",
+		"<NinaOutput>\n<NinaStop>done</NinaStop>\n</NinaOutput>",
+	)
+	err := lib.RunLoop(lib.LoopConfig{
+		Model:         "mock",
+		MaxTokens:     200000,
+		ToolProcessor: &XMLToolProcessor{},
+		StdinContent:  "what is the answer",
+		Provider:      mock,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mock.Messages) != 4 {
+		t.Fatalf("got %d calls, want 4", len(mock.Messages))
+	}
+	if !strings.Contains(mock.Messages[1], "find the answer") || strings.Contains(mock.Messages[1], "what is the answer") {
+		t.Errorf("child message should hold only the delegated task:\n%s", mock.Messages[1])
+	}
+	if !strings.Contains(mock.Messages[3], "<NinaAgent>the answer is 42, see answer.txt</NinaAgent>") {
+		t.Errorf("parent message is missing the report:\n%s", mock.Messages[3])
+	}
+}
+
+func TestRunLoopSpeculate(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	if out, err := exec.Command("git", "init", "-q").CombinedOutput(); err != nil {
+		t.Fatalf("git init: %v %s", err, out)
+	}
+	run := func(primary, draft *lib.MockClient) error {
+		return lib.RunLoop(lib.LoopConfig{
+			Model:         "mock",
+			MaxTokens:     200000,
+			ToolProcessor: &XMLToolProcessor{},
+			StdinContent:  "say hi",
+			Provider:      primary,
+			Speculate:     "mock-draft",
+			DraftAfter:    50 * time.Millisecond,
+			DraftProvider: draft,
+		})
+	}
+	stop := "<NinaOutput>\n<NinaStop>done</NinaStop>\n</NinaOutput>"
+	draftStop := "<NinaOutput>\n<NinaStop>drafted</NinaStop>\n</NinaOutput>"
+
+	// the primary answers and the slow draft is canceled
+	primary := lib.NewMockClient(stop)
+	draft := lib.NewMockClient(draftStop)
+	draft.Delay = time.Minute
+	start := time.Now()
+	if err := run(primary, draft); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("draft was not canceled, took %s", elapsed)
+	}
+	if len(draft.Messages) != 0 || len(draft.Recorded) != 1 || draft.Recorded[0] != stop {
+		t.Errorf("draft should record the primary response, got %q", draft.Recorded)
+	}
+
+	// the primary times out and the draft is used
+	primary = lib.NewMockClient(stop)
+	primary.Delay = time.Minute
+	draft = lib.NewMockClient(draftStop)
+	if err := run(primary, draft); err != nil {
+		t.Fatal(err)
+	}
+	if len(primary.Messages) != 0 || len(primary.Recorded) != 1 || primary.Recorded[0] != draftStop {
+		t.Errorf("primary should record the draft response, got %q", primary.Recorded)
+	}
+
+	// the primary fails and the draft is used
+	primary = lib.NewMockClient()
+	draft = lib.NewMockClient(draftStop)
+	if err := run(primary, draft); err != nil {
+		t.Fatal(err)
+	}
+	if len(draft.Messages) != 1 {
+		t.Errorf("got %d draft calls, want 1", len(draft.Messages))
+	}
+
+	// both fail
+	err := run(lib.NewMockClient(), lib.NewMockClient())
+	if err == nil || !strings.Contains(err.Error(), "draft from mock-draft failed") {
+		t.Errorf("expected both calls to fail, got %v", err)
+	}
+}
+
+func TestRunLoopMalformedResponse(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	if out, err := exec.Command("git", "init", "-q").CombinedOutput(); err != nil {
+		t.Fatalf("git init: %v %s", err, out)
+	}
+
+	mock := lib.NewMockClient(
+		"<NinaOutput>\n<NinaBash>echo hello > out.txt</NinaBash>\n<NinaChange>\n<NinaPath>",
+		"<NinaOutput>\n<NinaStop>done</NinaStop>\n</NinaOutput>",
+	)
+	err := lib.RunLoop(lib.LoopConfig{
+		Model:         "mock",
+		MaxTokens:     200000,
+		ToolProcessor: &XMLToolProcessor{},
+		StdinContent:  "write hello to out.txt",
+		Provider:      mock,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat("out.txt"); err == nil {
+		t.Error("the cut off response should not run")
+	}
+	if len(mock.Messages) != 2 || !strings.Contains(mock.Messages[1], "cut off before </NinaOutput>") {
+		t.Errorf("second message is missing the correction:\n%s", mock.Messages[len(mock.Messages)-1])
+	}
+
+	// invalid responses in a row end the loop as a parse error
+	var responses []string
+	for range 5 {
+		responses = append(responses, "<NinaOutput>\n<NinaChange>x</NinaOutput>")
+	}
+	err = lib.RunLoop(lib.LoopConfig{
+		Model:         "mock",
+		MaxTokens:     200000,
+		ToolProcessor: &XMLToolProcessor{},
+		StdinContent:  "write hello to out.txt",
+		Provider:      lib.NewMockClient(responses...),
+	})
+	if lib.ErrorKind(err) != lib.ErrorParse {
+		t.Errorf("expected a parse error, got %v", err)
+	}
+}
+
+func TestRunLoopContinuesCutOffResponse(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	if out, err := exec.Command("git", "init", "-q").CombinedOutput(); err != nil {
+		t.Fatalf("git init: %v %s", err, out)
+	}
+
+	// the first response is cut after 60 characters, the continuation
+	// repeats the last 10
+	first := "<NinaOutput>\n<NinaBash>echo hello > out.txt</NinaBash>\n</NinaOutput>"
+	mock := lib.NewMockClient(
+		first,
+		first[50:],
+		"<NinaOutput>\n<NinaStop>done</NinaStop>\n</NinaOutput>",
+	)
+	mock.MaxOutput = 60
+	err := lib.RunLoop(lib.LoopConfig{
+		Model:         "mock",
+		MaxTokens:     200000,
+		ToolProcessor: &XMLToolProcessor{},
+		StdinContent:  "write hello to out.txt",
+		Provider:      mock,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile("out.txt"); err != nil || string(got) != "hello\n" {
+		t.Fatalf("out.txt = %q, %v", got, err)
+	}
+	if len(mock.Messages) != 3 || !strings.Contains(mock.Messages[1], "Continue exactly where you left off") {
+		t.Errorf("expected a continuation request, got %q", mock.Messages)
+	}
+}
@@ -0,0 +1,271 @@
+package lib
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nathants/nina/util"
+)
+
+func ninaChange(path, search, replace string) string {
+	if search != "" {
+		search = util.NinaSearchStart + "\n" + search + "\n" + util.NinaSearchEnd + "\n"
+	}
+	return util.NinaStart + "\n" + util.NinaPathStart + path + util.NinaPathEnd + "\n" + search +
+		util.NinaReplaceStart + "\n" + replace + "\n" + util.NinaReplaceEnd + "\n" + util.NinaEnd
+}
+
+func TestProcessOutputTransaction(t *testing.T) {
+	dir := t.TempDir()
+	util.AllowPaths([]string{dir})
+	a := filepath.Join(dir, "a.txt")
+	b := filepath.Join(dir, "b.txt")
+	for _, path := range []string{a, b} {
+		if err := os.WriteFile(path, []byte("one\ntwo\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	output := func(changes ...string) string {
+		return util.NinaOutputStart + "\n" + strings.Join(changes, "\n") + "\n" + util.NinaOutputEnd
+	}
+
+	// the second file does not exist, so the first change is not written
+	missing := filepath.Join(dir, "missing.txt")
+	result := ProcessOutput(output(ninaChange(a, "", "ONE"), ninaChange(missing, "", "x")), nil, false)
+	if len(result.Events) != 2 || result.Events[0].Reason == "" || result.Events[1].Reason == "" {
+		t.Fatalf("expected both changes to fail, got %+v", result.Events)
+	}
+	if data, _ := os.ReadFile(a); string(data) != "one\ntwo\n" {
+		t.Errorf("a.txt changed by a failed response: %q", data)
+	}
+
+	t.Setenv("NINA_VALIDATE", "grep -q ONE "+a+" && ! grep -q TWO "+b)
+	result = ProcessOutput(output(ninaChange(a, "", "ONE"), ninaChange(b, "", "TWO")), nil, false)
+	if !strings.Contains(result.Events[0].Reason, "validation") {
+		t.Errorf("expected validation failure, got %+v", result.Events)
+	}
+	for _, path := range []string{a, b} {
+		if data, _ := os.ReadFile(path); string(data) != "one\ntwo\n" {
+			t.Errorf("%s not restored after failed validation: %q", path, data)
+		}
+	}
+
+	result = ProcessOutput(output(ninaChange(a, "", "ONE"), ninaChange(b, "", "ONE")), nil, false)
+	for _, event := range result.Events {
+		if event.Reason != "" {
+			t.Errorf("unexpected failure: %+v", event)
+		}
+	}
+	if data, _ := os.ReadFile(b); strings.TrimSpace(string(data)) != "ONE" {
+		t.Errorf("b.txt after valid response = %q", data)
+	}
+}
+
+func TestProcessOutputAlreadyApplied(t *testing.T) {
+	dir := t.TempDir()
+	util.AllowPaths([]string{dir})
+	a := filepath.Join(dir, "a.txt")
+	if err := os.WriteFile(a, []byte("one\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	output := util.NinaOutputStart + "\n" + ninaChange(a, "", "ONE") + "\n" + util.NinaOutputEnd
+	state := &LoopState{}
+
+	result := ProcessOutput(output, state, false)
+	if len(result.Events) != 1 || result.Events[0].AlreadyApplied || result.Events[0].Reason != "" {
+		t.Fatalf("first apply = %+v", result.Events)
+	}
+	result = ProcessOutput(output, state, false)
+	if len(result.Events) != 1 || !result.Events[0].AlreadyApplied {
+		t.Fatalf("expected repeat to be skipped, got %+v", result.Events)
+	}
+	if !strings.Contains(result.Results[0], "already applied") {
+		t.Errorf("result does not say already applied: %s", result.Results[0])
+	}
+
+	// once the file no longer holds the replacement the change applies again
+	if err := os.WriteFile(a, []byte("one\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	result = ProcessOutput(output, state, false)
+	if result.Events[0].AlreadyApplied {
+		t.Errorf("expected reverted change to apply again")
+	}
+	if data, _ := os.ReadFile(a); strings.TrimSpace(string(data)) != "ONE" {
+		t.Errorf("a.txt = %q", data)
+	}
+}
+
+func TestProcessOutputMalformed(t *testing.T) {
+	dir := t.TempDir()
+	util.AllowPaths([]string{dir})
+	a := filepath.Join(dir, "a.txt")
+	if err := os.WriteFile(a, []byte("one\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	change := ninaChange(a, "one", "ONE")
+	tests := []struct {
+		name   string
+		output string
+		state  *LoopState
+		want   string
+	}{
+		{"truncated", util.NinaOutputStart + "\n" + change, &LoopState{Truncated: true}, "output token limit"},
+		{"missing end", util.NinaOutputStart + "\n" + change, nil, "ends before"},
+		{"content filter", util.NinaOutputStart + "\n" + change, &LoopState{StopReason: StopContentFilter}, "content filter"},
+		{"content filter empty", "", &LoopState{StopReason: StopContentFilter}, "content filter"},
+		{"unbalanced change", util.NinaOutputStart + "\n" + strings.TrimSuffix(change, util.NinaEnd) + "\n" + util.NinaOutputEnd, nil, "unbalanced tags: 1 <NinaChange> and 0 </NinaChange>"},
+		{"outside output", "```xml\n" + change + "\n```", nil, "outside"},
+	}
+	for _, tt := range tests {
+		result := ProcessOutput(tt.output, tt.state, false)
+		var respErr *ResponseError
+		if !errors.As(result.Error, &respErr) || !strings.Contains(respErr.Problem, tt.want) || respErr.Suggestion == "" {
+			t.Errorf("%s: got %v", tt.name, result.Error)
+		}
+		if len(result.Events) != 0 {
+			t.Errorf("%s: expected nothing to run, got %+v", tt.name, result.Events)
+		}
+	}
+	if data, _ := os.ReadFile(a); string(data) != "one\n" {
+		t.Errorf("a.txt changed by a malformed response: %q", data)
+	}
+}
+
+func TestProcessOutputUnfence(t *testing.T) {
+	dir := t.TempDir()
+	util.AllowPaths([]string{dir})
+	a := filepath.Join(dir, "a.go")
+	if err := os.WriteFile(a, []byte("package a\n\nvar x = 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	output := util.NinaOutputStart + "\n" + ninaChange(a, "```go\nvar x = 1\n```", "```go\nvar x = 2\n```") + "\n" + util.NinaOutputEnd
+	result := ProcessOutput(output, nil, false)
+	if result.Error != nil || len(result.Events) != 1 || !result.Events[0].Unfenced || result.Events[0].Reason != "" {
+		t.Fatalf("expected the change to apply without fences, got %v %+v", result.Error, result.Events)
+	}
+	if !strings.Contains(result.Results[0], "without code fences") {
+		t.Errorf("result does not ask to stop fencing: %s", result.Results[0])
+	}
+	if data, _ := os.ReadFile(a); string(data) != "package a\n\nvar x = 2\n" {
+		t.Errorf("a.go = %q", data)
+	}
+
+	// fences that are part of the file are matched as they are
+	md := filepath.Join(dir, "README.md")
+	if err := os.WriteFile(md, []byte("# a\n\n```go\nx := 1\n```\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	output = util.NinaOutputStart + "\n" + ninaChange(md, "```go\nx := 1\n```", "```go\nx := 2\n```") + "\n" + util.NinaOutputEnd
+	result = ProcessOutput(output, nil, false)
+	if len(result.Events) != 1 || result.Events[0].Unfenced || result.Events[0].Reason != "" {
+		t.Fatalf("unexpected events %+v", result.Events)
+	}
+	if data, _ := os.ReadFile(md); string(data) != "# a\n\n```go\nx := 2\n```\n" {
+		t.Errorf("README.md = %q", data)
+	}
+}
+
+func TestProcessOutputSalvage(t *testing.T) {
+	dir := t.TempDir()
+	util.AllowPaths([]string{dir})
+	a := filepath.Join(dir, "a.txt")
+	b := filepath.Join(dir, "b.txt")
+	for _, path := range []string{a, b} {
+		if err := os.WriteFile(path, []byte("one\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	broken := strings.Replace(ninaChange(b, "one", "ONE"), util.NinaSearchEnd, "", 1)
+	output := util.NinaOutputStart + "\n" + ninaChange(a, "one", "ONE") + "\n" + broken + "\n" + util.NinaOutputEnd
+	state := &LoopState{}
+	result := ProcessOutput(output, state, false)
+	if result.Error != nil || len(result.Events) != 2 {
+		t.Fatalf("unexpected result %v %+v", result.Error, result.Events)
+	}
+	if result.Events[0].Reason != "" || !result.Events[1].Malformed || !strings.Contains(result.Events[1].Reason, "malformed") {
+		t.Errorf("expected only the broken change to fail, got %+v", result.Events)
+	}
+	if !strings.Contains(result.Results[1], "<NinaError>not applied, malformed") {
+		t.Errorf("result does not report the broken change: %s", result.Results[1])
+	}
+	if data, _ := os.ReadFile(a); string(data) != "ONE\n" {
+		t.Errorf("a.txt = %q", data)
+	}
+	if data, _ := os.ReadFile(b); string(data) != "one\n" {
+		t.Errorf("b.txt = %q", data)
+	}
+	if len(state.AppliedChanges) != 1 {
+		t.Errorf("applied changes = %v", state.AppliedChanges)
+	}
+}
+
+func TestProcessOutputDiff(t *testing.T) {
+	dir := t.TempDir()
+	util.AllowPaths([]string{dir})
+	a := filepath.Join(dir, "a.go")
+	if err := os.WriteFile(a, []byte("package a\n\nvar x = 1\n\nvar y = 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	output := util.NinaOutputStart + "\n" + ninaChange(a, "var x = 1", "var x = 2") + "\n" + util.NinaOutputEnd
+	result := ProcessOutput(output, nil, false)
+	if result.Error != nil || len(result.Results) != 1 {
+		t.Fatalf("unexpected result %v %v", result.Error, result.Results)
+	}
+	want := util.NinaDiffStart + "\n--- a" + a + "\n+++ b" + a + "\n@@ -1,5 +1,5 @@\n package a\n \n-var x = 1\n+var x = 2\n \n var y = 1\n" + util.NinaDiffEnd
+	if !strings.Contains(result.Results[0], want) {
+		t.Errorf("result does not contain the diff:\n%s", result.Results[0])
+	}
+
+	// a failed change has no diff
+	output = util.NinaOutputStart + "\n" + ninaChange(a, "var z = 1", "var z = 2") + "\n" + util.NinaOutputEnd
+	result = ProcessOutput(output, nil, false)
+	if len(result.Results) != 1 || strings.Contains(result.Results[0], util.NinaDiffStart) {
+		t.Errorf("failed change has a diff: %v", result.Results)
+	}
+}
+
+func TestProcessOutputRead(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.go")
+	if err := os.WriteFile(a, []byte("package a\n\nvar x = 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	read := util.NinaReadStart + util.NinaPathStart + a + util.NinaPathEnd + util.NinaStartLineStart + "3" + util.NinaStartLineEnd + util.NinaReadEnd
+	missing := util.NinaReadStart + util.NinaPathStart + filepath.Join(dir, "b.go") + util.NinaPathEnd + util.NinaReadEnd
+	result := ProcessOutput(util.NinaOutputStart+"\n"+read+"\n"+missing+"\n"+util.NinaOutputEnd, nil, false)
+	if result.Error != nil || len(result.Results) != 2 {
+		t.Fatalf("unexpected result %v %v", result.Error, result.Results)
+	}
+	want := "<NinaRead>" + a + "</NinaRead>\n<NinaLines>3-3 of 3</NinaLines>\n<NinaContent>\n3: var x = 1\n</NinaContent>"
+	if !strings.Contains(result.Results[0], want) {
+		t.Errorf("unexpected result %s", result.Results[0])
+	}
+	if !strings.Contains(result.Results[1], "<NinaError>") || result.Events[1].Reason == "" {
+		t.Errorf("expected an error for a missing file, got %s", result.Results[1])
+	}
+}
+
+func TestProcessOutputSearch(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := os.WriteFile("a.go", []byte("package a\n\nvar x = 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	grep := util.NinaGrepStart + util.NinaPatternStart + "var x" + util.NinaPatternEnd + util.NinaGrepEnd
+	glob := util.NinaGlobStart + util.NinaPatternStart + "*.go" + util.NinaPatternEnd + util.NinaGlobEnd
+	result := ProcessOutput(util.NinaOutputStart+"\n"+grep+"\n"+glob+"\n"+util.NinaOutputEnd, nil, false)
+	if result.Error != nil || len(result.Results) != 2 {
+		t.Fatalf("unexpected result %v %v", result.Error, result.Results)
+	}
+	for i, want := range []string{
+		"<NinaGrep>var x</NinaGrep>\n<NinaMatches>1 match in 1 file</NinaMatches>\n<NinaContent>\n./a.go:3: var x = 1\n</NinaContent>",
+		"<NinaGlob>*.go</NinaGlob>\n<NinaMatches>1 file</NinaMatches>\n<NinaContent>\n./a.go\n</NinaContent>",
+	} {
+		if !strings.Contains(result.Results[i], want) {
+			t.Errorf("unexpected result %s", result.Results[i])
+		}
+	}
+}
@@ -0,0 +1,105 @@
+// Nina protocol versions. The tool tags of nina run were added over time,
+// each version adding some, see protocolTags. A model follows the newest
+// version unless its models.json entry sets "protocol", like a fine tuned
+// model trained before a tag existed. The system prompt advertises the
+// version and lists the tools a model on an older one must not use.
+//
+// A response is checked against the version of its session: tool tags from
+// a newer version are not run and the model is told so, and a NinaProtocol
+// tag naming another version is logged, so a model speaking an older or
+// newer dialect degrades to the tools both sides know.
+package lib
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/nathants/nina/models"
+	"github.com/nathants/nina/util"
+)
+
+// ProtocolVersion is the newest Nina protocol version
+const ProtocolVersion = 3
+
+// protocolTags are the tool tags of each Nina protocol version
+var protocolTags = []struct {
+	tag     string
+	version int
+}{
+	{"NinaBash", 1},
+	{"NinaChange", 1},
+	{"NinaStop", 1},
+	{"NinaDelete", 2},
+	{"NinaRename", 2},
+	{"NinaRead", 2},
+	{"NinaGrep", 2},
+	{"NinaGlob", 2},
+	{"NinaSpawn", 3},
+	{"NinaKill", 3},
+	{"NinaRemember", 3},
+	{"NinaAgent", 3},
+}
+
+// modelProtocol returns the protocol version of model, the newest for a
+// model without one or one not in the registry
+func modelProtocol(model string) int {
+	m, err := models.Lookup(model)
+	if err != nil || m.Protocol <= 0 {
+		return ProtocolVersion
+	}
+	if m.Protocol > ProtocolVersion {
+		LogError("Warning: %s asks for Nina protocol %d, the newest is %d", model, m.Protocol, ProtocolVersion)
+		return ProtocolVersion
+	}
+	return m.Protocol
+}
+
+// unsupportedTags returns the tool tags newer than version
+func unsupportedTags(version int) []string {
+	var tags []string
+	for _, t := range protocolTags {
+		if t.version > version {
+			tags = append(tags, t.tag)
+		}
+	}
+	return tags
+}
+
+// protocolPrompt advertises version in the system prompt
+func protocolPrompt(version int) string {
+	prompt := fmt.Sprintf("%s%d%s\nThis session uses version %d of the Nina protocol.", util.NinaProtocolStart, version, util.NinaProtocolEnd, version)
+	if tags := unsupportedTags(version); len(tags) > 0 {
+		prompt += fmt.Sprintf(" These tools are not available in it, do not use them: <%s>.", strings.Join(tags, ">, <"))
+	}
+	return prompt
+}
+
+// checkProtocol removes the tool tags newer than the session's protocol from
+// ninaOutput, returning what is left and the results telling the model
+func checkProtocol(ninaOutput string, state *LoopState) (string, []string) {
+	version := ProtocolVersion
+	if state != nil && state.Protocol > 0 {
+		version = state.Protocol
+	}
+	if declared, _ := util.ExtractSingle(ninaOutput, util.NinaProtocolStart, util.NinaProtocolEnd); strings.TrimSpace(declared) != "" {
+		if n, err := strconv.Atoi(strings.TrimSpace(declared)); err != nil || n != version {
+			util.Verbosef("response follows Nina protocol %s, the session uses %d", strings.TrimSpace(declared), version)
+		}
+	}
+	var results []string
+	for _, tag := range unsupportedTags(version) {
+		start, end := "<"+tag+">", "</"+tag+">"
+		blocks, err := util.ExtractAll(ninaOutput, start, end)
+		if err != nil || len(blocks) == 0 {
+			continue
+		}
+		for _, block := range blocks {
+			ninaOutput = strings.Replace(ninaOutput, start+block+end, "", 1)
+		}
+		LogError("Warning: skipped %d %s, not in Nina protocol %d", len(blocks), start, version)
+		results = append(results, fmt.Sprintf("%s\n<NinaError>%s is not available in version %d of the Nina protocol, the %d you sent were not run. Use the tools listed in the system prompt.</NinaError>\n%s",
+			util.NinaResultStart, start, version, len(blocks), util.NinaResultEnd))
+	}
+	return ninaOutput, results
+}
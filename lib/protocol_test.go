@@ -0,0 +1,33 @@
+package lib
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nathants/nina/util"
+)
+
+func TestProtocol(t *testing.T) {
+	if got := modelProtocol("no-such-model"); got != ProtocolVersion {
+		t.Errorf("modelProtocol() = %d, want %d", got, ProtocolVersion)
+	}
+	if prompt := protocolPrompt(ProtocolVersion); strings.Contains(prompt, "not available") {
+		t.Errorf("newest protocol prompt lists unavailable tools: %s", prompt)
+	}
+	prompt := protocolPrompt(2)
+	if !strings.Contains(prompt, "<NinaProtocol>2</NinaProtocol>") || !strings.Contains(prompt, "<NinaSpawn>, <NinaKill>, <NinaRemember>, <NinaAgent>") {
+		t.Errorf("unexpected prompt %s", prompt)
+	}
+
+	output := "<NinaProtocol>3</NinaProtocol>\n<NinaBash>ls</NinaBash>\n<NinaRead><NinaPath>/a</NinaPath></NinaRead>\n<NinaGlob><NinaPattern>*</NinaPattern></NinaGlob>"
+	checked, results := checkProtocol(output, &LoopState{Protocol: 1})
+	if strings.Contains(checked, util.NinaReadStart) || strings.Contains(checked, util.NinaGlobStart) || !strings.Contains(checked, "<NinaBash>ls</NinaBash>") {
+		t.Errorf("unexpected output %q", checked)
+	}
+	if len(results) != 2 || !strings.Contains(results[0], "<NinaRead> is not available in version 1") {
+		t.Errorf("unexpected results %q", results)
+	}
+	if checked, results := checkProtocol(output, nil); checked != output || len(results) != 0 {
+		t.Errorf("newest protocol changed the output to %q, %q", checked, results)
+	}
+}
@@ -0,0 +1,263 @@
+// Ranking of candidate responses with a judge model, used by choose --rank
+// and arch --n. Each candidate is scored from 0 to 10 on every rubric
+// criterion, and in a tournament every pair is also judged head to head, both
+// candidates scored in the same call with the order alternating to offset
+// position bias. The ranking is by wins, then by the mean rubric score.
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultRubric is used when no criteria are given
+var DefaultRubric = []string{"correctness", "completeness", "simplicity"}
+
+// JudgeSystemPrompt instructs the judge model to answer with a json verdict
+const JudgeSystemPrompt = `You are a strict, impartial judge of responses to a task. Read the task and each labeled candidate response, then score every candidate from 0 to 10 on every rubric criterion, where 10 is flawless. Judge only the responses, not their length or position.
+
+Respond with only a json object and no other text:
+{"scores": {"<label>": {"<criterion>": <score>, ...}, ...}, "winner": "<label or tie>", "rationale": "<one paragraph explaining the scores>"}
+
+With a single candidate use its label as the winner.`
+
+// Candidate is one response being ranked
+type Candidate struct {
+	Path    string
+	Content string
+}
+
+// JudgeFunc sends a prompt to the judge model and returns its response
+type JudgeFunc func(prompt string) (string, error)
+
+// verdict is the judge's json response
+type verdict struct {
+	Scores    map[string]map[string]float64 `json:"scores"`
+	Winner    string                        `json:"winner"`
+	Rationale string                        `json:"rationale"`
+}
+
+// CandidateScore is a candidate's place in the ranking
+type CandidateScore struct {
+	Path       string             `json:"path"`
+	Rank       int                `json:"rank"`
+	Score      float64            `json:"score"`
+	Wins       float64            `json:"wins"`
+	Scores     map[string]float64 `json:"scores"`
+	Rationales []string           `json:"rationales,omitempty"`
+}
+
+// Match is one head to head judgement
+type Match struct {
+	A         string `json:"a"`
+	B         string `json:"b"`
+	Winner    string `json:"winner"`
+	Rationale string `json:"rationale"`
+}
+
+// Ranking is the result of ranking candidates
+type Ranking struct {
+	Mode       string           `json:"mode"`
+	Judge      string           `json:"judge"`
+	Rubric     []string         `json:"rubric"`
+	Winner     string           `json:"winner"`
+	Candidates []CandidateScore `json:"candidates"`
+	Matches    []Match          `json:"matches,omitempty"`
+}
+
+// judgement is one judge call over one or two candidates
+type judgement struct {
+	candidates []int // indexes into the candidates, in label order
+	verdict    verdict
+}
+
+// RankCandidates scores every candidate on the rubric and, for a tournament,
+// judges every pair, running up to parallel judge calls at once
+func RankCandidates(judge JudgeFunc, task string, candidates []Candidate, rubric []string, tournament bool, parallel int) (Ranking, error) {
+	var groups [][]int
+	for i := range candidates {
+		groups = append(groups, []int{i})
+	}
+	if tournament {
+		for i := range candidates {
+			for j := i + 1; j < len(candidates); j++ {
+				pair := []int{i, j}
+				if len(groups)%2 == 0 {
+					pair = []int{j, i}
+				}
+				groups = append(groups, pair)
+			}
+		}
+	}
+
+	if parallel < 1 {
+		parallel = 1
+	}
+	judgements := make([]judgement, len(groups))
+	errs := make([]error, len(groups))
+	sem := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for n, group := range groups {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			prompt := judgePrompt(task, candidates, group, rubric)
+			var v verdict
+			var err error
+			// a malformed verdict is retried once
+			for attempt := 0; attempt < 2; attempt++ {
+				var response string
+				if response, err = judge(prompt); err != nil {
+					break
+				}
+				if v, err = parseVerdict(response, labels(len(group)), rubric); err == nil {
+					break
+				}
+			}
+			judgements[n] = judgement{candidates: group, verdict: v}
+			errs[n] = err
+		}()
+	}
+	wg.Wait()
+	for n, err := range errs {
+		if err != nil {
+			names := []string{}
+			for _, i := range groups[n] {
+				names = append(names, candidates[i].Path)
+			}
+			return Ranking{}, fmt.Errorf("judging %s: %w", strings.Join(names, " vs "), err)
+		}
+	}
+
+	ranking := tally(candidates, rubric, judgements)
+	ranking.Mode = "rubric"
+	if tournament {
+		ranking.Mode = "tournament"
+	}
+	return ranking, nil
+}
+
+// labels returns the candidate labels of a judge call
+func labels(n int) []string {
+	result := make([]string, n)
+	for i := range result {
+		result[i] = string(rune('A' + i))
+	}
+	return result
+}
+
+// judgePrompt builds the judge input for candidates in group
+func judgePrompt(task string, candidates []Candidate, group []int, rubric []string) string {
+	var b strings.Builder
+	b.WriteString("<NinaInput>\n\n<NinaPrompt>\n\n")
+	b.WriteString(task)
+	b.WriteString("\n\n</NinaPrompt>\n\nRubric criteria: ")
+	b.WriteString(strings.Join(rubric, ", "))
+	b.WriteString("\n\n")
+	for n, i := range group {
+		fmt.Fprintf(&b, "<Candidate label=%q>\n\n%s\n\n</Candidate>\n\n", labels(len(group))[n], strings.TrimSpace(candidates[i].Content))
+	}
+	b.WriteString("</NinaInput>")
+	return b.String()
+}
+
+// parseVerdict extracts the json verdict, requiring a score for every label
+// and criterion, scores are clamped to 0 through 10
+func parseVerdict(response string, labels, rubric []string) (verdict, error) {
+	start := strings.Index(response, "{")
+	end := strings.LastIndex(response, "}")
+	if start == -1 || end < start {
+		return verdict{}, fmt.Errorf("no json object in judge response")
+	}
+	var v verdict
+	if err := json.Unmarshal([]byte(response[start:end+1]), &v); err != nil {
+		return verdict{}, fmt.Errorf("invalid judge response: %w", err)
+	}
+	for _, label := range labels {
+		scores, ok := v.Scores[label]
+		if !ok {
+			return verdict{}, fmt.Errorf("judge response has no scores for %s", label)
+		}
+		for _, criterion := range rubric {
+			score, ok := scores[criterion]
+			if !ok {
+				return verdict{}, fmt.Errorf("judge response has no %s score for %s", criterion, label)
+			}
+			scores[criterion] = min(max(score, 0), 10)
+		}
+	}
+	v.Winner = strings.TrimSpace(v.Winner)
+	if len(labels) > 1 && v.Winner != "tie" && !slices.Contains(labels, v.Winner) {
+		return verdict{}, fmt.Errorf("judge response has unknown winner %q", v.Winner)
+	}
+	return v, nil
+}
+
+// tally averages each candidate's scores over its judgements and counts head
+// to head wins, a tie is half a win for each side
+func tally(candidates []Candidate, rubric []string, judgements []judgement) Ranking {
+	totals := make([]map[string]float64, len(candidates))
+	counts := make([]int, len(candidates))
+	scores := make([]CandidateScore, len(candidates))
+	for i, c := range candidates {
+		totals[i] = map[string]float64{}
+		scores[i] = CandidateScore{Path: c.Path, Scores: map[string]float64{}}
+	}
+	var matches []Match
+	for _, j := range judgements {
+		names := labels(len(j.candidates))
+		for n, i := range j.candidates {
+			for _, criterion := range rubric {
+				totals[i][criterion] += j.verdict.Scores[names[n]][criterion]
+			}
+			counts[i]++
+			if len(j.candidates) == 1 && j.verdict.Rationale != "" {
+				scores[i].Rationales = append(scores[i].Rationales, j.verdict.Rationale)
+			}
+		}
+		if len(j.candidates) != 2 {
+			continue
+		}
+		a, b := j.candidates[0], j.candidates[1]
+		match := Match{A: candidates[a].Path, B: candidates[b].Path, Winner: "tie", Rationale: j.verdict.Rationale}
+		switch j.verdict.Winner {
+		case "A":
+			scores[a].Wins++
+			match.Winner = candidates[a].Path
+		case "B":
+			scores[b].Wins++
+			match.Winner = candidates[b].Path
+		default:
+			scores[a].Wins += 0.5
+			scores[b].Wins += 0.5
+		}
+		matches = append(matches, match)
+	}
+	for i := range scores {
+		if counts[i] == 0 {
+			continue
+		}
+		var sum float64
+		for _, criterion := range rubric {
+			scores[i].Scores[criterion] = totals[i][criterion] / float64(counts[i])
+			sum += scores[i].Scores[criterion]
+		}
+		scores[i].Score = sum / float64(len(rubric))
+	}
+	sort.SliceStable(scores, func(i, j int) bool {
+		if scores[i].Wins != scores[j].Wins {
+			return scores[i].Wins > scores[j].Wins
+		}
+		return scores[i].Score > scores[j].Score
+	})
+	for i := range scores {
+		scores[i].Rank = i + 1
+	}
+	return Ranking{Rubric: rubric, Winner: scores[0].Path, Candidates: scores, Matches: matches}
+}
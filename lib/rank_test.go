@@ -0,0 +1,92 @@
+// Tests for judge verdict parsing and ranking candidates by rubric scores and
+// head to head wins
+package lib
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParseVerdict(t *testing.T) {
+	rubric := []string{"correctness", "simplicity"}
+	v, err := parseVerdict("sure:\n```json\n{\"scores\": {\"A\": {\"correctness\": 12, \"simplicity\": 4}, \"B\": {\"correctness\": 6, \"simplicity\": -1}}, \"winner\": \"A\", \"rationale\": \"A is right\"}\n```", []string{"A", "B"}, rubric)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Scores["A"]["correctness"] != 10 || v.Scores["B"]["simplicity"] != 0 || v.Winner != "A" {
+		t.Errorf("verdict = %+v, want scores clamped", v)
+	}
+	for _, bad := range []string{
+		"no json here",
+		`{"scores": {"A": {"correctness": 5, "simplicity": 5}}, "winner": "A"}`,
+		`{"scores": {"A": {"correctness": 5}, "B": {"correctness": 5, "simplicity": 5}}, "winner": "A"}`,
+		`{"scores": {"A": {"correctness": 5, "simplicity": 5}, "B": {"correctness": 5, "simplicity": 5}}, "winner": "C"}`,
+	} {
+		if _, err := parseVerdict(bad, []string{"A", "B"}, rubric); err == nil {
+			t.Errorf("parseVerdict(%s) succeeded", bad)
+		}
+	}
+}
+
+// fakeJudge scores each candidate by the number in its content and prefers
+// the higher one head to head
+func fakeJudge(prompt string) (string, error) {
+	var labels, values []string
+	for _, part := range strings.Split(prompt, "<Candidate label=\"")[1:] {
+		label, rest, _ := strings.Cut(part, "\">")
+		body, _, _ := strings.Cut(rest, "</Candidate>")
+		labels = append(labels, label)
+		values = append(values, strings.TrimSpace(body))
+	}
+	var scores []string
+	for i, label := range labels {
+		scores = append(scores, fmt.Sprintf(`"%s": {"correctness": %s}`, label, values[i]))
+	}
+	winner := labels[0]
+	if len(labels) == 2 && values[1] > values[0] {
+		winner = labels[1]
+	}
+	return fmt.Sprintf(`{"scores": {%s}, "winner": "%s", "rationale": "by value"}`, strings.Join(scores, ", "), winner), nil
+}
+
+func TestRankCandidates(t *testing.T) {
+	candidates := []Candidate{{"a.md", "3"}, {"b.md", "9"}, {"c.md", "6"}}
+	rubric := []string{"correctness"}
+
+	ranking, err := RankCandidates(fakeJudge, "task", candidates, rubric, false, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ranking.Mode != "rubric" || ranking.Winner != "b.md" || len(ranking.Matches) != 0 {
+		t.Fatalf("ranking = %+v", ranking)
+	}
+
+	ranking, err = RankCandidates(fakeJudge, "task", candidates, rubric, true, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var order []string
+	for _, c := range ranking.Candidates {
+		order = append(order, fmt.Sprintf("%d:%s:%g:%g", c.Rank, c.Path, c.Wins, c.Score))
+	}
+	want := "1:b.md:2:9 2:c.md:1:6 3:a.md:0:3"
+	if got := strings.Join(order, " "); got != want || ranking.Mode != "tournament" || len(ranking.Matches) != 3 {
+		t.Errorf("tournament = %s, %d matches, want %s", got, len(ranking.Matches), want)
+	}
+}
+
+func TestRankCandidatesRetriesMalformedVerdict(t *testing.T) {
+	calls := 0
+	judge := func(prompt string) (string, error) {
+		calls++
+		if calls == 1 {
+			return "not json", nil
+		}
+		return fakeJudge(prompt)
+	}
+	_, err := RankCandidates(judge, "task", []Candidate{{"a.md", "1"}}, []string{"correctness"}, false, 1)
+	if err != nil || calls != 2 {
+		t.Errorf("err = %v after %d calls, want success after a retry", err, calls)
+	}
+}
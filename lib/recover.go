@@ -0,0 +1,117 @@
+// Recovery for malformed responses. ProcessOutput checks a response before
+// running anything in it: a response cut off before </NinaOutput>, usually at
+// the output token limit, tool tags outside NinaOutput, and NinaChange tags
+// that do not pair up run nothing and return a ResponseError, whose
+// suggestion the loop sends back through SUGGEST.md. Broken tags inside a
+// NinaChange only skip that change, see util.CheckNinaChange. A change whose
+// search only fails to match because its NinaSearch and NinaReplace content
+// is wrapped in code fences is applied without them, and the model is asked
+// to stop fencing. The loop gives up after maxInvalidResponses invalid
+// responses in a row.
+package lib
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nathants/nina/util"
+)
+
+// ResponseError is a response that could not be processed, with the
+// correction to send to the model
+type ResponseError struct {
+	Problem    string
+	Suggestion string
+}
+
+func (e *ResponseError) Error() string { return e.Problem }
+
+// balancedTags must open and close the same number of times in NinaOutput,
+// the blocks between them cannot be told apart otherwise
+var balancedTags = [][2]string{
+	{util.NinaStart, util.NinaEnd},
+}
+
+// toolTags are the tags that only run inside NinaOutput
+var toolTags = []string{util.NinaStart, util.NinaBashStart, util.NinaStopStart, util.NinaDeleteStart, util.NinaRenameStart, util.NinaReadStart, util.NinaGrepStart, util.NinaGlobStart, util.NinaSpawnStart, util.NinaKillStart}
+
+// checkResponse returns the NinaOutput content of output, or a ResponseError
+// when nothing in output should run
+func checkResponse(output string, state *LoopState) (string, error) {
+	start := strings.Index(output, util.NinaOutputStart)
+	if start == -1 {
+		for _, tag := range toolTags {
+			if strings.Contains(output, tag) {
+				return "", &ResponseError{
+					Problem:    fmt.Sprintf("%s found outside %s", tag, util.NinaOutputStart),
+					Suggestion: fmt.Sprintf("Your last response had %s outside %s, nothing in it was run. Put every tool tag inside one %s block, without code fences around it.", tag, util.NinaOutputStart, util.NinaOutputStart),
+				}
+			}
+		}
+		if state != nil && state.StopReason == StopContentFilter {
+			return "", contentFilterError()
+		}
+		return "", fmt.Errorf("no valid NinaOutput found in response")
+	}
+	ninaOutput, err := util.ExtractSingle(output, util.NinaOutputStart, util.NinaOutputEnd)
+	if err != nil {
+		if state != nil && state.StopReason == StopContentFilter {
+			return "", contentFilterError()
+		}
+		problem := "response ends before " + util.NinaOutputEnd
+		if state != nil && state.Truncated {
+			problem = "response was cut off at the output token limit before " + util.NinaOutputEnd
+		}
+		return "", &ResponseError{
+			Problem:    problem,
+			Suggestion: fmt.Sprintf("Your last response was cut off before %s, likely at the output token limit, and nothing in it was run. Send fewer changes per response and keep each NinaSearch to the few lines that locate the change.", util.NinaOutputEnd),
+		}
+	}
+	for _, tag := range balancedTags {
+		opened, closed := strings.Count(ninaOutput, tag[0]), strings.Count(ninaOutput, tag[1])
+		if opened != closed {
+			return "", &ResponseError{
+				Problem:    fmt.Sprintf("unbalanced tags: %d %s and %d %s", opened, tag[0], closed, tag[1]),
+				Suggestion: fmt.Sprintf("Your last response had %d %s and %d %s, nothing in it was run. Close every %s with %s and send the response again.", opened, tag[0], closed, tag[1], tag[0], tag[1]),
+			}
+		}
+	}
+	return ninaOutput, nil
+}
+
+// contentFilterError is the ResponseError of a response the provider stopped
+// with a content filter, which continuing would only stop again
+func contentFilterError() *ResponseError {
+	return &ResponseError{
+		Problem:    "response was stopped by the provider's content filter",
+		Suggestion: fmt.Sprintf("Your last response was stopped by the provider's content filter and nothing in it was run. Take a different approach to the task, avoid quoting the content that was flagged, and send a complete %s block.", util.NinaOutputStart),
+	}
+}
+
+// unfence returns search and replace without the code fences wrapping both,
+// and whether there were any
+func unfence(search, replace string) (string, string, bool) {
+	s, ok := stripFence(search)
+	if !ok {
+		return search, replace, false
+	}
+	r, ok := stripFence(replace)
+	if !ok {
+		return search, replace, false
+	}
+	return s, r, true
+}
+
+// stripFence removes a ``` line at the start and end of text
+func stripFence(text string) (string, bool) {
+	lines := strings.Split(strings.Trim(text, "\n"), "\n")
+	if len(lines) < 2 {
+		return text, false
+	}
+	first := strings.TrimSpace(lines[0])
+	last := strings.TrimSpace(lines[len(lines)-1])
+	if !strings.HasPrefix(first, "```") || last != "```" {
+		return text, false
+	}
+	return strings.Join(lines[1:len(lines)-1], "\n"), true
+}
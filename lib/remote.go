@@ -0,0 +1,374 @@
+// Remote sync of session logs under agents/, so a session started on one
+// machine can be continued on another. NINA_REMOTE names the remote: an
+// s3://bucket/prefix url copied with the aws cli, a local or mounted
+// directory, or any rclone remote like gdrive:nina. Only the session
+// directories are copied, artifacts and caches stay on the machine that made
+// them. Nothing is deleted, and a session where both sides logged calls the
+// other lacks is reported as a conflict instead of being merged. The
+// sessions index and memories are merged by line, so a memory forgotten on
+// one machine comes back from another's copy until both forget it.
+package lib
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/nathants/nina/util"
+)
+
+// RemoteTarget returns the configured remote, or an error when unset
+func RemoteTarget() (string, error) {
+	remote := strings.TrimSuffix(os.Getenv("NINA_REMOTE"), "/")
+	if remote == "" {
+		return "", fmt.Errorf("NINA_REMOTE is not set, use s3://bucket/prefix, a directory, or an rclone remote")
+	}
+	return remote, nil
+}
+
+// remoteKind classifies a remote as "s3", "dir", or "rclone"
+func remoteKind(remote string) string {
+	switch {
+	case strings.HasPrefix(remote, "s3://"):
+		return "s3"
+	case strings.HasPrefix(remote, "file://"), filepath.IsAbs(remote), strings.HasPrefix(remote, "."):
+		return "dir"
+	case strings.Contains(remote, ":"):
+		return "rclone"
+	default:
+		return "dir"
+	}
+}
+
+// syncedKinds are the session directories under agents/ synced with a remote
+var syncedKinds = []string{"api", "text", "debug", "ask", "choose"}
+
+// mergedLogs are the jsonl files under agents/ merged by line with a remote
+var mergedLogs = []string{"sessions.jsonl", "memory.jsonl"}
+
+// remoteCopy copies the directories dirs and files files at the top of src
+// into dst without deleting anything, one side is local and the other may be
+// remote
+func remoteCopy(ctx context.Context, remote, src, dst string, dirs, files []string) error {
+	var cmd *exec.Cmd
+	switch remoteKind(remote) {
+	case "s3":
+		// sync only deletes with --delete, it copies new and changed files
+		args := []string{"s3", "sync", "--only-show-errors", "--exclude", "*"}
+		for _, dir := range dirs {
+			args = append(args, "--include", dir+"/*")
+		}
+		for _, file := range files {
+			args = append(args, "--include", file)
+		}
+		cmd = exec.CommandContext(ctx, "aws", append(args, src, dst)...)
+	case "rclone":
+		args := []string{"copy"}
+		for _, dir := range dirs {
+			args = append(args, "--include", "/"+dir+"/**")
+		}
+		for _, file := range files {
+			args = append(args, "--include", "/"+file)
+		}
+		cmd = exec.CommandContext(ctx, "rclone", append(args, src, dst)...)
+	default:
+		src, dst = strings.TrimPrefix(src, "file://"), strings.TrimPrefix(dst, "file://")
+		for _, dir := range dirs {
+			if err := copyTree(filepath.Join(src, dir), filepath.Join(dst, dir), true); err != nil {
+				return err
+			}
+		}
+		for _, file := range files {
+			err := copyFile(filepath.Join(src, file), filepath.Join(dst, file))
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		return nil
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if stderr.Len() > 0 {
+			return fmt.Errorf("%s: %w: %s", strings.Join(cmd.Args[:2], " "), err, strings.TrimSpace(stderr.String()))
+		}
+		return fmt.Errorf("%s: %w", strings.Join(cmd.Args[:2], " "), err)
+	}
+	return nil
+}
+
+// copyTree copies regular files from src into dst, keeping existing files
+// unless overwrite is set. A missing src is empty.
+func copyTree(src, dst string, overwrite bool) error {
+	err := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if d.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		if !overwrite {
+			if _, err := os.Stat(target); err == nil {
+				return nil
+			}
+		}
+		return copyFile(path, target)
+	})
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// mergeLines appends the lines of src missing from dst to dst, a missing
+// file has no lines
+func mergeLines(dst, src string) error {
+	data, err := os.ReadFile(src)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	existing, err := os.ReadFile(dst)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	seen := map[string]bool{}
+	for _, line := range strings.Split(string(existing), "\n") {
+		seen[line] = true
+	}
+	var missing []byte
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == "" || seen[line] {
+			continue
+		}
+		seen[line] = true
+		missing = append(missing, line+"\n"...)
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	if len(existing) > 0 && !bytes.HasSuffix(existing, []byte("\n")) {
+		missing = append([]byte("\n"), missing...)
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(dst, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(missing); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// loggedCall matches the numbered files each api call writes
+var loggedCall = regexp.MustCompile(`^\d+\.`)
+
+// SyncConflict reports sessions where both sides logged calls the other lacks
+type SyncConflict struct {
+	Sessions []string
+}
+
+func (c *SyncConflict) Error() string {
+	return fmt.Sprintf("both local and remote advanced session %s since they were last synced, move one side's copy aside to choose", strings.Join(c.Sessions, ", "))
+}
+
+// sessionCalls returns the numbered log files of each session in an agents
+// directory, keyed by kind/session
+func sessionCalls(agentsDir string) (map[string]map[string][]byte, error) {
+	sessions := map[string]map[string][]byte{}
+	for _, kind := range syncedKinds {
+		entries, err := os.ReadDir(filepath.Join(agentsDir, kind))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				continue
+			}
+			dir := filepath.Join(agentsDir, kind, entry.Name())
+			files, err := os.ReadDir(dir)
+			if err != nil {
+				return nil, err
+			}
+			calls := map[string][]byte{}
+			for _, f := range files {
+				if f.IsDir() || !loggedCall.MatchString(f.Name()) {
+					continue
+				}
+				data, err := os.ReadFile(filepath.Join(dir, f.Name()))
+				if err != nil {
+					return nil, err
+				}
+				calls[f.Name()] = data
+			}
+			sessions[kind+"/"+entry.Name()] = calls
+		}
+	}
+	return sessions, nil
+}
+
+// checkSyncConflicts compares two agents directories, a session conflicts
+// when each side has a call the other lacks or a call differs
+func checkSyncConflicts(localDir, remoteDir string) error {
+	local, err := sessionCalls(localDir)
+	if err != nil {
+		return err
+	}
+	remote, err := sessionCalls(remoteDir)
+	if err != nil {
+		return err
+	}
+	var conflicts []string
+	for session, remoteCalls := range remote {
+		localCalls, ok := local[session]
+		if !ok {
+			continue
+		}
+		localAhead, remoteAhead := false, false
+		for name, data := range remoteCalls {
+			localData, ok := localCalls[name]
+			if !ok {
+				remoteAhead = true
+			} else if !bytes.Equal(localData, data) {
+				localAhead, remoteAhead = true, true
+			}
+		}
+		for name := range localCalls {
+			if _, ok := remoteCalls[name]; !ok {
+				localAhead = true
+			}
+		}
+		if localAhead && remoteAhead {
+			conflicts = append(conflicts, session)
+		}
+	}
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		return &SyncConflict{Sessions: conflicts}
+	}
+	return nil
+}
+
+// stageRemote downloads the synced part of the remote agents directory into
+// a temp directory, the caller removes it
+func stageRemote(ctx context.Context, remote string) (string, error) {
+	staging, err := os.MkdirTemp("", "nina-remote-")
+	if err != nil {
+		return "", err
+	}
+	if err := remoteCopy(ctx, remote, remote, staging, syncedKinds, mergedLogs); err != nil {
+		_ = os.RemoveAll(staging)
+		return "", fmt.Errorf("pull %s: %w", remote, err)
+	}
+	return staging, nil
+}
+
+// PullRemote copies sessions from the remote into agents/, keeping local
+// files and merging the jsonl logs, and fails without changes when a session
+// advanced on both sides
+func PullRemote(ctx context.Context) error {
+	remote, err := RemoteTarget()
+	if err != nil {
+		return err
+	}
+	staging, err := stageRemote(ctx, remote)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.RemoveAll(staging) }()
+	local := util.GetAgentsDir()
+	if err := checkSyncConflicts(local, staging); err != nil {
+		return err
+	}
+	for _, kind := range syncedKinds {
+		if err := copyTree(filepath.Join(staging, kind), filepath.Join(local, kind), false); err != nil {
+			return fmt.Errorf("pull %s: %w", remote, err)
+		}
+	}
+	for _, name := range mergedLogs {
+		if err := mergeLines(filepath.Join(local, name), filepath.Join(staging, name)); err != nil {
+			return fmt.Errorf("pull %s: %w", remote, err)
+		}
+	}
+	util.Infof("pulled sessions from %s", remote)
+	return nil
+}
+
+// PushRemote copies the sessions of agents/ to the remote, merging the jsonl
+// logs, and fails without changes when the remote advanced a session this
+// machine also advanced
+func PushRemote(ctx context.Context) error {
+	remote, err := RemoteTarget()
+	if err != nil {
+		return err
+	}
+	staging, err := stageRemote(ctx, remote)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.RemoveAll(staging) }()
+	local := util.GetAgentsDir()
+	if err := checkSyncConflicts(local, staging); err != nil {
+		return err
+	}
+	if err := remoteCopy(ctx, remote, local, remote, syncedKinds, nil); err != nil {
+		return fmt.Errorf("push %s: %w", remote, err)
+	}
+	// the staged remote logs take the local lines they lack, then replace
+	// the remote's
+	for _, name := range mergedLogs {
+		if err := mergeLines(filepath.Join(staging, name), filepath.Join(local, name)); err != nil {
+			return fmt.Errorf("push %s: %w", remote, err)
+		}
+	}
+	if err := remoteCopy(ctx, remote, staging, remote, nil, mergedLogs); err != nil {
+		return fmt.Errorf("push %s: %w", remote, err)
+	}
+	util.Infof("pushed sessions to %s", remote)
+	return nil
+}
@@ -0,0 +1,128 @@
+// Tests for remote session sync covering conflict detection between two
+// agents directories, copying through a directory remote, and merging the
+// jsonl logs
+package lib
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeCall(t *testing.T, agentsDir, session, name, data string) {
+	t.Helper()
+	path := filepath.Join(agentsDir, "api", session, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckSyncConflicts(t *testing.T) {
+	local, remote := t.TempDir(), t.TempDir()
+	writeCall(t, local, "20250101-000000", "00001.input.json", "a")
+	writeCall(t, remote, "20250101-000000", "00001.input.json", "a")
+	writeCall(t, remote, "20250101-000000", "00002.input.json", "b")
+	writeCall(t, local, "20250101-000000", "model.json", "local")
+	if err := checkSyncConflicts(local, remote); err != nil {
+		t.Errorf("remote ahead only should not conflict: %v", err)
+	}
+
+	writeCall(t, local, "20250101-000000", "00003.input.json", "c")
+	var conflict *SyncConflict
+	if err := checkSyncConflicts(local, remote); !errors.As(err, &conflict) || len(conflict.Sessions) != 1 || conflict.Sessions[0] != "api/20250101-000000" {
+		t.Errorf("both sides ahead should conflict, got %v", err)
+	}
+
+	other := t.TempDir()
+	writeCall(t, other, "20250101-000000", "00001.input.json", "changed")
+	if err := checkSyncConflicts(local, other); !errors.As(err, &conflict) {
+		t.Errorf("a differing call should conflict, got %v", err)
+	}
+}
+
+func TestRemoteDirectoryCopy(t *testing.T) {
+	local, remote := t.TempDir(), t.TempDir()
+	writeCall(t, remote, "20250101-000000", "00001.input.json", "a")
+	writeCall(t, local, "20250101-000000", "model.json", "local")
+	writeCall(t, remote, "20250101-000000", "model.json", "remote")
+	writeFile(t, filepath.Join(remote, "artifacts", "big.txt"), "artifact")
+
+	staging := t.TempDir()
+	if err := remoteCopy(context.Background(), remote, remote, staging, syncedKinds, mergedLogs); err != nil {
+		t.Fatal(err)
+	}
+	if err := copyTree(staging, local, false); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(local, "api", "20250101-000000", "00001.input.json"))
+	if err != nil || string(data) != "a" {
+		t.Errorf("pulled call = %q, %v", data, err)
+	}
+	data, _ = os.ReadFile(filepath.Join(local, "api", "20250101-000000", "model.json"))
+	if string(data) != "local" {
+		t.Errorf("pull overwrote a local file: %q", data)
+	}
+	if _, err := os.Stat(filepath.Join(local, "artifacts")); !os.IsNotExist(err) {
+		t.Errorf("artifacts should not be copied: %v", err)
+	}
+	if kind := remoteKind("gdrive:nina"); kind != "rclone" {
+		t.Errorf("remoteKind(gdrive:nina) = %s", kind)
+	}
+	if kind := remoteKind("s3://bucket/agents"); kind != "s3" {
+		t.Errorf("remoteKind(s3://...) = %s", kind)
+	}
+}
+
+func writeFile(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPushPullMergesLogs(t *testing.T) {
+	t.Chdir(t.TempDir())
+	remote := t.TempDir()
+	t.Setenv("NINA_REMOTE", remote)
+	writeFile(t, filepath.Join("agents", "sessions.jsonl"), "{\"id\":\"a\"}\n{\"id\":\"b\"}\n")
+	writeFile(t, filepath.Join("agents", "convert-cache", "key.json"), "{}")
+	writeFile(t, filepath.Join("agents", "artifacts", "big.txt"), "artifact")
+	writeCall(t, "agents", "20250101-000000", "00001.input.json", "a")
+	writeFile(t, filepath.Join(remote, "sessions.jsonl"), "{\"id\":\"c\"}\n{\"id\":\"a\"}\n")
+	writeFile(t, filepath.Join(remote, "memory.jsonl"), "{\"id\":\"m\"}\n")
+
+	if err := PushRemote(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(filepath.Join(remote, "sessions.jsonl"))
+	if string(data) != "{\"id\":\"c\"}\n{\"id\":\"a\"}\n{\"id\":\"b\"}\n" {
+		t.Errorf("pushed sessions.jsonl = %q, want the remote lines then the local ones it lacked", data)
+	}
+	if _, err := os.Stat(filepath.Join(remote, "api", "20250101-000000", "00001.input.json")); err != nil {
+		t.Errorf("session not pushed: %v", err)
+	}
+	for _, dir := range []string{"artifacts", "convert-cache"} {
+		if _, err := os.Stat(filepath.Join(remote, dir)); !os.IsNotExist(err) {
+			t.Errorf("%s should stay local: %v", dir, err)
+		}
+	}
+
+	if err := PullRemote(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	data, _ = os.ReadFile(filepath.Join("agents", "sessions.jsonl"))
+	if string(data) != "{\"id\":\"a\"}\n{\"id\":\"b\"}\n{\"id\":\"c\"}\n" {
+		t.Errorf("pulled sessions.jsonl = %q", data)
+	}
+	if data, _ = os.ReadFile(filepath.Join("agents", "memory.jsonl")); string(data) != "{\"id\":\"m\"}\n" {
+		t.Errorf("pulled memory.jsonl = %q", data)
+	}
+}
@@ -0,0 +1,235 @@
+// Replay feeds the responses recorded in an agents/api session back through
+// the loop instead of calling a provider, so parser and tool processor
+// changes can be debugged and regression tested offline against real
+// transcripts. Tools still run, so replay from a checkout of the same commit
+// the session started from to reproduce it.
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nathants/nina/providers/claude"
+	"github.com/nathants/nina/providers/gemini"
+	"github.com/nathants/nina/providers/grok"
+	"github.com/nathants/nina/providers/groq"
+	"github.com/nathants/nina/providers/ollama"
+	"github.com/nathants/nina/providers/openai"
+	"github.com/nathants/nina/util"
+)
+
+// ReplayClient is an AIProvider that returns recorded responses in order
+type ReplayClient struct {
+	dir   string
+	files []string
+	next  int
+}
+
+// ReplayResponse is one recorded response
+type ReplayResponse struct {
+	Path       string
+	Text       string
+	Usage      TokenUsage
+	StopReason string // normalized, see stopreason.go
+}
+
+// ReplaySessionDir resolves a session to its agents/api directory. The
+// session is a directory path, a session timestamp, or latest.
+func ReplaySessionDir(session string) (string, error) {
+	apiDir := util.GetAgentsSubdir("api")
+	if session == "latest" {
+		entries, err := os.ReadDir(apiDir)
+		if err != nil {
+			return "", fmt.Errorf("failed to read api directory: %w", err)
+		}
+		var sessions []string
+		for _, entry := range entries {
+			if entry.IsDir() {
+				sessions = append(sessions, entry.Name())
+			}
+		}
+		if len(sessions) == 0 {
+			return "", fmt.Errorf("no recorded sessions in %s", apiDir)
+		}
+		sort.Strings(sessions)
+		return filepath.Join(apiDir, sessions[len(sessions)-1]), nil
+	}
+	if info, err := os.Stat(session); err == nil && info.IsDir() {
+		return session, nil
+	}
+	dir := filepath.Join(apiDir, session)
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return "", fmt.Errorf("no recorded session %s in %s", session, apiDir)
+	}
+	return dir, nil
+}
+
+// NewReplayClient loads the recorded responses of a session
+func NewReplayClient(session string) (*ReplayClient, error) {
+	dir, err := ReplaySessionDir(session)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), ".output.json") {
+			files = append(files, entry.Name())
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no recorded responses in %s", dir)
+	}
+	// groq logs are not zero padded, so sort by number
+	sort.Slice(files, func(i, j int) bool {
+		return logIndex(files[i]) < logIndex(files[j])
+	})
+	return &ReplayClient{dir: dir, files: files}, nil
+}
+
+func logIndex(name string) int {
+	n, _ := strconv.Atoi(strings.SplitN(name, ".", 2)[0])
+	return n
+}
+
+// Dir returns the session directory being replayed
+func (c *ReplayClient) Dir() string {
+	return c.dir
+}
+
+// Call returns the next recorded response
+func (c *ReplayClient) Call(ctx context.Context, model, systemPrompt, userMessage string) (any, error) {
+	if c.next >= len(c.files) {
+		return nil, fmt.Errorf("replay exhausted after %d responses from %s", len(c.files), c.dir)
+	}
+	path := filepath.Join(c.dir, c.files[c.next])
+	c.next++
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := parseRecordedResponse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	util.Verbosef("replaying %s", path)
+	resp.Path = path
+	return resp, nil
+}
+
+// CallWithStore returns the next recorded response
+func (c *ReplayClient) CallWithStore(ctx context.Context, model, systemPrompt, userMessage string) (any, error) {
+	return c.Call(ctx, model, systemPrompt, userMessage)
+}
+
+// CallWithTools returns the next recorded response
+func (c *ReplayClient) CallWithTools(ctx context.Context, model, systemPrompt, userMessage string, tools []any) (any, error) {
+	return c.Call(ctx, model, systemPrompt, userMessage)
+}
+
+// SupportsTools returns false, recorded sessions use xml tool calls
+func (c *ReplayClient) SupportsTools() bool {
+	return false
+}
+
+// GetTokenUsage returns the usage recorded with the response
+func (c *ReplayClient) GetTokenUsage(resp any) (promptTokens, completionTokens, totalTokens int) {
+	usage := c.GetDetailedUsage(resp)
+	return usage.Input, usage.Output, usage.Input + usage.Output
+}
+
+// GetDetailedUsage returns the usage recorded with the response
+func (c *ReplayClient) GetDetailedUsage(resp any) TokenUsage {
+	if r, ok := resp.(*ReplayResponse); ok {
+		return r.Usage
+	}
+	return TokenUsage{}
+}
+
+// CompactMessages does nothing, there is no history to send
+func (c *ReplayClient) CompactMessages(messagePairs int) CompactionResult {
+	return CompactionResult{}
+}
+
+// parseRecordedResponse extracts the text, usage, and whether it was cut off
+// at the output token limit from an output.json written by any of the run
+// clients
+func parseRecordedResponse(data []byte) (*ReplayResponse, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	switch {
+	case fields["response"] != nil: // claude, wrapped with the message history
+		var r claude.Response
+		if err := json.Unmarshal(fields["response"], &r); err != nil {
+			return nil, err
+		}
+		var text strings.Builder
+		for _, content := range r.Content {
+			if content.Type == "text" {
+				text.WriteString(content.Text)
+			}
+		}
+		return &ReplayResponse{Text: text.String(), Usage: ClaudeTokenUsage(r.Usage), StopReason: normalizeStopReason(r.StopReason)}, nil
+	case fields["output"] != nil: // openai
+		var r openai.Response
+		if err := json.Unmarshal(data, &r); err != nil {
+			return nil, err
+		}
+		var text strings.Builder
+		for _, output := range r.Output {
+			for _, content := range output.Content {
+				if content.Type == "output_text" {
+					text.WriteString(content.Text)
+				}
+			}
+		}
+		return &ReplayResponse{Text: text.String(), Usage: OpenAITokenUsage(&r.Usage), StopReason: openaiStopReason(&r)}, nil
+	case fields["choices"] != nil: // grok
+		var r grok.Response
+		if err := json.Unmarshal(data, &r); err != nil {
+			return nil, err
+		}
+		if len(r.Choices) == 0 {
+			return nil, fmt.Errorf("recorded response has no choices")
+		}
+		return &ReplayResponse{Text: r.Choices[0].Message.Content, Usage: (&GrokClient{}).GetDetailedUsage(&r), StopReason: normalizeStopReason(r.Choices[0].FinishReason)}, nil
+	case fields["Text"] != nil: // groq
+		var r groq.HandleResponse
+		if err := json.Unmarshal(data, &r); err != nil {
+			return nil, err
+		}
+		return &ReplayResponse{Text: r.Text, Usage: (&GroqClient{}).GetDetailedUsage(&r), StopReason: normalizeStopReason(r.FinishReason)}, nil
+	case fields["message"] != nil: // ollama
+		var r ollama.ChatResponse
+		if err := json.Unmarshal(data, &r); err != nil {
+			return nil, err
+		}
+		return &ReplayResponse{Text: r.Message.Content, Usage: OllamaTokenUsage(&r), StopReason: normalizeStopReason(r.DoneReason)}, nil
+	case fields["text"] != nil: // gemini, older recordings have no usage
+		var r struct {
+			Text         string        `json:"text"`
+			FinishReason string        `json:"finish_reason"`
+			Usage        *gemini.Usage `json:"usage"`
+		}
+		if err := json.Unmarshal(data, &r); err != nil {
+			return nil, err
+		}
+		resp := &ReplayResponse{Text: r.Text, Usage: TokenUsage{Output: len(r.Text) / 4}, StopReason: normalizeStopReason(r.FinishReason)}
+		if r.Usage != nil {
+			resp.Usage = GeminiTokenUsage(*r.Usage)
+		}
+		return resp, nil
+	}
+	return nil, fmt.Errorf("unrecognized recorded response")
+}
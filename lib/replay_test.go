@@ -0,0 +1,51 @@
+package lib
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReplayClient(t *testing.T) {
+	dir := t.TempDir()
+	recorded := map[string]string{
+		"00001.output.json": `{"response": {"content": [{"type": "text", "text": "first"}], "usage": {"input_tokens": 10, "output_tokens": 2}}, "messages": []}`,
+		"00002.output.json": `{"output": [{"content": [{"type": "output_text", "text": "second"}]}], "usage": {"input_tokens": 20, "output_tokens": 3}}`,
+		"00003.output.json": `{"model": "gemini", "text": "third", "reasoning": ""}`,
+		"00003.input.json":  `{}`,
+		"10.output.json":    `{"Text": "fourth", "Usage": {"prompt_tokens": 40, "completion_tokens": 5}}`,
+	}
+	for name, data := range recorded {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	client, err := NewReplayClient(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		text  string
+		input int
+	}{{"first", 10}, {"second", 20}, {"third", 0}, {"fourth", 40}}
+	for _, w := range want {
+		resp, err := client.Call(context.Background(), "", "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := resp.(*ReplayResponse)
+		if r.Text != w.text || r.Usage.Input != w.input {
+			t.Errorf("got %q %d, want %q %d", r.Text, r.Usage.Input, w.text, w.input)
+		}
+	}
+	if _, err := client.Call(context.Background(), "", "", ""); err == nil || !strings.Contains(err.Error(), "exhausted") {
+		t.Errorf("expected exhausted error, got %v", err)
+	}
+
+	if _, err := NewReplayClient(filepath.Join(dir, "missing")); err == nil {
+		t.Error("expected error for missing session")
+	}
+}
@@ -0,0 +1,211 @@
+// Retention for session logs under agents/. Each session writes a directory
+// named by its timestamp into agents/{api,text,debug,ask,choose,artifacts},
+// and the converter writes one file per search in agents/convert-cache and
+// agents/convert-corpus, pruning removes the oldest by age and then by total size. A session with a
+// .keep file in any of its directories, like agents/api/<id>/.keep, is never
+// pruned.
+package lib
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nathants/nina/util"
+)
+
+// SessionKinds are the agents/ subdirectories holding per session directories
+var SessionKinds = []string{"api", "text", "debug", "ask", "choose", "artifacts", "convert-cache", "convert-corpus"}
+
+// fileKinds are SessionKinds holding one file per entry instead of a directory
+var fileKinds = map[string]bool{"convert-cache": true, "convert-corpus": true}
+
+// RetentionPolicy bounds session logs, zero values disable a limit
+type RetentionPolicy struct {
+	MaxAge  time.Duration
+	MaxSize int64
+}
+
+// SessionDir is one session directory of one kind, or one cache file
+type SessionDir struct {
+	Path      string
+	Kind      string
+	ID        string
+	Size      int64
+	ModTime   time.Time
+	Protected bool
+}
+
+// ParseAge parses "30d" or a Go duration like "72h"
+func ParseAge(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil {
+			return time.Duration(n) * 24 * time.Hour, nil
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid age: %s", s)
+	}
+	return d, nil
+}
+
+// ParseSize parses a byte count with an optional K, M, or G suffix
+func ParseSize(s string) (int64, error) {
+	upper := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B")
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(upper, "K"):
+		multiplier = 1 << 10
+	case strings.HasSuffix(upper, "M"):
+		multiplier = 1 << 20
+	case strings.HasSuffix(upper, "G"):
+		multiplier = 1 << 30
+	}
+	if multiplier > 1 {
+		upper = upper[:len(upper)-1]
+	}
+	n, err := strconv.ParseInt(upper, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size: %s", s)
+	}
+	return n * multiplier, nil
+}
+
+// RetentionPolicyFromEnv reads NINA_RETENTION_MAX_AGE and NINA_RETENTION_MAX_SIZE
+func RetentionPolicyFromEnv() (RetentionPolicy, error) {
+	var policy RetentionPolicy
+	var err error
+	if value := os.Getenv("NINA_RETENTION_MAX_AGE"); value != "" {
+		if policy.MaxAge, err = ParseAge(value); err != nil {
+			return policy, fmt.Errorf("NINA_RETENTION_MAX_AGE: %w", err)
+		}
+	}
+	if value := os.Getenv("NINA_RETENTION_MAX_SIZE"); value != "" {
+		if policy.MaxSize, err = ParseSize(value); err != nil {
+			return policy, fmt.Errorf("NINA_RETENTION_MAX_SIZE: %w", err)
+		}
+	}
+	return policy, nil
+}
+
+// ListSessionDirs returns all session directories, oldest first. The current
+// session, and sessions with a .keep file in any of their directories, are
+// protected.
+func ListSessionDirs() ([]SessionDir, error) {
+	kept := map[string]bool{}
+	var dirs []SessionDir
+	for _, kind := range SessionKinds {
+		kindDir := util.GetAgentsSubdir(kind)
+		entries, err := os.ReadDir(kindDir)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() && !fileKinds[kind] {
+				continue
+			}
+			dir := SessionDir{
+				Path: filepath.Join(kindDir, entry.Name()),
+				Kind: kind,
+				ID:   entry.Name(),
+			}
+			err := filepath.WalkDir(dir.Path, func(path string, d fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				info, err := d.Info()
+				if err != nil {
+					return err
+				}
+				if info.ModTime().After(dir.ModTime) {
+					dir.ModTime = info.ModTime()
+				}
+				if d.Name() == ".keep" {
+					dir.Protected = true
+				}
+				if !d.IsDir() {
+					dir.Size += info.Size()
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+			if dir.Protected || (sessionTimestamp != "" && dir.ID == sessionTimestamp) {
+				kept[dir.ID] = true
+			}
+			dirs = append(dirs, dir)
+		}
+	}
+	for i := range dirs {
+		dirs[i].Protected = kept[dirs[i].ID]
+	}
+	sort.SliceStable(dirs, func(i, j int) bool { return dirs[i].ModTime.Before(dirs[j].ModTime) })
+	return dirs, nil
+}
+
+// PlanPrune returns the unprotected directories to remove under policy. dirs
+// must be sorted oldest first.
+func PlanPrune(dirs []SessionDir, policy RetentionPolicy, now time.Time) []SessionDir {
+	var prune []SessionDir
+	var total int64
+	for _, dir := range dirs {
+		total += dir.Size
+	}
+	for _, dir := range dirs {
+		if dir.Protected {
+			continue
+		}
+		expired := policy.MaxAge > 0 && now.Sub(dir.ModTime) > policy.MaxAge
+		oversize := policy.MaxSize > 0 && total > policy.MaxSize
+		if expired || oversize {
+			prune = append(prune, dir)
+			total -= dir.Size
+		}
+	}
+	return prune
+}
+
+// PruneSessions removes session directories exceeding policy, returning what
+// was removed, or what would be with dryRun
+func PruneSessions(policy RetentionPolicy, dryRun bool) ([]SessionDir, error) {
+	if policy.MaxAge <= 0 && policy.MaxSize <= 0 {
+		return nil, nil
+	}
+	dirs, err := ListSessionDirs()
+	if err != nil {
+		return nil, err
+	}
+	prune := PlanPrune(dirs, policy, time.Now())
+	if dryRun {
+		return prune, nil
+	}
+	for i, dir := range prune {
+		if err := os.RemoveAll(dir.Path); err != nil {
+			return prune[:i], err
+		}
+	}
+	return prune, nil
+}
+
+// applyRetentionFromEnv prunes automatically when a policy is configured,
+// failures only warn so logging never blocks a session
+func applyRetentionFromEnv() {
+	policy, err := RetentionPolicyFromEnv()
+	if err != nil {
+		util.Errorf("warning: retention: %v", err)
+		return
+	}
+	if _, err := PruneSessions(policy, false); err != nil {
+		util.Errorf("warning: retention: %v", err)
+	}
+}
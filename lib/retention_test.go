@@ -0,0 +1,115 @@
+// Tests for session retention planning by age and total size, listing cache
+// files, and parsing of the retention limits
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/nathants/nina/util"
+)
+
+func TestPlanPrune(t *testing.T) {
+	now := time.Date(2025, 7, 1, 0, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	dirs := []SessionDir{
+		{ID: "old", Size: 100, ModTime: now.Add(-40 * day)},
+		{ID: "saved", Size: 100, ModTime: now.Add(-35 * day), Protected: true},
+		{ID: "week", Size: 100, ModTime: now.Add(-7 * day)},
+		{ID: "new", Size: 100, ModTime: now.Add(-time.Hour)},
+	}
+	tests := []struct {
+		name   string
+		policy RetentionPolicy
+		want   []string
+	}{
+		{"disabled", RetentionPolicy{}, nil},
+		{"age", RetentionPolicy{MaxAge: 30 * day}, []string{"old"}},
+		{"size", RetentionPolicy{MaxSize: 250}, []string{"old", "week"}},
+		{"age and size", RetentionPolicy{MaxAge: 30 * day, MaxSize: 300}, []string{"old"}},
+		{"protected survive", RetentionPolicy{MaxAge: time.Minute, MaxSize: 1}, []string{"old", "week", "new"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, dir := range PlanPrune(dirs, tt.policy, now) {
+				got = append(got, dir.ID)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("PlanPrune() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRetentionLimits(t *testing.T) {
+	if got, err := ParseAge("30d"); err != nil || got != 30*24*time.Hour {
+		t.Errorf("ParseAge(30d) = %v, %v", got, err)
+	}
+	if got, err := ParseAge("72h"); err != nil || got != 72*time.Hour {
+		t.Errorf("ParseAge(72h) = %v, %v", got, err)
+	}
+	if _, err := ParseAge("soon"); err == nil {
+		t.Error("ParseAge(soon) expected error")
+	}
+	sizes := map[string]int64{"512": 512, "10K": 10 << 10, "500M": 500 << 20, "2gb": 2 << 30}
+	for input, want := range sizes {
+		if got, err := ParseSize(input); err != nil || got != want {
+			t.Errorf("ParseSize(%s) = %v, %v, want %v", input, got, err, want)
+		}
+	}
+	if _, err := ParseSize("-1M"); err == nil {
+		t.Error("ParseSize(-1M) expected error")
+	}
+}
+
+func TestListSessionDirsCacheFiles(t *testing.T) {
+	t.Chdir(t.TempDir())
+	saveConvertCache("content", "search", util.RangeResult{Start: 1, End: 1})
+	if err := os.MkdirAll(filepath.Join("agents", "api", "20250701-000000"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join("agents", "api", "stray.txt"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dirs, err := ListSessionDirs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, dir := range dirs {
+		got = append(got, dir.Kind+"/"+dir.ID)
+	}
+	slices.Sort(got)
+	want := []string{"api/20250701-000000", "convert-cache/" + convertCacheKey("content", "search") + ".json"}
+	if !slices.Equal(got, want) {
+		t.Errorf("ListSessionDirs() = %v, want %v", got, want)
+	}
+}
+
+func TestListSessionDirsKeep(t *testing.T) {
+	t.Chdir(t.TempDir())
+	for _, dir := range []string{"api/kept", "text/kept", "api/other"} {
+		if err := os.MkdirAll(filepath.Join("agents", dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join("agents", "api", "kept", ".keep"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	dirs, err := ListSessionDirs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range dirs {
+		if dir.Protected != (dir.ID == "kept") {
+			t.Errorf("%s/%s protected = %v", dir.Kind, dir.ID, dir.Protected)
+		}
+	}
+	if len(dirs) != 3 {
+		t.Errorf("ListSessionDirs() = %d dirs, want 3", len(dirs))
+	}
+}
@@ -0,0 +1,299 @@
+// Index of past runs. When a top level nina run ends it appends a record to
+// agents/sessions.jsonl, or $NINA_SESSIONS_FILE: the session id naming its
+// agents/ directories, a short title, the prompt, and the files the run
+// changed. With --title-model a cheap model writes the title from the first
+// prompt before the first step, so the title call is not the last one a
+// --continue picks up, otherwise the title is the prompt's first line.
+// `nina sessions search` greps titles, prompts, and files to find a past
+// run, and a continued session's records are merged into one. Records also
+// hold whether the last test command the run ran passed, and tags added with
+// `nina sessions tag`, which pick the sessions `nina dataset` learns from.
+package lib
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nathants/nina/util"
+)
+
+// maxIndexedPrompt bounds the prompt bytes kept in the index
+const maxIndexedPrompt = 4000
+
+// maxTitleLength bounds titles, from the model or the prompt
+const maxTitleLength = 80
+
+const titleSystemPrompt = `You title coding sessions. Reply with a title of at most 8 words describing the task of the prompt, like "Fix tokenizer off by one in parser". Reply with the title only, no quotes or punctuation at the end.`
+
+// SessionRecord is one run in the sessions index
+type SessionRecord struct {
+	ID     string    `json:"id"`
+	Time   time.Time `json:"time"`
+	Model  string    `json:"model"`
+	Title  string    `json:"title"`
+	Prompt string    `json:"prompt"`
+	Files  []string  `json:"files,omitempty"`
+	Tests  string    `json:"tests,omitempty"` // TestsPassed or TestsFailed, the last test command's result
+	Tags   []string  `json:"tags,omitempty"`
+}
+
+// Results of the last test command of a session
+const (
+	TestsPassed = "passed"
+	TestsFailed = "failed"
+)
+
+// testCommand matches NinaBash commands that run a test suite
+var testCommand = regexp.MustCompile(`\b(go test|pytest|cargo test|(npm|yarn|pnpm)( run)? test|make (test|check)|jest|vitest|mvn test|gradle test|bin/check\.sh)\b`)
+
+// SessionsIndexPath returns the sessions index of the current project
+func SessionsIndexPath() string {
+	if path := os.Getenv("NINA_SESSIONS_FILE"); path != "" {
+		return path
+	}
+	return filepath.Join(util.GetAgentsDir(), "sessions.jsonl")
+}
+
+var sessionsMu sync.Mutex
+
+// AppendSession appends a record to the sessions index
+func AppendSession(record SessionRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	path := SessionsIndexPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+// ReadSessions returns the indexed sessions, newest first. Records of a
+// continued session are merged, keeping the first title and every prompt and
+// file.
+func ReadSessions() ([]SessionRecord, error) {
+	f, err := os.Open(SessionsIndexPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	byID := map[string]*SessionRecord{}
+	var ids []string
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var record SessionRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", SessionsIndexPath(), line, err)
+		}
+		existing, ok := byID[record.ID]
+		if !ok {
+			byID[record.ID] = &record
+			ids = append(ids, record.ID)
+			continue
+		}
+		if !record.Time.IsZero() {
+			existing.Time = record.Time
+		}
+		if record.Model != "" {
+			existing.Model = record.Model
+		}
+		if existing.Title == "" {
+			existing.Title = record.Title
+		}
+		if record.Prompt != "" {
+			existing.Prompt = strings.TrimSpace(existing.Prompt + "\n\n" + record.Prompt)
+		}
+		if record.Tests != "" {
+			existing.Tests = record.Tests
+		}
+		for _, file := range record.Files {
+			if !slices.Contains(existing.Files, file) {
+				existing.Files = append(existing.Files, file)
+			}
+		}
+		for _, tag := range record.Tags {
+			if !slices.Contains(existing.Tags, tag) {
+				existing.Tags = append(existing.Tags, tag)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	records := make([]SessionRecord, 0, len(ids))
+	for _, id := range ids {
+		records = append(records, *byID[id])
+	}
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Time.After(records[j].Time) })
+	return records, nil
+}
+
+// SearchSessions returns the records where every term appears, ignoring
+// case, in the title, the prompt, or a changed file
+func SearchSessions(records []SessionRecord, terms []string) []SessionRecord {
+	var matches []SessionRecord
+	for _, record := range records {
+		text := strings.ToLower(record.Title + "\n" + record.Prompt + "\n" + strings.Join(record.Files, "\n"))
+		matched := true
+		for _, term := range terms {
+			if !strings.Contains(text, strings.ToLower(term)) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			matches = append(matches, record)
+		}
+	}
+	return matches
+}
+
+// promptTitle is the title used without a title model, the first line of the
+// prompt
+func promptTitle(prompt string) string {
+	for _, line := range strings.Split(prompt, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			if len(line) > maxTitleLength {
+				line = strings.TrimSpace(line[:maxTitleLength]) + "..."
+			}
+			return line
+		}
+	}
+	return ""
+}
+
+// sessionTitle asks the title model for a title of prompt, falling back to
+// its first line when there is no title model or the call fails
+func sessionTitle(config LoopConfig, prompt string) string {
+	if strings.TrimSpace(prompt) == "" {
+		return ""
+	}
+	if config.TitleModel == "" && config.Titler == nil {
+		return promptTitle(prompt)
+	}
+	provider, model := config.Titler, config.TitleModel
+	if provider == nil {
+		var err error
+		if provider, model, err = CreateProviderForModel(config.TitleModel); err != nil {
+			LogError("Warning: failed to create title provider: %v", err)
+			return promptTitle(prompt)
+		}
+	}
+	if len(prompt) > maxIndexedPrompt {
+		prompt = prompt[:maxIndexedPrompt]
+	}
+	title, err := callAIProvider(context.Background(), provider, model, titleSystemPrompt, prompt, &LoopState{}, false)
+	title = strings.Trim(strings.TrimSpace(title), `"'.`)
+	if err != nil || title == "" || strings.Contains(title, "\n") || len(title) > maxTitleLength {
+		return promptTitle(prompt)
+	}
+	return title
+}
+
+// recordChangedFiles adds the files written by events to the session's
+// changed files
+func recordChangedFiles(state *LoopState, events []ProcessorEvent) {
+	for _, event := range events {
+		if event.Reason != "" || event.Filepath == "" {
+			continue
+		}
+		switch event.Type {
+		case "NinaChange", "NinaDelete", "NinaRename":
+			if state.ChangedFiles == nil {
+				state.ChangedFiles = map[string]bool{}
+			}
+			state.ChangedFiles[event.Filepath] = true
+			for _, path := range event.Args {
+				state.ChangedFiles[path] = true
+			}
+		}
+	}
+}
+
+// recordTests records the result of the last test command of events
+func recordTests(state *LoopState, events []ProcessorEvent) {
+	for _, event := range events {
+		if event.Type != "NinaBash" || event.Reason != "" || !testCommand.MatchString(event.Cmd) {
+			continue
+		}
+		state.Tests = TestsFailed
+		if event.ExitCode == 0 {
+			state.Tests = TestsPassed
+		}
+	}
+}
+
+// TagSession adds tags to an indexed session
+func TagSession(id string, tags []string) error {
+	records, err := ReadSessions()
+	if err != nil {
+		return err
+	}
+	if !slices.ContainsFunc(records, func(r SessionRecord) bool { return r.ID == id }) {
+		return fmt.Errorf("no session %s in %s", id, SessionsIndexPath())
+	}
+	return AppendSession(SessionRecord{ID: id, Tags: tags})
+}
+
+// indexSession appends the session to the sessions index once a step has
+// run, failures only warn
+func indexSession(state *LoopState) {
+	if state.stepsRun == 0 {
+		return
+	}
+	prompt := util.Redact(strings.TrimSpace(state.config.StdinContent))
+	files := map[string]bool{}
+	for path := range state.ChangedFiles {
+		files[path] = true
+	}
+	for path := range state.ExternalChanges {
+		files[path] = true
+	}
+	if prompt == "" && len(files) == 0 && state.Tests == "" {
+		return
+	}
+	record := SessionRecord{
+		ID:    GetSessionTimestamp(),
+		Time:  time.Now().UTC(),
+		Model: state.config.Model,
+		Title: state.Title,
+		Tests: state.Tests,
+	}
+	if len(prompt) > maxIndexedPrompt {
+		prompt = prompt[:maxIndexedPrompt]
+	}
+	record.Prompt = prompt
+	for path := range files {
+		record.Files = append(record.Files, path)
+	}
+	sort.Strings(record.Files)
+	if err := AppendSession(record); err != nil {
+		LogError("Warning: failed to index the session: %v", err)
+	}
+}
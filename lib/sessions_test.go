@@ -0,0 +1,109 @@
+// Tests for the sessions index covering titles, merging continued sessions,
+// and searching titles, prompts, and changed files
+package lib
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSessionTitle(t *testing.T) {
+	if got := sessionTitle(LoopConfig{}, "\n  fix the tokenizer\nthen run tests"); got != "fix the tokenizer" {
+		t.Errorf("sessionTitle() = %q, want the first line", got)
+	}
+	titler := NewMockClient(`"Fix tokenizer off by one."`)
+	if got := sessionTitle(LoopConfig{Titler: titler}, "fix the tokenizer"); got != "Fix tokenizer off by one" {
+		t.Errorf("sessionTitle() = %q, want the model's title", got)
+	}
+	failing := NewMockClient()
+	if got := sessionTitle(LoopConfig{Titler: failing}, "fix the tokenizer"); got != "fix the tokenizer" {
+		t.Errorf("sessionTitle() = %q, want the first line when the model fails", got)
+	}
+}
+
+func TestSessionsIndex(t *testing.T) {
+	t.Setenv("NINA_SESSIONS_FILE", t.TempDir()+"/sessions.jsonl")
+	now := time.Now().UTC()
+	for _, record := range []SessionRecord{
+		{ID: "20250101-120000", Time: now.Add(-time.Hour), Model: "o3", Title: "Fix tokenizer", Prompt: "fix the tokenizer", Files: []string{"util/parsing.go"}},
+		{ID: "20250102-120000", Time: now.Add(-time.Minute), Model: "sonnet", Title: "Add docs", Prompt: "document the cli"},
+		{ID: "20250101-120000", Time: now, Model: "o3", Title: "Continue", Prompt: "now add a test", Files: []string{"util/parsing_test.go"}},
+	} {
+		if err := AppendSession(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	records, err := ReadSessions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].ID != "20250101-120000" {
+		t.Fatalf("records = %+v, want the continued session merged and first", records)
+	}
+	merged := records[0]
+	if merged.Title != "Fix tokenizer" || len(merged.Files) != 2 || merged.Prompt != "fix the tokenizer\n\nnow add a test" {
+		t.Errorf("merged = %+v", merged)
+	}
+
+	for _, tc := range []struct {
+		terms []string
+		want  int
+	}{
+		{[]string{"TOKENIZER"}, 1},
+		{[]string{"parsing_test.go"}, 1},
+		{[]string{"tokenizer", "docs"}, 0},
+		{[]string{"cli"}, 1},
+		{nil, 2},
+	} {
+		if got := SearchSessions(records, tc.terms); len(got) != tc.want {
+			t.Errorf("SearchSessions(%q) = %d records, want %d", tc.terms, len(got), tc.want)
+		}
+	}
+}
+
+func TestRecordChangedFiles(t *testing.T) {
+	state := &LoopState{}
+	recordChangedFiles(state, []ProcessorEvent{
+		{Type: "NinaChange", Filepath: "a.go"},
+		{Type: "NinaRename", Filepath: "b.go", Args: []string{"c.go"}},
+		{Type: "NinaChange", Filepath: "d.go", Reason: "no match"},
+		{Type: "NinaBash", Cmd: "go test"},
+	})
+	if len(state.ChangedFiles) != 3 || !state.ChangedFiles["a.go"] || !state.ChangedFiles["c.go"] || state.ChangedFiles["d.go"] {
+		t.Errorf("ChangedFiles = %v", state.ChangedFiles)
+	}
+}
+
+func TestRecordTests(t *testing.T) {
+	state := &LoopState{}
+	recordTests(state, []ProcessorEvent{{Type: "NinaBash", Cmd: "go test ./...", ExitCode: 1}})
+	if state.Tests != TestsFailed {
+		t.Errorf("Tests = %q, want failed", state.Tests)
+	}
+	recordTests(state, []ProcessorEvent{
+		{Type: "NinaBash", Cmd: "cd lib && go test -run TestX", ExitCode: 0},
+		{Type: "NinaBash", Cmd: "ls", ExitCode: 1},
+	})
+	if state.Tests != TestsPassed {
+		t.Errorf("Tests = %q, want passed from the last test command", state.Tests)
+	}
+}
+
+func TestTagSession(t *testing.T) {
+	t.Setenv("NINA_SESSIONS_FILE", t.TempDir()+"/sessions.jsonl")
+	if err := AppendSession(SessionRecord{ID: "20250101-120000", Time: time.Now(), Model: "o3", Title: "Fix"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := TagSession("20250101-120000", []string{"good"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := TagSession("20990101-120000", []string{"good"}); err == nil {
+		t.Error("want an error tagging an unknown session")
+	}
+	records, err := ReadSessions()
+	if err != nil || len(records) != 1 || records[0].Model != "o3" || !slices.Equal(records[0].Tags, []string{"good"}) {
+		t.Errorf("records = %+v, %v", records, err)
+	}
+}
@@ -0,0 +1,264 @@
+// Per iteration change logs. Each iteration of nina run that changes files
+// writes agents/api/<session>/NNNNN.changes.diff, numbered like the
+// output.json of the response it ran, holding one unified diff of every
+// file changed since the previous iteration. In a git repo the changed files
+// come from git status and the commits made since, so changes by NinaBash and
+// background processes are included. Outside one, or for files outside the
+// repo, the diffs of the applied NinaChange blocks are used.
+//
+// Files changed other than by NinaChange, NinaDelete, NinaRename, or
+// NinaPlan, like by sed -i or a code generator run with NinaBash, are
+// recorded in LoopState.ExternalChanges and emitted as file_changed events
+// from the external tool. They are watched like files the model has seen, so
+// a later change to them is reported, see stale.go.
+package lib
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/nathants/nina/util"
+	"github.com/nathants/nina/workspace"
+)
+
+// maxSnapshotFile bounds the size of a file diffed, larger and binary files
+// are noted as changed without their content
+const maxSnapshotFile = 1 << 20
+
+// binaryPrefix marks snapshot content that holds the hash of a large or
+// binary file instead of the file
+const binaryPrefix = "\x00binary "
+
+// snapshot holds the changed and untracked files of a git repo at one point,
+// paths are relative to root
+type snapshot struct {
+	root  string
+	head  string
+	files map[string]string
+}
+
+// takeSnapshot returns the changed and untracked files of the git repo of
+// the working directory, nil outside a repo or in a remote workspace
+func takeSnapshot() *snapshot {
+	if !workspace.IsLocal(workspace.Current()) {
+		return nil
+	}
+	root := util.GetGitRoot()
+	if root == "" {
+		return nil
+	}
+	paths, err := gitStatusPaths(root)
+	if err != nil {
+		util.Verbosef("snapshot: %v", err)
+		return nil
+	}
+	s := &snapshot{root: root, head: gitOutput(root, "rev-parse", "HEAD"), files: map[string]string{}}
+	for _, path := range paths {
+		s.files[path] = snapshotContent(os.ReadFile(filepath.Join(root, path)))
+	}
+	return s
+}
+
+// gitStatusPaths returns the changed and untracked files of the repo at root,
+// both paths of a rename, without the files under agents
+func gitStatusPaths(root string) ([]string, error) {
+	output, err := exec.Command("git", "-C", root, "status", "--porcelain", "-z", "-uall").Output()
+	if err != nil {
+		return nil, fmt.Errorf("git status: %w", err)
+	}
+	var paths []string
+	entries := strings.Split(string(output), "\x00")
+	for i := 0; i < len(entries); i++ {
+		entry := entries[i]
+		if len(entry) < 4 {
+			continue
+		}
+		paths = append(paths, entry[3:])
+		if entry[0] == 'R' || entry[0] == 'C' {
+			// the source path of a rename follows as its own entry
+			i++
+			if i < len(entries) {
+				paths = append(paths, entries[i])
+			}
+		}
+	}
+	return slices.DeleteFunc(paths, func(path string) bool {
+		return path == "agents" || strings.HasPrefix(path, "agents/")
+	}), nil
+}
+
+// gitOutput returns the trimmed output of a git command in root, "" when it
+// fails
+func gitOutput(root string, args ...string) string {
+	output, err := exec.Command("git", append([]string{"-C", root}, args...)...).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(output))
+}
+
+// snapshotContent returns the content of a file as kept in a snapshot, ""
+// for a missing file and a hash for a large or binary one
+func snapshotContent(data []byte, err error) string {
+	if err != nil {
+		return ""
+	}
+	if len(data) > maxSnapshotFile || bytes.IndexByte(data, 0) != -1 {
+		return fmt.Sprintf("%s%x", binaryPrefix, sha256.Sum256(data))
+	}
+	return string(data)
+}
+
+// committed returns the content of path at commit, "" when it is not there
+func (s *snapshot) committed(commit, path string) string {
+	if commit == "" {
+		return ""
+	}
+	return snapshotContent(exec.Command("git", "-C", s.root, "show", commit+":"+path).Output())
+}
+
+// fileDiff is the change to one file between two snapshots
+type fileDiff struct {
+	path  string // absolute
+	diff  string
+	lines int
+}
+
+// diff returns the changed files from s to next. Files in neither snapshot
+// are as committed, at the head of each unless a commit since changed them.
+func (s *snapshot) diff(next *snapshot) []fileDiff {
+	paths := map[string]bool{}
+	for path := range s.files {
+		paths[path] = true
+	}
+	for path := range next.files {
+		paths[path] = true
+	}
+	if s.head != next.head && s.head != "" && next.head != "" {
+		for _, path := range strings.Split(gitOutput(s.root, "diff", "--name-only", s.head, next.head), "\n") {
+			if path != "" && path != "agents" && !strings.HasPrefix(path, "agents/") {
+				paths[path] = true
+			}
+		}
+	}
+	sorted := make([]string, 0, len(paths))
+	for path := range paths {
+		sorted = append(sorted, path)
+	}
+	slices.Sort(sorted)
+
+	var diffs []fileDiff
+	for _, path := range sorted {
+		before, ok := s.files[path]
+		if !ok {
+			before = s.committed(s.head, path)
+		}
+		after, ok := next.files[path]
+		if !ok {
+			after = s.committed(next.head, path)
+		}
+		if diff := contentDiff("/"+path, before, after); diff != "" {
+			diffs = append(diffs, fileDiff{path: filepath.Join(s.root, path), diff: diff, lines: diffLines(diff)})
+		}
+	}
+	return diffs
+}
+
+// diffLines counts the added and removed lines of a unified diff
+func diffLines(diff string) int {
+	lines := 0
+	for _, line := range strings.Split(diff, "\n") {
+		if (strings.HasPrefix(line, "+") || strings.HasPrefix(line, "-")) && !strings.HasPrefix(line, "+++ ") && !strings.HasPrefix(line, "--- ") {
+			lines++
+		}
+	}
+	return lines
+}
+
+// contentDiff returns the unified diff of the snapshot contents of a file
+func contentDiff(path, before, after string) string {
+	if before == after {
+		return ""
+	}
+	if strings.HasPrefix(before, binaryPrefix) || strings.HasPrefix(after, binaryPrefix) {
+		return fmt.Sprintf("Binary files a%s and b%s differ\n", path, path)
+	}
+	return util.UnifiedDiff(path, before, after, 3)
+}
+
+// logChanges writes the changes of an iteration to NNNNN.changes.diff,
+// records the files changed outside the tools of events, and keeps the
+// snapshot to diff the next iteration against
+func logChanges(state *LoopState, events []ProcessorEvent) {
+	var b strings.Builder
+	var root string
+	if state.snapshot != nil {
+		next := takeSnapshot()
+		if next != nil && next.root == state.snapshot.root {
+			diffs := state.snapshot.diff(next)
+			for _, diff := range diffs {
+				b.WriteString(diff.diff)
+			}
+			recordExternalChanges(state, diffs, events)
+			root = next.root
+		}
+		state.snapshot = next
+	}
+	for _, event := range events {
+		if event.Diff == "" || event.Reason != "" {
+			continue
+		}
+		if abs, err := filepath.Abs(event.Filepath); err == nil && root != "" && strings.HasPrefix(abs, root+string(filepath.Separator)) {
+			continue // in the git diff
+		}
+		b.WriteString(event.Diff)
+	}
+	if b.Len() == 0 {
+		return
+	}
+	path := GetTimestampedAgentsPath("api", fmt.Sprintf("%05d.changes.diff", CurrentAPILogNumber()))
+	if err := util.WriteLog(path, []byte(b.String())); err != nil {
+		LogError("Warning: failed to write the changes of step %d: %v", state.StepNumber, err)
+	}
+}
+
+// recordExternalChanges records the changed files no tool of events wrote
+func recordExternalChanges(state *LoopState, diffs []fileDiff, events []ProcessorEvent) {
+	written := map[string]bool{}
+	for _, event := range events {
+		switch event.Type {
+		case "NinaChange", "NinaDelete", "NinaRename", "NinaPlan":
+			for _, path := range append([]string{event.Filepath}, event.Args...) {
+				abs, err := filepath.Abs(path)
+				if err != nil || path == "" {
+					continue
+				}
+				// git reports the repo root with symlinks resolved
+				if resolved, err := filepath.EvalSymlinks(abs); err == nil {
+					abs = resolved
+				}
+				written[abs] = true
+			}
+		}
+	}
+	for _, diff := range diffs {
+		if written[diff.path] {
+			continue
+		}
+		if state.ExternalChanges == nil {
+			state.ExternalChanges = map[string]int{}
+		}
+		state.ExternalChanges[diff.path] += diff.lines
+		currentEvents().FileChanged("external", diff.path, diff.lines)
+		util.Verbosef("%s changed outside NinaChange, %d lines", diff.path, diff.lines)
+		if _, ok := state.SeenFiles[diff.path]; !ok {
+			seeFile(state, diff.path)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// gitRepo makes a git repo in a temp dir and changes to it, returning the
+// dir and a function running git in it
+func gitRepo(t *testing.T) (string, func(args ...string)) {
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(dir)
+	git := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@test", "-c", "commit.gpgsign=false"}, args...)...)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, output)
+		}
+	}
+	git("init", "-q")
+	return dir, git
+}
+
+func TestLogChanges(t *testing.T) {
+	dir, git := gitRepo(t)
+	for name, content := range map[string]string{"a.txt": "one\ntwo\n", "b.txt": "b\n", "c.txt": "c\n"} {
+		if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git("add", ".")
+	git("commit", "-qm", "init")
+	if err := os.WriteFile("b.txt", []byte("b dirty\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	state := &LoopState{snapshot: takeSnapshot()}
+	if state.snapshot == nil {
+		t.Fatal("expected a snapshot in a git repo")
+	}
+	// changes made outside NinaChange, including a commit, are logged
+	if err := os.WriteFile("a.txt", []byte("one\nTWO\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile("new.txt", []byte("new\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile("c.txt", []byte("C\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	git("commit", "-qm", "commit c", "--", "c.txt")
+	outside := ProcessorEvent{Type: "NinaChange", Filepath: "/elsewhere/x.txt", Diff: "--- a/elsewhere/x.txt\n+++ b/elsewhere/x.txt\n@@ -1,1 +1,1 @@\n-x\n+X\n"}
+	logChanges(state, []ProcessorEvent{outside})
+
+	matches, _ := filepath.Glob(filepath.Join(dir, "agents", "api", "*", "*.changes.diff"))
+	if len(matches) != 1 {
+		t.Fatalf("expected one changes.diff, got %v", matches)
+	}
+	data, err := os.ReadFile(matches[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff := string(data)
+	for _, want := range []string{"+++ b/a.txt\n", "-two\n+TWO\n", "+++ b/c.txt\n", "-c\n+C\n", "+++ b/new.txt\n", "+new\n", "-x\n+X\n"} {
+		if !strings.Contains(diff, want) {
+			t.Errorf("missing %q in\n%s", want, diff)
+		}
+	}
+	if strings.Contains(diff, "b.txt") || strings.Contains(diff, "agents/") {
+		t.Errorf("unchanged or agents files in\n%s", diff)
+	}
+
+	// an iteration without changes writes nothing
+	if err := os.Remove(matches[0]); err != nil {
+		t.Fatal(err)
+	}
+	logChanges(state, nil)
+	if matches, _ := filepath.Glob(filepath.Join(dir, "agents", "api", "*", "*.changes.diff")); len(matches) != 0 {
+		t.Errorf("unexpected changes.diff %v", matches)
+	}
+}
+
+func TestExternalChanges(t *testing.T) {
+	dir, git := gitRepo(t)
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if err := os.WriteFile(name, []byte("one\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git("add", ".")
+	git("commit", "-qm", "init")
+	var out bytes.Buffer
+	startEventStream(&out)
+	defer StopEventStream(nil)
+
+	state := &LoopState{snapshot: takeSnapshot()}
+	// a.txt is changed by NinaChange, b.txt by a command like sed -i
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if err := os.WriteFile(name, []byte("ONE\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	logChanges(state, []ProcessorEvent{{Type: "NinaChange", Filepath: "a.txt", Diff: "..."}})
+
+	b := filepath.Join(dir, "b.txt")
+	if len(state.ExternalChanges) != 1 || state.ExternalChanges[b] != 2 {
+		t.Errorf("external changes %v, want b.txt with 2 lines", state.ExternalChanges)
+	}
+	if _, ok := state.SeenFiles[b]; !ok {
+		t.Error("b.txt is not watched")
+	}
+	var event StreamEvent
+	if err := json.Unmarshal(out.Bytes(), &event); err != nil || event.Type != EventFileChanged || event.Tool != "external" || event.Path != b || event.LinesChanged != 2 {
+		t.Errorf("unexpected events %s", out.String())
+	}
+}
@@ -0,0 +1,255 @@
+// Background processes for nina run. NinaSpawn starts a long running command,
+// like a dev server or a file watcher, without waiting for it to exit, and
+// returns its id with the output of its first second. NinaSpawn with only that
+// id returns the output written since the last look and whether the process
+// is still running, and NinaKill stops it. Each process runs in its own
+// process group so stopping it stops its children too. The processes a loop
+// spawned are stopped when it returns, and all of them when nina is
+// interrupted.
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/nathants/nina/util"
+	"github.com/nathants/nina/workspace"
+)
+
+// spawnWait is how long a new process runs before its first output is
+// returned
+const spawnWait = time.Second
+
+// maxSpawned is how many processes may run at once
+const maxSpawned = 8
+
+// maxSpawnOutput is how much of a process's latest output is kept
+const maxSpawnOutput = 64 << 10
+
+// maxPollOutput bounds the output returned for one look at a process
+const maxPollOutput = 8000
+
+// stopGrace is how long a process has to exit after SIGTERM before SIGKILL
+const stopGrace = 3 * time.Second
+
+// spawnedProcess is a running or exited background process
+type spawnedProcess struct {
+	id      int
+	command string
+	owner   *LoopState
+	cmd     *exec.Cmd
+	done    chan struct{} // closed when the process exits
+
+	mu       sync.Mutex
+	output   []byte // the last maxSpawnOutput bytes of stdout and stderr
+	written  int    // bytes written in total
+	read     int    // bytes written when the output was last returned
+	exitCode int
+}
+
+func (p *spawnedProcess) Write(data []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.output = append(p.output, data...)
+	if len(p.output) > maxSpawnOutput {
+		p.output = p.output[len(p.output)-maxSpawnOutput:]
+	}
+	p.written += len(data)
+	return len(data), nil
+}
+
+// running reports whether the process has not exited
+func (p *spawnedProcess) running() bool {
+	select {
+	case <-p.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// SpawnStatus is a look at a background process
+type SpawnStatus struct {
+	ID       int
+	Command  string
+	Running  bool
+	ExitCode int
+	Output   string // output since the last look, the end of it when long
+}
+
+// status returns the process state and the output written since the last
+// status
+func (p *spawnedProcess) status() SpawnStatus {
+	running := p.running()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	unread := min(p.written-p.read, len(p.output))
+	output := p.output[len(p.output)-unread:]
+	dropped := p.written - p.read - len(output)
+	if len(output) > maxPollOutput {
+		dropped += len(output) - maxPollOutput
+		output = output[len(output)-maxPollOutput:]
+	}
+	p.read = p.written
+	text := string(bytes.ToValidUTF8(output, nil))
+	if dropped > 0 {
+		text = fmt.Sprintf("... %d earlier bytes dropped\n%s", dropped, text)
+	}
+	return SpawnStatus{ID: p.id, Command: p.command, Running: running, ExitCode: p.exitCode, Output: text}
+}
+
+var (
+	spawnedMu   sync.Mutex
+	spawned     = map[int]*spawnedProcess{}
+	nextSpawnID = 1
+	watchOnce   sync.Once
+	// stopMu makes a second stopSpawned wait for the first, so nothing exits
+	// while processes are still being stopped
+	stopMu sync.Mutex
+)
+
+// spawnProcess starts command in the background for owner
+func spawnProcess(owner *LoopState, command string) (SpawnStatus, error) {
+	cwd := workspace.Root(workspace.Current())
+	if cwd == "" {
+		cwd, _ = os.Getwd()
+	}
+	if err := util.CheckCommandProtected(command, cwd); err != nil {
+		return SpawnStatus{}, fmt.Errorf("not run, it writes a protected file: %w", err)
+	}
+	spawnedMu.Lock()
+	count := 0
+	for _, p := range spawned {
+		if p.running() {
+			count++
+		}
+	}
+	if count >= maxSpawned {
+		spawnedMu.Unlock()
+		return SpawnStatus{}, fmt.Errorf("%d background processes are running, stop one with NinaKill first", count)
+	}
+	p := &spawnedProcess{id: nextSpawnID, command: command, owner: owner, done: make(chan struct{})}
+	nextSpawnID++
+	spawned[p.id] = p
+	spawnedMu.Unlock()
+
+	p.cmd = workspace.BashCommand(util.NonInteractive(command))
+	p.cmd.Stdout = p
+	p.cmd.Stderr = p
+	setProcessGroup(p.cmd)
+	if err := p.cmd.Start(); err != nil {
+		spawnedMu.Lock()
+		delete(spawned, p.id)
+		spawnedMu.Unlock()
+		return SpawnStatus{}, err
+	}
+	watchOnce.Do(watchInterrupt)
+	go func() {
+		_ = p.cmd.Wait()
+		p.mu.Lock()
+		p.exitCode = p.cmd.ProcessState.ExitCode()
+		p.mu.Unlock()
+		close(p.done)
+	}()
+	select {
+	case <-p.done:
+	case <-time.After(spawnWait):
+	}
+	return p.status(), nil
+}
+
+// findSpawned returns the process with id
+func findSpawned(id int) (*spawnedProcess, error) {
+	spawnedMu.Lock()
+	defer spawnedMu.Unlock()
+	p, ok := spawned[id]
+	if !ok {
+		return nil, fmt.Errorf("no background process %d", id)
+	}
+	return p, nil
+}
+
+// pollProcess returns the output process id wrote since the last look
+func pollProcess(id int) (SpawnStatus, error) {
+	p, err := findSpawned(id)
+	if err != nil {
+		return SpawnStatus{}, err
+	}
+	return p.status(), nil
+}
+
+// killProcess stops process id and returns its last output
+func killProcess(id int) (SpawnStatus, error) {
+	p, err := findSpawned(id)
+	if err != nil {
+		return SpawnStatus{}, err
+	}
+	p.stop()
+	spawnedMu.Lock()
+	delete(spawned, id)
+	spawnedMu.Unlock()
+	return p.status(), nil
+}
+
+// stop terminates the process group, killing it when it does not exit
+// within stopGrace
+func (p *spawnedProcess) stop() {
+	if !p.running() {
+		return
+	}
+	signalProcessGroup(p.cmd, syscall.SIGTERM)
+	select {
+	case <-p.done:
+	case <-time.After(stopGrace):
+		signalProcessGroup(p.cmd, syscall.SIGKILL)
+		<-p.done
+	}
+}
+
+// stopSpawned stops the processes owner spawned, or every process for a nil
+// owner, in parallel
+func stopSpawned(owner *LoopState) {
+	stopMu.Lock()
+	defer stopMu.Unlock()
+	spawnedMu.Lock()
+	var procs []*spawnedProcess
+	for id, p := range spawned {
+		if owner == nil || p.owner == owner {
+			procs = append(procs, p)
+			delete(spawned, id)
+		}
+	}
+	spawnedMu.Unlock()
+	var wg sync.WaitGroup
+	for _, p := range procs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.stop()
+		}()
+	}
+	wg.Wait()
+}
+
+// watchInterrupt stops every process when nina is interrupted, then exits
+// like the default handler. The tui has its own handler that stops them after
+// restoring the terminal.
+func watchInterrupt() {
+	if currentTUI() != nil {
+		return
+	}
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-interrupt
+		restoreTerminal()
+		stopSpawned(nil)
+		os.Exit(130)
+	}()
+}
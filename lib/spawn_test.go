@@ -0,0 +1,66 @@
+package lib
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nathants/nina/util"
+)
+
+func TestProcessOutputSpawn(t *testing.T) {
+	t.Chdir(t.TempDir())
+	spawn := util.NinaSpawnStart + util.NinaCmdStart + "echo started; sleep 60" + util.NinaCmdEnd + util.NinaSpawnEnd
+	result := ProcessOutput(util.NinaOutputStart+"\n"+spawn+"\n"+util.NinaOutputEnd, nil, false)
+	if len(result.Results) != 1 || !strings.Contains(result.Results[0], "<NinaRunning>true</NinaRunning>\n<NinaStdout>started\n</NinaStdout>") {
+		t.Fatalf("unexpected results %v", result.Results)
+	}
+	id, _ := util.ExtractSingle(result.Results[0], util.NinaSpawnStart, util.NinaSpawnEnd)
+
+	// nothing new since the last look
+	poll := util.NinaSpawnStart + util.NinaIdStart + id + util.NinaIdEnd + util.NinaSpawnEnd
+	result = ProcessOutput(util.NinaOutputStart+"\n"+poll+"\n"+util.NinaOutputEnd, nil, false)
+	if len(result.Results) != 1 || !strings.Contains(result.Results[0], "<NinaRunning>true</NinaRunning>\n<NinaStdout></NinaStdout>") {
+		t.Fatalf("unexpected results %v", result.Results)
+	}
+
+	kill := util.NinaKillStart + util.NinaIdStart + id + util.NinaIdEnd + util.NinaKillEnd
+	result = ProcessOutput(util.NinaOutputStart+"\n"+kill+"\n"+util.NinaOutputEnd, nil, false)
+	if len(result.Results) != 1 || !strings.Contains(result.Results[0], "<NinaRunning>false</NinaRunning>") {
+		t.Fatalf("unexpected results %v", result.Results)
+	}
+	result = ProcessOutput(util.NinaOutputStart+"\n"+poll+"\n"+util.NinaOutputEnd, nil, false)
+	if len(result.Results) != 1 || !strings.Contains(result.Results[0], "<NinaError>no background process "+id) {
+		t.Fatalf("unexpected results %v", result.Results)
+	}
+}
+
+func TestSpawnExitAndStop(t *testing.T) {
+	t.Chdir(t.TempDir())
+	status, err := spawnProcess(nil, "echo done; exit 3")
+	if err != nil || status.Running || status.ExitCode != 3 || status.Output != "done\n" {
+		t.Fatalf("unexpected status %+v %v", status, err)
+	}
+
+	// a loop stops only the processes it spawned, with their children
+	owner, other := &LoopState{}, &LoopState{}
+	mine, err := spawnProcess(owner, "sleep 60 & sleep 60; wait")
+	if err != nil {
+		t.Fatal(err)
+	}
+	theirs, err := spawnProcess(other, "sleep 60")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, _ := findSpawned(mine.ID)
+	stopSpawned(owner)
+	if p.running() {
+		t.Error("process still running after stopSpawned")
+	}
+	if _, err := findSpawned(mine.ID); err == nil {
+		t.Error("stopped process still listed")
+	}
+	if _, err := pollProcess(theirs.ID); err != nil {
+		t.Errorf("other loop's process was stopped: %v", err)
+	}
+	stopSpawned(other)
+}
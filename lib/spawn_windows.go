@@ -0,0 +1,16 @@
+//go:build windows
+
+package lib
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup does nothing, windows has no process groups to signal
+func setProcessGroup(*exec.Cmd) {}
+
+// signalProcessGroup kills the process of cmd, its children keep running
+func signalProcessGroup(cmd *exec.Cmd, _ syscall.Signal) {
+	_ = cmd.Process.Kill()
+}
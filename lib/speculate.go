@@ -0,0 +1,125 @@
+// Speculative execution for nina run. With --speculate a cheap draft model
+// answers every message in parallel with the primary model. The primary
+// response is used when it arrives and the draft call is canceled, the draft
+// is used when the primary call fails or exceeds the speculation timeout.
+// Completed calls of both models are counted in the session usage and cost,
+// and the response used is recorded in both conversation histories so either
+// model can answer the next message.
+package lib
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/nathants/nina/util"
+)
+
+// defaultSpeculateTimeout is how long the primary model may take, retries
+// included, before the draft is used
+const defaultSpeculateTimeout = 5 * time.Minute
+
+// turnRecorder is implemented by providers that keep the conversation history
+// locally, so a response from another model can continue their conversation
+type turnRecorder interface {
+	// RecordTurn makes userMessage answered by response the newest exchange,
+	// replacing the reply when userMessage was already answered
+	RecordTurn(userMessage, response string)
+}
+
+func recordTurn(provider AIProvider, userMessage, response string) {
+	if r, ok := provider.(turnRecorder); ok {
+		r.RecordTurn(userMessage, response)
+	}
+}
+
+// turnStart returns where the exchange for userMessage starts in a history of
+// n messages: the index of userMessage when it is the newest user message,
+// with or without a reply after it, otherwise n
+func turnStart(n int, message func(i int) (role, text string), userMessage string) int {
+	for i := n - 1; i >= 0 && i >= n-2; i-- {
+		role, text := message(i)
+		if role == "user" {
+			if text == userMessage {
+				return i
+			}
+			return n
+		}
+	}
+	return n
+}
+
+// speculator runs the draft model of a speculative loop
+type speculator struct {
+	provider AIProvider
+	model    string
+	timeout  time.Duration
+	state    *LoopState // the draft's own context window and usage
+}
+
+// newSpeculator returns the speculator for config, nil without --speculate
+func newSpeculator(config LoopConfig) (*speculator, error) {
+	if config.Speculate == "" {
+		return nil, nil
+	}
+	provider := config.DraftProvider
+	model := config.Speculate
+	if provider == nil {
+		var err error
+		provider, model, err = CreateProviderForModel(config.Speculate)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create draft provider: %w", err)
+		}
+	}
+	timeout := config.DraftAfter
+	if timeout <= 0 {
+		timeout = defaultSpeculateTimeout
+	}
+	return &speculator{provider: provider, model: model, timeout: timeout, state: &LoopState{}}, nil
+}
+
+type draftResult struct {
+	response string
+	err      error
+}
+
+// call sends userMessage to the primary and draft models concurrently and
+// returns the primary response, or the draft when the primary call fails
+func (s *speculator) call(provider AIProvider, model, systemPrompt, userMessage string, state *LoopState, thinking bool) (string, error) {
+	draftCtx, cancelDraft := context.WithCancel(context.Background())
+	defer cancelDraft()
+	outputBefore, inputBefore := s.state.TokensUsed, s.state.SessionUsage.SessionInput
+	done := make(chan draftResult, 1)
+	go func() {
+		response, err := callAIProvider(draftCtx, s.provider, s.model, systemPrompt, userMessage, s.state, thinking)
+		done <- draftResult{response, err}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	response, err := callWithRetry(ctx, provider, model, systemPrompt, userMessage, state, thinking)
+	cancel()
+	if err == nil {
+		cancelDraft()
+	}
+	draft := <-done
+
+	// the loop's token totals include the draft, see RecordUsage for cost
+	state.TokensUsed += s.state.TokensUsed - outputBefore
+	state.SessionUsage.SessionInput += s.state.SessionUsage.SessionInput - inputBefore
+
+	switch {
+	case err == nil:
+		recordTurn(s.provider, userMessage, response)
+		return response, nil
+	case draft.err == nil:
+		state.DraftsUsed++
+		LogError("Warning: %s failed, using the draft from %s: %v", model, s.model, err)
+		recordTurn(provider, userMessage, draft.response)
+		if state.ContextTokens > 0 {
+			state.ContextTokens += util.CalculateMessageTokens("assistant", draft.response)
+		}
+		return draft.response, nil
+	default:
+		return "", fmt.Errorf("%w, and the draft from %s failed: %v", err, s.model, draft.err)
+	}
+}
@@ -0,0 +1,50 @@
+// Tests for recording responses from another model in provider histories
+package lib
+
+import (
+	"testing"
+
+	"github.com/nathants/nina/providers/grok"
+)
+
+func TestRecordTurn(t *testing.T) {
+	c := &GrokClient{messages: []grok.Message{{Role: "system", Content: "sys"}}}
+
+	// the primary failed, so the exchange is added
+	c.RecordTurn("one", "draft one")
+	// the draft answered too, so its reply is replaced
+	c.messages = append(c.messages, grok.Message{Role: "user", Content: "two"}, grok.Message{Role: "assistant", Content: "draft two"})
+	c.RecordTurn("two", "primary two")
+	// the call was canceled after adding the message
+	c.messages = append(c.messages, grok.Message{Role: "user", Content: "three"})
+	c.RecordTurn("three", "primary three")
+
+	want := []grok.Message{
+		{Role: "system", Content: "sys"},
+		{Role: "user", Content: "one"},
+		{Role: "assistant", Content: "draft one"},
+		{Role: "user", Content: "two"},
+		{Role: "assistant", Content: "primary two"},
+		{Role: "user", Content: "three"},
+		{Role: "assistant", Content: "primary three"},
+	}
+	if len(c.messages) != len(want) {
+		t.Fatalf("got %d messages, want %d: %v", len(c.messages), len(want), c.messages)
+	}
+	for i := range want {
+		if c.messages[i] != want[i] {
+			t.Errorf("message %d = %+v, want %+v", i, c.messages[i], want[i])
+		}
+	}
+}
+
+func TestRecordTurnOpenAIResendsHistory(t *testing.T) {
+	c := &OpenAIClient{responseID: "resp_1"}
+	c.RecordTurn("hello", "hi")
+	if c.responseID != "" {
+		t.Errorf("responseID = %q, want it cleared", c.responseID)
+	}
+	if len(c.messages) != 2 || c.messages[1].Content[0].Type != "output_text" {
+		t.Errorf("unexpected history: %+v", c.messages)
+	}
+}
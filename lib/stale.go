@@ -0,0 +1,169 @@
+// Stale file refresh for nina run. The loop remembers the content of each
+// file the model has seen: files sent in the prompt's NinaFile blocks, files
+// named in its NinaBash commands or read with NinaRead, and files it changed
+// with NinaChange or NinaPlan. Before each message those files are read again, and any that
+// changed since, whether by the model's own commands or outside the loop, are
+// reported through SUGGEST.md with a diff of what changed, so the model does
+// not edit against content it no longer has.
+package lib
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/nathants/nina/util"
+	"github.com/nathants/nina/workspace"
+)
+
+// maxSeenFiles bounds how many files are watched, later files are not added
+const maxSeenFiles = 200
+
+// maxSeenFileSize is the largest file whose content is kept for a diff,
+// larger files are reported without one
+const maxSeenFileSize = 256 << 10
+
+// maxStaleDiffTokens bounds the diff sent for each changed file
+const maxStaleDiffTokens = 600
+
+// seenFile is a file as the model last saw it
+type seenFile struct {
+	Hash    string
+	Content string // "" when larger than maxSeenFileSize
+}
+
+// seeFile records the current content of path, it is forgotten when it
+// cannot be read
+func seeFile(state *LoopState, path string) {
+	path = seenPath(path)
+	if _, ok := state.SeenFiles[path]; !ok && len(state.SeenFiles) >= maxSeenFiles {
+		return
+	}
+	data, err := workspace.Current().ReadFile(path)
+	if err != nil {
+		delete(state.SeenFiles, path)
+		return
+	}
+	if state.SeenFiles == nil {
+		state.SeenFiles = map[string]seenFile{}
+	}
+	file := seenFile{Hash: util.Sha256Hex(data)}
+	if len(data) <= maxSeenFileSize {
+		file.Content = string(data)
+	}
+	state.SeenFiles[path] = file
+}
+
+// seenPath returns path absolute within the workspace
+func seenPath(path string) string {
+	path = strings.TrimSpace(path)
+	if filepath.IsAbs(path) {
+		return filepath.Clean(path)
+	}
+	root := workspace.Root(workspace.Current())
+	if root == "" {
+		root, _ = os.Getwd()
+	}
+	return filepath.Join(root, path)
+}
+
+// seePrompt records the files sent in the prompt's NinaFile blocks
+func seePrompt(state *LoopState, prompt string) {
+	files, err := util.ExtractAll(prompt, util.NinaFileStart, util.NinaFileEnd)
+	if err != nil {
+		return
+	}
+	for _, file := range files {
+		if path, err := util.ExtractSingle(file, util.NinaPathStart, util.NinaPathEnd); err == nil {
+			seeFile(state, path)
+		}
+	}
+}
+
+// seeEvents records the files a response read or wrote. Files its commands
+// name are only added when new, so a command that changes a file already
+// seen is reported before the next message.
+func seeEvents(state *LoopState, events []ProcessorEvent) {
+	for _, event := range events {
+		switch event.Type {
+		case "NinaChange":
+			if event.Reason == "" && !event.Malformed {
+				seeFile(state, event.Filepath)
+			}
+		case "NinaRead":
+			if event.Reason == "" {
+				seeFile(state, event.Filepath)
+			}
+		case "NinaPlan":
+			if event.Reason == "" {
+				seeFile(state, PlanPath())
+			}
+		case "NinaDelete", "NinaRename":
+			if event.Reason == "" {
+				delete(state.SeenFiles, seenPath(event.Filepath))
+			}
+		case "NinaBash":
+			for _, path := range commandPaths(event.Cmd, event.Cwd) {
+				if _, ok := state.SeenFiles[seenPath(path)]; !ok {
+					seeFile(state, path)
+				}
+			}
+		}
+	}
+}
+
+// commandPaths returns the words of a command run in dir that name existing
+// files
+func commandPaths(cmd, dir string) []string {
+	var paths []string
+	for _, word := range strings.FieldsFunc(cmd, func(r rune) bool {
+		return strings.ContainsRune(" \t\n;|&<>()", r)
+	}) {
+		word = strings.Trim(word, `"'`+"`")
+		if word == "" || strings.HasPrefix(word, "-") || strings.ContainsAny(word, "*?$={}") || !strings.ContainsAny(word, "./") {
+			continue
+		}
+		if !filepath.IsAbs(word) && dir != "" {
+			word = filepath.Join(dir, word)
+		}
+		info, err := workspace.Current().Stat(seenPath(word))
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		paths = append(paths, word)
+	}
+	return paths
+}
+
+// staleFiles reads the seen files again and returns a notice for those that
+// changed since the model last saw them, "" when none did
+func staleFiles(state *LoopState) string {
+	paths := make([]string, 0, len(state.SeenFiles))
+	for path := range state.SeenFiles {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	var notices []string
+	for _, path := range paths {
+		old := state.SeenFiles[path]
+		data, err := workspace.Current().ReadFile(path)
+		if err != nil {
+			delete(state.SeenFiles, path)
+			notices = append(notices, fmt.Sprintf("%s was deleted since you last read it.", path))
+			continue
+		}
+		if util.Sha256Hex(data) == old.Hash {
+			continue
+		}
+		seeFile(state, path)
+		notice := fmt.Sprintf("%s changed since you last read it, read it again before changing it.", path)
+		if old.Content != "" && len(data) <= maxSeenFileSize {
+			diff := util.UnifiedDiff(path, old.Content, string(data), 2)
+			notice = fmt.Sprintf("%s changed since you last read it:\n%s", path, util.TruncateDiff(diff, maxStaleDiffTokens))
+		}
+		notices = append(notices, notice)
+	}
+	return strings.Join(notices, "\n")
+}
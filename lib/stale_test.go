@@ -0,0 +1,66 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nathants/nina/util"
+)
+
+func TestStaleFiles(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	for name, content := range map[string]string{"a.go": "package a\n\nvar x = 1\n", "b.go": "package b\n", "c.go": "package c\n"} {
+		if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	state := &LoopState{}
+	seePrompt(state, util.FormatNinaFile("a.go", "package a\n\nvar x = 1\n"))
+	seeEvents(state, []ProcessorEvent{{Type: "NinaBash", Cmd: "cat b.go | head -n 5 && ls missing.go"}})
+	if len(state.SeenFiles) != 2 {
+		t.Fatalf("expected a.go and b.go to be seen, got %v", state.SeenFiles)
+	}
+	if notice := staleFiles(state); notice != "" {
+		t.Fatalf("unexpected notice %q", notice)
+	}
+
+	// changes outside the loop are reported once, with a diff
+	if err := os.WriteFile("a.go", []byte("package a\n\nvar x = 2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove("b.go"); err != nil {
+		t.Fatal(err)
+	}
+	notice := staleFiles(state)
+	a := filepath.Join(dir, "a.go")
+	for _, want := range []string{a + " changed since you last read it:\n--- a" + a, "-var x = 1\n+var x = 2\n", filepath.Join(dir, "b.go") + " was deleted"} {
+		if !strings.Contains(notice, want) {
+			t.Errorf("notice does not contain %q:\n%s", want, notice)
+		}
+	}
+	if notice := staleFiles(state); notice != "" {
+		t.Errorf("change reported twice: %q", notice)
+	}
+
+	// the model's own changes are not reported
+	if err := os.WriteFile("a.go", []byte("package a\n\nvar x = 3\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	seeEvents(state, []ProcessorEvent{{Type: "NinaChange", Filepath: a}})
+	if notice := staleFiles(state); notice != "" {
+		t.Errorf("unexpected notice %q", notice)
+	}
+
+	// a command changing a file already seen is reported
+	seeEvents(state, []ProcessorEvent{{Type: "NinaBash", Cmd: "cat c.go"}})
+	if err := os.WriteFile("c.go", []byte("package d\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	seeEvents(state, []ProcessorEvent{{Type: "NinaBash", Cmd: "sed -i s/c/d/ c.go"}})
+	if notice := staleFiles(state); !strings.Contains(notice, "-package c\n+package d\n") {
+		t.Errorf("unexpected notice %q", notice)
+	}
+}
@@ -0,0 +1,75 @@
+// Steering for nina run. While the loop runs, a line typed into the terminal,
+// see keys.go, or written to stdin by an editor integration with --steer, is
+// queued and sent to the model as a NinaSuggestion with the next message.
+// Lines of just p, s, or q, or those keys pressed in a terminal, pause,
+// report on, or stop the loop, see control.go. SUGGEST.md in the git root
+// still works for scripts and other processes, its content is sent first.
+// Steer queues a message from code running in the same process.
+package lib
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/nathants/nina/util"
+)
+
+var (
+	steerMu    sync.Mutex
+	steerQueue []string
+)
+
+// Steer queues a message for the next message to the model
+func Steer(text string) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return
+	}
+	steerMu.Lock()
+	steerQueue = append(steerQueue, text)
+	steerMu.Unlock()
+	LogStderr("Queued for the next step: %s", text)
+}
+
+// StartSteering queues each line read from r with Steer until r ends, lines
+// naming a control run it instead, see control.go. A terminal is read key by
+// key, see keys.go.
+func StartSteering(r io.Reader) {
+	if f, ok := r.(*os.File); ok && util.IsTerminal(f) && startKeySteering(f) {
+		return
+	}
+	go func() {
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			if !handleControl(scanner.Text()) {
+				Steer(scanner.Text())
+			}
+		}
+	}()
+}
+
+// TakeSuggestions returns the content of SUGGEST.md followed by the queued
+// steering messages, "" when there are none, and clears both
+func TakeSuggestions() string {
+	var parts []string
+	path := filepath.Join(util.GetGitRoot(), "SUGGEST.md")
+	if data, err := os.ReadFile(path); err == nil && len(data) > 0 {
+		if text := strings.TrimSpace(string(data)); text != "" {
+			parts = append(parts, text)
+		}
+		// Truncate SUGGEST.md after reading
+		if err := os.Truncate(path, 0); err != nil {
+			LogError("Failed to truncate SUGGEST.md: %v", err)
+		}
+	}
+	steerMu.Lock()
+	parts = append(parts, steerQueue...)
+	steerQueue = nil
+	steerMu.Unlock()
+	return strings.Join(parts, "\n")
+}
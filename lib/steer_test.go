@@ -0,0 +1,39 @@
+package lib
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTakeSuggestions(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := os.WriteFile("SUGGEST.md", []byte("from the file\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	StartSteering(strings.NewReader("run the tests first\n\n  and keep it short  \n"))
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		steerMu.Lock()
+		queued := len(steerQueue)
+		steerMu.Unlock()
+		if queued == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected 2 queued messages, got %d", queued)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if got, want := TakeSuggestions(), "from the file\nrun the tests first\nand keep it short"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if data, err := os.ReadFile("SUGGEST.md"); err != nil || len(data) != 0 {
+		t.Errorf("SUGGEST.md not truncated: %q %v", data, err)
+	}
+	if got := TakeSuggestions(); got != "" {
+		t.Errorf("expected nothing left, got %q", got)
+	}
+}
@@ -0,0 +1,62 @@
+// Stop reasons of provider responses. Each provider names why a response
+// ended its own way, claude max_tokens and refusal, openai an incomplete
+// status with max_output_tokens or content_filter, grok and groq length and
+// content_filter, gemini MAX_TOKENS and SAFETY. The loop normalizes them so a
+// response cut off at the output token limit is continued, see cutoff.go,
+// while one stopped by a content filter is reported as such and not
+// continued.
+package lib
+
+import (
+	"github.com/nathants/nina/providers/openai"
+)
+
+// Normalized stop reasons, other reasons are kept as the provider sent them
+const (
+	StopMaxTokens     = "max_tokens"     // cut off at the output token limit
+	StopContentFilter = "content_filter" // stopped by a safety or content filter
+)
+
+var stopReasons = map[string]string{
+	"max_tokens":         StopMaxTokens,
+	"max_output_tokens":  StopMaxTokens,
+	"length":             StopMaxTokens,
+	"MAX_TOKENS":         StopMaxTokens,
+	"refusal":            StopContentFilter,
+	"content_filter":     StopContentFilter,
+	"SAFETY":             StopContentFilter,
+	"RECITATION":         StopContentFilter,
+	"BLOCKLIST":          StopContentFilter,
+	"PROHIBITED_CONTENT": StopContentFilter,
+	"SPII":               StopContentFilter,
+	"IMAGE_SAFETY":       StopContentFilter,
+}
+
+// normalizeStopReason maps a provider stop reason to StopMaxTokens or
+// StopContentFilter, other reasons are returned as is
+func normalizeStopReason(reason string) string {
+	if normalized, ok := stopReasons[reason]; ok {
+		return normalized
+	}
+	return reason
+}
+
+// openaiStopReason returns the normalized stop reason of an openai response,
+// the incomplete reason of an incomplete one. An incomplete response without
+// a reason was cut off at the output token limit.
+func openaiStopReason(r *openai.Response) string {
+	if r.Status != "incomplete" {
+		return r.Status
+	}
+	var reason string
+	switch details := r.IncompleteDetails.(type) {
+	case openai.IncompleteDetails:
+		reason = details.Reason
+	case map[string]any: // decoded from a recording
+		reason, _ = details["reason"].(string)
+	}
+	if reason == "" {
+		return StopMaxTokens
+	}
+	return normalizeStopReason(reason)
+}
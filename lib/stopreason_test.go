@@ -0,0 +1,47 @@
+package lib
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nathants/nina/providers/openai"
+)
+
+func TestStopReason(t *testing.T) {
+	tests := []struct {
+		resp *openai.Response
+		want string
+	}{
+		{&openai.Response{Status: "completed"}, "completed"},
+		{&openai.Response{Status: "incomplete"}, StopMaxTokens},
+		{&openai.Response{Status: "incomplete", IncompleteDetails: openai.IncompleteDetails{Reason: "max_output_tokens"}}, StopMaxTokens},
+		{&openai.Response{Status: "incomplete", IncompleteDetails: map[string]any{"reason": "content_filter"}}, StopContentFilter},
+	}
+	for _, tt := range tests {
+		if got := openaiStopReason(tt.resp); got != tt.want {
+			t.Errorf("openaiStopReason(%+v) = %q, want %q", tt.resp, got, tt.want)
+		}
+	}
+	for reason, want := range map[string]string{"end_turn": "end_turn", "refusal": StopContentFilter, "length": StopMaxTokens, "SAFETY": StopContentFilter, "MAX_TOKENS": StopMaxTokens} {
+		if got := normalizeStopReason(reason); got != want {
+			t.Errorf("normalizeStopReason(%q) = %q, want %q", reason, got, want)
+		}
+	}
+
+	t.Chdir(t.TempDir())
+	mock := NewMockClient("a long response", "short")
+	mock.MaxOutput = 6
+	state := &LoopState{}
+	if _, err := callAIProvider(context.Background(), mock, "mock", "", "hi", state, false); err != nil {
+		t.Fatal(err)
+	}
+	if state.StopReason != StopMaxTokens || !state.Truncated {
+		t.Errorf("stop reason %q truncated %v, want max_tokens", state.StopReason, state.Truncated)
+	}
+	if _, err := callAIProvider(context.Background(), mock, "mock", "", "hi", state, false); err != nil {
+		t.Fatal(err)
+	}
+	if state.StopReason != "" || state.Truncated {
+		t.Errorf("stop reason %q truncated %v, want none", state.StopReason, state.Truncated)
+	}
+}
@@ -0,0 +1,126 @@
+// Output summaries for nina run. With --summarize a cheap model, like flash,
+// reads the output of each NinaBash longer than summarizeTokens, or of any
+// command matching a --summarize-cmd pattern such as "^go test", and its
+// summary of the errors, failing tests, and key lines replaces the output in
+// the next message. The full output is kept under agents/artifacts, where the
+// model can read it with NinaRead. When summarizing fails the output is
+// returned as is.
+package lib
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/nathants/nina/util"
+	"github.com/nathants/nina/workspace"
+)
+
+// summarizeTokens is the stdout and stderr length above which output is
+// summarized
+const summarizeTokens = 1000
+
+// maxSummarizeInput bounds the bytes of each stream sent to the summarizer,
+// the end of longer output is kept
+const maxSummarizeInput = 256 << 10
+
+const summarizeSystemPrompt = `You summarize the output of a shell command for a coding agent that will not see the output. Report the errors, failing tests with their messages and locations, and the few lines needed to act on them, quoting them exactly with their file paths and line numbers. Say in one line what succeeded. Do not suggest fixes. Reply with the summary only, in under 300 words.`
+
+// summarizer runs the cheap model of --summarize
+type summarizer struct {
+	model    string
+	provider AIProvider // used for every summary when set, e.g. a MockClient in tests
+	patterns []*regexp.Regexp
+}
+
+// newSummarizer returns the summarizer for config, nil without --summarize
+func newSummarizer(config LoopConfig) (*summarizer, error) {
+	if config.Summarize == "" {
+		return nil, nil
+	}
+	s := &summarizer{model: config.Summarize, provider: config.Summarizer}
+	for _, pattern := range config.SummarizeCmds {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --summarize-cmd %q: %w", pattern, err)
+		}
+		s.patterns = append(s.patterns, re)
+	}
+	return s, nil
+}
+
+// applies reports whether the output of a NinaBash event is summarized
+func (s *summarizer) applies(event ProcessorEvent) bool {
+	if event.Stdout == "" && event.Stderr == "" {
+		return false
+	}
+	for _, re := range s.patterns {
+		if re.MatchString(event.Cmd) {
+			return true
+		}
+	}
+	return event.StdoutFile != "" || event.StderrFile != "" || util.CalculateTokens(event.Stdout+event.Stderr) > summarizeTokens
+}
+
+// summarize replaces the output of a NinaBash event with its summary, the
+// usage of the call is added to state
+func (s *summarizer) summarize(state *LoopState, event *ProcessorEvent) {
+	if !s.applies(*event) {
+		return
+	}
+	stdout, stdoutFile := fullOutput("stdout", event.Stdout, event.StdoutFile)
+	stderr, stderrFile := fullOutput("stderr", event.Stderr, event.StderrFile)
+	message := fmt.Sprintf("Command: %s\nExit code: %d\n\nStdout:\n%s\n\nStderr:\n%s\n", event.Cmd, event.ExitCode, stdout, stderr)
+
+	provider, model := s.provider, s.model
+	if provider == nil {
+		var err error
+		provider, model, err = CreateProviderForModel(s.model)
+		if err != nil {
+			LogError("Warning: failed to create summarize provider: %v", err)
+			return
+		}
+	}
+	callState := &LoopState{}
+	summary, err := callAIProvider(context.Background(), provider, model, summarizeSystemPrompt, message, callState, false)
+	state.TokensUsed += callState.TokensUsed
+	state.SessionUsage.SessionInput += callState.SessionUsage.SessionInput
+	if err != nil || strings.TrimSpace(summary) == "" {
+		LogError("Warning: failed to summarize the output of %s: %v", event.Cmd, err)
+		return
+	}
+
+	var files []string
+	for _, file := range []string{stdoutFile, stderrFile} {
+		if file != "" {
+			files = append(files, file)
+		}
+	}
+	event.Stdout = fmt.Sprintf("The output was summarized by %s:\n%s\n", s.model, strings.TrimSpace(summary))
+	if len(files) > 0 {
+		event.Stdout += fmt.Sprintf("The full output is in %s, read it with NinaRead.\n", strings.Join(files, " and "))
+	}
+	event.Stderr = ""
+	event.StdoutFile, event.StderrFile = stdoutFile, stderrFile
+}
+
+// fullOutput returns the complete stream output of a command, from its
+// artifact when it was spilled, and the artifact holding it, saving one when
+// there is none
+func fullOutput(stream, output, file string) (string, string) {
+	if file != "" {
+		if data, err := workspace.Base(workspace.Current()).ReadFile(file); err == nil {
+			output = string(data)
+		}
+	} else if output != "" {
+		var err error
+		if file, err = util.SaveArtifact(stream, output); err != nil {
+			LogError("Warning: failed to save the %s artifact: %v", stream, err)
+		}
+	}
+	if len(output) > maxSummarizeInput {
+		output = fmt.Sprintf("... %d earlier bytes dropped\n%s", len(output)-maxSummarizeInput, output[len(output)-maxSummarizeInput:])
+	}
+	return output, file
+}
@@ -0,0 +1,65 @@
+package lib
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/nathants/nina/util"
+)
+
+func TestProcessOutputSummarize(t *testing.T) {
+	t.Chdir(t.TempDir())
+	mock := NewMockClient("FAIL TestParse at parse_test.go:12", "ok, 3 packages passed")
+	s, err := newSummarizer(LoopConfig{Summarize: "flash", SummarizeCmds: []string{"^go test"}, Summarizer: mock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := &LoopState{summarizer: s}
+	bash := func(cmd string) ProcessorEvent {
+		output := util.NinaOutputStart + "\n" + util.NinaBashStart + cmd + util.NinaBashEnd + "\n" + util.NinaOutputEnd
+		result := ProcessOutput(output, state, false)
+		if len(result.Events) != 1 {
+			t.Fatalf("unexpected events %+v", result.Events)
+		}
+		return result.Events[0]
+	}
+
+	// short output is returned as is
+	if event := bash("echo short"); event.Stdout != "short\n" || len(mock.Messages) != 0 {
+		t.Fatalf("unexpected event %+v", event)
+	}
+
+	// long output is summarized, the full output is kept
+	event := bash("seq 1 2000; echo 'FAIL TestParse' >&2; exit 1")
+	if len(mock.Messages) != 1 || !strings.Contains(mock.Messages[0], "Exit code: 1") || !strings.Contains(mock.Messages[0], "\n2000\n") {
+		t.Fatalf("unexpected summarize request %q", mock.Messages)
+	}
+	if !strings.HasPrefix(event.Stdout, "The output was summarized by flash:\nFAIL TestParse at parse_test.go:12\nThe full output is in ") || event.Stderr != "" || event.ExitCode != 1 {
+		t.Errorf("unexpected event %+v", event)
+	}
+	data, err := os.ReadFile(event.StdoutFile)
+	if err != nil || strings.Count(string(data), "\n") != 2000 {
+		t.Errorf("unexpected stdout artifact %v", err)
+	}
+	if data, err := os.ReadFile(event.StderrFile); err != nil || string(data) != "FAIL TestParse\n" {
+		t.Errorf("unexpected stderr artifact %q %v", data, err)
+	}
+	if state.TokensUsed == 0 {
+		t.Error("summary usage not counted")
+	}
+
+	// matching commands are always summarized
+	if event := bash("go test ./... 2>/dev/null || echo ok"); !strings.Contains(event.Stdout, "ok, 3 packages passed") {
+		t.Errorf("unexpected event %+v", event)
+	}
+
+	// a failed summary leaves the output as is
+	if event := bash("seq 1 2000"); !strings.HasPrefix(event.Stdout, "1\n2\n") {
+		t.Errorf("unexpected event %+v", event)
+	}
+
+	if _, err := newSummarizer(LoopConfig{Summarize: "flash", SummarizeCmds: []string{"("}}); err == nil {
+		t.Error("expected an invalid pattern to fail")
+	}
+}
@@ -0,0 +1,124 @@
+// Token counting per provider. Budgets for context packing and compaction
+// are checked against the count the provider bills: claude and gemini
+// through their count tokens apis, other providers with the local o200k
+// tokenizer. NINA_TOKENIZER=local counts everything locally, and any api
+// error falls back to the local count.
+package lib
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/nathants/nina/models"
+	"github.com/nathants/nina/providers/claude"
+	"github.com/nathants/nina/providers/gemini"
+	"github.com/nathants/nina/util"
+)
+
+// Tokenizer counts the input tokens of a system prompt and user message
+type Tokenizer interface {
+	CountTokens(ctx context.Context, system, text string) (int, error)
+}
+
+// localTokenizer estimates with o200k, exact for openai models
+type localTokenizer struct{}
+
+func (localTokenizer) CountTokens(_ context.Context, system, text string) (int, error) {
+	tokens := util.CalculateMessageTokens("user", text)
+	if system != "" {
+		tokens += util.CalculateSystemPromptTokens(system)
+	}
+	return tokens, nil
+}
+
+type claudeTokenizer struct{ model string }
+
+func (t claudeTokenizer) CountTokens(ctx context.Context, system, text string) (int, error) {
+	return claude.CountTokens(ctx, t.model, system, text)
+}
+
+type geminiTokenizer struct{ model string }
+
+func (t geminiTokenizer) CountTokens(ctx context.Context, system, text string) (int, error) {
+	return gemini.CountTokens(ctx, t.model, system, text)
+}
+
+// TokenizerFor returns the tokenizer matching a model's provider
+func TokenizerFor(model models.Model) Tokenizer {
+	if os.Getenv("NINA_TOKENIZER") == "local" {
+		return localTokenizer{}
+	}
+	switch model.Provider {
+	case models.ProviderClaude:
+		return claudeTokenizer{model: model.APIModel}
+	case models.ProviderGemini:
+		return geminiTokenizer{model: model.APIModel}
+	default:
+		return localTokenizer{}
+	}
+}
+
+// countTimeout bounds a count tokens api call before falling back
+const countTimeout = 10 * time.Second
+
+var (
+	tokenCounts   = map[string]int{}
+	tokenCountsMu sync.Mutex
+)
+
+// CountTokens counts a system prompt and user message for model. Counts are
+// remembered for the process so repeated checks of the same text make one
+// api call, and api errors fall back to the local tokenizer.
+func CountTokens(model models.Model, system, text string) int {
+	tokenizer := TokenizerFor(model)
+	if _, ok := tokenizer.(localTokenizer); ok {
+		tokens, _ := tokenizer.CountTokens(context.Background(), system, text)
+		return tokens
+	}
+
+	hash := sha256.New()
+	hash.Write([]byte(model.Provider + "\x00" + model.APIModel + "\x00" + system + "\x00" + text))
+	key := hex.EncodeToString(hash.Sum(nil))
+	tokenCountsMu.Lock()
+	tokens, ok := tokenCounts[key]
+	tokenCountsMu.Unlock()
+	if ok {
+		return tokens
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), countTimeout)
+	defer cancel()
+	tokens, err := tokenizer.CountTokens(ctx, system, text)
+	if err != nil {
+		util.Verbosef("counting tokens with %s failed, estimating locally: %v", model.Provider, err)
+		tokens, _ = localTokenizer{}.CountTokens(ctx, system, text)
+		return tokens
+	}
+	tokenCountsMu.Lock()
+	tokenCounts[key] = tokens
+	tokenCountsMu.Unlock()
+	return tokens
+}
+
+// providerModels picks a model per provider for counting message history,
+// where the client does not know its model. models of one family share a
+// tokenizer.
+var providerModels = map[string]string{
+	models.ProviderClaude: "sonnet",
+	models.ProviderGemini: "flash",
+}
+
+// countProviderTokens counts text for any model of provider
+func countProviderTokens(provider, text string) int {
+	model := models.Model{Provider: provider}
+	if alias, ok := providerModels[provider]; ok {
+		if m, err := models.Lookup(alias); err == nil {
+			model = m
+		}
+	}
+	return CountTokens(model, "", text)
+}
@@ -0,0 +1,34 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/nathants/nina/models"
+	"github.com/nathants/nina/util"
+)
+
+func TestTokenizerFor(t *testing.T) {
+	for alias, want := range map[string]Tokenizer{
+		"sonnet": claudeTokenizer{model: "claude-sonnet-4-20250514"},
+		"flash":  geminiTokenizer{model: "gemini-2.5-flash"},
+		"o3":     localTokenizer{},
+		"grok":   localTokenizer{},
+	} {
+		model, err := models.Lookup(alias)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := TokenizerFor(model); got != want {
+			t.Errorf("TokenizerFor(%s) = %#v, want %#v", alias, got, want)
+		}
+	}
+	t.Setenv("NINA_TOKENIZER", "local")
+	model, _ := models.Lookup("sonnet")
+	if got := TokenizerFor(model); got != (localTokenizer{}) {
+		t.Errorf("expected NINA_TOKENIZER=local to count locally, got %#v", got)
+	}
+	want := util.CalculateSystemPromptTokens("system") + util.CalculateMessageTokens("user", "hello world")
+	if got := CountTokens(model, "system", "hello world"); got != want {
+		t.Errorf("CountTokens() = %d, want %d", got, want)
+	}
+}
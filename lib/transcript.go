@@ -0,0 +1,286 @@
+// Transcripts of recorded sessions as chat messages. A session under
+// agents/api is rebuilt from its numbered requests and responses, the system
+// prompt once, then the user message each request added and the response
+// text, whatever provider recorded it. Transcripts are exported and imported
+// as OpenAI chat JSONL, one {"messages": [...]} object per session, the
+// format of fine-tuning datasets and most chat tooling. An imported session
+// is written as chat completions logs, so it can be replayed and searched like
+// a recorded one.
+package lib
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nathants/nina/util"
+)
+
+// FormatOpenAIJSONL is the export and import format of chat message JSONL
+const FormatOpenAIJSONL = "openai-jsonl"
+
+// ChatMessage is one message of a transcript in the OpenAI chat format
+type ChatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// Transcript is the conversation of one session
+type Transcript struct {
+	Session  string        `json:"-"`
+	Model    string        `json:"-"`
+	Messages []ChatMessage `json:"messages"`
+}
+
+// LoadTranscript rebuilds the conversation of a recorded session, see
+// ReplaySessionDir for the forms session takes
+func LoadTranscript(session string) (Transcript, error) {
+	dir, err := ReplaySessionDir(session)
+	if err != nil {
+		return Transcript{}, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return Transcript{}, err
+	}
+	var inputs []string
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), ".input.json") {
+			inputs = append(inputs, entry.Name())
+		}
+	}
+	sort.Slice(inputs, func(i, j int) bool {
+		return logIndex(inputs[i]) < logIndex(inputs[j])
+	})
+	transcript := Transcript{Session: filepath.Base(dir)}
+	for _, name := range inputs {
+		output := strings.TrimSuffix(name, ".input.json") + ".output.json"
+		respData, err := os.ReadFile(filepath.Join(dir, output))
+		if os.IsNotExist(err) {
+			continue // the call failed
+		}
+		if err != nil {
+			return transcript, err
+		}
+		reqData, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return transcript, err
+		}
+		model, system, user, err := parseRecordedRequest(reqData)
+		if err != nil {
+			return transcript, fmt.Errorf("%s: %w", name, err)
+		}
+		resp, err := parseRecordedResponse(respData)
+		if err != nil {
+			return transcript, fmt.Errorf("%s: %w", output, err)
+		}
+		if len(transcript.Messages) == 0 && system != "" {
+			transcript.Messages = append(transcript.Messages, ChatMessage{Role: "system", Content: system})
+		}
+		if transcript.Model == "" {
+			transcript.Model = model
+		}
+		transcript.Messages = append(transcript.Messages,
+			ChatMessage{Role: "user", Content: user},
+			ChatMessage{Role: "assistant", Content: resp.Text},
+		)
+	}
+	if len(transcript.Messages) == 0 {
+		return transcript, fmt.Errorf("no recorded calls in %s", dir)
+	}
+	return transcript, nil
+}
+
+// parseRecordedRequest extracts the model, system prompt, and the last user
+// message from an input.json written by any of the run clients
+func parseRecordedRequest(data []byte) (model, system, user string, err error) {
+	var req map[string]any
+	if err := json.Unmarshal(data, &req); err != nil {
+		return "", "", "", err
+	}
+	model, _ = req["model"].(string)
+	switch s := req["system"].(type) {
+	case string: // gemini
+		system = s
+	case []any: // claude
+		system = loggedText(s)
+	}
+	var history []any
+	for _, key := range []string{"messages", "input", "contents"} {
+		if h, ok := req[key].([]any); ok {
+			history = h
+			break
+		}
+	}
+	for _, msg := range history {
+		m, ok := msg.(map[string]any)
+		if !ok {
+			continue
+		}
+		role, _ := m["role"].(string)
+		text := loggedText(m["content"])
+		if text == "" {
+			text = loggedText(m["parts"])
+		}
+		switch role {
+		case "system", "developer":
+			if system == "" {
+				system = text
+			}
+		case "user":
+			if text != "" {
+				user = text
+			}
+		}
+	}
+	if user == "" {
+		return model, system, "", fmt.Errorf("no user message in recorded request")
+	}
+	return model, system, user, nil
+}
+
+// loggedText returns the text of logged content, a string or a list of text
+// blocks or parts
+func loggedText(content any) string {
+	switch c := content.(type) {
+	case string:
+		return c
+	case []any:
+		var text strings.Builder
+		for _, block := range c {
+			if m, ok := block.(map[string]any); ok {
+				if t, ok := m["text"].(string); ok {
+					text.WriteString(t)
+				}
+			}
+		}
+		return text.String()
+	}
+	return ""
+}
+
+// WriteOpenAIJSONL writes transcripts one per line
+func WriteOpenAIJSONL(w io.Writer, transcripts []Transcript) error {
+	for _, transcript := range transcripts {
+		data, err := json.Marshal(transcript)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(append(data, '\n')); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadOpenAIJSONL reads transcripts one per line, each must alternate user
+// and assistant messages after an optional system message
+func ReadOpenAIJSONL(r io.Reader) ([]Transcript, error) {
+	var transcripts []Transcript
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var transcript Transcript
+		if err := json.Unmarshal(scanner.Bytes(), &transcript); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if err := checkTranscript(transcript); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		transcripts = append(transcripts, transcript)
+	}
+	return transcripts, scanner.Err()
+}
+
+// checkTranscript checks the order of roles
+func checkTranscript(transcript Transcript) error {
+	messages := transcript.Messages
+	if len(messages) > 0 && messages[0].Role == "system" {
+		messages = messages[1:]
+	}
+	if len(messages) == 0 {
+		return fmt.Errorf("no user messages")
+	}
+	for i, msg := range messages {
+		want := "user"
+		if i%2 == 1 {
+			want = "assistant"
+		}
+		if msg.Role != want {
+			return fmt.Errorf("message %d has role %q, want %q", i+1, msg.Role, want)
+		}
+	}
+	return nil
+}
+
+// ImportTranscript writes transcript as a new session under agents/api and
+// indexes it, returning the session id. Each assistant message is one
+// recorded call whose request holds the conversation before it.
+func ImportTranscript(transcript Transcript, model string, now time.Time) (string, error) {
+	if err := checkTranscript(transcript); err != nil {
+		return "", err
+	}
+	if !slices.ContainsFunc(transcript.Messages, func(msg ChatMessage) bool { return msg.Role == "assistant" }) {
+		return "", fmt.Errorf("no assistant messages")
+	}
+	apiDir := util.GetAgentsSubdir("api")
+	id := now.Format("20060102-150405")
+	for {
+		if _, err := os.Stat(filepath.Join(apiDir, id)); os.IsNotExist(err) {
+			break
+		}
+		now = now.Add(time.Second)
+		id = now.Format("20060102-150405")
+	}
+	dir := filepath.Join(apiDir, id)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	logNum := 0
+	for i, msg := range transcript.Messages {
+		if msg.Role != "assistant" {
+			continue
+		}
+		logNum++
+		req := map[string]any{"model": model, "messages": transcript.Messages[:i]}
+		resp := map[string]any{
+			"model": model,
+			"choices": []map[string]any{{
+				"index":         0,
+				"message":       msg,
+				"finish_reason": "stop",
+			}},
+		}
+		if err := util.WriteLog(filepath.Join(dir, fmt.Sprintf("%05d.input.json", logNum)), []byte(util.Pformat(req))); err != nil {
+			return "", err
+		}
+		if err := util.WriteLog(filepath.Join(dir, fmt.Sprintf("%05d.output.json", logNum)), []byte(util.Pformat(resp))); err != nil {
+			return "", err
+		}
+	}
+	prompt := ""
+	for _, msg := range transcript.Messages {
+		if msg.Role == "user" {
+			prompt = msg.Content
+			break
+		}
+	}
+	if len(prompt) > maxIndexedPrompt {
+		prompt = prompt[:maxIndexedPrompt]
+	}
+	record := SessionRecord{ID: id, Time: now.UTC(), Model: model, Title: promptTitle(prompt), Prompt: prompt}
+	if err := AppendSession(record); err != nil {
+		return id, err
+	}
+	return id, nil
+}
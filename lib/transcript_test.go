@@ -0,0 +1,73 @@
+// Tests for transcripts covering the request formats of each provider and a
+// round trip through OpenAI chat JSONL and an imported session
+package lib
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseRecordedRequest(t *testing.T) {
+	for name, input := range map[string]string{
+		"claude": `{"model": "m", "system": [{"type": "text", "text": "sys"}], "messages": [
+			{"role": "user", "content": [{"type": "text", "text": "first"}]},
+			{"role": "assistant", "content": [{"type": "text", "text": "reply"}]},
+			{"role": "user", "content": [{"type": "text", "text": "hi"}]}]}`,
+		"openai": `{"model": "m", "input": [
+			{"type": "message", "role": "developer", "content": [{"type": "input_text", "text": "sys"}]},
+			{"type": "message", "role": "user", "content": [{"type": "input_text", "text": "hi"}]}]}`,
+		"grok": `{"model": "m", "messages": [{"role": "system", "content": "sys"}, {"role": "user", "content": "hi"}]}`,
+		"gemini": `{"model": "m", "system": "sys", "contents": [
+			{"role": "user", "parts": [{"text": "hi"}]},
+			{"role": "model", "parts": [{"text": "reply"}]}]}`,
+	} {
+		model, system, user, err := parseRecordedRequest([]byte(input))
+		if err != nil || model != "m" || system != "sys" || user != "hi" {
+			t.Errorf("%s: parseRecordedRequest() = %q, %q, %q, %v", name, model, system, user, err)
+		}
+	}
+}
+
+func TestTranscriptRoundTrip(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("NINA_SESSIONS_FILE", t.TempDir()+"/sessions.jsonl")
+	jsonl := `{"messages": [{"role": "system", "content": "sys"}, {"role": "user", "content": "fix it"}, {"role": "assistant", "content": "done"}, {"role": "user", "content": "test it"}, {"role": "assistant", "content": "passing"}]}` + "\n"
+
+	transcripts, err := ReadOpenAIJSONL(bytes.NewBufferString(jsonl))
+	if err != nil || len(transcripts) != 1 {
+		t.Fatalf("ReadOpenAIJSONL() = %+v, %v", transcripts, err)
+	}
+	id, err := ImportTranscript(transcripts[0], "imported", time.Date(2025, 7, 1, 9, 30, 0, 0, time.UTC))
+	if err != nil || id != "20250701-093000" {
+		t.Fatalf("ImportTranscript() = %q, %v", id, err)
+	}
+
+	loaded, err := LoadTranscript(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded.Messages, transcripts[0].Messages) || loaded.Model != "imported" {
+		t.Errorf("loaded = %+v, want the imported messages", loaded)
+	}
+	var out bytes.Buffer
+	if err := WriteOpenAIJSONL(&out, []Transcript{loaded}); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != `{"messages":[{"role":"system","content":"sys"},{"role":"user","content":"fix it"},{"role":"assistant","content":"done"},{"role":"user","content":"test it"},{"role":"assistant","content":"passing"}]}`+"\n" {
+		t.Errorf("exported %s", out.String())
+	}
+
+	records, err := ReadSessions()
+	if err != nil || len(records) != 1 || records[0].Title != "fix it" {
+		t.Errorf("sessions = %+v, %v, want the imported session indexed", records, err)
+	}
+}
+
+func TestReadOpenAIJSONLChecksRoles(t *testing.T) {
+	_, err := ReadOpenAIJSONL(bytes.NewBufferString(`{"messages": [{"role": "assistant", "content": "hi"}]}`))
+	if err == nil {
+		t.Error("want an error for a transcript starting with an assistant message")
+	}
+}
@@ -0,0 +1,324 @@
+// tui.go is the full screen status view for nina run --tui. It takes over
+// the terminal's alternate screen and redraws fixed sections for the model's
+// reasoning stream, recent tool output, applied files, token and cost gauges,
+// and timers, instead of the scrolling status bar. Log output is captured
+// into the tool output section while the view is active, and the steering
+// line being typed is shown on the last row, see keys.go.
+package lib
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/nathants/nina/util"
+)
+
+const (
+	tuiRefresh      = 500 * time.Millisecond
+	tuiMaxLines     = 200
+	tuiGaugeWidth   = 30
+	tuiSizeInterval = 2 * time.Second
+)
+
+// TUI renders the live status view
+type TUI struct {
+	mu        sync.Mutex
+	out       io.Writer
+	prevLog   io.Writer
+	model     string
+	step      int
+	input     int
+	maxInput  int
+	output    int
+	cached    float64
+	start     time.Time
+	iterStart time.Time
+	reasoning []string
+	tools     []string
+	files     []string
+	partial   string
+	typed     string
+	width     int
+	height    int
+	sizedAt   time.Time
+	done      chan struct{}
+	stopped   sync.WaitGroup
+}
+
+var (
+	activeTUI   *TUI
+	activeTUIMu sync.Mutex
+)
+
+// TUIAvailable reports whether stdout and stderr are terminals that can host
+// the view
+func TUIAvailable() bool {
+	return util.IsTerminal(os.Stdout) && util.IsTerminal(os.Stderr) && os.Getenv("TERM") != "dumb"
+}
+
+// StartTUI switches the terminal to the status view until StopTUI
+func StartTUI(model string) *TUI {
+	t := &TUI{
+		out:       os.Stderr,
+		model:     model,
+		start:     time.Now(),
+		iterStart: time.Now(),
+		done:      make(chan struct{}),
+	}
+	t.prevLog = util.SetLogOutput(t)
+	activeTUIMu.Lock()
+	activeTUI = t
+	activeTUIMu.Unlock()
+
+	// alternate screen and hidden cursor
+	_, _ = io.WriteString(t.out, "\033[?1049h\033[?25l")
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	t.stopped.Add(1)
+	go func() {
+		defer t.stopped.Done()
+		defer signal.Stop(interrupt)
+		ticker := time.NewTicker(tuiRefresh)
+		defer ticker.Stop()
+		for {
+			t.render()
+			select {
+			case <-t.done:
+				return
+			case <-interrupt:
+				// restore the terminal before exiting like the default handler
+				go func() {
+					StopTUI()
+					restoreTerminal()
+					stopSpawned(nil)
+					os.Exit(130)
+				}()
+				<-t.done
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return t
+}
+
+// StopTUI restores the terminal and log output, then prints a summary so the
+// result of the run stays visible
+func StopTUI() {
+	activeTUIMu.Lock()
+	t := activeTUI
+	activeTUI = nil
+	activeTUIMu.Unlock()
+	if t == nil {
+		return
+	}
+	close(t.done)
+	t.stopped.Wait()
+	util.SetLogOutput(t.prevLog)
+	_, _ = io.WriteString(t.out, "\033[?25h\033[?1049l")
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, line := range lastLines(t.tools, 20) {
+		util.Infof("%s", line)
+	}
+	if len(t.files) > 0 {
+		util.Infof("applied: %s", strings.Join(t.files, ", "))
+	}
+	util.Infof("%s", t.summary())
+}
+
+// currentTUI returns the active view or nil
+func currentTUI() *TUI {
+	activeTUIMu.Lock()
+	defer activeTUIMu.Unlock()
+	return activeTUI
+}
+
+// Write captures log output as tool output lines
+func (t *TUI) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	text := t.partial + util.StripANSI(string(p))
+	lines := strings.Split(text, "\n")
+	t.partial = lines[len(lines)-1]
+	t.tools = appendLines(t.tools, lines[:len(lines)-1]...)
+	return len(p), nil
+}
+
+// Reasoning appends streamed reasoning text
+func (t *TUI) Reasoning(text string) {
+	if t == nil || text == "" {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.reasoning = appendLines(t.reasoning, strings.Split(strings.TrimRight(text, "\n"), "\n")...)
+}
+
+// Step records a finished iteration's usage and events
+func (t *TUI) Step(state *LoopState, events []ProcessorEvent) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.model = statusModel(state)
+	t.step = state.StepNumber
+	t.input = state.SessionUsage.SessionInput
+	t.maxInput = state.MaxTokens
+	t.output = state.SessionUsage.TotalTokens.Output
+	t.cached = state.SessionUsage.CacheHitRatio
+	t.iterStart = time.Now()
+	for _, event := range events {
+		switch event.Type {
+		case "NinaChange", "NinaDelete", "NinaRename", "NinaPlan":
+			if event.Reason == "" && event.Filepath != "" {
+				t.files = append(t.files, event.Filepath)
+			}
+		case "NinaBash":
+			output := strings.TrimSpace(event.Stdout + "\n" + event.Stderr)
+			t.tools = appendLines(t.tools, fmt.Sprintf("$ %s %s (exit %d)", event.Cmd, strings.Join(event.Args, " "), event.ExitCode))
+			t.tools = appendLines(t.tools, lastLines(strings.Split(output, "\n"), 10)...)
+		}
+	}
+}
+
+// SetInput shows the steering line being typed
+func (t *TUI) SetInput(text string) {
+	t.mu.Lock()
+	t.typed = text
+	t.mu.Unlock()
+	t.render()
+}
+
+func (t *TUI) summary() string {
+	return fmt.Sprintf("%s step %d, %s elapsed, %s/%s input, %s output, cached %d%%, $%.2f",
+		t.model, t.step, time.Since(t.start).Round(time.Second),
+		FormatTokens(t.input), FormatTokens(t.maxInput), FormatTokens(t.output),
+		int(t.cached), SessionCost())
+}
+
+func (t *TUI) render() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if time.Since(t.sizedAt) > tuiSizeInterval {
+		t.width, t.height = terminalSize()
+		t.sizedAt = time.Now()
+	}
+	_, _ = io.WriteString(t.out, "\033[H\033[2J"+t.frame(t.width, t.height))
+}
+
+// frame lays out the view in width columns and height rows
+func (t *TUI) frame(width, height int) string {
+	var b strings.Builder
+	header := fmt.Sprintf(" nina %s | step %d | total %s | step %s ",
+		t.model, t.step,
+		time.Since(t.start).Round(time.Second), time.Since(t.iterStart).Round(time.Second))
+	b.WriteString(ColorYellow + truncate(header, width) + ColorReset + "\n")
+
+	percent := 0.0
+	if t.maxInput > 0 {
+		percent = float64(t.input) / float64(t.maxInput)
+	}
+	b.WriteString(truncate(fmt.Sprintf(" input  %s %s/%s", gauge(percent), FormatTokens(t.input), FormatTokens(t.maxInput)), width) + "\n")
+	b.WriteString(truncate(fmt.Sprintf(" cache  %s %d%%", gauge(t.cached/100), int(t.cached)), width) + "\n")
+	b.WriteString(truncate(fmt.Sprintf(" output %s  cost $%.2f", FormatTokens(t.output), SessionCost()), width) + "\n")
+
+	// remaining rows split between reasoning, tools, and files, leaving the
+	// last row for the steering line
+	rows := max(height-4-3-1, 6)
+	fileRows := min(len(t.files), max(rows/5, 1))
+	reasoningRows := (rows - fileRows) / 2
+	toolRows := rows - fileRows - reasoningRows
+
+	section := func(title string, lines []string, n int) {
+		b.WriteString(ColorCyan + truncate("── "+title+" "+strings.Repeat("─", width), width) + ColorReset + "\n")
+		shown := lastLines(lines, n)
+		for _, line := range shown {
+			b.WriteString(truncate(line, width) + "\n")
+		}
+		for range n - len(shown) {
+			b.WriteString("\n")
+		}
+	}
+	section("reasoning", t.reasoning, reasoningRows)
+	section("tools", t.tools, toolRows)
+	var files []string
+	for _, file := range t.files {
+		files = append(files, relativePath(file))
+	}
+	section(fmt.Sprintf("files (%d)", len(t.files)), files, fileRows)
+	if keySteering() {
+		b.WriteString(truncate("> "+t.typed, width))
+	}
+	return b.String()
+}
+
+// terminalSize returns the stderr terminal's columns and rows, 80x24 when unknown
+func terminalSize() (int, int) {
+	cmd := exec.Command("stty", "size")
+	cmd.Stdin = os.Stderr
+	out, err := cmd.Output()
+	if err == nil {
+		if fields := strings.Fields(string(out)); len(fields) == 2 {
+			rows, errRows := strconv.Atoi(fields[0])
+			cols, errCols := strconv.Atoi(fields[1])
+			if errRows == nil && errCols == nil && rows > 0 && cols > 0 {
+				return cols, rows
+			}
+		}
+	}
+	return 80, 24
+}
+
+func gauge(fraction float64) string {
+	fraction = min(max(fraction, 0), 1)
+	filled := int(fraction * tuiGaugeWidth)
+	return "[" + strings.Repeat("#", filled) + strings.Repeat(".", tuiGaugeWidth-filled) + "]"
+}
+
+func truncate(s string, width int) string {
+	s = strings.ReplaceAll(s, "\t", "    ")
+	runes := []rune(s)
+	if width > 0 && len(runes) > width {
+		return string(runes[:width])
+	}
+	return s
+}
+
+func appendLines(lines []string, more ...string) []string {
+	lines = append(lines, more...)
+	if len(lines) > tuiMaxLines {
+		lines = append([]string(nil), lines[len(lines)-tuiMaxLines:]...)
+	}
+	return lines
+}
+
+func lastLines(lines []string, n int) []string {
+	if n <= 0 {
+		return nil
+	}
+	if len(lines) > n {
+		return lines[len(lines)-n:]
+	}
+	return lines
+}
+
+func relativePath(path string) string {
+	if cwd, err := os.Getwd(); err == nil {
+		if rel, err := filepath.Rel(cwd, path); err == nil && !strings.HasPrefix(rel, "..") {
+			return rel
+		}
+	}
+	return path
+}
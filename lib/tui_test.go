@@ -0,0 +1,92 @@
+package lib
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nathants/nina/util"
+)
+
+func TestGauge(t *testing.T) {
+	tests := []struct {
+		fraction float64
+		filled   int
+	}{
+		{0, 0},
+		{0.5, 15},
+		{1, 30},
+		{-1, 0},
+		{1.5, 30},
+	}
+	for _, tt := range tests {
+		got := gauge(tt.fraction)
+		want := "[" + strings.Repeat("#", tt.filled) + strings.Repeat(".", tuiGaugeWidth-tt.filled) + "]"
+		if got != want {
+			t.Errorf("gauge(%v) = %s, want %s", tt.fraction, got, want)
+		}
+	}
+}
+
+func TestTUIFrame(t *testing.T) {
+	tui := &TUI{
+		model:     "sonnet",
+		step:      3,
+		input:     50000,
+		maxInput:  200000,
+		output:    1500,
+		cached:    40,
+		start:     time.Now().Add(-65 * time.Second),
+		iterStart: time.Now().Add(-3 * time.Second),
+		files:     []string{"/tmp/a.go", "/tmp/b.go"},
+	}
+	for i := range 50 {
+		tui.reasoning = appendLines(tui.reasoning, fmt.Sprintf("thought %d", i))
+	}
+	tui.tools = appendLines(tui.tools, "$ go test ./... (exit 0)", "ok\t"+strings.Repeat("x", 100))
+
+	for _, width := range []int{20, 60} {
+		lines := strings.Split(util.StripANSI(tui.frame(width, 24)), "\n")
+		if len(lines) != 24 {
+			t.Fatalf("frame has %d rows, want 24", len(lines))
+		}
+		for _, line := range lines {
+			if n := len([]rune(line)); n > width {
+				t.Errorf("row is %d columns, want at most %d: %q", n, width, line)
+			}
+		}
+	}
+
+	lines := strings.Split(util.StripANSI(tui.frame(60, 24)), "\n")
+	if !strings.Contains(lines[0], "step 3") || !strings.Contains(lines[0], "total 1m5s") || !strings.Contains(lines[0], "step 3s") {
+		t.Errorf("header = %q", lines[0])
+	}
+	if want := " input  " + gauge(0.25) + " 50k/200k"; lines[1] != want {
+		t.Errorf("input gauge = %q, want %q", lines[1], want)
+	}
+	if want := " cache  " + gauge(0.4) + " 40%"; lines[2] != want {
+		t.Errorf("cache gauge = %q, want %q", lines[2], want)
+	}
+
+	// 16 rows under the titles: 2 for files, then 7 reasoning and 7 tools
+	if !strings.HasPrefix(lines[4], "── reasoning") || lines[5] != "thought 43" || lines[11] != "thought 49" {
+		t.Errorf("reasoning shows %q..%q, want the last 7 lines", lines[5], lines[11])
+	}
+	if !strings.HasPrefix(lines[12], "── tools") || lines[13] != "$ go test ./... (exit 0)" || len([]rune(lines[14])) != 60 || lines[19] != "" {
+		t.Errorf("tools section = %q", lines[12:20])
+	}
+	if !strings.HasPrefix(lines[20], "── files (2)") || lines[21] != "/tmp/a.go" || lines[22] != "/tmp/b.go" {
+		t.Errorf("files section = %q", lines[20:23])
+	}
+	if lines[23] != "" {
+		t.Errorf("steering row = %q, want empty without key steering", lines[23])
+	}
+
+	fakeKeySteering(t)
+	tui.typed = "run the tests"
+	lines = strings.Split(util.StripANSI(tui.frame(60, 24)), "\n")
+	if len(lines) != 24 || lines[23] != "> run the tests" {
+		t.Errorf("steering row = %q in %d rows", lines[len(lines)-1], len(lines))
+	}
+}
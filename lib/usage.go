@@ -0,0 +1,331 @@
+// Usage ledger that persists every API call's tokens and cost to ~/.nina/usage.jsonl.
+// Records are appended one json object per line so concurrent processes can share
+// the ledger, and are aggregated by `nina usage` into per model/day/command/user
+// reports or exported as csv or json. Records are tagged with $NINA_USER so teams
+// sharing api keys can attribute costs, and carry the estimated savings of cache
+// reads, the tokens read from cache priced at the input rate less the cache rate.
+package lib
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nathants/nina/models"
+	"github.com/nathants/nina/providers/claude"
+	"github.com/nathants/nina/providers/gemini"
+	"github.com/nathants/nina/providers/grok"
+	"github.com/nathants/nina/providers/groq"
+	"github.com/nathants/nina/providers/ollama"
+	"github.com/nathants/nina/providers/openai"
+	"github.com/nathants/nina/util"
+)
+
+// UsageRecord is a single API call in the usage ledger
+type UsageRecord struct {
+	Time       time.Time `json:"time"`
+	Command    string    `json:"command"`
+	User       string    `json:"user,omitempty"` // $NINA_USER of the caller
+	Model      string    `json:"model"`
+	Input      int       `json:"input"`       // Uncached input tokens
+	Output     int       `json:"output"`      // Output tokens including reasoning
+	CacheRead  int       `json:"cache_read"`  // Input tokens served from cache
+	CacheWrite int       `json:"cache_write"` // Input tokens written to cache
+	Batch      bool      `json:"batch,omitempty"`
+	Cost       float64   `json:"cost"`                    // USD
+	Savings    float64   `json:"cache_savings,omitempty"` // USD saved by cache reads
+}
+
+// UsageCost returns the USD cost of a call, zero for unknown models.
+// Batch calls are billed at half price by both anthropic and openai.
+func UsageCost(model string, usage TokenUsage, batch bool) float64 {
+	price, ok := models.PriceFor(model)
+	if !ok {
+		return 0
+	}
+	cost := (float64(usage.Input)*price.Input +
+		float64(usage.Output)*price.Output +
+		float64(usage.Cache.Read)*price.CacheRead +
+		float64(usage.Cache.Write)*price.CacheWrite) / 1_000_000
+	if batch {
+		cost /= 2
+	}
+	return cost
+}
+
+// CacheSavings returns the USD saved by reading tokens from cache instead of
+// paying the input price for them, zero for unknown models
+func CacheSavings(model string, cacheRead int, batch bool) float64 {
+	price, ok := models.PriceFor(model)
+	if !ok || price.Input <= price.CacheRead {
+		return 0
+	}
+	savings := float64(cacheRead) * (price.Input - price.CacheRead) / 1_000_000
+	if batch {
+		savings /= 2
+	}
+	return savings
+}
+
+// OpenAITokenUsage converts openai usage, where cached tokens are included in
+// input tokens, into a TokenUsage where Input counts only uncached tokens
+func OpenAITokenUsage(usage *openai.Usage) TokenUsage {
+	if usage == nil {
+		return TokenUsage{}
+	}
+	cached := usage.InputTokensDetails.CachedTokens
+	return TokenUsage{
+		Input:  usage.InputTokens - cached,
+		Output: usage.OutputTokens,
+		Cache:  CacheUsage{Read: cached},
+	}
+}
+
+// GrokTokenUsage converts grok usage into a TokenUsage, moving cached
+// prompt tokens from input to cache reads
+func GrokTokenUsage(usage *grok.Usage) TokenUsage {
+	if usage == nil {
+		return TokenUsage{}
+	}
+	cached := usage.CachedTokens()
+	return TokenUsage{
+		Input:  usage.PromptTokens - cached,
+		Output: usage.CompletionTokens,
+		Cache:  CacheUsage{Read: cached},
+	}
+}
+
+// GeminiTokenUsage converts gemini usage into a TokenUsage, thoughts count
+// as output and cached content as cache reads
+func GeminiTokenUsage(usage gemini.Usage) TokenUsage {
+	return TokenUsage{
+		Input:  usage.PromptTokens - usage.CachedTokens,
+		Output: usage.CandidatesTokens + usage.ThoughtsTokens,
+		Cache:  CacheUsage{Read: usage.CachedTokens},
+	}
+}
+
+// GroqTokenUsage converts groq usage into a TokenUsage like GrokTokenUsage
+func GroqTokenUsage(usage *groq.Usage) TokenUsage {
+	if usage == nil {
+		return TokenUsage{}
+	}
+	cached := usage.CachedTokens()
+	return TokenUsage{
+		Input:  usage.PromptTokens - cached,
+		Output: usage.CompletionTokens,
+		Cache:  CacheUsage{Read: cached},
+	}
+}
+
+// OllamaTokenUsage converts the counts of an ollama reply into a TokenUsage,
+// ollama reports no cache use
+func OllamaTokenUsage(resp *ollama.ChatResponse) TokenUsage {
+	if resp == nil {
+		return TokenUsage{}
+	}
+	return TokenUsage{Input: resp.PromptEvalCount, Output: resp.EvalCount}
+}
+
+// ClaudeTokenUsage converts claude usage into a TokenUsage
+func ClaudeTokenUsage(usage claude.Usage) TokenUsage {
+	return TokenUsage{
+		Input:  usage.InputTokens,
+		Output: usage.OutputTokens,
+		Cache:  CacheUsage{Read: usage.CacheReadTokens, Write: usage.CacheWriteTokens},
+	}
+}
+
+// UsageLedgerPath returns the path of the usage ledger, overridable with NINA_USAGE_FILE
+func UsageLedgerPath() string {
+	if path := os.Getenv("NINA_USAGE_FILE"); path != "" {
+		return path
+	}
+	return filepath.Join(os.Getenv("HOME"), ".nina", "usage.jsonl")
+}
+
+var usageMu sync.Mutex
+
+var (
+	sessionCostMu sync.Mutex
+	sessionCost   float64
+)
+
+// SessionCost returns the estimated cost of api calls made by this process
+func SessionCost() float64 {
+	sessionCostMu.Lock()
+	defer sessionCostMu.Unlock()
+	return sessionCost
+}
+
+// RecordUsage appends a call to the usage ledger. Failures are reported to
+// stderr but never fail the caller, the ledger is best effort accounting.
+func RecordUsage(model string, usage TokenUsage, batch bool) {
+	if usage.Input == 0 && usage.Output == 0 && usage.Cache.Read == 0 && usage.Cache.Write == 0 {
+		return
+	}
+	record := UsageRecord{
+		Time:       time.Now().UTC(),
+		Command:    util.CurrentCommand,
+		User:       os.Getenv("NINA_USER"),
+		Model:      model,
+		Input:      usage.Input,
+		Output:     usage.Output,
+		CacheRead:  usage.Cache.Read,
+		CacheWrite: usage.Cache.Write,
+		Batch:      batch,
+		Cost:       UsageCost(model, usage, batch),
+		Savings:    CacheSavings(model, usage.Cache.Read, batch),
+	}
+	sessionCostMu.Lock()
+	sessionCost += record.Cost
+	sessionCostMu.Unlock()
+	if err := appendUsage(record); err != nil {
+		util.Errorf("warning: failed to record usage: %v", err)
+	}
+}
+
+func appendUsage(record UsageRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	usageMu.Lock()
+	defer usageMu.Unlock()
+	path := UsageLedgerPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+// ReadUsage returns all ledger records at or after since
+func ReadUsage(since time.Time) ([]UsageRecord, error) {
+	f, err := os.Open(UsageLedgerPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	var records []UsageRecord
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var record UsageRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			continue // skip partially written lines
+		}
+		if record.Time.Before(since) {
+			continue
+		}
+		if record.Savings == 0 && record.CacheRead > 0 {
+			// recorded before savings were
+			record.Savings = CacheSavings(record.Model, record.CacheRead, record.Batch)
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
+
+// UsageSummary is the aggregate of ledger records sharing a key
+type UsageSummary struct {
+	Key        string
+	Calls      int
+	Input      int
+	Output     int
+	CacheRead  int
+	CacheWrite int
+	Cost       float64
+	Savings    float64
+}
+
+// AggregateUsage groups records by "model", "day", "command", or "user",
+// sorted by key
+func AggregateUsage(records []UsageRecord, by string) ([]UsageSummary, error) {
+	keyFn := map[string]func(UsageRecord) string{
+		"model":   func(r UsageRecord) string { return r.Model },
+		"day":     func(r UsageRecord) string { return r.Time.Local().Format("2006-01-02") },
+		"command": func(r UsageRecord) string { return r.Command },
+		"user":    func(r UsageRecord) string { return r.User },
+	}[by]
+	if keyFn == nil {
+		return nil, fmt.Errorf("unknown grouping: %s (expected model, day, command, or user)", by)
+	}
+	summaries := map[string]*UsageSummary{}
+	for _, r := range records {
+		key := keyFn(r)
+		if key == "" {
+			key = "-"
+		}
+		s, ok := summaries[key]
+		if !ok {
+			s = &UsageSummary{Key: key}
+			summaries[key] = s
+		}
+		s.Calls++
+		s.Input += r.Input
+		s.Output += r.Output
+		s.CacheRead += r.CacheRead
+		s.CacheWrite += r.CacheWrite
+		s.Cost += r.Cost
+		s.Savings += r.Savings
+	}
+	result := make([]UsageSummary, 0, len(summaries))
+	for _, s := range summaries {
+		result = append(result, *s)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Key < result[j].Key })
+	return result, nil
+}
+
+// ExportUsage writes records to w as "csv" with a header row or as a "json"
+// array
+func ExportUsage(w io.Writer, records []UsageRecord, format string) error {
+	switch format {
+	case "json":
+		if records == nil {
+			records = []UsageRecord{}
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(records)
+	case "csv":
+		out := csv.NewWriter(w)
+		_ = out.Write([]string{"time", "user", "command", "model", "input", "output", "cache_read", "cache_write", "batch", "cost", "cache_savings"})
+		for _, r := range records {
+			_ = out.Write([]string{
+				r.Time.UTC().Format(time.RFC3339),
+				r.User,
+				r.Command,
+				r.Model,
+				strconv.Itoa(r.Input),
+				strconv.Itoa(r.Output),
+				strconv.Itoa(r.CacheRead),
+				strconv.Itoa(r.CacheWrite),
+				strconv.FormatBool(r.Batch),
+				strconv.FormatFloat(r.Cost, 'f', 6, 64),
+				strconv.FormatFloat(r.Savings, 'f', 6, 64),
+			})
+		}
+		out.Flush()
+		return out.Error()
+	default:
+		return fmt.Errorf("unknown export format: %s (expected csv or json)", format)
+	}
+}
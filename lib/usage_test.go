@@ -0,0 +1,159 @@
+// Tests for the usage ledger covering cost calculation by model prefix,
+// batch discounting, round tripping records through the jsonl file, user
+// tagging, cache savings, and export
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nathants/nina/providers/grok"
+	"github.com/nathants/nina/providers/groq"
+	"github.com/nathants/nina/util"
+)
+
+func TestUsageCost(t *testing.T) {
+	tests := []struct {
+		name  string
+		model string
+		usage TokenUsage
+		batch bool
+		want  float64
+	}{
+		{"sonnet input output", "claude-sonnet-4-20250514", TokenUsage{Input: 1_000_000, Output: 1_000_000}, false, 18},
+		{"sonnet cache", "claude-sonnet-4-20250514", TokenUsage{Cache: CacheUsage{Read: 1_000_000, Write: 1_000_000}}, false, 4.05},
+		{"o3-pro beats o3 prefix", "o3-pro", TokenUsage{Input: 1_000_000}, false, 20},
+		{"gpt-4.1-mini beats gpt-4.1 prefix", "gpt-4.1-mini", TokenUsage{Output: 1_000_000}, false, 1.6},
+		{"batch is half price", "claude-opus-4-20250514", TokenUsage{Output: 1_000_000}, true, 37.5},
+		{"unknown model", "mystery", TokenUsage{Input: 1_000_000}, false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := UsageCost(tt.model, tt.usage, tt.batch)
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("UsageCost() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOpenAICompatibleTokenUsage(t *testing.T) {
+	want := TokenUsage{Input: 70, Output: 20, Cache: CacheUsage{Read: 30}}
+	gk := GrokTokenUsage(&grok.Usage{PromptTokens: 100, CompletionTokens: 20, PromptTokensDetails: &grok.PromptTokensDetails{CachedTokens: 30}})
+	if gk != want {
+		t.Errorf("GrokTokenUsage() = %+v, want %+v", gk, want)
+	}
+	gq := GroqTokenUsage(&groq.Usage{PromptTokens: 100, CompletionTokens: 20, PromptTokensDetails: &groq.PromptTokensDetails{CachedTokens: 30}})
+	if gq != want {
+		t.Errorf("GroqTokenUsage() = %+v, want %+v", gq, want)
+	}
+	if got := GrokTokenUsage(nil); got != (TokenUsage{}) {
+		t.Errorf("GrokTokenUsage(nil) = %+v, want zero", got)
+	}
+}
+
+func TestRecordAndAggregateUsage(t *testing.T) {
+	t.Setenv("NINA_USAGE_FILE", filepath.Join(t.TempDir(), "usage.jsonl"))
+	start := time.Now().Add(-time.Minute)
+	orig := util.CurrentCommand
+	util.CurrentCommand = "ask"
+	t.Cleanup(func() { util.CurrentCommand = orig })
+
+	RecordUsage("claude-sonnet-4-20250514", TokenUsage{Input: 100, Output: 10}, false)
+	RecordUsage("claude-sonnet-4-20250514", TokenUsage{Input: 50, Output: 5, Cache: CacheUsage{Read: 7}}, false)
+	RecordUsage("o3", TokenUsage{Input: 1, Output: 1}, false)
+	RecordUsage("o3", TokenUsage{}, false) // empty usage is not recorded
+
+	records, err := ReadUsage(start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("expected 3 records, got %d", len(records))
+	}
+	for _, record := range records {
+		if record.Command != "ask" {
+			t.Errorf("recorded command %q, want the dispatched subcommand ask", record.Command)
+		}
+	}
+
+	summaries, err := AggregateUsage(records, "model")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summaries) != 2 {
+		t.Fatalf("expected 2 summaries, got %d", len(summaries))
+	}
+	sonnet := summaries[0]
+	if sonnet.Key != "claude-sonnet-4-20250514" || sonnet.Calls != 2 || sonnet.Input != 150 || sonnet.Output != 15 || sonnet.CacheRead != 7 {
+		t.Errorf("unexpected sonnet summary: %+v", sonnet)
+	}
+
+	if _, err := AggregateUsage(records, "bogus"); err == nil {
+		t.Error("expected error for unknown grouping")
+	}
+
+	records, err = ReadUsage(time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 0 {
+		t.Errorf("expected no records after since, got %d", len(records))
+	}
+}
+
+func TestUsageUsersAndExport(t *testing.T) {
+	t.Setenv("NINA_USAGE_FILE", filepath.Join(t.TempDir(), "usage.jsonl"))
+	start := time.Now().Add(-time.Minute)
+	orig := util.CurrentCommand
+	util.CurrentCommand = "ask"
+	t.Cleanup(func() { util.CurrentCommand = orig })
+
+	t.Setenv("NINA_USER", "alice")
+	RecordUsage("claude-sonnet-4-20250514", TokenUsage{Input: 100, Cache: CacheUsage{Read: 1_000_000}}, false)
+	RecordUsage("claude-sonnet-4-20250514", TokenUsage{Cache: CacheUsage{Read: 1_000_000}}, true)
+	t.Setenv("NINA_USER", "")
+	RecordUsage("o3", TokenUsage{Input: 1, Output: 1}, false)
+
+	records, err := ReadUsage(start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	summaries, err := AggregateUsage(records, "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summaries) != 2 || summaries[0].Key != "-" || summaries[1].Key != "alice" || summaries[1].Calls != 2 {
+		t.Fatalf("unexpected user summaries: %+v", summaries)
+	}
+	if math.Abs(summaries[1].Savings-4.05) > 1e-9 {
+		t.Errorf("alice saved %v, want 2.70 plus 1.35 for the batch call", summaries[1].Savings)
+	}
+
+	var buf bytes.Buffer
+	if err := ExportUsage(&buf, records, "csv"); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[0], "time,user,command,model") || !strings.Contains(lines[1], ",alice,") || !strings.HasSuffix(lines[1], ",2.700000") {
+		t.Errorf("unexpected csv:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	if err := ExportUsage(&buf, records, "json"); err != nil {
+		t.Fatal(err)
+	}
+	var exported []UsageRecord
+	if err := json.Unmarshal(buf.Bytes(), &exported); err != nil || len(exported) != 3 || exported[0].User != "alice" {
+		t.Errorf("unexpected json export %v: %s", err, buf.String())
+	}
+
+	if err := ExportUsage(&buf, records, "xml"); err == nil {
+		t.Error("expected error for unknown export format")
+	}
+}
@@ -12,14 +12,33 @@ import (
 	"strings"
 
 	"github.com/alexflint/go-arg"
+	_ "github.com/nathants/nina/cmd/apply"
 	_ "github.com/nathants/nina/cmd/arch"
 	_ "github.com/nathants/nina/cmd/ask"
 	_ "github.com/nathants/nina/cmd/auth"
+	_ "github.com/nathants/nina/cmd/batch"
+	_ "github.com/nathants/nina/cmd/bench"
 	_ "github.com/nathants/nina/cmd/choose"
+	_ "github.com/nathants/nina/cmd/clean"
+	_ "github.com/nathants/nina/cmd/complete"
+	_ "github.com/nathants/nina/cmd/dataset"
+	_ "github.com/nathants/nina/cmd/docgen"
+	_ "github.com/nathants/nina/cmd/doctor"
 	_ "github.com/nathants/nina/cmd/edit"
+	_ "github.com/nathants/nina/cmd/explain"
+	_ "github.com/nathants/nina/cmd/export"
+	_ "github.com/nathants/nina/cmd/lsp"
+	_ "github.com/nathants/nina/cmd/memory"
+	_ "github.com/nathants/nina/cmd/models"
+	_ "github.com/nathants/nina/cmd/prompt"
+	_ "github.com/nathants/nina/cmd/rename"
 	_ "github.com/nathants/nina/cmd/run"
+	_ "github.com/nathants/nina/cmd/sessions"
+	_ "github.com/nathants/nina/cmd/testgen"
 	_ "github.com/nathants/nina/cmd/tools"
+	_ "github.com/nathants/nina/cmd/usage"
 	"github.com/nathants/nina/lib"
+	"github.com/nathants/nina/util"
 )
 
 func usage() {
@@ -32,6 +51,7 @@ func usage() {
 		}
 	}
 	sort.Strings(fns)
+	fmt.Println("usage: nina [-q|-v] [--json] [--color=auto|always|never] [--proxy=URL] [--ca-bundle=PATH] <command> [args]")
 	fmtStr := "%-" + fmt.Sprint(maxLen) + "s %s\n"
 	for _, fn := range fns {
 		args := lib.Args[fn]
@@ -59,7 +79,52 @@ func usage() {
 	}
 }
 
+// parseGlobalFlags consumes -q/--quiet, -v/--verbose, --json, --color,
+// --proxy, and --ca-bundle before the command, -v twice enables debug output
+// and --json writes fatal errors to stderr as json
+func parseGlobalFlags() {
+	level := util.GetLogLevel()
+	for len(os.Args) > 1 {
+		if value, ok := strings.CutPrefix(os.Args[1], "--color="); ok {
+			mode, err := util.ParseColorMode(value)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			util.SetColorMode(mode)
+			os.Args = append(os.Args[:1], os.Args[2:]...)
+			continue
+		}
+		if value, ok := strings.CutPrefix(os.Args[1], "--proxy="); ok {
+			_ = os.Setenv("NINA_PROXY", value)
+			os.Args = append(os.Args[:1], os.Args[2:]...)
+			continue
+		}
+		if value, ok := strings.CutPrefix(os.Args[1], "--ca-bundle="); ok {
+			_ = os.Setenv("NINA_CA_BUNDLE", value)
+			os.Args = append(os.Args[:1], os.Args[2:]...)
+			continue
+		}
+		switch os.Args[1] {
+		case "-q", "--quiet":
+			level = util.LogQuiet
+		case "-v", "--verbose":
+			level = max(level+1, util.LogVerbose)
+		case "-vv":
+			level = util.LogDebug
+		case "--json":
+			_ = os.Setenv("NINA_JSON_ERRORS", "1")
+		default:
+			util.SetLogLevel(min(level, util.LogDebug))
+			return
+		}
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
+	util.SetLogLevel(min(level, util.LogDebug))
+}
+
 func main() {
+	parseGlobalFlags()
 	if len(os.Args) < 2 || os.Args[1] == "-h" || os.Args[1] == "--help" {
 		usage()
 		os.Exit(1)
@@ -71,6 +136,7 @@ func main() {
 		fmt.Fprintln(os.Stderr, "\nunknown command:", cmd)
 		os.Exit(1)
 	}
+	util.CurrentCommand = cmd
 	os.Args = os.Args[1:]
 	fn()
 }
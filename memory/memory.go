@@ -0,0 +1,294 @@
+package memory
+
+// long-term memory for a project. the agent records durable facts with a
+// NinaRemember tag, they are appended to agents/memory.jsonl at the git root,
+// and the most similar ones are recalled into the first message of later
+// sessions. similarity is the cosine of hashed bag-of-words embeddings,
+// computed locally so recall works offline and costs no api call:
+//
+//	NINA_MEMORY_FILE    overrides the store location
+//	NINA_MEMORY_RECALL  memories recalled per session, default 5, 0 disables
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/nathants/nina/util"
+)
+
+const (
+	// dims is the size of the hashed embedding
+	dims = 512
+
+	// minSimilarity drops memories unrelated to the query
+	minSimilarity = 0.1
+
+	defaultRecall = 5
+
+	// maxLength bounds a single memory
+	maxLength = 2000
+)
+
+// Memory is one remembered fact
+type Memory struct {
+	ID      string    `json:"id"`
+	Text    string    `json:"text"`
+	Created time.Time `json:"created"`
+}
+
+// Path returns the memory store of the current project
+func Path() string {
+	if path := os.Getenv("NINA_MEMORY_FILE"); path != "" {
+		return path
+	}
+	return filepath.Join(util.GetAgentsDir(), "memory.jsonl")
+}
+
+// Load reads every memory in the store, oldest first, a missing store is empty
+func Load() ([]Memory, error) {
+	f, err := os.Open(Path())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	var memories []Memory
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var m Memory
+		if err := json.Unmarshal(scanner.Bytes(), &m); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", Path(), line, err)
+		}
+		memories = append(memories, m)
+	}
+	return memories, scanner.Err()
+}
+
+// save rewrites the store with memories
+func save(memories []Memory) error {
+	path := Path()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	var b strings.Builder
+	for _, m := range memories {
+		data, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+		b.Write(data)
+		b.WriteByte('\n')
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(b.String()), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// memoryID derives a stable short id from the text
+func memoryID(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:])[:8]
+}
+
+// Remember stores a fact, remembering the same text twice returns the
+// existing memory
+func Remember(text string) (Memory, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return Memory{}, fmt.Errorf("memory is empty")
+	}
+	if len(text) > maxLength {
+		return Memory{}, fmt.Errorf("memory is %d bytes, keep it under %d", len(text), maxLength)
+	}
+	memories, err := Load()
+	if err != nil {
+		return Memory{}, err
+	}
+	id := memoryID(text)
+	for _, m := range memories {
+		if m.ID == id {
+			return m, nil
+		}
+	}
+	m := Memory{ID: id, Text: text, Created: time.Now().UTC()}
+	if err := save(append(memories, m)); err != nil {
+		return Memory{}, err
+	}
+	return m, nil
+}
+
+// Forget removes the memory whose id starts with prefix, an ambiguous or
+// unknown prefix is an error
+func Forget(prefix string) (Memory, error) {
+	if prefix == "" {
+		return Memory{}, fmt.Errorf("memory id is empty")
+	}
+	memories, err := Load()
+	if err != nil {
+		return Memory{}, err
+	}
+	match := -1
+	for i, m := range memories {
+		if strings.HasPrefix(m.ID, prefix) {
+			if match != -1 {
+				return Memory{}, fmt.Errorf("memory id %s is ambiguous", prefix)
+			}
+			match = i
+		}
+	}
+	if match == -1 {
+		return Memory{}, fmt.Errorf("no memory with id %s", prefix)
+	}
+	forgotten := memories[match]
+	memories = append(memories[:match], memories[match+1:]...)
+	return forgotten, save(memories)
+}
+
+// RecallLimit is the number of memories recalled per session
+func RecallLimit() int {
+	value := os.Getenv("NINA_MEMORY_RECALL")
+	if value == "" {
+		return defaultRecall
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		util.Errorf("warning: ignoring invalid NINA_MEMORY_RECALL=%s", value)
+		return defaultRecall
+	}
+	return n
+}
+
+// Recall returns up to limit memories most similar to query, most similar
+// first
+func Recall(query string, limit int) ([]Memory, error) {
+	if limit <= 0 {
+		return nil, nil
+	}
+	memories, err := Load()
+	if err != nil {
+		return nil, err
+	}
+	return rank(memories, query, limit), nil
+}
+
+// rank orders memories by similarity to query, dropping unrelated ones
+func rank(memories []Memory, query string, limit int) []Memory {
+	q := Embed(query)
+	type scored struct {
+		memory Memory
+		score  float64
+	}
+	var candidates []scored
+	for _, m := range memories {
+		score := cosine(q, Embed(m.Text))
+		if score >= minSimilarity {
+			candidates = append(candidates, scored{m, score})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].score > candidates[j].score
+	})
+	var result []Memory
+	for i := 0; i < len(candidates) && i < limit; i++ {
+		result = append(result, candidates[i].memory)
+	}
+	return result
+}
+
+// tokens splits text into lowercase words, dropping single characters
+func tokens(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	})
+	result := words[:0]
+	for _, word := range words {
+		if len(word) > 1 {
+			result = append(result, word)
+		}
+	}
+	return result
+}
+
+// Embed maps text to a unit vector by hashing its words and adjacent word
+// pairs, so texts sharing vocabulary point the same way
+func Embed(text string) []float64 {
+	vector := make([]float64, dims)
+	add := func(feature string, weight float64) {
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(feature))
+		sum := h.Sum64()
+		sign := 1.0
+		if sum&(1<<63) != 0 {
+			sign = -1
+		}
+		vector[sum%dims] += sign * weight
+	}
+	words := tokens(text)
+	for i, word := range words {
+		add(word, 1)
+		if i > 0 {
+			add(words[i-1]+" "+word, 0.5)
+		}
+	}
+	var norm float64
+	for _, v := range vector {
+		norm += v * v
+	}
+	if norm == 0 {
+		return vector
+	}
+	norm = math.Sqrt(norm)
+	for i := range vector {
+		vector[i] /= norm
+	}
+	return vector
+}
+
+func cosine(a, b []float64) float64 {
+	var dot float64
+	for i := range a {
+		dot += a[i] * b[i]
+	}
+	return dot
+}
+
+// RecallBlock returns the memories relevant to prompt as a NinaRecall block
+// for the first message of a session, "" when there are none
+func RecallBlock(prompt string) string {
+	memories, err := Recall(prompt, RecallLimit())
+	if err != nil {
+		util.Errorf("warning: recalling memories: %v", err)
+		return ""
+	}
+	if len(memories) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString(util.NinaRecallStart + "\n")
+	for _, m := range memories {
+		b.WriteString("- " + strings.ReplaceAll(m.Text, "\n", "\n  ") + "\n")
+	}
+	b.WriteString(util.NinaRecallEnd)
+	util.Verbosef("recalled %d memories", len(memories))
+	return b.String()
+}
@@ -0,0 +1,77 @@
+package memory
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRememberForget(t *testing.T) {
+	t.Setenv("NINA_MEMORY_FILE", filepath.Join(t.TempDir(), "memory.jsonl"))
+
+	first, err := Remember("  run tests with bin/check.sh  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Text != "run tests with bin/check.sh" {
+		t.Errorf("text = %q, want it trimmed", first.Text)
+	}
+	again, err := Remember("run tests with bin/check.sh")
+	if err != nil || again.ID != first.ID {
+		t.Fatalf("remembering twice = %+v, %v, want %s", again, err, first.ID)
+	}
+	if _, err := Remember("logs go to stderr"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Remember(" "); err == nil {
+		t.Error("empty memory was stored")
+	}
+
+	memories, err := Load()
+	if err != nil || len(memories) != 2 {
+		t.Fatalf("Load() = %+v, %v, want 2 memories", memories, err)
+	}
+	if _, err := Forget("zzzz"); err == nil {
+		t.Error("forgetting an unknown id succeeded")
+	}
+	forgotten, err := Forget(first.ID[:4])
+	if err != nil || forgotten.ID != first.ID {
+		t.Fatalf("Forget() = %+v, %v", forgotten, err)
+	}
+	memories, _ = Load()
+	if len(memories) != 1 || memories[0].Text != "logs go to stderr" {
+		t.Errorf("after forget = %+v", memories)
+	}
+}
+
+func TestRecallRanksBySimilarity(t *testing.T) {
+	t.Setenv("NINA_MEMORY_FILE", filepath.Join(t.TempDir(), "memory.jsonl"))
+	for _, text := range []string{
+		"the database migrations live in db/migrations and run with make migrate",
+		"run the go tests with bin/check.sh before committing",
+		"the user prefers tabs in yaml files",
+	} {
+		if _, err := Remember(text); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := Recall("add a test and make sure the go tests pass", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) == 0 || !strings.Contains(got[0].Text, "go tests") {
+		t.Fatalf("Recall() = %+v, want the tests memory first", got)
+	}
+	if got, _ := Recall("kubernetes helm chart", 5); len(got) != 0 {
+		t.Errorf("unrelated query recalled %+v", got)
+	}
+	if got, _ := Recall("go tests", 0); got != nil {
+		t.Errorf("limit 0 recalled %+v", got)
+	}
+
+	block := RecallBlock("write go tests")
+	if !strings.HasPrefix(block, "<NinaRecall>\n- run the go tests") {
+		t.Errorf("RecallBlock() = %q", block)
+	}
+}
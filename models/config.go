@@ -0,0 +1,259 @@
+// User defined aliases and overrides, read from ~/.nina/models.json
+// (NINA_MODELS_FILE overrides the path) and then the project's
+// .nina/models.json at the git root, so new models can be used without a
+// release. Each key is an alias, the value is either a model string or an
+// object with the model string and default settings:
+//
+//	{
+//	  "fast": "openai:gpt-4.1-nano",
+//	  "sonnet": "claude-sonnet-4-latest",
+//	  "deep": {"model": "o3", "effort": "medium", "service_tier": "flex", "cheaper": "4.1-mini"},
+//	  "opus-1m": {"model": "opus", "context_window": 1000000, "betas": ["context-1m-2025-08-07"]},
+//	  "tuned": {"model": "openai:ft:gpt-4.1:acme::abc123", "protocol": 2},
+//	  "ollama:qwen2.5-coder:14b": {"num_ctx": 32768, "temperature": 0.2}
+//	}
+//
+// A model string is "provider:api-model", a built in alias to copy, or for
+// an alias that already exists, a new api model for the same provider. An
+// alias in the "provider:api-model" form is its own model string, which is
+// how options are set for a model used as --model ollama:<name>.
+
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/nathants/nina/util"
+)
+
+// UserModel is a user defined alias in models.json
+type UserModel struct {
+	Model          string   `json:"model"`
+	Effort         string   `json:"effort,omitempty"`
+	ThinkingBudget *int     `json:"thinking_budget,omitempty"`
+	Temperature    *float64 `json:"temperature,omitempty"`
+	TopP           *float64 `json:"top_p,omitempty"`
+	ServiceTier    string   `json:"service_tier,omitempty"`
+	Background     *bool    `json:"background,omitempty"`
+	ContextWindow  int      `json:"context_window,omitempty"`
+	MaxOutput      int      `json:"max_output,omitempty"`
+	Betas          []string `json:"betas,omitempty"`
+	LongContext    string   `json:"long_context,omitempty"`
+	Cheaper        string   `json:"cheaper,omitempty"`
+	Protocol       int      `json:"protocol,omitempty"`
+	NumCtx         int      `json:"num_ctx,omitempty"`
+}
+
+// UnmarshalJSON accepts a bare model string as shorthand for {"model": ...}
+func (u *UserModel) UnmarshalJSON(data []byte) error {
+	var model string
+	if err := json.Unmarshal(data, &model); err == nil {
+		*u = UserModel{Model: model}
+		return nil
+	}
+	type plain UserModel
+	return json.Unmarshal(data, (*plain)(u))
+}
+
+var (
+	loadOnce    sync.Once
+	loaded      []Model
+	loadedError error
+)
+
+// entries returns the built in registry merged with user config
+func entries() ([]Model, error) {
+	loadOnce.Do(func() {
+		loaded, loadedError = withUserConfig(registry, ConfigPaths())
+	})
+	return loaded, loadedError
+}
+
+// Load reads user config, returning any error in it
+func Load() error {
+	_, err := entries()
+	return err
+}
+
+// ConfigPaths returns the models.json files read, in increasing precedence
+func ConfigPaths() []string {
+	path := os.Getenv("NINA_MODELS_FILE")
+	if path == "" {
+		path = filepath.Join(os.Getenv("HOME"), ".nina", "models.json")
+	}
+	paths := []string{path}
+	if root := util.GetGitRoot(); root != "" {
+		paths = append(paths, filepath.Join(root, ".nina", "models.json"))
+	}
+	return paths
+}
+
+// withUserConfig applies each existing config file to base
+func withUserConfig(base []Model, paths []string) ([]Model, error) {
+	result := append([]Model(nil), base...)
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return base, err
+		}
+		var config map[string]UserModel
+		if err := json.Unmarshal(data, &config); err != nil {
+			return base, fmt.Errorf("invalid %s: %w", path, err)
+		}
+		aliases := make([]string, 0, len(config))
+		for alias := range config {
+			aliases = append(aliases, alias)
+		}
+		slices.Sort(aliases)
+		for _, alias := range aliases {
+			m, err := resolveUserModel(result, alias, config[alias])
+			if err != nil {
+				return base, fmt.Errorf("%s: %s: %w", path, alias, err)
+			}
+			m.Config = path
+			if i := slices.IndexFunc(result, func(e Model) bool { return e.Alias == alias }); i >= 0 {
+				result[i] = m
+			} else {
+				result = append(result, m)
+			}
+		}
+	}
+	return result, nil
+}
+
+// resolveUserModel builds the registry entry for a user alias
+func resolveUserModel(existing []Model, alias string, u UserModel) (Model, error) {
+	if alias == "" || strings.ContainsAny(alias, " \t") {
+		return Model{}, fmt.Errorf("invalid alias")
+	}
+	find := func(name string) (Model, bool) {
+		for _, m := range existing {
+			if m.Alias == name || slices.Contains(m.Aliases, name) {
+				return m, true
+			}
+		}
+		return Model{}, false
+	}
+
+	if _, ok := find(alias); !ok && u.Model == "" && strings.Contains(alias, ":") {
+		u.Model = alias
+	}
+	var m Model
+	provider, apiModel, hasProvider := strings.Cut(u.Model, ":")
+	switch {
+	case u.Model == "":
+		base, ok := find(alias)
+		if !ok {
+			return Model{}, fmt.Errorf("model is required for a new alias")
+		}
+		m = base
+	case hasProvider:
+		template, ok := providerTemplate(provider)
+		if !ok {
+			return Model{}, fmt.Errorf("unknown provider: %s (supported: %s)", provider, strings.Join(providers(), ", "))
+		}
+		if apiModel == "" {
+			return Model{}, fmt.Errorf("missing api model after %s:", provider)
+		}
+		m = template
+		m.APIModel = apiModel
+	default:
+		if base, ok := find(u.Model); ok {
+			m = base
+		} else if base, ok := find(alias); ok {
+			m = base
+			m.APIModel = u.Model
+			m.LongContext = ""
+		} else {
+			return Model{}, fmt.Errorf("unknown model %s, use provider:api-model", u.Model)
+		}
+	}
+
+	m.Alias = alias
+	m.Aliases = nil
+	m.ID = "user:" + alias
+	if u.Effort != "" {
+		m.Effort = u.Effort
+	}
+	if u.ThinkingBudget != nil {
+		m.ThinkingBudget = *u.ThinkingBudget
+	}
+	if u.Temperature != nil {
+		m.Temperature = u.Temperature
+	}
+	if u.TopP != nil {
+		m.TopP = u.TopP
+	}
+	if u.ServiceTier != "" {
+		m.ServiceTier = u.ServiceTier
+	}
+	if u.Background != nil {
+		m.Background = *u.Background
+	}
+	if u.ContextWindow != 0 {
+		m.ContextWindow = u.ContextWindow
+	}
+	if u.MaxOutput != 0 {
+		m.MaxOutput = u.MaxOutput
+	}
+	if u.Betas != nil {
+		m.Betas = u.Betas
+	}
+	if u.LongContext != "" {
+		m.LongContext = u.LongContext
+	}
+	if u.Cheaper != "" {
+		m.Cheaper = u.Cheaper
+	}
+	if u.Protocol != 0 {
+		m.Protocol = u.Protocol
+	}
+	if u.NumCtx != 0 {
+		if m.Provider != ProviderOllama {
+			return Model{}, fmt.Errorf("num_ctx is only supported by ollama models")
+		}
+		m.NumCtx = u.NumCtx
+	}
+	if m.LongContext == alias {
+		// copied from the alias it is the variant of
+		m.LongContext = ""
+	}
+	if err := m.validateReasoning(); err != nil {
+		return Model{}, err
+	}
+	return m, nil
+}
+
+// providerTemplate returns the limits of the provider's first built in model
+// with its reasoning settings cleared
+func providerTemplate(provider string) (Model, bool) {
+	for _, m := range registry {
+		if m.Provider == provider {
+			return Model{
+				Provider:      m.Provider,
+				ContextWindow: m.ContextWindow,
+				MaxOutput:     m.MaxOutput,
+			}, true
+		}
+	}
+	return Model{}, false
+}
+
+func providers() []string {
+	var names []string
+	for _, m := range registry {
+		if !slices.Contains(names, m.Provider) {
+			names = append(names, m.Provider)
+		}
+	}
+	return names
+}
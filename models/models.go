@@ -0,0 +1,349 @@
+// Package models is the registry of model short names shared by every
+// command. Each entry maps an alias like "sonnet" to its provider, the
+// internal id commands dispatch on, the provider's api model id, default
+// reasoning settings, and context window. A model whose prompts may outgrow
+// its window names a variant with a larger one, like sonnet-1m, used when a
+// prompt does not fit. Prices are kept per api model id and matched by
+// longest prefix so dated model ids share a price.
+package models
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+const (
+	ProviderOpenAI   = "openai"
+	ProviderClaude   = "claude"
+	ProviderGemini   = "gemini"
+	ProviderGrok     = "grok"
+	ProviderGroq     = "groq"
+	ProviderV0       = "v0"
+	ProviderOllama   = "ollama"
+	ProviderMistral  = "mistral"
+	ProviderDeepSeek = "deepseek"
+)
+
+// Model is a registry entry
+type Model struct {
+	Alias          string   // short name used on the command line
+	Aliases        []string // other accepted names
+	Provider       string
+	ID             string   // internal id, encodes the settings below
+	APIModel       string   // model id sent to the provider api
+	Effort         string   // openai reasoning effort, empty for none
+	ThinkingBudget int      // claude and gemini thinking tokens, 0 for none
+	Temperature    *float64 // nil for the provider default
+	TopP           *float64 // nil for the provider default
+	ServiceTier    string   // openai service tier, "flex" or empty
+	Background     bool     // openai background mode, submitted then polled
+	Batch          bool     // submitted through the provider batch api
+	ContextWindow  int      // input tokens
+	MaxOutput      int      // output tokens requested
+	Betas          []string // anthropic-beta flags sent with each request
+	LongContext    string   // alias of the same model with a larger context window
+	Cheaper        string   // alias a session past its cost ceiling downshifts to
+	Config         string   // models.json defining the entry, empty when built in
+	Protocol       int      // Nina protocol version of nina run, 0 for the newest
+	NumCtx         int      // ollama context length requested, 0 for the server default
+}
+
+// Price is USD per million tokens
+type Price struct {
+	Input      float64
+	Output     float64
+	CacheRead  float64
+	CacheWrite float64
+}
+
+func temp(t float64) *float64 { return &t }
+
+var registry = []Model{
+	{Alias: "o3", Provider: ProviderOpenAI, ID: "o3-high", APIModel: "o3", Effort: "high", ContextWindow: 200_000, MaxOutput: 100_000, Cheaper: "o4-mini"},
+	{Alias: "o3-flex", Provider: ProviderOpenAI, ID: "o3-flex", APIModel: "o3", Effort: "high", ServiceTier: "flex", ContextWindow: 200_000, MaxOutput: 100_000, Cheaper: "o4-mini-flex"},
+	{Alias: "o3-pro", Provider: ProviderOpenAI, ID: "o3-pro", APIModel: "o3-pro", Effort: "high", Background: true, ContextWindow: 200_000, MaxOutput: 100_000, Cheaper: "o3"},
+	{Alias: "o4-mini", Provider: ProviderOpenAI, ID: "o4-mini-medium", APIModel: "o4-mini", Effort: "medium", ContextWindow: 200_000, MaxOutput: 100_000},
+	{Alias: "o4-mini-flex", Provider: ProviderOpenAI, ID: "o4-mini-flex", APIModel: "o4-mini", Effort: "medium", ServiceTier: "flex", ContextWindow: 200_000, MaxOutput: 100_000},
+	{Alias: "4.1", Aliases: []string{"gpt-4.1"}, Provider: ProviderOpenAI, ID: "gpt-4.1-0.5-temp", APIModel: "gpt-4.1", Temperature: temp(0.5), ContextWindow: 1_047_576, MaxOutput: 32_768, Cheaper: "4.1-mini"},
+	{Alias: "4.1-mini", Aliases: []string{"gpt-4.1-mini"}, Provider: ProviderOpenAI, ID: "gpt-4.1-mini-0.5-temp", APIModel: "gpt-4.1-mini", Temperature: temp(0.5), ContextWindow: 1_047_576, MaxOutput: 32_768},
+	{Alias: "opus", Aliases: []string{"4-opus"}, Provider: ProviderClaude, ID: "claude-4-opus-24k-thinking", APIModel: "claude-opus-4-20250514", ThinkingBudget: 24_000, ContextWindow: 200_000, MaxOutput: 32_000, Cheaper: "sonnet"},
+	{Alias: "opus-batch", Provider: ProviderClaude, ID: "claude-4-opus-batch-24k-thinking", APIModel: "claude-opus-4-20250514", ThinkingBudget: 24_000, Batch: true, ContextWindow: 200_000, MaxOutput: 32_000},
+	{Alias: "sonnet", Aliases: []string{"4-sonnet"}, Provider: ProviderClaude, ID: "claude-4-sonnet-24k-thinking", APIModel: "claude-sonnet-4-20250514", ThinkingBudget: 24_000, ContextWindow: 200_000, MaxOutput: 32_000, LongContext: "sonnet-1m", Cheaper: "flash"},
+	{Alias: "sonnet-1m", Provider: ProviderClaude, ID: "claude-4-sonnet-1m-24k-thinking", APIModel: "claude-sonnet-4-20250514", ThinkingBudget: 24_000, ContextWindow: 1_000_000, MaxOutput: 32_000, Betas: []string{"context-1m-2025-08-07"}, Cheaper: "flash"},
+	{Alias: "sonnet-batch", Provider: ProviderClaude, ID: "claude-4-sonnet-batch-24k-thinking", APIModel: "claude-sonnet-4-20250514", ThinkingBudget: 24_000, Batch: true, ContextWindow: 200_000, MaxOutput: 32_000},
+	{Alias: "gemini", Provider: ProviderGemini, ID: "gemini-2.5-pro-32k-thinking", APIModel: "gemini-2.5-pro", ThinkingBudget: 32_000, ContextWindow: 1_048_576, MaxOutput: 65_536, Cheaper: "flash"},
+	{Alias: "flash", Provider: ProviderGemini, ID: "gemini-2.5-flash-24k-thinking", APIModel: "gemini-2.5-flash", ThinkingBudget: 24_000, ContextWindow: 1_048_576, MaxOutput: 65_536},
+	{Alias: "grok", Provider: ProviderGrok, ID: "grok-4-0709", APIModel: "grok-4-0709", Temperature: temp(0), ContextWindow: 256_000, Cheaper: "flash"},
+	{Alias: "k2", Provider: ProviderGroq, ID: "moonshotai/kimi-k2-instruct", APIModel: "moonshotai/kimi-k2-instruct", Temperature: temp(0.6), ContextWindow: 131_072, MaxOutput: 16_384},
+	{Alias: "v0-md", Provider: ProviderV0, ID: "v0-1.5-md", APIModel: "v0-1.5-md", ContextWindow: 128_000},
+	{Alias: "v0-lg", Provider: ProviderV0, ID: "v0-1.5-lg", APIModel: "v0-1.5-lg", ContextWindow: 512_000},
+	{Alias: "ollama", Provider: ProviderOllama, ID: "ollama", APIModel: "ollama"},
+	{Alias: "codestral", Provider: ProviderMistral, ID: "codestral-latest", APIModel: "codestral-latest", ContextWindow: 256_000},
+	{Alias: "deepseek", Provider: ProviderDeepSeek, ID: "deepseek-chat", APIModel: "deepseek-chat", ContextWindow: 64_000, MaxOutput: 8_192},
+}
+
+// prices is matched by longest prefix against the api model id
+var prices = map[string]Price{
+	"claude-opus-4":               {Input: 15, Output: 75, CacheRead: 1.5, CacheWrite: 18.75},
+	"claude-sonnet-4":             {Input: 3, Output: 15, CacheRead: 0.3, CacheWrite: 3.75},
+	"o3-pro":                      {Input: 20, Output: 80, CacheRead: 20},
+	"o3":                          {Input: 2, Output: 8, CacheRead: 0.5},
+	"o4-mini":                     {Input: 1.1, Output: 4.4, CacheRead: 0.275},
+	"gpt-4.1-mini":                {Input: 0.4, Output: 1.6, CacheRead: 0.1},
+	"gpt-4.1-nano":                {Input: 0.1, Output: 0.4, CacheRead: 0.025},
+	"gpt-4.1":                     {Input: 2, Output: 8, CacheRead: 0.5},
+	"gemini-2.5-pro":              {Input: 1.25, Output: 10, CacheRead: 0.31},
+	"gemini-2.5-flash":            {Input: 0.3, Output: 2.5, CacheRead: 0.075},
+	"grok-4":                      {Input: 3, Output: 15, CacheRead: 0.75},
+	"moonshotai/kimi-k2-instruct": {Input: 1, Output: 3},
+	"codestral":                   {Input: 0.3, Output: 0.9},
+	"deepseek-chat":               {Input: 0.27, Output: 1.1, CacheRead: 0.07},
+}
+
+// Lookup returns the model for an alias
+func Lookup(alias string) (Model, error) {
+	all, err := entries()
+	if err != nil {
+		return Model{}, err
+	}
+	for _, m := range all {
+		if m.Alias == alias {
+			return m, nil
+		}
+	}
+	for _, m := range all {
+		for _, a := range m.Aliases {
+			if a == alias {
+				return m, nil
+			}
+		}
+	}
+	if name, ok := strings.CutPrefix(alias, ProviderOllama+":"); ok && name != "" {
+		return ollamaModel(alias, name), nil
+	}
+	return Model{}, fmt.Errorf("unknown model: %s (supported: %s, or ollama:<name>)", alias, strings.Join(Aliases(), ", "))
+}
+
+// ollamaModel registers and returns an entry for an installed ollama model
+// named on the command line as ollama:<name> without a models.json entry
+func ollamaModel(alias, name string) Model {
+	m := Model{Alias: alias, Provider: ProviderOllama, ID: alias, APIModel: name}
+	loaded = append(loaded, m)
+	return m
+}
+
+// MustLookup returns the model for an alias, panicking when unknown
+func MustLookup(alias string) Model {
+	m, err := Lookup(alias)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+// ByID returns the model for an internal id
+func ByID(id string) (Model, bool) {
+	for _, m := range All() {
+		if m.ID == id {
+			return m, true
+		}
+	}
+	return Model{}, false
+}
+
+// ByAPIModel returns the first non batch model using an api model id
+func ByAPIModel(apiModel string) (Model, bool) {
+	for _, m := range All() {
+		if m.APIModel == apiModel && !m.Batch {
+			return m, true
+		}
+	}
+	return Model{}, false
+}
+
+// APIModelID returns the provider api model for an internal id, panicking
+// when unknown like the per provider tables it replaces
+func APIModelID(id string) string {
+	m, ok := ByID(id)
+	if !ok {
+		panic("unknown model id: " + id)
+	}
+	return m.APIModel
+}
+
+// Supported reports whether alias is a registered short name
+func Supported(alias string) bool {
+	_, err := Lookup(alias)
+	return err == nil
+}
+
+// Aliases returns the primary short names in registry order
+func Aliases() []string {
+	names := make([]string, 0, len(registry))
+	for _, m := range All() {
+		names = append(names, m.Alias)
+	}
+	return names
+}
+
+// All returns every registry entry in registry order, then user entries
+func All() []Model {
+	all, _ := entries()
+	return append([]Model(nil), all...)
+}
+
+// ForProvider returns the aliases served by provider
+func ForProvider(provider string) []string {
+	var names []string
+	for _, m := range All() {
+		if m.Provider == provider {
+			names = append(names, m.Alias)
+		}
+	}
+	return names
+}
+
+// PriceFor returns the price of an api model id by longest prefix
+func PriceFor(apiModel string) (Price, bool) {
+	best := ""
+	for prefix := range prices {
+		if strings.HasPrefix(apiModel, prefix) && len(prefix) > len(best) {
+			best = prefix
+		}
+	}
+	if best == "" {
+		return Price{}, false
+	}
+	return prices[best], true
+}
+
+// Price returns the model's price
+func (m Model) Price() (Price, bool) {
+	return PriceFor(m.APIModel)
+}
+
+// Names returns the alias followed by any other accepted names, sorted
+func (m Model) Names() []string {
+	names := append([]string{m.Alias}, m.Aliases...)
+	sort.Strings(names[1:])
+	return names
+}
+
+// Efforts are the accepted openai reasoning efforts
+var Efforts = []string{"low", "medium", "high"}
+
+// maxGeminiThinking is the largest thinking budget gemini accepts
+const maxGeminiThinking = 32_768
+
+// WithReasoning returns m with its effort and thinking budget overridden,
+// empty and zero keep the defaults
+func (m Model) WithReasoning(effort string, budget int) (Model, error) {
+	if effort != "" {
+		m.Effort = effort
+	}
+	if budget != 0 {
+		m.ThinkingBudget = budget
+	}
+	if err := m.validateReasoning(); err != nil {
+		return Model{}, fmt.Errorf("%s: %w", m.Alias, err)
+	}
+	return m, nil
+}
+
+// validateReasoning checks the settings against the provider's capabilities
+func (m Model) validateReasoning() error {
+	if m.Effort != "" {
+		if !slices.Contains(Efforts, m.Effort) {
+			return fmt.Errorf("invalid effort: %s (expected %s)", m.Effort, strings.Join(Efforts, ", "))
+		}
+		if m.Provider != ProviderOpenAI || !strings.HasPrefix(m.APIModel, "o") {
+			return fmt.Errorf("effort is only supported by openai reasoning models")
+		}
+	}
+	if m.ThinkingBudget != 0 {
+		switch m.Provider {
+		case ProviderClaude:
+			if m.ThinkingBudget < 1024 || (m.MaxOutput > 0 && m.ThinkingBudget >= m.MaxOutput) {
+				return fmt.Errorf("invalid thinking budget: %d (expected 1024 to %d)", m.ThinkingBudget, m.MaxOutput-1)
+			}
+		case ProviderGemini:
+			if m.ThinkingBudget < 0 || m.ThinkingBudget > maxGeminiThinking {
+				return fmt.Errorf("invalid thinking budget: %d (expected 0 to %d)", m.ThinkingBudget, maxGeminiThinking)
+			}
+		default:
+			return fmt.Errorf("thinking budget is only supported by claude and gemini models")
+		}
+	}
+	return nil
+}
+
+// SetReasoning overrides the effort and thinking budget of alias for the rest
+// of the process and returns the updated model
+func SetReasoning(alias, effort string, budget int) (Model, error) {
+	m, err := Lookup(alias)
+	if err != nil {
+		return Model{}, err
+	}
+	if effort == "" && budget == 0 {
+		return m, nil
+	}
+	if m, err = m.WithReasoning(effort, budget); err != nil {
+		return Model{}, err
+	}
+	Override(m)
+	return m, nil
+}
+
+// WithSampling returns m with its temperature, top_p, and max output tokens
+// overridden, nil and zero keep the defaults. Openai reasoning models and
+// claude with thinking enabled only accept their fixed sampling settings.
+func (m Model) WithSampling(temperature, topP *float64, maxOutput int) (Model, error) {
+	if temperature != nil || topP != nil {
+		switch {
+		case m.Provider == ProviderOpenAI && m.Effort != "":
+			return Model{}, fmt.Errorf("%s: reasoning models do not accept temperature or top_p, use --effort", m.Alias)
+		case m.Provider == ProviderClaude && m.ThinkingBudget > 0:
+			return Model{}, fmt.Errorf("%s: temperature and top_p are not supported with thinking, use --thinking-budget", m.Alias)
+		case m.Provider == ProviderV0:
+			return Model{}, fmt.Errorf("%s: temperature and top_p are not supported by %s", m.Alias, m.Provider)
+		}
+	}
+	maxTemperature := 2.0
+	if m.Provider == ProviderClaude {
+		maxTemperature = 1
+	}
+	if temperature != nil {
+		if *temperature < 0 || *temperature > maxTemperature {
+			return Model{}, fmt.Errorf("%s: invalid temperature: %g (expected 0 to %g)", m.Alias, *temperature, maxTemperature)
+		}
+		m.Temperature = temperature
+	}
+	if topP != nil {
+		if *topP <= 0 || *topP > 1 {
+			return Model{}, fmt.Errorf("%s: invalid top_p: %g (expected above 0 up to 1)", m.Alias, *topP)
+		}
+		m.TopP = topP
+	}
+	if maxOutput != 0 {
+		if maxOutput < 0 {
+			return Model{}, fmt.Errorf("%s: invalid max output: %d", m.Alias, maxOutput)
+		}
+		if m.ThinkingBudget > 0 && m.Provider == ProviderClaude && maxOutput <= m.ThinkingBudget {
+			return Model{}, fmt.Errorf("%s: max output %d must be above the thinking budget %d", m.Alias, maxOutput, m.ThinkingBudget)
+		}
+		m.MaxOutput = maxOutput
+	}
+	return m, nil
+}
+
+// Override replaces the registry entry with m's id for the rest of the process
+func Override(m Model) {
+	all, _ := entries()
+	for i := range all {
+		if all[i].ID == m.ID {
+			all[i] = m
+		}
+	}
+}
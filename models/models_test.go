@@ -0,0 +1,232 @@
+package models
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLookup(t *testing.T) {
+	for alias, id := range map[string]string{
+		"o3":      "o3-high",
+		"gpt-4.1": "gpt-4.1-0.5-temp",
+		"4-opus":  "claude-4-opus-24k-thinking",
+		"k2":      "moonshotai/kimi-k2-instruct",
+	} {
+		m, err := Lookup(alias)
+		if err != nil {
+			t.Fatalf("%s: %v", alias, err)
+		}
+		if m.ID != id {
+			t.Errorf("%s: got id %s, want %s", alias, m.ID, id)
+		}
+		byID, ok := ByID(id)
+		if !ok || byID.Alias != m.Alias {
+			t.Errorf("%s: ByID(%s) = %v, %v", alias, id, byID.Alias, ok)
+		}
+	}
+	if _, err := Lookup("gpt-5"); err == nil {
+		t.Error("expected error for unknown model")
+	}
+	m, err := Lookup("ollama:qwen2.5-coder:14b")
+	if err != nil || m.Provider != ProviderOllama || m.APIModel != "qwen2.5-coder:14b" {
+		t.Errorf("ollama:qwen2.5-coder:14b: %+v %v", m, err)
+	}
+	if byID, ok := ByID(m.ID); !ok || byID.APIModel != m.APIModel {
+		t.Errorf("ByID(%s) = %+v, %v", m.ID, byID, ok)
+	}
+}
+
+func TestPriceFor(t *testing.T) {
+	for model, input := range map[string]float64{
+		"o3":                      2,
+		"o3-pro":                  20,
+		"gpt-4.1-mini-2025-04-14": 0.4,
+		"claude-opus-4-20250514":  15,
+	} {
+		price, ok := PriceFor(model)
+		if !ok || price.Input != input {
+			t.Errorf("%s: got %v %v, want input %v", model, price, ok, input)
+		}
+	}
+	if _, ok := PriceFor("unknown"); ok {
+		t.Error("expected no price for unknown model")
+	}
+}
+
+func TestUserConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "models.json")
+	config := `{
+		"fast": "openai:gpt-4.1-nano",
+		"sonnet": "claude-sonnet-4-latest",
+		"deep": {"model": "o3", "effort": "medium", "service_tier": "flex"},
+		"cold": {"model": "4.1", "temperature": 0},
+		"ollama:qwen2.5-coder": {"num_ctx": 32768, "temperature": 0.2}
+	}`
+	if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	all, err := withUserConfig(registry, []string{path, filepath.Join(t.TempDir(), "missing.json")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	find := func(alias string) Model {
+		for _, m := range all {
+			if m.Alias == alias {
+				return m
+			}
+		}
+		t.Fatalf("missing %s", alias)
+		return Model{}
+	}
+	if m := find("fast"); m.Provider != ProviderOpenAI || m.APIModel != "gpt-4.1-nano" || m.Effort != "" || m.Config != path {
+		t.Errorf("fast: %+v", m)
+	}
+	if m := find("sonnet"); m.Provider != ProviderClaude || m.APIModel != "claude-sonnet-4-latest" || m.ThinkingBudget != 24_000 || m.ID != "user:sonnet" {
+		t.Errorf("sonnet: %+v", m)
+	}
+	if m := find("deep"); m.APIModel != "o3" || m.Effort != "medium" || m.ServiceTier != "flex" {
+		t.Errorf("deep: %+v", m)
+	}
+	if m := find("cold"); m.Temperature == nil || *m.Temperature != 0 {
+		t.Errorf("cold: %+v", m)
+	}
+	if m := find("ollama:qwen2.5-coder"); m.Provider != ProviderOllama || m.APIModel != "qwen2.5-coder" || m.NumCtx != 32768 || *m.Temperature != 0.2 {
+		t.Errorf("ollama:qwen2.5-coder: %+v", m)
+	}
+	if len(all) != len(registry)+4 {
+		t.Errorf("got %d entries, want %d", len(all), len(registry)+4)
+	}
+
+	for _, bad := range []string{
+		`{"x": "openrouter:qwen"}`,
+		`{"x": "nope"}`,
+		`{"x": {"model": "sonnet", "effort": "high"}}`,
+		`{"x": {"model": "sonnet", "num_ctx": 8192}}`,
+	} {
+		if err := os.WriteFile(path, []byte(bad), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := withUserConfig(registry, []string{path}); err == nil {
+			t.Errorf("expected error for %s", bad)
+		}
+	}
+}
+
+func TestWithReasoning(t *testing.T) {
+	m, err := MustLookup("o3").WithReasoning("low", 0)
+	if err != nil || m.Effort != "low" {
+		t.Errorf("o3 low: %v %v", m.Effort, err)
+	}
+	m, err = MustLookup("sonnet").WithReasoning("", 8000)
+	if err != nil || m.ThinkingBudget != 8000 {
+		t.Errorf("sonnet 8000: %v %v", m.ThinkingBudget, err)
+	}
+	for _, c := range []struct {
+		alias  string
+		effort string
+		budget int
+	}{
+		{"o3", "extreme", 0},
+		{"4.1", "high", 0},
+		{"sonnet", "high", 0},
+		{"o3", "", 8000},
+		{"sonnet", "", 500},
+		{"sonnet", "", 32000},
+		{"gemini", "", 40000},
+		{"grok", "", 1024},
+	} {
+		if _, err := MustLookup(c.alias).WithReasoning(c.effort, c.budget); err == nil {
+			t.Errorf("%s effort=%q budget=%d: expected error", c.alias, c.effort, c.budget)
+		}
+	}
+}
+
+func TestWithSampling(t *testing.T) {
+	temperature, topP := 0.2, 0.9
+	m, err := MustLookup("4.1").WithSampling(&temperature, &topP, 1000)
+	if err != nil || *m.Temperature != 0.2 || *m.TopP != 0.9 || m.MaxOutput != 1000 {
+		t.Errorf("4.1: %+v %v", m, err)
+	}
+	if _, err := MustLookup("o3").WithSampling(nil, nil, 5000); err != nil {
+		t.Errorf("o3 max output: %v", err)
+	}
+	high, zero := 1.5, 0.0
+	for _, c := range []struct {
+		alias       string
+		temperature *float64
+		topP        *float64
+		maxOutput   int
+	}{
+		{"o3", &temperature, nil, 0},
+		{"sonnet", nil, &topP, 0},
+		{"4.1", nil, &zero, 0},
+		{"k2", &high, &high, 0},
+		{"sonnet", nil, nil, 20000},
+	} {
+		if _, err := MustLookup(c.alias).WithSampling(c.temperature, c.topP, c.maxOutput); err == nil {
+			t.Errorf("%s: expected error", c.alias)
+		}
+	}
+}
+
+func TestCheaper(t *testing.T) {
+	for _, m := range registry {
+		if m.Cheaper == "" {
+			continue
+		}
+		cheaper, err := Lookup(m.Cheaper)
+		if err != nil || cheaper.Batch {
+			t.Errorf("%s: invalid cheaper model %s %v", m.Alias, m.Cheaper, err)
+			continue
+		}
+		price, _ := m.Price()
+		cheaperPrice, ok := cheaper.Price()
+		if !ok || cheaperPrice.Output >= price.Output {
+			t.Errorf("%s: %s is not cheaper", m.Alias, m.Cheaper)
+		}
+	}
+}
+
+func TestLongContext(t *testing.T) {
+	for _, m := range registry {
+		if m.LongContext == "" {
+			continue
+		}
+		long, err := Lookup(m.LongContext)
+		if err != nil || long.Provider != m.Provider || long.APIModel != m.APIModel || long.ContextWindow <= m.ContextWindow || long.LongContext != "" {
+			t.Errorf("%s: invalid long context variant %+v %v", m.Alias, long, err)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "models.json")
+	config := `{
+		"opus-1m": {"model": "opus", "context_window": 1000000, "betas": ["context-1m-2025-08-07"]},
+		"opus": {"model": "claude-opus-4-1", "long_context": "opus-1m"},
+		"sonnet": "claude-sonnet-5"
+	}`
+	if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	all, err := withUserConfig(registry, []string{path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range all {
+		switch m.Alias {
+		case "opus-1m":
+			if m.ContextWindow != 1_000_000 || len(m.Betas) != 1 || m.APIModel != "claude-opus-4-1" || m.LongContext != "" {
+				t.Errorf("opus-1m: %+v", m)
+			}
+		case "opus":
+			if m.LongContext != "opus-1m" || m.Betas != nil {
+				t.Errorf("opus: %+v", m)
+			}
+		case "sonnet":
+			// the built in variant is for another api model
+			if m.LongContext != "" {
+				t.Errorf("sonnet: %+v", m)
+			}
+		}
+	}
+}
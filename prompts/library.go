@@ -0,0 +1,172 @@
+package prompts
+
+// Named prompt templates stored as <name>.md under ~/.config/nina/prompts
+// ($XDG_CONFIG_HOME/nina/prompts when set, NINA_PROMPTS_DIR overrides). They
+// are rendered with text/template, each --var key=value is available both as
+// {{key}} and {{.key}}, and a placeholder without a value is an error.
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+var templateNameRegex = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+var varNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// TemplateDir returns the directory holding prompt templates
+func TemplateDir() string {
+	if dir := os.Getenv("NINA_PROMPTS_DIR"); dir != "" {
+		return dir
+	}
+	config := os.Getenv("XDG_CONFIG_HOME")
+	if config == "" {
+		config = filepath.Join(os.Getenv("HOME"), ".config")
+	}
+	return filepath.Join(config, "nina", "prompts")
+}
+
+// TemplatePath returns the file for a named template
+func TemplatePath(name string) (string, error) {
+	if !templateNameRegex.MatchString(name) {
+		return "", fmt.Errorf("invalid template name: %q", name)
+	}
+	return filepath.Join(TemplateDir(), name+".md"), nil
+}
+
+// ListTemplates returns the names of stored templates, sorted
+func ListTemplates() ([]string, error) {
+	entries, err := os.ReadDir(TemplateDir())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if name, ok := strings.CutSuffix(entry.Name(), ".md"); ok && !entry.IsDir() {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// LoadTemplate returns the text of a named template
+func LoadTemplate(name string) (string, error) {
+	path, err := TemplatePath(name)
+	if err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", fmt.Errorf("no prompt template named %s, see 'nina prompt list'", name)
+	}
+	return string(data), err
+}
+
+// SaveTemplate stores text as a named template after checking it parses
+func SaveTemplate(name, text string) error {
+	path, err := TemplatePath(name)
+	if err != nil {
+		return err
+	}
+	if _, err := parseTemplate(name, text, nil); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(text), 0644)
+}
+
+// DeleteTemplate removes a named template
+func DeleteTemplate(name string) error {
+	path, err := TemplatePath(name)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("no prompt template named %s", name)
+	}
+	return err
+}
+
+// ParseVars parses key=value pairs from --var flags
+func ParseVars(pairs []string) (map[string]string, error) {
+	vars := map[string]string{}
+	for _, pair := range pairs {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || !varNameRegex.MatchString(key) {
+			return nil, fmt.Errorf("invalid --var %q, expected key=value", pair)
+		}
+		vars[key] = value
+	}
+	return vars, nil
+}
+
+// Render executes template text with vars
+func Render(name, text string, vars map[string]string) (string, error) {
+	if vars == nil {
+		vars = map[string]string{}
+	}
+	tmpl, err := parseTemplate(name, text, vars)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, vars); err != nil {
+		return "", fmt.Errorf("prompt template %s: %w", name, err)
+	}
+	return buf.String(), nil
+}
+
+// RenderTemplate loads and renders a named template
+func RenderTemplate(name string, vars map[string]string) (string, error) {
+	text, err := LoadTemplate(name)
+	if err != nil {
+		return "", err
+	}
+	return Render(name, text, vars)
+}
+
+// parseTemplate parses text with each var as a function so {{key}} works. When
+// vars is nil, any undefined name is allowed so templates can be validated
+// before their values are known.
+func parseTemplate(name, text string, vars map[string]string) (*template.Template, error) {
+	funcs := template.FuncMap{}
+	for key, value := range vars {
+		funcs[key] = func() string { return value }
+	}
+	for {
+		parsed, err := template.New(name).Option("missingkey=error").Funcs(funcs).Parse(text)
+		if err == nil {
+			return parsed, nil
+		}
+		missing := undefinedFunction(err)
+		if missing == "" {
+			return nil, fmt.Errorf("prompt template %s: %w", name, err)
+		}
+		if vars != nil {
+			return nil, fmt.Errorf("prompt template %s: no value for {{%s}}, set it with --var %s=...", name, missing, missing)
+		}
+		funcs[missing] = func() string { return "" }
+	}
+}
+
+var undefinedFunctionRegex = regexp.MustCompile(`function "([^"]+)" not defined`)
+
+func undefinedFunction(err error) string {
+	if m := undefinedFunctionRegex.FindStringSubmatch(err.Error()); m != nil {
+		return m[1]
+	}
+	return ""
+}
@@ -0,0 +1,38 @@
+package prompts
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	text := "refactor {{pkg}} then test {{.pkg}}{{if .strict}} strictly{{end}}"
+	got, err := Render("refactor", text, map[string]string{"pkg": "util", "strict": "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "refactor util then test util strictly" {
+		t.Errorf("got %q", got)
+	}
+	if _, err := Render("refactor", text, map[string]string{"strict": "1"}); err == nil || !strings.Contains(err.Error(), "--var pkg=") {
+		t.Errorf("expected missing var error, got %v", err)
+	}
+	if _, err := parseTemplate("refactor", text, nil); err != nil {
+		t.Errorf("validate: %v", err)
+	}
+	if _, err := parseTemplate("bad", "{{pkg", nil); err == nil {
+		t.Error("expected parse error")
+	}
+}
+
+func TestParseVars(t *testing.T) {
+	vars, err := ParseVars([]string{"pkg=util", "msg=a=b"})
+	if err != nil || vars["pkg"] != "util" || vars["msg"] != "a=b" {
+		t.Errorf("got %v %v", vars, err)
+	}
+	for _, bad := range []string{"pkg", "=x", "1x=y"} {
+		if _, err := ParseVars([]string{bad}); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
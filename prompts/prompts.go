@@ -3,6 +3,9 @@ package prompts
 
 import (
 	"embed"
+	"fmt"
+	"os"
+	"strings"
 )
 
 //go:embed ASK.md
@@ -23,3 +26,39 @@ func Ask() string {
 func Choose() string {
 	return choosePrompt
 }
+
+// Override replaces or extends a command's embedded system prompt, from the
+// --system, --system-file, and --append-system flags
+type Override struct {
+	System string // replaces the embedded prompt when not empty
+	Append string // appended to the prompt when not empty
+}
+
+// NewOverride builds an override from flag values, reading systemFile when set
+func NewOverride(system, systemFile, appendSystem string) (Override, error) {
+	if system != "" && systemFile != "" {
+		return Override{}, fmt.Errorf("--system and --system-file cannot be used together")
+	}
+	if systemFile != "" {
+		data, err := os.ReadFile(systemFile)
+		if err != nil {
+			return Override{}, fmt.Errorf("--system-file: %w", err)
+		}
+		system = string(data)
+		if strings.TrimSpace(system) == "" {
+			return Override{}, fmt.Errorf("--system-file: %s is empty", systemFile)
+		}
+	}
+	return Override{System: system, Append: appendSystem}, nil
+}
+
+// Apply returns prompt with the override applied
+func (o Override) Apply(prompt string) string {
+	if o.System != "" {
+		prompt = o.System
+	}
+	if o.Append != "" {
+		prompt = strings.TrimRight(prompt, "\n") + "\n\n" + o.Append
+	}
+	return prompt
+}
@@ -0,0 +1,61 @@
+package prompts
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOverrideApply(t *testing.T) {
+	tests := []struct {
+		name     string
+		override Override
+		want     string
+	}{
+		{"none", Override{}, "embedded\n"},
+		{"replace", Override{System: "custom"}, "custom"},
+		{"append", Override{Append: "be brief"}, "embedded\n\nbe brief"},
+		{"replace and append", Override{System: "custom\n\n", Append: "be brief"}, "custom\n\nbe brief"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.override.Apply("embedded\n"); got != tt.want {
+				t.Errorf("Apply() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewOverride(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "system.md")
+	if err := os.WriteFile(file, []byte("from file\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	empty := filepath.Join(dir, "empty.md")
+	if err := os.WriteFile(empty, []byte(" \n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	override, err := NewOverride("", file, "be brief")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if override != (Override{System: "from file\n", Append: "be brief"}) {
+		t.Errorf("NewOverride() = %+v", override)
+	}
+	if override, err := NewOverride("inline", "", ""); err != nil || override.System != "inline" {
+		t.Errorf("NewOverride() = %+v, %v", override, err)
+	}
+
+	if _, err := NewOverride("inline", file, ""); err == nil || !strings.Contains(err.Error(), "cannot be used together") {
+		t.Errorf("expected --system with --system-file to be rejected, got %v", err)
+	}
+	if _, err := NewOverride("", filepath.Join(dir, "missing.md"), ""); err == nil || !strings.Contains(err.Error(), "--system-file") {
+		t.Errorf("expected an error for a missing --system-file, got %v", err)
+	}
+	if _, err := NewOverride("", empty, ""); err == nil || !strings.Contains(err.Error(), "is empty") {
+		t.Errorf("expected an error for an empty --system-file, got %v", err)
+	}
+}
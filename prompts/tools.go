@@ -46,6 +46,18 @@ func GetToolDefinitions() []ToolDefinition {
 						Required:    true,
 						Description: "The bash command to execute",
 					},
+					{
+						Name:        "stdin",
+						Type:        "string",
+						Required:    false,
+						Description: "input sent to the command's stdin, for commands that prompt",
+					},
+					{
+						Name:        "cwd",
+						Type:        "string",
+						Required:    false,
+						Description: "the directory to run the command in, default the repository root",
+					},
 				},
 			},
 			ResultSchema: ToolResultSchema{
@@ -56,6 +68,12 @@ func GetToolDefinitions() []ToolDefinition {
 						Required:    true,
 						Description: "your command",
 					},
+					{
+						Name:        "NinaCwd",
+						Type:        "string",
+						Required:    true,
+						Description: "the directory the command ran in",
+					},
 					{
 						Name:        "NinaExit",
 						Type:        "int",
@@ -77,6 +95,168 @@ func GetToolDefinitions() []ToolDefinition {
 				},
 			},
 		},
+		{
+			Name:        "NinaRead",
+			Description: "read numbered lines of a file, optionally a range or only lines matching a pattern",
+			InputSchema: ToolInputSchema{
+				Fields: []ToolField{
+					{
+						Name:        "NinaPath",
+						Type:        "string",
+						Required:    true,
+						Description: "the absolute filepath to read (starts with `/` or `~/`)",
+					},
+					{
+						Name:        "NinaStartLine",
+						Type:        "int",
+						Required:    false,
+						Description: "the first line to read, default 1",
+					},
+					{
+						Name:        "NinaEndLine",
+						Type:        "int",
+						Required:    false,
+						Description: "the last line to read, default 200 lines from the start, or the end of the file with a pattern",
+					},
+					{
+						Name:        "NinaPattern",
+						Type:        "string",
+						Required:    false,
+						Description: "a regular expression, only lines matching it are returned",
+					},
+				},
+			},
+			ResultSchema: ToolResultSchema{
+				Fields: []ToolField{
+					{
+						Name:        "NinaRead",
+						Type:        "string",
+						Required:    true,
+						Description: "the filepath",
+					},
+					{
+						Name:        "NinaLines",
+						Type:        "string",
+						Required:    true,
+						Description: "the range read and the total line count, and the line to continue from when the content was cut short",
+					},
+					{
+						Name:        "NinaContent",
+						Type:        "string",
+						Required:    true,
+						Description: "the lines, each prefixed with its line number",
+					},
+					{
+						Name:        "NinaError",
+						Type:        "string",
+						Required:    false,
+						Description: "error if any",
+					},
+				},
+			},
+		},
+		{
+			Name:        "NinaGrep",
+			Description: "search files for lines matching a regular expression",
+			InputSchema: ToolInputSchema{
+				Fields: []ToolField{
+					{
+						Name:        "NinaPattern",
+						Type:        "string",
+						Required:    true,
+						Description: "a regular expression",
+					},
+					{
+						Name:        "NinaPath",
+						Type:        "string",
+						Required:    false,
+						Description: "the directory or file to search, default the repository root",
+					},
+					{
+						Name:        "NinaInclude",
+						Type:        "string",
+						Required:    false,
+						Description: "a glob the searched files must match, like `*.go`",
+					},
+				},
+			},
+			ResultSchema: ToolResultSchema{
+				Fields: []ToolField{
+					{
+						Name:        "NinaGrep",
+						Type:        "string",
+						Required:    true,
+						Description: "the pattern",
+					},
+					{
+						Name:        "NinaMatches",
+						Type:        "string",
+						Required:    true,
+						Description: "the number of matching lines and files, and whether only the first were shown",
+					},
+					{
+						Name:        "NinaContent",
+						Type:        "string",
+						Required:    true,
+						Description: "the matching lines sorted by file and line, as `path:line: text`",
+					},
+					{
+						Name:        "NinaError",
+						Type:        "string",
+						Required:    false,
+						Description: "error if any",
+					},
+				},
+			},
+		},
+		{
+			Name:        "NinaGlob",
+			Description: "list files matching a glob pattern",
+			InputSchema: ToolInputSchema{
+				Fields: []ToolField{
+					{
+						Name:        "NinaPattern",
+						Type:        "string",
+						Required:    true,
+						Description: "a glob, `*` matches within a path element and `**` across them, a pattern without `/` matches file names",
+					},
+					{
+						Name:        "NinaPath",
+						Type:        "string",
+						Required:    false,
+						Description: "the directory to list, default the repository root",
+					},
+				},
+			},
+			ResultSchema: ToolResultSchema{
+				Fields: []ToolField{
+					{
+						Name:        "NinaGlob",
+						Type:        "string",
+						Required:    true,
+						Description: "the pattern",
+					},
+					{
+						Name:        "NinaMatches",
+						Type:        "string",
+						Required:    true,
+						Description: "the number of files, and whether only the first were shown",
+					},
+					{
+						Name:        "NinaContent",
+						Type:        "string",
+						Required:    true,
+						Description: "the files, sorted, one per line",
+					},
+					{
+						Name:        "NinaError",
+						Type:        "string",
+						Required:    false,
+						Description: "error if any",
+					},
+				},
+			},
+		},
 		{
 			Name:        "NinaChange",
 			Description: "search/replace once in a single file",
@@ -119,6 +299,102 @@ func GetToolDefinitions() []ToolDefinition {
 				},
 			},
 		},
+		{
+			Name:        "NinaDelete",
+			Description: "delete a single file, uses git rm when the file is tracked",
+			InputSchema: ToolInputSchema{
+				Fields: []ToolField{
+					{
+						Name:        "NinaPath",
+						Type:        "string",
+						Required:    true,
+						Description: "the absolute filepath to delete (starts with `/` or `~/`)",
+					},
+				},
+			},
+			ResultSchema: ToolResultSchema{
+				Fields: []ToolField{
+					{
+						Name:        "NinaDelete",
+						Type:        "string",
+						Required:    true,
+						Description: "the filepath",
+					},
+					{
+						Name:        "NinaError",
+						Type:        "string",
+						Required:    false,
+						Description: "error if any",
+					},
+				},
+			},
+		},
+		{
+			Name:        "NinaRename",
+			Description: "rename or move a file or directory, uses git mv when the source is tracked",
+			InputSchema: ToolInputSchema{
+				Fields: []ToolField{
+					{
+						Name:        "NinaPath",
+						Type:        "string",
+						Required:    true,
+						Description: "the absolute filepath to rename (starts with `/` or `~/`)",
+					},
+					{
+						Name:        "NinaDest",
+						Type:        "string",
+						Required:    true,
+						Description: "the absolute destination filepath, must not exist",
+					},
+				},
+			},
+			ResultSchema: ToolResultSchema{
+				Fields: []ToolField{
+					{
+						Name:        "NinaRename",
+						Type:        "string",
+						Required:    true,
+						Description: "the source and destination filepaths",
+					},
+					{
+						Name:        "NinaError",
+						Type:        "string",
+						Required:    false,
+						Description: "error if any",
+					},
+				},
+			},
+		},
+		{
+			Name:        "NinaRemember",
+			Description: "remember a durable fact about this project for future sessions",
+			InputSchema: ToolInputSchema{
+				Fields: []ToolField{
+					{
+						Name:        "NinaContent",
+						Type:        "string",
+						Required:    true,
+						Description: "the fact to remember, one short self-contained statement",
+					},
+				},
+			},
+			ResultSchema: ToolResultSchema{
+				Fields: []ToolField{
+					{
+						Name:        "NinaRemember",
+						Type:        "string",
+						Required:    true,
+						Description: "the id of the memory",
+					},
+					{
+						Name:        "NinaError",
+						Type:        "string",
+						Required:    false,
+						Description: "error if any",
+					},
+				},
+			},
+		},
 	}
 }
 
@@ -0,0 +1,163 @@
+package providers
+
+// persists submitted batch ids to ~/.nina/batches.json, or $NINA_BATCHES_FILE,
+// so interrupted processes can resume polling, cancel, or fetch results later.
+// writers lock batches.json.lock, since several nina processes may submit at
+// once.
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nathants/nina/util"
+)
+
+// BatchRecord is a submitted provider batch
+type BatchRecord struct {
+	ID        string    `json:"id"`
+	Provider  string    `json:"provider"`
+	Requests  int       `json:"requests"`
+	Status    string    `json:"status"`
+	Command   string    `json:"command,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// batchesFilePath returns the path of the batch records, overridable with
+// NINA_BATCHES_FILE
+func batchesFilePath() string {
+	if path := os.Getenv("NINA_BATCHES_FILE"); path != "" {
+		return path
+	}
+	return filepath.Join(os.Getenv("HOME"), ".nina", "batches.json")
+}
+
+var batchesMu sync.Mutex
+
+// updateBatches applies update to the records and saves them, holding a lock
+// on the file so concurrent nina processes do not lose each other's records
+func updateBatches(update func(records map[string]BatchRecord) bool) error {
+	batchesMu.Lock()
+	defer batchesMu.Unlock()
+	path := batchesFilePath()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	unlock, err := lockFile(path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	records, err := loadBatches()
+	if err != nil {
+		return err
+	}
+	if !update(records) {
+		return nil
+	}
+	return saveBatches(records)
+}
+
+func loadBatches() (map[string]BatchRecord, error) {
+	data, err := os.ReadFile(batchesFilePath())
+	if os.IsNotExist(err) {
+		return map[string]BatchRecord{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	records := map[string]BatchRecord{}
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+func saveBatches(records map[string]BatchRecord) error {
+	path := batchesFilePath()
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// SaveBatch records a newly created batch
+func SaveBatch(provider, id, status string, requests int) error {
+	now := time.Now().UTC()
+	return updateBatches(func(records map[string]BatchRecord) bool {
+		records[id] = BatchRecord{
+			ID:        id,
+			Provider:  provider,
+			Requests:  requests,
+			Status:    status,
+			Command:   util.CurrentCommand,
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+		return true
+	})
+}
+
+// UpdateBatchStatus updates the status of a known batch, unknown ids are ignored
+func UpdateBatchStatus(id, status string) error {
+	return updateBatches(func(records map[string]BatchRecord) bool {
+		record, ok := records[id]
+		if !ok || record.Status == status {
+			return false
+		}
+		record.Status = status
+		record.UpdatedAt = time.Now().UTC()
+		records[id] = record
+		return true
+	})
+}
+
+// GetBatch returns the record for a batch id
+func GetBatch(id string) (BatchRecord, bool, error) {
+	batchesMu.Lock()
+	defer batchesMu.Unlock()
+	records, err := loadBatches()
+	if err != nil {
+		return BatchRecord{}, false, err
+	}
+	record, ok := records[id]
+	return record, ok, nil
+}
+
+// ListBatches returns all known batches, newest first
+func ListBatches() ([]BatchRecord, error) {
+	batchesMu.Lock()
+	defer batchesMu.Unlock()
+	records, err := loadBatches()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]BatchRecord, 0, len(records))
+	for _, r := range records {
+		result = append(result, r)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].CreatedAt.After(result[j].CreatedAt) })
+	return result, nil
+}
+
+// SleepContext waits for d or until ctx is done, returning ctx.Err() if cancelled
+func SleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
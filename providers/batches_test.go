@@ -0,0 +1,109 @@
+package providers
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/nathants/nina/util"
+)
+
+func TestBatchesFilePath(t *testing.T) {
+	t.Setenv("HOME", "/home/test")
+	t.Setenv("NINA_BATCHES_FILE", "")
+	if got := batchesFilePath(); got != "/home/test/.nina/batches.json" {
+		t.Errorf("batchesFilePath() = %s", got)
+	}
+	t.Setenv("NINA_BATCHES_FILE", "/tmp/batches.json")
+	if got := batchesFilePath(); got != "/tmp/batches.json" {
+		t.Errorf("batchesFilePath() = %s, want NINA_BATCHES_FILE", got)
+	}
+}
+
+func TestBatchRecords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nested", "batches.json")
+	t.Setenv("NINA_BATCHES_FILE", path)
+	orig := util.CurrentCommand
+	util.CurrentCommand = "ask"
+	t.Cleanup(func() { util.CurrentCommand = orig })
+
+	if records, err := ListBatches(); err != nil || len(records) != 0 {
+		t.Fatalf("ListBatches() = %v, %v, want none before any batch", records, err)
+	}
+	if err := SaveBatch("claude", "msgbatch_1", "in_progress", 3); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+	if err := SaveBatch("openai", "batch_2", "validating", 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("batches not persisted to NINA_BATCHES_FILE: %v", err)
+	}
+
+	// a later process resumes from the file and sees the status move on
+	if err := UpdateBatchStatus("msgbatch_1", "canceling"); err != nil {
+		t.Fatal(err)
+	}
+	if err := UpdateBatchStatus("unknown", "ended"); err != nil {
+		t.Fatal(err)
+	}
+	record, ok, err := GetBatch("msgbatch_1")
+	if err != nil || !ok {
+		t.Fatalf("GetBatch() = %v, %v", ok, err)
+	}
+	if record.Provider != "claude" || record.Requests != 3 || record.Command != "ask" || record.Status != "canceling" || !record.UpdatedAt.After(record.CreatedAt) {
+		t.Errorf("GetBatch() = %+v", record)
+	}
+	if _, ok, _ := GetBatch("unknown"); ok {
+		t.Error("UpdateBatchStatus() recorded an unknown batch")
+	}
+
+	records, err := ListBatches()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].ID != "batch_2" || records[1].ID != "msgbatch_1" {
+		t.Errorf("ListBatches() = %+v, want newest first", records)
+	}
+}
+
+func TestSaveBatchConcurrentProcesses(t *testing.T) {
+	if id := os.Getenv("NINA_TEST_SAVE_BATCHES"); id != "" {
+		for i := range 20 {
+			if err := SaveBatch("claude", fmt.Sprintf("%s-%d", id, i), "in_progress", 1); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return
+	}
+	if runtime.GOOS == "windows" {
+		t.Skip("batches are only locked across processes on unix")
+	}
+	t.Setenv("NINA_BATCHES_FILE", filepath.Join(t.TempDir(), "batches.json"))
+	var cmds []*exec.Cmd
+	for i := range 4 {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestSaveBatchConcurrentProcesses$")
+		cmd.Env = append(os.Environ(), fmt.Sprintf("NINA_TEST_SAVE_BATCHES=p%d", i))
+		if err := cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+		cmds = append(cmds, cmd)
+	}
+	for _, cmd := range cmds {
+		if err := cmd.Wait(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	records, err := ListBatches()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 80 {
+		t.Errorf("got %d records from 4 processes saving 20 each, want 80", len(records))
+	}
+}
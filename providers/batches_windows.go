@@ -0,0 +1,8 @@
+//go:build windows
+
+package providers
+
+// lockFile is not supported, writers are only serialized within a process
+func lockFile(string) (unlock func(), err error) {
+	return func() {}, nil
+}
@@ -0,0 +1,195 @@
+package providers
+
+// VCR style record and replay of provider http traffic for integration tests.
+// Set NINA_CASSETTE to a json file and NINA_CASSETTE_MODE to record to save
+// every request and response through the shared clients, or replay to serve
+// them back in order without network. Requests are matched by method and url
+// in the order they were recorded, bodies differ between runs (temp paths,
+// timestamps) so they are kept only to debug mismatches. Headers are never
+// recorded so cassettes hold no credentials.
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	CassetteRecord = "record"
+	CassetteReplay = "replay"
+)
+
+// Cassette is a recorded sequence of http interactions
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Interaction is one recorded request and response
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+// RecordedRequest is the part of a request used to match it on replay
+type RecordedRequest struct {
+	Method string `json:"method"`
+	URL    string `json:"url"`
+	Body   string `json:"body,omitempty"`
+}
+
+// RecordedResponse is a recorded response
+type RecordedResponse struct {
+	Status      int    `json:"status"`
+	ContentType string `json:"content_type,omitempty"`
+	Body        string `json:"body"`
+}
+
+// CassetteTransport records or replays http traffic to a cassette file
+type CassetteTransport struct {
+	Path string
+	Mode string
+	Next http.RoundTripper // used when recording
+
+	mu       sync.Mutex
+	cassette Cassette
+	used     []bool
+}
+
+// NewCassetteTransport opens a cassette, which must exist to replay it
+func NewCassetteTransport(path, mode string, next http.RoundTripper) (*CassetteTransport, error) {
+	t := &CassetteTransport{Path: path, Mode: mode, Next: next}
+	switch mode {
+	case CassetteRecord:
+	case CassetteReplay:
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &t.cassette); err != nil {
+			return nil, fmt.Errorf("invalid cassette %s: %w", path, err)
+		}
+		t.used = make([]bool, len(t.cassette.Interactions))
+	default:
+		return nil, fmt.Errorf("unknown cassette mode %q, use %s or %s", mode, CassetteRecord, CassetteReplay)
+	}
+	return t, nil
+}
+
+// RoundTrip records or replays a request
+func (t *CassetteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		_ = req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	recorded := RecordedRequest{Method: req.Method, URL: req.URL.String(), Body: string(body)}
+	if t.Mode == CassetteReplay {
+		return t.replay(req, recorded)
+	}
+	return t.record(req, recorded)
+}
+
+func (t *CassetteTransport) replay(req *http.Request, recorded RecordedRequest) (*http.Response, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, interaction := range t.cassette.Interactions {
+		if t.used[i] || interaction.Request.Method != recorded.Method || interaction.Request.URL != recorded.URL {
+			continue
+		}
+		t.used[i] = true
+		resp := &http.Response{
+			StatusCode:    interaction.Response.Status,
+			Status:        fmt.Sprintf("%d %s", interaction.Response.Status, http.StatusText(interaction.Response.Status)),
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        http.Header{},
+			Body:          io.NopCloser(bytes.NewReader([]byte(interaction.Response.Body))),
+			ContentLength: int64(len(interaction.Response.Body)),
+			Request:       req,
+		}
+		if interaction.Response.ContentType != "" {
+			resp.Header.Set("Content-Type", interaction.Response.ContentType)
+		}
+		return resp, nil
+	}
+	return nil, fmt.Errorf("cassette %s has no unplayed interaction for %s %s", t.Path, recorded.Method, recorded.URL)
+}
+
+func (t *CassetteTransport) record(req *http.Request, recorded RecordedRequest) (*http.Response, error) {
+	next := t.Next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	resp, err := next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.cassette.Interactions = append(t.cassette.Interactions, Interaction{
+		Request: recorded,
+		Response: RecordedResponse{
+			Status:      resp.StatusCode,
+			ContentType: resp.Header.Get("Content-Type"),
+			Body:        string(body),
+		},
+	})
+	// saved after every interaction so a killed run keeps what it recorded
+	if err := t.save(); err != nil {
+		return nil, fmt.Errorf("failed to save cassette: %w", err)
+	}
+	return resp, nil
+}
+
+func (t *CassetteTransport) save() error {
+	data, err := json.MarshalIndent(t.cassette, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(t.Path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(t.Path, data, 0644)
+}
+
+// cassetteFromEnv wraps next with the cassette named by NINA_CASSETTE, if any
+func cassetteFromEnv(next http.RoundTripper) http.RoundTripper {
+	path := os.Getenv("NINA_CASSETTE")
+	if path == "" {
+		return next
+	}
+	mode := os.Getenv("NINA_CASSETTE_MODE")
+	if mode == "" {
+		mode = CassetteReplay
+	}
+	t, err := NewCassetteTransport(path, mode, next)
+	if err != nil {
+		// fail every request so a broken cassette never reaches a real api
+		return failingTransport{fmt.Errorf("NINA_CASSETTE: %w", err)}
+	}
+	return t
+}
+
+type failingTransport struct{ err error }
+
+func (f failingTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, f.err
+}
@@ -0,0 +1,71 @@
+package providers
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCassetteRecordReplay(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"echo": %q, "call": %d}`, body, calls)
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "cassettes", "test.json")
+	post := func(rt http.RoundTripper, body string) string {
+		t.Helper()
+		req, _ := http.NewRequest("POST", server.URL+"/v1/messages", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		resp, err := (&http.Client{Transport: rt}).Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+		data, _ := io.ReadAll(resp.Body)
+		if resp.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("content type %q", resp.Header.Get("Content-Type"))
+		}
+		return string(data)
+	}
+
+	recorder, err := NewCassetteTransport(path, CassetteRecord, http.DefaultTransport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := post(recorder, "a")
+	second := post(recorder, "b")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "secret") {
+		t.Error("cassette must not record headers")
+	}
+
+	server.Close()
+	player, err := NewCassetteTransport(path, CassetteReplay, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// bodies differ between runs, so interactions replay in recorded order
+	if got := post(player, "changed"); got != first {
+		t.Errorf("got %s, want %s", got, first)
+	}
+	if got := post(player, "b"); got != second {
+		t.Errorf("got %s, want %s", got, second)
+	}
+	req, _ := http.NewRequest("POST", server.URL+"/v1/messages", strings.NewReader("c"))
+	if _, err := player.RoundTrip(req); err == nil {
+		t.Error("expected an error once the cassette is used up")
+	}
+}
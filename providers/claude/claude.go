@@ -14,9 +14,11 @@ import (
 	"sync"
 	"time"
 
+	"github.com/nathants/nina/memory"
 	"github.com/nathants/nina/prompts"
 	"github.com/nathants/nina/providers"
 	util "github.com/nathants/nina/util"
+	"github.com/nathants/nina/workspace"
 )
 
 func init() {
@@ -37,10 +39,22 @@ type CacheControl struct {
 	Type string `json:"type"`
 }
 
+// Text is a content block of a message, text, or with native tool calling a
+// tool_use from the assistant or the tool_result answering it. The thinking
+// of a turn with tool calls is sent back as thinking and redacted_thinking
+// blocks before them.
 type Text struct {
-	Type  string        `json:"type"`
-	Text  string        `json:"text"`
-	Cache *CacheControl `json:"cache_control,omitempty"`
+	Type      string        `json:"type"`
+	Text      string        `json:"text,omitempty"`
+	ID        string        `json:"id,omitempty"`          // tool_use
+	Name      string        `json:"name,omitempty"`        // tool_use
+	Input     any           `json:"input,omitempty"`       // tool_use
+	ToolUseID string        `json:"tool_use_id,omitempty"` // tool_result
+	Content   string        `json:"content,omitempty"`     // tool_result
+	Thinking  string        `json:"thinking,omitempty"`    // thinking
+	Signature string        `json:"signature,omitempty"`   // thinking
+	Data      string        `json:"data,omitempty"`        // redacted_thinking
+	Cache     *CacheControl `json:"cache_control,omitempty"`
 }
 
 // Message represents a single chat message for the Anthropic API.
@@ -55,18 +69,28 @@ type Thinking struct {
 }
 
 type Request struct {
-	Model     string    `json:"model"`
-	System    []Text    `json:"system"`
-	Messages  []Message `json:"messages"`
-	MaxTokens int       `json:"max_tokens,omitempty"`
-	Thinking  *Thinking `json:"thinking,omitempty"`
-	Stream    bool      `json:"stream,omitempty"`
+	Model       string    `json:"model"`
+	System      []Text    `json:"system"`
+	Messages    []Message `json:"messages"`
+	MaxTokens   int       `json:"max_tokens,omitempty"`
+	Thinking    *Thinking `json:"thinking,omitempty"`
+	Temperature *float64  `json:"temperature,omitempty"`
+	TopP        *float64  `json:"top_p,omitempty"`
+	Stream      bool      `json:"stream,omitempty"`
+	Tools       []Tool    `json:"tools,omitempty"`
+	Betas       []string  `json:"-"` // anthropic-beta flags, e.g. context-1m-2025-08-07
 }
 
 // ContentBlock is a single "content" element in the response.
 type ContentBlock struct {
-	Type string `json:"type"`
-	Text string `json:"text,omitempty"`
+	Type      string         `json:"type"`
+	Text      string         `json:"text,omitempty"`
+	ID        string         `json:"id,omitempty"`        // tool_use
+	Name      string         `json:"name,omitempty"`      // tool_use
+	Input     map[string]any `json:"input,omitempty"`     // tool_use
+	Thinking  string         `json:"thinking,omitempty"`  // thinking
+	Signature string         `json:"signature,omitempty"` // thinking
+	Data      string         `json:"data,omitempty"`      // redacted_thinking
 }
 
 // Usage represents token usage information from the Claude API
@@ -79,26 +103,34 @@ type Usage struct {
 
 // Response is the top level response type returned by Anthropic.
 type Response struct {
-	Content []ContentBlock `json:"content"`
-	Usage   Usage          `json:"usage"`
+	Content    []ContentBlock `json:"content"`
+	Usage      Usage          `json:"usage"`
+	StopReason string         `json:"stop_reason,omitempty"`
+	Model      string         `json:"model,omitempty"`
 }
 
 // HandleResponse holds the response data from the Handle function including
 // the response text, usage statistics, and message ID for conversation state.
 type HandleResponse struct {
-	Text      string
-	Usage     Usage
-	MessageID string
+	Text       string
+	Usage      Usage
+	MessageID  string
+	StopReason string // end_turn, max_tokens, refusal, tool_use, ...
+	Model      string // the model version that answered
+	ToolUses   []ToolUse
+	Thinking   []Text // thinking and redacted_thinking blocks, sent back with tool results
 }
 
 // Batch API types
 type BatchParams struct {
-	Model     string    `json:"model"`
-	System    string    `json:"system,omitempty"`
-	Messages  []Message `json:"messages"`
-	MaxTokens int       `json:"max_tokens,omitempty"`
-	Thinking  *Thinking `json:"thinking,omitempty"`
-	UseOAuth  bool      `json:"-"` // Not part of API request, just for auth selection
+	Model       string    `json:"model"`
+	System      string    `json:"system,omitempty"`
+	Messages    []Message `json:"messages"`
+	MaxTokens   int       `json:"max_tokens,omitempty"`
+	Thinking    *Thinking `json:"thinking,omitempty"`
+	Temperature *float64  `json:"temperature,omitempty"`
+	TopP        *float64  `json:"top_p,omitempty"`
+	UseOAuth    bool      `json:"-"` // Not part of API request, just for auth selection
 }
 
 type BatchRequestItem struct {
@@ -185,6 +217,18 @@ func GetClaudeVersion() string {
 
 var logOnce bool
 
+// addBetas adds beta flags to the anthropic-beta header of req, keeping those
+// already set
+func addBetas(req *http.Request, betas []string) {
+	if len(betas) == 0 {
+		return
+	}
+	if existing := req.Header.Get("anthropic-beta"); existing != "" {
+		betas = append([]string{existing}, betas...)
+	}
+	req.Header.Set("anthropic-beta", strings.Join(betas, ","))
+}
+
 // setupClaudeAuth configures authentication headers for Claude API requests
 func setupClaudeAuth(req *http.Request, useOAuth bool) {
 	if useOAuth {
@@ -196,7 +240,7 @@ func setupClaudeAuth(req *http.Request, useOAuth bool) {
 			req.Header.Set("User-Agent", fmt.Sprintf("claude-cli/%s (external, cli)", GetClaudeVersion()))
 			if !logOnce {
 				logOnce = true
-				_, _ = fmt.Fprintln(os.Stderr, "Using OAuth authentication for Claude API")
+				util.Infof("Using OAuth authentication for Claude API")
 			}
 		} else {
 
@@ -209,7 +253,7 @@ func setupClaudeAuth(req *http.Request, useOAuth bool) {
 			req.Header.Set("x-api-key", apiKey)
 			if !logOnce {
 				logOnce = true
-				_, _ = fmt.Fprintf(os.Stderr, "OAuth requested but token not found, falling back to API key authentication\n")
+				util.Errorf("OAuth requested but token not found, falling back to API key authentication")
 			}
 		}
 	} else {
@@ -222,7 +266,7 @@ func setupClaudeAuth(req *http.Request, useOAuth bool) {
 		req.Header.Set("x-api-key", apiKey)
 		if !logOnce {
 			logOnce = true
-			_, _ = fmt.Fprintln(os.Stderr, "Using API key authentication for Claude API")
+			util.Infof("Using API key authentication for Claude API")
 		}
 	}
 }
@@ -266,6 +310,7 @@ func Handle(ctx context.Context, req Request, reasoningCallback func(data string
 
 	outReq.Header.Set("Content-Type", "application/json")
 	setupClaudeAuth(outReq, useOAuth)
+	addBetas(outReq, req.Betas)
 	outReq.Header.Set("anthropic-version", "2023-06-01")
 	if req.Stream {
 		outReq.Header.Set("Accept", "text/event-stream")
@@ -273,6 +318,12 @@ func Handle(ctx context.Context, req Request, reasoningCallback func(data string
 
 	// fmt.Println("headers:", lib.Pformat(outReq.Header))
 
+	release, err := providers.AcquireRateLimit(ctx, "claude", body)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
 	client := providers.LongTimeoutClient
 	resp, err := client.Do(outReq)
 	if err != nil {
@@ -281,8 +332,7 @@ func Handle(ctx context.Context, req Request, reasoningCallback func(data string
 	defer func() { _ = resp.Body.Close() }()
 
 	if resp.StatusCode != http.StatusOK {
-		resBody, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("api error: %s", string(resBody))
+		return nil, providers.NewStatusError(resp)
 	}
 
 	if !req.Stream {
@@ -310,10 +360,20 @@ func Handle(ctx context.Context, req Request, reasoningCallback func(data string
 		if err != nil {
 			panic(err)
 		}
-		_, _ = fmt.Fprintln(os.Stderr, string(data))
+		util.Verbosef("%s", data)
 
 		var builder strings.Builder
+		var toolUses []ToolUse
+		var thinking []Text
 		for i, blk := range cr.Content {
+			if blk.Type == "tool_use" {
+				toolUses = append(toolUses, ToolUse{Type: blk.Type, ID: blk.ID, Name: blk.Name, Input: blk.Input})
+				continue
+			}
+			if blk.Type == "thinking" || blk.Type == "redacted_thinking" {
+				thinking = append(thinking, Text{Type: blk.Type, Thinking: blk.Thinking, Signature: blk.Signature, Data: blk.Data})
+				continue
+			}
 			if blk.Type != "text" {
 				continue
 			}
@@ -328,9 +388,13 @@ func Handle(ctx context.Context, req Request, reasoningCallback func(data string
 		var messageID string
 
 		return &HandleResponse{
-			Text:      builder.String(),
-			Usage:     cr.Usage,
-			MessageID: messageID,
+			Text:       builder.String(),
+			Usage:      cr.Usage,
+			MessageID:  messageID,
+			StopReason: cr.StopReason,
+			Model:      cr.Model,
+			ToolUses:   toolUses,
+			Thinking:   thinking,
 		}, nil
 	}
 
@@ -341,7 +405,16 @@ func Handle(ctx context.Context, req Request, reasoningCallback func(data string
 	var eventData strings.Builder
 	var currentContentIndex int
 	var contentBlockTypes = map[int]string{}
+	// tool_use blocks by index, their input streamed as partial json
+	var toolUses = map[int]*ToolUse{}
+	var toolInputs = map[int]*strings.Builder{}
+	var toolOrder []int
+	// thinking blocks by index, with their signatures
+	var thinkingBlocks = map[int]*Text{}
+	var thinkingOrder []int
 	var messageID string
+	var stopReason string
+	var model string
 	var usage Usage
 
 	for {
@@ -359,7 +432,7 @@ func Handle(ctx context.Context, req Request, reasoningCallback func(data string
 			if ctx.Err() != nil {
 				return nil, ctx.Err()
 			}
-			return nil, fmt.Errorf("stream read error: %w", err)
+			return nil, &providers.StreamError{Err: fmt.Errorf("stream read error: %w", err)}
 		}
 
 		line = strings.TrimSpace(line)
@@ -385,7 +458,7 @@ func Handle(ctx context.Context, req Request, reasoningCallback func(data string
 
 			var event map[string]any
 			if err := json.Unmarshal([]byte(eventJSON), &event); err != nil {
-				_, _ = fmt.Fprintf(os.Stderr, "unmarshal event error: %v\n", err)
+				util.Errorf("unmarshal event error: %v", err)
 				continue
 			}
 
@@ -398,6 +471,7 @@ func Handle(ctx context.Context, req Request, reasoningCallback func(data string
 					if id, ok := message["id"].(string); ok {
 						messageID = id
 					}
+					model, _ = message["model"].(string)
 					// Extract usage information from message_start
 					if usageData, ok := message["usage"].(map[string]any); ok {
 						if v, ok := usageData["input_tokens"].(float64); ok {
@@ -421,6 +495,18 @@ func Handle(ctx context.Context, req Request, reasoningCallback func(data string
 				if ok {
 					blockType, _ := contentBlock["type"].(string)
 					contentBlockTypes[int(index)] = blockType
+					if blockType == "tool_use" {
+						id, _ := contentBlock["id"].(string)
+						name, _ := contentBlock["name"].(string)
+						toolUses[int(index)] = &ToolUse{Type: blockType, ID: id, Name: name}
+						toolInputs[int(index)] = &strings.Builder{}
+						toolOrder = append(toolOrder, int(index))
+					}
+					if blockType == "thinking" || blockType == "redacted_thinking" {
+						data, _ := contentBlock["data"].(string)
+						thinkingBlocks[int(index)] = &Text{Type: blockType, Data: data}
+						thinkingOrder = append(thinkingOrder, int(index))
+					}
 				}
 
 			case "content_block_delta":
@@ -435,16 +521,29 @@ func Handle(ctx context.Context, req Request, reasoningCallback func(data string
 				switch blockType {
 				case "thinking":
 					deltaType, _ := delta["type"].(string)
-					if deltaType == "thinking_delta" {
+					switch deltaType {
+					case "thinking_delta":
 						thinking, _ := delta["thinking"].(string)
 						thinkingBuilder.WriteString(thinking)
+						thinkingBlocks[int(index)].Thinking += thinking
+					case "signature_delta":
+						signature, _ := delta["signature"].(string)
+						thinkingBlocks[int(index)].Signature += signature
 					}
+				case "redacted_thinking":
+					// the encrypted data comes whole in content_block_start
 				case "text":
 					deltaType, _ := delta["type"].(string)
 					if deltaType == "text_delta" {
 						text, _ := delta["text"].(string)
 						answerBuilder.WriteString(text)
 					}
+				case "tool_use":
+					deltaType, _ := delta["type"].(string)
+					if deltaType == "input_json_delta" {
+						partial, _ := delta["partial_json"].(string)
+						toolInputs[int(index)].WriteString(partial)
+					}
 				default:
 					panic("unknown block type: " + blockType)
 				}
@@ -457,9 +556,22 @@ func Handle(ctx context.Context, req Request, reasoningCallback func(data string
 					}
 					thinkingBuilder.Reset()
 				}
+				if use := toolUses[int(index)]; use != nil {
+					use.Input = map[string]any{}
+					if input := toolInputs[int(index)].String(); input != "" {
+						if err := json.Unmarshal([]byte(input), &use.Input); err != nil {
+							return nil, fmt.Errorf("invalid input for tool %s: %w", use.Name, err)
+						}
+					}
+				}
 				currentContentIndex++
 
 			case "message_delta":
+				if delta, ok := event["delta"].(map[string]any); ok {
+					if reason, ok := delta["stop_reason"].(string); ok {
+						stopReason = reason
+					}
+				}
 				// Extract usage information from message_delta event (at top level, not in delta)
 				if usageData, ok := event["usage"].(map[string]any); ok {
 					if v, ok := usageData["output_tokens"].(float64); ok {
@@ -479,7 +591,7 @@ func Handle(ctx context.Context, req Request, reasoningCallback func(data string
 
 			case "message_stop":
 			case "error":
-				return nil, fmt.Errorf("api stream error: %s", util.Pformat(event))
+				return nil, &providers.StreamError{Err: fmt.Errorf("api stream error: %s", util.Pformat(event))}
 
 			case "ping":
 				// Ping event, ignore
@@ -490,90 +602,108 @@ func Handle(ctx context.Context, req Request, reasoningCallback func(data string
 		}
 	}
 
+	var uses []ToolUse
+	for _, index := range toolOrder {
+		uses = append(uses, *toolUses[index])
+	}
+	var thinking []Text
+	for _, index := range thinkingOrder {
+		thinking = append(thinking, *thinkingBlocks[index])
+	}
+
 	return &HandleResponse{
-		Text:      answerBuilder.String(),
-		Usage:     usage,
-		MessageID: messageID,
+		Text:       answerBuilder.String(),
+		Usage:      usage,
+		MessageID:  messageID,
+		StopReason: stopReason,
+		Model:      model,
+		ToolUses:   uses,
+		Thinking:   thinking,
 	}, nil
 }
 
 // HandleBatch sends multiple requests to Anthropic using the batch API
-// and returns the results after polling for completion.
+// and returns the results after polling for completion. The batch id is
+// persisted so an interrupted process can resume with WaitBatch.
 func HandleBatch(ctx context.Context, requests []BatchRequestItem) ([]BatchIndividualResult, error) {
-
-	// Create batch
-	createReq := BatchCreateRequest{Requests: requests}
-	body, err := json.Marshal(createReq)
+	batchResp, err := CreateBatch(ctx, requests)
 	if err != nil {
-		return nil, fmt.Errorf("json marshal error: %v", err)
+		return nil, err
 	}
-
-	outReq, err := http.NewRequestWithContext(
-		ctx,
-		"POST",
-		"https://api.anthropic.com/v1/messages/batches",
-		bytes.NewBuffer(body),
-	)
+	batchResp, err = WaitBatch(ctx, batchResp.ID)
 	if err != nil {
-		return nil, fmt.Errorf("request creation error: %v", err)
+		return nil, err
 	}
+	return FetchBatchResults(ctx, batchResp)
+}
 
-	outReq.Header.Set("Content-Type", "application/json")
-	// Determine if any request uses OAuth
-	useOAuth := false
+// CreateBatch submits requests as a new batch and records its id on disk
+func CreateBatch(ctx context.Context, requests []BatchRequestItem) (*BatchResponse, error) {
 	for _, req := range requests {
 		if req.Params.UseOAuth {
-			useOAuth = true
-			break
+			return nil, fmt.Errorf("oauth not supported for batch")
 		}
 	}
 
-	if useOAuth {
-		return nil, fmt.Errorf("oauth not supported for batch")
+	createReq := BatchCreateRequest{Requests: requests}
+	body, err := json.Marshal(createReq)
+	if err != nil {
+		return nil, fmt.Errorf("json marshal error: %v", err)
 	}
 
-	setupClaudeAuth(outReq, useOAuth)
-	outReq.Header.Set("anthropic-version", "2023-06-01")
-
-	client := providers.LongTimeoutClient
-	resp, err := client.Do(outReq)
+	var batchResp BatchResponse
+	err = doBatchRequest(ctx, "POST", "https://api.anthropic.com/v1/messages/batches", bytes.NewBuffer(body), &batchResp)
 	if err != nil {
-		return nil, fmt.Errorf("do request error: %v", err)
+		return nil, err
 	}
-	defer func() { _ = resp.Body.Close() }()
 
-	if resp.StatusCode != http.StatusOK {
-		resBody, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("api error: %s", string(resBody))
+	fmt.Printf("Created batch %s with %d requests\n", batchResp.ID, len(requests))
+	if err := providers.SaveBatch("claude", batchResp.ID, batchResp.ProcessingStatus, len(requests)); err != nil {
+		util.Errorf("warning: failed to save batch %s: %v", batchResp.ID, err)
 	}
+	return &batchResp, nil
+}
 
-	resBody, err := io.ReadAll(resp.Body)
+// GetBatch returns the current state of a batch
+func GetBatch(ctx context.Context, id string) (*BatchResponse, error) {
+	var batchResp BatchResponse
+	err := doBatchRequest(ctx, "GET", fmt.Sprintf("https://api.anthropic.com/v1/messages/batches/%s", id), nil, &batchResp)
 	if err != nil {
-		return nil, fmt.Errorf("error reading response: %v", err)
+		return nil, err
 	}
+	_ = providers.UpdateBatchStatus(id, batchResp.ProcessingStatus)
+	return &batchResp, nil
+}
 
+// CancelBatch requests cancellation of a batch, requests already processed
+// still produce results
+func CancelBatch(ctx context.Context, id string) (*BatchResponse, error) {
 	var batchResp BatchResponse
-	err = json.Unmarshal(resBody, &batchResp)
+	err := doBatchRequest(ctx, "POST", fmt.Sprintf("https://api.anthropic.com/v1/messages/batches/%s/cancel", id), nil, &batchResp)
 	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal json: %v", err)
+		return nil, err
 	}
+	_ = providers.UpdateBatchStatus(id, batchResp.ProcessingStatus)
+	return &batchResp, nil
+}
 
+// WaitBatch polls a batch until it has ended or ctx is cancelled
+func WaitBatch(ctx context.Context, id string) (*BatchResponse, error) {
 	batchStartTime := time.Now()
-	fmt.Printf("Created batch %s with %d requests\n", batchResp.ID, len(requests))
-
-	// Poll for completion
 	for {
-		select {
-		case <-ctx.Done():
-			return nil, ctx.Err()
-		default:
+		batchResp, err := GetBatch(ctx, id)
+		if err != nil {
+			return nil, err
 		}
 
+		elapsed := time.Since(batchStartTime).Seconds()
 		if batchResp.ProcessingStatus == "ended" {
-			break
+			fmt.Printf("Batch %s completed. Final counts - succeeded: %d, errored: %d, canceled: %d, expired: %d %.1f seconds\n",
+				batchResp.ID, batchResp.RequestCounts.Succeeded, batchResp.RequestCounts.Errored,
+				batchResp.RequestCounts.Canceled, batchResp.RequestCounts.Expired, elapsed)
+			return batchResp, nil
 		}
 
-		elapsed := time.Since(batchStartTime).Seconds()
 		fmt.Printf("Batch %s status: %s (succeeded: %d, errored: %d, processing: %d) %.1f seconds\n",
 			batchResp.ID, batchResp.ProcessingStatus,
 			batchResp.RequestCounts.Succeeded,
@@ -582,51 +712,14 @@ func HandleBatch(ctx context.Context, requests []BatchRequestItem) ([]BatchIndiv
 			elapsed)
 
 		// Wait before polling again
-		time.Sleep(5 * time.Second)
-
-		// Get batch status
-		statusReq, err := http.NewRequestWithContext(
-			ctx,
-			"GET",
-			fmt.Sprintf("https://api.anthropic.com/v1/messages/batches/%s", batchResp.ID),
-			nil,
-		)
-		if err != nil {
-			return nil, fmt.Errorf("status request creation error: %v", err)
-		}
-
-		setupClaudeAuth(statusReq, useOAuth)
-		statusReq.Header.Set("anthropic-version", "2023-06-01")
-
-		statusResp, err := client.Do(statusReq)
-		if err != nil {
-			return nil, fmt.Errorf("status request error: %v", err)
-		}
-
-		if statusResp.StatusCode != http.StatusOK {
-			statusResBody, _ := io.ReadAll(statusResp.Body)
-			_ = statusResp.Body.Close()
-			return nil, fmt.Errorf("status api error: %s", string(statusResBody))
-		}
-
-		statusResBody, err := io.ReadAll(statusResp.Body)
-		_ = statusResp.Body.Close()
-		if err != nil {
-			return nil, fmt.Errorf("error reading status response: %v", err)
-		}
-
-		err = json.Unmarshal(statusResBody, &batchResp)
-		if err != nil {
-			return nil, fmt.Errorf("failed to unmarshal status json: %v", err)
+		if err := providers.SleepContext(ctx, 5*time.Second); err != nil {
+			return nil, err
 		}
 	}
+}
 
-	elapsed := time.Since(batchStartTime).Seconds()
-	fmt.Printf("Batch %s completed. Final counts - succeeded: %d, errored: %d, canceled: %d, expired: %d %.1f seconds\n",
-		batchResp.ID, batchResp.RequestCounts.Succeeded, batchResp.RequestCounts.Errored,
-		batchResp.RequestCounts.Canceled, batchResp.RequestCounts.Expired, elapsed)
-
-	// Get results
+// FetchBatchResults downloads and parses the results of an ended batch
+func FetchBatchResults(ctx context.Context, batchResp *BatchResponse) ([]BatchIndividualResult, error) {
 	if batchResp.ResultsURL == nil {
 		return nil, fmt.Errorf("no results URL available")
 	}
@@ -641,10 +734,10 @@ func HandleBatch(ctx context.Context, requests []BatchRequestItem) ([]BatchIndiv
 		return nil, fmt.Errorf("results request creation error: %v", err)
 	}
 
-	setupClaudeAuth(resultsReq, useOAuth)
+	setupClaudeAuth(resultsReq, false)
 	resultsReq.Header.Set("anthropic-version", "2023-06-01")
 
-	resultsResp, err := client.Do(resultsReq)
+	resultsResp, err := providers.LongTimeoutClient.Do(resultsReq)
 	if err != nil {
 		return nil, fmt.Errorf("results request error: %v", err)
 	}
@@ -682,6 +775,35 @@ func HandleBatch(ctx context.Context, requests []BatchRequestItem) ([]BatchIndiv
 	return results, nil
 }
 
+// doBatchRequest performs an authenticated batch api call and decodes the json response
+func doBatchRequest(ctx context.Context, method, url string, body io.Reader, out any) error {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return fmt.Errorf("request creation error: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	setupClaudeAuth(req, false)
+	req.Header.Set("anthropic-version", "2023-06-01")
+
+	resp, err := providers.LongTimeoutClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("do request error: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	resBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("error reading response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("api error: %s", string(resBody))
+	}
+	if err := json.Unmarshal(resBody, out); err != nil {
+		return fmt.Errorf("failed to unmarshal json: %v", err)
+	}
+	return nil
+}
+
 // Tool represents a tool definition for Claude's tool use feature
 type Tool struct {
 	Name        string         `json:"name"`
@@ -753,6 +875,24 @@ func TranslateToClaudeTools(tools []prompts.ToolDefinition) []map[string]any {
 		if tool.Name == "NinaChange" {
 			claudeName = "change_file"
 		}
+		if tool.Name == "NinaDelete" {
+			claudeName = "delete_file"
+		}
+		if tool.Name == "NinaRename" {
+			claudeName = "rename_file"
+		}
+		if tool.Name == "NinaRemember" {
+			claudeName = "remember"
+		}
+		if tool.Name == "NinaRead" {
+			claudeName = "read_file"
+		}
+		if tool.Name == "NinaGrep" {
+			claudeName = "grep"
+		}
+		if tool.Name == "NinaGlob" {
+			claudeName = "glob"
+		}
 
 		// Build properties for input schema
 		properties := make(map[string]any)
@@ -763,8 +903,8 @@ func TranslateToClaudeTools(tools []prompts.ToolDefinition) []map[string]any {
 			// Map field names for Claude
 			if tool.Name == "NinaBash" && field.Name == "command" {
 				// Keep as "command" for execute_bash
-			} else if tool.Name == "NinaChange" {
-				// Map NinaPath -> path, NinaSearch -> search, NinaReplace -> replace
+			} else {
+				// Map NinaPath -> path, NinaSearch -> search, NinaReplace -> replace, NinaDest -> dest
 				switch field.Name {
 				case "NinaPath":
 					fieldName = "path"
@@ -772,11 +912,27 @@ func TranslateToClaudeTools(tools []prompts.ToolDefinition) []map[string]any {
 					fieldName = "search"
 				case "NinaReplace":
 					fieldName = "replace"
+				case "NinaDest":
+					fieldName = "dest"
+				case "NinaContent":
+					fieldName = "content"
+				case "NinaStartLine":
+					fieldName = "start_line"
+				case "NinaEndLine":
+					fieldName = "end_line"
+				case "NinaPattern":
+					fieldName = "pattern"
+				case "NinaInclude":
+					fieldName = "include"
 				}
 			}
 
+			fieldType := field.Type
+			if fieldType == "int" {
+				fieldType = "integer"
+			}
 			properties[fieldName] = map[string]any{
-				"type":        field.Type,
+				"type":        fieldType,
 				"description": field.Description,
 			}
 
@@ -954,6 +1110,12 @@ func sendToolRequestRaw(ctx context.Context, reqBody map[string]any, debug bool)
 	outReq.Header.Set("anthropic-beta", "tools-2024-04-04")
 	outReq.Header.Set("Content-Type", "application/json")
 
+	release, err := providers.AcquireRateLimit(ctx, "claude", body)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
 	client := providers.LongTimeoutClient
 	resp, err := client.Do(outReq)
 	if err != nil {
@@ -971,7 +1133,7 @@ func sendToolRequestRaw(ctx context.Context, reqBody map[string]any, debug bool)
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("API error: %s - %s", resp.Status, string(respBody))
+		return nil, &providers.StatusError{StatusCode: resp.StatusCode, Body: string(respBody)}
 	}
 
 	var response ResponseWithTools
@@ -993,23 +1155,14 @@ func executeToolCall(toolCall ToolUse) (string, error) {
 		}
 
 		// Execute bash command as defined in XML.md: bash -c "$cmd"
-		cmd := exec.Command("bash", "-c", command)
-		output, err := cmd.CombinedOutput()
+		bashCmd := util.BashCommand{Command: command}
+		bashCmd.Stdin, _ = toolCall.Input["stdin"].(string)
+		bashCmd.Cwd, _ = toolCall.Input["cwd"].(string)
+		result := util.ExecuteBash(bashCmd)
 
 		// Format result to match NinaResult structure from XML.md
-		exitCode := 0
-		if err != nil {
-			if exitErr, ok := err.(*exec.ExitError); ok {
-				exitCode = exitErr.ExitCode()
-			} else {
-				exitCode = 1
-			}
-		}
-
-		// Split output into stdout/stderr (combined output doesn't separate them)
-		// For now, put all in stdout as we're using CombinedOutput
-		return fmt.Sprintf("NinaCmd: %s\nNinaExit: %d\nNinaStdout: %s\nNinaStderr: ",
-			command, exitCode, string(output)), nil
+		return fmt.Sprintf("NinaCmd: %s\nNinaCwd: %s\nNinaExit: %d\nNinaStdout: %s\nNinaStderr: %s",
+			command, result.Cwd, result.ExitCode, result.Stdout, result.Stderr), nil
 
 	case "change_file":
 		// Map from Claude field names back to Nina field names
@@ -1026,8 +1179,17 @@ func executeToolCall(toolCall ToolUse) (string, error) {
 			return "", fmt.Errorf("invalid replace parameter")
 		}
 
+		// Refuse paths outside the allowed roots, and binary or oversized files
+		if err := util.CheckPathAllowed(path); err != nil {
+			return fmt.Sprintf("NinaChange: %s\nNinaError: %v", path, err), nil
+		}
+		if err := util.CheckFile(path); err != nil {
+			return fmt.Sprintf("NinaChange: %s\nNinaError: %v", path, err), nil
+		}
+
 		// Read file
-		content, err := os.ReadFile(path)
+		ws := workspace.Current()
+		content, err := ws.ReadFile(path)
 		if err != nil {
 			return fmt.Sprintf("NinaChange: %s\nNinaError: %v", path, err), nil
 		}
@@ -1042,14 +1204,133 @@ func executeToolCall(toolCall ToolUse) (string, error) {
 		newContent := strings.Replace(fileStr, search, replace, 1)
 
 		// Write back
-		err = os.WriteFile(path, []byte(newContent), 0644)
+		err = ws.WriteFile(path, []byte(newContent), 0644)
 		if err != nil {
 			return fmt.Sprintf("NinaChange: %s\nNinaError: %v", path, err), nil
 		}
 
 		return fmt.Sprintf("NinaChange: %s", path), nil
 
+	case "delete_file":
+		path, ok := toolCall.Input["path"].(string)
+		if !ok {
+			return "", fmt.Errorf("invalid path parameter")
+		}
+		result := util.ExecuteDelete(path)
+		if result.Error != "" {
+			return fmt.Sprintf("NinaDelete: %s\nNinaError: %s", path, result.Error), nil
+		}
+		return fmt.Sprintf("NinaDelete: %s", path), nil
+
+	case "rename_file":
+		path, ok := toolCall.Input["path"].(string)
+		if !ok {
+			return "", fmt.Errorf("invalid path parameter")
+		}
+		dest, ok := toolCall.Input["dest"].(string)
+		if !ok {
+			return "", fmt.Errorf("invalid dest parameter")
+		}
+		result := util.ExecuteRename(path, dest)
+		if result.Error != "" {
+			return fmt.Sprintf("NinaRename: %s -> %s\nNinaError: %s", path, dest, result.Error), nil
+		}
+		return fmt.Sprintf("NinaRename: %s -> %s", path, dest), nil
+
+	case "read_file":
+		path, ok := toolCall.Input["path"].(string)
+		if !ok {
+			return "", fmt.Errorf("invalid path parameter")
+		}
+		req := util.ReadRequest{Path: path}
+		// json numbers decode as float64
+		if start, ok := toolCall.Input["start_line"].(float64); ok {
+			req.Start = int(start)
+		}
+		if end, ok := toolCall.Input["end_line"].(float64); ok {
+			req.End = int(end)
+		}
+		req.Pattern, _ = toolCall.Input["pattern"].(string)
+		result := util.ExecuteRead(req)
+		if result.Error != "" {
+			return fmt.Sprintf("NinaRead: %s\nNinaError: %s", result.Path, result.Error), nil
+		}
+		return fmt.Sprintf("NinaRead: %s\nNinaLines: %s\nNinaContent:\n%s", result.Path, result.Summary(), result.Content), nil
+
+	case "grep", "glob":
+		pattern, ok := toolCall.Input["pattern"].(string)
+		if !ok {
+			return "", fmt.Errorf("invalid pattern parameter")
+		}
+		req := util.SearchRequest{Pattern: pattern}
+		req.Path, _ = toolCall.Input["path"].(string)
+		req.Include, _ = toolCall.Input["include"].(string)
+		name, unit, result := "NinaGrep", "match", util.SearchResult{}
+		if toolCall.Name == "grep" {
+			result = util.ExecuteGrep(req)
+		} else {
+			name, unit, result = "NinaGlob", "file", util.ExecuteGlob(req)
+		}
+		if result.Error != "" {
+			return fmt.Sprintf("%s: %s\nNinaError: %s", name, pattern, result.Error), nil
+		}
+		return fmt.Sprintf("%s: %s\nNinaMatches: %s\nNinaContent:\n%s", name, pattern, result.Summary(unit), result.Content()), nil
+
+	case "remember":
+		content, ok := toolCall.Input["content"].(string)
+		if !ok {
+			return "", fmt.Errorf("invalid content parameter")
+		}
+		m, err := memory.Remember(content)
+		if err != nil {
+			return fmt.Sprintf("NinaRemember:\nNinaError: %v", err), nil
+		}
+		return fmt.Sprintf("NinaRemember: %s", m.ID), nil
+
 	default:
 		return "", fmt.Errorf("unknown tool: %s", toolCall.Name)
 	}
 }
+
+// CountTokens returns the input tokens the messages api would bill for a
+// system prompt and a single user message, without running the model
+func CountTokens(ctx context.Context, model, system, text string) (int, error) {
+	reqBody := map[string]any{
+		"model":    model,
+		"messages": []Message{{Role: "user", Content: []Text{{Type: "text", Text: text}}}},
+	}
+	if system != "" {
+		reqBody["system"] = []Text{{Type: "text", Text: system}}
+	}
+	body, err := json.Marshal(reqBody)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal json: %v", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", "https://api.anthropic.com/v1/messages/count_tokens", bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("request creation error: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	setupClaudeAuth(req, os.Getenv("ANTHROPIC_OAUTH_TOKEN") != "")
+	req.Header.Set("anthropic-version", "2023-06-01")
+
+	resp, err := providers.ShortTimeoutClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("do request error: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	resBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, fmt.Errorf("error reading response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("api error: %s", string(resBody))
+	}
+	var out struct {
+		InputTokens int `json:"input_tokens"`
+	}
+	if err := json.Unmarshal(resBody, &out); err != nil {
+		return 0, fmt.Errorf("failed to unmarshal json: %v", err)
+	}
+	return out.InputTokens, nil
+}
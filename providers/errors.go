@@ -0,0 +1,48 @@
+package providers
+
+// typed provider errors, so callers decide what to retry from the http
+// status or the kind of failure instead of matching error text
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// StatusError is an api response with an error status
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+// NewStatusError reads the body of an error response
+func NewStatusError(resp *http.Response) *StatusError {
+	body, _ := io.ReadAll(resp.Body)
+	return &StatusError{StatusCode: resp.StatusCode, Body: string(body)}
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("api error (status %d): %s", e.StatusCode, e.Body)
+}
+
+// Transient reports whether the status is worth retrying: timeouts, rate
+// limits, and server errors, including 529 for an overloaded anthropic api
+func (e *StatusError) Transient() bool {
+	switch e.StatusCode {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests,
+		http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout, 529:
+		return true
+	}
+	return false
+}
+
+// StreamError is a streamed response that failed after the request was
+// accepted: the connection dropped, an event could not be parsed, or the
+// provider sent an error event. These are worth retrying.
+type StreamError struct {
+	Err error
+}
+
+func (e *StreamError) Error() string { return e.Err.Error() }
+
+func (e *StreamError) Unwrap() error { return e.Err }
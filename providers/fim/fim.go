@@ -0,0 +1,189 @@
+// fim requests fill-in-the-middle code completions: given the text before and
+// after a cursor, return the text to insert. Mistral's codestral and deepseek
+// have native fim endpoints, openai chat models are prompted to fill a marked
+// hole. Calls are single shot and unstreamed, callers bound latency with the
+// context deadline.
+package fim
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	providers "github.com/nathants/nina/providers"
+)
+
+func init() {
+	providers.InitAllHTTPClients()
+}
+
+// Request is one completion at a cursor
+type Request struct {
+	Model       string // provider api model id
+	Prefix      string
+	Suffix      string
+	MaxTokens   int
+	Temperature float64
+	Stop        []string
+}
+
+// Usage is the token usage of a completion
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	CachedTokens     int `json:"prompt_cache_hit_tokens"` // deepseek only
+}
+
+// Response is the text to insert at the cursor
+type Response struct {
+	Text  string
+	Usage Usage
+}
+
+// Hole marks the cursor in the openai prompt
+const Hole = "<|fim_hole|>"
+
+type nativeRequest struct {
+	Model       string   `json:"model"`
+	Prompt      string   `json:"prompt"`
+	Suffix      string   `json:"suffix,omitempty"`
+	MaxTokens   int      `json:"max_tokens,omitempty"`
+	Temperature float64  `json:"temperature"`
+	Stop        []string `json:"stop,omitempty"`
+}
+
+type completionResponse struct {
+	Choices []struct {
+		Text    string `json:"text"`
+		Message struct {
+			Content string `json:"content"`
+		} `json:"message"`
+	} `json:"choices"`
+	Usage Usage `json:"usage"`
+}
+
+// Mistral completes with codestral, using CODESTRAL_API_KEY against the
+// codestral endpoint when set and MISTRAL_API_KEY otherwise
+func Mistral(ctx context.Context, req Request) (*Response, error) {
+	url, key := "https://api.mistral.ai/v1/fim/completions", os.Getenv("MISTRAL_API_KEY")
+	if codestral := os.Getenv("CODESTRAL_API_KEY"); codestral != "" {
+		url, key = "https://codestral.mistral.ai/v1/fim/completions", codestral
+	}
+	return post(ctx, "mistral", url, key, nativeRequest{
+		Model:       req.Model,
+		Prompt:      req.Prefix,
+		Suffix:      req.Suffix,
+		MaxTokens:   req.MaxTokens,
+		Temperature: req.Temperature,
+		Stop:        req.Stop,
+	})
+}
+
+// DeepSeek completes with deepseek's beta fim endpoint using DEEPSEEK_API_KEY
+func DeepSeek(ctx context.Context, req Request) (*Response, error) {
+	return post(ctx, "deepseek", "https://api.deepseek.com/beta/completions", os.Getenv("DEEPSEEK_API_KEY"), nativeRequest{
+		Model:       req.Model,
+		Prompt:      req.Prefix,
+		Suffix:      req.Suffix,
+		MaxTokens:   req.MaxTokens,
+		Temperature: req.Temperature,
+		Stop:        req.Stop,
+	})
+}
+
+const openAIInstructions = `You are a code completion engine. The user sends a file with the cursor marked ` + Hole + `.
+Reply with only the text to insert at the cursor, no explanation and no markdown fences.
+The insertion must fit exactly between the text before and after the cursor, do not repeat either.
+Prefer completing the current statement or block over writing large amounts of code.`
+
+// OpenAI completes with an openai chat model using OPENAI_API_KEY
+func OpenAI(ctx context.Context, req Request) (*Response, error) {
+	type message struct {
+		Role    string `json:"role"`
+		Content string `json:"content"`
+	}
+	resp, err := post(ctx, "openai", "https://api.openai.com/v1/chat/completions", os.Getenv("OPENAI_API_KEY"), struct {
+		Model       string    `json:"model"`
+		Messages    []message `json:"messages"`
+		MaxTokens   int       `json:"max_completion_tokens,omitempty"`
+		Temperature float64   `json:"temperature"`
+		Stop        []string  `json:"stop,omitempty"`
+	}{
+		Model: req.Model,
+		Messages: []message{
+			{Role: "system", Content: openAIInstructions},
+			{Role: "user", Content: req.Prefix + Hole + req.Suffix},
+		},
+		MaxTokens:   req.MaxTokens,
+		Temperature: req.Temperature,
+		Stop:        req.Stop,
+	})
+	if err != nil {
+		return nil, err
+	}
+	resp.Text = stripFence(resp.Text)
+	return resp, nil
+}
+
+func post(ctx context.Context, name, url, key string, payload any) (*Response, error) {
+	if key == "" {
+		return nil, fmt.Errorf("%s: missing api key", name)
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("%s: marshal request: %w", name, err)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("%s: create request: %w", name, err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", "Bearer "+key)
+
+	release, err := providers.AcquireRateLimit(ctx, name, body)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	resp, err := providers.ShortTimeoutClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %w", name, providers.NewStatusError(resp))
+	}
+
+	var out completionResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("%s: decode response: %w", name, err)
+	}
+	if len(out.Choices) == 0 {
+		return nil, fmt.Errorf("%s: no choices in response", name)
+	}
+	text := out.Choices[0].Text
+	if text == "" {
+		text = out.Choices[0].Message.Content // mistral fim replies in chat format
+	}
+	return &Response{Text: text, Usage: out.Usage}, nil
+}
+
+// stripFence removes a markdown fence chat models wrap code in despite
+// being told not to
+func stripFence(text string) string {
+	trimmed := strings.TrimSpace(text)
+	if !strings.HasPrefix(trimmed, "```") || !strings.HasSuffix(trimmed, "```") || len(trimmed) < 6 {
+		return text
+	}
+	trimmed = strings.TrimSuffix(trimmed, "```")
+	_, body, found := strings.Cut(trimmed, "\n")
+	if !found {
+		return text
+	}
+	return strings.TrimSuffix(body, "\n")
+}
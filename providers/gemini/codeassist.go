@@ -43,12 +43,15 @@ type vertexGenerateContentRequest struct {
 	Contents          []*genai.Content        `json:"contents"`
 	SystemInstruction *genai.Content          `json:"systemInstruction,omitempty"`
 	GenerationConfig  *vertexGenerationConfig `json:"generationConfig,omitempty"`
+	Tools             []*genai.Tool           `json:"tools,omitempty"`
 }
 
 // vertexGenerationConfig represents generation configuration
 type vertexGenerationConfig struct {
-	Temperature    *float32        `json:"temperature,omitempty"`
-	ThinkingConfig *thinkingConfig `json:"thinkingConfig,omitempty"`
+	Temperature     *float32        `json:"temperature,omitempty"`
+	TopP            *float32        `json:"topP,omitempty"`
+	MaxOutputTokens int32           `json:"maxOutputTokens,omitempty"`
+	ThinkingConfig  *thinkingConfig `json:"thinkingConfig,omitempty"`
 }
 
 // thinkingConfig represents thinking configuration
@@ -66,6 +69,7 @@ type generateContentResponse struct {
 type vertexGenerateContentResponse struct {
 	Candidates    []candidate    `json:"candidates"`
 	UsageMetadata *usageMetadata `json:"usageMetadata,omitempty"`
+	ModelVersion  string         `json:"modelVersion,omitempty"`
 }
 
 // candidate represents a response candidate
@@ -76,9 +80,11 @@ type candidate struct {
 
 // usageMetadata represents token usage information
 type usageMetadata struct {
-	PromptTokenCount     int `json:"promptTokenCount"`
-	CandidatesTokenCount int `json:"candidatesTokenCount"`
-	TotalTokenCount      int `json:"totalTokenCount"`
+	PromptTokenCount        int `json:"promptTokenCount"`
+	CandidatesTokenCount    int `json:"candidatesTokenCount"`
+	CachedContentTokenCount int `json:"cachedContentTokenCount,omitempty"`
+	ThoughtsTokenCount      int `json:"thoughtsTokenCount,omitempty"`
+	TotalTokenCount         int `json:"totalTokenCount"`
 }
 
 // generateContentStream makes a streaming request to the Code Assist API
@@ -88,8 +94,11 @@ func (c *codeAssistClient) generateContentStream(ctx context.Context, model stri
 		Contents:          contents,
 		SystemInstruction: cfg.SystemInstruction,
 		GenerationConfig: &vertexGenerationConfig{
-			Temperature: cfg.Temperature,
+			Temperature:     cfg.Temperature,
+			TopP:            cfg.TopP,
+			MaxOutputTokens: cfg.MaxOutputTokens,
 		},
+		Tools: cfg.Tools,
 	}
 
 	if cfg.ThinkingConfig != nil {
@@ -122,9 +131,9 @@ func (c *codeAssistClient) generateContentStream(ctx context.Context, model stri
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
+		statusErr := providers.NewStatusError(resp)
 		_ = resp.Body.Close()
-		return nil, fmt.Errorf("code assist api error: status %d: %s", resp.StatusCode, string(body))
+		return nil, fmt.Errorf("code assist: %w", statusErr)
 	}
 
 	return &codeAssistStreamIterator{
@@ -193,7 +202,7 @@ func (it *codeAssistStreamIterator) Next() (*genai.GenerateContentResponse, erro
 	}
 
 	// Convert to genai.GenerateContentResponse
-	genaiResp := &genai.GenerateContentResponse{}
+	genaiResp := &genai.GenerateContentResponse{ModelVersion: caResp.Response.ModelVersion}
 	for _, cand := range caResp.Response.Candidates {
 		genaiCand := &genai.Candidate{
 			Content:      cand.Content,
@@ -204,9 +213,11 @@ func (it *codeAssistStreamIterator) Next() (*genai.GenerateContentResponse, erro
 
 	if caResp.Response.UsageMetadata != nil {
 		genaiResp.UsageMetadata = &genai.GenerateContentResponseUsageMetadata{
-			PromptTokenCount:     int32(caResp.Response.UsageMetadata.PromptTokenCount),
-			CandidatesTokenCount: int32(caResp.Response.UsageMetadata.CandidatesTokenCount),
-			TotalTokenCount:      int32(caResp.Response.UsageMetadata.TotalTokenCount),
+			PromptTokenCount:        int32(caResp.Response.UsageMetadata.PromptTokenCount),
+			CandidatesTokenCount:    int32(caResp.Response.UsageMetadata.CandidatesTokenCount),
+			CachedContentTokenCount: int32(caResp.Response.UsageMetadata.CachedContentTokenCount),
+			ThoughtsTokenCount:      int32(caResp.Response.UsageMetadata.ThoughtsTokenCount),
+			TotalTokenCount:         int32(caResp.Response.UsageMetadata.TotalTokenCount),
 		}
 	}
 
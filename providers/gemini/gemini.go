@@ -3,6 +3,7 @@ package gemini
 import (
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"mime"
@@ -13,8 +14,10 @@ import (
 	"sync"
 
 	"google.golang.org/genai"
+	"github.com/nathants/nina/prompts"
 	providers "github.com/nathants/nina/providers"
 	oauth "github.com/nathants/nina/providers/oauth"
+	util "github.com/nathants/nina/util"
 )
 
 func init() {
@@ -58,8 +61,9 @@ func getClient(ctx context.Context) (*genai.Client, error) {
 		}
 
 		cfg := &genai.ClientConfig{
-			APIKey:  apiKey,
-			Backend: genai.BackendGeminiAPI,
+			APIKey:     apiKey,
+			Backend:    genai.BackendGeminiAPI,
+			HTTPClient: providers.LongTimeoutClient,
 		}
 		cli, cliErr = genai.NewClient(ctx, cfg)
 	})
@@ -111,225 +115,369 @@ func fileToBlob(path string) (*genai.Part, error) {
 	return genai.NewPartFromBytes(data, mimeType), nil
 }
 
-type ChatMessage struct {
-	Role    genai.Role `json:"role"`
-	Content string     `json:"content"`
+// Content is one turn of the conversation, role is "user" or "model"
+type Content struct {
+	Role  string `json:"role"`
+	Parts []Part `json:"parts"`
 }
 
+// Part is a piece of a turn, exactly one field is set
+type Part struct {
+	Text             string            `json:"text,omitempty"`
+	FunctionCall     *FunctionCall     `json:"functionCall,omitempty"`
+	FunctionResponse *FunctionResponse `json:"functionResponse,omitempty"`
+}
+
+// FunctionCall is a tool invocation requested by the model
+type FunctionCall struct {
+	ID   string         `json:"id,omitempty"`
+	Name string         `json:"name"`
+	Args map[string]any `json:"args,omitempty"`
+}
+
+// FunctionResponse answers a FunctionCall with the same id and name
+type FunctionResponse struct {
+	ID       string         `json:"id,omitempty"`
+	Name     string         `json:"name"`
+	Response map[string]any `json:"response"`
+}
+
+// FunctionDeclaration describes a tool the model may call, parameters are a
+// json schema object
+type FunctionDeclaration struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	Parameters  map[string]any `json:"parameters,omitempty"`
+}
+
+// Request is a generateContent call, images are urls, data urls, or paths
+type Request struct {
+	Model          string
+	System         string
+	Contents       []Content
+	ImageURLs      []string
+	Tools          []FunctionDeclaration
+	Temperature    *float64
+	TopP           *float64
+	MaxOutput      int
+	ThinkingBudget int
+	NoThoughts     bool
+}
+
+// Usage is the usageMetadata of the final stream chunk
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CandidatesTokens int `json:"candidates_tokens"`
+	CachedTokens     int `json:"cached_tokens,omitempty"`
+	ThoughtsTokens   int `json:"thoughts_tokens,omitempty"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// HandleResponse holds the answer text, any function calls, and usage
+type HandleResponse struct {
+	Text          string
+	FunctionCalls []FunctionCall
+	FinishReason  string
+	ModelVersion  string // the model version that answered
+	Usage         Usage
+}
+
+// UserText returns a user turn holding a single text part
+func UserText(text string) Content {
+	return Content{Role: RoleUser, Parts: []Part{{Text: text}}}
+}
+
+const (
+	RoleUser  = string(genai.RoleUser)
+	RoleModel = string(genai.RoleModel)
+)
+
 var logModelOnce sync.Once
 
-func Handle(ctx context.Context, model string, system string, messages []string, imageUrls []string, reasoningCallback func(string), noThoughts bool, thinkingBudget int) (string, error) {
+// Handle streams a generateContent call, thoughts go to reasoningCallback and
+// are not part of the returned text
+func Handle(ctx context.Context, req Request, reasoningCallback func(string)) (*HandleResponse, error) {
 
 	logModelOnce.Do(func() {
 		thinking := ""
-		if thinkingBudget > 0 {
-			thinking = fmt.Sprintf("thinking=%d", thinkingBudget)
+		if req.ThinkingBudget > 0 {
+			thinking = fmt.Sprintf("thinking=%d", req.ThinkingBudget)
 		}
-		fmt.Fprintln(os.Stderr, "model="+model, thinking)
+		util.Infof("%s", strings.TrimSpace("model="+req.Model+" "+thinking))
 	})
 
+	var rateText strings.Builder
+	rateText.WriteString(req.System)
+	for _, c := range req.Contents {
+		for _, p := range c.Parts {
+			rateText.WriteString(p.Text)
+		}
+	}
+	release, err := providers.AcquireRateLimit(ctx, "gemini", []byte(rateText.String()))
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	contents := toGenaiContents(req.Contents)
+	for _, u := range req.ImageURLs {
+		part, err := imagePart(ctx, u)
+		if err != nil {
+			// fmt.Println("gemini: skip image:", err)
+			continue
+		}
+		contents = append(contents, genai.NewContentFromParts([]*genai.Part{part}, genai.RoleUser))
+	}
+	cfg := generateConfig(req)
+
 	// Check if we should use OAuth with Code Assist API
 	token, _ := oauth.GeminiAccess()
 	if token != "" {
 		// Prefer OAuth over API key
-		return handleWithCodeAssist(ctx, token, model, system, messages, imageUrls, reasoningCallback, noThoughts, thinkingBudget)
+		return handleWithCodeAssist(ctx, token, req.Model, contents, cfg, reasoningCallback)
 	}
 
 	client, err := getClient(ctx)
 	if err != nil && err.Error() != "oauth-mode" {
-		return "", err
-	}
-
-	contents := []*genai.Content{}
-	for _, msg := range messages {
-		contents = append(contents, genai.NewContentFromText(msg, genai.RoleUser))
-	}
-
-	for _, u := range imageUrls {
-		var part *genai.Part
-		var convErr error
-		if strings.HasPrefix(u, "http://") || strings.HasPrefix(u, "https://") {
-			part, convErr = inlineURL(ctx, u)
-		} else if strings.HasPrefix(u, "data:") {
-			comma := strings.Index(u, ",")
-			if comma == -1 {
-				convErr = fmt.Errorf("invalid data url")
-			} else {
-				meta := u[5:comma]
-				mimeType := meta
-				if semi := strings.Index(meta, ";"); semi != -1 {
-					mimeType = meta[:semi]
-				}
-				decoded, decErr := base64.StdEncoding.DecodeString(u[comma+1:])
-				if decErr != nil {
-					convErr = decErr
-				} else {
-					part = genai.NewPartFromBytes(decoded, mimeType)
-				}
-			}
-		} else {
-			part, convErr = fileToBlob(u)
-		}
-		if convErr != nil {
-			// fmt.Println("gemini: skip image:", convErr)
-			continue
-		}
-		contents = append(contents, genai.NewContentFromParts([]*genai.Part{part}, ""))
+		return nil, err
 	}
 
-	budget := int32(24000)
-	cfg := &genai.GenerateContentConfig{
-		SystemInstruction: genai.NewContentFromText(system, genai.RoleUser),
-		Temperature:       genai.Ptr(defaultTemperature),
-		ThinkingConfig: &genai.ThinkingConfig{
-			IncludeThoughts: !noThoughts,
-			ThinkingBudget:  &budget,
-		},
-	}
-	if thinkingBudget != 0 {
-		budget := int32(thinkingBudget)
-		cfg.ThinkingConfig.ThinkingBudget = &budget
+	var out streamCollector
+	for chunk, err := range client.Models.GenerateContentStream(ctx, req.Model, contents, cfg) {
+		var apiErr genai.APIError
+		if errors.As(err, &apiErr) {
+			return nil, &providers.StatusError{StatusCode: apiErr.Code, Body: apiErr.Error()}
+		}
+		if err != nil {
+			return nil, err
+		}
+		out.add(chunk, reasoningCallback)
 	}
+	return out.response(), nil
+}
 
-	// fmt.Println("gemini send")
+// handleWithCodeAssist handles requests using OAuth with the Code Assist API
+func handleWithCodeAssist(ctx context.Context, token, model string, contents []*genai.Content, cfg *genai.GenerateContentConfig, reasoningCallback func(string)) (*HandleResponse, error) {
+	client := newCodeAssistClient(token)
 
-	stream := client.Models.GenerateContentStream(ctx, model, contents, cfg)
+	// Try to load Code Assist (this may help with permissions)
+	if err := client.loadCodeAssist(ctx); err != nil {
+		// Log but don't fail - the actual request might still work
+		util.Errorf("Warning: loadCodeAssist failed: %v", err)
+	}
 
-	var answerBuilder strings.Builder
-	// var usage *genai.GenerateContentResponseUsageMetadata
+	stream, err := client.generateContentStream(ctx, model, contents, cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = stream.Close() }()
 
-	// start := time.Now()
-	for chunk, err := range stream {
+	var out streamCollector
+	for {
+		chunk, err := stream.Next()
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
-			return "", err
+			return nil, err
 		}
+		out.add(chunk, reasoningCallback)
+	}
+	return out.response(), nil
+}
 
-		if chunk == nil {
-			continue
-		}
+// streamCollector accumulates stream chunks into a HandleResponse
+type streamCollector struct {
+	text   strings.Builder
+	calls  []FunctionCall
+	finish string
+	model  string
+	usage  Usage
+}
 
-		if chunk.UsageMetadata != nil {
-			// usage = chunk.UsageMetadata
+func (s *streamCollector) add(chunk *genai.GenerateContentResponse, reasoningCallback func(string)) {
+	if chunk == nil {
+		return
+	}
+	if chunk.ModelVersion != "" {
+		s.model = chunk.ModelVersion
+	}
+	if u := chunk.UsageMetadata; u != nil {
+		s.usage = Usage{
+			PromptTokens:     int(u.PromptTokenCount),
+			CandidatesTokens: int(u.CandidatesTokenCount),
+			CachedTokens:     int(u.CachedContentTokenCount),
+			ThoughtsTokens:   int(u.ThoughtsTokenCount),
+			TotalTokens:      int(u.TotalTokenCount),
 		}
-
-		for _, cand := range chunk.Candidates {
-			if cand.Content == nil {
-				continue
-			}
-			for _, part := range cand.Content.Parts {
-				if part.Thought && reasoningCallback != nil {
+	}
+	for _, cand := range chunk.Candidates {
+		if cand.FinishReason != "" {
+			s.finish = string(cand.FinishReason)
+		}
+		if cand.Content == nil {
+			continue
+		}
+		for _, part := range cand.Content.Parts {
+			switch {
+			case part.FunctionCall != nil:
+				s.calls = append(s.calls, FunctionCall{ID: part.FunctionCall.ID, Name: part.FunctionCall.Name, Args: part.FunctionCall.Args})
+			case part.Thought:
+				if reasoningCallback != nil {
 					reasoningCallback(strings.TrimSpace(part.Text))
-				} else if part.Text != "" {
-					answerBuilder.WriteString(part.Text)
 				}
+			case part.Text != "":
+				s.text.WriteString(part.Text)
 			}
 		}
 	}
-	// fmt.Printf("Gemini finished in %.1fs\n", time.Since(start).Seconds())
-
-	// if usage != nil {
-	// 	fmt.Println("Gemini usage:", lib.Pformat(usage))
-	// }
-
-	return answerBuilder.String(), nil
 }
 
-// handleWithCodeAssist handles requests using OAuth with the Code Assist API
-func handleWithCodeAssist(ctx context.Context, token, model, system string, messages []string, imageUrls []string, reasoningCallback func(string), noThoughts bool, thinkingBudget int) (string, error) {
-	// Create Code Assist client
-	client := newCodeAssistClient(token)
+func (s *streamCollector) response() *HandleResponse {
+	return &HandleResponse{
+		Text:          s.text.String(),
+		FunctionCalls: s.calls,
+		FinishReason:  s.finish,
+		ModelVersion:  s.model,
+		Usage:         s.usage,
+	}
+}
 
-	// Try to load Code Assist (this may help with permissions)
-	if err := client.loadCodeAssist(ctx); err != nil {
-		// Log but don't fail - the actual request might still work
-		fmt.Fprintf(os.Stderr, "Warning: loadCodeAssist failed: %v\n", err)
-	}
-
-	// Build contents
-	contents := []*genai.Content{}
-	for _, msg := range messages {
-		contents = append(contents, genai.NewContentFromText(msg, genai.RoleUser))
-	}
-
-	// Handle images
-	for _, u := range imageUrls {
-		var part *genai.Part
-		var convErr error
-		if strings.HasPrefix(u, "http://") || strings.HasPrefix(u, "https://") {
-			part, convErr = inlineURL(ctx, u)
-		} else if strings.HasPrefix(u, "data:") {
-			comma := strings.Index(u, ",")
-			if comma == -1 {
-				convErr = fmt.Errorf("invalid data url")
-			} else {
-				meta := u[5:comma]
-				mimeType := meta
-				if semi := strings.Index(meta, ";"); semi != -1 {
-					mimeType = meta[:semi]
-				}
-				decoded, decErr := base64.StdEncoding.DecodeString(u[comma+1:])
-				if decErr != nil {
-					convErr = decErr
-				} else {
-					part = genai.NewPartFromBytes(decoded, mimeType)
-				}
+// toGenaiContents converts the typed history into genai contents
+func toGenaiContents(contents []Content) []*genai.Content {
+	out := make([]*genai.Content, 0, len(contents))
+	for _, c := range contents {
+		parts := make([]*genai.Part, 0, len(c.Parts))
+		for _, p := range c.Parts {
+			switch {
+			case p.FunctionCall != nil:
+				parts = append(parts, &genai.Part{FunctionCall: &genai.FunctionCall{ID: p.FunctionCall.ID, Name: p.FunctionCall.Name, Args: p.FunctionCall.Args}})
+			case p.FunctionResponse != nil:
+				parts = append(parts, &genai.Part{FunctionResponse: &genai.FunctionResponse{ID: p.FunctionResponse.ID, Name: p.FunctionResponse.Name, Response: p.FunctionResponse.Response}})
+			default:
+				parts = append(parts, genai.NewPartFromText(p.Text))
 			}
-		} else {
-			part, convErr = fileToBlob(u)
 		}
-		if convErr != nil {
-			continue
+		role := c.Role
+		if role == "" {
+			role = RoleUser
 		}
-		contents = append(contents, genai.NewContentFromParts([]*genai.Part{part}, ""))
+		out = append(out, genai.NewContentFromParts(parts, genai.Role(role)))
 	}
+	return out
+}
 
-	// Build configuration
+// generateConfig builds the generation settings, thinking defaults to a
+// 24000 token budget
+func generateConfig(req Request) *genai.GenerateContentConfig {
 	budget := int32(24000)
+	if req.ThinkingBudget != 0 {
+		budget = int32(req.ThinkingBudget)
+	}
 	cfg := &genai.GenerateContentConfig{
-		SystemInstruction: genai.NewContentFromText(system, genai.RoleUser),
-		Temperature:       genai.Ptr(defaultTemperature),
+		Temperature: genai.Ptr(defaultTemperature),
 		ThinkingConfig: &genai.ThinkingConfig{
-			IncludeThoughts: !noThoughts,
+			IncludeThoughts: !req.NoThoughts,
 			ThinkingBudget:  &budget,
 		},
 	}
-	if thinkingBudget != 0 {
-		budget := int32(thinkingBudget)
-		cfg.ThinkingConfig.ThinkingBudget = &budget
+	if req.System != "" {
+		cfg.SystemInstruction = genai.NewContentFromText(req.System, genai.RoleUser)
 	}
-
-	// Make streaming request
-	stream, err := client.generateContentStream(ctx, model, contents, cfg)
-	if err != nil {
-		return "", err
+	if req.Temperature != nil {
+		cfg.Temperature = genai.Ptr(float32(*req.Temperature))
 	}
-	defer func() { _ = stream.Close() }()
-
-	var answerBuilder strings.Builder
+	if req.TopP != nil {
+		cfg.TopP = genai.Ptr(float32(*req.TopP))
+	}
+	if req.MaxOutput > 0 {
+		cfg.MaxOutputTokens = int32(req.MaxOutput)
+	}
+	if len(req.Tools) > 0 {
+		decls := make([]*genai.FunctionDeclaration, 0, len(req.Tools))
+		for _, t := range req.Tools {
+			decls = append(decls, &genai.FunctionDeclaration{
+				Name:                 t.Name,
+				Description:          t.Description,
+				ParametersJsonSchema: t.Parameters,
+			})
+		}
+		cfg.Tools = []*genai.Tool{{FunctionDeclarations: decls}}
+	}
+	return cfg
+}
 
-	// Process stream
-	for {
-		chunk, err := stream.Next()
-		if err == io.EOF {
-			break
+// imagePart loads an image from a url, a data url, or a local path
+func imagePart(ctx context.Context, u string) (*genai.Part, error) {
+	if strings.HasPrefix(u, "http://") || strings.HasPrefix(u, "https://") {
+		return inlineURL(ctx, u)
+	}
+	if strings.HasPrefix(u, "data:") {
+		comma := strings.Index(u, ",")
+		if comma == -1 {
+			return nil, fmt.Errorf("invalid data url")
+		}
+		mimeType := u[5:comma]
+		if semi := strings.Index(mimeType, ";"); semi != -1 {
+			mimeType = mimeType[:semi]
 		}
+		decoded, err := base64.StdEncoding.DecodeString(u[comma+1:])
 		if err != nil {
-			return "", err
+			return nil, err
 		}
+		return genai.NewPartFromBytes(decoded, mimeType), nil
+	}
+	return fileToBlob(u)
+}
 
-		for _, cand := range chunk.Candidates {
-			if cand.Content == nil {
-				continue
+// TranslateToGeminiTools converts generic tool definitions into function
+// declarations, keeping the nina tool and field names
+func TranslateToGeminiTools(tools []prompts.ToolDefinition) []FunctionDeclaration {
+	decls := make([]FunctionDeclaration, 0, len(tools))
+	for _, tool := range tools {
+		properties := map[string]any{}
+		required := []string{}
+		for _, field := range tool.InputSchema.Fields {
+			fieldType := field.Type
+			if fieldType == "int" {
+				fieldType = "integer"
 			}
-			for _, part := range cand.Content.Parts {
-				if part.Thought && reasoningCallback != nil {
-					reasoningCallback(strings.TrimSpace(part.Text))
-				} else if part.Text != "" {
-					answerBuilder.WriteString(part.Text)
-				}
+			properties[field.Name] = map[string]any{
+				"type":        fieldType,
+				"description": field.Description,
+			}
+			if field.Required {
+				required = append(required, field.Name)
 			}
 		}
+		decls = append(decls, FunctionDeclaration{
+			Name:        tool.Name,
+			Description: tool.Description,
+			Parameters: map[string]any{
+				"type":       "object",
+				"properties": properties,
+				"required":   required,
+			},
+		})
 	}
+	return decls
+}
 
-	return answerBuilder.String(), nil
+// CountTokens returns the tokens gemini counts for a system prompt and a
+// single user message. The countTokens api needs an api key, it is not
+// available through oauth.
+func CountTokens(ctx context.Context, model, system, text string) (int, error) {
+	client, err := getClient(ctx)
+	if err != nil {
+		return 0, err
+	}
+	contents := []*genai.Content{genai.NewContentFromText(text, genai.RoleUser)}
+	if system != "" {
+		contents = append([]*genai.Content{genai.NewContentFromText(system, genai.RoleUser)}, contents...)
+	}
+	resp, err := client.Models.CountTokens(ctx, model, contents, nil)
+	if err != nil {
+		return 0, err
+	}
+	return int(resp.TotalTokens), nil
 }
@@ -1,9 +1,13 @@
 // grok.go provides integration with X.AI's Grok models via their chat completions API
 // supporting both regular messages and proper streaming with model grok-4-0709.
+// Streaming requests ask for usage in the final chunk, so both modes return it.
+// Reasoning models like grok-4 return their reasoning_content, passed to the
+// reasoning callback before the answer starts.
 
 package grok
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
@@ -12,6 +16,7 @@ import (
 	"net/http"
 	providers "github.com/nathants/nina/providers"
 	"os"
+	"strings"
 )
 
 func init() {
@@ -24,15 +29,24 @@ type Message struct {
 }
 
 type Request struct {
-	Model       string    `json:"model"`
-	Messages    []Message `json:"messages"`
-	Stream      bool      `json:"stream"`
-	Temperature float64   `json:"temperature"`
+	Model         string         `json:"model"`
+	Messages      []Message      `json:"messages"`
+	Stream        bool           `json:"stream"`
+	StreamOptions *StreamOptions `json:"stream_options,omitempty"`
+	Temperature   float64        `json:"temperature"`
+	TopP          *float64       `json:"top_p,omitempty"`
+	MaxTokens     *int           `json:"max_tokens,omitempty"`
+}
+
+// StreamOptions asks for usage in the final streamed chunk
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
 }
 
 type ChoiceMessage struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role             string `json:"role"`
+	Content          string `json:"content"`
+	ReasoningContent string `json:"reasoning_content,omitempty"`
 }
 
 type Choice struct {
@@ -41,19 +55,78 @@ type Choice struct {
 	FinishReason string        `json:"finish_reason"`
 }
 
+// Usage is the token usage of a request, prompt tokens include cached ones
+type Usage struct {
+	PromptTokens            int                      `json:"prompt_tokens"`
+	CompletionTokens        int                      `json:"completion_tokens"`
+	TotalTokens             int                      `json:"total_tokens"`
+	PromptTokensDetails     *PromptTokensDetails     `json:"prompt_tokens_details,omitempty"`
+	CompletionTokensDetails *CompletionTokensDetails `json:"completion_tokens_details,omitempty"`
+}
+
+type PromptTokensDetails struct {
+	CachedTokens int `json:"cached_tokens"`
+}
+
+type CompletionTokensDetails struct {
+	ReasoningTokens int `json:"reasoning_tokens"`
+}
+
+// CachedTokens returns the prompt tokens read from cache
+func (u *Usage) CachedTokens() int {
+	if u == nil || u.PromptTokensDetails == nil {
+		return 0
+	}
+	return u.PromptTokensDetails.CachedTokens
+}
+
 type Response struct {
 	ID      string   `json:"id"`
 	Object  string   `json:"object"`
 	Created int64    `json:"created"`
 	Model   string   `json:"model"`
 	Choices []Choice `json:"choices"`
-	Usage   any      `json:"usage"`
+	Usage   *Usage   `json:"usage"`
+}
+
+type StreamDelta struct {
+	Role             string `json:"role,omitempty"`
+	Content          string `json:"content,omitempty"`
+	ReasoningContent string `json:"reasoning_content,omitempty"`
 }
 
-func Handle(ctx context.Context, req Request) (string, error) {
+type StreamChoice struct {
+	Index        int         `json:"index"`
+	Delta        StreamDelta `json:"delta"`
+	FinishReason *string     `json:"finish_reason"`
+}
+
+// StreamResponse is one streamed chunk, usage is only set on the last
+type StreamResponse struct {
+	ID      string         `json:"id"`
+	Model   string         `json:"model"`
+	Choices []StreamChoice `json:"choices"`
+	Usage   *Usage         `json:"usage,omitempty"`
+}
+
+// HandleResponse holds the response data from Handle function.
+type HandleResponse struct {
+	Text         string
+	Reasoning    string
+	FinishReason string
+	Model        string // the model version that answered
+	Usage        *Usage
+}
+
+// Handle sends a chat completion, reasoning goes to reasoningCallback when
+// it is not nil
+func Handle(ctx context.Context, req Request, reasoningCallback func(data string)) (*HandleResponse, error) {
+	if req.Stream {
+		req.StreamOptions = &StreamOptions{IncludeUsage: true}
+	}
 	body, err := json.Marshal(req)
 	if err != nil {
-		return "", fmt.Errorf("grok: marshal request: %w", err)
+		return nil, fmt.Errorf("grok: marshal request: %w", err)
 	}
 
 	httpReq, err := http.NewRequestWithContext(
@@ -63,38 +136,117 @@ func Handle(ctx context.Context, req Request) (string, error) {
 		bytes.NewBuffer(body),
 	)
 	if err != nil {
-		return "", fmt.Errorf("grok: create request: %w", err)
+		return nil, fmt.Errorf("grok: create request: %w", err)
 	}
 
 	httpReq.Header.Set("Content-Type", "application/json")
+	if req.Stream {
+		httpReq.Header.Set("Accept", "text/event-stream")
+	}
 
 	apiKey := os.Getenv("XAI_API_KEY")
 	httpReq.Header.Set("Authorization", "Bearer "+apiKey)
 
+	release, err := providers.AcquireRateLimit(ctx, "grok", body)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
 	cli := providers.LongTimeoutClient
 	resp, err := cli.Do(httpReq)
 	if err != nil {
-		return "", fmt.Errorf("grok: do request: %w", err)
+		return nil, fmt.Errorf("grok: do request: %w", err)
 	}
 	defer func() { _ = resp.Body.Close() }()
 
-	rawBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("grok: read response: %w", err)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("grok: %w", providers.NewStatusError(resp))
+	}
+	if req.Stream {
+		return readStream(ctx, resp.Body, reasoningCallback)
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("grok: api error (status %d): %s", resp.StatusCode, string(rawBody))
+	rawBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("grok: read response: %w", err)
 	}
 
 	var grokResp Response
 	if err := json.Unmarshal(rawBody, &grokResp); err != nil {
-		return "", fmt.Errorf("grok: unmarshal response: %w", err)
+		return nil, fmt.Errorf("grok: unmarshal response: %w", err)
 	}
 
 	if len(grokResp.Choices) == 0 {
-		return "", fmt.Errorf("grok: no choices in response")
+		return nil, fmt.Errorf("grok: no choices in response")
 	}
 
-	return grokResp.Choices[0].Message.Content, nil
+	message := grokResp.Choices[0].Message
+	if message.ReasoningContent != "" && reasoningCallback != nil {
+		reasoningCallback(message.ReasoningContent)
+	}
+	return &HandleResponse{
+		Text:         message.Content,
+		Reasoning:    message.ReasoningContent,
+		FinishReason: grokResp.Choices[0].FinishReason,
+		Model:        grokResp.Model,
+		Usage:        grokResp.Usage,
+	}, nil
+}
+
+// readStream collects the text, reasoning, and final usage of a streamed
+// response. Reasoning not yet reported goes to reasoningCallback when the
+// answer starts and when the stream ends.
+func readStream(ctx context.Context, body io.Reader, reasoningCallback func(data string)) (*HandleResponse, error) {
+	var text, reasoning strings.Builder
+	reported := 0
+	report := func() {
+		if reasoning.Len() > reported && ctx.Err() == nil && reasoningCallback != nil {
+			reasoningCallback(reasoning.String()[reported:])
+		}
+		reported = reasoning.Len()
+	}
+	out := &HandleResponse{}
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		data := strings.TrimPrefix(line, "data: ")
+		if data == "[DONE]" {
+			break
+		}
+		var chunk StreamResponse
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return nil, &providers.StreamError{Err: fmt.Errorf("grok: unmarshal stream chunk: %w", err)}
+		}
+		for _, choice := range chunk.Choices {
+			reasoning.WriteString(choice.Delta.ReasoningContent)
+			if choice.Delta.Content != "" {
+				report()
+			}
+			text.WriteString(choice.Delta.Content)
+			if choice.FinishReason != nil {
+				out.FinishReason = *choice.FinishReason
+			}
+		}
+		if chunk.Model != "" {
+			out.Model = chunk.Model
+		}
+		if chunk.Usage != nil {
+			out.Usage = chunk.Usage
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, &providers.StreamError{Err: fmt.Errorf("grok: read stream: %w", err)}
+	}
+	report()
+	out.Text = text.String()
+	out.Reasoning = reasoning.String()
+	return out, nil
 }
@@ -0,0 +1,31 @@
+package grok
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestReadStreamReasoning(t *testing.T) {
+	stream := strings.Join([]string{
+		`data: {"choices": [{"delta": {"role": "assistant", "reasoning_content": "The user "}}]}`,
+		`data: {"choices": [{"delta": {"reasoning_content": "wants a greeting."}}]}`,
+		`data: {"choices": [{"delta": {"content": "Hel"}}]}`,
+		`data: {"choices": [{"delta": {"content": "lo"}, "finish_reason": "stop"}]}`,
+		`data: {"choices": [], "usage": {"prompt_tokens": 10, "completion_tokens": 7, "total_tokens": 17}}`,
+		`data: [DONE]`,
+	}, "\n\n")
+	var reported []string
+	resp, err := readStream(context.Background(), strings.NewReader(stream), func(data string) {
+		reported = append(reported, data)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Text != "Hello" || resp.Reasoning != "The user wants a greeting." || resp.FinishReason != "stop" || resp.Usage == nil || resp.Usage.TotalTokens != 17 {
+		t.Errorf("unexpected response %+v", resp)
+	}
+	if len(reported) != 1 || reported[0] != "The user wants a greeting." {
+		t.Errorf("unexpected reasoning callbacks %q", reported)
+	}
+}
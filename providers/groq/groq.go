@@ -1,5 +1,6 @@
 // Groq provider for fast LLM inference with OpenAI-compatible API including
-// support for reasoning models like moonshotai/kimi-k2-instruct.
+// support for reasoning models like moonshotai/kimi-k2-instruct, whose
+// reasoning is passed to the reasoning callback before the answer starts.
 package groq
 
 import (
@@ -48,6 +49,7 @@ type Request struct {
 	PresencePenalty  *float64  `json:"presence_penalty,omitempty"`
 	N                *int      `json:"n,omitempty"`
 	Stream           bool      `json:"stream"`
+	StreamOptions    *StreamOptions `json:"stream_options,omitempty"`
 	Stop             []string  `json:"stop,omitempty"`
 	Seed             *int      `json:"seed,omitempty"`
 	User             string    `json:"user,omitempty"`
@@ -56,23 +58,52 @@ type Request struct {
 	ReasoningEffort  string    `json:"reasoning_effort,omitempty"`
 }
 
+// StreamOptions asks for usage in the final streamed chunk.
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
 // Format represents response format options.
 type Format struct {
 	Type string `json:"type"` // "text" or "json_object"
 }
 
+// ChoiceMessage is the message of a response choice.
+type ChoiceMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+	// Reasoning is returned with reasoning_format parsed, ReasoningContent
+	// by models that name it so
+	Reasoning        string `json:"reasoning,omitempty"`
+	ReasoningContent string `json:"reasoning_content,omitempty"`
+}
+
 // Choice represents a single response choice.
 type Choice struct {
-	Index        int     `json:"index"`
-	Message      Message `json:"message"`
-	FinishReason string  `json:"finish_reason"`
+	Index        int           `json:"index"`
+	Message      ChoiceMessage `json:"message"`
+	FinishReason string        `json:"finish_reason"`
 }
 
-// Usage represents token usage statistics.
+// Usage represents token usage statistics, prompt tokens include cached ones.
 type Usage struct {
-	PromptTokens     int `json:"prompt_tokens"`
-	CompletionTokens int `json:"completion_tokens"`
-	TotalTokens      int `json:"total_tokens"`
+	PromptTokens        int                  `json:"prompt_tokens"`
+	CompletionTokens    int                  `json:"completion_tokens"`
+	TotalTokens         int                  `json:"total_tokens"`
+	PromptTokensDetails *PromptTokensDetails `json:"prompt_tokens_details,omitempty"`
+}
+
+// PromptTokensDetails reports prompt caching for models that support it.
+type PromptTokensDetails struct {
+	CachedTokens int `json:"cached_tokens"`
+}
+
+// CachedTokens returns the prompt tokens read from cache.
+func (u *Usage) CachedTokens() int {
+	if u == nil || u.PromptTokensDetails == nil {
+		return 0
+	}
+	return u.PromptTokensDetails.CachedTokens
 }
 
 // Response represents the API response.
@@ -94,17 +125,24 @@ type StreamChoice struct {
 
 // StreamDelta represents the delta content in streaming responses.
 type StreamDelta struct {
-	Role    string `json:"role,omitempty"`
-	Content string `json:"content,omitempty"`
+	Role             string `json:"role,omitempty"`
+	Content          string `json:"content,omitempty"`
+	Reasoning        string `json:"reasoning,omitempty"`
+	ReasoningContent string `json:"reasoning_content,omitempty"`
 }
 
-// StreamResponse represents a streaming API response chunk.
+// StreamResponse represents a streaming API response chunk. Usage comes in
+// the last chunk, as usage with stream_options or under x_groq.
 type StreamResponse struct {
 	ID      string         `json:"id"`
 	Object  string         `json:"object"`
 	Created int64          `json:"created"`
 	Model   string         `json:"model"`
 	Choices []StreamChoice `json:"choices"`
+	Usage   *Usage         `json:"usage,omitempty"`
+	XGroq   *struct {
+		Usage *Usage `json:"usage,omitempty"`
+	} `json:"x_groq,omitempty"`
 }
 
 // ErrorResponse represents API error response structure.
@@ -118,17 +156,24 @@ type ErrorResponse struct {
 
 // HandleResponse holds the response data from Handle function.
 type HandleResponse struct {
-	Text  string
-	Usage *Usage
+	Text         string
+	Reasoning    string
+	FinishReason string
+	Model        string // the model version that answered
+	Usage        *Usage
 }
 
-// Handle sends a request to Groq API and returns the response.
-func Handle(ctx context.Context, req Request) (*HandleResponse, error) {
+// Handle sends a request to Groq API and returns the response, reasoning goes
+// to reasoningCallback when it is not nil.
+func Handle(ctx context.Context, req Request, reasoningCallback func(data string)) (*HandleResponse, error) {
 
 	if req.Temperature == nil {
 		temp := 0.6
 		req.Temperature = &temp
 	}
+	if req.Stream {
+		req.StreamOptions = &StreamOptions{IncludeUsage: true}
+	}
 
 	authToken := getAuthToken()
 	if authToken == "" {
@@ -156,6 +201,12 @@ func Handle(ctx context.Context, req Request) (*HandleResponse, error) {
 		httpReq.Header.Set("Accept", "text/event-stream")
 	}
 
+	release, err := providers.AcquireRateLimit(ctx, "groq", body)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
 	client := providers.LongTimeoutClient
 	resp, err := client.Do(httpReq)
 	if err != nil {
@@ -166,15 +217,15 @@ func Handle(ctx context.Context, req Request) (*HandleResponse, error) {
 	if resp.StatusCode != http.StatusOK {
 		resBody, err := io.ReadAll(resp.Body)
 		if err != nil {
-			return nil, fmt.Errorf("API error: status %s, failed to read body: %w", resp.Status, err)
+			return nil, &providers.StatusError{StatusCode: resp.StatusCode, Body: fmt.Sprintf("failed to read body: %v", err)}
 		}
 
 		var errResp ErrorResponse
 		if err := json.Unmarshal(resBody, &errResp); err == nil && errResp.Error.Message != "" {
-			return nil, fmt.Errorf("API error: status %s, %s: %s", resp.Status, errResp.Error.Type, errResp.Error.Message)
+			return nil, &providers.StatusError{StatusCode: resp.StatusCode, Body: errResp.Error.Type + ": " + errResp.Error.Message}
 		}
 
-		return nil, fmt.Errorf("API error: status %s, body: %s", resp.Status, string(resBody))
+		return nil, &providers.StatusError{StatusCode: resp.StatusCode, Body: string(resBody)}
 	}
 
 	if !req.Stream {
@@ -197,15 +248,37 @@ func Handle(ctx context.Context, req Request) (*HandleResponse, error) {
 			return nil, fmt.Errorf("no choices in response")
 		}
 
+		message := response.Choices[0].Message
+		reasoning := message.Reasoning + message.ReasoningContent
+		if reasoning != "" && reasoningCallback != nil {
+			reasoningCallback(reasoning)
+		}
 		return &HandleResponse{
-			Text:  response.Choices[0].Message.Content,
-			Usage: &response.Usage,
+			Text:         message.Content,
+			Reasoning:    reasoning,
+			FinishReason: response.Choices[0].FinishReason,
+			Model:        response.Model,
+			Usage:        &response.Usage,
 		}, nil
 	}
 
-	// Handle streaming response
-	var textBuilder strings.Builder
-	reader := bufio.NewReader(resp.Body)
+	return readStream(ctx, resp.Body, reasoningCallback)
+}
+
+// readStream collects the text, reasoning, and final usage of a streamed
+// response. Reasoning not yet reported goes to reasoningCallback when the
+// answer starts and when the stream ends.
+func readStream(ctx context.Context, body io.Reader, reasoningCallback func(data string)) (*HandleResponse, error) {
+	out := &HandleResponse{}
+	var textBuilder, reasoning strings.Builder
+	reported := 0
+	report := func() {
+		if reasoning.Len() > reported && ctx.Err() == nil && reasoningCallback != nil {
+			reasoningCallback(reasoning.String()[reported:])
+		}
+		reported = reasoning.Len()
+	}
+	reader := bufio.NewReader(body)
 
 	for {
 		select {
@@ -222,7 +295,7 @@ func Handle(ctx context.Context, req Request) (*HandleResponse, error) {
 			if ctx.Err() != nil {
 				return nil, ctx.Err()
 			}
-			return nil, fmt.Errorf("stream read error: %w", err)
+			return nil, &providers.StreamError{Err: fmt.Errorf("stream read error: %w", err)}
 		}
 
 		line = strings.TrimSpace(line)
@@ -237,16 +310,32 @@ func Handle(ctx context.Context, req Request) (*HandleResponse, error) {
 
 		var streamResp StreamResponse
 		if err := json.Unmarshal([]byte(data), &streamResp); err != nil {
-			return nil, fmt.Errorf("unmarshal stream error: %w", err)
+			return nil, &providers.StreamError{Err: fmt.Errorf("unmarshal stream error: %w", err)}
 		}
 
-		if len(streamResp.Choices) > 0 && streamResp.Choices[0].Delta.Content != "" {
-			textBuilder.WriteString(streamResp.Choices[0].Delta.Content)
+		if len(streamResp.Choices) > 0 {
+			delta := streamResp.Choices[0].Delta
+			reasoning.WriteString(delta.Reasoning + delta.ReasoningContent)
+			if delta.Content != "" {
+				report()
+			}
+			textBuilder.WriteString(delta.Content)
+			if reason := streamResp.Choices[0].FinishReason; reason != nil {
+				out.FinishReason = *reason
+			}
+		}
+		if streamResp.Model != "" {
+			out.Model = streamResp.Model
+		}
+		if streamResp.Usage != nil {
+			out.Usage = streamResp.Usage
+		} else if streamResp.XGroq != nil && streamResp.XGroq.Usage != nil {
+			out.Usage = streamResp.XGroq.Usage
 		}
 	}
 
-	return &HandleResponse{
-		Text:  textBuilder.String(),
-		Usage: nil, // Groq doesn't provide usage in streaming mode
-	}, nil
+	report()
+	out.Text = textBuilder.String()
+	out.Reasoning = reasoning.String()
+	return out, nil
 }
@@ -0,0 +1,39 @@
+package groq
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestReadStreamReasoning(t *testing.T) {
+	stream := strings.Join([]string{
+		`data: {"choices": [{"delta": {"role": "assistant", "reasoning": "Checking the "}}]}`,
+		`data: {"choices": [{"delta": {"reasoning": "question."}}]}`,
+		`data: {"choices": [{"delta": {"content": "Yes."}, "finish_reason": "stop"}]}`,
+		`data: {"choices": [], "x_groq": {"usage": {"prompt_tokens": 4, "completion_tokens": 6, "total_tokens": 10}}}`,
+		`data: [DONE]`,
+	}, "\n\n")
+	var reported []string
+	resp, err := readStream(context.Background(), strings.NewReader(stream), func(data string) {
+		reported = append(reported, data)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Text != "Yes." || resp.Reasoning != "Checking the question." || resp.FinishReason != "stop" || resp.Usage == nil || resp.Usage.TotalTokens != 10 {
+		t.Errorf("unexpected response %+v", resp)
+	}
+	if len(reported) != 1 || reported[0] != "Checking the question." {
+		t.Errorf("unexpected reasoning callbacks %q", reported)
+	}
+
+	// reasoning without an answer is reported when the stream ends
+	reported = nil
+	resp, err = readStream(context.Background(), strings.NewReader(`data: {"choices": [{"delta": {"reasoning_content": "thinking"}, "finish_reason": "length"}]}`+"\n"), func(data string) {
+		reported = append(reported, data)
+	})
+	if err != nil || resp.Text != "" || len(reported) != 1 || reported[0] != "thinking" {
+		t.Errorf("unexpected response %+v %q %v", resp, reported, err)
+	}
+}
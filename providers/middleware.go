@@ -0,0 +1,165 @@
+package providers
+
+// composable request/response middleware for the shared http clients. a
+// middleware wraps the next RoundTripper, so logging, redaction, headers, and
+// similar concerns are written once and registered per provider with Use
+// instead of being repeated inside each Handle function. the provider of a
+// request is derived from its host, see ProviderForHost.
+//
+// extra headers can be configured from the environment:
+//
+//	NINA_<PROVIDER>_HEADERS  "Name: value; Other: value" added to each request
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nathants/nina/util"
+)
+
+// Middleware wraps a RoundTripper, it may change the request, the response,
+// or both before handing off to next
+type Middleware func(next http.RoundTripper) http.RoundTripper
+
+// RoundTripperFunc adapts a function to http.RoundTripper
+type RoundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f RoundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+var (
+	middlewares   = map[string][]Middleware{}
+	middlewaresMu sync.RWMutex
+)
+
+// providerHosts maps api hosts to the provider names used by rate limits
+var providerHosts = map[string]string{
+	"api.anthropic.com":                 "claude",
+	"api.openai.com":                    "openai",
+	"generativelanguage.googleapis.com": "gemini",
+	"api.x.ai":                          "grok",
+	"api.groq.com":                      "groq",
+	"openrouter.ai":                     "openrouter",
+	"api.mistral.ai":                    "mistral",
+	"codestral.mistral.ai":              "mistral",
+	"api.deepseek.com":                  "deepseek",
+}
+
+// ProviderForHost returns the provider for a request host, the ollama port
+// matches ollama on any host, unknown hosts return ""
+func ProviderForHost(host string) string {
+	name, port, err := net.SplitHostPort(host)
+	if err != nil {
+		name = host
+	}
+	if port == "11434" {
+		return "ollama"
+	}
+	return providerHosts[strings.ToLower(name)]
+}
+
+// Use registers middleware for a provider, provider "" applies to every
+// request. Middleware registered first runs outermost.
+func Use(provider string, mw ...Middleware) {
+	middlewaresMu.Lock()
+	defer middlewaresMu.Unlock()
+	middlewares[provider] = append(middlewares[provider], mw...)
+}
+
+// Chain wraps next with mw, the first middleware is outermost
+func Chain(next http.RoundTripper, mw ...Middleware) http.RoundTripper {
+	for i := len(mw) - 1; i >= 0; i-- {
+		next = mw[i](next)
+	}
+	return next
+}
+
+// middlewareFor returns the global middleware followed by the provider's
+func middlewareFor(provider string) []Middleware {
+	middlewaresMu.RLock()
+	defer middlewaresMu.RUnlock()
+	mw := append([]Middleware{}, middlewares[""]...)
+	if provider != "" {
+		mw = append(mw, middlewares[provider]...)
+	}
+	return mw
+}
+
+// middlewareTransport applies the registered middleware for each request's
+// provider, so registration after the clients are built still takes effect
+type middlewareTransport struct {
+	next http.RoundTripper
+}
+
+func (t middlewareTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	provider := ProviderForHost(req.URL.Host)
+	mw := middlewareFor(provider)
+	if env := headersFromEnv(provider); env != nil {
+		mw = append(mw, env)
+	}
+	if base := baseURLFromEnv(provider); base != nil {
+		mw = append(mw, base)
+	}
+	mw = append(mw, timeoutMiddleware(provider, HTTPConfigFor(provider)))
+	return Chain(t.next, mw...).RoundTrip(req)
+}
+
+// Headers sets each header on outgoing requests, replacing existing values
+func Headers(headers http.Header) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			req = req.Clone(req.Context())
+			for name, values := range headers {
+				req.Header.Del(name)
+				for _, value := range values {
+					req.Header.Add(name, value)
+				}
+			}
+			return next.RoundTrip(req)
+		})
+	}
+}
+
+// Logging logs method, url, status, and duration of each request at verbose
+// level, the query string is dropped since it may carry an api key
+func Logging() Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			start := time.Now()
+			target := req.URL.Scheme + "://" + req.URL.Host + req.URL.Path
+			resp, err := next.RoundTrip(req)
+			if err != nil {
+				util.Verbosef("http %s %s failed after %s: %v", req.Method, target, time.Since(start).Round(time.Millisecond), err)
+				return resp, err
+			}
+			util.Verbosef("http %s %s %d in %s", req.Method, target, resp.StatusCode, time.Since(start).Round(time.Millisecond))
+			return resp, nil
+		})
+	}
+}
+
+// headersFromEnv builds a Headers middleware from NINA_<PROVIDER>_HEADERS
+func headersFromEnv(provider string) Middleware {
+	if provider == "" {
+		return nil
+	}
+	value := os.Getenv("NINA_" + strings.ToUpper(provider) + "_HEADERS")
+	if value == "" {
+		return nil
+	}
+	headers := http.Header{}
+	for _, entry := range strings.Split(value, ";") {
+		name, val, ok := strings.Cut(entry, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			util.Errorf("warning: ignoring invalid header %q in NINA_%s_HEADERS", strings.TrimSpace(entry), strings.ToUpper(provider))
+			continue
+		}
+		headers.Add(strings.TrimSpace(name), strings.TrimSpace(val))
+	}
+	return Headers(headers)
+}
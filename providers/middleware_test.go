@@ -0,0 +1,77 @@
+package providers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestChainOrder(t *testing.T) {
+	var order []string
+	mark := func(name string) Middleware {
+		return func(next http.RoundTripper) http.RoundTripper {
+			return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				order = append(order, name)
+				return next.RoundTrip(req)
+			})
+		}
+	}
+	final := RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		order = append(order, "final")
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	})
+	req, _ := http.NewRequest("GET", "https://example.com", nil)
+	if _, err := Chain(final, mark("a"), mark("b")).RoundTrip(req); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(order, ","); got != "a,b,final" {
+		t.Errorf("order = %s, want a,b,final", got)
+	}
+}
+
+func TestProviderForHost(t *testing.T) {
+	tests := map[string]string{
+		"api.anthropic.com":                 "claude",
+		"API.OpenAI.com:443":                "openai",
+		"localhost:11434":                   "ollama",
+		"example.com":                       "",
+		"generativelanguage.googleapis.com": "gemini",
+	}
+	for host, want := range tests {
+		if got := ProviderForHost(host); got != want {
+			t.Errorf("ProviderForHost(%q) = %q, want %q", host, got, want)
+		}
+	}
+}
+
+func TestMiddlewareTransportPerProvider(t *testing.T) {
+	var seen http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r.Header.Clone()
+	}))
+	defer server.Close()
+
+	saved := middlewares
+	middlewares = map[string][]Middleware{}
+	defer func() { middlewares = saved }()
+	providerHosts["127.0.0.1"] = "test"
+	defer delete(providerHosts, "127.0.0.1")
+
+	Use("test", Headers(http.Header{"X-Provider": {"test"}}))
+	Use("other", Headers(http.Header{"X-Other": {"other"}}))
+	t.Setenv("NINA_TEST_HEADERS", "X-Env: one; X-Also: two")
+
+	client := &http.Client{Transport: middlewareTransport{next: http.DefaultTransport}}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if seen.Get("X-Provider") != "test" || seen.Get("X-Env") != "one" || seen.Get("X-Also") != "two" {
+		t.Errorf("missing headers: %v", seen)
+	}
+	if seen.Get("X-Other") != "" {
+		t.Errorf("other provider's middleware ran: %v", seen)
+	}
+}
@@ -1,6 +1,8 @@
 // ollama provides integration with local Ollama server for LLM inference with
 // streaming support and full parameter control. Automatically selects the latest
 // model when none specified, supports all parameters, and streams responses.
+// Installed models are listed from /api/tags and missing ones pulled with
+// /api/pull, a model's context length is read from /api/show.
 package ollama
 
 import (
@@ -12,51 +14,63 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
-	
+
 	providers "github.com/nathants/nina/providers"
 )
 
-type ollamaMessage struct {
+// Message is one chat message
+type Message struct {
 	Role    string `json:"role"`
 	Content string `json:"content"`
 }
 
-type ollamaRequest struct {
-	Model            string          `json:"model"`
-	Messages         []ollamaMessage `json:"messages"`
-	Stream           bool            `json:"stream"`
-	Temperature      *float64        `json:"temperature,omitempty"`
-	TopP             *float64        `json:"top_p,omitempty"`
-	TopK             *int            `json:"top_k,omitempty"`
-	Seed             *int            `json:"seed,omitempty"`
-	NumPredict       *int            `json:"num_predict,omitempty"`
-	NumCtx           *int            `json:"num_ctx,omitempty"`
-	RepeatPenalty    *float64        `json:"repeat_penalty,omitempty"`
-	RepeatLastN      *int            `json:"repeat_last_n,omitempty"`
-	Stop             []string        `json:"stop,omitempty"`
-	TfsZ             *float64        `json:"tfs_z,omitempty"`
-	TypicalP         *float64        `json:"typical_p,omitempty"`
-	PresencePenalty  *float64        `json:"presence_penalty,omitempty"`
-	FrequencyPenalty *float64        `json:"frequency_penalty,omitempty"`
+// Options are the model parameters ollama reads from a request's options,
+// nil fields use the model's defaults
+type Options struct {
+	Temperature      *float64 `json:"temperature,omitempty"`
+	TopP             *float64 `json:"top_p,omitempty"`
+	TopK             *int     `json:"top_k,omitempty"`
+	Seed             *int     `json:"seed,omitempty"`
+	NumPredict       *int     `json:"num_predict,omitempty"`
+	NumCtx           *int     `json:"num_ctx,omitempty"`
+	RepeatPenalty    *float64 `json:"repeat_penalty,omitempty"`
+	RepeatLastN      *int     `json:"repeat_last_n,omitempty"`
+	Stop             []string `json:"stop,omitempty"`
+	TfsZ             *float64 `json:"tfs_z,omitempty"`
+	TypicalP         *float64 `json:"typical_p,omitempty"`
+	PresencePenalty  *float64 `json:"presence_penalty,omitempty"`
+	FrequencyPenalty *float64 `json:"frequency_penalty,omitempty"`
+}
+
+// Request is a chat request
+type Request struct {
+	Model    string    `json:"model"`
+	Messages []Message `json:"messages"`
+	Stream   bool      `json:"stream"`
+	Options  *Options  `json:"options,omitempty"`
 }
 
-type ollamaResponse struct {
-	Message            ollamaMessage `json:"message"`
-	Done               bool          `json:"done"`
-	Model              string        `json:"model,omitempty"`
-	CreatedAt          string        `json:"created_at,omitempty"`
-	DoneReason         string        `json:"done_reason,omitempty"`
-	TotalDuration      int64         `json:"total_duration,omitempty"`
-	LoadDuration       int64         `json:"load_duration,omitempty"`
-	PromptEvalCount    int           `json:"prompt_eval_count,omitempty"`
-	PromptEvalDuration int64         `json:"prompt_eval_duration,omitempty"`
-	EvalCount          int           `json:"eval_count,omitempty"`
-	EvalDuration       int64         `json:"eval_duration,omitempty"`
+// ChatResponse is a chat reply, or one chunk of a streamed reply whose last
+// chunk holds the token counts
+type ChatResponse struct {
+	Message            Message `json:"message"`
+	Done               bool    `json:"done"`
+	Model              string  `json:"model,omitempty"`
+	CreatedAt          string  `json:"created_at,omitempty"`
+	DoneReason         string  `json:"done_reason,omitempty"`
+	TotalDuration      int64   `json:"total_duration,omitempty"`
+	LoadDuration       int64   `json:"load_duration,omitempty"`
+	PromptEvalCount    int     `json:"prompt_eval_count,omitempty"`
+	PromptEvalDuration int64   `json:"prompt_eval_duration,omitempty"`
+	EvalCount          int     `json:"eval_count,omitempty"`
+	EvalDuration       int64   `json:"eval_duration,omitempty"`
 }
 
-type ollamaModelInfo struct {
+// ModelInfo is an installed model
+type ModelInfo struct {
 	Name       string    `json:"name"`
 	ModifiedAt time.Time `json:"modified_at"`
 	Size       int64     `json:"size"`
@@ -71,7 +85,7 @@ type ollamaModelInfo struct {
 }
 
 type ollamaModelsResponse struct {
-	Models []ollamaModelInfo `json:"models"`
+	Models []ModelInfo `json:"models"`
 }
 
 // OllamaConfig holds all configuration parameters for Ollama requests including
@@ -96,6 +110,25 @@ type OllamaConfig struct {
 	ReasoningCallback func(string)
 }
 
+// options returns the model parameters of config
+func (c OllamaConfig) options() *Options {
+	return &Options{
+		Temperature:      c.Temperature,
+		TopP:             c.TopP,
+		TopK:             c.TopK,
+		Seed:             c.Seed,
+		NumPredict:       c.NumPredict,
+		NumCtx:           c.NumCtx,
+		RepeatPenalty:    c.RepeatPenalty,
+		RepeatLastN:      c.RepeatLastN,
+		Stop:             c.Stop,
+		TfsZ:             c.TfsZ,
+		TypicalP:         c.TypicalP,
+		PresencePenalty:  c.PresencePenalty,
+		FrequencyPenalty: c.FrequencyPenalty,
+	}
+}
+
 var (
 	ollamaClient *http.Client
 )
@@ -115,39 +148,59 @@ func getOllamaURL() string {
 	return url
 }
 
-// getLatestOllamaModel queries the Ollama server for available models and returns
-// the name of the most recently modified model. Returns an error if no models
-// are available or if the server cannot be reached.
-func getLatestOllamaModel(ctx context.Context) (string, error) {
+// ListModels returns the models installed on the Ollama server
+func ListModels(ctx context.Context) ([]ModelInfo, error) {
 	url := getOllamaURL() + "/api/tags"
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		return "", fmt.Errorf("create request: %w", err)
+		return nil, fmt.Errorf("create request: %w", err)
 	}
 
 	resp, err := ollamaClient.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("fetch models: %w", err)
+		return nil, fmt.Errorf("fetch models: %w", err)
 	}
 	defer func() { _ = resp.Body.Close() }()
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("ollama error: %s", string(body))
+		return nil, fmt.Errorf("ollama error: %s", string(body))
 	}
 
 	var modelsResp ollamaModelsResponse
 	if err := json.NewDecoder(resp.Body).Decode(&modelsResp); err != nil {
-		return "", fmt.Errorf("decode response: %w", err)
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+	return modelsResp.Models, nil
+}
+
+// Installed reports whether name is among the installed models, a name
+// without a tag matches its :latest tag
+func Installed(installed []ModelInfo, name string) bool {
+	for _, m := range installed {
+		if m.Name == name || (!strings.Contains(name, ":") && m.Name == name+":latest") {
+			return true
+		}
+	}
+	return false
+}
+
+// getLatestOllamaModel queries the Ollama server for available models and returns
+// the name of the most recently modified model. Returns an error if no models
+// are available or if the server cannot be reached.
+func getLatestOllamaModel(ctx context.Context) (string, error) {
+	installed, err := ListModels(ctx)
+	if err != nil {
+		return "", err
 	}
 
-	if len(modelsResp.Models) == 0 {
+	if len(installed) == 0 {
 		return "", fmt.Errorf("no models available")
 	}
 
 	// Find the most recently modified model
-	latestModel := modelsResp.Models[0]
-	for _, model := range modelsResp.Models[1:] {
+	latestModel := installed[0]
+	for _, model := range installed[1:] {
 		if model.ModifiedAt.After(latestModel.ModifiedAt) {
 			latestModel = model
 		}
@@ -156,6 +209,112 @@ func getLatestOllamaModel(ctx context.Context) (string, error) {
 	return latestModel.Name, nil
 }
 
+// ContextLength returns the context length name was trained with and the
+// num_ctx its Modelfile sets, 0 when it sets none, from /api/show. The model
+// "ollama" is the most recently modified installed model.
+func ContextLength(ctx context.Context, name string) (trained, configured int, err error) {
+	if name == "ollama" || name == "" {
+		if name, err = getLatestOllamaModel(ctx); err != nil {
+			return 0, 0, err
+		}
+	}
+	reqBody, err := json.Marshal(map[string]string{"model": name})
+	if err != nil {
+		return 0, 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, getOllamaURL()+"/api/show", bytes.NewBuffer(reqBody))
+	if err != nil {
+		return 0, 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := ollamaClient.Do(req)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return 0, 0, fmt.Errorf("ollama error: %s", string(body))
+	}
+
+	var show struct {
+		Parameters string         `json:"parameters"`
+		ModelInfo  map[string]any `json:"model_info"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&show); err != nil {
+		return 0, 0, fmt.Errorf("decode response: %w", err)
+	}
+	for key, value := range show.ModelInfo {
+		if n, ok := value.(float64); ok && strings.HasSuffix(key, ".context_length") {
+			trained = int(n)
+		}
+	}
+	for line := range strings.SplitSeq(show.Parameters, "\n") {
+		if fields := strings.Fields(line); len(fields) == 2 && fields[0] == "num_ctx" {
+			configured, _ = strconv.Atoi(fields[1])
+		}
+	}
+	return trained, configured, nil
+}
+
+// pullProgress is one line of the streamed pull status
+type pullProgress struct {
+	Status    string `json:"status"`
+	Completed int64  `json:"completed,omitempty"`
+	Total     int64  `json:"total,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Pull downloads a model to the Ollama server, calling progress with each
+// new status like "pulling manifest" or "success"
+func Pull(ctx context.Context, name string, progress func(status string)) error {
+	reqBody, err := json.Marshal(map[string]any{"model": name, "stream": true})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, getOllamaURL()+"/api/pull", bytes.NewBuffer(reqBody))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := ollamaClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("ollama error: %s", string(body))
+	}
+
+	last := ""
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var p pullProgress
+		if err := json.Unmarshal(scanner.Bytes(), &p); err != nil {
+			continue
+		}
+		if p.Error != "" {
+			return fmt.Errorf("pull %s: %s", name, p.Error)
+		}
+		if p.Status != last && progress != nil {
+			progress(p.Status)
+		}
+		last = p.Status
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if last != "success" {
+		return fmt.Errorf("pull %s: ended with status %q", name, last)
+	}
+	return nil
+}
+
 // HandleOllamaChat sends the prompt to an Ollama server (configured via OLLAMA_URL
 // env var, defaults to http://localhost:11434) and returns the assistant's reply.
 // Streaming is not used – the whole answer is returned in one go which is then
@@ -173,74 +332,79 @@ func HandleOllamaChat(ctx context.Context, prompt string, model string) (string,
 // callback and the complete response is returned. System prompts should be
 // included in the prompt parameter.
 func HandleOllamaChatWithConfig(ctx context.Context, prompt string, config OllamaConfig) (string, error) {
+	resp, err := HandleChat(ctx, []Message{{Role: "user", Content: prompt}}, config)
+	if err != nil {
+		return "", err
+	}
+	return resp.Message.Content, nil
+}
+
+// HandleChat sends a conversation to the Ollama server and returns the reply
+// with its token counts. The model "ollama" or an empty model uses the most
+// recently modified installed model, the response names the model used.
+func HandleChat(ctx context.Context, messages []Message, config OllamaConfig) (*ChatResponse, error) {
 	modelName := config.Model
 	if modelName == "ollama" || modelName == "" {
 		latestModel, err := getLatestOllamaModel(ctx)
 		if err != nil {
-			return "", fmt.Errorf("get latest model: %w", err)
+			return nil, fmt.Errorf("get latest model: %w", err)
 		}
-		// fmt.Fprintln(os.Stderr, "ollama model:", latestModel)
 		modelName = latestModel
 	}
 
-	reqBody, err := json.Marshal(ollamaRequest{
-		Model:            modelName,
-		Messages:         []ollamaMessage{{Role: "user", Content: prompt}},
-		Stream:           config.Stream,
-		Temperature:      config.Temperature,
-		TopP:             config.TopP,
-		TopK:             config.TopK,
-		Seed:             config.Seed,
-		NumPredict:       config.NumPredict,
-		NumCtx:           config.NumCtx,
-		RepeatPenalty:    config.RepeatPenalty,
-		RepeatLastN:      config.RepeatLastN,
-		Stop:             config.Stop,
-		TfsZ:             config.TfsZ,
-		TypicalP:         config.TypicalP,
-		PresencePenalty:  config.PresencePenalty,
-		FrequencyPenalty: config.FrequencyPenalty,
+	reqBody, err := json.Marshal(Request{
+		Model:    modelName,
+		Messages: messages,
+		Stream:   config.Stream,
+		Options:  config.options(),
 	})
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	url := getOllamaURL() + "/api/chat"
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(reqBody))
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	req.Header.Set("Content-Type", "application/json")
 
+	release, err := providers.AcquireRateLimit(ctx, "ollama", reqBody)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
 	resp, err := ollamaClient.Do(req)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	defer func() { _ = resp.Body.Close() }()
 
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("ollama error: %s", string(body))
+		return nil, fmt.Errorf("ollama: %w", providers.NewStatusError(resp))
 	}
 
 	if config.Stream {
 		return handleOllamaStream(resp.Body, config.ReasoningCallback)
 	}
 
-	var respObj ollamaResponse
+	var respObj ChatResponse
 	if err := json.NewDecoder(resp.Body).Decode(&respObj); err != nil {
-		return "", err
+		return nil, err
 	}
 
-	return respObj.Message.Content, nil
+	return &respObj, nil
 }
 
 // handleOllamaStream processes streaming responses from Ollama, calling the
 // reasoning callback with each chunk and accumulating the full response to
-// return when complete.
-func handleOllamaStream(body io.ReadCloser, reasoningCallback func(string)) (string, error) {
+// return with the token counts of the final chunk when complete.
+func handleOllamaStream(body io.ReadCloser, reasoningCallback func(string)) (*ChatResponse, error) {
 	var fullResponse strings.Builder
+	var final ChatResponse
 	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
 
 	for scanner.Scan() {
 		line := scanner.Text()
@@ -248,9 +412,9 @@ func handleOllamaStream(body io.ReadCloser, reasoningCallback func(string)) (str
 			continue
 		}
 
-		var chunk ollamaResponse
+		var chunk ChatResponse
 		if err := json.Unmarshal([]byte(line), &chunk); err != nil {
-			return "", fmt.Errorf("failed to parse stream chunk: %w", err)
+			return nil, &providers.StreamError{Err: fmt.Errorf("failed to parse stream chunk: %w", err)}
 		}
 
 		if chunk.Message.Content != "" {
@@ -263,13 +427,15 @@ func handleOllamaStream(body io.ReadCloser, reasoningCallback func(string)) (str
 		}
 
 		if chunk.Done {
+			final = chunk
 			break
 		}
 	}
 
 	if err := scanner.Err(); err != nil {
-		return "", fmt.Errorf("stream reading error: %w", err)
+		return nil, &providers.StreamError{Err: fmt.Errorf("stream reading error: %w", err)}
 	}
 
-	return fullResponse.String(), nil
+	final.Message = Message{Role: "assistant", Content: fullResponse.String()}
+	return &final, nil
 }
@@ -0,0 +1,108 @@
+package ollama
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// fakeServer serves /api/tags with installed, /api/pull adding the pulled
+// model, /api/show with a 131072 token model whose Modelfile sets num_ctx
+// 8192, and /api/chat streaming reply, recording each chat request
+func fakeServer(t *testing.T, installed []string, reply string, requests *[]Request) {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/tags", func(w http.ResponseWriter, r *http.Request) {
+		var resp ollamaModelsResponse
+		for _, name := range installed {
+			resp.Models = append(resp.Models, ModelInfo{Name: name})
+		}
+		_ = json.NewEncoder(w).Encode(resp)
+	})
+	mux.HandleFunc("/api/pull", func(w http.ResponseWriter, r *http.Request) {
+		var req struct{ Model string }
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		installed = append(installed, req.Model)
+		_, _ = fmt.Fprintln(w, `{"status": "pulling manifest"}`)
+		_, _ = fmt.Fprintln(w, `{"status": "downloading", "completed": 1, "total": 2}`)
+		_, _ = fmt.Fprintln(w, `{"status": "downloading", "completed": 2, "total": 2}`)
+		_, _ = fmt.Fprintln(w, `{"status": "success"}`)
+	})
+	mux.HandleFunc("/api/show", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, `{"parameters": "stop \"<|im_end|>\"\nnum_ctx 8192", "model_info": {"general.architecture": "qwen2", "qwen2.context_length": 131072}}`)
+	})
+	mux.HandleFunc("/api/chat", func(w http.ResponseWriter, r *http.Request) {
+		var req Request
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		*requests = append(*requests, req)
+		for _, word := range []string{reply[:2], reply[2:]} {
+			_, _ = fmt.Fprintf(w, `{"model": %q, "message": {"role": "assistant", "content": %q}, "done": false}`+"\n", req.Model, word)
+		}
+		_, _ = fmt.Fprintf(w, `{"model": %q, "message": {"role": "assistant", "content": ""}, "done": true, "done_reason": "stop", "prompt_eval_count": 12, "eval_count": 3}`+"\n", req.Model)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	t.Setenv("OLLAMA_URL", server.URL)
+}
+
+func TestHandleChat(t *testing.T) {
+	var requests []Request
+	fakeServer(t, []string{"qwen2.5-coder:latest"}, "hello", &requests)
+	numCtx, temperature := 8192, 0.2
+	var streamed string
+	resp, err := HandleChat(context.Background(), []Message{{Role: "user", Content: "hi"}}, OllamaConfig{
+		Model:             "ollama",
+		NumCtx:            &numCtx,
+		Temperature:       &temperature,
+		Stream:            true,
+		ReasoningCallback: func(data string) { streamed += data },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Message.Content != "hello" || streamed != "hello" || resp.Model != "qwen2.5-coder:latest" || resp.PromptEvalCount != 12 || resp.EvalCount != 3 || resp.DoneReason != "stop" {
+		t.Errorf("unexpected response %+v, streamed %q", resp, streamed)
+	}
+	options := requests[0].Options
+	if options == nil || *options.NumCtx != 8192 || *options.Temperature != 0.2 {
+		t.Errorf("options = %+v, want num_ctx and temperature sent as options", options)
+	}
+}
+
+func TestInstalledAndPull(t *testing.T) {
+	var requests []Request
+	fakeServer(t, []string{"llama3:latest"}, "hello", &requests)
+	installed, err := ListModels(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !Installed(installed, "llama3") || !Installed(installed, "llama3:latest") || Installed(installed, "llama3:8b") {
+		t.Errorf("Installed() did not match tags: %+v", installed)
+	}
+	var statuses []string
+	if err := Pull(context.Background(), "qwen3", func(status string) { statuses = append(statuses, status) }); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(statuses) != "[pulling manifest downloading success]" {
+		t.Errorf("statuses = %q", statuses)
+	}
+	installed, _ = ListModels(context.Background())
+	if !Installed(installed, "qwen3") {
+		t.Error("want the pulled model installed")
+	}
+}
+
+func TestContextLength(t *testing.T) {
+	var requests []Request
+	fakeServer(t, []string{"qwen2.5-coder:latest"}, "hello", &requests)
+	trained, configured, err := ContextLength(context.Background(), "qwen2.5-coder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trained != 131072 || configured != 8192 {
+		t.Errorf("ContextLength() = %d, %d, want 131072, 8192", trained, configured)
+	}
+}
@@ -72,11 +72,14 @@ type Response struct {
 
 // Output represents each element in the "output" array.
 type Output struct {
-	Content []Content `json:"content"`
-	ID      string    `json:"id"`
-	Role    string    `json:"role"`
-	Status  string    `json:"status"`
-	Type    string    `json:"type"`
+	Content   []Content `json:"content"`
+	ID        string    `json:"id"`
+	Role      string    `json:"role"`
+	Status    string    `json:"status"`
+	Type      string    `json:"type"`
+	CallID    string    `json:"call_id,omitempty"`   // function_call
+	Name      string    `json:"name,omitempty"`      // function_call
+	Arguments string    `json:"arguments,omitempty"` // function_call, json
 }
 
 // Content represents each item in an Output's "content" array.
@@ -140,10 +143,31 @@ type ContentPart struct {
 	ImageURL string `json:"image_url,omitempty"`
 }
 
+// ChatMessage is an input item, a message, or with function calling a
+// function_call from the assistant or the function_call_output answering it
 type ChatMessage struct {
-	Type    string        `json:"type"` // always "message"
-	Role    string        `json:"role"`
-	Content []ContentPart `json:"content"`
+	Type      string        `json:"type"` // message, function_call, or function_call_output
+	Role      string        `json:"role,omitempty"`
+	Content   []ContentPart `json:"content,omitempty"`
+	CallID    string        `json:"call_id,omitempty"`
+	Name      string        `json:"name,omitempty"`
+	Arguments string        `json:"arguments,omitempty"`
+	Output    string        `json:"output,omitempty"`
+}
+
+// Tool is a function the model may call
+type Tool struct {
+	Type        string         `json:"type"` // always "function"
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	Parameters  map[string]any `json:"parameters"`
+}
+
+// FunctionCall is a function call of a response, Arguments is json
+type FunctionCall struct {
+	CallID    string `json:"call_id"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
 }
 
 type ReasoningRequest struct {
@@ -158,11 +182,14 @@ type Request struct {
 	Instructions    string            `json:"instructions,omitempty"`
 	Reasoning       *ReasoningRequest `json:"reasoning,omitempty"`
 	Temperature     *float64          `json:"temperature,omitempty"`
+	TopP            *float64          `json:"top_p,omitempty"`
 	MaxOutputTokens *int              `json:"max_output_tokens,omitempty"`
 	Stream          bool              `json:"stream"`
 	Store           bool              `json:"store"`
 	User            string            `json:"user"`
 	PreviousID      string            `json:"previous_response_id,omitempty"`
+	Background      bool              `json:"background,omitempty"`
+	Tools           []Tool            `json:"tools,omitempty"`
 }
 
 /*
@@ -171,7 +198,16 @@ type Request struct {
 */
 
 type ResponsePayload struct {
-	Usage Usage `json:"usage"`
+	Usage             Usage              `json:"usage"`
+	Model             string             `json:"model"`
+	Status            string             `json:"status"`
+	IncompleteDetails *IncompleteDetails `json:"incomplete_details"`
+}
+
+// IncompleteDetails says why a response stopped early, like
+// max_output_tokens or content_filter
+type IncompleteDetails struct {
+	Reason string `json:"reason"`
 }
 
 type ResponseCompletedEvent struct {
@@ -182,9 +218,13 @@ type ResponseCompletedEvent struct {
 // HandleResponse holds the response data from the Handle function including
 // the response text, usage statistics, and response ID for conversation state.
 type HandleResponse struct {
-	Text       string
-	Usage      *Usage
-	ResponseID string
+	Text             string
+	Usage            *Usage
+	ResponseID       string
+	Model            string // the model version that answered
+	Status           string // completed or incomplete
+	IncompleteReason string // max_output_tokens, content_filter, ...
+	FunctionCalls    []FunctionCall
 }
 
 var logModelOnce sync.Once
@@ -195,7 +235,7 @@ func Handle(ctx context.Context, req Request, reasoningCallback func(data string
 		if req.Reasoning != nil {
 			effort = "effort=" + req.Reasoning.Effort
 		}
-		fmt.Fprintln(os.Stderr, "model="+ req.Model, "serviceTier="+ req.ServiceTier, effort)
+		util.Infof("%s", strings.TrimSpace("model="+req.Model+" serviceTier="+req.ServiceTier+" "+effort))
 	})
 
 	// fmt.Println("calling openai", req.Model)
@@ -211,7 +251,16 @@ func Handle(ctx context.Context, req Request, reasoningCallback func(data string
 		return nil, fmt.Errorf("json marshal error: %w", err)
 	}
 
-	outReq, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://api.openai.com/v1/responses", bytes.NewBuffer(body))
+	if req.Background {
+		return handleBackground(ctx, req, reasoningCallback)
+	}
+
+	reqCtx := ctx
+	if req.ServiceTier == "flex" {
+		// flex requests may queue for many minutes before responding
+		reqCtx = providers.WithRequestTimeout(ctx, providers.FlexTimeout())
+	}
+	outReq, err := http.NewRequestWithContext(reqCtx, http.MethodPost, responsesURL, bytes.NewBuffer(body))
 	if err != nil {
 		return nil, fmt.Errorf("request creation error: %w", err)
 	}
@@ -226,6 +275,12 @@ func Handle(ctx context.Context, req Request, reasoningCallback func(data string
 	// Execute the request
 	// ---------------------------------------------------------------------
 
+	release, err := providers.AcquireRateLimit(ctx, "openai", body)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
 	client := providers.LongTimeoutClient
 	resp, err := client.Do(outReq)
 	if err != nil {
@@ -234,8 +289,7 @@ func Handle(ctx context.Context, req Request, reasoningCallback func(data string
 	defer func() { _ = resp.Body.Close() }()
 
 	if resp.StatusCode != http.StatusOK {
-		resBody, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("api error: %s", string(resBody))
+		return nil, providers.NewStatusError(resp)
 	}
 
 	if !req.Stream {
@@ -257,32 +311,67 @@ func Handle(ctx context.Context, req Request, reasoningCallback func(data string
 		if err != nil {
 			return nil, err
 		}
-		for _, output := range val.Output {
-			if output.Type == "message" {
-				res := &HandleResponse{
-					Text:       output.Content[0].Text,
-					Usage:      &val.Usage,
-					ResponseID: val.ID,
-				}
-				return res, nil
-			}
-		}
+		return responseResult(&val)
+	}
 
-		return nil, fmt.Errorf("no message output returned")
+	var stream streamState
+	if err := stream.read(ctx, resp.Body, reasoningCallback); err != nil {
+		return nil, err
 	}
+	return stream.result(), nil
+}
 
-	var answerBuilder strings.Builder
-	var reasoningSummaryBuilder strings.Builder
-	var eventData strings.Builder
-	var raw map[string]any
-	var responseID string
+const responsesURL = "https://api.openai.com/v1/responses"
+
+// responseResult extracts the message output of a finished response, an
+// incomplete response may have none
+func responseResult(val *Response) (*HandleResponse, error) {
+	res := &HandleResponse{
+		Usage:      &val.Usage,
+		ResponseID: val.ID,
+		Model:      val.Model,
+		Status:     val.Status,
+	}
+	if details, ok := val.IncompleteDetails.(map[string]any); ok {
+		res.IncompleteReason, _ = details["reason"].(string)
+	}
+	message := false
+	for _, output := range val.Output {
+		switch {
+		case output.Type == "function_call":
+			res.FunctionCalls = append(res.FunctionCalls, FunctionCall{CallID: output.CallID, Name: output.Name, Arguments: output.Arguments})
+		case output.Type == "message" && len(output.Content) > 0 && !message:
+			res.Text = output.Content[0].Text
+			message = true
+		}
+	}
+	if message || len(res.FunctionCalls) > 0 || val.Status == "incomplete" {
+		return res, nil
+	}
+	return nil, fmt.Errorf("no message output returned")
+}
 
-	reader := bufio.NewReader(resp.Body)
+// streamState accumulates server sent events across one or more reads of a
+// response stream, background streams resume after the last sequence number
+type streamState struct {
+	answer    strings.Builder
+	reasoning strings.Builder
+	calls     []FunctionCall
+	raw       map[string]any
+	id        string
+	sequence  int
+	completed bool
+}
+
+// read consumes events from body until it ends or the response completes
+func (s *streamState) read(ctx context.Context, body io.Reader, reasoningCallback func(data string)) error {
+	var eventData strings.Builder
+	reader := bufio.NewReader(body)
 
 	for {
 		select {
 		case <-ctx.Done():
-			return nil, ctx.Err()
+			return ctx.Err()
 		default:
 		}
 
@@ -292,9 +381,9 @@ func Handle(ctx context.Context, req Request, reasoningCallback func(data string
 				break
 			}
 			if ctx.Err() != nil {
-				return nil, ctx.Err()
+				return ctx.Err()
 			}
-			return nil, fmt.Errorf("stream read error: %w", err)
+			return &providers.StreamError{Err: fmt.Errorf("stream read error: %w", err)}
 		}
 
 		if strings.HasPrefix(line, "data:") {
@@ -318,6 +407,10 @@ func Handle(ctx context.Context, req Request, reasoningCallback func(data string
 			continue
 		}
 
+		if seq, ok := val["sequence_number"].(float64); ok {
+			s.sequence = int(seq)
+		}
+
 		evtType, _ := val["type"].(string)
 
 		switch evtType {
@@ -326,41 +419,57 @@ func Handle(ctx context.Context, req Request, reasoningCallback func(data string
 			// Extract response ID from the first streamed event
 			if response, ok := val["response"].(map[string]any); ok {
 				if id, ok := response["id"].(string); ok {
-					responseID = id
+					s.id = id
 				}
 			}
 
 		case "response.reasoning_summary_text.delta":
 			delta, ok := val["delta"].(string)
 			if ok {
-				reasoningSummaryBuilder.WriteString(delta)
+				s.reasoning.WriteString(delta)
 			}
 
 		case "response.reasoning_summary_text.done":
-			if ctx.Err() == nil {
-				reasoningCallback(reasoningSummaryBuilder.String())
+			if ctx.Err() == nil && reasoningCallback != nil {
+				reasoningCallback(s.reasoning.String())
 			}
-			reasoningSummaryBuilder.Reset()
+			s.reasoning.Reset()
 
 		case "response.output_text.delta":
 			delta, ok := val["delta"].(string)
 			if ok {
-				answerBuilder.WriteString(delta)
+				s.answer.WriteString(delta)
+			}
+
+		case "response.output_item.done":
+			item, _ := val["item"].(map[string]any)
+			if kind, _ := item["type"].(string); kind == "function_call" {
+				call := FunctionCall{}
+				call.CallID, _ = item["call_id"].(string)
+				call.Name, _ = item["name"].(string)
+				call.Arguments, _ = item["arguments"].(string)
+				s.calls = append(s.calls, call)
 			}
 
-		case "response.completed":
-			raw = val
+		case "response.completed", "response.incomplete":
+			// an incomplete response stopped early, at the output token
+			// limit or a content filter, and is returned with its status
+			s.raw = val
+			s.completed = true
 
-		case "error", "response.failed", "response.incomplete":
-			return nil, fmt.Errorf("api stream error: %s", util.Pformat(val))
+		case "error", "response.failed", "response.cancelled":
+			return &providers.StreamError{Err: fmt.Errorf("api stream error: %s", util.Pformat(val))}
 
 		default:
 
 		}
 	}
+	return nil
+}
 
+func (s *streamState) result() *HandleResponse {
 	var val ResponseCompletedEvent
-	data, err := json.Marshal(raw)
+	data, err := json.Marshal(s.raw)
 	if err != nil {
 		panic(err)
 	}
@@ -369,11 +478,165 @@ func Handle(ctx context.Context, req Request, reasoningCallback func(data string
 		panic(err)
 	}
 
-	return &HandleResponse{
-		Text:       answerBuilder.String(),
-		Usage:      &val.Response.Usage,
-		ResponseID: responseID,
-	}, nil
+	res := &HandleResponse{
+		Text:          s.answer.String(),
+		Usage:         &val.Response.Usage,
+		ResponseID:    s.id,
+		Model:         val.Response.Model,
+		Status:        val.Response.Status,
+		FunctionCalls: s.calls,
+	}
+	if val.Response.IncompleteDetails != nil {
+		res.IncompleteReason = val.Response.IncompleteDetails.Reason
+	}
+	return res
+}
+
+var (
+	backgroundPollMin = 2 * time.Second
+	backgroundPollMax = 15 * time.Second
+	backgroundResumes = 5
+)
+
+// handleBackground submits a request in background mode, which outlives any
+// single http connection. streaming requests resume the event stream after
+// a dropped connection, others poll the response until it finishes. the
+// response is cancelled if ctx is cancelled first.
+func handleBackground(ctx context.Context, req Request, reasoningCallback func(data string)) (*HandleResponse, error) {
+	req.Store = true // background mode requires stored responses
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("json marshal error: %w", err)
+	}
+
+	release, err := providers.AcquireRateLimit(ctx, "openai", body)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	resp, err := backgroundRequest(ctx, http.MethodPost, responsesURL, body, req.Stream)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Stream {
+		var stream streamState
+		for attempt := 0; ; attempt++ {
+			readErr := stream.read(ctx, resp.Body, reasoningCallback)
+			_ = resp.Body.Close()
+			if stream.completed {
+				return stream.result(), nil
+			}
+			if ctx.Err() != nil {
+				cancelBackground(stream.id)
+				return nil, ctx.Err()
+			}
+			if readErr != nil && strings.HasPrefix(readErr.Error(), "api stream error") {
+				return nil, readErr
+			}
+			if stream.id == "" || attempt >= backgroundResumes {
+				if readErr == nil {
+					readErr = &providers.StreamError{Err: fmt.Errorf("stream ended before the response completed")}
+				}
+				return nil, readErr
+			}
+			util.Verbosef("openai background stream dropped, resuming %s after event %d", stream.id, stream.sequence)
+			url := fmt.Sprintf("%s/%s?stream=true&starting_after=%d", responsesURL, stream.id, stream.sequence)
+			resp, err = backgroundRequest(ctx, http.MethodGet, url, nil, true)
+			if err != nil {
+				cancelBackground(stream.id)
+				return nil, err
+			}
+		}
+	}
+
+	val, err := decodeResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+	interval := backgroundPollMin
+	for val.Status == "queued" || val.Status == "in_progress" {
+		util.Verbosef("openai background %s is %s", val.ID, val.Status)
+		if err := providers.SleepContext(ctx, interval); err != nil {
+			cancelBackground(val.ID)
+			return nil, err
+		}
+		interval = min(interval*2, backgroundPollMax)
+		resp, err := backgroundRequest(ctx, http.MethodGet, responsesURL+"/"+val.ID, nil, false)
+		if err != nil {
+			if ctx.Err() != nil {
+				cancelBackground(val.ID)
+				return nil, ctx.Err()
+			}
+			// a failed poll does not lose the response, try again
+			util.Verbosef("openai background poll failed: %v", err)
+			continue
+		}
+		next, err := decodeResponse(resp)
+		if err != nil {
+			util.Verbosef("openai background poll failed: %v", err)
+			continue
+		}
+		val = next
+	}
+	if val.Status != "completed" && val.Status != "incomplete" {
+		return nil, fmt.Errorf("background response %s %s: %s", val.ID, val.Status, util.Pformat(map[string]any{"error": val.Error, "incomplete_details": val.IncompleteDetails}))
+	}
+	return responseResult(val)
+}
+
+// backgroundRequest makes a short request against the responses api, the
+// caller closes the body
+func backgroundRequest(ctx context.Context, method, url string, body []byte, stream bool) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	outReq, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return nil, fmt.Errorf("request creation error: %w", err)
+	}
+	outReq.Header.Set("Content-Type", "application/json")
+	outReq.Header.Set("Authorization", "Bearer "+getAuthToken())
+	if stream {
+		outReq.Header.Set("Accept", "text/event-stream")
+	}
+	resp, err := providers.LongTimeoutClient.Do(outReq)
+	if err != nil {
+		return nil, fmt.Errorf("do request error: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		statusErr := providers.NewStatusError(resp)
+		_ = resp.Body.Close()
+		return nil, statusErr
+	}
+	return resp, nil
+}
+
+func decodeResponse(resp *http.Response) (*Response, error) {
+	defer func() { _ = resp.Body.Close() }()
+	var val Response
+	if err := json.NewDecoder(resp.Body).Decode(&val); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+	return &val, nil
+}
+
+// cancelBackground stops a background response so it is not billed to
+// completion after the caller gave up
+func cancelBackground(id string) {
+	if id == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	resp, err := backgroundRequest(ctx, http.MethodPost, responsesURL+"/"+id+"/cancel", nil, false)
+	if err != nil {
+		util.Verbosef("openai background cancel failed: %v", err)
+		return
+	}
+	_ = resp.Body.Close()
 }
 
 // -----------------------------------------------------------------------------
@@ -422,8 +685,23 @@ type BatchIndividualResult struct {
 // HandleBatch sends multiple requests to OpenAI using the Batch API and
 // returns all individual results once the batch has finished processing. The
 // function follows the same calling convention as HandleClaudeBatch so callers
-// can switch providers without changing code.
+// can switch providers without changing code. The batch id is persisted so an
+// interrupted process can resume with WaitBatch.
 func HandleBatch(ctx context.Context, requests []BatchRequestItem) ([]BatchIndividualResult, error) {
+	batchResp, err := CreateBatch(ctx, requests)
+	if err != nil {
+		return nil, err
+	}
+	batchResp, err = WaitBatch(ctx, batchResp.ID)
+	if err != nil {
+		return nil, err
+	}
+	return FetchBatchResults(ctx, batchResp)
+}
+
+// CreateBatch uploads the requests as a jsonl file, creates a batch from it,
+// and records the batch id on disk
+func CreateBatch(ctx context.Context, requests []BatchRequestItem) (*BatchResponse, error) {
 
 	// ---------------------------------------------------------------------
 	// Build the JSONL file content (one request per line)
@@ -509,44 +787,60 @@ func HandleBatch(ctx context.Context, requests []BatchRequestItem) ([]BatchIndiv
 		return nil, fmt.Errorf("marshal batch create body: %v", err)
 	}
 
-	createReq, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://api.openai.com/v1/batches", bytes.NewBuffer(createBody))
-	if err != nil {
-		return nil, fmt.Errorf("batch create request creation: %v", err)
+	var batchResp BatchResponse
+	if err := doBatchRequest(ctx, http.MethodPost, "https://api.openai.com/v1/batches", bytes.NewBuffer(createBody), &batchResp); err != nil {
+		return nil, fmt.Errorf("batch create: %v", err)
 	}
-	createReq.Header.Set("Authorization", "Bearer "+getAuthToken())
-	createReq.Header.Set("Content-Type", "application/json")
 
-	createResp, err := providers.LongTimeoutClient.Do(createReq)
-	if err != nil {
-		return nil, fmt.Errorf("batch create request error: %v", err)
+	fmt.Printf("Created OpenAI batch %s with %d requests\n", batchResp.ID, len(requests))
+	if err := providers.SaveBatch("openai", batchResp.ID, batchResp.Status, len(requests)); err != nil {
+		util.Errorf("warning: failed to save batch %s: %v", batchResp.ID, err)
 	}
-	defer func() { _ = createResp.Body.Close() }()
+	return &batchResp, nil
+}
 
-	if createResp.StatusCode != http.StatusOK {
-		resBody, _ := io.ReadAll(createResp.Body)
-		return nil, fmt.Errorf("batch create api error: %s", string(resBody))
+// GetBatch returns the current state of a batch
+func GetBatch(ctx context.Context, id string) (*BatchResponse, error) {
+	var batchResp BatchResponse
+	if err := doBatchRequest(ctx, http.MethodGet, fmt.Sprintf("https://api.openai.com/v1/batches/%s", id), nil, &batchResp); err != nil {
+		return nil, fmt.Errorf("status: %v", err)
 	}
+	_ = providers.UpdateBatchStatus(id, batchResp.Status)
+	return &batchResp, nil
+}
 
+// CancelBatch requests cancellation of a batch, requests already processed
+// still produce results
+func CancelBatch(ctx context.Context, id string) (*BatchResponse, error) {
 	var batchResp BatchResponse
-	if err := json.NewDecoder(createResp.Body).Decode(&batchResp); err != nil {
-		return nil, fmt.Errorf("decode batch create response: %v", err)
+	if err := doBatchRequest(ctx, http.MethodPost, fmt.Sprintf("https://api.openai.com/v1/batches/%s/cancel", id), nil, &batchResp); err != nil {
+		return nil, fmt.Errorf("cancel: %v", err)
 	}
+	_ = providers.UpdateBatchStatus(id, batchResp.Status)
+	return &batchResp, nil
+}
 
-	start := time.Now()
-	fmt.Printf("Created OpenAI batch %s with %d requests\n", batchResp.ID, len(requests))
+// IsBatchDone reports whether a batch status is terminal
+func IsBatchDone(status string) bool {
+	switch status {
+	case "completed", "failed", "cancelled", "expired":
+		return true
+	}
+	return false
+}
 
-	// ---------------------------------------------------------------------
-	// Poll for completion
-	// ---------------------------------------------------------------------
+// WaitBatch polls a batch until it reaches a terminal status or ctx is cancelled
+func WaitBatch(ctx context.Context, id string) (*BatchResponse, error) {
+	start := time.Now()
 	for {
-		select {
-		case <-ctx.Done():
-			return nil, ctx.Err()
-		default:
+		batchResp, err := GetBatch(ctx, id)
+		if err != nil {
+			return nil, err
 		}
 
-		if batchResp.Status == "completed" || batchResp.Status == "failed" || batchResp.Status == "cancelled" {
-			break
+		if IsBatchDone(batchResp.Status) {
+			fmt.Printf("Batch %s completed with status %s\n", batchResp.ID, batchResp.Status)
+			return batchResp, nil
 		}
 
 		elapsed := time.Since(start).Seconds()
@@ -557,42 +851,35 @@ func HandleBatch(ctx context.Context, requests []BatchRequestItem) ([]BatchIndiv
 			batchResp.RequestCounts.Failed,
 			elapsed)
 
-		time.Sleep(5 * time.Second)
-
-		statusReq, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("https://api.openai.com/v1/batches/%s", batchResp.ID), nil)
-		if err != nil {
-			return nil, fmt.Errorf("status request creation: %v", err)
+		if err := providers.SleepContext(ctx, 5*time.Second); err != nil {
+			return nil, err
 		}
-		statusReq.Header.Set("Authorization", "Bearer "+getAuthToken())
+	}
+}
 
-		statusResp, err := providers.LongTimeoutClient.Do(statusReq)
-		if err != nil {
-			return nil, fmt.Errorf("status request error: %v", err)
-		}
+// FetchBatchResults downloads and parses the output and error files of a
+// finished batch, so failed requests are returned alongside successful ones
+func FetchBatchResults(ctx context.Context, batchResp *BatchResponse) ([]BatchIndividualResult, error) {
+	if batchResp.OutputFileID == nil && batchResp.ErrorFileID == nil {
+		return nil, fmt.Errorf("no output file ID available")
+	}
 
-		if statusResp.StatusCode != http.StatusOK {
-			resBody, _ := io.ReadAll(statusResp.Body)
-			_ = statusResp.Body.Close()
-			return nil, fmt.Errorf("status api error: %s", string(resBody))
+	var results []BatchIndividualResult
+	for _, fileID := range []*string{batchResp.OutputFileID, batchResp.ErrorFileID} {
+		if fileID == nil {
+			continue
 		}
-
-		if err := json.NewDecoder(statusResp.Body).Decode(&batchResp); err != nil {
-			_ = statusResp.Body.Close()
-			return nil, fmt.Errorf("decode status response: %v", err)
+		fileResults, err := fetchBatchFile(ctx, *fileID)
+		if err != nil {
+			return nil, err
 		}
-		_ = statusResp.Body.Close()
-	}
-
-	fmt.Printf("Batch %s completed with status %s\n", batchResp.ID, batchResp.Status)
-
-	if batchResp.OutputFileID == nil {
-		return nil, fmt.Errorf("no output file ID available")
+		results = append(results, fileResults...)
 	}
+	return results, nil
+}
 
-	// ---------------------------------------------------------------------
-	// Download output file and parse JSONL results
-	// ---------------------------------------------------------------------
-	outputReq, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("https://api.openai.com/v1/files/%s/content", *batchResp.OutputFileID), nil)
+func fetchBatchFile(ctx context.Context, fileID string) ([]BatchIndividualResult, error) {
+	outputReq, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("https://api.openai.com/v1/files/%s/content", fileID), nil)
 	if err != nil {
 		return nil, fmt.Errorf("output file request creation: %v", err)
 	}
@@ -632,3 +919,28 @@ func HandleBatch(ctx context.Context, requests []BatchRequestItem) ([]BatchIndiv
 
 	return results, nil
 }
+
+// doBatchRequest performs an authenticated batch api call and decodes the json response
+func doBatchRequest(ctx context.Context, method, url string, body io.Reader, out any) error {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return fmt.Errorf("request creation: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+getAuthToken())
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := providers.LongTimeoutClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("request error: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		resBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("api error: %s", string(resBody))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decode response: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHandleBackgroundPolls(t *testing.T) {
+	polls := 0
+	var submitted map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/v1/responses":
+			_ = json.NewDecoder(r.Body).Decode(&submitted)
+			_, _ = fmt.Fprint(w, `{"id": "resp_1", "status": "queued"}`)
+		case r.Method == http.MethodGet && r.URL.Path == "/v1/responses/resp_1":
+			polls++
+			if polls < 2 {
+				_, _ = fmt.Fprint(w, `{"id": "resp_1", "status": "in_progress"}`)
+				return
+			}
+			_, _ = fmt.Fprint(w, `{"id": "resp_1", "status": "completed", "output": [{"type": "message", "content": [{"type": "output_text", "text": "done"}]}], "usage": {"input_tokens": 3, "output_tokens": 5}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	t.Setenv("NINA_OPENAI_BASE_URL", server.URL+"/v1")
+	t.Setenv("OPENAI_API_KEY", "test")
+	saved := backgroundPollMin
+	backgroundPollMin = time.Millisecond
+	defer func() { backgroundPollMin = saved }()
+
+	resp, err := Handle(context.Background(), Request{Model: "o3-pro", Background: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Text != "done" || resp.ResponseID != "resp_1" || resp.Usage.OutputTokens != 5 {
+		t.Errorf("resp = %+v", resp)
+	}
+	if submitted["background"] != true || submitted["store"] != true {
+		t.Errorf("submitted = %v, want background and store", submitted)
+	}
+	if polls != 2 {
+		t.Errorf("polls = %d, want 2", polls)
+	}
+}
+
+func TestHandleBackgroundResumesStream(t *testing.T) {
+	var resumedAfter string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		if r.Method == http.MethodPost {
+			// the connection drops before the response completes
+			_, _ = fmt.Fprint(w, "data: {\"type\": \"response.created\", \"sequence_number\": 1, \"response\": {\"id\": \"resp_2\"}}\n\n")
+			_, _ = fmt.Fprint(w, "data: {\"type\": \"response.output_text.delta\", \"sequence_number\": 2, \"delta\": \"hel\"}\n\n")
+			return
+		}
+		resumedAfter = r.URL.Query().Get("starting_after")
+		_, _ = fmt.Fprint(w, "data: {\"type\": \"response.output_text.delta\", \"sequence_number\": 3, \"delta\": \"lo\"}\n\n")
+		_, _ = fmt.Fprint(w, "data: {\"type\": \"response.completed\", \"sequence_number\": 4, \"response\": {\"usage\": {\"output_tokens\": 2}}}\n\n")
+	}))
+	defer server.Close()
+	t.Setenv("NINA_OPENAI_BASE_URL", server.URL+"/v1")
+	t.Setenv("OPENAI_API_KEY", "test")
+
+	resp, err := Handle(context.Background(), Request{Model: "o3-pro", Background: true, Stream: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Text != "hello" || resp.ResponseID != "resp_2" || resp.Usage.OutputTokens != 2 {
+		t.Errorf("resp = %+v", resp)
+	}
+	if resumedAfter != "2" {
+		t.Errorf("resumed after %q, want 2", resumedAfter)
+	}
+}
+
+func TestHandleStreamIncomplete(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = fmt.Fprint(w, "data: {\"type\": \"response.created\", \"response\": {\"id\": \"resp_3\"}}\n\n")
+		_, _ = fmt.Fprint(w, "data: {\"type\": \"response.output_text.delta\", \"delta\": \"cut\"}\n\n")
+		_, _ = fmt.Fprint(w, "data: {\"type\": \"response.incomplete\", \"response\": {\"model\": \"o3-2025-04-16\", \"status\": \"incomplete\", \"incomplete_details\": {\"reason\": \"max_output_tokens\"}, \"usage\": {\"output_tokens\": 7}}}\n\n")
+	}))
+	defer server.Close()
+	t.Setenv("NINA_OPENAI_BASE_URL", server.URL+"/v1")
+	t.Setenv("OPENAI_API_KEY", "test")
+
+	resp, err := Handle(context.Background(), Request{Model: "o3", Stream: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Text != "cut" || resp.Status != "incomplete" || resp.IncompleteReason != "max_output_tokens" || resp.Model != "o3-2025-04-16" || resp.Usage.OutputTokens != 7 {
+		t.Errorf("resp = %+v", resp)
+	}
+}
+
+func TestHandleStreamFunctionCalls(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = fmt.Fprint(w, "data: {\"type\": \"response.created\", \"response\": {\"id\": \"resp_4\"}}\n\n")
+		_, _ = fmt.Fprint(w, "data: {\"type\": \"response.output_item.done\", \"item\": {\"type\": \"function_call\", \"call_id\": \"call_1\", \"name\": \"NinaBash\", \"arguments\": \"{\\\"command\\\": \\\"ls\\\"}\"}}\n\n")
+		_, _ = fmt.Fprint(w, "data: {\"type\": \"response.completed\", \"response\": {\"model\": \"o3\", \"status\": \"completed\", \"usage\": {\"output_tokens\": 3}}}\n\n")
+	}))
+	defer server.Close()
+	t.Setenv("NINA_OPENAI_BASE_URL", server.URL+"/v1")
+	t.Setenv("OPENAI_API_KEY", "test")
+
+	resp, err := Handle(context.Background(), Request{Model: "o3", Stream: true, Tools: []Tool{{Type: "function", Name: "NinaBash"}}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := FunctionCall{CallID: "call_1", Name: "NinaBash", Arguments: `{"command": "ls"}`}
+	if len(resp.FunctionCalls) != 1 || resp.FunctionCalls[0] != want {
+		t.Errorf("function calls = %+v, want %+v", resp.FunctionCalls, want)
+	}
+
+	// a response holding only function calls has no message output
+	result, err := responseResult(&Response{Status: "completed", Output: []Output{{Type: "function_call", CallID: "call_2", Name: "NinaRead", Arguments: "{}"}}})
+	if err != nil || len(result.FunctionCalls) != 1 || result.FunctionCalls[0].CallID != "call_2" {
+		t.Errorf("result = %+v, %v", result, err)
+	}
+}
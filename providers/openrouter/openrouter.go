@@ -165,6 +165,12 @@ func HandleOpenRouterChat(ctx context.Context, req Request, reasoningCallback fu
 		outReq.Header.Set("Accept", "text/event-stream")
 	}
 
+	release, err := providers.AcquireRateLimit(ctx, "openrouter", body)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
 	client := providers.LongTimeoutClient
 	resp, err := client.Do(outReq)
 	if err != nil {
@@ -173,8 +179,7 @@ func HandleOpenRouterChat(ctx context.Context, req Request, reasoningCallback fu
 	defer func() { _ = resp.Body.Close() }()
 
 	if resp.StatusCode != http.StatusOK {
-		resBody, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("api error: %s", string(resBody))
+		return "", providers.NewStatusError(resp)
 	}
 
 	if !req.Stream {
@@ -232,7 +237,7 @@ func HandleOpenRouterChat(ctx context.Context, req Request, reasoningCallback fu
 			if ctx.Err() != nil {
 				return "", ctx.Err()
 			}
-			return "", fmt.Errorf("stream read error: %w", err)
+			return "", &providers.StreamError{Err: fmt.Errorf("stream read error: %w", err)}
 		}
 
 		line = strings.TrimSpace(line)
@@ -14,19 +14,16 @@ var (
 
 func InitAllHTTPClients() {
 	httpClientsOnce.Do(func() {
-		transport := &http.Transport{
-			MaxIdleConns:        100,
-			MaxIdleConnsPerHost: 10,
-			IdleConnTimeout:     90 * time.Second,
-		}
+		Use("", Logging())
+		roundTripper := middlewareTransport{next: cassetteFromEnv(&lazyTransport{})}
+		// request and idle timeouts are per provider, see timeouts.go
 		LongTimeoutClient = &http.Client{
-			Timeout:   15 * time.Minute,
-			Transport: transport,
+			Transport: roundTripper,
 		}
 
 		ShortTimeoutClient = &http.Client{
 			Timeout:   3 * time.Minute,
-			Transport: transport,
+			Transport: roundTripper,
 		}
 	})
 }
@@ -0,0 +1,143 @@
+package providers
+
+// network configuration for corporate environments, read from the
+// environment when the first request is made so global flags can set it:
+//
+//	NINA_PROXY                  proxy url for every request, overrides HTTPS_PROXY
+//	NINA_CA_BUNDLE              pem file of extra root certificates to trust
+//	NINA_<PROVIDER>_BASE_URL    replaces the provider's api base url, e.g. an
+//	                            openai compatible gateway like litellm
+//
+// without NINA_PROXY the standard HTTPS_PROXY, HTTP_PROXY, and NO_PROXY
+// variables are honored.
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultBaseURLs are the api roots that NINA_<PROVIDER>_BASE_URL replaces
+var defaultBaseURLs = map[string]string{
+	"claude":     "https://api.anthropic.com",
+	"openai":     "https://api.openai.com/v1",
+	"gemini":     "https://generativelanguage.googleapis.com",
+	"grok":       "https://api.x.ai/v1",
+	"groq":       "https://api.groq.com/openai/v1",
+	"openrouter": "https://openrouter.ai/api/v1",
+	"deepseek":   "https://api.deepseek.com",
+}
+
+// proxyFromEnv returns NINA_PROXY when set, otherwise the standard proxy
+// environment variables apply
+func proxyFromEnv(req *http.Request) (*url.URL, error) {
+	if value := os.Getenv("NINA_PROXY"); value != "" {
+		proxy, err := url.Parse(value)
+		if err != nil {
+			return nil, fmt.Errorf("NINA_PROXY: %w", err)
+		}
+		return proxy, nil
+	}
+	return http.ProxyFromEnvironment(req)
+}
+
+// tlsConfigFromEnv returns a tls config trusting the system roots plus
+// NINA_CA_BUNDLE, or nil when no bundle is configured
+func tlsConfigFromEnv() (*tls.Config, error) {
+	path := os.Getenv("NINA_CA_BUNDLE")
+	if path == "" {
+		return nil, nil
+	}
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("NINA_CA_BUNDLE: %w", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("NINA_CA_BUNDLE: no certificates found in %s", path)
+	}
+	return &tls.Config{RootCAs: pool}, nil
+}
+
+// newTransport builds the shared transport from the current environment
+func newTransport() http.RoundTripper {
+	tlsConfig, err := tlsConfigFromEnv()
+	if err != nil {
+		// fail every request rather than silently ignoring the bundle
+		return failingTransport{err}
+	}
+	return &http.Transport{
+		Proxy:               proxyFromEnv,
+		DialContext:         dialContext,
+		TLSClientConfig:     tlsConfig,
+		TLSHandshakeTimeout: HTTPConfigFor("").ConnectTimeout,
+		ForceAttemptHTTP2:   true,
+		MaxIdleConns:        envInt("NINA_HTTP_MAX_IDLE_CONNS", 100),
+		MaxIdleConnsPerHost: envInt("NINA_HTTP_MAX_IDLE_CONNS_PER_HOST", 10),
+		IdleConnTimeout:     envDuration("NINA_HTTP_IDLE_CONN_TIMEOUT", 90*time.Second),
+	}
+}
+
+// lazyTransport builds its transport on first use, after flags have been
+// parsed, since the shared clients are created from package init
+type lazyTransport struct {
+	once sync.Once
+	rt   http.RoundTripper
+}
+
+func (t *lazyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.once.Do(func() { t.rt = newTransport() })
+	return t.rt.RoundTrip(req)
+}
+
+// BaseURL returns the api base url for a provider, honoring
+// NINA_<PROVIDER>_BASE_URL
+func BaseURL(provider string) string {
+	if value := os.Getenv("NINA_" + strings.ToUpper(provider) + "_BASE_URL"); value != "" {
+		return strings.TrimSuffix(value, "/")
+	}
+	return defaultBaseURLs[provider]
+}
+
+// baseURLFromEnv builds a middleware that moves requests from the provider's
+// default base url to NINA_<PROVIDER>_BASE_URL, or nil when not overridden
+func baseURLFromEnv(provider string) Middleware {
+	def, ok := defaultBaseURLs[provider]
+	if !ok || BaseURL(provider) == def {
+		return nil
+	}
+	from, err := url.Parse(def)
+	if err != nil {
+		return nil
+	}
+	to, err := url.Parse(BaseURL(provider))
+	if err != nil {
+		return func(http.RoundTripper) http.RoundTripper {
+			return failingTransport{fmt.Errorf("NINA_%s_BASE_URL: %w", strings.ToUpper(provider), err)}
+		}
+	}
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			rest, ok := strings.CutPrefix(req.URL.Path, from.Path)
+			if !ok {
+				return next.RoundTrip(req)
+			}
+			req = req.Clone(req.Context())
+			req.URL.Scheme = to.Scheme
+			req.URL.Host = to.Host
+			req.URL.Path = strings.TrimSuffix(to.Path, "/") + rest
+			req.URL.RawPath = ""
+			req.Host = to.Host
+			return next.RoundTrip(req)
+		})
+	}
+}
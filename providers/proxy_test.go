@@ -0,0 +1,55 @@
+package providers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBaseURLOverride(t *testing.T) {
+	var path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+	}))
+	defer server.Close()
+	t.Setenv("NINA_OPENAI_BASE_URL", server.URL+"/litellm/v1/")
+
+	client := &http.Client{Transport: middlewareTransport{next: http.DefaultTransport}}
+	resp, err := client.Post("https://api.openai.com/v1/responses", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if path != "/litellm/v1/responses" {
+		t.Errorf("path = %q, want /litellm/v1/responses", path)
+	}
+	if got := BaseURL("openai"); got != server.URL+"/litellm/v1" {
+		t.Errorf("BaseURL() = %q", got)
+	}
+}
+
+func TestProxyFromEnv(t *testing.T) {
+	t.Setenv("NINA_PROXY", "http://proxy.corp:3128")
+	req, _ := http.NewRequest("GET", "https://api.anthropic.com/v1/messages", nil)
+	proxy, err := proxyFromEnv(req)
+	if err != nil || proxy == nil || proxy.Host != "proxy.corp:3128" {
+		t.Errorf("proxyFromEnv() = %v, %v", proxy, err)
+	}
+}
+
+func TestCABundleWithoutCertificates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bundle.pem")
+	if err := os.WriteFile(path, []byte("not a certificate"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("NINA_CA_BUNDLE", path)
+	if _, err := tlsConfigFromEnv(); err == nil {
+		t.Error("expected an error for a bundle without certificates")
+	}
+	req, _ := http.NewRequest("GET", "https://api.anthropic.com", nil)
+	if _, err := newTransport().RoundTrip(req); err == nil {
+		t.Error("expected requests to fail with a bad bundle")
+	}
+}
@@ -0,0 +1,186 @@
+package providers
+
+// shared per provider rate limiting so concurrent callers back off locally
+// instead of triggering 429 storms. each provider gets a token bucket for
+// requests/min, a token bucket for tokens/min, and a concurrency cap, all
+// configured from the environment:
+//
+//	NINA_<PROVIDER>_RPM          requests per minute, 0 for unlimited
+//	NINA_<PROVIDER>_TPM          estimated tokens per minute, 0 for unlimited
+//	NINA_<PROVIDER>_CONCURRENCY  max in-flight requests, 0 for unlimited
+//
+// where provider is CLAUDE, OPENAI, GEMINI, GROK, GROQ, OLLAMA, or OPENROUTER.
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nathants/nina/util"
+)
+
+// defaultConcurrency bounds in-flight requests per provider when not configured
+const defaultConcurrency = 8
+
+// bucket is a token bucket refilled continuously at ratePerSec up to capacity
+type bucket struct {
+	capacity   float64
+	available  float64
+	ratePerSec float64
+	last       time.Time
+}
+
+func newBucket(perMinute int, now time.Time) *bucket {
+	if perMinute <= 0 {
+		return nil
+	}
+	return &bucket{
+		capacity:   float64(perMinute),
+		available:  float64(perMinute),
+		ratePerSec: float64(perMinute) / 60,
+		last:       now,
+	}
+}
+
+func (b *bucket) refill(now time.Time) {
+	b.available = min(b.capacity, b.available+now.Sub(b.last).Seconds()*b.ratePerSec)
+	b.last = now
+}
+
+// wait returns how long until n units are available. Requests larger than the
+// bucket are clamped to its capacity so they can eventually proceed.
+func (b *bucket) wait(n float64) time.Duration {
+	n = min(n, b.capacity)
+	if b.available >= n {
+		return 0
+	}
+	return time.Duration((n - b.available) / b.ratePerSec * float64(time.Second))
+}
+
+func (b *bucket) take(n float64) {
+	b.available -= min(n, b.capacity)
+}
+
+// Limiter rate limits calls to a single provider
+type Limiter struct {
+	mu       sync.Mutex
+	requests *bucket
+	tokens   *bucket
+	slots    chan struct{}
+	// clock, replaced in tests
+	now   func() time.Time
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// NewLimiter creates a limiter, zero values disable that limit
+func NewLimiter(requestsPerMinute, tokensPerMinute, concurrency int) *Limiter {
+	return newLimiter(requestsPerMinute, tokensPerMinute, concurrency, time.Now, SleepContext)
+}
+
+func newLimiter(requestsPerMinute, tokensPerMinute, concurrency int, now func() time.Time, sleep func(context.Context, time.Duration) error) *Limiter {
+	l := &Limiter{
+		requests: newBucket(requestsPerMinute, now()),
+		tokens:   newBucket(tokensPerMinute, now()),
+		now:      now,
+		sleep:    sleep,
+	}
+	if concurrency > 0 {
+		l.slots = make(chan struct{}, concurrency)
+	}
+	return l
+}
+
+// Acquire blocks until a request of estimatedTokens may be sent, returning a
+// release func that must be called when the request completes
+func (l *Limiter) Acquire(ctx context.Context, estimatedTokens int) (func(), error) {
+	if l.slots != nil {
+		select {
+		case l.slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	release := func() {
+		if l.slots != nil {
+			<-l.slots
+		}
+	}
+
+	for {
+		l.mu.Lock()
+		now := l.now()
+		var wait time.Duration
+		if l.requests != nil {
+			l.requests.refill(now)
+			wait = max(wait, l.requests.wait(1))
+		}
+		if l.tokens != nil {
+			l.tokens.refill(now)
+			wait = max(wait, l.tokens.wait(float64(estimatedTokens)))
+		}
+		if wait == 0 {
+			if l.requests != nil {
+				l.requests.take(1)
+			}
+			if l.tokens != nil {
+				l.tokens.take(float64(estimatedTokens))
+			}
+			l.mu.Unlock()
+			return release, nil
+		}
+		l.mu.Unlock()
+		if err := l.sleep(ctx, wait); err != nil {
+			release()
+			return nil, err
+		}
+	}
+}
+
+var (
+	limiters   = map[string]*Limiter{}
+	limitersMu sync.Mutex
+)
+
+// LimiterFor returns the shared limiter for a provider, configured from env
+func LimiterFor(provider string) *Limiter {
+	limitersMu.Lock()
+	defer limitersMu.Unlock()
+	if l, ok := limiters[provider]; ok {
+		return l
+	}
+	prefix := "NINA_" + strings.ToUpper(provider) + "_"
+	l := NewLimiter(
+		envInt(prefix+"RPM", 0),
+		envInt(prefix+"TPM", 0),
+		envInt(prefix+"CONCURRENCY", defaultConcurrency),
+	)
+	limiters[provider] = l
+	return l
+}
+
+// AcquireRateLimit waits for the provider's limiter, estimating tokens from
+// the request body size at roughly four bytes per token
+func AcquireRateLimit(ctx context.Context, provider string, body []byte) (func(), error) {
+	release, err := LimiterFor(provider).Acquire(ctx, len(body)/4)
+	if err != nil {
+		return nil, fmt.Errorf("%s rate limit: %w", provider, err)
+	}
+	return release, nil
+}
+
+func envInt(name string, def int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		util.Errorf("warning: ignoring invalid %s=%s", name, value)
+		return def
+	}
+	return n
+}
@@ -0,0 +1,147 @@
+package providers
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// fakeClock advances only when the limiter sleeps, recording each wait
+type fakeClock struct {
+	now   time.Time
+	waits []time.Duration
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Sleep(ctx context.Context, d time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.waits = append(c.waits, d)
+	c.now = c.now.Add(d)
+	return nil
+}
+
+func TestLimiterRefill(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	l := newLimiter(60, 0, 0, clock.Now, clock.Sleep)
+	for range 60 {
+		release, err := l.Acquire(context.Background(), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		release()
+	}
+	if len(clock.waits) != 0 {
+		t.Fatalf("the first 60 requests waited %v, want none", clock.waits)
+	}
+	if _, err := l.Acquire(context.Background(), 0); err != nil {
+		t.Fatal(err)
+	}
+	if len(clock.waits) != 1 || clock.waits[0] != time.Second {
+		t.Errorf("request 61 waited %v, want 1s for one request to refill at 60/min", clock.waits)
+	}
+
+	clock.now = clock.now.Add(time.Hour)
+	clock.waits = nil
+	for range 60 {
+		if _, err := l.Acquire(context.Background(), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(clock.waits) != 0 {
+		t.Errorf("after an idle hour requests waited %v, the bucket should refill to its capacity only", clock.waits)
+	}
+}
+
+func TestLimiterTokens(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	l := newLimiter(0, 6000, 0, clock.Now, clock.Sleep)
+	if _, err := l.Acquire(context.Background(), 3000); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Acquire(context.Background(), 6000); err != nil {
+		t.Fatal(err)
+	}
+	if len(clock.waits) != 1 || clock.waits[0] != 30*time.Second {
+		t.Errorf("waits = %v, want 30s for 3000 tokens at 6000/min", clock.waits)
+	}
+	clock.waits = nil
+	if _, err := l.Acquire(context.Background(), 100000); err != nil {
+		t.Fatal(err)
+	}
+	if len(clock.waits) != 1 || clock.waits[0] != time.Minute {
+		t.Errorf("waits = %v, want a request over capacity clamped to a full minute", clock.waits)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := l.Acquire(ctx, 6000); err == nil {
+		t.Error("expected an error waiting with a cancelled context")
+	}
+}
+
+func TestLimiterConcurrency(t *testing.T) {
+	l := NewLimiter(0, 0, 2)
+	first, err := l.Acquire(context.Background(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Acquire(context.Background(), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := l.Acquire(ctx, 0); err == nil {
+		t.Fatal("a third request should wait for a slot")
+	}
+
+	acquired := make(chan error)
+	go func() {
+		_, err := l.Acquire(context.Background(), 0)
+		acquired <- err
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("acquired a slot before one was released")
+	case <-time.After(20 * time.Millisecond):
+	}
+	first()
+	select {
+	case err := <-acquired:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("release did not free a slot")
+	}
+}
+
+func TestLimiterFor(t *testing.T) {
+	t.Setenv("NINA_LIMITTEST_RPM", "120")
+	t.Setenv("NINA_LIMITTEST_TPM", "lots")
+	l := LimiterFor("limittest")
+	if l.requests == nil || l.requests.capacity != 120 || l.requests.ratePerSec != 2 {
+		t.Errorf("requests = %+v, want 120/min", l.requests)
+	}
+	if l.tokens != nil {
+		t.Errorf("an invalid TPM should leave tokens unlimited, got %+v", l.tokens)
+	}
+	if cap(l.slots) != defaultConcurrency {
+		t.Errorf("concurrency = %d, want the default %d", cap(l.slots), defaultConcurrency)
+	}
+	if LimiterFor("limittest") != l {
+		t.Error("LimiterFor should return the shared limiter")
+	}
+
+	t.Setenv("NINA_LIMITTEST2_TPM", "90000")
+	t.Setenv("NINA_LIMITTEST2_CONCURRENCY", "0")
+	l = LimiterFor("limittest2")
+	if l.requests != nil || l.tokens == nil || l.tokens.capacity != 90000 || l.slots != nil {
+		t.Errorf("limiter = %+v, want only a 90000/min token limit", l)
+	}
+}
@@ -0,0 +1,183 @@
+package providers
+
+// per provider network timeouts, configured from the environment with
+// NINA_HTTP_* as the fallback for every provider:
+//
+//	NINA_<PROVIDER>_CONNECT_TIMEOUT  dial and tls handshake, default 30s
+//	NINA_<PROVIDER>_REQUEST_TIMEOUT  whole request including the body, default 15m
+//	NINA_<PROVIDER>_IDLE_TIMEOUT     max gap between reads of a streaming body, default 5m
+//
+// durations use go syntax like 90s or 20m, 0 disables a timeout. openai flex
+// requests can queue for a long time, so they use NINA_OPENAI_FLEX_TIMEOUT,
+// default 45m, as their request timeout. the connection pool is shared:
+//
+//	NINA_HTTP_MAX_IDLE_CONNS           default 100
+//	NINA_HTTP_MAX_IDLE_CONNS_PER_HOST  default 10
+//	NINA_HTTP_IDLE_CONN_TIMEOUT        default 90s
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nathants/nina/util"
+)
+
+const (
+	defaultConnectTimeout = 30 * time.Second
+	defaultRequestTimeout = 15 * time.Minute
+	defaultIdleTimeout    = 5 * time.Minute
+	defaultFlexTimeout    = 45 * time.Minute
+)
+
+// HTTPConfig holds the network timeouts for one provider
+type HTTPConfig struct {
+	ConnectTimeout time.Duration
+	RequestTimeout time.Duration
+	IdleTimeout    time.Duration
+}
+
+// HTTPConfigFor returns the timeouts for a provider, "" for unknown hosts
+func HTTPConfigFor(provider string) HTTPConfig {
+	setting := func(name string, def time.Duration) time.Duration {
+		def = envDuration("NINA_HTTP_"+name, def)
+		if provider == "" {
+			return def
+		}
+		return envDuration("NINA_"+strings.ToUpper(provider)+"_"+name, def)
+	}
+	return HTTPConfig{
+		ConnectTimeout: setting("CONNECT_TIMEOUT", defaultConnectTimeout),
+		RequestTimeout: setting("REQUEST_TIMEOUT", defaultRequestTimeout),
+		IdleTimeout:    setting("IDLE_TIMEOUT", defaultIdleTimeout),
+	}
+}
+
+// FlexTimeout is the request timeout for openai flex tier requests
+func FlexTimeout() time.Duration {
+	return envDuration("NINA_OPENAI_FLEX_TIMEOUT", defaultFlexTimeout)
+}
+
+type requestTimeoutKey struct{}
+
+// WithRequestTimeout overrides the provider's request timeout for requests
+// made with the returned context, 0 disables it
+func WithRequestTimeout(ctx context.Context, timeout time.Duration) context.Context {
+	return context.WithValue(ctx, requestTimeoutKey{}, timeout)
+}
+
+// dialContext dials with the connect timeout of the provider being dialed,
+// when going through a proxy that is the default timeout
+func dialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	dialer := net.Dialer{
+		Timeout:   HTTPConfigFor(ProviderForHost(addr)).ConnectTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+	return dialer.DialContext(ctx, network, addr)
+}
+
+// timeoutMiddleware enforces the request and idle timeouts, reporting which
+// one fired instead of a bare context cancellation
+func timeoutMiddleware(provider string, cfg HTTPConfig) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			requestTimeout := cfg.RequestTimeout
+			if d, ok := req.Context().Value(requestTimeoutKey{}).(time.Duration); ok {
+				requestTimeout = d
+			}
+			if requestTimeout <= 0 && cfg.IdleTimeout <= 0 {
+				return next.RoundTrip(req)
+			}
+			name := provider
+			if name == "" {
+				name = req.URL.Host
+			}
+			ctx, cancel := context.WithCancelCause(req.Context())
+			var deadline *time.Timer
+			if requestTimeout > 0 {
+				deadline = time.AfterFunc(requestTimeout, func() {
+					cancel(fmt.Errorf("%s request timed out after %s", name, requestTimeout))
+				})
+			}
+			stop := func() {
+				if deadline != nil {
+					deadline.Stop()
+				}
+				cancel(nil)
+			}
+			resp, err := next.RoundTrip(req.WithContext(ctx))
+			if err != nil {
+				stop()
+				if cause := context.Cause(ctx); cause != nil && ctx.Err() != nil && req.Context().Err() == nil {
+					return nil, cause
+				}
+				return nil, err
+			}
+			body := &idleBody{ReadCloser: resp.Body, ctx: ctx, parent: req.Context(), stop: stop}
+			if cfg.IdleTimeout > 0 {
+				idle := cfg.IdleTimeout
+				body.idle = time.AfterFunc(idle, func() {
+					cancel(fmt.Errorf("%s response idle for %s", name, idle))
+				})
+				body.timeout = idle
+			}
+			resp.Body = body
+			return resp, nil
+		})
+	}
+}
+
+// idleBody resets the idle timer on each read and releases the timers on
+// close
+type idleBody struct {
+	io.ReadCloser
+	ctx     context.Context
+	parent  context.Context
+	idle    *time.Timer
+	timeout time.Duration
+	stop    func()
+	once    sync.Once
+}
+
+func (b *idleBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if b.idle != nil && n > 0 {
+		b.idle.Reset(b.timeout)
+	}
+	if err != nil && err != io.EOF && b.ctx.Err() != nil && b.parent.Err() == nil {
+		if cause := context.Cause(b.ctx); cause != nil {
+			return n, cause
+		}
+	}
+	return n, err
+}
+
+func (b *idleBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() {
+		if b.idle != nil {
+			b.idle.Stop()
+		}
+		b.stop()
+	})
+	return err
+}
+
+func envDuration(name string, def time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		util.Errorf("warning: ignoring invalid %s=%s", name, value)
+		return def
+	}
+	return d
+}
@@ -0,0 +1,76 @@
+package providers
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHTTPConfigFor(t *testing.T) {
+	t.Setenv("NINA_HTTP_IDLE_TIMEOUT", "1m")
+	t.Setenv("NINA_CLAUDE_IDLE_TIMEOUT", "2m")
+	t.Setenv("NINA_OPENAI_REQUEST_TIMEOUT", "bogus")
+	if got := HTTPConfigFor("claude").IdleTimeout; got != 2*time.Minute {
+		t.Errorf("claude idle = %s, want 2m", got)
+	}
+	if got := HTTPConfigFor("gemini").IdleTimeout; got != time.Minute {
+		t.Errorf("gemini idle = %s, want the NINA_HTTP fallback of 1m", got)
+	}
+	if got := HTTPConfigFor("openai").RequestTimeout; got != defaultRequestTimeout {
+		t.Errorf("openai request = %s, want the default for an invalid value", got)
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("data: first\n\n"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	rt := Chain(http.DefaultTransport, timeoutMiddleware("test", HTTPConfig{IdleTimeout: 50 * time.Millisecond}))
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	_, err = io.ReadAll(resp.Body)
+	if err == nil || !strings.Contains(err.Error(), "test response idle for 50ms") {
+		t.Errorf("err = %v, want an idle timeout", err)
+	}
+}
+
+func TestRequestTimeoutOverride(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(100 * time.Millisecond):
+			_, _ = w.Write([]byte("ok"))
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+
+	rt := Chain(http.DefaultTransport, timeoutMiddleware("test", HTTPConfig{RequestTimeout: 20 * time.Millisecond}))
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	if _, err := rt.RoundTrip(req); err == nil || !strings.Contains(err.Error(), "test request timed out after 20ms") {
+		t.Errorf("err = %v, want a request timeout", err)
+	}
+
+	ctx := WithRequestTimeout(context.Background(), time.Second)
+	req, _ = http.NewRequestWithContext(ctx, "GET", server.URL, nil)
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if string(body) != "ok" {
+		t.Errorf("body = %q, want ok with the longer override", body)
+	}
+}
@@ -0,0 +1,112 @@
+// artifact.go spills command output too large for the context, like a full
+// test log, to a file under agents/artifacts. The model gets the first and
+// last lines with the path of the complete output, which it can page through
+// or search with NinaRead.
+package util
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nathants/nina/workspace"
+)
+
+// MaxOutputTokens bounds the stdout or stderr of a command returned to the
+// model, longer output is saved as an artifact
+const MaxOutputTokens = 4000
+
+// maxArtifactLine is where a long line shown from spilled output is cut
+const maxArtifactLine = 500
+
+var (
+	artifactMu      sync.Mutex
+	artifactSession string // the agents/artifacts subdirectory of this process
+	artifactCount   int
+)
+
+// artifactPath returns a new path for the stream output of a command, in
+// the workspace the command ran in
+func artifactPath(stream string) string {
+	artifactMu.Lock()
+	defer artifactMu.Unlock()
+	if artifactSession == "" {
+		artifactSession = time.Now().Format("20060102-150405")
+	}
+	artifactCount++
+	dir := GetAgentsSubdir("artifacts")
+	if ws := workspace.Current(); !workspace.IsLocal(ws) {
+		dir = filepath.Join(workspace.Root(ws), "agents", "artifacts")
+	}
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	return filepath.Join(dir, artifactSession, fmt.Sprintf("%05d.%s.log", artifactCount, stream))
+}
+
+// SpillOutput returns output unchanged when within MaxOutputTokens, otherwise
+// it saves output as an artifact and returns its first and last lines with
+// the artifact path in between, and the path, "" when it could not be saved.
+// stream names the output, like "stdout".
+func SpillOutput(stream, output string) (string, string) {
+	if len(output) <= MaxOutputTokens || CalculateTokens(output) <= MaxOutputTokens {
+		return output, ""
+	}
+	lines := splitLines(output)
+	head := outputLines(lines, 1)
+	tail := outputLines(lines[len(head):], -1)
+
+	var note string
+	path, err := SaveArtifact(stream, output)
+	omitted := len(lines) - len(head) - len(tail)
+	if err != nil {
+		note = fmt.Sprintf("... %s omitted, the full output could not be saved: %v ...", plural(omitted, "line"), err)
+	} else {
+		note = fmt.Sprintf("... %s omitted, the full output of %s is in %s, read it with NinaRead ...", plural(omitted, "line"), plural(len(lines), "line"), path)
+	}
+	parts := append(append(head, note), tail...)
+	return strings.Join(parts, "\n") + "\n", path
+}
+
+// SaveArtifact writes the stream output of a command to a new file under
+// agents/artifacts and returns its path
+func SaveArtifact(stream, output string) (string, error) {
+	path := artifactPath(stream)
+	ws := workspace.Base(workspace.Current())
+	if err := ws.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	if err := ws.WriteFile(path, []byte(output), 0644); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// outputLines returns the first lines, or the last for a negative direction,
+// within half of MaxOutputTokens, cutting long lines at maxArtifactLine
+func outputLines(lines []string, direction int) []string {
+	var kept []string
+	tokens := 0
+	for i := range lines {
+		line := lines[i]
+		if direction < 0 {
+			line = lines[len(lines)-1-i]
+		}
+		if len(line) > maxArtifactLine {
+			line = line[:maxArtifactLine] + "..."
+		}
+		tokens += CalculateTokens(line) + 1
+		if tokens > MaxOutputTokens/2 {
+			break
+		}
+		kept = append(kept, line)
+	}
+	if direction < 0 {
+		for i, j := 0, len(kept)-1; i < j; i, j = i+1, j-1 {
+			kept[i], kept[j] = kept[j], kept[i]
+		}
+	}
+	return kept
+}
@@ -0,0 +1,109 @@
+// canonical.go matches paths the model reports against the paths a session
+// was built from. a repo reached through a symlink, or named with different
+// case on a case-insensitive filesystem like the macOS default, names the same
+// files with different strings.
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/nathants/nina/workspace"
+)
+
+// CanonicalPath returns path made absolute with symlinks resolved in its
+// longest existing prefix. Remote workspace paths are only cleaned.
+func CanonicalPath(path string) string {
+	path = expandHome(path)
+	if !workspace.IsLocal(workspace.Base(workspace.Current())) {
+		return filepath.Clean(path)
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	return resolveExisting(path)
+}
+
+// SamePath reports whether a and b name the same file, following symlinks
+// and ignoring case where the filesystem does
+func SamePath(a, b string) bool {
+	if a == b {
+		return true
+	}
+	ca, cb := CanonicalPath(a), CanonicalPath(b)
+	if ca == cb {
+		return true
+	}
+	if !strings.EqualFold(ca, cb) || !workspace.IsLocal(workspace.Base(workspace.Current())) {
+		return false
+	}
+	infoA, errA := os.Stat(ca)
+	infoB, errB := os.Stat(cb)
+	if errA == nil && errB == nil {
+		return os.SameFile(infoA, infoB)
+	}
+	// a file not created yet matches when its directory ignores case
+	return CaseInsensitiveDir(filepath.Dir(ca))
+}
+
+var (
+	caseInsensitive   = map[string]bool{}
+	caseInsensitiveMu sync.Mutex
+)
+
+// CaseInsensitiveDir reports whether the filesystem holding dir ignores
+// case, by checking whether the nearest existing directory with letters in
+// its name is found again with their case swapped
+func CaseInsensitiveDir(dir string) bool {
+	caseInsensitiveMu.Lock()
+	defer caseInsensitiveMu.Unlock()
+	if result, ok := caseInsensitive[dir]; ok {
+		return result
+	}
+	result := false
+	for current := dir; ; current = filepath.Dir(current) {
+		base := filepath.Base(current)
+		swapped := swapCase(base)
+		info, err := os.Stat(current)
+		if err == nil && swapped != base {
+			other, err := os.Stat(filepath.Join(filepath.Dir(current), swapped))
+			result = err == nil && os.SameFile(info, other)
+			break
+		}
+		if filepath.Dir(current) == current {
+			break
+		}
+	}
+	caseInsensitive[dir] = result
+	return result
+}
+
+func swapCase(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsUpper(r) {
+			return unicode.ToLower(r)
+		}
+		return unicode.ToUpper(r)
+	}, s)
+}
+
+// ResolvePath returns the session path for a file name reported by the
+// model, matching exactly first and then by SamePath
+func (s *SessionState) ResolvePath(name string) (string, bool) {
+	if path, ok := s.PathMap[name]; ok {
+		return path, true
+	}
+	for orig, path := range s.PathMap {
+		// _parent and _session are settings, not files
+		if strings.HasPrefix(orig, "_") {
+			continue
+		}
+		if SamePath(orig, name) {
+			return path, true
+		}
+	}
+	return "", false
+}
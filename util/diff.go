@@ -0,0 +1,85 @@
+// diff.go renders the unified diff of a change as applied, fed back to the
+// model so it can see when the result differs from what it meant
+package util
+
+import (
+	"fmt"
+	"strings"
+)
+
+// UnifiedDiff returns a unified diff of before and after as one hunk spanning
+// the lines between their common prefix and suffix, with context lines around
+// it, or "" when they are equal
+func UnifiedDiff(path, before, after string, context int) string {
+	if before == after {
+		return ""
+	}
+	oldLines := splitLines(before)
+	newLines := splitLines(after)
+	prefix := 0
+	for prefix < len(oldLines) && prefix < len(newLines) && oldLines[prefix] == newLines[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(oldLines)-prefix && suffix < len(newLines)-prefix && oldLines[len(oldLines)-1-suffix] == newLines[len(newLines)-1-suffix] {
+		suffix++
+	}
+	start := max(prefix-context, 0)
+	oldEnd := min(len(oldLines)-suffix+context, len(oldLines))
+	newEnd := min(len(newLines)-suffix+context, len(newLines))
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- a%s\n+++ b%s\n", path, path)
+	fmt.Fprintf(&b, "@@ -%s +%s @@\n", hunkRange(start, oldEnd-start), hunkRange(start, newEnd-start))
+	for _, line := range oldLines[start:prefix] {
+		b.WriteString(" " + line + "\n")
+	}
+	for _, line := range oldLines[prefix : len(oldLines)-suffix] {
+		b.WriteString("-" + line + "\n")
+	}
+	for _, line := range newLines[prefix : len(newLines)-suffix] {
+		b.WriteString("+" + line + "\n")
+	}
+	for _, line := range oldLines[len(oldLines)-suffix : oldEnd] {
+		b.WriteString(" " + line + "\n")
+	}
+	return b.String()
+}
+
+// splitLines splits text into lines without the empty line after a final
+// newline
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// hunkRange formats the 1-based start and length of a hunk side, an empty
+// side starts at the line before it
+func hunkRange(start, length int) string {
+	if length == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	return fmt.Sprintf("%d,%d", start+1, length)
+}
+
+// TruncateDiff keeps the lines of diff within maxTokens, noting how many
+// lines were dropped
+func TruncateDiff(diff string, maxTokens int) string {
+	if CalculateTokens(diff) <= maxTokens {
+		return diff
+	}
+	lines := splitLines(diff)
+	var b strings.Builder
+	tokens := 0
+	for i, line := range lines {
+		tokens += CalculateTokens(line) + 1
+		if tokens > maxTokens {
+			fmt.Fprintf(&b, "... %d more lines\n", len(lines)-i)
+			break
+		}
+		b.WriteString(line + "\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,29 @@
+// escape.go keeps file content holding Nina tags, like the prompts of nina
+// itself, from breaking the tags around it. A tag in content sent to the
+// model gets a backslash after its <, so </NinaSearch> is sent as
+// <\/NinaSearch>, and the backslash is removed from the NinaSearch and
+// NinaReplace of changes. Content already holding the escaped form gets one
+// more backslash, so every file round trips. A tag left unescaped by the
+// model still works unless it is one closing the block it is in, like a
+// </NinaReplace> inside a NinaReplace.
+package util
+
+import "regexp"
+
+var (
+	// ninaTag matches the start of a Nina tag, opening or closing, after any
+	// backslashes of escaping
+	ninaTag = regexp.MustCompile(`<(\\*)(/?Nina[A-Z])`)
+	// escapedNinaTag matches the start of an escaped Nina tag
+	escapedNinaTag = regexp.MustCompile(`<\\(\\*)(/?Nina[A-Z])`)
+)
+
+// EscapeNinaTags escapes the Nina tags of content sent inside Nina tags
+func EscapeNinaTags(content string) string {
+	return ninaTag.ReplaceAllString(content, `<\${1}${2}`)
+}
+
+// UnescapeNinaTags reverses EscapeNinaTags, unescaped tags are kept as is
+func UnescapeNinaTags(content string) string {
+	return escapedNinaTag.ReplaceAllString(content, `<${1}${2}`)
+}
@@ -8,12 +8,49 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+
+	"github.com/nathants/nina/workspace"
 )
 
+// nonInteractiveEnv is exported to commands so tools that would prompt pick
+// a default or fail instead of waiting for input that never comes
+var nonInteractiveEnv = []string{"CI=1", "GIT_TERMINAL_PROMPT=0", "DEBIAN_FRONTEND=noninteractive", "GIT_EDITOR=true", "GIT_PAGER=cat", "PAGER=cat"}
+
+// NonInteractive returns script exporting nonInteractiveEnv first, unless
+// NINA_INTERACTIVE=1. The variables are set in the script rather than the
+// process environment so they also reach remote workspaces, on its first line
+// so line numbers in errors are unchanged.
+func NonInteractive(script string) string {
+	if os.Getenv("NINA_INTERACTIVE") == "1" {
+		return script
+	}
+	return "export " + strings.Join(nonInteractiveEnv, " ") + "; " + script
+}
+
 // ExecuteBash runs a bash command and returns the result
 func ExecuteBash(cmd BashCommand) CommandResult {
-	// Create command with bash -c
-	bashCmd := exec.Command("bash", "-c", cmd.Command)
+	cwd := workspace.Root(workspace.Current())
+	if cwd == "" {
+		cwd, _ = os.Getwd()
+	}
+	script := cmd.Command
+	if cmd.Cwd != "" {
+		dir, err := commandDir(cwd, cmd.Cwd)
+		if err != nil {
+			return CommandResult{Command: cmd.Command, Cmd: cmd.Command, Cwd: cmd.Cwd, Args: cmd.Args, ExitCode: -1, Stderr: err.Error()}
+		}
+		cwd = dir
+		script = "cd " + workspace.Quote(dir) + " && " + script
+	}
+	if err := CheckCommandProtected(cmd.Command, cwd); err != nil {
+		return CommandResult{Command: cmd.Command, Cmd: cmd.Command, Cwd: cwd, Args: cmd.Args, ExitCode: -1, Stderr: fmt.Sprintf("not run, it writes a protected file: %v", err)}
+	}
+
+	// Create command with bash -c, in the current workspace which may be remote
+	bashCmd := workspace.BashCommand(NonInteractive(script))
+	if cmd.Stdin != "" {
+		bashCmd.Stdin = strings.NewReader(cmd.Stdin)
+	}
 
 	// Capture output
 	var stdout, stderr bytes.Buffer
@@ -35,16 +72,37 @@ func ExecuteBash(cmd BashCommand) CommandResult {
 		}
 	}
 
-	cwd, _ := os.Getwd()
-	return CommandResult{
+	result := CommandResult{
 		Command:  cmd.Command,
 		Cmd:      cmd.Command,
 		Cwd:      cwd,
 		Args:     cmd.Args,
 		ExitCode: exitCode,
-		Stdout:   stdout.String(),
-		Stderr:   stderr.String(),
 	}
+	result.Stdout, result.StdoutFile = SpillOutput("stdout", Redact(stdout.String()))
+	result.Stderr, result.StderrFile = SpillOutput("stderr", Redact(stderr.String()))
+	return result
+}
+
+// commandDir resolves the NinaCwd of a command against root, it must be an
+// allowed directory, see CheckPathAllowed, protected directories are allowed
+func commandDir(root, dir string) (string, error) {
+	dir = expandHome(dir)
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(root, dir)
+	}
+	dir = filepath.Clean(dir)
+	if _, err := checkRoots(dir); err != nil {
+		return "", err
+	}
+	info, err := workspace.Current().Stat(dir)
+	if err != nil {
+		return "", fmt.Errorf("cwd %s: %w", dir, err)
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("cwd %s is not a directory", dir)
+	}
+	return dir, nil
 }
 
 // ApplyFileChange applies a file update and returns the result
@@ -64,20 +122,34 @@ func ApplyFileChange(update FileUpdate, sessionState *SessionState) ChangeResult
 	// Get the resolved path
 	resolvedPath := update.FileName
 	if sessionState != nil && sessionState.PathMap != nil {
-		if mapped, ok := sessionState.PathMap[update.FileName]; ok {
+		if mapped, ok := sessionState.ResolvePath(update.FileName); ok {
 			resolvedPath = mapped
 		}
 	}
 
+	// Refuse paths outside the allowed roots, and binary or oversized files
+	if err := CheckPathAllowed(resolvedPath); err != nil {
+		result.Stderr = err.Error()
+		result.Error = err.Error()
+		return result
+	}
+	if err := CheckFile(resolvedPath); err != nil {
+		result.Stderr = err.Error()
+		result.Error = err.Error()
+		return result
+	}
+
 	// Read current content
+	ws := workspace.Current()
 	currentContent := ""
-	fileData, err := os.ReadFile(resolvedPath)
+	format := DetectTextFormat("")
+	fileData, err := ws.ReadFile(resolvedPath)
 	if err != nil && !os.IsNotExist(err) {
 		result.Stderr = fmt.Sprintf("Error reading file: %v", err)
 		return result
 	}
 	if err == nil {
-		currentContent = string(fileData)
+		currentContent, format = NormalizeText(string(fileData))
 	}
 
 	// Apply the update
@@ -85,7 +157,7 @@ func ApplyFileChange(update FileUpdate, sessionState *SessionState) ChangeResult
 	if sessionState != nil && sessionState.OrigFiles != nil {
 		// Use original content for search/replace
 		origPath := resolvedPath
-		if mapped, ok := sessionState.PathMap[update.FileName]; ok {
+		if mapped, ok := sessionState.ResolvePath(update.FileName); ok {
 			// Find the original path
 			for orig, dest := range sessionState.PathMap {
 				if dest == mapped {
@@ -97,7 +169,7 @@ func ApplyFileChange(update FileUpdate, sessionState *SessionState) ChangeResult
 
 		originalContent := ""
 		if orig, ok := sessionState.OrigFiles[origPath]; ok {
-			originalContent = StripLineNumbers(orig)
+			originalContent, _ = NormalizeText(StripLineNumbers(orig))
 		}
 
 		// Apply updates with search
@@ -122,13 +194,13 @@ func ApplyFileChange(update FileUpdate, sessionState *SessionState) ChangeResult
 
 	// Ensure directory exists
 	dir := filepath.Dir(resolvedPath)
-	if err := os.MkdirAll(dir, 0755); err != nil {
+	if err := ws.MkdirAll(dir, 0755); err != nil {
 		result.Stderr = fmt.Sprintf("Error creating directory: %v", err)
 		return result
 	}
 
 	// Write the file
-	if err := os.WriteFile(resolvedPath, []byte(newContent), 0644); err != nil {
+	if err := ws.WriteFile(resolvedPath, []byte(format.Restore(newContent)), workspace.FileMode(ws, resolvedPath, 0644)); err != nil {
 		result.Stderr = fmt.Sprintf("Error writing file: %v", err)
 		return result
 	}
@@ -182,14 +254,30 @@ func ExecuteChange(filepath, searchText, replaceText string) ChangeResult {
 		}
 	}
 
-	// Read the file
-	content, err := os.ReadFile(filepath)
+	// Refuse paths outside the allowed roots, and binary or oversized files
+	if err := CheckPathAllowed(filepath); err != nil {
+		return ChangeResult{
+			FilePath: filepath,
+			Error:    err.Error(),
+		}
+	}
+	if err := CheckFile(filepath); err != nil {
+		return ChangeResult{
+			FilePath: filepath,
+			Error:    err.Error(),
+		}
+	}
+
+	// Read the file, editing it with \n line endings and no BOM
+	ws := workspace.Current()
+	data, err := ws.ReadFile(filepath)
 	if err != nil {
 		return ChangeResult{
 			FilePath: filepath,
 			Stderr:   fmt.Sprintf("Failed to read file: %v", err),
 		}
 	}
+	content, format := NormalizeText(string(data))
 
 	// Create file update
 	update := FileUpdate{
@@ -198,16 +286,21 @@ func ExecuteChange(filepath, searchText, replaceText string) ChangeResult {
 
 	var newContent string
 	if searchText == "" {
-		// Full file replacement
-		update.ReplaceLines = strings.Split(replaceText, "\n")
-		newContent, err = ApplyFileUpdates(string(content), []FileUpdate{update})
+		// Full file replacement, without the newline after the opening tag
+		update.ReplaceLines = strings.Split(strings.TrimPrefix(replaceText, "\n"), "\n")
+		newContent, err = ApplyFileUpdates(content, []FileUpdate{update})
 	} else {
-		// Search/replace update
+		// Search/replace update, the search must match once
 		update.SearchLines = TrimBlankLines(strings.Split(searchText, "\n"))
 		update.ReplaceLines = TrimBlankLines(strings.Split(replaceText, "\n"))
 
 		// Apply the update directly without AI conversion
-		newContent, err = ApplyFileUpdates(string(content), []FileUpdate{update})
+		var idx int
+		idx, err = findSearchLines(update.SearchLines, strings.Split(content, "\n"), searchSimilarityThreshold())
+		if err == nil {
+			update.StartLine, update.EndLine = idx+1, idx+len(update.SearchLines)
+			newContent, err = ApplyFileUpdates(content, []FileUpdate{update})
+		}
 	}
 
 	if err != nil {
@@ -216,9 +309,15 @@ func ExecuteChange(filepath, searchText, replaceText string) ChangeResult {
 			Stderr:   fmt.Sprintf("Failed to apply changes: %v", err),
 		}
 	}
+	if err := CheckContent(filepath, []byte(newContent)); err != nil {
+		return ChangeResult{
+			FilePath: filepath,
+			Error:    err.Error(),
+		}
+	}
 
 	// Count changed lines
-	oldLines := strings.Split(string(content), "\n")
+	oldLines := strings.Split(content, "\n")
 	newLines := strings.Split(newContent, "\n")
 	linesChanged := 0
 	maxLen := len(oldLines)
@@ -233,7 +332,7 @@ func ExecuteChange(filepath, searchText, replaceText string) ChangeResult {
 	}
 
 	// Write the file
-	err = os.WriteFile(filepath, []byte(newContent), 0644)
+	err = ws.WriteFile(filepath, []byte(format.Restore(newContent)), workspace.FileMode(ws, filepath, 0644))
 	if err != nil {
 		return ChangeResult{
 			FilePath: filepath,
@@ -244,5 +343,114 @@ func ExecuteChange(filepath, searchText, replaceText string) ChangeResult {
 	return ChangeResult{
 		FilePath:     filepath,
 		LinesChanged: linesChanged,
+		Diff:         UnifiedDiff(filepath, content, newContent, 2),
+	}
+}
+
+// expandHome replaces a leading ~/ with the user's home directory
+func expandHome(path string) string {
+	if strings.HasPrefix(path, "~/") {
+		if homeDir, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(homeDir, path[2:])
+		}
+	}
+	return path
+}
+
+// ExecuteDelete deletes a single file. Files tracked by git are removed with
+// git rm so the deletion is staged, untracked files are removed directly.
+func ExecuteDelete(path string) ChangeResult {
+	result := ChangeResult{FilePath: path}
+	path = expandHome(path)
+	if !filepath.IsAbs(path) {
+		result.Error = fmt.Sprintf("path must be absolute: %s", path)
+		return result
+	}
+	if err := CheckPathAllowed(path); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	ws := workspace.Current()
+	info, err := ws.Stat(path)
+	if err != nil {
+		result.Error = fmt.Sprintf("Failed to stat file: %v", err)
+		return result
 	}
+	if info.IsDir() {
+		result.Error = fmt.Sprintf("refusing to delete directory: %s", path)
+		return result
+	}
+
+	if workspace.IsLocal(ws) && gitTracked(path) {
+		if err := runGit(filepath.Dir(path), "rm", "-q", "-f", "--", filepath.Base(path)); err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		result.Stdout = fmt.Sprintf("Deleted %s with git rm", path)
+		return result
+	}
+
+	if err := ws.Remove(path); err != nil {
+		result.Error = fmt.Sprintf("Failed to delete file: %v", err)
+		return result
+	}
+	result.Stdout = fmt.Sprintf("Deleted %s", path)
+	return result
+}
+
+// ExecuteRename moves a file or directory, refusing to overwrite an existing
+// destination. When the source is tracked by git and the destination is in the
+// same repository git mv is used so history follows the file.
+func ExecuteRename(src, dst string) ChangeResult {
+	result := ChangeResult{FilePath: src, Args: []string{dst}}
+	src = expandHome(src)
+	dst = expandHome(dst)
+	if !filepath.IsAbs(src) || !filepath.IsAbs(dst) {
+		result.Error = fmt.Sprintf("paths must be absolute: %s -> %s", src, dst)
+		return result
+	}
+	for _, path := range []string{src, dst} {
+		if err := CheckPathAllowed(path); err != nil {
+			result.Error = err.Error()
+			return result
+		}
+	}
+
+	ws := workspace.Current()
+	if _, err := ws.Stat(src); err != nil {
+		result.Error = fmt.Sprintf("Failed to stat source: %v", err)
+		return result
+	}
+	if _, err := ws.Stat(dst); err == nil {
+		result.Error = fmt.Sprintf("destination already exists: %s", dst)
+		return result
+	} else if !os.IsNotExist(err) {
+		result.Error = fmt.Sprintf("Failed to stat destination: %v", err)
+		return result
+	}
+	if err := ws.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		result.Error = fmt.Sprintf("Error creating directory: %v", err)
+		return result
+	}
+
+	root := ""
+	if workspace.IsLocal(ws) {
+		root = gitRootOf(filepath.Dir(src))
+	}
+	if root != "" && root == gitRootOf(filepath.Dir(dst)) && gitTracked(src) {
+		if err := runGit(root, "mv", "--", src, dst); err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		result.Stdout = fmt.Sprintf("Renamed %s to %s with git mv", src, dst)
+		return result
+	}
+
+	if err := ws.Rename(src, dst); err != nil {
+		result.Error = fmt.Sprintf("Failed to rename: %v", err)
+		return result
+	}
+	result.Stdout = fmt.Sprintf("Renamed %s to %s", src, dst)
+	return result
 }
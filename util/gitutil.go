@@ -2,6 +2,7 @@
 package util
 
 import (
+	"fmt"
 	"os/exec"
 	"path/filepath"
 	"strings"
@@ -29,4 +30,30 @@ func GetAgentsDir() string {
 // GetAgentsSubdir returns a subdirectory path under the agents directory
 func GetAgentsSubdir(subdir string) string {
 	return filepath.Join(GetAgentsDir(), subdir)
-}
\ No newline at end of file
+}
+
+// gitRootOf returns the repository root containing dir, or empty if none
+func gitRootOf(dir string) string {
+	cmd := exec.Command("git", "-C", dir, "rev-parse", "--show-toplevel")
+	output, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(output))
+}
+
+// gitTracked reports whether path is tracked by the repository containing it
+func gitTracked(path string) bool {
+	cmd := exec.Command("git", "-C", filepath.Dir(path), "ls-files", "--error-unmatch", "--", filepath.Base(path))
+	return cmd.Run() == nil
+}
+
+// runGit runs a git command in dir, including its output in any error
+func runGit(dir string, args ...string) error {
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("git %s: %v: %s", args[0], err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
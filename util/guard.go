@@ -0,0 +1,121 @@
+// guard.go detects binary and oversized files so they are never sent to a
+// model as context or rewritten by a change
+package util
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/nathants/nina/workspace"
+)
+
+// DefaultMaxFileSize is the largest file read or written when
+// NINA_MAX_FILE_SIZE is unset
+const DefaultMaxFileSize = 1 << 20
+
+// binarySniffLen is how much of a file is inspected for binary content
+const binarySniffLen = 8000
+
+var (
+	ErrBinaryFile   = errors.New("binary file")
+	ErrFileTooLarge = errors.New("file too large")
+	// ErrNotUTF8 is text in another encoding, it is also an ErrBinaryFile
+	ErrNotUTF8 = fmt.Errorf("%w, not utf8 encoded", ErrBinaryFile)
+)
+
+// MaxFileSize returns the size limit in bytes from NINA_MAX_FILE_SIZE, zero
+// or negative disables the limit
+func MaxFileSize() int64 {
+	value := os.Getenv("NINA_MAX_FILE_SIZE")
+	if value == "" {
+		return DefaultMaxFileSize
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		Errorf("warning: ignoring invalid NINA_MAX_FILE_SIZE=%s", value)
+		return DefaultMaxFileSize
+	}
+	return n
+}
+
+// IsBinary reports whether data looks binary, using the same heuristic as
+// git: a NUL byte in the first 8000 bytes, or content that is not utf8
+func IsBinary(data []byte) bool {
+	if len(data) > binarySniffLen {
+		data = data[:binarySniffLen]
+		// avoid rejecting a multibyte rune split by the cut
+		for i := 0; i < utf8.UTFMax && len(data) > 0 && !utf8.Valid(data); i++ {
+			data = data[:len(data)-1]
+		}
+	}
+	return bytes.IndexByte(data, 0) != -1 || !utf8.Valid(data)
+}
+
+// CheckContent returns ErrFileTooLarge or ErrBinaryFile, wrapped with the
+// path, when data should not be read or written as text
+func CheckContent(path string, data []byte) error {
+	if limit := MaxFileSize(); limit > 0 && int64(len(data)) > limit {
+		return fmt.Errorf("%w: %s is %d bytes, limit is %d (NINA_MAX_FILE_SIZE)", ErrFileTooLarge, path, len(data), limit)
+	}
+	if IsBinary(data) {
+		return binaryError(path, data)
+	}
+	return nil
+}
+
+// binaryError returns ErrNotUTF8 for data without NUL bytes, which is likely
+// text in another encoding, and ErrBinaryFile otherwise
+func binaryError(path string, data []byte) error {
+	if bytes.IndexByte(data, 0) == -1 {
+		return fmt.Errorf("%w: %s, convert it to utf8 to edit it", ErrNotUTF8, path)
+	}
+	return fmt.Errorf("%w: %s", ErrBinaryFile, path)
+}
+
+// CheckFile applies CheckContent to a file in the current workspace without
+// reading all of an oversized file. Missing files pass so changes may create them.
+func CheckFile(path string) error {
+	ws := workspace.Current()
+	info, err := ws.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return nil
+	}
+	if limit := MaxFileSize(); limit > 0 && info.Size() > limit {
+		return fmt.Errorf("%w: %s is %d bytes, limit is %d (NINA_MAX_FILE_SIZE)", ErrFileTooLarge, path, info.Size(), limit)
+	}
+	if !workspace.IsLocal(ws) {
+		data, err := ws.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return CheckContent(path, data)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	// one extra byte lets IsBinary know the sample was truncated
+	head := make([]byte, binarySniffLen+1)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return err
+	}
+	if IsBinary(head[:n]) {
+		return binaryError(path, head[:n])
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+// log.go is the leveled logger for diagnostic output on stderr. The level
+// comes from NINA_LOG (quiet, normal, verbose, debug), which the global -q
+// and -v flags set. Quiet keeps only errors and warnings so scripted use gets
+// clean stderr, verbose and debug add detail. Output meant for stdout, like
+// results and reports, does not go through the logger.
+package util
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+type LogLevel int
+
+const (
+	LogQuiet LogLevel = iota
+	LogNormal
+	LogVerbose
+	LogDebug
+)
+
+var logLevelNames = []string{"quiet", "normal", "verbose", "debug"}
+
+func (l LogLevel) String() string {
+	if l < LogQuiet || l > LogDebug {
+		return fmt.Sprintf("LogLevel(%d)", int(l))
+	}
+	return logLevelNames[l]
+}
+
+var (
+	logMu     sync.Mutex
+	logOutput io.Writer = os.Stderr
+)
+
+// ParseLogLevel parses a level name or its number
+func ParseLogLevel(s string) (LogLevel, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	for i, name := range logLevelNames {
+		if s == name || s == fmt.Sprint(i) {
+			return LogLevel(i), nil
+		}
+	}
+	return LogNormal, fmt.Errorf("invalid log level: %s, expected one of %s", s, strings.Join(logLevelNames, ", "))
+}
+
+// GetLogLevel returns the level from NINA_LOG, defaulting to normal, or to
+// debug when the legacy DEBUG variable is set
+func GetLogLevel() LogLevel {
+	value := os.Getenv("NINA_LOG")
+	if value == "" {
+		if os.Getenv("DEBUG") != "" {
+			return LogDebug
+		}
+		return LogNormal
+	}
+	level, err := ParseLogLevel(value)
+	if err != nil {
+		return LogNormal
+	}
+	return level
+}
+
+// SetLogLevel sets NINA_LOG for this process and any children
+func SetLogLevel(level LogLevel) {
+	_ = os.Setenv("NINA_LOG", level.String())
+}
+
+// SetLogOutput redirects log output, returning the previous writer
+func SetLogOutput(w io.Writer) io.Writer {
+	logMu.Lock()
+	defer logMu.Unlock()
+	prev := logOutput
+	logOutput = w
+	return prev
+}
+
+// LogColorEnabled reports whether the log output supports colors and
+// decoration
+func LogColorEnabled() bool {
+	logMu.Lock()
+	defer logMu.Unlock()
+	return ColorEnabled(logOutput)
+}
+
+// LogEnabled reports whether messages at level are written
+func LogEnabled(level LogLevel) bool {
+	return GetLogLevel() >= level
+}
+
+// Logf writes a message at level, adding a trailing newline when missing.
+// Messages are redacted since stderr is often captured into logs.
+func Logf(level LogLevel, format string, args ...any) {
+	if !LogEnabled(level) {
+		return
+	}
+	message := Redact(fmt.Sprintf(format, args...))
+	if !strings.HasSuffix(message, "\n") {
+		message += "\n"
+	}
+	logMu.Lock()
+	defer logMu.Unlock()
+	_, _ = io.WriteString(logOutput, message)
+}
+
+// Printf writes at level exactly as formatted, for streamed or colored output
+// that manages its own newlines. Colors are stripped when stderr does not
+// support them.
+func Printf(level LogLevel, format string, args ...any) {
+	if !LogEnabled(level) {
+		return
+	}
+	logMu.Lock()
+	defer logMu.Unlock()
+	_, _ = io.WriteString(logOutput, ForTerminal(logOutput, fmt.Sprintf(format, args...)))
+}
+
+// Errorf logs errors and warnings, which are shown at every level
+func Errorf(format string, args ...any) { Logf(LogQuiet, format, args...) }
+
+// Infof logs progress and decorative output, hidden by -q
+func Infof(format string, args ...any) { Logf(LogNormal, format, args...) }
+
+// Verbosef logs detail shown with -v
+func Verbosef(format string, args ...any) { Logf(LogVerbose, format, args...) }
+
+// Debugf logs internals shown with -v -v or NINA_LOG=debug
+func Debugf(format string, args ...any) { Logf(LogDebug, format, args...) }
@@ -1,11 +1,13 @@
 package util
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"regexp"
 	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -31,6 +33,63 @@ var (
 	NinaChangeRangeEndStart   = "<" + "NinaEnd" + ">"
 	NinaChangeRangeEndEnd     = "</" + "NinaEnd" + ">"
 
+	NinaDeleteStart = "<" + "NinaDelete" + ">"
+	NinaDeleteEnd   = "</" + "NinaDelete" + ">"
+	NinaRenameStart = "<" + "NinaRename" + ">"
+	NinaRenameEnd   = "</" + "NinaRename" + ">"
+	NinaDestStart   = "<" + "NinaDest" + ">"
+	NinaDestEnd     = "</" + "NinaDest" + ">"
+
+	NinaReadStart      = "<" + "NinaRead" + ">"
+	NinaReadEnd        = "</" + "NinaRead" + ">"
+	NinaStartLineStart = "<" + "NinaStartLine" + ">"
+	NinaStartLineEnd   = "</" + "NinaStartLine" + ">"
+	NinaEndLineStart   = "<" + "NinaEndLine" + ">"
+	NinaEndLineEnd     = "</" + "NinaEndLine" + ">"
+	NinaPatternStart   = "<" + "NinaPattern" + ">"
+	NinaPatternEnd     = "</" + "NinaPattern" + ">"
+	NinaLinesStart     = "<" + "NinaLines" + ">"
+	NinaLinesEnd       = "</" + "NinaLines" + ">"
+	NinaGrepStart      = "<" + "NinaGrep" + ">"
+	NinaGrepEnd        = "</" + "NinaGrep" + ">"
+	NinaGlobStart      = "<" + "NinaGlob" + ">"
+	NinaGlobEnd        = "</" + "NinaGlob" + ">"
+	NinaIncludeStart   = "<" + "NinaInclude" + ">"
+	NinaIncludeEnd     = "</" + "NinaInclude" + ">"
+	NinaMatchesStart   = "<" + "NinaMatches" + ">"
+	NinaMatchesEnd     = "</" + "NinaMatches" + ">"
+	NinaSpawnStart     = "<" + "NinaSpawn" + ">"
+	NinaSpawnEnd       = "</" + "NinaSpawn" + ">"
+	NinaKillStart      = "<" + "NinaKill" + ">"
+	NinaKillEnd        = "</" + "NinaKill" + ">"
+	NinaIdStart        = "<" + "NinaId" + ">"
+	NinaIdEnd          = "</" + "NinaId" + ">"
+	NinaCmdStart       = "<" + "NinaCmd" + ">"
+	NinaCmdEnd         = "</" + "NinaCmd" + ">"
+	NinaStdinStart     = "<" + "NinaStdin" + ">"
+	NinaStdinEnd       = "</" + "NinaStdin" + ">"
+	NinaCwdStart       = "<" + "NinaCwd" + ">"
+	NinaCwdEnd         = "</" + "NinaCwd" + ">"
+
+	NinaRememberStart = "<" + "NinaRemember" + ">"
+	NinaRememberEnd   = "</" + "NinaRemember" + ">"
+	NinaRecallStart   = "<" + "NinaRecall" + ">"
+	NinaRecallEnd     = "</" + "NinaRecall" + ">"
+	NinaPlanStart     = "<" + "NinaPlan" + ">"
+	NinaPlanEnd       = "</" + "NinaPlan" + ">"
+
+	NinaAgentStart     = "<" + "NinaAgent" + ">"
+	NinaAgentEnd       = "</" + "NinaAgent" + ">"
+	NinaTaskStart      = "<" + "NinaTask" + ">"
+	NinaTaskEnd        = "</" + "NinaTask" + ">"
+	NinaModelStart     = "<" + "NinaModel" + ">"
+	NinaModelEnd       = "</" + "NinaModel" + ">"
+	NinaMaxTokensStart = "<" + "NinaMaxTokens" + ">"
+	NinaMaxTokensEnd   = "</" + "NinaMaxTokens" + ">"
+
+	NinaProtocolStart = "<" + "NinaProtocol" + ">"
+	NinaProtocolEnd   = "</" + "NinaProtocol" + ">"
+
 	NinaMessageStart = "<" + "NinaMessage" + ">"
 	NinaMessageEnd   = "</" + "NinaMessage" + ">"
 	NinaInputStart   = "<" + "NinaInput" + ">"
@@ -44,6 +103,8 @@ var (
 	NinaFileEnd           = "</" + "NinaFile" + ">"
 	NinaPatchStart        = "<" + "NinaPatch" + ">"
 	NinaPatchEnd          = "</" + "NinaPatch" + ">"
+	NinaDiffStart         = "<" + "NinaDiff" + ">"
+	NinaDiffEnd           = "</" + "NinaDiff" + ">"
 
 	NinaBashStart   = "<" + "NinaBash" + ">"
 	NinaBashEnd     = "</" + "NinaBash" + ">"
@@ -83,10 +144,25 @@ type FileUpdate struct {
 	EndLine      int // 1-based inclusive end line for range updates
 }
 
+// File operations parsed from NinaDelete and NinaRename tags
+const (
+	FileOpDelete = "delete"
+	FileOpRename = "rename"
+)
+
+// FileOp is a file deletion or rename
+type FileOp struct {
+	Op   string // FileOpDelete or FileOpRename
+	Path string
+	Dest string // rename destination, empty for deletes
+}
+
 // BashCommand represents a bash command to execute
 type BashCommand struct {
 	Command string
 	Args    []string
+	Stdin   string // sent to the command's stdin, see NinaStdin
+	Cwd     string // directory the command runs in, the workspace root when empty
 }
 
 // CommandResult represents the result of executing a command
@@ -98,6 +174,10 @@ type CommandResult struct {
 	ExitCode int
 	Stdout   string
 	Stderr   string
+	// StdoutFile and StderrFile hold the full output when it was too long to
+	// return, see SpillOutput
+	StdoutFile string
+	StderrFile string
 }
 
 // ChangeResult represents the result of applying a file change
@@ -108,6 +188,7 @@ type ChangeResult struct {
 	Stderr       string
 	Error        string
 	LinesChanged int
+	Diff         string // unified diff of the change, see UnifiedDiff
 }
 
 func TrimBlankLines(lines []string) []string {
@@ -120,6 +201,75 @@ func TrimBlankLines(lines []string) []string {
 	return lines
 }
 
+// ChangeError is a NinaChange block that could not be parsed
+type ChangeError struct {
+	Index int    // position of the block in the response, from 1
+	Path  string // empty when the block has no NinaPath
+	Err   error
+}
+
+func (e ChangeError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("NinaChange %d: %v", e.Index, e.Err)
+	}
+	return fmt.Sprintf("NinaChange %d (%s): %v", e.Index, e.Path, e.Err)
+}
+
+// ChangeErrors are the broken NinaChange blocks of a response, returned by
+// ParseFileUpdates with the blocks that parsed
+type ChangeErrors []ChangeError
+
+func (e ChangeErrors) Error() string {
+	var parts []string
+	for _, ce := range e {
+		parts = append(parts, ce.Error())
+	}
+	return strings.Join(parts, "; ")
+}
+
+// SkipBrokenChanges logs the broken blocks when err is ChangeErrors and
+// returns true, so the blocks that parsed can still be applied. Any other
+// error returns false.
+func SkipBrokenChanges(err error) bool {
+	var changeErrs ChangeErrors
+	if !errors.As(err, &changeErrs) {
+		return false
+	}
+	for _, ce := range changeErrs {
+		Errorf("skipping %v", ce)
+	}
+	return true
+}
+
+// CheckNinaChange checks the tags of one NinaChange block: one non-empty
+// NinaPath, at most one NinaSearch, and one NinaReplace. It returns the path,
+// when there is one, even for a broken block.
+func CheckNinaChange(chunk string) (string, error) {
+	path, err := ExtractSingle(chunk, NinaPathStart, NinaPathEnd)
+	path = strings.TrimSpace(path)
+	switch {
+	case err != nil:
+		return "", fmt.Errorf("unclosed %s", NinaPathStart)
+	case path == "":
+		return "", fmt.Errorf("missing NinaPath in NinaChange")
+	}
+	for _, tag := range [][2]string{{NinaSearchStart, NinaSearchEnd}, {NinaReplaceStart, NinaReplaceEnd}} {
+		opened, closed := strings.Count(chunk, tag[0]), strings.Count(chunk, tag[1])
+		switch {
+		case opened != closed:
+			return path, fmt.Errorf("%d %s and %d %s", opened, tag[0], closed, tag[1])
+		case opened > 1:
+			return path, fmt.Errorf("%d %s, a NinaChange holds one", opened, tag[0])
+		case opened == 0 && tag[0] == NinaReplaceStart:
+			return path, fmt.Errorf("missing NinaReplace in NinaChange")
+		}
+	}
+	return path, nil
+}
+
+// ParseFileUpdates parses the NinaChange blocks of output. Broken blocks are
+// skipped and returned as ChangeErrors with the updates of the blocks that
+// parsed, see SkipBrokenChanges.
 func ParseFileUpdates(output string) ([]FileUpdate, error) {
 	ninaOutput, err := ExtractSingle(output, NinaOutputStart, NinaOutputEnd)
 	if err != nil {
@@ -130,6 +280,7 @@ func ParseFileUpdates(output string) ([]FileUpdate, error) {
 	}
 
 	var updates []FileUpdate
+	var changeErrs ChangeErrors
 
 	// Handle range-based changes
 	changeChunks, err := ExtractAll(ninaOutput, NinaStart, NinaEnd)
@@ -137,27 +288,17 @@ func ParseFileUpdates(output string) ([]FileUpdate, error) {
 		return nil, err
 	}
 
-	for _, chunk := range changeChunks {
-		path, err := ExtractSingle(chunk, NinaPathStart, NinaPathEnd)
-		if err != nil {
-			return nil, err
-		}
-		if path == "" {
-			return nil, fmt.Errorf("missing NinaPath in NinaChange")
-		}
-
-		// Parse range format
-		search, err := ExtractSingle(chunk, NinaSearchStart, NinaSearchEnd)
-		if err != nil {
-			return nil, err
-		}
-
-		replace, err := ExtractSingle(chunk, NinaReplaceStart, NinaReplaceEnd)
+	for i, chunk := range changeChunks {
+		path, err := CheckNinaChange(chunk)
 		if err != nil {
-			return nil, err
+			changeErrs = append(changeErrs, ChangeError{Index: i + 1, Path: path, Err: err})
+			continue
 		}
 
-		path = strings.TrimSpace(path)
+		// Parse range format, content tags come escaped
+		search, _ := ExtractSingle(chunk, NinaSearchStart, NinaSearchEnd)
+		replace, _ := ExtractSingle(chunk, NinaReplaceStart, NinaReplaceEnd)
+		search, replace = UnescapeNinaTags(search), UnescapeNinaTags(replace)
 
 		searchLines := TrimBlankLines(strings.Split(search, "\n"))
 		replaceLines := TrimBlankLines(strings.Split(replace, "\n"))
@@ -169,6 +310,9 @@ func ParseFileUpdates(output string) ([]FileUpdate, error) {
 		})
 	}
 
+	if len(changeErrs) > 0 {
+		return updates, changeErrs
+	}
 	if len(updates) == 0 {
 		return nil, nil // no changes is not an error
 	}
@@ -176,6 +320,63 @@ func ParseFileUpdates(output string) ([]FileUpdate, error) {
 	return updates, nil
 }
 
+// ParseFileOps parses NinaDelete and NinaRename tags in the order they appear
+func ParseFileOps(output string) ([]FileOp, error) {
+	ninaOutput, err := ExtractSingle(output, NinaOutputStart, NinaOutputEnd)
+	if err != nil {
+		return nil, err
+	}
+	if ninaOutput == "" {
+		ninaOutput = output
+	}
+
+	var ops []FileOp
+	for {
+		deleteIdx := strings.Index(ninaOutput, NinaDeleteStart)
+		renameIdx := strings.Index(ninaOutput, NinaRenameStart)
+		if deleteIdx == -1 && renameIdx == -1 {
+			break
+		}
+
+		op, start, stop := FileOpDelete, NinaDeleteStart, NinaDeleteEnd
+		idx := deleteIdx
+		if deleteIdx == -1 || (renameIdx != -1 && renameIdx < deleteIdx) {
+			op, start, stop = FileOpRename, NinaRenameStart, NinaRenameEnd
+			idx = renameIdx
+		}
+		ninaOutput = ninaOutput[idx:]
+		chunk, err := ExtractSingle(ninaOutput, start, stop)
+		if err != nil {
+			return nil, err
+		}
+		ninaOutput = ninaOutput[len(start)+len(chunk)+len(stop):]
+
+		path, err := ExtractSingle(chunk, NinaPathStart, NinaPathEnd)
+		if err != nil {
+			return nil, err
+		}
+		path = strings.TrimSpace(path)
+		if path == "" {
+			return nil, fmt.Errorf("missing NinaPath in %s", start)
+		}
+
+		fileOp := FileOp{Op: op, Path: path}
+		if op == FileOpRename {
+			dest, err := ExtractSingle(chunk, NinaDestStart, NinaDestEnd)
+			if err != nil {
+				return nil, err
+			}
+			fileOp.Dest = strings.TrimSpace(dest)
+			if fileOp.Dest == "" {
+				return nil, fmt.Errorf("missing NinaDest in %s", start)
+			}
+		}
+		ops = append(ops, fileOp)
+	}
+
+	return ops, nil
+}
+
 func ExtractNinaMessage(output string) (string, error) {
 	ninaOutput, err := ExtractSingle(output, NinaOutputStart, NinaOutputEnd)
 	if err != nil {
@@ -239,7 +440,7 @@ func ExtractAll(src, start, stop string) ([]string, error) {
 func ValidateRangeReplace(update FileUpdate, sessionState *SessionState) error {
 	// Line-range replacement mode.
 	if update.StartLine > 0 || update.EndLine > 0 {
-		path, ok := sessionState.PathMap[update.FileName]
+		path, ok := sessionState.ResolvePath(update.FileName)
 		if !ok {
 			return fmt.Errorf("missing pathMap for range update: %s", update.FileName)
 		}
@@ -381,7 +582,7 @@ func ApplyRangeReplace(update FileUpdate, dryRun bool, sessionState *SessionStat
 	_ = dryRun // kept for API compatibility
 	var content string
 	if sessionState != nil {
-		if path, ok := sessionState.PathMap[update.FileName]; ok {
+		if path, ok := sessionState.ResolvePath(update.FileName); ok {
 			if data, ok2 := sessionState.OrigFiles[path]; ok2 {
 				content = StripLineNumbers(data)
 			}
@@ -390,8 +591,10 @@ func ApplyRangeReplace(update FileUpdate, dryRun bool, sessionState *SessionStat
 	return ApplyFileUpdates(content, []FileUpdate{update})
 }
 
-// ApplyUpdatesWithSearch applies updates to currentContent using exact text search/replace
-// based on the original content. Returns the updated content and any errors.
+// ApplyUpdatesWithSearch applies updates to currentContent using text search/replace
+// based on the original content. Searches match exactly, then ignoring whitespace
+// differences, then optionally by similarity, see findSearchLines. Returns the
+// updated content and any errors.
 func ApplyUpdatesWithSearch(originalContent, currentContent string, updates []FileUpdate) (string, []error) {
 	var errs []error
 
@@ -424,32 +627,11 @@ func ApplyUpdatesWithSearch(originalContent, currentContent string, updates []Fi
 		searchLines := origLines[update.StartLine-1 : update.EndLine]
 		replaceLines := update.ReplaceLines
 
-		// Locate the *exact* sequence of searchLines within the current result.
-		matchIdx := -1
-		matchCount := 0
-		for i := 0; i+len(searchLines) <= len(resultLines); i++ {
-			if slices.Equal(searchLines, resultLines[i:i+len(searchLines)]) {
-				matchCount++
-				if matchIdx == -1 {
-					matchIdx = i
-				}
-			}
-		}
-		switch {
-		case matchCount == 0:
-			errs = append(errs, fmt.Errorf(
-				"search text not found in current file (lines %d-%d)",
-				update.StartLine, update.EndLine-1,
-			))
-			continue
-		case matchCount > 1:
-			errs = append(errs, fmt.Errorf(
-				"search text found %d times in current file (lines %d-%d), must be unique",
-				matchCount, update.StartLine, update.EndLine-1,
-			))
+		matchIdx, err := findSearchLines(searchLines, resultLines, searchSimilarityThreshold())
+		if err != nil {
+			errs = append(errs, fmt.Errorf("search lines %d-%d: %v",
+				update.StartLine, update.EndLine, err))
 			continue
-		default:
-			// exactly one match found; proceed with replacement
 		}
 
 		// Perform the replacement.
@@ -462,6 +644,185 @@ func ApplyUpdatesWithSearch(originalContent, currentContent string, updates []Fi
 	return strings.Join(resultLines, "\n"), errs
 }
 
+// normalizeWhitespace collapses runs of spaces and tabs and trims the line, so
+// indentation style and trailing whitespace do not affect matching
+func normalizeWhitespace(line string) string {
+	return strings.Join(strings.Fields(line), " ")
+}
+
+// searchSimilarityThreshold reads NINA_SEARCH_SIMILARITY, a value in (0,1]
+// enabling similarity matching. Zero or unset disables it.
+func searchSimilarityThreshold() float64 {
+	value := os.Getenv("NINA_SEARCH_SIMILARITY")
+	if value == "" {
+		return 0
+	}
+	threshold, err := strconv.ParseFloat(value, 64)
+	if err != nil || threshold < 0 || threshold > 1 {
+		return 0
+	}
+	return threshold
+}
+
+// findSearchLines returns the index in lines where searchLines occur. Matching
+// is tried in passes, each only if the previous found nothing:
+//
+//  1. exact
+//  2. whitespace-insensitive, via normalizeWhitespace
+//  3. similarity, when threshold > 0, picking the single best window whose
+//     average line similarity is at least threshold
+//
+// A pass with more than one candidate is an error rather than a guess.
+func findSearchLines(searchLines, lines []string, threshold float64) (int, error) {
+	if len(searchLines) == 0 {
+		return -1, fmt.Errorf("empty search text")
+	}
+	windows := len(lines) - len(searchLines) + 1
+
+	var matches []int
+	for i := 0; i < windows; i++ {
+		if slices.Equal(searchLines, lines[i:i+len(searchLines)]) {
+			matches = append(matches, i)
+		}
+	}
+	if len(matches) > 1 {
+		return -1, fmt.Errorf("search text found %d times%s, must be unique", len(matches), formatCandidates(matches))
+	}
+	if len(matches) == 1 {
+		return matches[0], nil
+	}
+
+	normSearch := make([]string, len(searchLines))
+	for i, line := range searchLines {
+		normSearch[i] = normalizeWhitespace(line)
+	}
+	normLines := make([]string, len(lines))
+	for i, line := range lines {
+		normLines[i] = normalizeWhitespace(line)
+	}
+	for i := 0; i < windows; i++ {
+		if slices.Equal(normSearch, normLines[i:i+len(normSearch)]) {
+			matches = append(matches, i)
+		}
+	}
+	if len(matches) > 1 {
+		return -1, fmt.Errorf("search text found %d times ignoring whitespace%s, must be unique", len(matches), formatCandidates(matches))
+	}
+	if len(matches) == 1 {
+		return matches[0], nil
+	}
+
+	if threshold <= 0 {
+		return -1, fmt.Errorf("search text not found")
+	}
+	bestScore := 0.0
+	for i := 0; i < windows; i++ {
+		score := 0.0
+		for j, line := range normSearch {
+			score += lineSimilarity(line, normLines[i+j])
+		}
+		score /= float64(len(normSearch))
+		switch {
+		case score < threshold:
+		case score > bestScore:
+			bestScore = score
+			matches = []int{i}
+		case score == bestScore:
+			matches = append(matches, i)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return -1, fmt.Errorf("search text not found, no candidate reached similarity %.2f", threshold)
+	case 1:
+		return matches[0], nil
+	default:
+		return -1, fmt.Errorf("search text ambiguous, %d candidates with similarity %.2f%s", len(matches), bestScore, formatCandidates(matches))
+	}
+}
+
+// formatCandidates describes 0-based match indexes as 1-based line numbers
+func formatCandidates(matches []int) string {
+	var lines []string
+	for _, idx := range matches {
+		lines = append(lines, strconv.Itoa(idx+1))
+	}
+	return " starting at lines " + strings.Join(lines, ", ")
+}
+
+// lineSimilarity returns 1 minus the normalized levenshtein distance of a and b
+func lineSimilarity(a, b string) float64 {
+	if a == b {
+		return 1
+	}
+	ra, rb := []rune(a), []rune(b)
+	longest := max(len(ra), len(rb))
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return 1 - float64(prev[len(rb)])/float64(longest)
+}
+
+// FindSearchRange deterministically locates searchLines in content that has
+// "<num>: " line prefixes, returning the 1-based line range of the single exact
+// match. Blank lines around the search are ignored. Errors when the search is
+// not found or is ambiguous, in which case callers may fall back to AI.
+func FindSearchRange(numberedContent string, searchLines []string) (RangeResult, error) {
+	searchLines = TrimBlankLines(searchLines)
+	if len(searchLines) == 0 {
+		return RangeResult{}, fmt.Errorf("empty search")
+	}
+
+	var lineNums []int
+	var lines []string
+	for i, line := range strings.Split(numberedContent, "\n") {
+		num := i + 1
+		if idx := strings.Index(line, ": "); idx > 0 && IsNumeric(line[:idx]) {
+			num, _ = strconv.Atoi(line[:idx])
+			line = line[idx+2:]
+		}
+		lineNums = append(lineNums, num)
+		lines = append(lines, line)
+	}
+
+	matchIdx := -1
+	matchCount := 0
+	for i := 0; i+len(searchLines) <= len(lines); i++ {
+		if slices.Equal(searchLines, lines[i:i+len(searchLines)]) {
+			matchCount++
+			if matchIdx == -1 {
+				matchIdx = i
+			}
+		}
+	}
+	switch matchCount {
+	case 0:
+		return RangeResult{}, fmt.Errorf("search text not found")
+	case 1:
+		start := lineNums[matchIdx]
+		end := lineNums[matchIdx+len(searchLines)-1]
+		if end-start+1 != len(searchLines) {
+			return RangeResult{}, fmt.Errorf("search text spans non-contiguous lines %d-%d", start, end)
+		}
+		return RangeResult{Start: start, End: end}, nil
+	default:
+		return RangeResult{}, fmt.Errorf("search text found %d times, must be unique", matchCount)
+	}
+}
+
 // addLineNumbers prefixes each line with a 1-based index for LLM context.
 func AddLineNumbers(content string) string {
 	lines := strings.Split(content, "\n")
@@ -513,6 +874,30 @@ func RemoveFencedBlocks(content string) string {
 	return strings.Join(parts, "\n")
 }
 
+// FormatNinaFile wraps a file's path and content in NinaFile tags, with the
+// Nina tags of the content escaped, see EscapeNinaTags.
+func FormatNinaFile(path, content string) string {
+	content = EscapeNinaTags(content)
+	var builder strings.Builder
+	builder.WriteString(NinaFileStart)
+	builder.WriteString("\n\n")
+	builder.WriteString(NinaPathStart)
+	builder.WriteString("\n")
+	builder.WriteString(path)
+	builder.WriteString("\n")
+	builder.WriteString(NinaPathEnd)
+	builder.WriteString("\n\n")
+	builder.WriteString(NinaContentStart)
+	builder.WriteString("\n")
+	builder.WriteString(content)
+	builder.WriteString("\n")
+	builder.WriteString(NinaContentEnd)
+	builder.WriteString("\n\n")
+	builder.WriteString(NinaFileEnd)
+	builder.WriteString("\n")
+	return builder.String()
+}
+
 // CalculateTokens returns approximate token count using o200k tokenizer.
 func CalculateTokens(text string) int {
 	enc, err := tokenizer.Get(tokenizer.O200kBase)
@@ -637,11 +1022,26 @@ func ParseNinaBash(output string) ([]BashCommand, error) {
 	for _, chunk := range bashChunks {
 		// Parse bash command - for now just store the raw content
 		// Could be enhanced to parse args separately if needed
+		chunk, stdin, err := splitTag(chunk, NinaStdinStart, NinaStdinEnd)
+		if err != nil {
+			return nil, err
+		}
+		// a final answer must end in a newline to be read as a whole line
+		stdin = strings.TrimPrefix(stdin, "\n")
+		if stdin != "" && !strings.HasSuffix(stdin, "\n") {
+			stdin += "\n"
+		}
+		chunk, cwd, err := splitTag(chunk, NinaCwdStart, NinaCwdEnd)
+		if err != nil {
+			return nil, err
+		}
 		cmd := strings.TrimSpace(chunk)
 		if cmd != "" {
 			commands = append(commands, BashCommand{
 				Command: cmd,
 				Args:    []string{},
+				Stdin:   stdin,
+				Cwd:     strings.TrimSpace(cwd),
 			})
 		}
 	}
@@ -649,6 +1049,208 @@ func ParseNinaBash(output string) ([]BashCommand, error) {
 	return commands, nil
 }
 
+// splitTag removes the first start and end tag from chunk, like NinaStdin
+// from a NinaBash, and returns chunk without it and its content
+func splitTag(chunk, start, end string) (string, string, error) {
+	i := strings.Index(chunk, start)
+	if i == -1 {
+		return chunk, "", nil
+	}
+	content, err := ExtractSingle(chunk, start, end)
+	if err != nil {
+		return "", "", err
+	}
+	j := i + len(start) + len(content) + len(end)
+	return chunk[:i] + chunk[j:], content, nil
+}
+
+// ParseNinaRemember extracts the facts of NinaRemember tags in NinaOutput
+func ParseNinaRemember(output string) ([]string, error) {
+	ninaOutput, err := ExtractSingle(output, NinaOutputStart, NinaOutputEnd)
+	if err != nil {
+		return nil, err
+	}
+	if ninaOutput == "" {
+		ninaOutput = output
+	}
+	chunks, err := ExtractAll(ninaOutput, NinaRememberStart, NinaRememberEnd)
+	if err != nil {
+		return nil, err
+	}
+	var facts []string
+	for _, chunk := range chunks {
+		if fact := strings.TrimSpace(chunk); fact != "" {
+			facts = append(facts, fact)
+		}
+	}
+	return facts, nil
+}
+
+// ParseNinaRead extracts the NinaRead requests from NinaOutput
+func ParseNinaRead(output string) ([]ReadRequest, error) {
+	ninaOutput, err := ExtractSingle(output, NinaOutputStart, NinaOutputEnd)
+	if err != nil {
+		return nil, err
+	}
+	if ninaOutput == "" {
+		ninaOutput = output
+	}
+	chunks, err := ExtractAll(ninaOutput, NinaReadStart, NinaReadEnd)
+	if err != nil {
+		return nil, err
+	}
+	var reads []ReadRequest
+	for _, chunk := range chunks {
+		path, err := ExtractSingle(chunk, NinaPathStart, NinaPathEnd)
+		if err != nil {
+			return nil, err
+		}
+		if strings.TrimSpace(path) == "" {
+			return nil, fmt.Errorf("NinaRead without NinaPath")
+		}
+		req := ReadRequest{Path: strings.TrimSpace(path)}
+		for _, field := range []struct {
+			start, end string
+			dest       *int
+		}{
+			{NinaStartLineStart, NinaStartLineEnd, &req.Start},
+			{NinaEndLineStart, NinaEndLineEnd, &req.End},
+		} {
+			value, err := ExtractSingle(chunk, field.start, field.end)
+			if err != nil {
+				return nil, err
+			}
+			if value = strings.TrimSpace(value); value == "" {
+				continue
+			}
+			if *field.dest, err = strconv.Atoi(value); err != nil {
+				return nil, fmt.Errorf("invalid %s in NinaRead: %s", field.start, value)
+			}
+		}
+		if req.Pattern, err = ExtractSingle(chunk, NinaPatternStart, NinaPatternEnd); err != nil {
+			return nil, err
+		}
+		req.Pattern = strings.Trim(req.Pattern, "\n")
+		reads = append(reads, req)
+	}
+	return reads, nil
+}
+
+// ParseNinaSearch extracts the NinaGrep or NinaGlob requests from NinaOutput,
+// start and end are the tags of the tool
+func ParseNinaSearch(output, start, end string) ([]SearchRequest, error) {
+	ninaOutput, err := ExtractSingle(output, NinaOutputStart, NinaOutputEnd)
+	if err != nil {
+		return nil, err
+	}
+	if ninaOutput == "" {
+		ninaOutput = output
+	}
+	chunks, err := ExtractAll(ninaOutput, start, end)
+	if err != nil {
+		return nil, err
+	}
+	var reqs []SearchRequest
+	for _, chunk := range chunks {
+		var req SearchRequest
+		for _, field := range []struct {
+			start, end string
+			dest       *string
+		}{
+			{NinaPatternStart, NinaPatternEnd, &req.Pattern},
+			{NinaPathStart, NinaPathEnd, &req.Path},
+			{NinaIncludeStart, NinaIncludeEnd, &req.Include},
+		} {
+			value, err := ExtractSingle(chunk, field.start, field.end)
+			if err != nil {
+				return nil, err
+			}
+			*field.dest = strings.Trim(value, "\n")
+		}
+		req.Path = strings.TrimSpace(req.Path)
+		req.Include = strings.TrimSpace(req.Include)
+		if req.Pattern == "" {
+			return nil, fmt.Errorf("%s without NinaPattern", start)
+		}
+		reqs = append(reqs, req)
+	}
+	return reqs, nil
+}
+
+// SpawnRequest is a NinaSpawn, starting Command or, without one, returning
+// the recent output of process ID
+type SpawnRequest struct {
+	Command string
+	ID      int
+}
+
+// ParseNinaSpawn extracts the NinaSpawn requests from NinaOutput
+func ParseNinaSpawn(output string) ([]SpawnRequest, error) {
+	chunks, err := extractTool(output, NinaSpawnStart, NinaSpawnEnd)
+	if err != nil {
+		return nil, err
+	}
+	var reqs []SpawnRequest
+	for _, chunk := range chunks {
+		cmd, err := ExtractSingle(chunk, NinaCmdStart, NinaCmdEnd)
+		if err != nil {
+			return nil, err
+		}
+		if cmd = strings.TrimSpace(cmd); cmd != "" {
+			reqs = append(reqs, SpawnRequest{Command: cmd})
+			continue
+		}
+		id, err := parseNinaID(chunk)
+		if err != nil {
+			return nil, fmt.Errorf("NinaSpawn needs a NinaCmd or a NinaId: %w", err)
+		}
+		reqs = append(reqs, SpawnRequest{ID: id})
+	}
+	return reqs, nil
+}
+
+// ParseNinaKill extracts the process ids of NinaKill tags in NinaOutput
+func ParseNinaKill(output string) ([]int, error) {
+	chunks, err := extractTool(output, NinaKillStart, NinaKillEnd)
+	if err != nil {
+		return nil, err
+	}
+	var ids []int
+	for _, chunk := range chunks {
+		id, err := parseNinaID(chunk)
+		if err != nil {
+			return nil, fmt.Errorf("NinaKill: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// extractTool returns the content of each start and end tag in NinaOutput
+func extractTool(output, start, end string) ([]string, error) {
+	ninaOutput, err := ExtractSingle(output, NinaOutputStart, NinaOutputEnd)
+	if err != nil {
+		return nil, err
+	}
+	if ninaOutput == "" {
+		ninaOutput = output
+	}
+	return ExtractAll(ninaOutput, start, end)
+}
+
+// parseNinaID returns the number in the NinaId tag of chunk
+func parseNinaID(chunk string) (int, error) {
+	value, err := ExtractSingle(chunk, NinaIdStart, NinaIdEnd)
+	if err != nil {
+		return 0, err
+	}
+	id, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid NinaId %q", strings.TrimSpace(value))
+	}
+	return id, nil
+}
+
 // ParseNinaStop extracts stop reason from NinaOutput
 func ParseNinaStop(output string) (string, error) {
 	ninaOutput, err := ExtractSingle(output, NinaOutputStart, NinaOutputEnd)
@@ -689,6 +1291,7 @@ func ParseNinaResult(input string) (*CommandResult, *ChangeResult, error) {
 		stdout, _ := ExtractSingle(result, "<NinaStdout>", "</NinaStdout>")
 		stderr, _ := ExtractSingle(result, "<NinaStderr>", "</NinaStderr>")
 		argsStr, _ := ExtractSingle(result, "<args>", "</args>")
+		cwd, _ := ExtractSingle(result, NinaCwdStart, NinaCwdEnd)
 
 		var args []string
 		if argsStr != "" {
@@ -697,6 +1300,7 @@ func ParseNinaResult(input string) (*CommandResult, *ChangeResult, error) {
 
 		return &CommandResult{
 			Command:  cmd,
+			Cwd:      cwd,
 			Args:     args,
 			ExitCode: exitCode,
 			Stdout:   stdout,
@@ -1,7 +1,11 @@
 package util
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
@@ -47,6 +51,32 @@ func TestParseFileUpdatesMissingFilenameSearchReplace(t *testing.T) {
 	}
 }
 
+func TestParseFileUpdatesSalvage(t *testing.T) {
+	change := func(parts ...string) string {
+		return NinaStart + strings.Join(parts, "") + NinaEnd
+	}
+	input := NinaOutputStart +
+		change(NinaPathStart, "/a.txt", NinaPathEnd, NinaSearchStart, "a", NinaSearchEnd, NinaReplaceStart, "A", NinaReplaceEnd) +
+		change(NinaPathStart, "/b.txt", NinaPathEnd, NinaSearchStart, "b", NinaReplaceStart, "B", NinaReplaceEnd) +
+		change(NinaPathStart, "/c.txt", NinaPathEnd, NinaSearchStart, "c", NinaSearchEnd) +
+		change(NinaPathStart, "/d.txt", NinaPathEnd, NinaReplaceStart, "D", NinaReplaceEnd) +
+		NinaOutputEnd
+	updates, err := ParseFileUpdates(input)
+	if len(updates) != 2 || updates[0].FileName != "/a.txt" || updates[1].FileName != "/d.txt" {
+		t.Fatalf("expected the well formed changes, got %+v", updates)
+	}
+	var changeErrs ChangeErrors
+	if !errors.As(err, &changeErrs) || len(changeErrs) != 2 {
+		t.Fatalf("expected two change errors, got %v", err)
+	}
+	if changeErrs[0].Index != 2 || changeErrs[0].Path != "/b.txt" || changeErrs[1].Index != 3 || !strings.Contains(changeErrs[1].Error(), "missing NinaReplace") {
+		t.Errorf("unexpected change errors %v", changeErrs)
+	}
+	if !SkipBrokenChanges(err) || SkipBrokenChanges(errors.New("other")) {
+		t.Error("SkipBrokenChanges should only accept ChangeErrors")
+	}
+}
+
 func TestParseMultipleBlocks(t *testing.T) {
 	input := fmt.Sprintf(`
 this is prose and should be ignored
@@ -838,6 +868,25 @@ func TestParseNinaBash(t *testing.T) {
 	}
 }
 
+func TestParseNinaRemember(t *testing.T) {
+	input := `<NinaOutput>
+<NinaRemember>
+  run tests with bin/check.sh
+</NinaRemember>
+<NinaRemember> </NinaRemember>
+<NinaBash>ls</NinaBash>
+<NinaRemember>logs go to stderr</NinaRemember>
+</NinaOutput>`
+	got, err := ParseNinaRemember(input)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{"run tests with bin/check.sh", "logs go to stderr"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
 func TestParseNinaStop(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -999,3 +1048,835 @@ drwxr-xr-x 2 user user 4096 Jan 1 12:00 .</NinaStdout>
 		})
 	}
 }
+
+func TestFindSearchRange(t *testing.T) {
+	content := AddLineNumbers("package main\n\nfunc a() {\n\treturn\n}\n\nfunc b() {\n\treturn\n}")
+	tests := []struct {
+		name    string
+		search  []string
+		want    RangeResult
+		wantErr bool
+	}{
+		{
+			name:   "unique match",
+			search: []string{"func a() {", "\treturn", "}"},
+			want:   RangeResult{Start: 3, End: 5},
+		},
+		{
+			name:   "surrounding blank lines ignored",
+			search: []string{"", "func b() {", ""},
+			want:   RangeResult{Start: 7, End: 7},
+		},
+		{
+			name:    "ambiguous",
+			search:  []string{"\treturn", "}"},
+			wantErr: true,
+		},
+		{
+			name:    "not found",
+			search:  []string{"func c() {"},
+			wantErr: true,
+		},
+		{
+			name:    "empty",
+			search:  []string{""},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := FindSearchRange(content, tt.search)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("FindSearchRange() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	// Partial numbered content keeps the original line numbers
+	partial := "10: x := 1\n11: y := 2"
+	got, err := FindSearchRange(partial, []string{"y := 2"})
+	if err != nil || got != (RangeResult{Start: 11, End: 11}) {
+		t.Errorf("partial content: got %+v, %v", got, err)
+	}
+}
+
+func TestFindSearchLines(t *testing.T) {
+	lines := []string{
+		"func a() {",
+		"\tif x {",
+		"\t\treturn 1  ",
+		"\t}",
+		"}",
+		"func b() {",
+		"    return 2",
+		"}",
+	}
+	tests := []struct {
+		name      string
+		search    []string
+		threshold float64
+		want      int
+		wantErr   string
+	}{
+		{
+			name:   "exact",
+			search: []string{"func b() {"},
+			want:   5,
+		},
+		{
+			name:   "spaces instead of tabs and no trailing whitespace",
+			search: []string{"    if x {", "        return 1", "    }"},
+			want:   1,
+		},
+		{
+			name:   "tab instead of spaces",
+			search: []string{"\treturn 2"},
+			want:   6,
+		},
+		{
+			name:    "ambiguous exact",
+			search:  []string{"}"},
+			wantErr: "found 2 times",
+		},
+		{
+			name:    "not found without threshold",
+			search:  []string{"func bb() {", "\treturn 2"},
+			wantErr: "not found",
+		},
+		{
+			name:      "similarity picks best candidate",
+			search:    []string{"func bb() {", "\treturn 2"},
+			threshold: 0.8,
+			want:      5,
+		},
+		{
+			name:      "similarity below threshold",
+			search:    []string{"func zzz() {"},
+			threshold: 0.95,
+			wantErr:   "no candidate reached similarity",
+		},
+		{
+			name:      "similarity ambiguous",
+			search:    []string{"func c() {"},
+			threshold: 0.5,
+			wantErr:   "ambiguous",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := findSearchLines(tt.search, lines, tt.threshold)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("findSearchLines() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFileOps(t *testing.T) {
+	output := NinaOutputStart + "\n" +
+		NinaRenameStart + NinaPathStart + "/a.go" + NinaPathEnd + NinaDestStart + " /b.go\n" + NinaDestEnd + NinaRenameEnd + "\n" +
+		NinaDeleteStart + "\n" + NinaPathStart + "\n/c.go\n" + NinaPathEnd + "\n" + NinaDeleteEnd + "\n" +
+		NinaRenameStart + NinaPathStart + "/d.go" + NinaPathEnd + NinaDestStart + "/e.go" + NinaDestEnd + NinaRenameEnd + "\n" +
+		NinaOutputEnd
+	ops, err := ParseFileOps(output)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []FileOp{
+		{Op: FileOpRename, Path: "/a.go", Dest: "/b.go"},
+		{Op: FileOpDelete, Path: "/c.go"},
+		{Op: FileOpRename, Path: "/d.go", Dest: "/e.go"},
+	}
+	if !reflect.DeepEqual(ops, expected) {
+		t.Fatalf("ParseFileOps() = %+v, want %+v", ops, expected)
+	}
+
+	_, err = ParseFileOps(NinaRenameStart + NinaPathStart + "/a.go" + NinaPathEnd + NinaRenameEnd)
+	if err == nil {
+		t.Errorf("expected error for rename without NinaDest")
+	}
+	_, err = ParseFileOps(NinaDeleteStart + NinaPathStart + "/a.go" + NinaPathEnd)
+	if err == nil {
+		t.Errorf("expected error for unclosed NinaDelete")
+	}
+}
+
+func TestCheckContent(t *testing.T) {
+	t.Setenv("NINA_MAX_FILE_SIZE", "16")
+	if err := CheckContent("a.txt", []byte("hello\nworld\n")); err != nil {
+		t.Errorf("unexpected error for text: %v", err)
+	}
+	if err := CheckContent("a.bin", []byte("hi\x00there")); !errors.Is(err, ErrBinaryFile) {
+		t.Errorf("expected ErrBinaryFile, got %v", err)
+	}
+	if err := CheckContent("a.bin", []byte{0xff, 0xfe, 'a'}); !errors.Is(err, ErrBinaryFile) || !errors.Is(err, ErrNotUTF8) {
+		t.Errorf("expected ErrNotUTF8 for invalid utf8, got %v", err)
+	}
+	if err := CheckContent("a.bin", []byte("hi\x00\xff")); errors.Is(err, ErrNotUTF8) {
+		t.Errorf("expected NUL bytes to be binary, not another encoding, got %v", err)
+	}
+	if err := CheckContent("big.txt", []byte(strings.Repeat("x", 17))); !errors.Is(err, ErrFileTooLarge) {
+		t.Errorf("expected ErrFileTooLarge, got %v", err)
+	}
+	t.Setenv("NINA_MAX_FILE_SIZE", "0")
+	if err := CheckContent("big.txt", []byte(strings.Repeat("x", 17))); err != nil {
+		t.Errorf("expected no limit, got %v", err)
+	}
+	// a multibyte rune split at the sniff boundary is still text
+	if IsBinary([]byte(strings.Repeat("a", binarySniffLen-1) + "é")) {
+		t.Errorf("expected split rune at boundary to be text")
+	}
+}
+
+func TestCheckPathAllowed(t *testing.T) {
+	root := t.TempDir()
+	other := t.TempDir()
+	t.Chdir(root)
+	t.Setenv("NINA_ALLOW_PATH", "")
+
+	tests := []struct {
+		name    string
+		path    string
+		allow   string
+		allowed bool
+	}{
+		{name: "inside root", path: root + "/a/b.go", allowed: true},
+		{name: "root itself", path: root, allowed: true},
+		{name: "relative inside root", path: "c.go", allowed: true},
+		{name: "dot dot", path: root + "/../x.go", allowed: false},
+		{name: "outside root", path: other + "/x.go", allowed: false},
+		{name: "allow path", path: other + "/x.go", allow: other, allowed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("NINA_ALLOW_PATH", tt.allow)
+			err := CheckPathAllowed(tt.path)
+			if tt.allowed && err != nil {
+				t.Errorf("expected allowed, got %v", err)
+			}
+			if !tt.allowed && !errors.Is(err, ErrPathNotAllowed) {
+				t.Errorf("expected ErrPathNotAllowed, got %v", err)
+			}
+		})
+	}
+
+	// a symlink inside the root cannot redirect writes outside it
+	if err := os.Symlink(other, filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckPathAllowed(filepath.Join(root, "link", "x.go")); !errors.Is(err, ErrPathNotAllowed) {
+		t.Errorf("expected symlink escape to be rejected, got %v", err)
+	}
+}
+
+func TestProtect(t *testing.T) {
+	root := t.TempDir()
+	t.Chdir(root)
+	t.Setenv("NINA_ALLOW_PATH", "")
+	t.Setenv("NINA_PROTECT", "")
+	ProtectPaths([]string{".github/workflows", "*.lock", ".env", "secrets.env"})
+
+	tests := []struct {
+		path      string
+		protected bool
+	}{
+		{".github/workflows/ci.yml", true},
+		{".github/workflows", true},
+		{".github/CODEOWNERS", false},
+		{"web/yarn.lock", true},
+		{"config/.env/prod", true},
+		{"main.go", false},
+		{"lock/main.go", false},
+	}
+	for _, tt := range tests {
+		err := CheckPathAllowed(filepath.Join(root, tt.path))
+		if tt.protected != errors.Is(err, ErrPathProtected) || (tt.protected && !errors.Is(err, ErrPathNotAllowed)) {
+			t.Errorf("%s: protected %v, got %v", tt.path, tt.protected, err)
+		}
+	}
+
+	// commands writing a protected file they name are not run
+	for _, cmd := range []string{
+		"echo x > .github/workflows/ci.yml",
+		"cat a >> web/yarn.lock",
+		"sed -i 's/a b/c/' web/yarn.lock",
+		"go mod tidy && rm -f yarn.lock",
+		"cp ci.yml .github/workflows/",
+		"FOO=1 tee -a .env < /dev/null",
+		"perl -pi -e 's/a/b/' yarn.lock",
+		`echo x > ".env"`,
+		"echo x >> 'web/yarn.lock'",
+		`sed -i 's/a/b/' "secrets.env"`,
+		`rm -f "config/.env"`,
+		`cp ci.yml ".github/workflows/ci.yml"`,
+		`dd if=/dev/zero of="yarn.lock" count=1`,
+		`"rm" "a b.txt" 'secrets.env'`,
+	} {
+		if result := ExecuteBash(BashCommand{Command: cmd}); result.ExitCode != -1 || !strings.Contains(result.Stderr, "protected") {
+			t.Errorf("expected %q to be refused, got %+v", cmd, result)
+		}
+	}
+	for _, cmd := range []string{
+		"cat yarn.lock > out.txt 2>&1",
+		"sed 's/a/b/' yarn.lock",
+		"cp yarn.lock backup",
+		"echo 'x > yarn.lock'",
+		`echo "a; rm .env" > "out.txt"`,
+		`sed -i "s/.env/yarn.lock/" "main.go"`,
+	} {
+		if result := ExecuteBash(BashCommand{Command: cmd}); strings.Contains(result.Stderr, "protected") {
+			t.Errorf("expected %q to run, got %+v", cmd, result)
+		}
+	}
+	// running in a protected directory is allowed
+	if err := os.MkdirAll(".github/workflows", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if result := ExecuteBash(BashCommand{Command: "ls", Cwd: ".github/workflows"}); result.ExitCode != 0 {
+		t.Errorf("unexpected result %+v", result)
+	}
+}
+
+func TestLogLevels(t *testing.T) {
+	var buf bytes.Buffer
+	prev := SetLogOutput(&buf)
+	defer SetLogOutput(prev)
+	t.Setenv("DEBUG", "")
+
+	t.Setenv("NINA_LOG", "quiet")
+	Infof("info")
+	Errorf("error: %d", 1)
+	if buf.String() != "error: 1\n" {
+		t.Errorf("quiet wrote %q", buf.String())
+	}
+
+	buf.Reset()
+	SetLogLevel(LogVerbose)
+	Verbosef("verbose\n")
+	Debugf("debug")
+	if buf.String() != "verbose\n" {
+		t.Errorf("verbose wrote %q", buf.String())
+	}
+
+	t.Setenv("NINA_LOG", "")
+	if GetLogLevel() != LogNormal {
+		t.Errorf("default level = %v", GetLogLevel())
+	}
+	t.Setenv("DEBUG", "1")
+	if GetLogLevel() != LogDebug {
+		t.Errorf("DEBUG level = %v", GetLogLevel())
+	}
+	if _, err := ParseLogLevel("loud"); err == nil {
+		t.Error("expected error for invalid level")
+	}
+}
+
+func TestColorEnabled(t *testing.T) {
+	var buf bytes.Buffer
+	t.Setenv("NO_COLOR", "")
+	t.Setenv("NINA_COLOR", "auto")
+	if ColorEnabled(&buf) {
+		t.Error("expected no color for a non-terminal writer")
+	}
+	t.Setenv("NINA_COLOR", "always")
+	if !ColorEnabled(&buf) {
+		t.Error("expected color with --color=always")
+	}
+	t.Setenv("NINA_COLOR", "never")
+	if got := ForTerminal(&buf, "\033[33m| status |\033[0m"); got != "| status |" {
+		t.Errorf("ForTerminal() = %q", got)
+	}
+	if _, err := ParseColorMode("sometimes"); err == nil {
+		t.Error("expected error for invalid color mode")
+	}
+}
+
+func TestTextFormat(t *testing.T) {
+	tests := []struct {
+		data   string
+		format TextFormat
+	}{
+		{"a\nb\n", TextFormat{LineEnding: "\n", FinalNewline: true}},
+		{"a\r\nb\r\n", TextFormat{LineEnding: "\r\n", FinalNewline: true}},
+		{"a\rb\r", TextFormat{LineEnding: "\r", FinalNewline: true}},
+		{"\ufeffa\r\nb", TextFormat{BOM: true, LineEnding: "\r\n"}},
+		{"a", TextFormat{LineEnding: "\n"}},
+		{"", TextFormat{LineEnding: "\n", FinalNewline: true}},
+	}
+	for _, tt := range tests {
+		normal, format := NormalizeText(tt.data)
+		if format != tt.format {
+			t.Errorf("NormalizeText(%q) format = %+v, want %+v", tt.data, format, tt.format)
+		}
+		if strings.ContainsAny(normal, "\r\ufeff") {
+			t.Errorf("NormalizeText(%q) = %q, still has CR or BOM", tt.data, normal)
+		}
+		if got := format.Restore(normal); got != tt.data {
+			t.Errorf("Restore(%q) = %q, want %q", normal, got, tt.data)
+		}
+	}
+}
+
+func TestExecuteChangeKeepsLineEndings(t *testing.T) {
+	dir := t.TempDir()
+	AllowPaths([]string{dir})
+	path := filepath.Join(dir, "a.txt")
+	if err := os.WriteFile(path, []byte("\ufeffone\r\ntwo\r\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if result := ExecuteChange(path, "", "one\nTWO\n"); result.Error != "" || result.Stderr != "" {
+		t.Fatalf("ExecuteChange failed: %+v", result)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "\ufeffone\r\nTWO\r\n" {
+		t.Errorf("a.txt = %q", data)
+	}
+}
+
+func TestExecuteChangeKeepsScriptModeAndFinalNewline(t *testing.T) {
+	dir := t.TempDir()
+	AllowPaths([]string{dir})
+	script := filepath.Join(dir, "run.sh")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\necho hi\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	// a rewrite as sent by the model, without the trailing newline
+	if result := ExecuteChange(script, "", "\n#!/bin/sh\necho bye"); result.Error != "" || result.Stderr != "" {
+		t.Fatalf("ExecuteChange failed: %+v", result)
+	}
+	if data, _ := os.ReadFile(script); string(data) != "#!/bin/sh\necho bye\n" {
+		t.Errorf("run.sh = %q", data)
+	}
+	if info, _ := os.Stat(script); info.Mode().Perm() != 0755 {
+		t.Errorf("run.sh mode = %v, want 0755", info.Mode().Perm())
+	}
+
+	// a file without a final newline keeps not having one
+	plain := filepath.Join(dir, "a.txt")
+	if err := os.WriteFile(plain, []byte("one"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if result := ExecuteChange(plain, "", "\ntwo\n"); result.Error != "" || result.Stderr != "" {
+		t.Fatalf("ExecuteChange failed: %+v", result)
+	}
+	if data, _ := os.ReadFile(plain); string(data) != "two" {
+		t.Errorf("a.txt = %q", data)
+	}
+	if info, _ := os.Stat(plain); info.Mode().Perm() != 0600 {
+		t.Errorf("a.txt mode = %v, want 0600", info.Mode().Perm())
+	}
+}
+
+func TestExecuteChangeSearch(t *testing.T) {
+	dir := t.TempDir()
+	AllowPaths([]string{dir})
+	path := filepath.Join(dir, "a.txt")
+	if err := os.WriteFile(path, []byte("one\ntwo\nthree\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if result := ExecuteChange(path, "two", "TWO\n2"); result.Error != "" || result.Stderr != "" {
+		t.Fatalf("ExecuteChange failed: %+v", result)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "one\nTWO\n2\nthree\n" {
+		t.Errorf("a.txt = %q", data)
+	}
+	if result := ExecuteChange(path, "four", "FOUR"); result.Stderr == "" {
+		t.Errorf("expected a missing search to fail, got %+v", result)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "one\nTWO\n2\nthree\n" {
+		t.Errorf("a.txt changed by a failed search: %q", data)
+	}
+}
+
+func TestSessionStateResolvePath(t *testing.T) {
+	dir := t.TempDir()
+	real := filepath.Join(dir, "real")
+	if err := os.Mkdir(real, 0755); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "link")
+	if err := os.Symlink(real, link); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(real, "a.txt")
+	if err := os.WriteFile(path, []byte("a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	session := &SessionState{PathMap: map[string]string{path: path, "_parent": dir}}
+
+	if got, ok := session.ResolvePath(filepath.Join(link, "a.txt")); !ok || got != path {
+		t.Errorf("ResolvePath through symlink = %q, %v", got, ok)
+	}
+	// a file not created yet under a symlinked directory
+	newPath := filepath.Join(real, "new.txt")
+	session.PathMap[newPath] = newPath
+	if got, ok := session.ResolvePath(filepath.Join(link, "new.txt")); !ok || got != newPath {
+		t.Errorf("ResolvePath of new file through symlink = %q, %v", got, ok)
+	}
+	if _, ok := session.ResolvePath(filepath.Join(link, "b.txt")); ok {
+		t.Errorf("expected no match for another file")
+	}
+	if _, ok := session.ResolvePath(filepath.Join(dir, "_parent")); ok {
+		t.Errorf("expected settings to never match a path")
+	}
+
+	// case only matches where the filesystem ignores it
+	upper := filepath.Join(real, "A.TXT")
+	_, ok := session.ResolvePath(upper)
+	if ok != CaseInsensitiveDir(real) {
+		t.Errorf("ResolvePath(%s) = %v, CaseInsensitiveDir = %v", upper, ok, CaseInsensitiveDir(real))
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	before := "a\nb\nc\nd\ne\nf\n"
+	tests := []struct {
+		after string
+		want  string
+	}{
+		{before, ""},
+		{"a\nb\nC\nd\ne\nf\n", "--- a/x\n+++ b/x\n@@ -2,3 +2,3 @@\n b\n-c\n+C\n d\n"},
+		{"a\nb\nc\nc2\nd\ne\nf\n", "--- a/x\n+++ b/x\n@@ -3,2 +3,3 @@\n c\n+c2\n d\n"},
+		{"b\nc\nd\ne\nf\n", "--- a/x\n+++ b/x\n@@ -1,2 +1,1 @@\n-a\n b\n"},
+		{"", "--- a/x\n+++ b/x\n@@ -1,6 +0,0 @@\n-a\n-b\n-c\n-d\n-e\n-f\n"},
+	}
+	for _, tt := range tests {
+		if got := UnifiedDiff("/x", before, tt.after, 1); got != tt.want {
+			t.Errorf("UnifiedDiff(%q) =\n%s\nwant\n%s", tt.after, got, tt.want)
+		}
+	}
+}
+
+func TestTruncateDiff(t *testing.T) {
+	diff := UnifiedDiff("/x", "", strings.Repeat("some line of text\n", 200), 2)
+	if got := TruncateDiff(diff, 10000); got != diff {
+		t.Error("diff within the limit was changed")
+	}
+	got := TruncateDiff(diff, 100)
+	if CalculateTokens(got) > 120 || !strings.Contains(got, "more lines\n") {
+		t.Errorf("diff not truncated: %d tokens\n%s", CalculateTokens(got), got)
+	}
+}
+
+func TestParseNinaRead(t *testing.T) {
+	output := NinaOutputStart + NinaReadStart + NinaPathStart + "/a.go" + NinaPathEnd + NinaReadEnd +
+		NinaReadStart + NinaPathStart + "/b.go" + NinaPathEnd + NinaStartLineStart + "10" + NinaStartLineEnd +
+		NinaEndLineStart + " 20 " + NinaEndLineEnd + NinaPatternStart + "\nfunc \\w+\n" + NinaPatternEnd + NinaReadEnd + NinaOutputEnd
+	reads, err := ParseNinaRead(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ReadRequest{{Path: "/a.go"}, {Path: "/b.go", Start: 10, End: 20, Pattern: `func \w+`}}
+	if !reflect.DeepEqual(reads, want) {
+		t.Errorf("got %+v, want %+v", reads, want)
+	}
+	bad := NinaOutputStart + NinaReadStart + NinaPathStart + "/a.go" + NinaPathEnd + NinaStartLineStart + "ten" + NinaStartLineEnd + NinaReadEnd + NinaOutputEnd
+	if _, err := ParseNinaRead(bad); err == nil {
+		t.Error("expected an error for a start line that is not a number")
+	}
+}
+
+func TestExecuteRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.txt")
+	var lines []string
+	for i := 1; i <= 500; i++ {
+		lines = append(lines, fmt.Sprintf("line %d", i))
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	result := ExecuteRead(ReadRequest{Path: path})
+	if result.Error != "" || result.Start != 1 || result.End != DefaultReadLines || result.TotalLines != 500 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	if !strings.HasPrefix(result.Content, "1: line 1\n2: line 2\n") || !strings.HasSuffix(result.Content, "200: line 200") {
+		t.Errorf("unexpected content %q", result.Content[:40])
+	}
+	if result.Summary() != "1-200 of 500" {
+		t.Errorf("summary = %q", result.Summary())
+	}
+
+	result = ExecuteRead(ReadRequest{Path: path, Start: 498, End: 600})
+	if result.Content != "498: line 498\n499: line 499\n500: line 500" || result.Summary() != "498-500 of 500" {
+		t.Errorf("unexpected result %+v", result)
+	}
+
+	result = ExecuteRead(ReadRequest{Path: path, Pattern: `^line 4\d\d$`, Start: 450})
+	if result.Matches != 50 || !strings.HasPrefix(result.Content, "450: line 450\n") || result.Summary() != `50 lines matching "^line 4\\d\\d$" in 450-500 of 500` {
+		t.Errorf("unexpected result %d %q", result.Matches, result.Summary())
+	}
+
+	for _, req := range []ReadRequest{{Path: path, Start: 501}, {Path: path, Pattern: "("}, {Path: path + ".missing"}} {
+		if result := ExecuteRead(req); result.Error == "" {
+			t.Errorf("expected an error for %+v", req)
+		}
+	}
+
+	long := filepath.Join(t.TempDir(), "long.txt")
+	if err := os.WriteFile(long, []byte(strings.Repeat("some words on a long line of text, again and again\n", 1000)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	result = ExecuteRead(ReadRequest{Path: long, End: 1000})
+	if !result.Truncated || result.End >= 1000 || CalculateTokens(result.Content) > MaxReadTokens {
+		t.Errorf("expected the read to be cut short, got end %d", result.End)
+	}
+	if !strings.Contains(result.Summary(), fmt.Sprintf("continue from line %d", result.End+1)) {
+		t.Errorf("summary = %q", result.Summary())
+	}
+}
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"*.go", "main.go", true},
+		{"*.go", "cmd/run/main.go", true},
+		{"*.go", "main.md", false},
+		{"cmd/*/main.go", "cmd/run/main.go", true},
+		{"cmd/*/main.go", "cmd/run/sub/main.go", false},
+		{"cmd/**/main.go", "cmd/run/sub/main.go", true},
+		{"cmd/**/main.go", "cmd/main.go", true},
+		{"**/*_test.go", "lib/loop_test.go", true},
+		{"**/*_test.go", "lib/loop.go", false},
+		{"lib/**", "lib/processors/json.go", true},
+	}
+	for _, tt := range tests {
+		if got := MatchGlob(tt.pattern, tt.name); got != tt.want {
+			t.Errorf("MatchGlob(%q, %q) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestExecuteSearch(t *testing.T) {
+	t.Chdir(t.TempDir())
+	for name, content := range map[string]string{
+		"main.go":          "package main\n\nfunc main() {}\n",
+		"lib/lib.go":       "package lib\n\nfunc Run() {}\n\nfunc stop() {}\n",
+		"lib/lib_test.go":  "package lib\n\nfunc TestRun(t *testing.T) {}\n",
+		"docs/README.md":   "func in docs\n",
+		".git/config":      "func in git\n",
+		"lib/processors/x": "no match\n",
+	} {
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	result := ExecuteGrep(SearchRequest{Pattern: `^func [A-Z]`, Include: "*.go"})
+	want := []string{"./lib/lib.go:3: func Run() {}", "./lib/lib_test.go:3: func TestRun(t *testing.T) {}"}
+	if result.Error != "" || !reflect.DeepEqual(result.Lines, want) || result.Summary("match") != "2 matches in 2 files" {
+		t.Errorf("unexpected result %+v", result)
+	}
+	result = ExecuteGrep(SearchRequest{Pattern: "func", Path: "lib/lib.go"})
+	if result.Total != 2 || result.Lines[0] != "lib/lib.go:3: func Run() {}" {
+		t.Errorf("unexpected result %+v", result)
+	}
+	if result := ExecuteGrep(SearchRequest{Pattern: "nothing matches this"}); result.Error != "" || result.Total != 0 {
+		t.Errorf("unexpected result %+v", result)
+	}
+	if result := ExecuteGrep(SearchRequest{Pattern: "func", Path: "missing"}); result.Error == "" {
+		t.Error("expected an error for a missing path")
+	}
+
+	result = ExecuteGlob(SearchRequest{Pattern: "**/*.go"})
+	want = []string{"./lib/lib.go", "./lib/lib_test.go", "./main.go"}
+	if result.Error != "" || !reflect.DeepEqual(result.Lines, want) || result.Summary("file") != "3 files" {
+		t.Errorf("unexpected result %+v", result)
+	}
+	result = ExecuteGlob(SearchRequest{Pattern: "*_test.go", Path: "lib"})
+	if !reflect.DeepEqual(result.Lines, []string{"lib/lib_test.go"}) {
+		t.Errorf("unexpected result %+v", result)
+	}
+	if result := ExecuteGlob(SearchRequest{Pattern: "[", Path: "lib"}); result.Error == "" {
+		t.Error("expected an error for an invalid pattern")
+	}
+
+	lines, truncated := boundLines([]string{"a", "b", "c"}, 2)
+	if !truncated || len(lines) != 2 {
+		t.Errorf("unexpected bound %v %v", lines, truncated)
+	}
+}
+
+func TestParseNinaSearch(t *testing.T) {
+	output := NinaOutputStart + NinaGrepStart + NinaPatternStart + "\nfunc \\w+\n" + NinaPatternEnd + NinaIncludeStart + "*.go" + NinaIncludeEnd + NinaGrepEnd +
+		NinaGlobStart + NinaPatternStart + "**/*.md" + NinaPatternEnd + NinaPathStart + " docs " + NinaPathEnd + NinaGlobEnd + NinaOutputEnd
+	greps, err := ParseNinaSearch(output, NinaGrepStart, NinaGrepEnd)
+	if err != nil || !reflect.DeepEqual(greps, []SearchRequest{{Pattern: `func \w+`, Include: "*.go"}}) {
+		t.Errorf("unexpected greps %+v %v", greps, err)
+	}
+	globs, err := ParseNinaSearch(output, NinaGlobStart, NinaGlobEnd)
+	if err != nil || !reflect.DeepEqual(globs, []SearchRequest{{Pattern: "**/*.md", Path: "docs"}}) {
+		t.Errorf("unexpected globs %+v %v", globs, err)
+	}
+	if _, err := ParseNinaSearch(NinaOutputStart+NinaGrepStart+NinaGrepEnd+NinaOutputEnd, NinaGrepStart, NinaGrepEnd); err == nil {
+		t.Error("expected an error without a pattern")
+	}
+}
+
+func TestParseNinaSpawn(t *testing.T) {
+	output := NinaOutputStart + NinaSpawnStart + NinaCmdStart + "\nnpm run dev\n" + NinaCmdEnd + NinaSpawnEnd +
+		NinaSpawnStart + NinaIdStart + " 2 " + NinaIdEnd + NinaSpawnEnd +
+		NinaKillStart + NinaIdStart + "1" + NinaIdEnd + NinaKillEnd + NinaOutputEnd
+	spawns, err := ParseNinaSpawn(output)
+	if err != nil || !reflect.DeepEqual(spawns, []SpawnRequest{{Command: "npm run dev"}, {ID: 2}}) {
+		t.Errorf("unexpected spawns %+v %v", spawns, err)
+	}
+	kills, err := ParseNinaKill(output)
+	if err != nil || !reflect.DeepEqual(kills, []int{1}) {
+		t.Errorf("unexpected kills %v %v", kills, err)
+	}
+	if _, err := ParseNinaSpawn(NinaOutputStart + NinaSpawnStart + NinaSpawnEnd + NinaOutputEnd); err == nil {
+		t.Error("expected an error for a NinaSpawn without a command or id")
+	}
+	if _, err := ParseNinaKill(NinaOutputStart + NinaKillStart + NinaIdStart + "one" + NinaIdEnd + NinaKillEnd + NinaOutputEnd); err == nil {
+		t.Error("expected an error for an invalid id")
+	}
+}
+
+func TestParseNinaBashStdin(t *testing.T) {
+	output := NinaOutputStart + NinaBashStart + "read -r a b; echo $a-$b\n" + NinaStdinStart + "\nyes\nno" + NinaStdinEnd + "\n" + NinaBashEnd + NinaOutputEnd
+	cmds, err := ParseNinaBash(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []BashCommand{{Command: "read -r a b; echo $a-$b", Args: []string{}, Stdin: "yes\nno\n"}}
+	if !reflect.DeepEqual(cmds, want) {
+		t.Fatalf("got %+v, want %+v", cmds, want)
+	}
+	if result := ExecuteBash(BashCommand{Command: "read -r a; read -r b; echo $a-$b-$CI-$GIT_TERMINAL_PROMPT", Stdin: cmds[0].Stdin}); result.Stdout != "yes-no-1-0\n" {
+		t.Errorf("unexpected result %+v", result)
+	}
+	if result := ExecuteBash(BashCommand{Command: "nosuchcommand"}); !strings.Contains(result.Stderr, "line 1:") {
+		t.Errorf("unexpected stderr %q", result.Stderr)
+	}
+	t.Setenv("NINA_INTERACTIVE", "1")
+	t.Setenv("CI", "")
+	if result := ExecuteBash(BashCommand{Command: "echo -$CI-"}); result.Stdout != "--\n" {
+		t.Errorf("unexpected result %+v", result)
+	}
+}
+
+func TestExecuteBashCwd(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	if err := os.MkdirAll(filepath.Join("pkg", "a"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile("file.txt", nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	output := NinaOutputStart + NinaBashStart + NinaCwdStart + " pkg/a " + NinaCwdEnd + "\npwd\n" + NinaBashEnd + NinaOutputEnd
+	cmds, err := ParseNinaBash(output)
+	if err != nil || len(cmds) != 1 || cmds[0].Command != "pwd" || cmds[0].Cwd != "pkg/a" {
+		t.Fatalf("unexpected commands %+v %v", cmds, err)
+	}
+	result := ExecuteBash(cmds[0])
+	want := filepath.Join(dir, "pkg", "a")
+	if result.ExitCode != 0 || result.Stdout != want+"\n" || result.Cwd != want {
+		t.Errorf("unexpected result %+v", result)
+	}
+	if result := ExecuteBash(BashCommand{Command: "pwd"}); result.Cwd != dir {
+		t.Errorf("unexpected cwd %q", result.Cwd)
+	}
+	for _, cwd := range []string{"/", "../", "file.txt", "missing"} {
+		if result := ExecuteBash(BashCommand{Command: "touch ran", Cwd: cwd}); result.ExitCode != -1 || result.Stderr == "" {
+			t.Errorf("expected cwd %q to be refused, got %+v", cwd, result)
+		}
+	}
+	if _, err := os.Stat("ran"); err == nil {
+		t.Error("refused command ran")
+	}
+}
+
+func TestExecuteBashSpillsOutput(t *testing.T) {
+	t.Chdir(t.TempDir())
+	result := ExecuteBash(BashCommand{Command: "seq 1 20000; echo short >&2"})
+	if result.ExitCode != 0 || result.Stderr != "short\n" {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	if !strings.HasPrefix(result.Stdout, "1\n2\n") || !strings.HasSuffix(result.Stdout, "19999\n20000\n") {
+		t.Errorf("expected the first and last lines, got %q", result.Stdout)
+	}
+	if tokens := CalculateTokens(result.Stdout); tokens > MaxOutputTokens+100 {
+		t.Errorf("spilled output is %d tokens", tokens)
+	}
+	_, after, ok := strings.Cut(result.Stdout, "the full output of 20000 lines is in ")
+	path, _, _ := strings.Cut(after, ",")
+	if !ok || !strings.Contains(path, filepath.Join("agents", "artifacts")) || path != result.StdoutFile || result.StderrFile != "" {
+		t.Fatalf("no artifact path in %+v", result)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 20000 {
+		t.Errorf("artifact has %d lines", lines)
+	}
+	read := ExecuteRead(ReadRequest{Path: path, Pattern: "^12345$"})
+	if read.Error != "" || read.Content != "12345: 12345" {
+		t.Errorf("unexpected read %+v", read)
+	}
+}
+
+func TestNinaTagEscaping(t *testing.T) {
+	contents := []string{
+		"plain text",
+		"<NinaSearch>\nold\n</NinaSearch>",
+		`already <\NinaPath> and <\\/NinaChange> escaped`,
+		"<Ninja> <nina> and < NinaX> are not tags",
+	}
+	for _, content := range contents {
+		escaped := EscapeNinaTags(content)
+		if got := UnescapeNinaTags(escaped); got != content {
+			t.Errorf("round trip of %q gave %q", content, got)
+		}
+		for _, tag := range []string{NinaSearchStart, NinaSearchEnd, NinaPathStart, NinaStart, NinaEnd} {
+			if strings.Contains(escaped, tag) {
+				t.Errorf("escaped %q still holds %s", escaped, tag)
+			}
+		}
+	}
+	if got := EscapeNinaTags("</NinaSearch>"); got != `<\/NinaSearch>` {
+		t.Errorf("EscapeNinaTags() = %q", got)
+	}
+
+	// a file holding the tag vocabulary goes through a change unbroken
+	file := "prompt := `\n<NinaChange>\n<NinaSearch>\nx\n</NinaSearch>\n</NinaChange>\n`"
+	edited := strings.Replace(file, "x", "y", 1)
+	formatted := FormatNinaFile("/a.go", file)
+	if strings.Count(formatted, NinaContentEnd) != 1 || strings.Contains(formatted, NinaSearchStart) {
+		t.Errorf("unescaped tags in\n%s", formatted)
+	}
+	response := NinaOutputStart + NinaStart + NinaPathStart + "/a.go" + NinaPathEnd +
+		NinaSearchStart + "\n" + EscapeNinaTags(file) + "\n" + NinaSearchEnd +
+		NinaReplaceStart + "\n" + EscapeNinaTags(edited) + "\n" + NinaReplaceEnd + NinaEnd + NinaOutputEnd
+	updates, err := ParseFileUpdates(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(updates) != 1 || strings.Join(updates[0].SearchLines, "\n") != file || strings.Join(updates[0].ReplaceLines, "\n") != edited {
+		t.Errorf("unexpected updates %#v", updates)
+	}
+}
@@ -0,0 +1,138 @@
+// pathpolicy.go restricts where model driven file changes may write, by
+// default to the git root, or the working directory outside of a repo.
+// Remote workspaces use their directory instead. Additional roots come from
+// NINA_ALLOW_PATH, a list separated like PATH, which commands set from
+// --allow-path.
+package util
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/nathants/nina/workspace"
+)
+
+var ErrPathNotAllowed = errors.New("path not allowed")
+
+// AllowedRoots returns the directories file changes may write under
+func AllowedRoots() []string {
+	var roots []string
+	ws := workspace.Base(workspace.Current())
+	gitRoot := ""
+	if workspace.IsLocal(ws) {
+		gitRoot = GetGitRoot()
+	}
+	// remote workspaces without a directory only allow configured roots
+	switch root := workspace.Root(ws); {
+	case root != "":
+		roots = append(roots, root)
+	case gitRoot != "":
+		roots = append(roots, gitRoot)
+	case workspace.IsLocal(ws):
+		if cwd, err := os.Getwd(); err == nil {
+			roots = append(roots, cwd)
+		}
+	}
+	for _, root := range filepath.SplitList(os.Getenv("NINA_ALLOW_PATH")) {
+		if root == "" {
+			continue
+		}
+		if abs, err := filepath.Abs(expandHome(root)); err == nil {
+			roots = append(roots, abs)
+		}
+	}
+	return roots
+}
+
+// AllowPaths adds roots to NINA_ALLOW_PATH for this process and any children
+func AllowPaths(paths []string) {
+	if len(paths) == 0 {
+		return
+	}
+	existing := filepath.SplitList(os.Getenv("NINA_ALLOW_PATH"))
+	_ = os.Setenv("NINA_ALLOW_PATH", strings.Join(append(existing, paths...), string(os.PathListSeparator)))
+}
+
+// CheckPathAllowed returns ErrPathNotAllowed, wrapped with the reason, when
+// path contains a .. element or resolves outside every allowed root, and
+// ErrPathProtected when it is protected, see CheckProtected. Symlinks are
+// resolved so a link inside a root cannot redirect writes outside it.
+func CheckPathAllowed(path string) error {
+	abs, err := checkRoots(path)
+	if err != nil {
+		return err
+	}
+	return CheckProtected(abs)
+}
+
+// checkRoots returns path absolute, or ErrPathNotAllowed when it is outside
+// every allowed root, see CheckPathAllowed
+func checkRoots(path string) (string, error) {
+	path = expandHome(path)
+	if slices.Contains(strings.Split(filepath.ToSlash(path), "/"), "..") {
+		return "", fmt.Errorf("%w: %s contains ..", ErrPathNotAllowed, path)
+	}
+	ws := workspace.Base(workspace.Current())
+	abs := path
+	var err error
+	if !filepath.IsAbs(path) {
+		if root := workspace.Root(ws); root != "" {
+			abs = filepath.Join(root, path)
+		} else if abs, err = filepath.Abs(path); err != nil {
+			return "", err
+		}
+	}
+
+	// symlinks can only be resolved for local files
+	resolve := func(p string) string { return p }
+	if workspace.IsLocal(ws) {
+		resolve = resolveExisting
+	}
+	roots := AllowedRoots()
+	if len(roots) == 0 {
+		return "", fmt.Errorf("%w: %s, the workspace has no directory (use ssh://host:path or --allow-path)", ErrPathNotAllowed, abs)
+	}
+	for _, root := range roots {
+		path, dir := resolve(abs), resolve(root)
+		if withinDir(path, dir) {
+			return abs, nil
+		}
+		// a root named with other case on a case-insensitive filesystem
+		if workspace.IsLocal(ws) && withinDir(strings.ToLower(path), strings.ToLower(dir)) && CaseInsensitiveDir(dir) {
+			return abs, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s is outside %s (use --allow-path to permit)",
+		ErrPathNotAllowed, abs, strings.Join(roots, ", "))
+}
+
+// resolveExisting resolves symlinks in the longest existing prefix of path,
+// keeping the remaining, not yet created, elements as is
+func resolveExisting(path string) string {
+	var rest []string
+	current := path
+	for {
+		if resolved, err := filepath.EvalSymlinks(current); err == nil {
+			return filepath.Join(append([]string{resolved}, rest...)...)
+		}
+		parent := filepath.Dir(current)
+		if parent == current {
+			return path
+		}
+		rest = append([]string{filepath.Base(current)}, rest...)
+		current = parent
+	}
+}
+
+// withinDir reports whether path is dir or is below it
+func withinDir(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))
+}
@@ -0,0 +1,202 @@
+// protect.go keeps model driven changes away from protected paths, like
+// .github/workflows, secrets, or lockfiles. Patterns come from NINA_PROTECT,
+// a list separated like PATH, which commands set from --protect. A pattern
+// without a slash matches a file or directory name anywhere, like *.lock or
+// .env, others match paths from the git root, like .github/workflows, or are
+// absolute. Everything below a matching directory is protected.
+//
+// NinaChange, NinaDelete, and NinaRename refuse protected paths, see
+// CheckPathAllowed, and a NinaBash or NinaSpawn naming one as the target of a
+// write, like a redirect, sed -i, rm, or mv, is not run. Commands that write
+// files they do not name, like code generators, are not caught.
+package util
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ErrPathProtected is a path matching a NINA_PROTECT pattern, it is also an
+// ErrPathNotAllowed
+var ErrPathProtected = fmt.Errorf("%w, protected", ErrPathNotAllowed)
+
+// ProtectPaths adds patterns to NINA_PROTECT for this process and any children
+func ProtectPaths(patterns []string) {
+	if len(patterns) == 0 {
+		return
+	}
+	existing := filepath.SplitList(os.Getenv("NINA_PROTECT"))
+	_ = os.Setenv("NINA_PROTECT", strings.Join(append(existing, patterns...), string(os.PathListSeparator)))
+}
+
+// protectPatterns returns the NINA_PROTECT patterns
+func protectPatterns() []string {
+	var patterns []string
+	for _, pattern := range filepath.SplitList(os.Getenv("NINA_PROTECT")) {
+		pattern = strings.TrimSuffix(filepath.ToSlash(strings.TrimSpace(pattern)), "/")
+		if pattern != "" {
+			patterns = append(patterns, expandHome(pattern))
+		}
+	}
+	return patterns
+}
+
+// CheckProtected returns ErrPathProtected, wrapped with the pattern, when the
+// absolute path abs matches a NINA_PROTECT pattern
+func CheckProtected(abs string) error {
+	patterns := protectPatterns()
+	if len(patterns) == 0 {
+		return nil
+	}
+	abs = filepath.Clean(abs)
+	var rels [][]string
+	for _, root := range AllowedRoots() {
+		if withinDir(abs, root) {
+			rel, _ := filepath.Rel(root, abs)
+			rels = append(rels, strings.Split(filepath.ToSlash(rel), "/"))
+		}
+	}
+	// names are matched below the roots, or in the whole path outside them
+	names := strings.Split(filepath.ToSlash(abs), "/")
+	if len(rels) > 0 {
+		names = slices.Concat(rels...)
+	}
+	for _, pattern := range patterns {
+		var matched bool
+		switch {
+		case filepath.IsAbs(pattern):
+			matched = matchPrefix(pattern, strings.Split(filepath.ToSlash(abs), "/"))
+		case !strings.Contains(pattern, "/"):
+			matched = slices.ContainsFunc(names, func(name string) bool {
+				ok, _ := filepath.Match(pattern, name)
+				return ok
+			})
+		default:
+			matched = slices.ContainsFunc(rels, func(rel []string) bool { return matchPrefix(pattern, rel) })
+		}
+		if matched {
+			return fmt.Errorf("%w: %s matches %s (NINA_PROTECT)", ErrPathProtected, abs, pattern)
+		}
+	}
+	return nil
+}
+
+// matchPrefix reports whether pattern matches the path of elements or one of
+// its parent directories
+func matchPrefix(pattern string, elements []string) bool {
+	for i := 1; i <= len(elements); i++ {
+		if ok, _ := filepath.Match(pattern, strings.Join(elements[:i], "/")); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// quoted matches quoted strings, which stand in as placeholders while a
+// command is split, so a quoted ; or > does not split it, and are unquoted in
+// the targets found
+var quoted = regexp.MustCompile(`'[^']*'|"[^"]*"`)
+
+// placeholder matches a quoted string swapped out of a command
+var placeholder = regexp.MustCompile("\x00([0-9]+)\x00")
+
+// perlInPlace matches the perl flags that edit files in place, like -pi
+var perlInPlace = regexp.MustCompile(`^-[lnpa0]*i`)
+
+// redirectTarget matches the file of an output redirect, not a redirect to
+// another descriptor like 2>&1
+var redirectTarget = regexp.MustCompile(`>>?\|?\s*([^\s;|&<>()]+)`)
+
+// writeCommands are the commands whose file arguments are written, true when
+// only the last one is, like the destination of cp
+var writeCommands = map[string]bool{
+	"rm": false, "mv": false, "touch": false, "truncate": false, "chmod": false, "chown": false,
+	"tee": false, "shred": false, "unlink": false, "rmdir": false,
+	"cp": true, "ln": true, "install": true, "rsync": true,
+}
+
+// CheckCommandProtected returns ErrPathProtected when command, run in dir,
+// writes to a protected path it names
+func CheckCommandProtected(command, dir string) error {
+	if len(protectPatterns()) == 0 {
+		return nil
+	}
+	for _, target := range writeTargets(command) {
+		target = expandHome(strings.Trim(target, `"'`))
+		if target == "" || strings.HasPrefix(target, "/dev/") {
+			continue
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(dir, target)
+		}
+		if err := CheckProtected(target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeTargets returns the paths command names as the target of a write:
+// redirects, the files of writeCommands, of sed and perl with -i, and dd of=
+func writeTargets(command string) []string {
+	var strs []string
+	command = quoted.ReplaceAllStringFunc(command, func(s string) string {
+		strs = append(strs, s[1:len(s)-1])
+		return fmt.Sprintf("\x00%d\x00", len(strs)-1)
+	})
+	unquote := func(word string) string {
+		return placeholder.ReplaceAllStringFunc(word, func(m string) string {
+			i, _ := strconv.Atoi(strings.Trim(m, "\x00"))
+			return strs[i]
+		})
+	}
+	var targets []string
+	add := func(words ...string) {
+		for _, word := range words {
+			targets = append(targets, unquote(word))
+		}
+	}
+	for _, match := range redirectTarget.FindAllStringSubmatch(command, -1) {
+		add(match[1])
+	}
+	simple := strings.FieldsFunc(command, func(r rune) bool { return strings.ContainsRune(";|&\n()", r) })
+	for _, cmd := range simple {
+		words := strings.Fields(redirectTarget.ReplaceAllString(cmd, ""))
+		// skip variable assignments and wrappers like sudo
+		for len(words) > 0 && (strings.Contains(words[0], "=") || words[0] == "sudo" || words[0] == "env" || words[0] == "command") {
+			words = words[1:]
+		}
+		if len(words) == 0 {
+			continue
+		}
+		name := filepath.Base(unquote(words[0]))
+		var args []string
+		inPlace := false
+		for _, word := range words[1:] {
+			switch {
+			case name == "dd" && strings.HasPrefix(word, "of="):
+				add(strings.TrimPrefix(word, "of="))
+			case strings.HasPrefix(word, "-"):
+				inPlace = inPlace || strings.HasPrefix(word, "-i") || word == "--in-place" || (name == "perl" && perlInPlace.MatchString(word))
+			default:
+				args = append(args, word)
+			}
+		}
+		lastOnly, ok := writeCommands[name]
+		switch {
+		case ok && lastOnly && len(args) > 0:
+			add(args[len(args)-1])
+		case ok:
+			add(args...)
+		case (name == "sed" || name == "perl") && inPlace && len(args) > 1:
+			// the first argument is the script
+			add(args[1:]...)
+		}
+	}
+	return targets
+}
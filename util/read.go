@@ -0,0 +1,118 @@
+// read.go reads numbered line ranges of a file for NinaRead, so a model can
+// page through a file or grep it instead of printing all of it with cat
+package util
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/nathants/nina/workspace"
+)
+
+// DefaultReadLines is how many lines a NinaRead without an end returns
+const DefaultReadLines = 200
+
+// MaxReadTokens bounds the content a NinaRead returns, the range is cut short
+// when it is larger
+const MaxReadTokens = 4000
+
+// ReadRequest is a NinaRead, Start and End are 1-based and inclusive, zero
+// when unset
+type ReadRequest struct {
+	Path    string
+	Start   int
+	End     int
+	Pattern string // only lines matching this regexp are returned
+}
+
+// ReadResult is the numbered lines of a NinaRead
+type ReadResult struct {
+	Path       string
+	Content    string // lines as "<num>: <line>", see AddLineNumbers
+	Start      int
+	End        int // last line read, less than asked for when Truncated
+	TotalLines int
+	Pattern    string
+	Matches    int // lines matching Pattern
+	Truncated  bool
+	Error      string
+}
+
+// Summary describes the lines returned, such as "1-200 of 1520"
+func (r ReadResult) Summary() string {
+	summary := fmt.Sprintf("%d-%d of %d", r.Start, r.End, r.TotalLines)
+	if r.TotalLines == 0 {
+		summary = "empty file"
+	}
+	if r.Pattern != "" {
+		summary = fmt.Sprintf("%d lines matching %q in %s", r.Matches, r.Pattern, summary)
+	}
+	if r.Truncated {
+		summary += fmt.Sprintf(", cut short at %d tokens, continue from line %d", MaxReadTokens, r.End+1)
+	}
+	return summary
+}
+
+// ExecuteRead returns the numbered lines of a file between Start and End,
+// DefaultReadLines from Start when End is unset, and at most MaxReadTokens
+func ExecuteRead(req ReadRequest) ReadResult {
+	path := expandHome(strings.TrimSpace(req.Path))
+	result := ReadResult{Path: path, Pattern: req.Pattern}
+	var pattern *regexp.Regexp
+	if req.Pattern != "" {
+		var err error
+		if pattern, err = regexp.Compile(req.Pattern); err != nil {
+			result.Error = fmt.Sprintf("invalid pattern: %v", err)
+			return result
+		}
+	}
+	data, err := workspace.Current().ReadFile(path)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	if IsBinary(data) {
+		result.Error = binaryError(path, data).Error()
+		return result
+	}
+	lines := splitLines(string(data))
+	result.TotalLines = len(lines)
+
+	start := max(req.Start, 1)
+	end := req.End
+	if end <= 0 {
+		end = start + DefaultReadLines - 1
+		if pattern != nil {
+			end = len(lines)
+		}
+	}
+	end = min(end, len(lines))
+	if start > len(lines) && len(lines) > 0 {
+		result.Error = fmt.Sprintf("start line %d is past the end of the file, it has %d lines", start, len(lines))
+		return result
+	}
+	result.Start, result.End = start, end
+
+	var b strings.Builder
+	tokens := 0
+	for i := start; i <= end; i++ {
+		line := lines[i-1]
+		if pattern != nil && !pattern.MatchString(line) {
+			continue
+		}
+		numbered := fmt.Sprintf("%d: %s\n", i, line)
+		tokens += CalculateTokens(numbered)
+		if tokens > MaxReadTokens && b.Len() > 0 {
+			result.End = i - 1
+			result.Truncated = true
+			break
+		}
+		if pattern != nil {
+			result.Matches++
+		}
+		b.WriteString(numbered)
+	}
+	result.Content = strings.TrimSuffix(b.String(), "\n")
+	return result
+}
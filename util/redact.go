@@ -0,0 +1,110 @@
+// redact.go masks secrets before they are persisted under agents/ or sent
+// back to a model, covering the values of sensitive environment variables and
+// well known key formats. Set NINA_NO_REDACT=1 to disable.
+package util
+
+import (
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// minSecretLen avoids masking short env values like "1" or "true" everywhere
+const minSecretLen = 8
+
+var sensitiveEnvName = regexp.MustCompile(`(?i)(KEY|TOKEN|SECRET|PASSWORD|PASSWD|CREDENTIAL|AUTH)|^(ANTHROPIC|OPENAI|AWS|GEMINI|GOOGLE|GROQ|XAI|OPENROUTER)_`)
+
+var secretPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`sk-ant-[A-Za-z0-9_\-]{20,}`),
+	regexp.MustCompile(`sk-(proj-)?[A-Za-z0-9_\-]{20,}`),
+	regexp.MustCompile(`gsk_[A-Za-z0-9]{20,}`),
+	regexp.MustCompile(`xai-[A-Za-z0-9]{20,}`),
+	regexp.MustCompile(`AIza[0-9A-Za-z_\-]{35}`),
+	regexp.MustCompile(`(A3T[A-Z0-9]|AKIA|ASIA)[A-Z0-9]{16}`),
+	regexp.MustCompile(`(ghp|gho|ghu|ghs|ghr)_[A-Za-z0-9]{36}|github_pat_[A-Za-z0-9_]{40,}`),
+	regexp.MustCompile(`xox[abprs]-[A-Za-z0-9\-]{10,}`),
+	regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`),
+}
+
+// secretAssignments match NAME=value and "name": "value" where the name looks
+// sensitive, keeping the name and masking the value. NAME=value only matches
+// upper case env style names with a literal value, so source code like
+// InputTokens: usage.InputTokens passes through unchanged.
+var secretAssignments = []*regexp.Regexp{
+	regexp.MustCompile(`(?m)\b([A-Z0-9_]*(?:KEY|TOKEN|SECRET|PASSWORD|PASSWD|CREDENTIAL)[A-Z0-9_]*[ \t]*[=:][ \t]*["']?)([A-Za-z0-9_\-./+=~]{8,})(["']?(?:[\s,;]|$))`),
+	regexp.MustCompile(`(?i)("[a-z0-9_\-]*(?:key|token|secret|password|credential)[a-z0-9_\-]*"\s*:\s*")([^"]{8,})(")`),
+	regexp.MustCompile(`(?i)(\bbearer\s+)([A-Za-z0-9._~+/\-]{16,}=*)`),
+}
+
+const redacted = "[REDACTED]"
+
+// Redact returns s with secrets replaced by [REDACTED]
+func Redact(s string) string {
+	if s == "" || os.Getenv("NINA_NO_REDACT") != "" {
+		return s
+	}
+	return redactSecrets(s)
+}
+
+// redactSecrets masks secrets whether or not NINA_NO_REDACT is set
+func redactSecrets(s string) string {
+	// exact values of sensitive env vars, longest first so a value that
+	// contains another is masked whole
+	var values []string
+	for _, kv := range os.Environ() {
+		name, value, ok := strings.Cut(kv, "=")
+		if ok && len(value) >= minSecretLen && sensitiveEnvName.MatchString(name) {
+			values = append(values, value)
+		}
+	}
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+	for _, value := range values {
+		s = strings.ReplaceAll(s, value, redacted)
+	}
+
+	for _, re := range secretPatterns {
+		s = re.ReplaceAllString(s, redacted)
+	}
+	for _, re := range secretAssignments {
+		s = re.ReplaceAllStringFunc(s, func(match string) string {
+			groups := re.FindStringSubmatch(match)
+			if groups[2] == redacted {
+				return match
+			}
+			result := groups[1] + redacted
+			if len(groups) > 3 {
+				result += groups[3]
+			}
+			return result
+		})
+	}
+	return s
+}
+
+// piiPatterns match personal data kept out of datasets built from sessions,
+// home directories keep the path below the user name
+var piiPatterns = []struct {
+	re      *regexp.Regexp
+	replace string
+}{
+	{regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`), "[EMAIL]"},
+	{regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b`), "[IP]"},
+	{regexp.MustCompile(`(/home|/Users)/[^/\s"']+`), "~"},
+	{regexp.MustCompile(`(?i)C:\\Users\\[^\\\s"']+`), "~"},
+}
+
+// RedactPII returns s with secrets, email addresses, ip addresses, and user
+// names in home directory paths masked, regardless of NINA_NO_REDACT
+func RedactPII(s string) string {
+	s = redactSecrets(s)
+	for _, p := range piiPatterns {
+		s = p.re.ReplaceAllString(s, p.replace)
+	}
+	return s
+}
+
+// WriteLog writes a log file under agents/ with secrets redacted
+func WriteLog(path string, data []byte) error {
+	return os.WriteFile(path, []byte(Redact(string(data))), 0644)
+}
@@ -0,0 +1,242 @@
+// search.go runs NinaGrep and NinaGlob in the current workspace with ripgrep,
+// falling back to grep and find where it is missing, and returns sorted,
+// bounded results so exploring a repository costs a predictable number of
+// tokens
+package util
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nathants/nina/workspace"
+)
+
+// MaxGrepMatches bounds the lines a NinaGrep returns
+const MaxGrepMatches = 200
+
+// MaxGlobFiles bounds the files a NinaGlob returns
+const MaxGlobFiles = 500
+
+// MaxSearchTokens bounds the content a NinaGrep or NinaGlob returns
+const MaxSearchTokens = 4000
+
+// maxMatchLength is where a long matching line is cut
+const maxMatchLength = 300
+
+// SearchRequest is a NinaGrep or NinaGlob, Path defaults to the workspace root
+type SearchRequest struct {
+	Pattern string
+	Path    string
+	Include string // NinaGrep only, a glob the searched files must match
+}
+
+// SearchResult is the sorted lines of a NinaGrep, as "<path>:<line>: <text>",
+// or the sorted files of a NinaGlob
+type SearchResult struct {
+	Lines     []string
+	Files     int // files with a match
+	Total     int // matching lines or files, including those not returned
+	Truncated bool
+	Error     string
+}
+
+// Summary describes the results, such as "12 matches in 3 files", unit is
+// the singular noun counted
+func (r SearchResult) Summary(unit string) string {
+	summary := plural(r.Total, unit)
+	if r.Files > 0 {
+		summary += " in " + plural(r.Files, "file")
+	}
+	if r.Truncated {
+		summary += fmt.Sprintf(", first %d shown, narrow the search for the rest", len(r.Lines))
+	}
+	return summary
+}
+
+// plural returns n and noun, with an s or es for other than one
+func plural(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	if strings.HasSuffix(noun, "ch") {
+		return fmt.Sprintf("%d %ses", n, noun)
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+// Content returns the lines of the result, one per line
+func (r SearchResult) Content() string {
+	return strings.Join(r.Lines, "\n")
+}
+
+// searchScript runs rg when installed and fallback otherwise
+func searchScript(rg, fallback string) string {
+	return fmt.Sprintf("if command -v rg >/dev/null 2>&1; then %s; else %s; fi", rg, fallback)
+}
+
+// runSearch runs script in the workspace, exit code 1 means nothing matched
+func runSearch(script string) (string, error) {
+	cmd := workspace.BashCommand(script)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 && stderr.Len() == 0 {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// searchPath returns the quoted path to search
+func searchPath(p string) string {
+	p = expandHome(strings.TrimSpace(p))
+	if p == "" {
+		p = "."
+	}
+	return workspace.Quote(p)
+}
+
+// ExecuteGrep returns the lines matching a regexp under Path, sorted by file
+// and line, at most MaxGrepMatches and MaxSearchTokens of them
+func ExecuteGrep(req SearchRequest) SearchResult {
+	if req.Pattern == "" {
+		return SearchResult{Error: "empty pattern"}
+	}
+	rg := "rg --line-number --with-filename --no-heading --color never"
+	grep := "grep -rnHIE --exclude-dir=.git"
+	if req.Include != "" {
+		rg += " --glob " + workspace.Quote(req.Include)
+		grep += " --include " + workspace.Quote(req.Include)
+	}
+	args := " -e " + workspace.Quote(req.Pattern) + " -- " + searchPath(req.Path)
+	out, err := runSearch(searchScript(rg+args, grep+args))
+	if err != nil {
+		return SearchResult{Error: err.Error()}
+	}
+
+	type match struct {
+		path string
+		line int
+		text string
+	}
+	var matches []match
+	files := map[string]bool{}
+	for _, line := range splitLines(out) {
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		n, err := strconv.Atoi(parts[1])
+		if err != nil {
+			continue
+		}
+		text := parts[2]
+		if len(text) > maxMatchLength {
+			text = text[:maxMatchLength] + "..."
+		}
+		matches = append(matches, match{parts[0], n, text})
+		files[parts[0]] = true
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].path != matches[j].path {
+			return matches[i].path < matches[j].path
+		}
+		return matches[i].line < matches[j].line
+	})
+
+	result := SearchResult{Files: len(files), Total: len(matches)}
+	lines := make([]string, len(matches))
+	for i, m := range matches {
+		lines[i] = fmt.Sprintf("%s:%d: %s", m.path, m.line, m.text)
+	}
+	result.Lines, result.Truncated = boundLines(lines, MaxGrepMatches)
+	return result
+}
+
+// ExecuteGlob returns the files under Path matching a glob, sorted, at most
+// MaxGlobFiles of them. A * matches within one path element and ** matches
+// any number of them, a pattern without a / matches the file name. Files
+// ignored by git are skipped when ripgrep is installed.
+func ExecuteGlob(req SearchRequest) SearchResult {
+	pattern := strings.TrimSpace(req.Pattern)
+	if pattern == "" {
+		return SearchResult{Error: "empty pattern"}
+	}
+	if _, err := path.Match(strings.ReplaceAll(pattern, "**", "*"), ""); err != nil {
+		return SearchResult{Error: fmt.Sprintf("invalid pattern: %v", err)}
+	}
+	dir := searchPath(req.Path)
+	out, err := runSearch(searchScript("rg --files --color never -- "+dir, "find "+dir+" -type f -not -path '*/.git/*'"))
+	if err != nil {
+		return SearchResult{Error: err.Error()}
+	}
+
+	root := strings.TrimSuffix(expandHome(strings.TrimSpace(req.Path)), "/")
+	var files []string
+	for _, file := range splitLines(out) {
+		rel := strings.TrimPrefix(file, "./")
+		if root != "" && root != "." {
+			rel = strings.TrimPrefix(strings.TrimPrefix(file, root), "/")
+		}
+		if MatchGlob(pattern, rel) {
+			files = append(files, file)
+		}
+	}
+	sort.Strings(files)
+	result := SearchResult{Total: len(files)}
+	result.Lines, result.Truncated = boundLines(files, MaxGlobFiles)
+	return result
+}
+
+// boundLines returns the first lines within limit and MaxSearchTokens, and
+// whether any were dropped
+func boundLines(lines []string, limit int) ([]string, bool) {
+	tokens := 0
+	for i, line := range lines {
+		tokens += CalculateTokens(line) + 1
+		if i == limit || tokens > MaxSearchTokens {
+			return lines[:i], true
+		}
+	}
+	return lines, false
+}
+
+// MatchGlob reports whether the slash separated path name matches pattern,
+// where ** matches zero or more path elements. A pattern without a / is
+// matched against the last element of name.
+func MatchGlob(pattern, name string) bool {
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, path.Base(name))
+		return ok
+	}
+	return matchElements(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchElements(pattern, name []string) bool {
+	if len(pattern) == 0 {
+		return len(name) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(name); i++ {
+			if matchElements(pattern[1:], name[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(name) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(pattern[0], name[0]); !ok {
+		return false
+	}
+	return matchElements(pattern[1:], name[1:])
+}
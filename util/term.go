@@ -0,0 +1,85 @@
+// term.go decides whether output may use ANSI colors and decoration. Color
+// follows NINA_COLOR (auto, always, never), set by the global --color flag.
+// In auto mode color is used only when the stream is a terminal, NO_COLOR is
+// unset, and TERM is not dumb, so pipes and CI logs get plain text.
+package util
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+const (
+	ColorAuto   = "auto"
+	ColorAlways = "always"
+	ColorNever  = "never"
+)
+
+var ansiPattern = regexp.MustCompile(`\x1b\[[0-9;]*[A-Za-z]`)
+
+// ParseColorMode validates a --color value
+func ParseColorMode(s string) (string, error) {
+	switch mode := strings.ToLower(strings.TrimSpace(s)); mode {
+	case ColorAuto, ColorAlways, ColorNever:
+		return mode, nil
+	case "":
+		return ColorAuto, nil
+	default:
+		return "", fmt.Errorf("invalid color mode: %s, expected auto, always, or never", s)
+	}
+}
+
+// GetColorMode returns the mode from NINA_COLOR, defaulting to auto
+func GetColorMode() string {
+	mode, err := ParseColorMode(os.Getenv("NINA_COLOR"))
+	if err != nil {
+		return ColorAuto
+	}
+	return mode
+}
+
+// SetColorMode sets NINA_COLOR for this process and any children
+func SetColorMode(mode string) {
+	_ = os.Setenv("NINA_COLOR", mode)
+}
+
+// IsTerminal reports whether w is a character device like a tty
+func IsTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// ColorEnabled reports whether ANSI colors should be written to w
+func ColorEnabled(w io.Writer) bool {
+	switch GetColorMode() {
+	case ColorAlways:
+		return true
+	case ColorNever:
+		return false
+	}
+	if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	return IsTerminal(w)
+}
+
+// StripANSI removes ANSI escape sequences from s
+func StripANSI(s string) string {
+	return ansiPattern.ReplaceAllString(s, "")
+}
+
+// ForTerminal returns s unchanged when w supports color, otherwise without
+// escape sequences
+func ForTerminal(w io.Writer, s string) string {
+	if ColorEnabled(w) {
+		return s
+	}
+	return StripANSI(s)
+}
@@ -0,0 +1,67 @@
+// textformat.go keeps a file's line endings, final newline, and byte order
+// mark through an edit. content is edited with \n endings and no BOM, then written back in
+// the file's own form so a change does not rewrite every line.
+package util
+
+import "strings"
+
+const utf8BOM = "\ufeff"
+
+// TextFormat is how a file stores text
+type TextFormat struct {
+	BOM          bool   // starts with a utf8 byte order mark
+	LineEnding   string // "\n", "\r\n" or "\r"
+	FinalNewline bool   // ends with a line ending, true for new files
+}
+
+// DetectTextFormat returns the format of data, using its most common line
+// ending, \n when it has none
+func DetectTextFormat(data string) TextFormat {
+	format := TextFormat{
+		BOM:          strings.HasPrefix(data, utf8BOM),
+		LineEnding:   "\n",
+		FinalNewline: data == "" || strings.HasSuffix(data, "\n") || strings.HasSuffix(data, "\r"),
+	}
+	crlf := strings.Count(data, "\r\n")
+	cr := strings.Count(data, "\r") - crlf
+	lf := strings.Count(data, "\n") - crlf
+	switch {
+	case crlf > lf && crlf >= cr:
+		format.LineEnding = "\r\n"
+	case cr > lf && cr > crlf:
+		format.LineEnding = "\r"
+	}
+	return format
+}
+
+// NormalizeText returns data with \n line endings and no BOM, and the format
+// to restore it with
+func NormalizeText(data string) (string, TextFormat) {
+	format := DetectTextFormat(data)
+	data = strings.TrimPrefix(data, utf8BOM)
+	data = strings.ReplaceAll(data, "\r\n", "\n")
+	if format.LineEnding == "\r" {
+		data = strings.ReplaceAll(data, "\r", "\n")
+	}
+	return data, format
+}
+
+// Restore converts edited content back to the format, content may use \n or
+// \r\n endings. A final newline is added or removed to match the original.
+func (f TextFormat) Restore(content string) string {
+	content = strings.ReplaceAll(content, "\r\n", "\n")
+	switch {
+	case content == "":
+	case f.FinalNewline && !strings.HasSuffix(content, "\n"):
+		content += "\n"
+	case !f.FinalNewline:
+		content = strings.TrimSuffix(content, "\n")
+	}
+	if f.LineEnding != "" && f.LineEnding != "\n" {
+		content = strings.ReplaceAll(content, "\n", f.LineEnding)
+	}
+	if f.BOM && !strings.HasPrefix(content, utf8BOM) {
+		content = utf8BOM + content
+	}
+	return content
+}
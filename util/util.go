@@ -8,6 +8,10 @@ import (
 	"strings"
 )
 
+// CurrentCommand is the nina subcommand being run, like run or ask, set by
+// main before it is dispatched and recorded with usage
+var CurrentCommand string
+
 func ValueSlice[T any](val []*T) []T {
 	var resp []T
 	for _, v := range val {
@@ -0,0 +1,163 @@
+package workspace
+
+// command backed workspace for remote hosts and containers. every operation
+// runs a small sh script through a command prefix, for example
+// `docker exec -i $container` or `ssh $host`.
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Command runs file operations through a command prefix
+type Command struct {
+	Prefix []string
+	// Remote is set when the prefix joins its arguments into a single string
+	// for a remote shell, like ssh, so the script must be quoted once more
+	Remote bool
+	// Dir is the working directory for bash commands and relative paths
+	Dir string
+	// Name describes where commands run, like the --exec spec
+	Name string
+}
+
+// NewCommand creates a workspace for a prefix that passes argv through as is
+func NewCommand(prefix ...string) *Command {
+	return &Command{Prefix: prefix}
+}
+
+// Quote single quotes s for sh
+func Quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// Root returns Dir, the directory changes are expected under
+func (c *Command) Root() string {
+	return c.Dir
+}
+
+// abs resolves relative paths against Dir
+func (c *Command) abs(path string) string {
+	if c.Dir != "" && !filepath.IsAbs(path) {
+		return filepath.Join(c.Dir, path)
+	}
+	return path
+}
+
+// command builds the command running script with shell through the prefix
+func (c *Command) command(shell, script string) *exec.Cmd {
+	if c.Dir != "" {
+		script = "cd " + Quote(c.Dir) + " && " + shell + " -c " + Quote(script)
+		shell = "sh"
+	}
+	argv := append([]string{}, c.Prefix...)
+	if c.Remote {
+		argv = append(argv, shell+" -c "+Quote(script))
+	} else {
+		argv = append(argv, shell, "-c", script)
+	}
+	return exec.Command(argv[0], argv[1:]...)
+}
+
+// BashCommand returns a command running script with bash in Dir
+func (c *Command) BashCommand(script string) *exec.Cmd {
+	return c.command("bash", script)
+}
+
+// run executes script with sh through the prefix, args are quoted into it
+func (c *Command) run(stdin []byte, script string, args ...string) ([]byte, error) {
+	if len(c.Prefix) == 0 || c.Prefix[0] == "" {
+		return nil, fmt.Errorf("workspace command prefix is empty")
+	}
+	for _, arg := range args {
+		script += " " + Quote(arg)
+	}
+	cmd := c.command("sh", script)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == notExistExit {
+			return nil, fs.ErrNotExist
+		}
+		return nil, fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// notExistExit is the exit code scripts use to report a missing path
+const notExistExit = 66
+
+func (c *Command) ReadFile(path string) ([]byte, error) {
+	data, err := c.run(nil, `f() { [ -e "$1" ] || exit 66; cat -- "$1"; }; f`, c.abs(path))
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: path, Err: err}
+	}
+	return data, nil
+}
+
+func (c *Command) WriteFile(path string, data []byte, perm fs.FileMode) error {
+	mode := strconv.FormatUint(uint64(perm.Perm()), 8)
+	_, err := c.run(data, `f() { [ -e "$1" ] || { : > "$1" && chmod "$2" "$1"; } && cat > "$1"; }; f`, c.abs(path), mode)
+	if err != nil {
+		return &fs.PathError{Op: "write", Path: path, Err: err}
+	}
+	return nil
+}
+
+// Stat reports size, type, and permissions only, as 0755 for executable
+// files and 0644 otherwise
+func (c *Command) Stat(path string) (fs.FileInfo, error) {
+	out, err := c.run(nil, `f() { [ -e "$1" ] || [ -L "$1" ] || exit 66; if [ -d "$1" ]; then echo d 0; elif [ -x "$1" ]; then echo x "$(wc -c < "$1")"; else echo f "$(wc -c < "$1")"; fi; }; f`, c.abs(path))
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: path, Err: err}
+	}
+	fields := strings.Fields(string(out))
+	if len(fields) != 2 {
+		return nil, &fs.PathError{Op: "stat", Path: path, Err: fmt.Errorf("unexpected output: %q", out)}
+	}
+	size, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: path, Err: err}
+	}
+	info := fileInfo{name: filepath.Base(path), size: size, mode: 0644}
+	switch fields[0] {
+	case "d":
+		info.isDir = true
+		info.mode = fs.ModeDir | 0755
+	case "x":
+		info.mode = 0755
+	}
+	return info, nil
+}
+
+func (c *Command) Remove(path string) error {
+	if _, err := c.run(nil, `f() { [ -e "$1" ] || [ -L "$1" ] || exit 66; rm -- "$1"; }; f`, c.abs(path)); err != nil {
+		return &fs.PathError{Op: "remove", Path: path, Err: err}
+	}
+	return nil
+}
+
+func (c *Command) Rename(src, dst string) error {
+	if _, err := c.run(nil, `f() { [ -e "$1" ] || exit 66; mv -- "$1" "$2"; }; f`, c.abs(src), c.abs(dst)); err != nil {
+		return &os.LinkError{Op: "rename", Old: src, New: dst, Err: err}
+	}
+	return nil
+}
+
+func (c *Command) MkdirAll(path string, perm fs.FileMode) error {
+	mode := strconv.FormatUint(uint64(perm.Perm()), 8)
+	if _, err := c.run(nil, `mkdir -p -m`, mode, "--", c.abs(path)); err != nil {
+		return &fs.PathError{Op: "mkdir", Path: path, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package workspace
+
+// docker execution backend. the repository is bind mounted at the same path
+// inside a long running container, so file changes are applied locally while
+// every NinaBash command runs in the container:
+//
+//	docker:<image>[?network=none&memory=2g&cpus=2&pids=1024&ttl=24h]
+//
+// network defaults to none so untrusted tasks cannot reach the network. the
+// container is removed on Close, or when ttl expires if nina is killed.
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Docker runs bash in a container with the repository mounted
+type Docker struct {
+	OS
+	Image     string
+	Container string
+	Dir       string
+}
+
+// dockerDefaults are applied for options missing from the spec
+var dockerDefaults = map[string]string{
+	"network": "none",
+	"pids":    "1024",
+	"ttl":     "24h",
+}
+
+// NewDocker starts a container for spec, "<image>[?options]", with mountDir
+// bind mounted and workDir as the working directory for commands
+func NewDocker(spec, mountDir, workDir string) (*Docker, error) {
+	image, query, _ := strings.Cut(spec, "?")
+	if image == "" {
+		return nil, fmt.Errorf("missing image in docker:%s", spec)
+	}
+	options, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, fmt.Errorf("invalid docker options %q: %w", query, err)
+	}
+	for key := range options {
+		switch key {
+		case "network", "memory", "cpus", "pids", "ttl":
+		default:
+			return nil, fmt.Errorf("unknown docker option: %s", key)
+		}
+	}
+	option := func(key string) string {
+		if value := options.Get(key); value != "" {
+			return value
+		}
+		return dockerDefaults[key]
+	}
+	ttl, err := time.ParseDuration(option("ttl"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid docker ttl: %w", err)
+	}
+
+	args := []string{
+		"run", "--detach", "--rm",
+		"--label", "nina=1",
+		"--network", option("network"),
+		"--pids-limit", option("pids"),
+		"--user", fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid()),
+		"--volume", mountDir + ":" + mountDir,
+		"--workdir", workDir,
+	}
+	if memory := option("memory"); memory != "" {
+		args = append(args, "--memory", memory)
+	}
+	if cpus := option("cpus"); cpus != "" {
+		args = append(args, "--cpus", cpus)
+	}
+	args = append(args, image, "sleep", fmt.Sprint(int(ttl.Seconds())))
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("docker", args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("docker run %s: %v: %s", image, err, strings.TrimSpace(stderr.String()))
+	}
+	return &Docker{
+		Image:     image,
+		Container: strings.TrimSpace(stdout.String()),
+		Dir:       workDir,
+	}, nil
+}
+
+// Local reports true, files live on the local filesystem via the mount
+func (d *Docker) Local() bool {
+	return true
+}
+
+// BashCommand returns a command running script with bash in the container
+func (d *Docker) BashCommand(script string) *exec.Cmd {
+	return exec.Command("docker", "exec", "-i", "--workdir", d.Dir, d.Container, "bash", "-c", script)
+}
+
+// Close removes the container
+func (d *Docker) Close() error {
+	output, err := exec.Command("docker", "rm", "--force", d.Container).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("docker rm %s: %v: %s", d.Container, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package workspace
+
+// execution backends. a workspace that can also run bash is selected with
+// --exec, so NinaBash commands and file changes happen in the same place:
+//
+//	local                       the local machine, the default
+//	ssh://[user@]host[:path]    a remote machine over ssh, optionally in path
+//	docker:<image>[?options]    a container with the repository mounted, see docker.go
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Executor runs bash scripts where the workspace files live
+type Executor interface {
+	BashCommand(script string) *exec.Cmd
+}
+
+// BashCommand returns a command running script locally with bash
+func (OS) BashCommand(script string) *exec.Cmd {
+	return exec.Command("bash", "-c", script)
+}
+
+// BashCommand returns a command running script in the current workspace,
+// falling back to local bash when the workspace cannot execute
+func BashCommand(script string) *exec.Cmd {
+	if e, ok := Current().(Executor); ok {
+		return e.BashCommand(script)
+	}
+	return OS{}.BashCommand(script)
+}
+
+// Root returns the directory changes are expected under for workspaces that
+// run elsewhere, or empty for the local filesystem
+func Root(ws Workspace) string {
+	if r, ok := ws.(interface{ Root() string }); ok {
+		return r.Root()
+	}
+	return ""
+}
+
+// Describe tells the model where its commands run when that is not the local
+// machine, so it does not assume local paths or tools
+func Describe(ws Workspace) string {
+	if d, ok := Base(ws).(*Docker); ok {
+		return "NinaBash commands run in a " + d.Image + " docker container with the repository mounted at the same path"
+	}
+	c, ok := Base(ws).(*Command)
+	if !ok {
+		return ""
+	}
+	desc := "NinaBash commands and file changes run on " + c.Name
+	if c.Dir != "" {
+		desc += " in the directory " + c.Dir
+	}
+	return desc
+}
+
+// Open creates the workspace described by an --exec spec
+func Open(spec string) (Workspace, error) {
+	switch {
+	case spec == "" || spec == "local":
+		return OS{}, nil
+	case strings.HasPrefix(spec, "ssh://"):
+		return NewSSH(strings.TrimPrefix(spec, "ssh://"))
+	case strings.HasPrefix(spec, "docker:"):
+		cwd, err := os.Getwd()
+		if err != nil {
+			return nil, err
+		}
+		mountDir := cwd
+		if output, err := exec.Command("git", "rev-parse", "--show-toplevel").Output(); err == nil {
+			mountDir = strings.TrimSpace(string(output))
+		}
+		return NewDocker(strings.TrimPrefix(spec, "docker:"), mountDir, cwd)
+	default:
+		return nil, fmt.Errorf("unknown exec backend: %s", spec)
+	}
+}
+
+// Use opens spec and makes it the current workspace
+func Use(spec string) error {
+	ws, err := Open(spec)
+	if err != nil {
+		return err
+	}
+	SetCurrent(ws)
+	return nil
+}
+
+// NewSSH creates a workspace on a remote host from "[user@]host[:path]".
+// Connections are multiplexed so each file operation does not pay for a new
+// handshake, and BatchMode fails fast instead of prompting for a password.
+func NewSSH(target string) (*Command, error) {
+	host, dir, _ := strings.Cut(target, ":")
+	if host == "" {
+		return nil, fmt.Errorf("missing host in ssh://%s", target)
+	}
+	return &Command{
+		Prefix: []string{
+			"ssh",
+			"-o", "BatchMode=yes",
+			"-o", "ControlMaster=auto",
+			"-o", "ControlPath=~/.ssh/nina-%C",
+			"-o", "ControlPersist=60",
+			host,
+		},
+		Remote: true,
+		Dir:    dir,
+		Name:   "ssh://" + target,
+	}, nil
+}
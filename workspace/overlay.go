@@ -0,0 +1,158 @@
+package workspace
+
+// in-memory overlay for dry runs. reads fall through to the base workspace
+// until a path is written or removed, nothing reaches the base until Commit.
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Change is a pending write or removal in an Overlay
+type Change struct {
+	Path    string
+	Data    []byte
+	Perm    fs.FileMode
+	Deleted bool
+}
+
+// Overlay buffers writes in memory on top of a base workspace
+type Overlay struct {
+	base    Workspace
+	mu      sync.Mutex
+	changes map[string]*Change
+}
+
+// NewOverlay creates an empty overlay on base
+func NewOverlay(base Workspace) *Overlay {
+	return &Overlay{
+		base:    base,
+		changes: map[string]*Change{},
+	}
+}
+
+func (o *Overlay) ReadFile(path string) ([]byte, error) {
+	o.mu.Lock()
+	change, ok := o.changes[filepath.Clean(path)]
+	o.mu.Unlock()
+	if ok {
+		if change.Deleted {
+			return nil, &fs.PathError{Op: "open", Path: path, Err: fs.ErrNotExist}
+		}
+		return append([]byte(nil), change.Data...), nil
+	}
+	return o.base.ReadFile(path)
+}
+
+func (o *Overlay) WriteFile(path string, data []byte, perm fs.FileMode) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	path = filepath.Clean(path)
+	o.changes[path] = &Change{Path: path, Data: append([]byte(nil), data...), Perm: perm}
+	return nil
+}
+
+func (o *Overlay) Stat(path string) (fs.FileInfo, error) {
+	o.mu.Lock()
+	change, ok := o.changes[filepath.Clean(path)]
+	o.mu.Unlock()
+	if ok {
+		if change.Deleted {
+			return nil, &fs.PathError{Op: "stat", Path: path, Err: fs.ErrNotExist}
+		}
+		return fileInfo{name: filepath.Base(path), size: int64(len(change.Data)), mode: change.Perm}, nil
+	}
+	return o.base.Stat(path)
+}
+
+func (o *Overlay) Remove(path string) error {
+	if _, err := o.Stat(path); err != nil {
+		return err
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	path = filepath.Clean(path)
+	o.changes[path] = &Change{Path: path, Deleted: true}
+	return nil
+}
+
+// Rename moves a single file, directories are not supported in the overlay
+func (o *Overlay) Rename(src, dst string) error {
+	info, err := o.Stat(src)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("rename %s: directories are not supported in dry run", src)
+	}
+	data, err := o.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	if err := o.WriteFile(dst, data, info.Mode().Perm()); err != nil {
+		return err
+	}
+	return o.Remove(src)
+}
+
+// Base returns the workspace the overlay buffers changes for
+func (o *Overlay) Base() Workspace {
+	return o.base
+}
+
+// MkdirAll is a no-op, directories are created by Commit as needed
+func (o *Overlay) MkdirAll(string, fs.FileMode) error {
+	return nil
+}
+
+// Changes returns pending writes and removals sorted by path
+func (o *Overlay) Changes() []Change {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	changes := make([]Change, 0, len(o.changes))
+	for _, c := range o.changes {
+		changes = append(changes, *c)
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes
+}
+
+// Commit applies pending changes to the base workspace and clears them
+func (o *Overlay) Commit() error {
+	for _, c := range o.Changes() {
+		var err error
+		if c.Deleted {
+			err = o.base.Remove(c.Path)
+		} else {
+			if err = o.base.MkdirAll(filepath.Dir(c.Path), 0755); err == nil {
+				err = o.base.WriteFile(c.Path, c.Data, c.Perm)
+			}
+		}
+		if err != nil {
+			return err
+		}
+		o.mu.Lock()
+		delete(o.changes, c.Path)
+		o.mu.Unlock()
+	}
+	return nil
+}
+
+// fileInfo describes a file held in memory or reported by a remote stat
+type fileInfo struct {
+	name  string
+	size  int64
+	mode  fs.FileMode
+	isDir bool
+}
+
+func (f fileInfo) Name() string       { return f.name }
+func (f fileInfo) Size() int64        { return f.size }
+func (f fileInfo) Mode() fs.FileMode  { return f.mode }
+func (f fileInfo) ModTime() time.Time { return time.Time{} }
+func (f fileInfo) IsDir() bool        { return f.isDir }
+func (f fileInfo) Sys() any           { return nil }
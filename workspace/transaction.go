@@ -0,0 +1,90 @@
+package workspace
+
+// all or nothing application of several changes. writes are staged in an
+// overlay, Commit applies them and puts back what it already wrote if a later
+// write fails, and Rollback undoes a commit, for example when a build run
+// afterwards fails.
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+)
+
+// Transaction stages writes and removals and applies them together
+type Transaction struct {
+	*Overlay
+	originals []original
+}
+
+// original is a path's state before Commit changed it
+type original struct {
+	path    string
+	data    []byte
+	perm    fs.FileMode
+	existed bool
+}
+
+// NewTransaction creates an empty transaction on base
+func NewTransaction(base Workspace) *Transaction {
+	return &Transaction{Overlay: NewOverlay(base)}
+}
+
+// Commit applies every staged change to the base workspace, restoring the
+// paths it already changed when one fails
+func (t *Transaction) Commit() error {
+	t.originals = nil
+	for _, c := range t.Changes() {
+		orig := original{path: c.Path, perm: 0644}
+		info, err := t.base.Stat(c.Path)
+		switch {
+		case err == nil:
+			if orig.data, err = t.base.ReadFile(c.Path); err != nil {
+				return t.abort(err)
+			}
+			orig.perm = info.Mode().Perm()
+			orig.existed = true
+		case !errors.Is(err, fs.ErrNotExist):
+			return t.abort(err)
+		}
+		t.originals = append(t.originals, orig)
+
+		if c.Deleted {
+			err = t.base.Remove(c.Path)
+		} else if err = t.base.MkdirAll(filepath.Dir(c.Path), 0755); err == nil {
+			err = t.base.WriteFile(c.Path, c.Data, c.Perm)
+		}
+		if err != nil {
+			return t.abort(err)
+		}
+	}
+	return nil
+}
+
+// abort rolls back a partial commit and returns err
+func (t *Transaction) abort(err error) error {
+	if rerr := t.Rollback(); rerr != nil {
+		return fmt.Errorf("%w, and rolling back failed: %v", err, rerr)
+	}
+	return err
+}
+
+// Rollback restores every path changed by the last Commit, newest first
+func (t *Transaction) Rollback() error {
+	var errs []error
+	for i := len(t.originals) - 1; i >= 0; i-- {
+		o := t.originals[i]
+		var err error
+		if o.existed {
+			err = t.base.WriteFile(o.path, o.data, o.perm)
+		} else if _, serr := t.base.Stat(o.path); serr == nil {
+			err = t.base.Remove(o.path)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	t.originals = nil
+	return errors.Join(errs...)
+}
@@ -0,0 +1,110 @@
+// Package workspace abstracts the file operations used to apply changes, so
+// edit, arch, and run can target the real filesystem, an in-memory overlay for
+// dry runs, or a remote host or container through a command prefix.
+package workspace
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+)
+
+// Workspace is the set of file operations used when applying changes
+type Workspace interface {
+	ReadFile(path string) ([]byte, error)
+	WriteFile(path string, data []byte, perm fs.FileMode) error
+	Stat(path string) (fs.FileInfo, error)
+	Remove(path string) error
+	Rename(src, dst string) error
+	MkdirAll(path string, perm fs.FileMode) error
+}
+
+// OS is the local filesystem
+type OS struct{}
+
+func (OS) ReadFile(path string) ([]byte, error) {
+	return os.ReadFile(path)
+}
+
+func (OS) WriteFile(path string, data []byte, perm fs.FileMode) error {
+	return os.WriteFile(path, data, perm)
+}
+
+func (OS) Stat(path string) (fs.FileInfo, error) {
+	return os.Lstat(path)
+}
+
+func (OS) Remove(path string) error {
+	return os.Remove(path)
+}
+
+func (OS) Rename(src, dst string) error {
+	return os.Rename(src, dst)
+}
+
+func (OS) MkdirAll(path string, perm fs.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
+
+var (
+	current   Workspace = OS{}
+	currentMu sync.RWMutex
+)
+
+// Current returns the workspace changes are applied to, OS unless replaced
+func Current() Workspace {
+	currentMu.RLock()
+	defer currentMu.RUnlock()
+	return current
+}
+
+// SetCurrent replaces the workspace changes are applied to
+func SetCurrent(ws Workspace) {
+	currentMu.Lock()
+	defer currentMu.Unlock()
+	current = ws
+}
+
+// Base unwraps overlays and transactions, returning the workspace their
+// changes are destined for
+func Base(ws Workspace) Workspace {
+	for {
+		overlay, ok := ws.(interface{ Base() Workspace })
+		if !ok {
+			return ws
+		}
+		ws = overlay.Base()
+	}
+}
+
+// FileMode returns the permissions of an existing file so a rewrite keeps
+// them, including executable bits, or def for new files
+func FileMode(ws Workspace, path string, def fs.FileMode) fs.FileMode {
+	info, err := ws.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return def
+	}
+	return info.Mode().Perm()
+}
+
+// IsLocal reports whether ws reads and writes the local filesystem, where git
+// commands and symlink resolution apply directly
+func IsLocal(ws Workspace) bool {
+	if _, ok := ws.(OS); ok {
+		return true
+	}
+	l, ok := ws.(interface{ Local() bool })
+	return ok && l.Local()
+}
+
+// Close releases resources held by the current workspace, like a container,
+// and restores the local filesystem
+func Close() error {
+	ws := Current()
+	SetCurrent(OS{})
+	if c, ok := Base(ws).(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
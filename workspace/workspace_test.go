@@ -0,0 +1,208 @@
+package workspace
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOverlay(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.txt")
+	b := filepath.Join(dir, "b.txt")
+	if err := os.WriteFile(a, []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	o := NewOverlay(OS{})
+	if err := o.WriteFile(a, []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Rename(a, b); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := o.ReadFile(a); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected renamed file to be gone, got %v", err)
+	}
+	if data, err := o.ReadFile(b); err != nil || string(data) != "changed" {
+		t.Errorf("ReadFile(b) = %q, %v", data, err)
+	}
+	if data, _ := os.ReadFile(a); string(data) != "a" {
+		t.Errorf("overlay wrote through to disk: %q", data)
+	}
+
+	if err := o.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(a); !os.IsNotExist(err) {
+		t.Errorf("expected a.txt removed after commit, got %v", err)
+	}
+	if data, _ := os.ReadFile(b); string(data) != "changed" {
+		t.Errorf("b.txt after commit = %q", data)
+	}
+	if len(o.Changes()) != 0 {
+		t.Errorf("expected no pending changes after commit")
+	}
+}
+
+func TestTransaction(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.txt")
+	b := filepath.Join(dir, "b.txt")
+	c := filepath.Join(dir, "c.txt")
+	for _, path := range []string{a, c} {
+		if err := os.WriteFile(path, []byte("orig"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// c.txt is a file, so writing under it fails after a and b were written
+	tx := NewTransaction(OS{})
+	for _, path := range []string{a, b, filepath.Join(c, "x.txt")} {
+		if err := tx.WriteFile(path, []byte("new"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tx.Commit(); err == nil {
+		t.Fatal("expected commit to fail")
+	}
+	if data, _ := os.ReadFile(a); string(data) != "orig" {
+		t.Errorf("a.txt after failed commit = %q", data)
+	}
+	if _, err := os.Stat(b); !os.IsNotExist(err) {
+		t.Errorf("expected b.txt removed after failed commit, got %v", err)
+	}
+
+	tx = NewTransaction(OS{})
+	if err := tx.WriteFile(a, []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.WriteFile(b, []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Remove(c); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(b); string(data) != "new" {
+		t.Errorf("b.txt after commit = %q", data)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]string{a: "orig", c: "orig"} {
+		if data, _ := os.ReadFile(path); string(data) != want {
+			t.Errorf("%s after rollback = %q, want %q", path, data, want)
+		}
+	}
+	if _, err := os.Stat(b); !os.IsNotExist(err) {
+		t.Errorf("expected b.txt removed after rollback, got %v", err)
+	}
+	if Base(tx) != (OS{}) {
+		t.Errorf("Base(tx) = %v, want OS", Base(tx))
+	}
+}
+
+func TestCommand(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "sub dir", "it's.txt")
+	ws := NewCommand("env")
+
+	if _, err := ws.ReadFile(path); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected not exist, got %v", err)
+	}
+	if err := ws.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.WriteFile(path, []byte("hello\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ws.ReadFile(path)
+	if err != nil || string(data) != "hello\n" {
+		t.Fatalf("ReadFile() = %q, %v", data, err)
+	}
+	info, err := ws.Stat(path)
+	if err != nil || info.Size() != 6 || info.IsDir() {
+		t.Fatalf("Stat() = %+v, %v", info, err)
+	}
+	if local, _ := os.Stat(path); local.Mode().Perm() != 0600 {
+		t.Errorf("expected mode 0600, got %v", local.Mode().Perm())
+	}
+	if mode := FileMode(ws, path, 0600); mode != 0644 {
+		t.Errorf("FileMode() = %v, want 0644", mode)
+	}
+	if err := os.Chmod(path, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if mode := FileMode(ws, path, 0644); mode != 0755 {
+		t.Errorf("FileMode() of executable = %v, want 0755", mode)
+	}
+	if mode := FileMode(ws, path+".new", 0600); mode != 0600 {
+		t.Errorf("FileMode() of missing file = %v, want 0600", mode)
+	}
+	// sh -c joins its argument into one string like ssh does
+	remote := &Command{Prefix: []string{"sh", "-c"}, Remote: true}
+	if data, err := remote.ReadFile(path); err != nil || string(data) != "hello\n" {
+		t.Fatalf("remote ReadFile() = %q, %v", data, err)
+	}
+	dst := filepath.Join(dir, "moved.txt")
+	if err := ws.Rename(path, dst); err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.Remove(dst); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ws.Stat(dst); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected removed file to not exist, got %v", err)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	ws, err := Open("ssh://me@example.com:/srv/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := ws.(*Command)
+	if c.Prefix[len(c.Prefix)-1] != "me@example.com" || c.Dir != "/srv/app" || !c.Remote {
+		t.Errorf("unexpected ssh workspace: %+v", c)
+	}
+	if _, err := Open("local"); err != nil {
+		t.Errorf("unexpected error for local: %v", err)
+	}
+	if _, err := Open("ftp://host"); err == nil {
+		t.Errorf("expected error for unknown backend")
+	}
+
+	// Dir applies to bash commands and relative paths
+	dir := t.TempDir()
+	local := &Command{Prefix: []string{"env"}, Dir: dir}
+	out, err := local.BashCommand("pwd").Output()
+	if err != nil || filepath.Clean(string(out[:len(out)-1])) != dir {
+		t.Errorf("BashCommand pwd = %q, %v", out, err)
+	}
+	if err := local.WriteFile("rel.txt", []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "rel.txt")); err != nil {
+		t.Errorf("expected relative path under Dir: %v", err)
+	}
+}
+
+func TestNewDockerOptions(t *testing.T) {
+	if _, err := NewDocker("golang:1.24?gpu=1", "/src", "/src"); err == nil {
+		t.Errorf("expected error for unknown option")
+	}
+	if _, err := NewDocker("golang:1.24?ttl=forever", "/src", "/src"); err == nil {
+		t.Errorf("expected error for invalid ttl")
+	}
+	if _, err := NewDocker("?network=none", "/src", "/src"); err == nil {
+		t.Errorf("expected error for missing image")
+	}
+	if !IsLocal(&Docker{}) {
+		t.Errorf("expected docker workspace files to be local")
+	}
+}